@@ -0,0 +1,108 @@
+// Package audit is dedicated to reading and writing the audit log in Auth-Db.
+// Entries record who did what to which user; they are kept after the user is deleted.
+package audit
+
+import (
+	"errors"
+	"fmt"
+	sq "github.com/Masterminds/squirrel"
+	"log"
+	"time"
+)
+
+var (
+	// ListLimit is the maximum number of entries returned by List.
+	ListLimit = 100
+
+	ErrorEntryInvalid = errors.New("Entry.actor, Entry.subject and Entry.action must not be empty.")
+)
+
+// Entry is one row of the auth.AuditLog table.
+type Entry struct {
+	Actor   string
+	Subject string
+	Action  string
+	Detail  string
+	Created time.Time
+}
+
+// NewEntry returns an Entry of actor doing action to subject now.
+func NewEntry(actor, subject, action, detail string) *Entry {
+	return &Entry{Actor: actor, Subject: subject, Action: action, Detail: detail, Created: time.Now().UTC()}
+}
+
+type Client interface {
+	Recorder
+	Lister
+	Err() error
+}
+
+type Recorder interface {
+	Record(*Entry, sq.BaseRunner)
+}
+
+type Lister interface {
+	List(string, sq.BaseRunner) []*Entry
+}
+
+type AuditClient struct {
+	err error
+}
+
+// Record inserts e into the auth.AuditLog table in db.
+func (ac *AuditClient) Record(e *Entry, db sq.BaseRunner) {
+	if ac.err != nil {
+		return
+	}
+	if e.Actor == "" || e.Subject == "" || e.Action == "" {
+		ac.err = ErrorEntryInvalid
+		return
+	}
+
+	insert := sq.Insert("[auth].[AuditLog]").
+		Columns("[Actor]", "[Subject]", "[Action]", "[Detail]", "[Created]").
+		Values(e.Actor, e.Subject, e.Action, e.Detail, e.Created)
+	if _, err := insert.RunWith(db).Exec(); err != nil {
+		log.Print(err)
+		ac.err = err
+	}
+}
+
+// List returns the last ListLimit entries about subject, newest first.
+func (ac *AuditClient) List(subject string, db sq.BaseRunner) (entries []*Entry) {
+	if ac.err != nil {
+		return
+	}
+
+	sel := sq.Select(fmt.Sprintf("TOP %d [Actor]", ListLimit), "[Subject]", "[Action]", "[Detail]", "[Created]").
+		From("[auth].[AuditLog]").
+		Where(sq.Eq{"[Subject]": subject}).
+		OrderBy("[Created] DESC")
+	rows, err := sel.RunWith(db).Query()
+	if err != nil {
+		log.Print(err)
+		ac.err = err
+		return
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		e := new(Entry)
+		if err := rows.Scan(&e.Actor, &e.Subject, &e.Action, &e.Detail, &e.Created); err != nil {
+			log.Print(err)
+			ac.err = err
+			return
+		}
+		entries = append(entries, e)
+	}
+	if err := rows.Err(); err != nil {
+		log.Print(err)
+		ac.err = err
+	}
+	return
+}
+
+// Err returns the error status of an AuditClient.
+func (ac *AuditClient) Err() error {
+	return ac.err
+}
@@ -0,0 +1,67 @@
+package audit
+
+import (
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/DATA-DOG/go-sqlmock.v1"
+	"testing"
+	"time"
+)
+
+var (
+	tAdmin = "adminuser"
+	tUser  = "testuser"
+)
+
+func Test_Record(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("An error occured when opening a stub database connection. ERROR: %v\n", err)
+	}
+	defer db.Close()
+
+	t.Run("1", func(t *testing.T) {
+		e := NewEntry(tAdmin, tUser, "status", "active -> suspended")
+		mock.ExpectExec(`INSERT INTO \[auth]\.\[AuditLog] \(\[Actor],\[Subject],\[Action],\[Detail],\[Created]\) VALUES \(\?,\?,\?,\?,\?\)`).
+			WithArgs(tAdmin, tUser, "status", "active -> suspended", e.Created).
+			WillReturnResult(sqlmock.NewResult(1, 1))
+
+		ac := new(AuditClient)
+		ac.Record(e, db)
+		assert.Nil(t, ac.Err())
+
+		if err = mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("Expectations were not met. ERROR: %v\n", err)
+		}
+	})
+
+	t.Run("2", func(t *testing.T) {
+		ac := new(AuditClient)
+		ac.Record(NewEntry("", tUser, "status", ""), db)
+		assert.EqualError(t, ac.Err(), ErrorEntryInvalid.Error())
+	})
+}
+
+func Test_List(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("An error occured when opening a stub database connection. ERROR: %v\n", err)
+	}
+	defer db.Close()
+
+	now := time.Now().UTC()
+	mock.ExpectQuery(`SELECT TOP 100 \[Actor], \[Subject], \[Action], \[Detail], \[Created] FROM \[auth]\.\[AuditLog] WHERE \[Subject] = \? ORDER BY \[Created] DESC`).
+		WithArgs(tUser).
+		WillReturnRows(sqlmock.NewRows([]string{"Actor", "Subject", "Action", "Detail", "Created"}).
+			AddRow(tAdmin, tUser, "status", "suspended -> active", now).
+			AddRow(tAdmin, tUser, "status", "active -> suspended", now.Add(-time.Hour)))
+
+	ac := new(AuditClient)
+	entries := ac.List(tUser, db)
+	assert.Nil(t, ac.Err())
+	assert.Len(t, entries, 2)
+	assert.Equal(t, "suspended -> active", entries[0].Detail)
+
+	if err = mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Expectations were not met. ERROR: %v\n", err)
+	}
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"github.com/penutty/authservice/exchange"
+	"github.com/penutty/authservice/policy"
+	"github.com/penutty/authservice/user"
+	"github.com/penutty/authservice/verification"
+	"net/http"
+	"time"
+)
+
+var (
+	ErrorActionMissing       = errors.New("Field \"Action\" is required.")
+	ErrorSubjectTokenInvalid = errors.New("Subject token is invalid.")
+)
+
+// decisionLogEntry is the record of a decision written to the log for every request to AuthorizeCheckEndpoint.
+type decisionLogEntry struct {
+	ID       string
+	Time     time.Time
+	Client   string
+	Subject  interface{}
+	Action   string
+	Resource map[string]interface{}
+	Context  map[string]interface{}
+	Allowed  bool
+	Policy   string
+	Rule     string
+}
+
+// authorizeCheckHandler answers whether a subject may perform an action on a resource according to the policies
+// in policy.Dir. Callers authenticate as exchange clients with HTTP Basic authentication. A denial is a decision,
+// not an error, and is returned with status 200 like an approval.
+func (a *app) authorizeCheckHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		logger(Error).Println(ErrorMethodNotImplemented)
+		http.Error(w, http.StatusText(http.StatusNotImplemented), http.StatusNotImplemented)
+		return
+	}
+
+	d, err := a.postAuthorizeCheck(r)
+	switch err {
+	case nil:
+	case ErrorClientUnauthenticated, exchange.ErrorClientSecretInvalid, sql.ErrNoRows:
+		logger(Warn).Println(err)
+		w.Header().Set("WWW-Authenticate", "Basic realm=\"Auth-Service\"")
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return
+	default:
+		genErrorHandler(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	if err := json.NewEncoder(w).Encode(d); err != nil {
+		logger(Error).Println(err)
+	}
+}
+
+func (a *app) postAuthorizeCheck(r *http.Request) (*verification.Decision, error) {
+	client, err := a.authenticateClient(r)
+	if err != nil {
+		return nil, err
+	}
+
+	req := new(verification.CheckRequest)
+	if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+		return nil, err
+	}
+	if req.Action == "" {
+		return nil, ErrorActionMissing
+	}
+	subject := req.Subject
+	if req.SubjectToken != "" {
+		if subject, err = a.tokenAttributes(req.SubjectToken, client.TenantID()); err != nil {
+			return nil, err
+		}
+	}
+
+	id, err := user.NewID()
+	if err != nil {
+		return nil, err
+	}
+	res := a.pol.Decide(&policy.Request{Subject: subject, Action: req.Action, Resource: req.Resource, Context: req.Context})
+	d := &verification.Decision{ID: id, Allowed: res.Allowed, Policy: res.Policy, Rule: res.Rule}
+
+	entry := &decisionLogEntry{
+		ID:       d.ID,
+		Time:     time.Now().UTC(),
+		Client:   client.ClientID(),
+		Subject:  subject["id"],
+		Action:   req.Action,
+		Resource: req.Resource,
+		Context:  req.Context,
+		Allowed:  d.Allowed,
+		Policy:   d.Policy,
+		Rule:     d.Rule,
+	}
+	if b, err := json.Marshal(entry); err != nil {
+		logger(Error).Println(err)
+	} else {
+		logger(Info).Println("decision " + string(b))
+	}
+	return d, nil
+}
+
+// tokenAttributes returns the subject attributes of the user token was issued to: its "id", "username" and
+// "tenant", and the "roles", "permissions" and "groups" in token. Groups that did not fit in token are looked up.
+// token must be an access token of the tenant with ID tenantID; tokens issued for a purpose are refused.
+func (a *app) tokenAttributes(token, tenantID string) (map[string]interface{}, error) {
+	claims, err := parseAccessToken(token)
+	if err != nil {
+		logger(Warn).Println(err)
+		return nil, ErrorSubjectTokenInvalid
+	}
+	if verification.Tenant(claims) != tenantID {
+		logger(Warn).Println(ErrorTenantMismatch)
+		return nil, ErrorSubjectTokenInvalid
+	}
+	u, err := a.subject(claims)
+	if err != nil {
+		logger(Warn).Println(err)
+		return nil, ErrorSubjectTokenInvalid
+	}
+
+	groups := verification.Groups(claims)
+	if groups == nil && verification.GroupsEndpoint(claims) != "" {
+		groups = a.g.Memberships(u.ID(), user.AuthDB())
+		if err := a.g.Err(); err != nil {
+			return nil, err
+		}
+	}
+	return map[string]interface{}{
+		"id":          u.ID(),
+		"username":    u.UserID(),
+		"tenant":      u.TenantID(),
+		"roles":       verification.Roles(claims),
+		"permissions": verification.Permissions(claims),
+		"groups":      groups,
+	}, nil
+}
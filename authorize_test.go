@@ -0,0 +1,130 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/dgrijalva/jwt-go"
+	"github.com/penutty/authservice/policy"
+	"github.com/penutty/authservice/tenant"
+	"github.com/penutty/authservice/verification"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+)
+
+var tPolicy = `{"Name": "moments", "Rules": [
+	{"ID": "owner", "Effect": "allow", "Actions": ["moments:edit"], "Resource": "moment", "When": ["resource.owner == subject.id"]},
+	{"ID": "editor", "Effect": "allow", "Actions": ["moments:*"], "Resource": "moment", "When": ["'editor' in subject.roles"]}
+]}`
+
+func NewAuthorizeCheckRequest(clientID, secret string, req *verification.CheckRequest) *http.Request {
+	b, err := json.Marshal(req)
+	if err != nil {
+		panic(err)
+	}
+	r := httptest.NewRequest(http.MethodPost, AuthorizeCheckEndpoint, bytes.NewReader(b))
+	if clientID != "" {
+		r.SetBasicAuth(clientID, secret)
+	}
+	return r
+}
+
+func Test_authorizeCheckHandler(t *testing.T) {
+	p, err := policy.Parse([]byte(tPolicy))
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := new(app)
+	a.rb = new(MockRBACClient)
+	a.g = new(MockGroupClient)
+	a.ss = NewMockSessionClient()
+	a.c = new(MockUserClient)
+	a.x = new(MockExchangeClient)
+	a.pol = policy.New(p)
+
+	token, err := generateJwt(tID, nil, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	editor, err := generateJwt(tID, []string{tRole}, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	purpose, err := generateJwt(tID, nil, nil, jwt.MapClaims{"typ": "login_link"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	own := map[string]interface{}{"type": "moment", "owner": tID}
+	other := map[string]interface{}{"type": "moment", "owner": "id-" + tOtherUser}
+
+	type test struct {
+		req     *http.Request
+		code    int
+		allowed bool
+		rule    string
+	}
+	cases := []*test{
+		&test{NewAuthorizeCheckRequest(tClientID, tSecret, &verification.CheckRequest{SubjectToken: token, Action: "moments:edit", Resource: own}), http.StatusOK, true, "owner"},
+		&test{NewAuthorizeCheckRequest(tClientID, tSecret, &verification.CheckRequest{SubjectToken: token, Action: "moments:edit", Resource: other}), http.StatusOK, false, ""},
+		&test{NewAuthorizeCheckRequest(tClientID, tSecret, &verification.CheckRequest{SubjectToken: editor, Action: "moments:delete", Resource: other}), http.StatusOK, true, "editor"},
+		&test{NewAuthorizeCheckRequest(tClientID, tSecret, &verification.CheckRequest{Subject: map[string]interface{}{"id": tID}, Action: "moments:edit", Resource: own}), http.StatusOK, true, "owner"},
+		&test{NewAuthorizeCheckRequest(tClientID, tSecret, &verification.CheckRequest{SubjectToken: token, Subject: map[string]interface{}{"roles": []string{tRole}}, Action: "moments:edit", Resource: other}), http.StatusOK, false, ""},
+		&test{NewAuthorizeCheckRequest(tClientID, tSecret, &verification.CheckRequest{SubjectToken: "not.a.token", Action: "moments:edit", Resource: own}), http.StatusBadRequest, false, ""},
+		&test{NewAuthorizeCheckRequest(tClientID, tSecret, &verification.CheckRequest{SubjectToken: purpose, Action: "moments:edit", Resource: own}), http.StatusBadRequest, false, ""},
+		&test{NewAuthorizeCheckRequest(tClientID, tSecret, &verification.CheckRequest{SubjectToken: token, Resource: own}), http.StatusBadRequest, false, ""},
+		&test{NewAuthorizeCheckRequest("", "", &verification.CheckRequest{SubjectToken: token, Action: "moments:edit", Resource: own}), http.StatusUnauthorized, false, ""},
+		&test{NewAuthorizeCheckRequest(tClientID, "wrong", &verification.CheckRequest{SubjectToken: token, Action: "moments:edit", Resource: own}), http.StatusUnauthorized, false, ""},
+		&test{httptest.NewRequest(http.MethodGet, AuthorizeCheckEndpoint, nil), http.StatusNotImplemented, false, ""},
+	}
+	for i, c := range cases {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			a.authorizeCheckHandler(rec, c.req)
+			assert.Equal(t, c.code, rec.Code)
+			if c.code != http.StatusOK {
+				return
+			}
+
+			d := new(verification.Decision)
+			assert.Nil(t, json.NewDecoder(rec.Body).Decode(d))
+			assert.NotEmpty(t, d.ID)
+			assert.Equal(t, c.allowed, d.Allowed)
+			assert.Equal(t, c.rule, d.Rule)
+		})
+	}
+}
+
+func Test_tokenAttributes(t *testing.T) {
+	defer func(limit int) { GroupsClaimLimit = limit }(GroupsClaimLimit)
+
+	a, m, _ := newVerifyApp()
+	a.g = NewMockGroupClient()
+	a.ss = NewMockSessionClient()
+
+	GroupsClaimLimit = 1
+	token, _, err := a.accessToken(m.Fetch(tUser, nil), NewAuthRequest())
+	if err != nil {
+		t.Fatal(err)
+	}
+	attrs, err := a.tokenAttributes(token, tenant.Default)
+	assert.Nil(t, err)
+	assert.Equal(t, tID, attrs["id"])
+	assert.Equal(t, tUser, attrs["username"])
+	assert.Equal(t, []string{tGroup, tParentGroup}, attrs["groups"])
+
+	_, err = a.tokenAttributes("not.a.token", tenant.Default)
+	assert.Equal(t, ErrorSubjectTokenInvalid, err)
+
+	// A token of one tenant cannot be checked by the clients of another.
+	_, err = a.tokenAttributes(token, "acme")
+	assert.Equal(t, ErrorSubjectTokenInvalid, err)
+
+	purpose, err := generateJwt(tID, nil, nil, jwt.MapClaims{"typ": "login_link"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = a.tokenAttributes(purpose, tenant.Default)
+	assert.Equal(t, ErrorSubjectTokenInvalid, err)
+}
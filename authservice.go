@@ -4,18 +4,75 @@ import (
 	"encoding/json"
 	"errors"
 	"github.com/dgrijalva/jwt-go"
+	"github.com/penutty/authservice/audit"
+	"github.com/penutty/authservice/exchange"
+	"github.com/penutty/authservice/group"
+	"github.com/penutty/authservice/history"
+	"github.com/penutty/authservice/lockout"
+	"github.com/penutty/authservice/mailer"
+	"github.com/penutty/authservice/mfa"
+	"github.com/penutty/authservice/passwordless"
+	"github.com/penutty/authservice/pat"
+	"github.com/penutty/authservice/policy"
+	"github.com/penutty/authservice/rbac"
+	"github.com/penutty/authservice/reset"
+	"github.com/penutty/authservice/serviceaccount"
+	"github.com/penutty/authservice/session"
+	"github.com/penutty/authservice/signing"
+	"github.com/penutty/authservice/tenant"
 	"github.com/penutty/authservice/user"
+	"github.com/penutty/authservice/verification"
+	"github.com/penutty/authservice/webauthn"
 	"io/ioutil"
 	"log"
 	"net/http"
 	"os"
+	"path/filepath"
 	"strings"
 	"time"
 )
 
 const (
-	UserEndpoint = "/user"
-	AuthEndpoint = "/auth"
+	UserEndpoint        = "/user"
+	AuthEndpoint        = "/auth"
+	MFAEndpoint         = "/auth/mfa"
+	TokenEndpoint       = "/token"
+	TOTPEndpoint        = "/mfa/totp"
+	TOTPConfirmEndpoint = "/mfa/totp/confirm"
+	RecoveryEndpoint    = "/mfa/recovery"
+
+	EmailLoginEndpoint       = "/auth/email"
+	EmailLoginVerifyEndpoint = "/auth/email/verify"
+	VerifyEndpoint           = "/user/verify"
+	VerifyResendEndpoint     = "/user/verify/resend"
+	PasswordForgotEndpoint   = "/password/forgot"
+	PasswordResetEndpoint    = "/password/reset"
+	PasswordExpiredEndpoint  = "/password/expired"
+	UserPasswordEndpoint     = "/user/password"
+	UnlockEndpoint           = "/user/unlock"
+	StatusEndpoint           = "/user/status"
+	RenameEndpoint           = "/user/rename"
+	UserRolesEndpoint        = "/user/roles"
+	RolesEndpoint            = "/roles"
+	UserGroupsEndpoint       = "/user/groups"
+	GroupsEndpoint           = "/groups"
+	GroupMembersEndpoint     = "/groups/members"
+	GroupRolesEndpoint       = "/groups/roles"
+	AuthorizeCheckEndpoint   = "/authorize/check"
+	TenantsEndpoint          = "/tenants"
+	UserTokensEndpoint       = "/user/tokens"
+	IntrospectionEndpoint    = "/token/introspect"
+	SessionsEndpoint         = "/sessions"
+
+	ServiceAccountsEndpoint           = "/service-accounts"
+	ServiceAccountCredentialsEndpoint = "/service-accounts/credentials"
+	ServiceAccountSigningKeysEndpoint = "/service-accounts/signing-keys"
+	SignatureVerificationEndpoint     = "/signature/verify"
+
+	WebAuthnRegisterEndpoint       = "/webauthn/register"
+	WebAuthnRegisterFinishEndpoint = "/webauthn/register/finish"
+	WebAuthnLoginEndpoint          = "/webauthn/login"
+	WebAuthnLoginFinishEndpoint    = "/webauthn/login/finish"
 )
 
 var (
@@ -40,12 +97,68 @@ func logger(t logType) *log.Logger {
 
 func main() {
 	a := new(app)
-	a.c = new(user.UserClient)
 
-	http.HandleFunc(UserEndpoint, a.userHandler)
-	http.HandleFunc(AuthEndpoint, a.authHandler)
+	driver, err := mailer.NewDriver()
+	if err != nil {
+		logger(Error).Fatal(err)
+	}
+	q := mailer.NewQueue(driver, user.AuthDB())
+	go q.Run(nil)
+	a.mail = q
+	if a.tmpl, err = mailer.LoadTemplates(mailer.TemplateDir); err != nil {
+		logger(Error).Fatal(err)
+	}
+	if a.pol, err = policy.Load(policy.Dir); err != nil {
+		logger(Error).Fatal(err)
+	}
 
-	logger(Error).Fatal(http.ListenAndServe(listenPort, nil))
+	a.handle(UserEndpoint, (*app).userHandler)
+	a.handle(VerifyEndpoint, (*app).verifyHandler)
+	a.handle(VerifyResendEndpoint, (*app).verifyResendHandler)
+	a.handle(UserPasswordEndpoint, (*app).userPasswordHandler)
+	a.handle(UnlockEndpoint, (*app).unlockHandler)
+	a.handle(StatusEndpoint, (*app).statusHandler)
+	a.handle(RenameEndpoint, (*app).renameHandler)
+	a.handle(UserRolesEndpoint, (*app).userRolesHandler)
+	a.handle(RolesEndpoint, (*app).rolesHandler)
+	a.handle(UserGroupsEndpoint, (*app).userGroupsHandler)
+	a.handle(GroupsEndpoint, (*app).groupsHandler)
+	a.handle(GroupMembersEndpoint, (*app).groupMembersHandler)
+	a.handle(GroupRolesEndpoint, (*app).groupRolesHandler)
+	a.handle(AuthorizeCheckEndpoint, (*app).authorizeCheckHandler)
+	a.handle(TenantsEndpoint, (*app).tenantsHandler)
+	a.handle(UserTokensEndpoint, (*app).userTokensHandler)
+	a.handle(IntrospectionEndpoint, (*app).introspectionHandler)
+	a.handle(SessionsEndpoint, (*app).sessionsHandler)
+	a.handle(SessionsEndpoint+"/", (*app).sessionsHandler)
+	a.handle(ServiceAccountsEndpoint, (*app).serviceAccountsHandler)
+	a.handle(ServiceAccountCredentialsEndpoint, (*app).serviceAccountCredentialsHandler)
+	a.handle(ServiceAccountSigningKeysEndpoint, (*app).serviceAccountSigningKeysHandler)
+	a.handle(SignatureVerificationEndpoint, (*app).signatureVerificationHandler)
+	a.handle(PasswordForgotEndpoint, (*app).passwordForgotHandler)
+	a.handle(PasswordResetEndpoint, (*app).passwordResetHandler)
+	a.handle(PasswordExpiredEndpoint, (*app).passwordExpiredHandler)
+	a.handle(AuthEndpoint, (*app).authHandler)
+	a.handle(MFAEndpoint, (*app).mfaHandler)
+	a.handle(TokenEndpoint, (*app).tokenHandler)
+	a.handle(TOTPEndpoint, (*app).totpHandler)
+	a.handle(TOTPConfirmEndpoint, (*app).totpConfirmHandler)
+	a.handle(RecoveryEndpoint, (*app).recoveryCodesHandler)
+	a.handle(EmailLoginEndpoint, (*app).emailLoginHandler)
+	a.handle(EmailLoginVerifyEndpoint, (*app).emailLoginVerifyHandler)
+	a.handle(WebAuthnRegisterEndpoint, (*app).webauthnRegisterHandler)
+	a.handle(WebAuthnRegisterFinishEndpoint, (*app).webauthnRegisterFinishHandler)
+	a.handle(WebAuthnLoginEndpoint, (*app).webauthnLoginHandler)
+	a.handle(WebAuthnLoginFinishEndpoint, (*app).webauthnLoginFinishHandler)
+
+	limiter, err := newLimiter(a)
+	if err != nil {
+		logger(Error).Fatal(err)
+	}
+	h := limiter.Wrap(http.DefaultServeMux)
+	logger(Error).Fatal(http.ListenAndServe(listenPort, a.perRequest(func(a *app, w http.ResponseWriter, r *http.Request) {
+		a.resolveTenant(h).ServeHTTP(w, r)
+	})))
 }
 
 var (
@@ -53,7 +166,60 @@ var (
 )
 
 type app struct {
-	c user.Client
+	c    user.Client
+	x    exchange.Client
+	m    mfa.Client
+	w    webauthn.Client
+	p    passwordless.Client
+	rs   reset.Client
+	h    history.Client
+	au   audit.Client
+	l    lockout.Client
+	rb   rbac.Client
+	g    group.Client
+	t    tenant.Client
+	pt   pat.Client
+	sa   serviceaccount.Client
+	sk   signing.Client
+	ss   session.Client
+	mail mailer.Mailer
+	tmpl *mailer.Templates
+	pol  *policy.Policies
+}
+
+// withClients returns a copy of a with new clients, sharing its mailer, templates and policies. Clients keep the
+// first error they encounter, so every request is served by its own copy; see perRequest.
+func (a *app) withClients() *app {
+	c := &app{mail: a.mail, tmpl: a.tmpl, pol: a.pol}
+	c.c = new(user.UserClient)
+	c.x = new(exchange.PolicyClient)
+	c.m = new(mfa.MFAClient)
+	c.w = new(webauthn.CredentialClient)
+	c.p = new(passwordless.CodeClient)
+	c.rs = new(reset.ResetClient)
+	c.h = new(history.HistoryClient)
+	c.au = new(audit.AuditClient)
+	c.l = new(lockout.AttemptsClient)
+	c.rb = new(rbac.RoleClient)
+	c.g = new(group.GroupClient)
+	c.t = new(tenant.TenantClient)
+	c.pt = new(pat.TokenClient)
+	c.sa = new(serviceaccount.AccountClient)
+	c.sk = new(signing.KeyClient)
+	c.ss = new(session.SessionClient)
+	return c
+}
+
+// perRequest returns a handler calling h with a copy of a with new clients for each request.
+func (a *app) perRequest(h func(*app, http.ResponseWriter, *http.Request)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		h(a.withClients(), w, r)
+	}
+}
+
+// handle registers h for pattern in http.DefaultServeMux; see perRequest.
+func (a *app) handle(pattern string, h func(*app, http.ResponseWriter, *http.Request)) {
+	http.HandleFunc(pattern, a.perRequest(h))
 }
 
 func (a *app) userHandler(w http.ResponseWriter, r *http.Request) {
@@ -64,6 +230,28 @@ func (a *app) userHandler(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 		w.WriteHeader(http.StatusCreated)
+	case http.MethodGet, http.MethodPatch:
+		var (
+			res *userResource
+			err error
+		)
+		if r.Method == http.MethodGet {
+			res, err = a.getUser(r)
+		} else {
+			res, err = a.patchUser(r)
+		}
+		if err != nil {
+			genErrorHandler(w, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(res)
+	case http.MethodDelete:
+		if err := a.deleteUser(r); err != nil {
+			genErrorHandler(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
 	default:
 		logger(Error).Println(ErrorMethodNotImplemented)
 		http.Error(w, http.StatusText(http.StatusNotImplemented), http.StatusNotImplemented)
@@ -73,20 +261,74 @@ func (a *app) userHandler(w http.ResponseWriter, r *http.Request) {
 func (a *app) authHandler(w http.ResponseWriter, r *http.Request) {
 	switch r.Method {
 	case http.MethodPost:
-		token, err := a.postAuth(r)
-		if err != nil {
-			genErrorHandler(w, err)
-			return
-		}
-		w.Header().Set("jwt", token)
+		token, refresh, err := a.postAuth(r)
+		loginResponse(w, token, refresh, err)
 	default:
 		logger(Error).Println(ErrorMethodNotImplemented)
 		http.Error(w, http.StatusText(http.StatusNotImplemented), http.StatusNotImplemented)
 	}
 }
 
+// loginResponse writes the result of a login attempt. token is an access token, returned with the refresh token
+// of its session, or a challenge token when err is ErrorMFARequired.
+func loginResponse(w http.ResponseWriter, token, refresh string, err error) {
+	if t, ok := err.(*throttledError); ok {
+		setRetryAfter(w, t.until)
+		err = t.err
+	}
+	switch err {
+	case nil:
+		w.Header().Set("jwt", token)
+		w.Header().Set("refresh_token", refresh)
+	case ErrorMFARequired:
+		w.Header().Set("mfa", token)
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+	case ErrorPasswordExpired:
+		w.Header().Set("password_expired", token)
+		http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+	default:
+		genErrorHandler(w, err)
+	}
+}
+
+// conflictCodes are the error codes in the body of 409 Conflict responses to duplicate users.
+var conflictCodes = map[error]string{
+	user.ErrorUserExists:       "user_exists",
+	user.ErrorUserIDConfusable: "user_id_confusable",
+	user.ErrorEmailInUse:       "email_in_use",
+}
+
 func genErrorHandler(w http.ResponseWriter, err error) {
 	switch err {
+	case ErrorBearerTokenMissing, ErrorBearerTokenInvalid, ErrorMFAChallengeInvalid, mfa.ErrorCodeInvalid, mfa.ErrorCodeReused, mfa.ErrorRecoveryCodeInvalid,
+		ErrorCredentialUserMismatch, webauthn.ErrorChallengeInvalid, webauthn.ErrorChallengeExpired, webauthn.ErrorSignatureInvalid, webauthn.ErrorSignCountInvalid,
+		ErrorLoginLinkInvalid, passwordless.ErrorCodeInvalid, passwordless.ErrorCodeExpired, passwordless.ErrorAttemptsExceeded,
+		reset.ErrorTokenInvalid, reset.ErrorTokenExpired, ErrorPasswordChangeInvalid, ErrorTenantMismatch:
+		logger(Warn).Println(err)
+		w.Header().Set("WWW-Authenticate", "Bearer realm=\"Auth-Service\"")
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+	case mfa.ErrorTOTPEnrolled, group.ErrorGroupCycle, tenant.ErrorHostInUse, pat.ErrorNameInUse, serviceaccount.ErrorNameInUse:
+		logger(Warn).Println(err)
+		http.Error(w, http.StatusText(http.StatusConflict), http.StatusConflict)
+	case user.ErrorUserExists, user.ErrorUserIDConfusable, user.ErrorEmailInUse:
+		logger(Warn).Println(err)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusConflict)
+		json.NewEncoder(w).Encode(map[string]string{"error": conflictCodes[err]})
+	case ErrorEmailUnverified, ErrorUserForbidden, ErrorTenantForbidden, ErrorPATForbidden, ErrorPATScope, ErrorScopeForbidden, ErrorStatusSelf, ErrorAccountSuspended, ErrorAccountDisabled, ErrorAccountPendingDeletion:
+		logger(Warn).Println(err)
+		http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+	case ErrorLoginDelayed:
+		logger(Warn).Println(err)
+		http.Error(w, http.StatusText(http.StatusTooManyRequests), http.StatusTooManyRequests)
+	case ErrorAccountLocked:
+		logger(Warn).Println(err)
+		http.Error(w, http.StatusText(http.StatusLocked), http.StatusLocked)
+	case ErrorUserNotFound, rbac.ErrorRoleNotFound, group.ErrorGroupNotFound, group.ErrorMemberNotFound, tenant.ErrorTenantNotFound, pat.ErrorTokenNotFound,
+		serviceaccount.ErrorAccountNotFound, serviceaccount.ErrorCredentialNotFound, serviceaccount.ErrorTeamNotFound, signing.ErrorKeyNotFound,
+		session.ErrorSessionNotFound:
+		logger(Warn).Println(err)
+		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
 	default:
 		logger(Error).Println(err)
 		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
@@ -104,67 +346,326 @@ func (a *app) postUser(r *http.Request) error {
 		return err
 	}
 
-	u := a.c.NewUser(b.UserID, b.Email, b.Password)
+	u := a.c.NewUser(qualify(r, b.UserID), b.Email, b.Password)
 
 	if err := a.c.Err(); err != nil {
 		return err
 	}
+	if err := checkTenantPassword(tenantOf(r), b.Password); err != nil {
+		return err
+	}
 
 	a.c.Create(u, user.AuthDB())
-	return a.c.Err()
+	if err := a.c.Err(); err != nil {
+		return err
+	}
+	a.h.Record(u.UserID(), b.Password, user.AuthDB())
+	if err := a.h.Err(); err != nil {
+		logger(Error).Println(err)
+	}
+
+	if err := a.sendVerification(u, mailer.Locale(r.Header.Get("Accept-Language"))); err != nil {
+		logger(Error).Println(err)
+	}
+	return nil
 }
 
 var ErrorInvalidPass = errors.New("Form value \"Password\" is invalid.")
 
-func (a *app) postAuth(r *http.Request) (string, error) {
+// postAuth logs a user in with its UserID or, if UserID is empty, its Email and its Password. Only users of
+// the tenant of r are found.
+func (a *app) postAuth(r *http.Request) (string, string, error) {
 	type body struct {
 		UserID   string
+		Email    string
 		Password string
 	}
 	b := new(body)
 	if err := json.NewDecoder(r.Body).Decode(b); err != nil {
-		return "", err
+		return "", "", err
 	}
 
-	u := a.c.Fetch(b.UserID, user.AuthDB())
-	if err := a.c.Err(); err != nil {
-		return "", err
+	t := tenantOf(r)
+	var u *user.User
+	if b.UserID == "" && b.Email != "" {
+		u = a.c.FetchByEmail(t.ID, b.Email, user.AuthDB())
+		b.UserID = user.Qualify(t.ID, user.NormalizeEmail(b.Email))
+	} else {
+		b.UserID = qualify(r, b.UserID)
+		u = a.c.Fetch(b.UserID, user.AuthDB())
+	}
+	fetchErr := a.c.Err()
+	if fetchErr == nil {
+		b.UserID = u.UserID()
+	}
+	// Failures are counted per user regardless of the case of its UserID and of how it logs in.
+	key := user.NormalizeUserID(b.UserID)
+
+	if err := a.checkAttempts(key, r); err != nil {
+		return "", "", err
+	}
+	if fetchErr != nil {
+		a.failAttempt(key, nil, r)
+		return "", "", fetchErr
 	}
 
 	if u.Password() != b.Password {
-		return "", ErrorInvalidPass
+		a.failAttempt(key, u, r)
+		return "", "", ErrorInvalidPass
+	}
+	a.l.Reset(lockout.UserKey(key), user.AuthDB())
+	if err := a.l.Err(); err != nil {
+		return "", "", err
+	}
+
+	if err := checkStatus(u); err != nil {
+		return "", "", err
+	}
+
+	if EmailVerificationPolicy == VerificationPolicyBlock && !u.Verified() {
+		return "", "", ErrorEmailUnverified
+	}
+	if passwordExpired(t, u) {
+		token, err := generatePasswordChange(u.ID())
+		if err != nil {
+			return "", "", err
+		}
+		return token, "", ErrorPasswordExpired
+	}
+
+	return a.login(u, r)
+}
+
+// login completes the first authentication step of u in request r. It returns an access token and a refresh
+// token, or a challenge token and ErrorMFARequired if the user must also present a second factor at MFAEndpoint.
+func (a *app) login(u *user.User, r *http.Request) (string, string, error) {
+	t := a.m.Fetch(u, user.AuthDB())
+	if err := a.m.Err(); err != nil {
+		return "", "", err
+	}
+	if t.Enabled() {
+		challenge, err := generateChallenge(u.ID())
+		if err != nil {
+			return "", "", err
+		}
+		return challenge, "", ErrorMFARequired
 	}
 
-	token, err := generateJwt(b.UserID)
-	return token, err
+	return a.accessToken(u, r)
+}
+
+// accessToken returns an access token for u once all authentication steps of request r are complete
+// unless the status of the user no longer allows it to log in. Its subject is the ID of u;
+// the current UserID is only informational. Each access token starts a session, and is returned with the first
+// refresh token of the session; see startSession.
+func (a *app) accessToken(u *user.User, r *http.Request) (string, string, error) {
+	claims, roles, permissions, err := a.accessClaims(u)
+	if err != nil {
+		return "", "", err
+	}
+	sid, refresh, err := a.startSession(u, r)
+	if err != nil {
+		return "", "", err
+	}
+	claims["sid"] = sid
+	token, err := generateJwt(u.ID(), roles, permissions, claims)
+	if err != nil {
+		return "", "", err
+	}
+	return token, refresh, nil
 }
 
-// generateJwt uses a requests UserID and a []byte secret to generate a JSON web token.
-func generateJwt(UserID string) (string, error) {
+// accessClaims returns the claims, roles and permissions of an access token for u unless the status of the user
+// no longer allows it to log in.
+func (a *app) accessClaims(u *user.User) (jwt.MapClaims, []string, []string, error) {
+	if err := checkStatus(u); err != nil {
+		return nil, nil, nil, err
+	}
 
-	p, err := ioutil.ReadFile(GOPATH + "/src/github.com/penutty/authservice/.ssh/jwt_private.pem")
+	claims := jwt.MapClaims{"preferred_username": u.UserID(), "tid": u.TenantID()}
+	switch {
+	case u.Verified():
+		claims["email_verified"] = true
+	case EmailVerificationPolicy == VerificationPolicyBlock:
+		return nil, nil, nil, ErrorEmailUnverified
+	}
+	groups, roles, permissions, err := a.grants(u)
 	if err != nil {
-		return "", err
+		return nil, nil, nil, err
 	}
+	groupsClaims(groups, claims)
+	return claims, roles, permissions, nil
+}
 
-	t := jwt.New(jwt.SigningMethodRS256)
-	claims, ok := t.Claims.(jwt.MapClaims)
-	if !ok {
-		return "", err
+var (
+	ErrorBearerTokenMissing = errors.New("Request does not contain a bearer token.")
+	ErrorBearerTokenInvalid = errors.New("Bearer token is invalid.")
+	ErrorTokenRevoked       = errors.New("Token was issued before the tokens of its subject were revoked.")
+	ErrorTokenPurpose       = errors.New("Token is a purpose token, not an access token.")
+)
+
+// parseAccessToken returns the claims of token if it is an access token for Moment-Service. Purpose tokens, such
+// as MFA challenges and login links, carry a "typ" claim and are refused with ErrorTokenPurpose.
+func parseAccessToken(token string) (jwt.MapClaims, error) {
+	claims, err := verification.ParseAudience(token, "Moment-Service")
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := claims["typ"]; ok {
+		return nil, ErrorTokenPurpose
+	}
+	return claims, nil
+}
+
+// authenticate returns the user the access token or personal access token in the Authorization header of r
+// was issued to. The token must have been issued for the tenant of r, and a personal access token must have scope.
+func (a *app) authenticate(r *http.Request, scope string) (*user.User, error) {
+	u, t, err := a.bearer(r)
+	if err != nil {
+		return nil, err
+	}
+	if t != nil && !t.HasScope(scope) {
+		return nil, ErrorPATScope
+	}
+	return u, nil
+}
+
+// authenticateLogin is authenticate, refusing personal access tokens with ErrorPATForbidden. Personal access tokens
+// grant API access within their scopes; credentials, tokens and administration require the access token of a login.
+func (a *app) authenticateLogin(r *http.Request) (*user.User, error) {
+	u, t, err := a.bearer(r)
+	if err != nil {
+		return nil, err
+	}
+	if t != nil {
+		return nil, ErrorPATForbidden
+	}
+	return u, nil
+}
+
+// bearer is authenticate, also returning the metadata of the bearer token if it is a personal access token.
+func (a *app) bearer(r *http.Request) (*user.User, *pat.Token, error) {
+	h := r.Header.Get("Authorization")
+	if !strings.HasPrefix(h, "Bearer ") {
+		return nil, nil, ErrorBearerTokenMissing
+	}
+	token := strings.TrimPrefix(h, "Bearer ")
+
+	if strings.HasPrefix(token, pat.Prefix) {
+		u, t, err := a.personalAccessToken(token)
+		if err != nil {
+			logger(Warn).Println(err)
+			return nil, nil, ErrorBearerTokenInvalid
+		}
+		if err := checkTenant(r, u); err != nil {
+			logger(Warn).Println(err)
+			return nil, nil, ErrorBearerTokenInvalid
+		}
+		return u, t, nil
+	}
+
+	claims, err := parseAccessToken(token)
+	if err != nil {
+		logger(Warn).Println(err)
+		return nil, nil, ErrorBearerTokenInvalid
+	}
+	if verification.Tenant(claims) != tenantOf(r).ID {
+		logger(Warn).Println(ErrorTenantMismatch)
+		return nil, nil, ErrorBearerTokenInvalid
+	}
+	u, err := a.subject(claims)
+	if err != nil {
+		logger(Warn).Println(err)
+		return nil, nil, ErrorBearerTokenInvalid
 	}
+	return u, nil, nil
+}
 
-	claims["iss"] = "Auth-Service"
-	claims["sub"] = UserID
-	claims["aud"] = "Moment-Service"
-	claims["exp"] = time.Now().UTC().AddDate(0, 0, 7).Unix()
-	claims["iat"] = time.Now().UTC().Unix()
+// subject returns the user whose ID is the subject of claims. It returns ErrorTokenRevoked if claims were
+// issued before the tokens of the user were revoked, e.g. by a password reset, ErrorSessionRevoked if their
+// session was revoked, and ErrorTenantMismatch if they were issued for a tenant other than that of the user.
+// Tokens of service accounts have no user subject and are refused with ErrorServiceAccount. Services verifying
+// tokens offline cannot see revocations.
+func (a *app) subject(claims jwt.MapClaims) (*user.User, error) {
+	if verification.ServiceAccount(claims) {
+		return nil, ErrorServiceAccount
+	}
+	sub, _ := claims["sub"].(string)
+	u := a.c.FetchByID(sub, user.AuthDB())
+	if err := a.c.Err(); err != nil {
+		return nil, err
+	}
+	if verification.Tenant(claims) != u.TenantID() {
+		return nil, ErrorTenantMismatch
+	}
+
+	iat, _ := claims["iat"].(float64)
+	revoked := a.c.Revoked(u.UserID(), user.AuthDB())
+	if err := a.c.Err(); err != nil {
+		return nil, err
+	}
+	if iat < issuedAt(revoked) {
+		return nil, ErrorTokenRevoked
+	}
+	if err := a.checkSession(u, claims); err != nil {
+		return nil, err
+	}
+	return u, nil
+}
+
+// issuedAt returns t as the "iat" claim of an access token. The claim keeps fractions of a second so that a token
+// issued in the same second as a revocation is ordered correctly against it.
+func issuedAt(t time.Time) float64 {
+	return float64(t.UnixNano()) / float64(time.Second)
+}
+
+// generateJwt generates a JSON web token for the user with ID id, which is the subject of the token,
+// granting roles and permissions; see verification.Check. Additional claims are added to the registered ones;
+// a "tid" claim among them makes the token one of that tenant, issued and signed by it.
+func generateJwt(id string, roles, permissions []string, additional jwt.MapClaims) (string, error) {
+	tid, _ := additional["tid"].(string)
+	claims := jwt.MapClaims{
+		"iss": verification.IssuerFor(tid),
+		"sub": id,
+		"aud": "Moment-Service",
+		"exp": time.Now().UTC().Add(AccessTokenLifetime).Unix(),
+		"iat": issuedAt(time.Now().UTC()),
+	}
+	if len(roles) > 0 {
+		claims["roles"] = roles
+	}
+	if len(permissions) > 0 {
+		claims["permissions"] = permissions
+	}
+	for k, v := range additional {
+		if _, ok := claims[k]; !ok {
+			claims[k] = v
+		}
+	}
+	return signJwt(claims)
+}
+
+// signJwt signs claims with the Auth-Service private key, or with the private key in TenantKeyDir of the
+// tenant in the "tid" claim of claims.
+func signJwt(claims jwt.MapClaims) (string, error) {
+	path := GOPATH + "/src/github.com/penutty/authservice/.ssh/jwt_private.pem"
+	if tid, _ := claims["tid"].(string); tid != "" && tid != tenant.Default {
+		if err := tenant.CheckID(tid); err != nil {
+			return "", err
+		}
+		path = filepath.Join(TenantKeyDir, tid, "jwt_private.pem")
+	}
+	p, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
 
 	key, err := jwt.ParseRSAPrivateKeyFromPEM(p)
 	if err != nil {
 		return "", err
 	}
 
-	token, err := t.SignedString(key)
+	token, err := jwt.NewWithClaims(jwt.SigningMethodRS256, claims).SignedString(key)
 	if err != nil {
 		return "", err
 	}
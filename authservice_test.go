@@ -1,11 +1,14 @@
 package main
 
 import (
+	"database/sql"
 	"errors"
 	"fmt"
 	sq "github.com/Masterminds/squirrel"
 	"github.com/dgrijalva/jwt-go"
+	"github.com/penutty/authservice/mailer"
 	"github.com/penutty/authservice/user"
+	"github.com/penutty/authservice/verification"
 	"github.com/stretchr/testify/assert"
 	"io/ioutil"
 	"net/http"
@@ -14,12 +17,18 @@ import (
 	"strconv"
 	"strings"
 	"testing"
+	"time"
 )
 
 var (
 	tUser     = "testuser"
 	tEmail    = "<testemail@email.com>"
 	tPassword = "TestPassword123!"
+
+	tUserMissing = "missinguser"
+
+	// tID is the ID MockUserClient gives tUser; every user's ID is "id-" followed by its UserID.
+	tID = "id-" + tUser
 )
 
 func NewAuthBody(u, p string) *strings.Reader {
@@ -31,7 +40,20 @@ func NewUserBody(u, e, p string) *strings.Reader {
 }
 
 type MockUserClient struct {
-	err error
+	err        error
+	verified   bool
+	throttled  bool
+	password   string
+	revoked    time.Time
+	changed    time.Time
+	status     user.Status
+	until      time.Time
+	updated    *user.User
+	deleted    string
+	conflict   error
+	renamed    string
+	renamedID  string
+	revokedIDs []string
 }
 
 func (m *MockUserClient) NewUser(UserID, Email, Password string) *user.User {
@@ -43,12 +65,134 @@ func (m *MockUserClient) NewUser(UserID, Email, Password string) *user.User {
 }
 
 func (m *MockUserClient) Fetch(u string, db sq.BaseRunner) *user.User {
+	if u == tUserMissing || user.Tenant(u) == tEmptyTenant {
+		m.err = sql.ErrNoRows
+		return nil
+	}
+	password, changed := tPassword, time.Now().UTC()
+	if m.password != "" {
+		password = m.password
+	}
+	if !m.changed.IsZero() {
+		changed = m.changed
+	}
+	if user.NormalizeUserID(u) == tUser {
+		u = tUser
+	}
 	uc := new(user.UserClient)
-	return uc.NewUser(u, tEmail, tPassword)
+	usr := uc.NewUser(u, tEmail, password)
+	usr.SetID("id-" + u)
+	usr.SetVerified(m.verified)
+	usr.SetPasswordChanged(changed)
+	if m.status != "" {
+		usr.SetStatus(m.status, "", m.until)
+	}
+	return usr
+}
+
+func (m *MockUserClient) FetchByEmail(tenantID, email string, db sq.BaseRunner) *user.User {
+	if user.NormalizeEmail(email) != user.NormalizeEmail(tEmail) {
+		m.err = sql.ErrNoRows
+		return nil
+	}
+	return m.Fetch(user.Qualify(tenantID, tUser), db)
+}
+
+func (m *MockUserClient) FetchByID(id string, db sq.BaseRunner) *user.User {
+	if !strings.HasPrefix(id, "id-") {
+		m.err = sql.ErrNoRows
+		return nil
+	}
+	if id == m.renamedID {
+		u := m.Fetch(m.renamed, db)
+		u.SetID(id)
+		return u
+	}
+	return m.Fetch(strings.TrimPrefix(id, "id-"), db)
+}
+
+func (m *MockUserClient) Rename(u *user.User, userID string, db sq.BaseRunner) {
+	if _, err := user.EnforceUserID(userID); err != nil {
+		m.err = err
+		return
+	}
+	if user.Tenant(userID) != u.TenantID() {
+		m.err = user.ErrorTenantChange
+		return
+	}
+	if user.NormalizeUserID(userID) == tOtherUser {
+		m.err = user.ErrorUserExists
+		return
+	}
+	id := u.ID()
+	*u = *m.Fetch(userID, db)
+	u.SetID(id)
+	m.renamed, m.renamedID = userID, id
 }
 
 func (m *MockUserClient) Create(u *user.User, db sq.BaseRunner) {
+	m.err = m.conflict
+}
+
+func (m *MockUserClient) Verify(u, email string, db sq.BaseRunner) {
+	if email != tEmail {
+		m.err = user.ErrorUserNotVerified
+		return
+	}
+	m.verified = true
+}
 
+func (m *MockUserClient) ThrottleVerification(u string, db sq.BaseRunner) error {
+	if m.verified || m.throttled {
+		return user.ErrorVerificationThrottled
+	}
+	m.throttled = true
+	return nil
+}
+
+func (m *MockUserClient) Update(u *user.User, db sq.BaseRunner) {
+	if err := u.Err(); err != nil {
+		m.err = err
+		return
+	}
+	m.updated = u
+	m.verified = u.Verified()
+}
+
+func (m *MockUserClient) Delete(u string, db sq.BaseRunner) {
+	m.deleted = u
+}
+
+func (m *MockUserClient) SetPassword(u, password string, db sq.BaseRunner) {
+	if err := user.CheckPassword(password); err != nil {
+		m.err = err
+		return
+	}
+	m.password = password
+	m.changed = time.Now().UTC()
+}
+
+func (m *MockUserClient) RevokeTokens(u string, db sq.BaseRunner) {
+	m.revoked = time.Now().UTC()
+}
+
+func (m *MockUserClient) RevokeTokensByID(ids []string, db sq.BaseRunner) {
+	if len(ids) > 0 {
+		m.revoked = time.Now().UTC()
+	}
+	m.revokedIDs = append(m.revokedIDs, ids...)
+}
+
+func (m *MockUserClient) Revoked(u string, db sq.BaseRunner) time.Time {
+	return m.revoked
+}
+
+func (m *MockUserClient) ChangeStatus(u string, status user.Status, reason string, until time.Time, db sq.BaseRunner) {
+	if err := user.CheckStatus(status); err != nil {
+		m.err = err
+		return
+	}
+	m.status, m.until = status, until
 }
 
 func (m *MockUserClient) Err() error {
@@ -60,9 +204,35 @@ type RequestCodePair struct {
 	code int
 }
 
+func Test_perRequest(t *testing.T) {
+	a := &app{mail: new(mailer.Memory)}
+	var served []*app
+	h := a.perRequest(func(a *app, w http.ResponseWriter, r *http.Request) {
+		served = append(served, a)
+	})
+	for i := 0; i < 2; i++ {
+		h(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, UserEndpoint, nil))
+	}
+
+	assert.Len(t, served, 2)
+	assert.Equal(t, a.mail, served[0].mail)
+	assert.Equal(t, a.mail, served[1].mail)
+	assert.True(t, served[0].c != served[1].c)
+	assert.True(t, served[0].x != served[1].x)
+	assert.True(t, served[0].ss != served[1].ss)
+	assert.Nil(t, a.c)
+}
+
 func Test_userHandler(t *testing.T) {
+	mail := new(mailer.Memory)
 	a := new(app)
+	a.rb = new(MockRBACClient)
+	a.g = new(MockGroupClient)
+	a.ss = NewMockSessionClient()
 	a.c = new(MockUserClient)
+	a.h = new(MockHistoryClient)
+	a.mail = mail
+	a.tmpl, _ = mailer.LoadTemplates("mailer/templates")
 
 	testVars := []*RequestCodePair{
 		&RequestCodePair{httptest.NewRequest(http.MethodPost, UserEndpoint, NewUserBody(tUser, tEmail, tPassword)), http.StatusCreated},
@@ -78,11 +248,18 @@ func Test_userHandler(t *testing.T) {
 			assert.Equal(t, v.code, rec.Code)
 		})
 	}
+	assert.Len(t, mail.Messages, 1)
+	assert.Equal(t, tEmail, mail.Last().To)
 }
 
 func Test_authHandler(t *testing.T) {
 	a := new(app)
+	a.rb = new(MockRBACClient)
+	a.g = new(MockGroupClient)
+	a.ss = NewMockSessionClient()
 	a.c = new(MockUserClient)
+	a.m = new(MockMFAClient)
+	a.l = NewMockLockoutClient()
 
 	testVars := []*RequestCodePair{
 		&RequestCodePair{httptest.NewRequest(http.MethodPost, AuthEndpoint, NewAuthBody(tUser, tPassword)), http.StatusOK},
@@ -101,6 +278,70 @@ func Test_authHandler(t *testing.T) {
 	}
 }
 
+func Test_userHandler_conflict(t *testing.T) {
+	type test struct {
+		conflict error
+		code     string
+	}
+	cases := []*test{
+		&test{user.ErrorUserExists, "user_exists"},
+		&test{user.ErrorUserIDConfusable, "user_id_confusable"},
+		&test{user.ErrorEmailInUse, "email_in_use"},
+	}
+	for i, c := range cases {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			a := new(app)
+			a.rb = new(MockRBACClient)
+			a.g = new(MockGroupClient)
+			a.ss = NewMockSessionClient()
+			a.c = &MockUserClient{conflict: c.conflict}
+			a.h = new(MockHistoryClient)
+
+			rec := httptest.NewRecorder()
+			a.userHandler(rec, httptest.NewRequest(http.MethodPost, UserEndpoint, NewUserBody(tUser, tEmail, tPassword)))
+			assert.Equal(t, http.StatusConflict, rec.Code)
+			assert.JSONEq(t, fmt.Sprintf("{\"error\": \"%s\"}", c.code), rec.Body.String())
+		})
+	}
+}
+
+func Test_authHandler_login(t *testing.T) {
+	type test struct {
+		body string
+		code int
+	}
+	cases := []*test{
+		&test{fmt.Sprintf("{\"UserID\": \"TestUser\", \"Password\": \"%s\"}", tPassword), http.StatusOK},
+		&test{fmt.Sprintf("{\"Email\": \"<TestEmail@Email.com>\", \"Password\": \"%s\"}", tPassword), http.StatusOK},
+		&test{fmt.Sprintf("{\"Email\": \"testemail@email.com\", \"Password\": \"%s\"}", tPassword), http.StatusOK},
+		&test{fmt.Sprintf("{\"Email\": \"<otheremail@email.com>\", \"Password\": \"%s\"}", tPassword), http.StatusBadRequest},
+		&test{"{\"Email\": \"<TestEmail@Email.com>\", \"Password\": \"fail\"}", http.StatusBadRequest},
+	}
+	for i, c := range cases {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			a := new(app)
+			a.rb = new(MockRBACClient)
+			a.g = new(MockGroupClient)
+			a.ss = NewMockSessionClient()
+			a.c = new(MockUserClient)
+			a.m = new(MockMFAClient)
+			a.l = NewMockLockoutClient()
+
+			rec := httptest.NewRecorder()
+			a.authHandler(rec, httptest.NewRequest(http.MethodPost, AuthEndpoint, strings.NewReader(c.body)))
+			assert.Equal(t, c.code, rec.Code)
+			if c.code != http.StatusOK {
+				return
+			}
+			claims, err := verification.ParseAudience(rec.Header().Get("jwt"), "Moment-Service")
+			assert.Nil(t, err)
+			assert.Equal(t, tID, claims["sub"])
+			assert.Equal(t, tUser, claims["preferred_username"])
+			assert.Equal(t, "refresh-0", rec.Header().Get("refresh_token"))
+		})
+	}
+}
+
 type RequestErrPair struct {
 	req *http.Request
 	err error
@@ -108,7 +349,13 @@ type RequestErrPair struct {
 
 func Test_postUser(t *testing.T) {
 	a := new(app)
+	a.rb = new(MockRBACClient)
+	a.g = new(MockGroupClient)
+	a.ss = NewMockSessionClient()
 	a.c = new(MockUserClient)
+	a.h = new(MockHistoryClient)
+	a.mail = new(mailer.Memory)
+	a.tmpl, _ = mailer.LoadTemplates("mailer/templates")
 
 	testVars := []*RequestErrPair{
 		&RequestErrPair{httptest.NewRequest(http.MethodPost, UserEndpoint, NewUserBody(tUser, tEmail, tPassword)), nil},
@@ -130,7 +377,12 @@ func Test_postUser(t *testing.T) {
 
 func Test_postAuth(t *testing.T) {
 	a := new(app)
+	a.rb = new(MockRBACClient)
+	a.g = new(MockGroupClient)
+	a.ss = NewMockSessionClient()
 	a.c = new(MockUserClient)
+	a.m = new(MockMFAClient)
+	a.l = NewMockLockoutClient()
 
 	testVars := []*RequestErrPair{
 		&RequestErrPair{httptest.NewRequest(http.MethodPost, AuthEndpoint, NewAuthBody(tUser, tPassword)), nil},
@@ -140,7 +392,7 @@ func Test_postAuth(t *testing.T) {
 		v.req.Header.Add("Content-Type", "application/json")
 
 		t.Run(strconv.Itoa(i), func(t *testing.T) {
-			_, err := a.postAuth(v.req)
+			_, _, err := a.postAuth(v.req)
 			if v.err != nil {
 				assert.EqualError(t, err, v.err.Error())
 			} else {
@@ -150,8 +402,91 @@ func Test_postAuth(t *testing.T) {
 	}
 }
 
+func Test_authenticate(t *testing.T) {
+	token, err := generateJwt(tID, nil, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	challenge, err := generateChallenge(tID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Tokens issued before users had IDs have the UserID as their subject.
+	tUserToken, err := generateJwt(tUser, nil, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	type headerErrPair struct {
+		header  string
+		revoked time.Time
+		err     error
+	}
+	testVars := []*headerErrPair{
+		&headerErrPair{"Bearer " + token, time.Time{}, nil},
+		&headerErrPair{"Bearer " + token, time.Now().Add(-time.Minute), nil},
+		&headerErrPair{"Bearer " + token, time.Now().Add(time.Minute), ErrorBearerTokenInvalid},
+		&headerErrPair{"", time.Time{}, ErrorBearerTokenMissing},
+		&headerErrPair{"Basic " + token, time.Time{}, ErrorBearerTokenMissing},
+		&headerErrPair{"Bearer not.a.token", time.Time{}, ErrorBearerTokenInvalid},
+		&headerErrPair{"Bearer " + challenge, time.Time{}, ErrorBearerTokenInvalid},
+		&headerErrPair{"Bearer " + tUserToken, time.Time{}, ErrorBearerTokenInvalid},
+	}
+
+	for i, v := range testVars {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			a := new(app)
+			a.rb = new(MockRBACClient)
+			a.g = new(MockGroupClient)
+			a.ss = NewMockSessionClient()
+			a.c = &MockUserClient{revoked: v.revoked}
+			r := httptest.NewRequest(http.MethodGet, UserEndpoint, nil)
+			r.Header.Set("Authorization", v.header)
+			u, err := a.authenticate(r, ScopeUserRead)
+			if v.err != nil {
+				assert.EqualError(t, err, v.err.Error())
+			} else {
+				assert.Nil(t, err)
+				assert.Equal(t, tID, u.ID())
+				assert.Equal(t, tUser, u.UserID())
+			}
+		})
+	}
+}
+
+func Test_subject_revokedWithinSecond(t *testing.T) {
+	a := new(app)
+	a.ss = NewMockSessionClient()
+	m := new(MockUserClient)
+	a.c = m
+
+	before, err := generateJwt(tID, nil, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.revoked = time.Now().UTC()
+	after, err := generateJwt(tID, nil, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	claims, err := parseAccessToken(before)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = a.subject(claims)
+	assert.Equal(t, ErrorTokenRevoked, err)
+
+	claims, err = parseAccessToken(after)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = a.subject(claims)
+	assert.Nil(t, err)
+}
+
 func Test_generateJwt_pass(t *testing.T) {
-	tokenString, err := generateJwt(tUser)
+	tokenString, err := generateJwt(tID, nil, nil, nil)
 	if err != nil {
 		t.Error(err)
 	}
@@ -180,7 +515,7 @@ func Test_generateJwt_pass(t *testing.T) {
 	assert.True(t, token.Valid)
 
 	if claims, ok := token.Claims.(jwt.MapClaims); ok && token.Valid {
-		assert.Equal(t, tUser, claims["sub"])
+		assert.Equal(t, tID, claims["sub"])
 	} else {
 		t.Error("token.Claims.(jwt.MapClaims) assertion failed.")
 	}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/dgrijalva/jwt-go"
+	"github.com/penutty/authservice/mailer"
+	"github.com/penutty/authservice/passwordless"
+	"github.com/penutty/authservice/user"
+	"github.com/penutty/authservice/verification"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+)
+
+const (
+	LoginMethodCode = "code"
+	LoginMethodLink = "link"
+)
+
+var (
+	// LoginLinkURL is the page login links point to; the link token is appended as the "token" query parameter.
+	LoginLinkURL = os.Getenv("LoginLinkURL")
+
+	ErrorLoginMethodInvalid = errors.New("Form value \"Method\" must be \"code\" or \"link\".")
+	ErrorLoginLinkInvalid   = errors.New("Form value \"Token\" is invalid.")
+)
+
+// generateLoginLink returns a signed login link token carrying the login secret of the user with ID id.
+func generateLoginLink(id, secret string) (string, error) {
+	claims := jwt.MapClaims{
+		"iss": "Auth-Service",
+		"sub": id,
+		"aud": "Auth-Service",
+		"typ": "login_link",
+		"jti": secret,
+		"exp": time.Now().UTC().Add(passwordless.LinkLifetime).Unix(),
+		"iat": time.Now().UTC().Unix(),
+	}
+	return signJwt(claims)
+}
+
+// parseLoginLink returns the user ID and login secret of a token generated by generateLoginLink.
+func parseLoginLink(token string) (string, string, error) {
+	claims, err := verification.ParseAudience(token, "Auth-Service")
+	if err != nil {
+		logger(Warn).Println(err)
+		return "", "", ErrorLoginLinkInvalid
+	}
+	sub, ok := claims["sub"].(string)
+	jti, _ := claims["jti"].(string)
+	if claims["typ"] != "login_link" || !ok || jti == "" {
+		return "", "", ErrorLoginLinkInvalid
+	}
+	return sub, jti, nil
+}
+
+func (a *app) emailLoginHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodPost:
+		if err := a.postEmailLogin(r); err != nil {
+			genErrorHandler(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusAccepted)
+	default:
+		logger(Error).Println(ErrorMethodNotImplemented)
+		http.Error(w, http.StatusText(http.StatusNotImplemented), http.StatusNotImplemented)
+	}
+}
+
+// postEmailLogin emails a login code or login link to the address of a user.
+// The response does not reveal whether the user exists.
+func (a *app) postEmailLogin(r *http.Request) error {
+	type body struct {
+		UserID string
+		Method string
+	}
+	b := new(body)
+	if err := json.NewDecoder(r.Body).Decode(b); err != nil {
+		return err
+	}
+	if b.Method == "" {
+		b.Method = LoginMethodCode
+	}
+	if b.Method != LoginMethodCode && b.Method != LoginMethodLink {
+		return ErrorLoginMethodInvalid
+	}
+	if err := user.CheckUserID(b.UserID); err != nil {
+		return err
+	}
+
+	u := a.c.Fetch(qualify(r, b.UserID), user.AuthDB())
+	if err := a.c.Err(); err != nil {
+		logger(Warn).Println(err)
+		return nil
+	}
+	b.UserID = u.UserID()
+
+	var (
+		name string
+		data map[string]interface{}
+	)
+	switch b.Method {
+	case LoginMethodCode:
+		code := a.p.IssueCode(b.UserID, user.AuthDB())
+		if err := a.p.Err(); err != nil {
+			return err
+		}
+		name = "login_code"
+		data = map[string]interface{}{"Code": code, "Minutes": int(passwordless.CodeLifetime.Minutes())}
+	case LoginMethodLink:
+		secret := a.p.IssueLink(b.UserID, user.AuthDB())
+		if err := a.p.Err(); err != nil {
+			return err
+		}
+		token, err := generateLoginLink(u.ID(), secret)
+		if err != nil {
+			return err
+		}
+		name = "login_link"
+		data = map[string]interface{}{"URL": LoginLinkURL + "?token=" + url.QueryEscape(token), "Minutes": int(passwordless.LinkLifetime.Minutes())}
+	}
+
+	m, err := a.tmpl.Render(name, mailer.Locale(r.Header.Get("Accept-Language")), data)
+	if err != nil {
+		return err
+	}
+	m.To = u.Email()
+	return a.mail.Send(m)
+}
+
+func (a *app) emailLoginVerifyHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodPost:
+		token, refresh, err := a.postEmailLoginVerify(r)
+		loginResponse(w, token, refresh, err)
+	default:
+		logger(Error).Println(ErrorMethodNotImplemented)
+		http.Error(w, http.StatusText(http.StatusNotImplemented), http.StatusNotImplemented)
+	}
+}
+
+// postEmailLoginVerify exchanges a login code, given with its UserID, or a login link Token for a login.
+func (a *app) postEmailLoginVerify(r *http.Request) (string, string, error) {
+	type body struct {
+		UserID string
+		Code   string
+		Token  string
+	}
+	b := new(body)
+	if err := json.NewDecoder(r.Body).Decode(b); err != nil {
+		return "", "", err
+	}
+
+	var (
+		u   *user.User
+		err error
+	)
+	secret := b.Code
+	if b.Token != "" {
+		var id string
+		if id, secret, err = parseLoginLink(b.Token); err != nil {
+			return "", "", err
+		}
+		if u, err = a.fetchUserByID(id); err != nil {
+			logger(Warn).Println(err)
+			return "", "", ErrorLoginLinkInvalid
+		}
+		if err := checkTenant(r, u); err != nil {
+			logger(Warn).Println(err)
+			return "", "", ErrorLoginLinkInvalid
+		}
+	} else if u, err = a.fetchUser(qualify(r, b.UserID)); err != nil {
+		logger(Warn).Println(err)
+		return "", "", passwordless.ErrorCodeInvalid
+	}
+	if secret == "" {
+		return "", "", passwordless.ErrorCodeInvalid
+	}
+
+	a.p.Redeem(u.UserID(), secret, user.AuthDB())
+	if err := a.p.Err(); err != nil {
+		return "", "", err
+	}
+	return a.login(u, r)
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"fmt"
+	sq "github.com/Masterminds/squirrel"
+	"github.com/penutty/authservice/mailer"
+	"github.com/penutty/authservice/passwordless"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+type MockPasswordlessClient struct {
+	err     error
+	secrets map[string]string
+}
+
+func (m *MockPasswordlessClient) IssueCode(u string, db sq.BaseRunner) string {
+	code, _ := passwordless.GenerateCode()
+	m.secrets[u] = code
+	return code
+}
+
+func (m *MockPasswordlessClient) IssueLink(u string, db sq.BaseRunner) string {
+	secret, _ := passwordless.GenerateLinkSecret()
+	m.secrets[u] = secret
+	return secret
+}
+
+func (m *MockPasswordlessClient) Redeem(u, secret string, db sq.BaseRunner) {
+	if s, ok := m.secrets[u]; !ok || s != secret {
+		m.err = passwordless.ErrorCodeInvalid
+		return
+	}
+	delete(m.secrets, u)
+}
+
+func (m *MockPasswordlessClient) Err() error {
+	return m.err
+}
+
+func newEmailLoginApp() (*app, *mailer.Memory) {
+	mail := new(mailer.Memory)
+	a := new(app)
+	a.rb = new(MockRBACClient)
+	a.g = new(MockGroupClient)
+	a.ss = NewMockSessionClient()
+	a.c = new(MockUserClient)
+	a.m = new(MockMFAClient)
+	a.p = &MockPasswordlessClient{secrets: make(map[string]string)}
+	a.mail = mail
+	a.tmpl, _ = mailer.LoadTemplates("mailer/templates")
+	return a, mail
+}
+
+func NewEmailLoginBody(u, method string) *strings.Reader {
+	return strings.NewReader(fmt.Sprintf("{\"UserID\": \"%s\", \"Method\": \"%s\"}", u, method))
+}
+
+func Test_emailLoginHandler(t *testing.T) {
+	a, mail := newEmailLoginApp()
+
+	testVars := []*RequestCodePair{
+		&RequestCodePair{httptest.NewRequest(http.MethodPost, EmailLoginEndpoint, NewEmailLoginBody(tUser, "")), http.StatusAccepted},
+		&RequestCodePair{httptest.NewRequest(http.MethodPost, EmailLoginEndpoint, NewEmailLoginBody(tUser, LoginMethodLink)), http.StatusAccepted},
+		&RequestCodePair{httptest.NewRequest(http.MethodPost, EmailLoginEndpoint, NewEmailLoginBody(tUser, "sms")), http.StatusBadRequest},
+		&RequestCodePair{httptest.NewRequest(http.MethodPost, EmailLoginEndpoint, NewEmailLoginBody("fail", LoginMethodCode)), http.StatusBadRequest},
+		&RequestCodePair{httptest.NewRequest(http.MethodGet, EmailLoginEndpoint, nil), http.StatusNotImplemented},
+	}
+
+	for i, v := range testVars {
+		rec := httptest.NewRecorder()
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			a.emailLoginHandler(rec, v.req)
+			assert.Equal(t, v.code, rec.Code)
+		})
+	}
+	assert.Len(t, mail.Messages, 2)
+	assert.Equal(t, tEmail, mail.Messages[0].To)
+}
+
+func Test_emailLogin_code(t *testing.T) {
+	a, mail := newEmailLoginApp()
+
+	rec := httptest.NewRecorder()
+	a.emailLoginHandler(rec, httptest.NewRequest(http.MethodPost, EmailLoginEndpoint, NewEmailLoginBody(tUser, LoginMethodCode)))
+	assert.Equal(t, http.StatusAccepted, rec.Code)
+
+	code := regexp.MustCompile(`[0-9]{6}`).FindString(mail.Last().Text)
+	assert.NotEmpty(t, code)
+
+	body := func(code string) *strings.Reader {
+		return strings.NewReader(fmt.Sprintf("{\"UserID\": \"%s\", \"Code\": \"%s\"}", tUser, code))
+	}
+
+	rec = httptest.NewRecorder()
+	a.emailLoginVerifyHandler(rec, httptest.NewRequest(http.MethodPost, EmailLoginVerifyEndpoint, body(code)))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.NotEmpty(t, rec.Header().Get("jwt"))
+
+	rec = httptest.NewRecorder()
+	a.emailLoginVerifyHandler(rec, httptest.NewRequest(http.MethodPost, EmailLoginVerifyEndpoint, body(code)))
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+}
+
+func Test_emailLogin_link(t *testing.T) {
+	a, mail := newEmailLoginApp()
+	a.m = &MockMFAClient{enabled: true}
+
+	rec := httptest.NewRecorder()
+	a.emailLoginHandler(rec, httptest.NewRequest(http.MethodPost, EmailLoginEndpoint, NewEmailLoginBody(tUser, LoginMethodLink)))
+	assert.Equal(t, http.StatusAccepted, rec.Code)
+
+	escaped := regexp.MustCompile(`token=(\S+)`).FindStringSubmatch(mail.Last().Text)
+	if len(escaped) != 2 {
+		t.Fatal("Login link not found in message.")
+	}
+	token, err := url.QueryUnescape(escaped[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rec = httptest.NewRecorder()
+	a.emailLoginVerifyHandler(rec, httptest.NewRequest(http.MethodPost, EmailLoginVerifyEndpoint, strings.NewReader(`{"Token": "`+token+`"}`)))
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+	assert.NotEmpty(t, rec.Header().Get("mfa"))
+
+	challenge, err := generateChallenge(tID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec = httptest.NewRecorder()
+	a.emailLoginVerifyHandler(rec, httptest.NewRequest(http.MethodPost, EmailLoginVerifyEndpoint, strings.NewReader(`{"Token": "`+challenge+`"}`)))
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+	assert.Empty(t, rec.Header().Get("mfa"))
+}
+
+func Test_emailLogin_locale(t *testing.T) {
+	a, mail := newEmailLoginApp()
+
+	req := httptest.NewRequest(http.MethodPost, EmailLoginEndpoint, NewEmailLoginBody(tUser, LoginMethodCode))
+	req.Header.Set("Accept-Language", "de-DE,de;q=0.9")
+	rec := httptest.NewRecorder()
+	a.emailLoginHandler(rec, req)
+	assert.Equal(t, http.StatusAccepted, rec.Code)
+	assert.Equal(t, "Ihr Auth-Service Anmeldecode", mail.Last().Subject)
+	assert.NotEmpty(t, mail.Last().HTML)
+}
@@ -0,0 +1,43 @@
+// Package env reads the configuration of Auth-Service from environment variables. Every package reads
+// numbers and durations the same way: unset or malformed values fall back to the default. Int and Duration
+// accept zero, for settings where zero means "off"; PositiveInt and PositiveDuration do not, for thresholds
+// and lifetimes that zero would break.
+package env
+
+import (
+	"os"
+	"strconv"
+	"time"
+)
+
+// Int returns the integer in the environment variable name if it is not negative, or def.
+func Int(name string, def int) int {
+	if v, err := strconv.Atoi(os.Getenv(name)); err == nil && v >= 0 {
+		return v
+	}
+	return def
+}
+
+// PositiveInt returns the integer in the environment variable name if it is positive, or def.
+func PositiveInt(name string, def int) int {
+	if v := Int(name, def); v > 0 {
+		return v
+	}
+	return def
+}
+
+// Duration returns the duration in the environment variable name, e.g. "15m", if it is not negative, or def.
+func Duration(name string, def time.Duration) time.Duration {
+	if v, err := time.ParseDuration(os.Getenv(name)); err == nil && v >= 0 {
+		return v
+	}
+	return def
+}
+
+// PositiveDuration returns the duration in the environment variable name if it is positive, or def.
+func PositiveDuration(name string, def time.Duration) time.Duration {
+	if v := Duration(name, def); v > 0 {
+		return v
+	}
+	return def
+}
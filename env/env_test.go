@@ -0,0 +1,92 @@
+package env
+
+import (
+	"github.com/stretchr/testify/assert"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func Test_Int(t *testing.T) {
+	defer os.Unsetenv("EnvTest")
+
+	cases := []struct {
+		value    string
+		expected int
+	}{
+		{"", 5},
+		{"12", 12},
+		{"0", 0},
+		{"-1", 5},
+		{"ten", 5},
+	}
+	for i, c := range cases {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			os.Setenv("EnvTest", c.value)
+			assert.Equal(t, c.expected, Int("EnvTest", 5))
+		})
+	}
+}
+
+func Test_Duration(t *testing.T) {
+	defer os.Unsetenv("EnvTest")
+
+	cases := []struct {
+		value    string
+		expected time.Duration
+	}{
+		{"", time.Hour},
+		{"15m", 15 * time.Minute},
+		{"0", 0},
+		{"-1s", time.Hour},
+		{"15", time.Hour},
+	}
+	for i, c := range cases {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			os.Setenv("EnvTest", c.value)
+			assert.Equal(t, c.expected, Duration("EnvTest", time.Hour))
+		})
+	}
+}
+
+func Test_PositiveInt(t *testing.T) {
+	defer os.Unsetenv("EnvTest")
+
+	cases := []struct {
+		value    string
+		expected int
+	}{
+		{"", 5},
+		{"12", 12},
+		{"0", 5},
+		{"-1", 5},
+	}
+	for i, c := range cases {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			os.Setenv("EnvTest", c.value)
+			assert.Equal(t, c.expected, PositiveInt("EnvTest", 5))
+		})
+	}
+}
+
+func Test_PositiveDuration(t *testing.T) {
+	defer os.Unsetenv("EnvTest")
+
+	cases := []struct {
+		value    string
+		expected time.Duration
+	}{
+		{"", time.Hour},
+		{"15m", 15 * time.Minute},
+		{"0", time.Hour},
+		{"0s", time.Hour},
+		{"-1s", time.Hour},
+	}
+	for i, c := range cases {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			os.Setenv("EnvTest", c.value)
+			assert.Equal(t, c.expected, PositiveDuration("EnvTest", time.Hour))
+		})
+	}
+}
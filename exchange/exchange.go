@@ -0,0 +1,192 @@
+// Package exchange is dedicated to reading client token exchange policies from Auth-Db.
+// A Policy describes which audiences a client may exchange a subject token for,
+// which scopes it may request for each audience and how long issued tokens live.
+package exchange
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	sq "github.com/Masterminds/squirrel"
+	"github.com/penutty/authservice/tenant"
+	"log"
+	"strings"
+	"time"
+)
+
+var (
+	DefaultLifetime = time.Hour
+
+	ErrorClientIDParameterInvalid = errors.New("Policy.clientID must be a valid clientID.")
+	ErrorClientSecretInvalid      = errors.New("Client secret is invalid.")
+	ErrorAudienceNotPermitted     = errors.New("Client may not exchange tokens for the requested audience.")
+	ErrorScopeNotPermitted        = errors.New("Client may not request the requested scope.")
+)
+
+type Client interface {
+	Fetcher
+	Err() error
+}
+
+type Fetcher interface {
+	Fetch(string, sq.BaseRunner) *Policy
+}
+
+type PolicyClient struct {
+	err error
+}
+
+// Fetch selects a client from the auth.Clients table and its rules from the auth.ExchangePolicies table in db.
+func (pc *PolicyClient) Fetch(clientID string, db sq.BaseRunner) (p *Policy) {
+	if pc.err != nil {
+		return
+	}
+	if clientID == "" {
+		pc.err = ErrorClientIDParameterInvalid
+		return
+	}
+
+	client := sq.Select("[ClientID], [Secret], [TenantID]").From("[auth].[Clients]").Where(sq.Eq{"[ClientID]": clientID})
+
+	p = &Policy{rules: make(map[string]*Rule)}
+	if err := client.RunWith(db).QueryRow().Scan(&p.clientID, &p.secret, &p.tenantID); err != nil {
+		log.Print(err)
+		pc.err = err
+		return
+	}
+
+	rules := sq.Select("[Audience], [Scopes], [Lifetime]").From("[auth].[ExchangePolicies]").Where(sq.Eq{"[ClientID]": clientID})
+	rows, err := rules.RunWith(db).Query()
+	if err != nil {
+		log.Print(err)
+		pc.err = err
+		return
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			scopes   string
+			lifetime int64
+		)
+		r := new(Rule)
+		if err := rows.Scan(&r.Audience, &scopes, &lifetime); err != nil {
+			log.Print(err)
+			pc.err = err
+			return
+		}
+		r.Scopes = strings.Fields(scopes)
+		r.Lifetime = time.Duration(lifetime) * time.Second
+		if r.Lifetime <= 0 {
+			r.Lifetime = DefaultLifetime
+		}
+		p.rules[r.Audience] = r
+	}
+	if err := rows.Err(); err != nil {
+		log.Print(err)
+		pc.err = err
+	}
+	return
+}
+
+// Err returns the error status of a PolicyClient instance.
+func (pc *PolicyClient) Err() error {
+	return pc.err
+}
+
+// Rule permits a client to exchange tokens for one audience.
+type Rule struct {
+	Audience string
+	Scopes   []string
+	Lifetime time.Duration
+}
+
+// Policy references a unique auth.Clients row and its auth.ExchangePolicies rows.
+type Policy struct {
+	clientID string
+	secret   string
+	tenantID string
+	rules    map[string]*Rule
+}
+
+// NewPolicy is a constructor of the Policy struct for a client of the default tenant. secret is hashed before it is stored.
+func NewPolicy(clientID, secret string, rules ...*Rule) *Policy {
+	p := &Policy{clientID: clientID, secret: HashSecret(secret), tenantID: tenant.Default, rules: make(map[string]*Rule)}
+	for _, r := range rules {
+		p.rules[r.Audience] = r
+	}
+	return p
+}
+
+// HashSecret returns the hex encoded SHA-256 digest of a client secret as stored in auth.Clients.
+func HashSecret(secret string) string {
+	h := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(h[:])
+}
+
+// ClientID returns the ID of the client the Policy belongs to.
+func (p *Policy) ClientID() string {
+	return p.clientID
+}
+
+// TenantID returns the ID of the tenant the client belongs to. A client only exchanges tokens of its tenant.
+func (p *Policy) TenantID() string {
+	return p.tenantID
+}
+
+// SetTenantID sets Policy.tenantID.
+func (p *Policy) SetTenantID(tenantID string) {
+	p.tenantID = tenantID
+}
+
+// Authenticate returns an error if secret does not match the client's stored secret.
+func (p *Policy) Authenticate(secret string) error {
+	if subtle.ConstantTimeCompare([]byte(HashSecret(secret)), []byte(p.secret)) != 1 {
+		return ErrorClientSecretInvalid
+	}
+	return nil
+}
+
+// Narrow returns the scopes and lifetime of a token exchanged for audience.
+// requested are the scopes asked for by the client; when empty every permitted scope is granted.
+// held are the scopes of the subject token; a nil held places no restriction on the result.
+func (p *Policy) Narrow(audience string, requested, held []string) ([]string, time.Duration, error) {
+	r, ok := p.rules[audience]
+	if !ok {
+		return nil, 0, ErrorAudienceNotPermitted
+	}
+
+	permitted := make(map[string]bool)
+	for _, s := range r.Scopes {
+		permitted[s] = true
+	}
+	if held != nil {
+		h := make(map[string]bool)
+		for _, s := range held {
+			h[s] = true
+		}
+		for s := range permitted {
+			if !h[s] {
+				delete(permitted, s)
+			}
+		}
+	}
+
+	if len(requested) == 0 {
+		scopes := make([]string, 0, len(r.Scopes))
+		for _, s := range r.Scopes {
+			if permitted[s] {
+				scopes = append(scopes, s)
+			}
+		}
+		return scopes, r.Lifetime, nil
+	}
+
+	for _, s := range requested {
+		if !permitted[s] {
+			return nil, 0, ErrorScopeNotPermitted
+		}
+	}
+	return requested, r.Lifetime, nil
+}
@@ -0,0 +1,96 @@
+package exchange
+
+import (
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/DATA-DOG/go-sqlmock.v1"
+	"strconv"
+	"testing"
+	"time"
+)
+
+var (
+	tClientID = "moment-service"
+	tSecret   = "TestSecret123!"
+	tAudience = "Media-Service"
+)
+
+func newTestPolicy() *Policy {
+	return NewPolicy(tClientID, tSecret, &Rule{tAudience, []string{"media.read", "media.write"}, time.Minute})
+}
+
+func Test_Authenticate(t *testing.T) {
+	p := newTestPolicy()
+	assert.Nil(t, p.Authenticate(tSecret))
+	assert.EqualError(t, p.Authenticate("wrong"), ErrorClientSecretInvalid.Error())
+}
+
+func Test_Narrow(t *testing.T) {
+	type narrowCase struct {
+		audience  string
+		requested []string
+		held      []string
+		scopes    []string
+		err       error
+	}
+	testVars := []*narrowCase{
+		&narrowCase{tAudience, nil, nil, []string{"media.read", "media.write"}, nil},
+		&narrowCase{tAudience, []string{"media.read"}, nil, []string{"media.read"}, nil},
+		&narrowCase{tAudience, nil, []string{"media.read"}, []string{"media.read"}, nil},
+		&narrowCase{tAudience, []string{"media.write"}, []string{"media.read"}, nil, ErrorScopeNotPermitted},
+		&narrowCase{tAudience, []string{"media.delete"}, nil, nil, ErrorScopeNotPermitted},
+		&narrowCase{"Other-Service", nil, nil, nil, ErrorAudienceNotPermitted},
+	}
+
+	p := newTestPolicy()
+	for i, v := range testVars {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			scopes, lifetime, err := p.Narrow(v.audience, v.requested, v.held)
+			if v.err != nil {
+				assert.EqualError(t, err, v.err.Error())
+				return
+			}
+			assert.Nil(t, err)
+			assert.Equal(t, v.scopes, scopes)
+			assert.Equal(t, time.Minute, lifetime)
+		})
+	}
+}
+
+func Test_Fetch(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("An error occured when opening a stub database connection. ERROR: %v\n", err)
+	}
+	defer db.Close()
+
+	t.Run("1", func(t *testing.T) {
+		mock.ExpectQuery(`SELECT \[ClientID], \[Secret], \[TenantID] FROM \[auth]\.\[Clients] WHERE \[ClientID] = \?`).
+			WithArgs(tClientID).
+			WillReturnRows(sqlmock.NewRows([]string{"ClientID", "Secret", "TenantID"}).AddRow(tClientID, HashSecret(tSecret), "acme"))
+		mock.ExpectQuery(`SELECT \[Audience], \[Scopes], \[Lifetime] FROM \[auth]\.\[ExchangePolicies] WHERE \[ClientID] = \?`).
+			WithArgs(tClientID).
+			WillReturnRows(sqlmock.NewRows([]string{"Audience", "Scopes", "Lifetime"}).AddRow(tAudience, "media.read media.write", 0))
+
+		pc := new(PolicyClient)
+		p := pc.Fetch(tClientID, db)
+		assert.Nil(t, pc.Err())
+		assert.Equal(t, tClientID, p.ClientID())
+		assert.Equal(t, "acme", p.TenantID())
+		assert.Nil(t, p.Authenticate(tSecret))
+
+		scopes, lifetime, err := p.Narrow(tAudience, nil, nil)
+		assert.Nil(t, err)
+		assert.Equal(t, []string{"media.read", "media.write"}, scopes)
+		assert.Equal(t, DefaultLifetime, lifetime)
+
+		if err = mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("Expectations were not met. ERROR: %v\n", err)
+		}
+	})
+
+	t.Run("2", func(t *testing.T) {
+		pc := new(PolicyClient)
+		_ = pc.Fetch("", db)
+		assert.EqualError(t, pc.Err(), ErrorClientIDParameterInvalid.Error())
+	})
+}
@@ -0,0 +1,407 @@
+// Package group is dedicated to reading and writing groups of users and their nesting in Auth-Db.
+// Users are referenced by their immutable ID. A group may be a subgroup of other groups; the members
+// of a subgroup are members of every group it is nested in.
+package group
+
+import (
+	"errors"
+	sq "github.com/Masterminds/squirrel"
+	"log"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+var (
+	// MaxDepth is the maximum number of nesting levels followed when resolving memberships.
+	MaxDepth = 32
+
+	DescriptionMaxLength = 256
+
+	ErrorGroupInvalid    = errors.New("Group must be 1 to 64 lowercase letters, digits, '_', '-', '.' or ':'.")
+	ErrorDescriptionLong = errors.New("Description too long.")
+	ErrorGroupNotFound   = errors.New("Group does not exist.")
+	ErrorMemberNotFound  = errors.New("User or subgroup is not a member of the group.")
+	ErrorGroupCycle      = errors.New("Group cannot be nested in itself.")
+
+	groupRegexp = regexp.MustCompile(`^[a-z0-9_.:-]{1,64}$`)
+)
+
+// Group is a named set of users and subgroups.
+type Group struct {
+	Name        string
+	Description string
+}
+
+// CheckGroup returns an error if group is not a valid group name.
+func CheckGroup(group string) error {
+	if !groupRegexp.MatchString(group) {
+		return ErrorGroupInvalid
+	}
+	return nil
+}
+
+// Check returns an error if g is invalid.
+func (g *Group) Check() error {
+	if err := CheckGroup(g.Name); err != nil {
+		return err
+	}
+	if utf8.RuneCountInString(g.Description) > DescriptionMaxLength {
+		return ErrorDescriptionLong
+	}
+	return nil
+}
+
+type Client interface {
+	Saver
+	Deleter
+	Lister
+	MemberLister
+	MemberAdder
+	MemberRemover
+	SubgroupAdder
+	SubgroupRemover
+	Resolver
+	MemberResolver
+	Err() error
+}
+
+type Saver interface {
+	Save(*Group, sq.BaseRunner)
+}
+
+type Deleter interface {
+	Delete(string, sq.BaseRunner)
+}
+
+type Lister interface {
+	List(sq.BaseRunner) []*Group
+}
+
+type MemberLister interface {
+	Members(string, sq.BaseRunner) ([]string, []string)
+}
+
+type MemberAdder interface {
+	AddMember(string, string, sq.BaseRunner)
+}
+
+type MemberRemover interface {
+	RemoveMember(string, string, sq.BaseRunner)
+}
+
+type SubgroupAdder interface {
+	AddSubgroup(string, string, sq.BaseRunner)
+}
+
+type SubgroupRemover interface {
+	RemoveSubgroup(string, string, sq.BaseRunner)
+}
+
+type Resolver interface {
+	Memberships(string, sq.BaseRunner) []string
+}
+
+type MemberResolver interface {
+	MemberIDs([]string, sq.BaseRunner) []string
+}
+
+type GroupClient struct {
+	err error
+}
+
+// Save creates g or replaces its description in the auth.Groups table in db.
+func (gc *GroupClient) Save(g *Group, db sq.BaseRunner) {
+	if gc.err != nil {
+		return
+	}
+	if err := g.Check(); err != nil {
+		gc.err = err
+		return
+	}
+
+	update := sq.Update("[auth].[Groups]").Set("[Description]", g.Description).Where(sq.Eq{"[Group]": g.Name})
+	res, err := update.RunWith(db).Exec()
+	if err != nil {
+		log.Print(err)
+		gc.err = err
+		return
+	}
+	if cnt, err := res.RowsAffected(); err == nil && cnt > 0 {
+		return
+	}
+	insert := sq.Insert("[auth].[Groups]").Columns("[Group]", "[Description]").Values(g.Name, g.Description)
+	if _, err := insert.RunWith(db).Exec(); err != nil {
+		log.Print(err)
+		gc.err = err
+	}
+}
+
+// Delete removes group, its memberships and its nesting in other groups from db.
+func (gc *GroupClient) Delete(group string, db sq.BaseRunner) {
+	if gc.err != nil {
+		return
+	}
+
+	nesting := sq.Delete("[auth].[GroupSubgroups]").Where(sq.Or{sq.Eq{"[Group]": group}, sq.Eq{"[Subgroup]": group}})
+	if _, err := nesting.RunWith(db).Exec(); err != nil {
+		log.Print(err)
+		gc.err = err
+		return
+	}
+	del := sq.Delete("[auth].[Groups]").Where(sq.Eq{"[Group]": group})
+	res, err := del.RunWith(db).Exec()
+	if err != nil {
+		log.Print(err)
+		gc.err = err
+		return
+	}
+	if cnt, err := res.RowsAffected(); err != nil || cnt != 1 {
+		gc.err = ErrorGroupNotFound
+	}
+}
+
+// List returns every group, ordered by name.
+func (gc *GroupClient) List(db sq.BaseRunner) (groups []*Group) {
+	if gc.err != nil {
+		return
+	}
+
+	sel := sq.Select("[Group]", "[Description]").From("[auth].[Groups]").OrderBy("[Group]")
+	rows, err := sel.RunWith(db).Query()
+	if err != nil {
+		log.Print(err)
+		gc.err = err
+		return
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		g := new(Group)
+		if err := rows.Scan(&g.Name, &g.Description); err != nil {
+			log.Print(err)
+			gc.err = err
+			return
+		}
+		groups = append(groups, g)
+	}
+	if err := rows.Err(); err != nil {
+		log.Print(err)
+		gc.err = err
+	}
+	return
+}
+
+// Members returns the UserIDs of the direct members of group and its direct subgroups, both sorted.
+func (gc *GroupClient) Members(group string, db sq.BaseRunner) (users []string, subgroups []string) {
+	if gc.err != nil {
+		return
+	}
+
+	sel := sq.Select("u.[UserID]").
+		From("[auth].[GroupMembers] m").
+		Join("[auth].[Users] u ON u.[ID] = m.[ID]").
+		Where(sq.Eq{"m.[Group]": group}).
+		OrderBy("u.[UserID]")
+	if users = gc.strings(sel, db); gc.err != nil {
+		return nil, nil
+	}
+	sel = sq.Select("[Subgroup]").From("[auth].[GroupSubgroups]").Where(sq.Eq{"[Group]": group}).OrderBy("[Subgroup]")
+	if subgroups = gc.strings(sel, db); gc.err != nil {
+		return nil, nil
+	}
+	return
+}
+
+// AddMember makes the user with ID id a member of group. Adding a member twice does nothing.
+func (gc *GroupClient) AddMember(group, id string, db sq.BaseRunner) {
+	if gc.err != nil {
+		return
+	}
+
+	insert := sq.Insert("[auth].[GroupMembers]").Columns("[Group]", "[ID]").Values(group, id)
+	if _, err := insert.RunWith(db).Exec(); err != nil {
+		gc.err = gc.conflict(err, "PK_GroupMembers", "FK_GroupMembers_Groups")
+	}
+}
+
+// RemoveMember removes the user with ID id from group.
+func (gc *GroupClient) RemoveMember(group, id string, db sq.BaseRunner) {
+	if gc.err != nil {
+		return
+	}
+
+	del := sq.Delete("[auth].[GroupMembers]").Where(sq.Eq{"[Group]": group, "[ID]": id})
+	gc.deleteOne(del, db)
+}
+
+// AddSubgroup nests subgroup in group. It fails with ErrorGroupCycle if group is subgroup or already
+// nested in subgroup, directly or through other groups.
+func (gc *GroupClient) AddSubgroup(group, subgroup string, db sq.BaseRunner) {
+	if gc.err != nil {
+		return
+	}
+	if err := CheckGroup(subgroup); err != nil {
+		gc.err = err
+		return
+	}
+
+	ancestors := gc.ancestors([]string{group}, db)
+	if gc.err != nil {
+		return
+	}
+	for _, g := range ancestors {
+		if g == subgroup {
+			gc.err = ErrorGroupCycle
+			return
+		}
+	}
+
+	insert := sq.Insert("[auth].[GroupSubgroups]").Columns("[Group]", "[Subgroup]").Values(group, subgroup)
+	if _, err := insert.RunWith(db).Exec(); err != nil {
+		gc.err = gc.conflict(err, "PK_GroupSubgroups", "FK_GroupSubgroups_")
+	}
+}
+
+// RemoveSubgroup removes subgroup from group.
+func (gc *GroupClient) RemoveSubgroup(group, subgroup string, db sq.BaseRunner) {
+	if gc.err != nil {
+		return
+	}
+
+	del := sq.Delete("[auth].[GroupSubgroups]").Where(sq.Eq{"[Group]": group, "[Subgroup]": subgroup})
+	gc.deleteOne(del, db)
+}
+
+// Memberships returns every group the user with ID id is a member of, directly or through subgroups, sorted.
+func (gc *GroupClient) Memberships(id string, db sq.BaseRunner) []string {
+	if gc.err != nil {
+		return nil
+	}
+
+	sel := sq.Select("[Group]").From("[auth].[GroupMembers]").Where(sq.Eq{"[ID]": id})
+	direct := gc.strings(sel, db)
+	if gc.err != nil || len(direct) == 0 {
+		return nil
+	}
+	return gc.ancestors(direct, db)
+}
+
+// MemberIDs returns the IDs of the users who are members of groups, directly or through subgroups, sorted.
+func (gc *GroupClient) MemberIDs(groups []string, db sq.BaseRunner) []string {
+	if gc.err != nil || len(groups) == 0 {
+		return nil
+	}
+
+	all := gc.descendants(groups, db)
+	if gc.err != nil {
+		return nil
+	}
+	sel := sq.Select("DISTINCT [ID]").From("[auth].[GroupMembers]").Where(sq.Eq{"[Group]": all}).OrderBy("[ID]")
+	return gc.strings(sel, db)
+}
+
+// ancestors returns groups and every group they are nested in, sorted.
+func (gc *GroupClient) ancestors(groups []string, db sq.BaseRunner) []string {
+	return gc.walk(groups, "[Subgroup]", "[Group]", db)
+}
+
+// descendants returns groups and every group nested in them, sorted.
+func (gc *GroupClient) descendants(groups []string, db sq.BaseRunner) []string {
+	return gc.walk(groups, "[Group]", "[Subgroup]", db)
+}
+
+// walk returns groups and every group reached from them by following auth.GroupSubgroups rows from column from
+// to column to, sorted. Groups already seen are not followed again, so cycles that exist despite AddSubgroup
+// end the walk instead of looping; so does MaxDepth.
+func (gc *GroupClient) walk(groups []string, from, to string, db sq.BaseRunner) []string {
+	seen := make(map[string]bool)
+	var frontier []string
+	for _, g := range groups {
+		if !seen[g] {
+			seen[g] = true
+			frontier = append(frontier, g)
+		}
+	}
+
+	for depth := 0; len(frontier) > 0 && depth < MaxDepth; depth++ {
+		sel := sq.Select(to).From("[auth].[GroupSubgroups]").Where(sq.Eq{from: frontier})
+		next := gc.strings(sel, db)
+		if gc.err != nil {
+			return nil
+		}
+		frontier = nil
+		for _, g := range next {
+			if !seen[g] {
+				seen[g] = true
+				frontier = append(frontier, g)
+			}
+		}
+	}
+
+	all := make([]string, 0, len(seen))
+	for g := range seen {
+		all = append(all, g)
+	}
+	sort.Strings(all)
+	return all
+}
+
+// strings returns the first column of the rows selected by sel.
+func (gc *GroupClient) strings(sel sq.SelectBuilder, db sq.BaseRunner) (s []string) {
+	rows, err := sel.RunWith(db).Query()
+	if err != nil {
+		log.Print(err)
+		gc.err = err
+		return
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var v string
+		if err := rows.Scan(&v); err != nil {
+			log.Print(err)
+			gc.err = err
+			return nil
+		}
+		s = append(s, v)
+	}
+	if err := rows.Err(); err != nil {
+		log.Print(err)
+		gc.err = err
+		return nil
+	}
+	return
+}
+
+// deleteOne runs del and fails with ErrorMemberNotFound unless it deleted one row.
+func (gc *GroupClient) deleteOne(del sq.DeleteBuilder, db sq.BaseRunner) {
+	res, err := del.RunWith(db).Exec()
+	if err != nil {
+		log.Print(err)
+		gc.err = err
+		return
+	}
+	if cnt, err := res.RowsAffected(); err != nil || cnt != 1 {
+		gc.err = ErrorMemberNotFound
+	}
+}
+
+// conflict returns nil if err is a violation of the primary key pk, since the row exists already,
+// ErrorGroupNotFound if it is a violation of a foreign key starting with fk, and err otherwise.
+func (gc *GroupClient) conflict(err error, pk, fk string) error {
+	switch {
+	case strings.Contains(err.Error(), pk):
+		return nil
+	case strings.Contains(err.Error(), fk):
+		return ErrorGroupNotFound
+	}
+	log.Print(err)
+	return err
+}
+
+// Err returns the error status of a GroupClient.
+func (gc *GroupClient) Err() error {
+	return gc.err
+}
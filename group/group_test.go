@@ -0,0 +1,411 @@
+package group
+
+import (
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/DATA-DOG/go-sqlmock.v1"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+var (
+	tID    = "01890a5d-ac96-774b-bcce-b302099a8057"
+	tGroup = "engineering"
+)
+
+func Test_Check(t *testing.T) {
+	cases := []struct {
+		g   *Group
+		err error
+	}{
+		{&Group{Name: tGroup}, nil},
+		{&Group{Name: "team:backend-eu.1"}, nil},
+		{&Group{Name: ""}, ErrorGroupInvalid},
+		{&Group{Name: "Engineering"}, ErrorGroupInvalid},
+		{&Group{Name: strings.Repeat("a", 65)}, ErrorGroupInvalid},
+		{&Group{Name: tGroup, Description: strings.Repeat("a", 257)}, ErrorDescriptionLong},
+	}
+	for i, c := range cases {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			assert.Equal(t, c.err, c.g.Check())
+		})
+	}
+}
+
+func Test_Save(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("An error occured when opening a stub database connection. ERROR: %v\n", err)
+	}
+	defer db.Close()
+
+	cases := []struct {
+		affected int64
+	}{
+		{1},
+		{0},
+	}
+	for i, c := range cases {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			mock.ExpectExec(`UPDATE \[auth]\.\[Groups] SET \[Description] = \? WHERE \[Group] = \?`).
+				WithArgs("Engineers", tGroup).
+				WillReturnResult(sqlmock.NewResult(0, c.affected))
+			if c.affected == 0 {
+				mock.ExpectExec(`INSERT INTO \[auth]\.\[Groups] \(\[Group],\[Description]\) VALUES \(\?,\?\)`).
+					WithArgs(tGroup, "Engineers").
+					WillReturnResult(sqlmock.NewResult(0, 1))
+			}
+
+			gc := new(GroupClient)
+			gc.Save(&Group{Name: tGroup, Description: "Engineers"}, db)
+			assert.Nil(t, gc.Err())
+
+			if err = mock.ExpectationsWereMet(); err != nil {
+				t.Errorf("Expectations were not met. ERROR: %v\n", err)
+			}
+		})
+	}
+
+	t.Run(strconv.Itoa(len(cases)), func(t *testing.T) {
+		gc := new(GroupClient)
+		gc.Save(&Group{Name: "Engineering"}, db)
+		assert.Equal(t, ErrorGroupInvalid, gc.Err())
+	})
+}
+
+func Test_Delete(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("An error occured when opening a stub database connection. ERROR: %v\n", err)
+	}
+	defer db.Close()
+
+	cases := []struct {
+		affected int64
+		err      error
+	}{
+		{1, nil},
+		{0, ErrorGroupNotFound},
+	}
+	for i, c := range cases {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			mock.ExpectExec(`DELETE FROM \[auth]\.\[GroupSubgroups] WHERE \(\[Group] = \? OR \[Subgroup] = \?\)`).
+				WithArgs(tGroup, tGroup).
+				WillReturnResult(sqlmock.NewResult(0, 2))
+			mock.ExpectExec(`DELETE FROM \[auth]\.\[Groups] WHERE \[Group] = \?`).
+				WithArgs(tGroup).
+				WillReturnResult(sqlmock.NewResult(0, c.affected))
+
+			gc := new(GroupClient)
+			gc.Delete(tGroup, db)
+			assert.Equal(t, c.err, gc.Err())
+
+			if err = mock.ExpectationsWereMet(); err != nil {
+				t.Errorf("Expectations were not met. ERROR: %v\n", err)
+			}
+		})
+	}
+}
+
+func Test_List(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("An error occured when opening a stub database connection. ERROR: %v\n", err)
+	}
+	defer db.Close()
+
+	mock.ExpectQuery(`SELECT \[Group], \[Description] FROM \[auth]\.\[Groups] ORDER BY \[Group]`).
+		WillReturnRows(sqlmock.NewRows([]string{"Group", "Description"}).
+			AddRow(tGroup, "Engineers").
+			AddRow("staff", ""))
+
+	gc := new(GroupClient)
+	groups := gc.List(db)
+	assert.Nil(t, gc.Err())
+	assert.Equal(t, []*Group{&Group{Name: tGroup, Description: "Engineers"}, &Group{Name: "staff"}}, groups)
+
+	if err = mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Expectations were not met. ERROR: %v\n", err)
+	}
+}
+
+func Test_Members(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("An error occured when opening a stub database connection. ERROR: %v\n", err)
+	}
+	defer db.Close()
+
+	mock.ExpectQuery(`SELECT u\.\[UserID] FROM \[auth]\.\[GroupMembers] m JOIN \[auth]\.\[Users] u ON u\.\[ID] = m\.\[ID] WHERE m\.\[Group] = \? ORDER BY u\.\[UserID]`).
+		WithArgs(tGroup).
+		WillReturnRows(sqlmock.NewRows([]string{"UserID"}).AddRow("alice").AddRow("bob"))
+	mock.ExpectQuery(`SELECT \[Subgroup] FROM \[auth]\.\[GroupSubgroups] WHERE \[Group] = \? ORDER BY \[Subgroup]`).
+		WithArgs(tGroup).
+		WillReturnRows(sqlmock.NewRows([]string{"Subgroup"}).AddRow("backend"))
+
+	gc := new(GroupClient)
+	users, subgroups := gc.Members(tGroup, db)
+	assert.Nil(t, gc.Err())
+	assert.Equal(t, []string{"alice", "bob"}, users)
+	assert.Equal(t, []string{"backend"}, subgroups)
+
+	if err = mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Expectations were not met. ERROR: %v\n", err)
+	}
+}
+
+func Test_AddMember(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("An error occured when opening a stub database connection. ERROR: %v\n", err)
+	}
+	defer db.Close()
+
+	cases := []struct {
+		err    error
+		expect error
+	}{
+		{nil, nil},
+		{errors.New("Violation of PRIMARY KEY constraint 'PK_GroupMembers'."), nil},
+		{errors.New("The INSERT statement conflicted with the FOREIGN KEY constraint \"FK_GroupMembers_Groups\"."), ErrorGroupNotFound},
+	}
+	for i, c := range cases {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			exec := mock.ExpectExec(`INSERT INTO \[auth]\.\[GroupMembers] \(\[Group],\[ID]\) VALUES \(\?,\?\)`).WithArgs(tGroup, tID)
+			if c.err != nil {
+				exec.WillReturnError(c.err)
+			} else {
+				exec.WillReturnResult(sqlmock.NewResult(0, 1))
+			}
+
+			gc := new(GroupClient)
+			gc.AddMember(tGroup, tID, db)
+			assert.Equal(t, c.expect, gc.Err())
+
+			if err = mock.ExpectationsWereMet(); err != nil {
+				t.Errorf("Expectations were not met. ERROR: %v\n", err)
+			}
+		})
+	}
+}
+
+func Test_RemoveMember(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("An error occured when opening a stub database connection. ERROR: %v\n", err)
+	}
+	defer db.Close()
+
+	cases := []struct {
+		affected int64
+		err      error
+	}{
+		{1, nil},
+		{0, ErrorMemberNotFound},
+	}
+	for i, c := range cases {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			mock.ExpectExec(`DELETE FROM \[auth]\.\[GroupMembers] WHERE \[Group] = \? AND \[ID] = \?`).
+				WithArgs(tGroup, tID).
+				WillReturnResult(sqlmock.NewResult(0, c.affected))
+
+			gc := new(GroupClient)
+			gc.RemoveMember(tGroup, tID, db)
+			assert.Equal(t, c.err, gc.Err())
+
+			if err = mock.ExpectationsWereMet(); err != nil {
+				t.Errorf("Expectations were not met. ERROR: %v\n", err)
+			}
+		})
+	}
+}
+
+func Test_AddSubgroup(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("An error occured when opening a stub database connection. ERROR: %v\n", err)
+	}
+	defer db.Close()
+
+	parents := `SELECT \[Group] FROM \[auth]\.\[GroupSubgroups] WHERE \[Subgroup] IN \(\?\)`
+	insert := `INSERT INTO \[auth]\.\[GroupSubgroups] \(\[Group],\[Subgroup]\) VALUES \(\?,\?\)`
+
+	t.Run("0", func(t *testing.T) {
+		mock.ExpectQuery(parents).WithArgs("backend").WillReturnRows(sqlmock.NewRows([]string{"Group"}).AddRow(tGroup))
+		mock.ExpectQuery(parents).WithArgs(tGroup).WillReturnRows(sqlmock.NewRows([]string{"Group"}))
+		mock.ExpectExec(insert).WithArgs("backend", "api").WillReturnResult(sqlmock.NewResult(0, 1))
+
+		gc := new(GroupClient)
+		gc.AddSubgroup("backend", "api", db)
+		assert.Nil(t, gc.Err())
+
+		if err = mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("Expectations were not met. ERROR: %v\n", err)
+		}
+	})
+
+	t.Run("1", func(t *testing.T) {
+		mock.ExpectQuery(parents).WithArgs("backend").WillReturnRows(sqlmock.NewRows([]string{"Group"}).AddRow(tGroup))
+		mock.ExpectQuery(parents).WithArgs(tGroup).WillReturnRows(sqlmock.NewRows([]string{"Group"}))
+
+		gc := new(GroupClient)
+		gc.AddSubgroup("backend", tGroup, db)
+		assert.Equal(t, ErrorGroupCycle, gc.Err())
+
+		if err = mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("Expectations were not met. ERROR: %v\n", err)
+		}
+	})
+
+	t.Run("2", func(t *testing.T) {
+		mock.ExpectQuery(parents).WithArgs(tGroup).WillReturnRows(sqlmock.NewRows([]string{"Group"}))
+
+		gc := new(GroupClient)
+		gc.AddSubgroup(tGroup, tGroup, db)
+		assert.Equal(t, ErrorGroupCycle, gc.Err())
+
+		if err = mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("Expectations were not met. ERROR: %v\n", err)
+		}
+	})
+
+	t.Run("3", func(t *testing.T) {
+		mock.ExpectQuery(parents).WithArgs(tGroup).WillReturnRows(sqlmock.NewRows([]string{"Group"}))
+		mock.ExpectExec(insert).WithArgs(tGroup, "missing").
+			WillReturnError(errors.New("The INSERT statement conflicted with the FOREIGN KEY constraint \"FK_GroupSubgroups_Subgroups\"."))
+
+		gc := new(GroupClient)
+		gc.AddSubgroup(tGroup, "missing", db)
+		assert.Equal(t, ErrorGroupNotFound, gc.Err())
+
+		if err = mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("Expectations were not met. ERROR: %v\n", err)
+		}
+	})
+
+	t.Run("4", func(t *testing.T) {
+		gc := new(GroupClient)
+		gc.AddSubgroup(tGroup, "Backend", db)
+		assert.Equal(t, ErrorGroupInvalid, gc.Err())
+	})
+}
+
+func Test_RemoveSubgroup(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("An error occured when opening a stub database connection. ERROR: %v\n", err)
+	}
+	defer db.Close()
+
+	cases := []struct {
+		affected int64
+		err      error
+	}{
+		{1, nil},
+		{0, ErrorMemberNotFound},
+	}
+	for i, c := range cases {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			mock.ExpectExec(`DELETE FROM \[auth]\.\[GroupSubgroups] WHERE \[Group] = \? AND \[Subgroup] = \?`).
+				WithArgs(tGroup, "backend").
+				WillReturnResult(sqlmock.NewResult(0, c.affected))
+
+			gc := new(GroupClient)
+			gc.RemoveSubgroup(tGroup, "backend", db)
+			assert.Equal(t, c.err, gc.Err())
+
+			if err = mock.ExpectationsWereMet(); err != nil {
+				t.Errorf("Expectations were not met. ERROR: %v\n", err)
+			}
+		})
+	}
+}
+
+func Test_Memberships(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("An error occured when opening a stub database connection. ERROR: %v\n", err)
+	}
+	defer db.Close()
+
+	direct := `SELECT \[Group] FROM \[auth]\.\[GroupMembers] WHERE \[ID] = \?`
+
+	t.Run("0", func(t *testing.T) {
+		mock.ExpectQuery(direct).WithArgs(tID).WillReturnRows(sqlmock.NewRows([]string{"Group"}).AddRow("api").AddRow("staff"))
+		mock.ExpectQuery(`SELECT \[Group] FROM \[auth]\.\[GroupSubgroups] WHERE \[Subgroup] IN \(\?,\?\)`).
+			WithArgs("api", "staff").
+			WillReturnRows(sqlmock.NewRows([]string{"Group"}).AddRow("backend"))
+		mock.ExpectQuery(`SELECT \[Group] FROM \[auth]\.\[GroupSubgroups] WHERE \[Subgroup] IN \(\?\)`).
+			WithArgs("backend").
+			WillReturnRows(sqlmock.NewRows([]string{"Group"}).AddRow(tGroup).AddRow("api"))
+		mock.ExpectQuery(`SELECT \[Group] FROM \[auth]\.\[GroupSubgroups] WHERE \[Subgroup] IN \(\?\)`).
+			WithArgs(tGroup).
+			WillReturnRows(sqlmock.NewRows([]string{"Group"}))
+
+		gc := new(GroupClient)
+		assert.Equal(t, []string{"api", "backend", tGroup, "staff"}, gc.Memberships(tID, db))
+		assert.Nil(t, gc.Err())
+
+		if err = mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("Expectations were not met. ERROR: %v\n", err)
+		}
+	})
+
+	t.Run("1", func(t *testing.T) {
+		mock.ExpectQuery(direct).WithArgs(tID).WillReturnRows(sqlmock.NewRows([]string{"Group"}))
+
+		gc := new(GroupClient)
+		assert.Nil(t, gc.Memberships(tID, db))
+		assert.Nil(t, gc.Err())
+
+		if err = mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("Expectations were not met. ERROR: %v\n", err)
+		}
+	})
+
+	t.Run("2", func(t *testing.T) {
+		defer func(depth int) { MaxDepth = depth }(MaxDepth)
+		MaxDepth = 1
+
+		mock.ExpectQuery(direct).WithArgs(tID).WillReturnRows(sqlmock.NewRows([]string{"Group"}).AddRow("api"))
+		mock.ExpectQuery(`SELECT \[Group] FROM \[auth]\.\[GroupSubgroups] WHERE \[Subgroup] IN \(\?\)`).
+			WithArgs("api").
+			WillReturnRows(sqlmock.NewRows([]string{"Group"}).AddRow("backend"))
+
+		gc := new(GroupClient)
+		assert.Equal(t, []string{"api", "backend"}, gc.Memberships(tID, db))
+		assert.Nil(t, gc.Err())
+
+		if err = mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("Expectations were not met. ERROR: %v\n", err)
+		}
+	})
+}
+
+func Test_MemberIDs(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("An error occured when opening a stub database connection. ERROR: %v\n", err)
+	}
+	defer db.Close()
+
+	mock.ExpectQuery(`SELECT \[Subgroup] FROM \[auth]\.\[GroupSubgroups] WHERE \[Group] IN \(\?\)`).
+		WithArgs(tGroup).
+		WillReturnRows(sqlmock.NewRows([]string{"Subgroup"}).AddRow("backend"))
+	mock.ExpectQuery(`SELECT \[Subgroup] FROM \[auth]\.\[GroupSubgroups] WHERE \[Group] IN \(\?\)`).
+		WithArgs("backend").
+		WillReturnRows(sqlmock.NewRows([]string{"Subgroup"}).AddRow(tGroup))
+	mock.ExpectQuery(`SELECT DISTINCT \[ID] FROM \[auth]\.\[GroupMembers] WHERE \[Group] IN \(\?,\?\) ORDER BY \[ID]`).
+		WithArgs("backend", tGroup).
+		WillReturnRows(sqlmock.NewRows([]string{"ID"}).AddRow(tID))
+
+	gc := new(GroupClient)
+	assert.Equal(t, []string{tID}, gc.MemberIDs([]string{tGroup}, db))
+	assert.Nil(t, gc.MemberIDs(nil, db))
+	assert.Nil(t, gc.Err())
+
+	if err = mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Expectations were not met. ERROR: %v\n", err)
+	}
+}
@@ -0,0 +1,356 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/dgrijalva/jwt-go"
+	"github.com/penutty/authservice/audit"
+	"github.com/penutty/authservice/env"
+	"github.com/penutty/authservice/group"
+	"github.com/penutty/authservice/user"
+	"net/http"
+	"os"
+)
+
+var (
+	// GroupsClaimLimit is the maximum number of groups put in the "groups" claim of an access token. The token of a
+	// user in more groups instead points to GroupsEndpointURL, where they are looked up with the token.
+	GroupsClaimLimit = env.Int("GroupsClaimLimit", 20)
+	// GroupsEndpointURL is the absolute URL of UserGroupsEndpoint announced in tokens; UserGroupsEndpoint if unset.
+	GroupsEndpointURL = os.Getenv("GroupsEndpointURL")
+
+	ErrorGroupMemberInvalid = errors.New("Exactly one of \"UserID\" and \"Subgroup\" is required.")
+)
+
+// groupMembersResource is the representation of the direct members of a group returned by GroupMembersEndpoint.
+type groupMembersResource struct {
+	Group     string
+	Users     []string
+	Subgroups []string
+}
+
+// userGroupsResource is the representation of the groups of a user returned by UserGroupsEndpoint.
+type userGroupsResource struct {
+	UserID string
+	Groups []string
+}
+
+// groupsClaims adds groups to claims: as the "groups" claim if there are at most GroupsClaimLimit, and otherwise
+// as an OpenID Connect distributed claim whose source is GroupsEndpointURL, keeping tokens small.
+func groupsClaims(groups []string, claims jwt.MapClaims) {
+	switch {
+	case len(groups) == 0:
+	case len(groups) <= GroupsClaimLimit:
+		claims["groups"] = groups
+	default:
+		endpoint := GroupsEndpointURL
+		if endpoint == "" {
+			endpoint = UserGroupsEndpoint
+		}
+		claims["_claim_names"] = map[string]string{"groups": "groups"}
+		claims["_claim_sources"] = map[string]map[string]string{"groups": {"endpoint": endpoint}}
+	}
+}
+
+func (a *app) groupsHandler(w http.ResponseWriter, r *http.Request) {
+	var (
+		res interface{}
+		err error
+	)
+	switch r.Method {
+	case http.MethodGet:
+		res, err = a.getGroups(r)
+	case http.MethodPut:
+		res, err = a.putGroup(r)
+	case http.MethodDelete:
+		if err := a.deleteGroup(r); err != nil {
+			genErrorHandler(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return
+	default:
+		logger(Error).Println(ErrorMethodNotImplemented)
+		http.Error(w, http.StatusText(http.StatusNotImplemented), http.StatusNotImplemented)
+		return
+	}
+	if err != nil {
+		genErrorHandler(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(res); err != nil {
+		logger(Error).Println(err)
+	}
+}
+
+// getGroups lets an administrator list every group.
+func (a *app) getGroups(r *http.Request) ([]*group.Group, error) {
+	if _, err := a.defaultAdministrator(r); err != nil {
+		return nil, err
+	}
+	groups := a.g.List(user.AuthDB())
+	if err := a.g.Err(); err != nil {
+		return nil, err
+	}
+	if groups == nil {
+		groups = []*group.Group{}
+	}
+	return groups, nil
+}
+
+// putGroup lets an administrator create a group or replace its description.
+func (a *app) putGroup(r *http.Request) (*group.Group, error) {
+	if _, err := a.defaultAdministrator(r); err != nil {
+		return nil, err
+	}
+
+	g := new(group.Group)
+	if err := json.NewDecoder(r.Body).Decode(g); err != nil {
+		return nil, err
+	}
+	a.g.Save(g, user.AuthDB())
+	if err := a.g.Err(); err != nil {
+		return nil, err
+	}
+	return g, nil
+}
+
+// deleteGroup lets an administrator delete the group in the "Group" query parameter with its memberships and roles.
+// Every token of its members, including those of its subgroups, is revoked.
+func (a *app) deleteGroup(r *http.Request) error {
+	if _, err := a.defaultAdministrator(r); err != nil {
+		return err
+	}
+	name := r.URL.Query().Get("Group")
+	members, err := a.groupMemberIDs(name)
+	if err != nil {
+		return err
+	}
+	a.g.Delete(name, user.AuthDB())
+	if err := a.g.Err(); err != nil {
+		return err
+	}
+	a.c.RevokeTokensByID(members, user.AuthDB())
+	return a.c.Err()
+}
+
+// groupMemberIDs returns the IDs of the members of the group name, including those of its subgroups.
+func (a *app) groupMemberIDs(name string) ([]string, error) {
+	ids := a.g.MemberIDs([]string{name}, user.AuthDB())
+	if err := a.g.Err(); err != nil {
+		return nil, err
+	}
+	return ids, nil
+}
+
+func (a *app) groupMembersHandler(w http.ResponseWriter, r *http.Request) {
+	var (
+		res *groupMembersResource
+		err error
+	)
+	switch r.Method {
+	case http.MethodGet:
+		res, err = a.getGroupMembers(r)
+	case http.MethodPost, http.MethodDelete:
+		res, err = a.changeGroupMembers(r)
+	default:
+		logger(Error).Println(ErrorMethodNotImplemented)
+		http.Error(w, http.StatusText(http.StatusNotImplemented), http.StatusNotImplemented)
+		return
+	}
+	if err != nil {
+		genErrorHandler(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(res); err != nil {
+		logger(Error).Println(err)
+	}
+}
+
+// getGroupMembers lets an administrator list the direct members of the group in the "Group" query parameter.
+func (a *app) getGroupMembers(r *http.Request) (*groupMembersResource, error) {
+	if _, err := a.defaultAdministrator(r); err != nil {
+		return nil, err
+	}
+	return a.groupMembers(r.URL.Query().Get("Group"))
+}
+
+// groupMembers returns the direct members of name.
+func (a *app) groupMembers(name string) (*groupMembersResource, error) {
+	users, subgroups := a.g.Members(name, user.AuthDB())
+	if err := a.g.Err(); err != nil {
+		return nil, err
+	}
+	res := &groupMembersResource{Group: name, Users: users, Subgroups: subgroups}
+	if res.Users == nil {
+		res.Users = []string{}
+	}
+	if res.Subgroups == nil {
+		res.Subgroups = []string{}
+	}
+	return res, nil
+}
+
+// changeGroupMembers lets an administrator add (POST) or remove (DELETE) the user with the UserID in the body, or the
+// Subgroup in the body, to or from the Group in the body. Every token of a removed user, or of the members of a removed
+// subgroup, is revoked. The change is recorded in the audit log.
+func (a *app) changeGroupMembers(r *http.Request) (*groupMembersResource, error) {
+	caller, err := a.defaultAdministrator(r)
+	if err != nil {
+		return nil, err
+	}
+
+	type body struct {
+		Group    string
+		UserID   string
+		Subgroup string
+	}
+	b := new(body)
+	if err := json.NewDecoder(r.Body).Decode(b); err != nil {
+		return nil, err
+	}
+	if (b.UserID == "") == (b.Subgroup == "") {
+		return nil, ErrorGroupMemberInvalid
+	}
+
+	action := "group_add"
+	if r.Method == http.MethodDelete {
+		action = "group_remove"
+	}
+	if b.Subgroup != "" {
+		var members []string
+		if r.Method == http.MethodPost {
+			a.g.AddSubgroup(b.Group, b.Subgroup, user.AuthDB())
+		} else {
+			if members, err = a.groupMemberIDs(b.Subgroup); err != nil {
+				return nil, err
+			}
+			a.g.RemoveSubgroup(b.Group, b.Subgroup, user.AuthDB())
+		}
+		if err := a.g.Err(); err != nil {
+			return nil, err
+		}
+		a.c.RevokeTokensByID(members, user.AuthDB())
+		if err := a.c.Err(); err != nil {
+			return nil, err
+		}
+		a.au.Record(audit.NewEntry(caller.ID(), b.Group, action, "subgroup "+b.Subgroup), user.AuthDB())
+		if err := a.au.Err(); err != nil {
+			logger(Error).Println(err)
+		}
+		return a.groupMembers(b.Group)
+	}
+
+	u, err := a.fetchUser(qualify(r, b.UserID))
+	if err != nil {
+		return nil, err
+	}
+	if r.Method == http.MethodPost {
+		a.g.AddMember(b.Group, u.ID(), user.AuthDB())
+	} else {
+		a.g.RemoveMember(b.Group, u.ID(), user.AuthDB())
+	}
+	if err := a.g.Err(); err != nil {
+		return nil, err
+	}
+	if r.Method == http.MethodDelete {
+		a.c.RevokeTokens(u.UserID(), user.AuthDB())
+		if err := a.c.Err(); err != nil {
+			return nil, err
+		}
+	}
+	a.au.Record(audit.NewEntry(caller.ID(), u.ID(), action, b.Group), user.AuthDB())
+	if err := a.au.Err(); err != nil {
+		logger(Error).Println(err)
+	}
+	return a.groupMembers(b.Group)
+}
+
+// groupRolesHandler lets an administrator assign (POST) or unassign (DELETE) the Role in the body to the Group in the body.
+// Unassigning the role revokes every token of the members of the group, including those of its subgroups. The change
+// is recorded in the audit log.
+func (a *app) groupRolesHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost && r.Method != http.MethodDelete {
+		logger(Error).Println(ErrorMethodNotImplemented)
+		http.Error(w, http.StatusText(http.StatusNotImplemented), http.StatusNotImplemented)
+		return
+	}
+	if err := a.changeGroupRoles(r); err != nil {
+		genErrorHandler(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (a *app) changeGroupRoles(r *http.Request) error {
+	caller, err := a.defaultAdministrator(r)
+	if err != nil {
+		return err
+	}
+
+	type body struct {
+		Group string
+		Role  string
+	}
+	b := new(body)
+	if err := json.NewDecoder(r.Body).Decode(b); err != nil {
+		return err
+	}
+
+	action := "group_role_assign"
+	if r.Method == http.MethodPost {
+		a.rb.AssignGroup(b.Group, b.Role, user.AuthDB())
+	} else {
+		action = "group_role_unassign"
+		a.rb.UnassignGroup(b.Group, b.Role, user.AuthDB())
+	}
+	if err := a.rb.Err(); err != nil {
+		return err
+	}
+	if r.Method == http.MethodDelete {
+		members, err := a.groupMemberIDs(b.Group)
+		if err != nil {
+			return err
+		}
+		a.c.RevokeTokensByID(members, user.AuthDB())
+		if err := a.c.Err(); err != nil {
+			return err
+		}
+	}
+	a.au.Record(audit.NewEntry(caller.ID(), b.Group, action, b.Role), user.AuthDB())
+	if err := a.au.Err(); err != nil {
+		logger(Error).Println(err)
+	}
+	return nil
+}
+
+// userGroupsHandler returns the groups of the caller, or as an administrator of any user, including the groups
+// they are a member of through subgroups. It is the source of the groups of tokens without a "groups" claim.
+func (a *app) userGroupsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		logger(Error).Println(ErrorMethodNotImplemented)
+		http.Error(w, http.StatusText(http.StatusNotImplemented), http.StatusNotImplemented)
+		return
+	}
+
+	_, u, err := a.targetUser(r, ScopeGroupsRead)
+	if err != nil {
+		genErrorHandler(w, err)
+		return
+	}
+	groups := a.g.Memberships(u.ID(), user.AuthDB())
+	if err := a.g.Err(); err != nil {
+		genErrorHandler(w, err)
+		return
+	}
+	if groups == nil {
+		groups = []string{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(&userGroupsResource{UserID: u.UserID(), Groups: groups}); err != nil {
+		logger(Error).Println(err)
+	}
+}
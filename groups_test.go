@@ -0,0 +1,422 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	sq "github.com/Masterminds/squirrel"
+	"github.com/dgrijalva/jwt-go"
+	"github.com/penutty/authservice/group"
+	"github.com/penutty/authservice/verification"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+var (
+	tGroup       = "engineering"
+	tParentGroup = "staff"
+)
+
+type MockGroupClient struct {
+	err     error
+	groups  map[string]*group.Group
+	members map[string][]string
+	parents map[string][]string
+}
+
+// NewMockGroupClient returns a MockGroupClient where tUser is a member of tGroup, itself a subgroup of tParentGroup.
+func NewMockGroupClient() *MockGroupClient {
+	return &MockGroupClient{
+		groups:  map[string]*group.Group{tGroup: &group.Group{Name: tGroup}, tParentGroup: &group.Group{Name: tParentGroup}},
+		members: map[string][]string{tGroup: []string{tID}},
+		parents: map[string][]string{tGroup: []string{tParentGroup}},
+	}
+}
+
+func (m *MockGroupClient) Save(g *group.Group, db sq.BaseRunner) {
+	if err := g.Check(); err != nil {
+		m.err = err
+		return
+	}
+	m.groups[g.Name] = g
+}
+
+func (m *MockGroupClient) Delete(name string, db sq.BaseRunner) {
+	if _, ok := m.groups[name]; !ok {
+		m.err = group.ErrorGroupNotFound
+		return
+	}
+	delete(m.groups, name)
+}
+
+func (m *MockGroupClient) List(db sq.BaseRunner) (groups []*group.Group) {
+	for _, g := range m.groups {
+		groups = append(groups, g)
+	}
+	return
+}
+
+func (m *MockGroupClient) Members(name string, db sq.BaseRunner) (users []string, subgroups []string) {
+	for _, id := range m.members[name] {
+		users = append(users, strings.TrimPrefix(id, "id-"))
+	}
+	for sub, parents := range m.parents {
+		for _, p := range parents {
+			if p == name {
+				subgroups = append(subgroups, sub)
+			}
+		}
+	}
+	return
+}
+
+func (m *MockGroupClient) AddMember(name, id string, db sq.BaseRunner) {
+	if _, ok := m.groups[name]; !ok {
+		m.err = group.ErrorGroupNotFound
+		return
+	}
+	m.members[name] = append(m.members[name], id)
+}
+
+func (m *MockGroupClient) RemoveMember(name, id string, db sq.BaseRunner) {
+	m.members[name], m.err = remove(m.members[name], id)
+}
+
+func (m *MockGroupClient) AddSubgroup(name, subgroup string, db sq.BaseRunner) {
+	if _, ok := m.groups[subgroup]; !ok {
+		m.err = group.ErrorGroupNotFound
+		return
+	}
+	for _, g := range m.ancestors([]string{name}) {
+		if g == subgroup {
+			m.err = group.ErrorGroupCycle
+			return
+		}
+	}
+	m.parents[subgroup] = append(m.parents[subgroup], name)
+}
+
+func (m *MockGroupClient) RemoveSubgroup(name, subgroup string, db sq.BaseRunner) {
+	m.parents[subgroup], m.err = remove(m.parents[subgroup], name)
+}
+
+func (m *MockGroupClient) Memberships(id string, db sq.BaseRunner) []string {
+	var direct []string
+	for g, ids := range m.members {
+		for _, v := range ids {
+			if v == id {
+				direct = append(direct, g)
+			}
+		}
+	}
+	if len(direct) == 0 {
+		return nil
+	}
+	return m.ancestors(direct)
+}
+
+func (m *MockGroupClient) ancestors(groups []string) (all []string) {
+	seen := make(map[string]bool)
+	for len(groups) > 0 {
+		g := groups[0]
+		groups = groups[1:]
+		if seen[g] {
+			continue
+		}
+		seen[g] = true
+		all = append(all, g)
+		groups = append(groups, m.parents[g]...)
+	}
+	sort.Strings(all)
+	return
+}
+
+func (m *MockGroupClient) MemberIDs(groups []string, db sq.BaseRunner) (ids []string) {
+	seen := make(map[string]bool)
+	for len(groups) > 0 {
+		g := groups[0]
+		groups = groups[1:]
+		if seen[g] {
+			continue
+		}
+		seen[g] = true
+		ids = append(ids, m.members[g]...)
+		for sub, parents := range m.parents {
+			for _, p := range parents {
+				if p == g {
+					groups = append(groups, sub)
+				}
+			}
+		}
+	}
+	sort.Strings(ids)
+	return
+}
+
+func (m *MockGroupClient) Err() error {
+	return m.err
+}
+
+// remove returns s without v, or group.ErrorMemberNotFound if s does not contain v.
+func remove(s []string, v string) ([]string, error) {
+	for i, e := range s {
+		if e == v {
+			return append(s[:i], s[i+1:]...), nil
+		}
+	}
+	return s, group.ErrorMemberNotFound
+}
+
+func NewGroupMemberBody(name, userID, subgroup string) *strings.Reader {
+	return strings.NewReader(fmt.Sprintf("{\"Group\": \"%s\", \"UserID\": \"%s\", \"Subgroup\": \"%s\"}", name, userID, subgroup))
+}
+
+func NewGroupRoleBody(name, role string) *strings.Reader {
+	return strings.NewReader(fmt.Sprintf("{\"Group\": \"%s\", \"Role\": \"%s\"}", name, role))
+}
+
+func Test_groupsClaims(t *testing.T) {
+	defer func(limit int, url string) { GroupsClaimLimit, GroupsEndpointURL = limit, url }(GroupsClaimLimit, GroupsEndpointURL)
+
+	type test struct {
+		groups   []string
+		limit    int
+		url      string
+		claim    []string
+		endpoint string
+	}
+	cases := []*test{
+		&test{nil, 2, "", nil, ""},
+		&test{[]string{tGroup, tParentGroup}, 2, "", []string{tGroup, tParentGroup}, ""},
+		&test{[]string{tGroup, tParentGroup}, 1, "", nil, UserGroupsEndpoint},
+		&test{[]string{tGroup, tParentGroup}, 0, "https://auth.example.com/user/groups", nil, "https://auth.example.com/user/groups"},
+	}
+	for i, c := range cases {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			GroupsClaimLimit, GroupsEndpointURL = c.limit, c.url
+			claims := jwt.MapClaims{}
+			groupsClaims(c.groups, claims)
+
+			token, err := generateJwt(tID, nil, nil, claims)
+			if err != nil {
+				t.Fatal(err)
+			}
+			parsed, err := verification.Parse(token)
+			assert.Nil(t, err)
+			assert.Equal(t, c.claim, verification.Groups(parsed))
+			assert.Equal(t, c.endpoint, verification.GroupsEndpoint(parsed))
+		})
+	}
+}
+
+func Test_accessToken_groups(t *testing.T) {
+	defer func(limit int) { GroupsClaimLimit = limit }(GroupsClaimLimit)
+
+	a, m, _ := newVerifyApp()
+	rb := NewMockRBACClient()
+	rb.groups[tParentGroup] = []string{tRole}
+	a.rb = rb
+	a.g = NewMockGroupClient()
+	a.ss = NewMockSessionClient()
+
+	token, _, err := a.accessToken(m.Fetch(tUser, nil), NewAuthRequest())
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims, err := verification.ParseAudience(token, "Moment-Service")
+	assert.Nil(t, err)
+	assert.Equal(t, []string{tGroup, tParentGroup}, verification.Groups(claims))
+	assert.Equal(t, []string{tRole}, verification.Roles(claims))
+	assert.Nil(t, verification.Check(claims, "moments:write"))
+
+	GroupsClaimLimit = 1
+	token, _, err = a.accessToken(m.Fetch(tUser, nil), NewAuthRequest())
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims, err = verification.ParseAudience(token, "Moment-Service")
+	assert.Nil(t, err)
+	assert.NotContains(t, claims, "groups")
+	assert.Equal(t, UserGroupsEndpoint, verification.GroupsEndpoint(claims))
+	assert.Equal(t, []string{tRole}, verification.Roles(claims))
+
+	token, _, err = a.accessToken(m.Fetch(tOtherUser, nil), NewAuthRequest())
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims, err = verification.ParseAudience(token, "Moment-Service")
+	assert.Nil(t, err)
+	assert.NotContains(t, claims, "groups")
+	assert.NotContains(t, claims, "_claim_names")
+}
+
+func Test_groupsHandler(t *testing.T) {
+	defer func(admins []string) { Administrators = admins }(Administrators)
+
+	type test struct {
+		req     *http.Request
+		admins  []string
+		code    int
+		groups  int
+		revoked []string
+	}
+	cases := []*test{
+		&test{NewBearerRequest(http.MethodGet, GroupsEndpoint, nil), nil, http.StatusForbidden, 2, nil},
+		&test{NewBearerRequest(http.MethodGet, GroupsEndpoint, nil), []string{tUser}, http.StatusOK, 2, nil},
+		&test{NewBearerRequest(http.MethodPut, GroupsEndpoint, strings.NewReader(`{"Name": "backend"}`)), nil, http.StatusForbidden, 2, nil},
+		&test{NewBearerRequest(http.MethodPut, GroupsEndpoint, strings.NewReader(`{"Name": "backend", "Description": "Backend"}`)), []string{tUser}, http.StatusOK, 3, nil},
+		&test{NewBearerRequest(http.MethodPut, GroupsEndpoint, strings.NewReader(`{"Name": "Backend"}`)), []string{tUser}, http.StatusBadRequest, 2, nil},
+		&test{NewBearerRequest(http.MethodDelete, GroupsEndpoint+"?Group="+tGroup, nil), []string{tUser}, http.StatusNoContent, 1, []string{tID}},
+		&test{NewBearerRequest(http.MethodDelete, GroupsEndpoint+"?Group=backend", nil), []string{tUser}, http.StatusNotFound, 2, nil},
+		&test{httptest.NewRequest(http.MethodGet, GroupsEndpoint, nil), []string{tUser}, http.StatusUnauthorized, 2, nil},
+		&test{NewBearerRequest(http.MethodPost, GroupsEndpoint, nil), []string{tUser}, http.StatusNotImplemented, 2, nil},
+	}
+	for i, c := range cases {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			Administrators = administrators(c.admins...)
+			a, m, _ := newVerifyApp()
+			g := NewMockGroupClient()
+			a.g = g
+			rec := httptest.NewRecorder()
+			a.groupsHandler(rec, c.req)
+			assert.Equal(t, c.code, rec.Code)
+			assert.Len(t, g.groups, c.groups)
+			assert.Equal(t, c.revoked, m.revokedIDs)
+		})
+	}
+}
+
+func Test_groupMembersHandler(t *testing.T) {
+	defer func(admins []string) { Administrators = admins }(Administrators)
+
+	type test struct {
+		req       *http.Request
+		admins    []string
+		code      int
+		users     []string
+		subgroups []string
+		revoked   bool
+		audit     int
+	}
+	cases := []*test{
+		&test{NewBearerRequest(http.MethodGet, GroupMembersEndpoint+"?Group="+tGroup, nil), nil, http.StatusForbidden, nil, nil, false, 0},
+		&test{NewBearerRequest(http.MethodGet, GroupMembersEndpoint+"?Group="+tGroup, nil), []string{tUser}, http.StatusOK, []string{tUser}, []string{}, false, 0},
+		&test{NewBearerRequest(http.MethodGet, GroupMembersEndpoint+"?Group="+tParentGroup, nil), []string{tUser}, http.StatusOK, []string{}, []string{tGroup}, false, 0},
+		&test{NewBearerRequest(http.MethodPost, GroupMembersEndpoint, NewGroupMemberBody(tGroup, tOtherUser, "")), nil, http.StatusForbidden, nil, nil, false, 0},
+		&test{NewBearerRequest(http.MethodPost, GroupMembersEndpoint, NewGroupMemberBody(tGroup, tOtherUser, "")), []string{tUser}, http.StatusOK, []string{tUser, tOtherUser}, []string{}, false, 1},
+		&test{NewBearerRequest(http.MethodPost, GroupMembersEndpoint, NewGroupMemberBody("backend", tOtherUser, "")), []string{tUser}, http.StatusNotFound, nil, nil, false, 0},
+		&test{NewBearerRequest(http.MethodPost, GroupMembersEndpoint, NewGroupMemberBody(tGroup, tUserMissing, "")), []string{tUser}, http.StatusNotFound, nil, nil, false, 0},
+		&test{NewBearerRequest(http.MethodPost, GroupMembersEndpoint, NewGroupMemberBody(tGroup, tOtherUser, tParentGroup)), []string{tUser}, http.StatusBadRequest, nil, nil, false, 0},
+		&test{NewBearerRequest(http.MethodPost, GroupMembersEndpoint, NewGroupMemberBody(tGroup, "", "")), []string{tUser}, http.StatusBadRequest, nil, nil, false, 0},
+		&test{NewBearerRequest(http.MethodPost, GroupMembersEndpoint, NewGroupMemberBody(tGroup, "", tParentGroup)), []string{tUser}, http.StatusConflict, nil, nil, false, 0},
+		&test{NewBearerRequest(http.MethodPost, GroupMembersEndpoint, NewGroupMemberBody(tGroup, "", tGroup)), []string{tUser}, http.StatusConflict, nil, nil, false, 0},
+		&test{NewBearerRequest(http.MethodDelete, GroupMembersEndpoint, NewGroupMemberBody(tParentGroup, "", tGroup)), []string{tUser}, http.StatusOK, []string{}, []string{}, true, 1},
+		&test{NewBearerRequest(http.MethodDelete, GroupMembersEndpoint, NewGroupMemberBody(tGroup, tUser, "")), []string{tUser}, http.StatusOK, []string{}, []string{}, true, 1},
+		&test{NewBearerRequest(http.MethodDelete, GroupMembersEndpoint, NewGroupMemberBody(tGroup, tOtherUser, "")), []string{tUser}, http.StatusNotFound, nil, nil, false, 0},
+		&test{httptest.NewRequest(http.MethodGet, GroupMembersEndpoint, nil), nil, http.StatusUnauthorized, nil, nil, false, 0},
+		&test{NewBearerRequest(http.MethodPut, GroupMembersEndpoint, nil), nil, http.StatusNotImplemented, nil, nil, false, 0},
+	}
+	for i, c := range cases {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			Administrators = administrators(c.admins...)
+			a, m, _ := newVerifyApp()
+			a.g = NewMockGroupClient()
+			a.ss = NewMockSessionClient()
+			au := new(MockAuditClient)
+			a.au = au
+			rec := httptest.NewRecorder()
+			a.groupMembersHandler(rec, c.req)
+			assert.Equal(t, c.code, rec.Code)
+			assert.Equal(t, c.revoked, !m.revoked.IsZero())
+			assert.Len(t, au.entries, c.audit)
+			if c.code != http.StatusOK {
+				return
+			}
+
+			res := new(groupMembersResource)
+			assert.Nil(t, json.NewDecoder(rec.Body).Decode(res))
+			assert.Equal(t, c.users, res.Users)
+			assert.Equal(t, c.subgroups, res.Subgroups)
+		})
+	}
+}
+
+func Test_groupRolesHandler(t *testing.T) {
+	defer func(admins []string) { Administrators = admins }(Administrators)
+
+	type test struct {
+		req     *http.Request
+		admins  []string
+		code    int
+		roles   []string
+		revoked bool
+		audit   int
+	}
+	cases := []*test{
+		&test{NewBearerRequest(http.MethodPost, GroupRolesEndpoint, NewGroupRoleBody(tGroup, tRole)), nil, http.StatusForbidden, nil, false, 0},
+		&test{NewBearerRequest(http.MethodPost, GroupRolesEndpoint, NewGroupRoleBody(tGroup, tRole)), []string{tUser}, http.StatusNoContent, []string{tRole}, false, 1},
+		&test{NewBearerRequest(http.MethodPost, GroupRolesEndpoint, NewGroupRoleBody(tGroup, "viewer")), []string{tUser}, http.StatusNotFound, nil, false, 0},
+		&test{NewBearerRequest(http.MethodPost, GroupRolesEndpoint, NewGroupRoleBody("backend", tRole)), []string{tUser}, http.StatusNotFound, nil, false, 0},
+		&test{NewBearerRequest(http.MethodDelete, GroupRolesEndpoint, NewGroupRoleBody(tParentGroup, tRole)), []string{tUser}, http.StatusNoContent, nil, true, 1},
+		&test{NewBearerRequest(http.MethodDelete, GroupRolesEndpoint, NewGroupRoleBody(tGroup, tRole)), []string{tUser}, http.StatusNotFound, nil, false, 0},
+		&test{NewBearerRequest(http.MethodGet, GroupRolesEndpoint, nil), []string{tUser}, http.StatusNotImplemented, nil, false, 0},
+	}
+	for i, c := range cases {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			Administrators = administrators(c.admins...)
+			a, m, _ := newVerifyApp()
+			rb := NewMockRBACClient()
+			rb.groups[tParentGroup] = []string{tRole}
+			a.rb = rb
+			a.g = NewMockGroupClient()
+			au := new(MockAuditClient)
+			a.au = au
+			rec := httptest.NewRecorder()
+			a.groupRolesHandler(rec, c.req)
+			assert.Equal(t, c.code, rec.Code)
+			assert.Equal(t, c.roles, rb.groups[tGroup])
+			assert.Equal(t, c.revoked, !m.revoked.IsZero())
+			assert.Len(t, au.entries, c.audit)
+		})
+	}
+}
+
+func Test_userGroupsHandler(t *testing.T) {
+	defer func(admins []string) { Administrators = admins }(Administrators)
+
+	type test struct {
+		req    *http.Request
+		admins []string
+		code   int
+		groups []string
+	}
+	cases := []*test{
+		&test{NewBearerRequest(http.MethodGet, UserGroupsEndpoint, nil), nil, http.StatusOK, []string{tGroup, tParentGroup}},
+		&test{NewBearerRequest(http.MethodGet, UserGroupsEndpoint+"?UserID="+tOtherUser, nil), nil, http.StatusForbidden, nil},
+		&test{NewBearerRequest(http.MethodGet, UserGroupsEndpoint+"?UserID="+tOtherUser, nil), []string{tUser}, http.StatusOK, []string{}},
+		&test{httptest.NewRequest(http.MethodGet, UserGroupsEndpoint, nil), nil, http.StatusUnauthorized, nil},
+		&test{NewBearerRequest(http.MethodPost, UserGroupsEndpoint, nil), nil, http.StatusNotImplemented, nil},
+	}
+	for i, c := range cases {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			Administrators = administrators(c.admins...)
+			a, _, _ := newVerifyApp()
+			a.g = NewMockGroupClient()
+			a.ss = NewMockSessionClient()
+			rec := httptest.NewRecorder()
+			a.userGroupsHandler(rec, c.req)
+			assert.Equal(t, c.code, rec.Code)
+			if c.code != http.StatusOK {
+				return
+			}
+
+			res := new(userGroupsResource)
+			assert.Nil(t, json.NewDecoder(rec.Body).Decode(res))
+			assert.Equal(t, c.groups, res.Groups)
+		})
+	}
+}
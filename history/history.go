@@ -0,0 +1,124 @@
+// Package history is dedicated to reading and writing the password history of users in Auth-Db.
+// Only bcrypt hashes of previous passwords are kept, the last Length per user, so that
+// a password that was used recently can be refused wherever passwords are set.
+package history
+
+import (
+	"errors"
+	"fmt"
+	sq "github.com/Masterminds/squirrel"
+	"github.com/penutty/authservice/env"
+	"golang.org/x/crypto/bcrypt"
+	"log"
+	"time"
+)
+
+var (
+	// Length is the number of previous passwords of a user that may not be reused.
+	Length = env.PositiveInt("PasswordHistory", 5)
+	// Cost is the bcrypt cost of the hashes of previous passwords.
+	Cost = bcrypt.DefaultCost
+
+	ErrorPasswordReused = errors.New("Password was used recently; choose a different one.")
+)
+
+// Hash returns the bcrypt hash of password, which is salted with a random salt.
+func Hash(password string) (string, error) {
+	h, err := bcrypt.GenerateFromPassword([]byte(password), Cost)
+	return string(h), err
+}
+
+// Match reports whether hash is the bcrypt hash of password.
+func Match(hash, password string) bool {
+	return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil
+}
+
+type Client interface {
+	Checker
+	Recorder
+	Err() error
+}
+
+type Checker interface {
+	Check(string, string, sq.BaseRunner)
+}
+
+type Recorder interface {
+	Record(string, string, sq.BaseRunner)
+}
+
+type HistoryClient struct {
+	err error
+}
+
+// Check fails with ErrorPasswordReused if password is one of the last Length passwords of userID.
+func (hc *HistoryClient) Check(userID, password string, db sq.BaseRunner) {
+	if hc.err != nil {
+		return
+	}
+
+	sel := sq.Select(fmt.Sprintf("TOP %d [Hash]", Length)).
+		From("[auth].[PasswordHistory]").
+		Where(sq.Eq{"[UserID]": userID}).
+		OrderBy("[Created] DESC")
+	rows, err := sel.RunWith(db).Query()
+	if err != nil {
+		log.Print(err)
+		hc.err = err
+		return
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var hash string
+		if err := rows.Scan(&hash); err != nil {
+			log.Print(err)
+			hc.err = err
+			return
+		}
+		if Match(hash, password) {
+			hc.err = ErrorPasswordReused
+			return
+		}
+	}
+	if err := rows.Err(); err != nil {
+		log.Print(err)
+		hc.err = err
+	}
+}
+
+// Record adds password to the history of userID and forgets all but the last Length passwords.
+func (hc *HistoryClient) Record(userID, password string, db sq.BaseRunner) {
+	if hc.err != nil {
+		return
+	}
+
+	hash, err := Hash(password)
+	if err != nil {
+		log.Print(err)
+		hc.err = err
+		return
+	}
+
+	insert := sq.Insert("[auth].[PasswordHistory]").
+		Columns("[UserID]", "[Hash]", "[Created]").
+		Values(userID, hash, time.Now().UTC())
+	if _, err := insert.RunWith(db).Exec(); err != nil {
+		log.Print(err)
+		hc.err = err
+		return
+	}
+
+	trim := sq.Delete("[auth].[PasswordHistory]").
+		Where(sq.Eq{"[UserID]": userID}).
+		Where(fmt.Sprintf("[ID] NOT IN (SELECT TOP %d [ID] FROM [auth].[PasswordHistory] WHERE [UserID] = ? ORDER BY [Created] DESC)", Length), userID)
+	if _, err := trim.RunWith(db).Exec(); err != nil {
+		log.Print(err)
+		hc.err = err
+	}
+}
+
+// Err returns the error status of a HistoryClient.
+func (hc *HistoryClient) Err() error {
+	return hc.err
+}
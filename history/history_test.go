@@ -0,0 +1,92 @@
+package history
+
+import (
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/bcrypt"
+	"gopkg.in/DATA-DOG/go-sqlmock.v1"
+	"strconv"
+	"testing"
+)
+
+var (
+	tUser     = "testuser"
+	tPassword = "TestPassword123!"
+)
+
+func init() {
+	Cost = bcrypt.MinCost
+}
+
+// hash returns the hash of password or panics.
+func hash(password string) string {
+	h, err := Hash(password)
+	if err != nil {
+		panic(err)
+	}
+	return h
+}
+
+func Test_Hash(t *testing.T) {
+	h := hash(tPassword)
+	assert.Len(t, h, 60)
+	assert.NotEqual(t, h, hash(tPassword))
+	assert.True(t, Match(h, tPassword))
+	assert.False(t, Match(h, "OtherPassword123!"))
+}
+
+func Test_Check(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("An error occured when opening a stub database connection. ERROR: %v\n", err)
+	}
+	defer db.Close()
+
+	type test struct {
+		password string
+		err      error
+	}
+	cases := []*test{
+		&test{tPassword, ErrorPasswordReused},
+		&test{"OtherPassword123!", nil},
+	}
+	for i, c := range cases {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			mock.ExpectQuery(`SELECT TOP 5 \[Hash] FROM \[auth]\.\[PasswordHistory] WHERE \[UserID] = \? ORDER BY \[Created] DESC`).
+				WithArgs(tUser).
+				WillReturnRows(sqlmock.NewRows([]string{"Hash"}).
+					AddRow(hash("NewerPassword123!")).
+					AddRow(hash(tPassword)))
+
+			hc := new(HistoryClient)
+			hc.Check(tUser, c.password, db)
+			assert.Equal(t, c.err, hc.Err())
+
+			if err = mock.ExpectationsWereMet(); err != nil {
+				t.Errorf("Expectations were not met. ERROR: %v\n", err)
+			}
+		})
+	}
+}
+
+func Test_Record(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("An error occured when opening a stub database connection. ERROR: %v\n", err)
+	}
+	defer db.Close()
+
+	mock.ExpectExec(`INSERT INTO \[auth]\.\[PasswordHistory] \(\[UserID],\[Hash],\[Created]\) VALUES \(\?,\?,\?\)`).
+		WithArgs(tUser, sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec(`DELETE FROM \[auth]\.\[PasswordHistory] WHERE \[UserID] = \? AND \[ID] NOT IN \(SELECT TOP 5 \[ID] FROM \[auth]\.\[PasswordHistory] WHERE \[UserID] = \? ORDER BY \[Created] DESC\)`).
+		WithArgs(tUser, tUser).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	hc := new(HistoryClient)
+	hc.Record(tUser, tPassword, db)
+	assert.Nil(t, hc.Err())
+
+	if err = mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Expectations were not met. ERROR: %v\n", err)
+	}
+}
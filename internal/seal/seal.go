@@ -0,0 +1,61 @@
+// Package seal encrypts the secrets Auth-Service keeps at rest, such as TOTP secrets and the secrets of signing
+// keys, with AES-GCM. Each kind of secret has its own base64 encoded AES-256 key, read from its own environment
+// variable, so that the error about an invalid key can name that variable.
+package seal
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+)
+
+var (
+	ErrorCiphertextInvalid = errors.New("Sealed secret is invalid.")
+)
+
+// gcm returns the AES-GCM cipher of key, the base64 encoded key in the environment variable env.
+func gcm(key, env string) (cipher.AEAD, error) {
+	k, err := base64.StdEncoding.DecodeString(key)
+	if err != nil || len(k) != 32 {
+		return nil, fmt.Errorf("%s must be a base64 encoded 32 byte key.", env)
+	}
+	block, err := aes.NewCipher(k)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// Seal encrypts plaintext with key, read from the environment variable env, binding it to ad. The nonce is
+// prepended to the result.
+func Seal(key, env string, plaintext, ad []byte) ([]byte, error) {
+	aead, err := gcm(key, env)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, plaintext, ad), nil
+}
+
+// Open decrypts ciphertext produced by Seal with the same key and ad.
+func Open(key, env string, ciphertext, ad []byte) ([]byte, error) {
+	aead, err := gcm(key, env)
+	if err != nil {
+		return nil, err
+	}
+	if len(ciphertext) < aead.NonceSize() {
+		return nil, ErrorCiphertextInvalid
+	}
+	nonce, sealed := ciphertext[:aead.NonceSize()], ciphertext[aead.NonceSize():]
+	plaintext, err := aead.Open(nil, nonce, sealed, ad)
+	if err != nil {
+		return nil, ErrorCiphertextInvalid
+	}
+	return plaintext, nil
+}
@@ -0,0 +1,42 @@
+package seal
+
+import (
+	"encoding/base64"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+var (
+	tKey    = base64.StdEncoding.EncodeToString([]byte("0123456789abcdef0123456789abcdef"))
+	tEnv    = "TestSecretKey"
+	tSecret = []byte("12345678901234567890")
+	tAD     = []byte("testuser")
+)
+
+func Test_Seal(t *testing.T) {
+	sealed, err := Seal(tKey, tEnv, tSecret, tAD)
+	assert.Nil(t, err)
+	assert.NotContains(t, string(sealed), string(tSecret))
+
+	plain, err := Open(tKey, tEnv, sealed, tAD)
+	assert.Nil(t, err)
+	assert.Equal(t, tSecret, plain)
+
+	_, err = Open(tKey, tEnv, sealed, []byte("otheruser"))
+	assert.Equal(t, ErrorCiphertextInvalid, err)
+
+	_, err = Open(tKey, tEnv, sealed[:4], tAD)
+	assert.Equal(t, ErrorCiphertextInvalid, err)
+
+	other := base64.StdEncoding.EncodeToString([]byte("abcdef0123456789abcdef0123456789"))
+	_, err = Open(other, tEnv, sealed, tAD)
+	assert.Equal(t, ErrorCiphertextInvalid, err)
+}
+
+func Test_gcm(t *testing.T) {
+	_, err := Seal("short", tEnv, tSecret, nil)
+	assert.EqualError(t, err, "TestSecretKey must be a base64 encoded 32 byte key.")
+
+	_, err = Open("", tEnv, nil, nil)
+	assert.EqualError(t, err, "TestSecretKey must be a base64 encoded 32 byte key.")
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/penutty/authservice/pat"
+	"github.com/penutty/authservice/serviceaccount"
+	"github.com/penutty/authservice/user"
+	"github.com/penutty/authservice/verification"
+	"net/http"
+	"strings"
+)
+
+// Token types of personal access tokens and API keys of service accounts in introspection responses.
+const (
+	TokenTypePersonalAccessToken = "personal_access_token"
+	TokenTypeAPIKey              = "api_key"
+)
+
+var (
+	ErrorTokenMissing = errors.New("Form value \"token\" is required.")
+)
+
+// introspectionHandler implements RFC 7662 token introspection for access tokens, personal access tokens and
+// API keys of service accounts.
+// Callers authenticate as exchange clients with HTTP Basic authentication and only learn about tokens of their tenant.
+func (a *app) introspectionHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		logger(Error).Println(ErrorMethodNotImplemented)
+		http.Error(w, http.StatusText(http.StatusNotImplemented), http.StatusNotImplemented)
+		return
+	}
+
+	res, err := a.postIntrospection(r)
+	if err != nil {
+		tokenErrorHandler(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	if err := json.NewEncoder(w).Encode(res); err != nil {
+		logger(Error).Println(err)
+	}
+}
+
+// postIntrospection returns the introspection response about the "token" form value. Tokens that are invalid,
+// expired, revoked, of another tenant than the client or of a user who may not log in are inactive.
+func (a *app) postIntrospection(r *http.Request) (*verification.Introspection, error) {
+	if err := r.ParseForm(); err != nil {
+		return nil, err
+	}
+	client, err := a.authenticateClient(r)
+	if err != nil {
+		return nil, err
+	}
+	token := r.PostForm.Get("token")
+	if token == "" {
+		return nil, ErrorTokenMissing
+	}
+
+	var res *verification.Introspection
+	switch {
+	case strings.HasPrefix(token, pat.Prefix):
+		res, err = a.introspectPAT(token)
+	case strings.HasPrefix(token, serviceaccount.APIKeyPrefix):
+		res, err = a.introspectAPIKey(token)
+	default:
+		res, err = a.introspectJwt(token)
+	}
+	if err != nil {
+		logger(Warn).Println(err)
+		return &verification.Introspection{}, nil
+	}
+	if res.Tenant != client.TenantID() {
+		logger(Warn).Println(ErrorTenantMismatch)
+		return &verification.Introspection{}, nil
+	}
+	return res, nil
+}
+
+// introspectPAT returns the introspection response about an active personal access token.
+func (a *app) introspectPAT(token string) (*verification.Introspection, error) {
+	u, t, err := a.personalAccessToken(token)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkStatus(u); err != nil {
+		return nil, err
+	}
+	res := &verification.Introspection{
+		Active:    true,
+		Scope:     strings.Join(t.Scopes, " "),
+		Username:  u.UserID(),
+		TokenType: TokenTypePersonalAccessToken,
+		Iat:       t.Created.Unix(),
+		Sub:       u.ID(),
+		Iss:       verification.IssuerFor(u.TenantID()),
+		Tenant:    u.TenantID(),
+	}
+	if !t.Expires.IsZero() {
+		res.Exp = t.Expires.Unix()
+	}
+	return res, nil
+}
+
+// introspectAPIKey returns the introspection response about an active API key of a service account.
+func (a *app) introspectAPIKey(key string) (*verification.Introspection, error) {
+	acct, err := a.sa.AuthenticateAPIKey(key, user.AuthDB())
+	if err != nil {
+		return nil, err
+	}
+	return &verification.Introspection{
+		Active:    true,
+		Scope:     strings.Join(acct.Scopes, " "),
+		ClientID:  acct.ID,
+		Username:  acct.Name,
+		TokenType: TokenTypeAPIKey,
+		Sub:       acct.ID,
+		Iss:       verification.IssuerFor(acct.TenantID),
+		Tenant:    acct.TenantID,
+	}, nil
+}
+
+// introspectJwt returns the introspection response about an active access token issued by Auth-Service to
+// a user or a service account, for Moment-Service or exchanged for another audience, which is reported in
+// "aud". Tokens issued for a purpose are not access tokens.
+func (a *app) introspectJwt(token string) (*verification.Introspection, error) {
+	claims, err := verification.Parse(token)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := claims["typ"]; ok {
+		return nil, ErrorTokenPurpose
+	}
+	res := &verification.Introspection{Active: true, TokenType: "Bearer", Aud: claims["aud"]}
+	res.Scope, _ = claims["scope"].(string)
+	res.ClientID, _ = claims["client_id"].(string)
+	if exp, ok := claims["exp"].(float64); ok {
+		res.Exp = int64(exp)
+	}
+	if iat, ok := claims["iat"].(float64); ok {
+		res.Iat = int64(iat)
+	}
+
+	if verification.ServiceAccount(claims) {
+		sub, _ := claims["sub"].(string)
+		acct, err := a.activeServiceAccount(sub)
+		if err != nil {
+			return nil, err
+		}
+		if verification.Tenant(claims) != acct.TenantID {
+			return nil, ErrorTenantMismatch
+		}
+		res.Sub, res.Username, res.Tenant = acct.ID, acct.Name, acct.TenantID
+	} else {
+		u, err := a.subject(claims)
+		if err != nil {
+			return nil, err
+		}
+		if err := checkStatus(u); err != nil {
+			return nil, err
+		}
+		res.Sub, res.Username, res.Tenant = u.ID(), u.UserID(), u.TenantID()
+	}
+	res.Iss = verification.IssuerFor(res.Tenant)
+	return res, nil
+}
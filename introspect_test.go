@@ -0,0 +1,122 @@
+package main
+
+import (
+	"encoding/json"
+	"github.com/dgrijalva/jwt-go"
+	"github.com/penutty/authservice/user"
+	"github.com/penutty/authservice/verification"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func NewIntrospectionRequest(clientID, secret, token string) *http.Request {
+	r := httptest.NewRequest(http.MethodPost, IntrospectionEndpoint, strings.NewReader(url.Values{"token": {token}}.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if clientID != "" {
+		r.SetBasicAuth(clientID, secret)
+	}
+	return r
+}
+
+func Test_introspectionHandler(t *testing.T) {
+	access, err := generateJwt(tID, nil, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	purpose, err := generateJwt(tID, nil, nil, jwt.MapClaims{"typ": "login_link"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	exchanged, err := signJwt(jwt.MapClaims{"iss": verification.IssuerFor(""), "sub": tID, "aud": tAudience, "exp": time.Now().UTC().Add(time.Minute).Unix()})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	type test struct {
+		secret string
+		token  func(secret string) string
+		tenant string
+		status user.Status
+		code   int
+		active bool
+	}
+	issued := func(s string) string { return s }
+	cases := []*test{
+		&test{tSecret, func(string) string { return access }, "", "", http.StatusOK, true},
+		&test{tSecret, issued, "", "", http.StatusOK, true},
+		&test{tSecret, func(s string) string { return s[:len(s)-1] + "x" }, "", "", http.StatusOK, false},
+		&test{tSecret, func(string) string { return "not.a.token" }, "", "", http.StatusOK, false},
+		&test{tSecret, func(string) string { return purpose }, "", "", http.StatusOK, false},
+		&test{tSecret, func(string) string { return exchanged }, "", "", http.StatusOK, true},
+		&test{tSecret, issued, tTenant, "", http.StatusOK, false},
+		&test{tSecret, func(string) string { return access }, tTenant, "", http.StatusOK, false},
+		&test{tSecret, issued, "", user.StatusDisabled, http.StatusOK, false},
+		&test{tSecret, func(string) string { return "" }, "", "", http.StatusBadRequest, false},
+		&test{"wrong", issued, "", "", http.StatusUnauthorized, false},
+	}
+	for i, c := range cases {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			a, _, secret := newPATApp()
+			a.x = &MockExchangeClient{tenantID: c.tenant}
+			a.c.(*MockUserClient).status = c.status
+			rec := httptest.NewRecorder()
+			a.introspectionHandler(rec, NewIntrospectionRequest(tClientID, c.secret, c.token(secret)))
+			assert.Equal(t, c.code, rec.Code)
+			if c.code != http.StatusOK {
+				return
+			}
+
+			res := new(verification.Introspection)
+			assert.Nil(t, json.NewDecoder(rec.Body).Decode(res))
+			assert.Equal(t, c.active, res.Active)
+			if !c.active {
+				assert.Equal(t, &verification.Introspection{}, res)
+				return
+			}
+			assert.Equal(t, tID, res.Sub)
+			assert.Equal(t, tUser, res.Username)
+			assert.Equal(t, "no-store", rec.Header().Get("Cache-Control"))
+		})
+	}
+
+	t.Run(strconv.Itoa(len(cases)), func(t *testing.T) {
+		a, pt, secret := newPATApp()
+		a.x = new(MockExchangeClient)
+		expires := time.Now().UTC().Add(time.Hour)
+		pt.tokens[secret].Expires = expires
+		rec := httptest.NewRecorder()
+		a.introspectionHandler(rec, NewIntrospectionRequest(tClientID, tSecret, secret))
+
+		res := new(verification.Introspection)
+		assert.Nil(t, json.NewDecoder(rec.Body).Decode(res))
+		assert.Equal(t, TokenTypePersonalAccessToken, res.TokenType)
+		assert.Equal(t, []string{"repo:read"}, res.Scopes())
+		assert.Equal(t, expires.Unix(), res.Exp)
+		assert.Nil(t, res.Aud)
+	})
+
+	t.Run(strconv.Itoa(len(cases)+1), func(t *testing.T) {
+		a, _, _ := newPATApp()
+		a.x = new(MockExchangeClient)
+		rec := httptest.NewRecorder()
+		a.introspectionHandler(rec, NewIntrospectionRequest(tClientID, tSecret, exchanged))
+
+		res := new(verification.Introspection)
+		assert.Nil(t, json.NewDecoder(rec.Body).Decode(res))
+		assert.True(t, res.Active)
+		assert.Equal(t, tAudience, res.Aud)
+	})
+
+	t.Run(strconv.Itoa(len(cases)+2), func(t *testing.T) {
+		a, _, _ := newPATApp()
+		rec := httptest.NewRecorder()
+		a.introspectionHandler(rec, httptest.NewRequest(http.MethodGet, IntrospectionEndpoint, nil))
+		assert.Equal(t, http.StatusNotImplemented, rec.Code)
+	})
+}
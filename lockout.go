@@ -0,0 +1,140 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/penutty/authservice/lockout"
+	"github.com/penutty/authservice/user"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+var (
+	ErrorLoginDelayed  = errors.New("Too many failed login attempts; retry later.")
+	ErrorAccountLocked = errors.New("Account is temporarily locked after too many failed login attempts.")
+)
+
+// throttledError is ErrorLoginDelayed or ErrorAccountLocked with the time the next attempt is allowed.
+type throttledError struct {
+	err   error
+	until time.Time
+}
+
+func (e *throttledError) Error() string {
+	return e.err.Error()
+}
+
+// setRetryAfter sets the Retry-After header of w to the seconds until until.
+func setRetryAfter(w http.ResponseWriter, until time.Time) {
+	s := int(math.Ceil(time.Until(until).Seconds()))
+	if s < 1 {
+		s = 1
+	}
+	w.Header().Set("Retry-After", strconv.Itoa(s))
+}
+
+// clientIP returns the address of the client sending r.
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// checkAttempts returns a throttledError if failed logins of UserID or from r delay or lock this attempt.
+func (a *app) checkAttempts(UserID string, r *http.Request) error {
+	keys := map[string]lockout.Policy{lockout.IPKey(clientIP(r)): lockout.IPPolicy}
+	if user.CheckUserID(UserID) == nil {
+		keys[lockout.UserKey(UserID)] = lockout.UserPolicy
+	}
+
+	now := time.Now().UTC()
+	for key, p := range keys {
+		att := a.l.Fetch(key, user.AuthDB())
+		if err := a.l.Err(); err != nil {
+			return err
+		}
+		if until := att.Until(p); until.After(now) {
+			if att.Locked(now) {
+				return &throttledError{ErrorAccountLocked, until}
+			}
+			return &throttledError{ErrorLoginDelayed, until}
+		}
+	}
+	return nil
+}
+
+// failAttempt records a failed login of UserID from r. If it locks u, u is notified by email.
+func (a *app) failAttempt(UserID string, u *user.User, r *http.Request) {
+	if _, err := a.l.Fail(lockout.IPKey(clientIP(r)), lockout.IPPolicy, user.AuthDB()); err != nil {
+		logger(Error).Println(err)
+	}
+	if user.CheckUserID(UserID) != nil {
+		return
+	}
+	att, err := a.l.Fail(lockout.UserKey(UserID), lockout.UserPolicy, user.AuthDB())
+	if err != nil {
+		logger(Error).Println(err)
+		return
+	}
+	if u == nil || !att.Locked(time.Now().UTC()) {
+		return
+	}
+
+	data := map[string]interface{}{
+		"Minutes": int(lockout.UserPolicy.Duration.Minutes()),
+		"IP":      clientIP(r),
+	}
+	m, err := a.tmpl.Render("lockout_notice", u.Locale(), data)
+	if err != nil {
+		logger(Error).Println(err)
+		return
+	}
+	m.To = u.Email()
+	if err := a.mail.Send(m); err != nil {
+		logger(Error).Println(err)
+	}
+}
+
+func (a *app) unlockHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodPost:
+		if err := a.postUnlock(r); err != nil {
+			genErrorHandler(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		logger(Error).Println(ErrorMethodNotImplemented)
+		http.Error(w, http.StatusText(http.StatusNotImplemented), http.StatusNotImplemented)
+	}
+}
+
+// postUnlock lets an administrator forget the failed logins of a user, unlocking it.
+func (a *app) postUnlock(r *http.Request) error {
+	caller, err := a.authenticateLogin(r)
+	if err != nil {
+		return err
+	}
+	if !isAdministrator(caller.ID()) {
+		return ErrorUserForbidden
+	}
+
+	type body struct {
+		UserID string
+	}
+	b := new(body)
+	if err := json.NewDecoder(r.Body).Decode(b); err != nil {
+		return err
+	}
+	if err := user.CheckUserID(b.UserID); err != nil {
+		return err
+	}
+
+	a.l.Reset(lockout.UserKey(user.NormalizeUserID(qualify(r, b.UserID))), user.AuthDB())
+	return a.l.Err()
+}
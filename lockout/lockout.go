@@ -0,0 +1,231 @@
+// Package lockout is dedicated to reading and writing failed login attempts in Auth-Db.
+// Failures are counted per key, e.g. a UserID or a source IP address. Every failure delays the next
+// attempt exponentially and reaching the threshold of a Policy locks the key for a while. Counters
+// decay once no failure was recorded for the cool-down of the Policy.
+package lockout
+
+import (
+	"database/sql"
+	"errors"
+	sq "github.com/Masterminds/squirrel"
+	"github.com/penutty/authservice/env"
+	"log"
+	"time"
+)
+
+// Policy configures how failures of one kind of key are treated.
+type Policy struct {
+	// Threshold is the number of failures that locks a key.
+	Threshold int
+	// Duration is how long a key stays locked.
+	Duration time.Duration
+	// BaseDelay is the delay after the first failure; it doubles with every further failure up to MaxDelay.
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+	// CoolDown is the time without failures after which the counter of a key starts over.
+	CoolDown time.Duration
+}
+
+var (
+	UserPolicy = Policy{
+		Threshold: env.PositiveInt("LockoutThreshold", 10),
+		Duration:  env.PositiveDuration("LockoutDuration", 15*time.Minute),
+		BaseDelay: time.Second,
+		MaxDelay:  30 * time.Second,
+		CoolDown:  env.PositiveDuration("LockoutCoolDown", time.Hour),
+	}
+	IPPolicy = Policy{
+		Threshold: env.PositiveInt("IPLockoutThreshold", 100),
+		Duration:  env.PositiveDuration("LockoutDuration", 15*time.Minute),
+		BaseDelay: 0,
+		MaxDelay:  0,
+		CoolDown:  env.PositiveDuration("LockoutCoolDown", time.Hour),
+	}
+
+	ErrorKeyParameterInvalid = errors.New("Attempts.key must not be empty.")
+)
+
+// UserKey returns the key counting failures of userID.
+func UserKey(userID string) string {
+	return "user:" + userID
+}
+
+// IPKey returns the key counting failures from ip.
+func IPKey(ip string) string {
+	return "ip:" + ip
+}
+
+// ChallengeKey returns the key counting failures against the MFA challenge with ID id.
+func ChallengeKey(id string) string {
+	return "challenge:" + id
+}
+
+type Client interface {
+	Fetcher
+	Failer
+	Resetter
+	Err() error
+}
+
+type Fetcher interface {
+	Fetch(string, sq.BaseRunner) *Attempts
+}
+
+type Failer interface {
+	Fail(string, Policy, sq.BaseRunner) (*Attempts, error)
+}
+
+type Resetter interface {
+	Reset(string, sq.BaseRunner)
+}
+
+type AttemptsClient struct {
+	err error
+}
+
+// Attempts are the recorded login failures of a key.
+type Attempts struct {
+	Failures    int
+	LastFailure time.Time
+	LockedUntil time.Time
+}
+
+// Delay returns the delay before the attempt following failures failures.
+func (p Policy) Delay(failures int) time.Duration {
+	if failures <= 0 || p.BaseDelay <= 0 {
+		return 0
+	}
+	d := p.BaseDelay
+	for i := 1; i < failures && d < p.MaxDelay; i++ {
+		d *= 2
+	}
+	if d > p.MaxDelay {
+		d = p.MaxDelay
+	}
+	return d
+}
+
+// Locked reports whether the key is locked at now.
+func (a *Attempts) Locked(now time.Time) bool {
+	return now.Before(a.LockedUntil)
+}
+
+// Until returns the time before which the next attempt under p is refused.
+func (a *Attempts) Until(p Policy) time.Time {
+	until := a.LastFailure.Add(p.Delay(a.Failures))
+	if a.LockedUntil.After(until) {
+		until = a.LockedUntil
+	}
+	return until
+}
+
+// Fetch returns the attempts recorded for key. A key without failures has zero Attempts.
+func (ac *AttemptsClient) Fetch(key string, db sq.BaseRunner) (a *Attempts) {
+	if ac.err != nil {
+		return
+	}
+	if key == "" {
+		ac.err = ErrorKeyParameterInvalid
+		return
+	}
+
+	a = new(Attempts)
+	var locked *time.Time
+	sel := sq.Select("[Failures], [LastFailure], [LockedUntil]").From("[auth].[LoginFailures]").Where(sq.Eq{"[Key]": key})
+	err := sel.RunWith(db).QueryRow().Scan(&a.Failures, &a.LastFailure, &locked)
+	if err == sql.ErrNoRows {
+		return
+	}
+	if err != nil {
+		log.Print(err)
+		ac.err = err
+		return
+	}
+	if locked != nil {
+		a.LockedUntil = *locked
+	}
+	return
+}
+
+// failures is the number of failures of the row merged by failMerge, counting the one being recorded.
+const failures = "CASE WHEN [t].[LastFailure] < ? THEN 1 ELSE [t].[Failures] + 1 END"
+
+// failMerge records a failure in a single statement, so that concurrent failures of a key are all counted and
+// the first ones do not race to insert its row. HOLDLOCK keeps the key locked from the match to the insert.
+const failMerge = `MERGE [auth].[LoginFailures] WITH (HOLDLOCK) AS [t]
+USING (SELECT ? AS [Key]) AS [s] ON [t].[Key] = [s].[Key]
+WHEN MATCHED THEN UPDATE SET
+	[Failures] = CASE WHEN ` + failures + ` >= ? THEN 0 ELSE ` + failures + ` END,
+	[LastFailure] = ?,
+	[LockedUntil] = CASE WHEN ` + failures + ` >= ? THEN ? ELSE [t].[LockedUntil] END
+WHEN NOT MATCHED THEN
+	INSERT ([Key], [Failures], [LastFailure], [LockedUntil]) VALUES (?, ?, ?, ?)
+OUTPUT [inserted].[Failures], [inserted].[LastFailure], [inserted].[LockedUntil];`
+
+// Fail records a failure of key under p and returns the resulting attempts.
+// Reaching p.Threshold locks the key for p.Duration and starts the counter over.
+func (ac *AttemptsClient) Fail(key string, p Policy, db sq.BaseRunner) (*Attempts, error) {
+	if ac.err != nil {
+		return nil, ac.err
+	}
+	if key == "" {
+		return nil, ErrorKeyParameterInvalid
+	}
+
+	now := time.Now().UTC()
+	decayed := now.Add(-p.CoolDown)
+	lockedUntil := now.Add(p.Duration)
+	// The values of a first failure, inserted if key has no row yet.
+	var firstLocked interface{}
+	first := 1
+	if first >= p.Threshold {
+		first, firstLocked = 0, lockedUntil
+	}
+	rows, err := db.Query(failMerge,
+		key,
+		decayed, p.Threshold, decayed,
+		now,
+		decayed, p.Threshold, lockedUntil,
+		key, first, now, firstLocked)
+	if err != nil {
+		log.Print(err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	if !rows.Next() {
+		if err = rows.Err(); err == nil {
+			err = sql.ErrNoRows
+		}
+		log.Print(err)
+		return nil, err
+	}
+	a := new(Attempts)
+	var locked *time.Time
+	if err := rows.Scan(&a.Failures, &a.LastFailure, &locked); err != nil {
+		log.Print(err)
+		return nil, err
+	}
+	if locked != nil {
+		a.LockedUntil = *locked
+	}
+	return a, nil
+}
+
+// Reset forgets the failures of key, unlocking it.
+func (ac *AttemptsClient) Reset(key string, db sq.BaseRunner) {
+	if ac.err != nil {
+		return
+	}
+
+	del := sq.Delete("[auth].[LoginFailures]").Where(sq.Eq{"[Key]": key})
+	if _, err := del.RunWith(db).Exec(); err != nil {
+		log.Print(err)
+		ac.err = err
+	}
+}
+
+// Err returns the error status of an AttemptsClient instance.
+func (ac *AttemptsClient) Err() error {
+	return ac.err
+}
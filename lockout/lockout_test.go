@@ -0,0 +1,163 @@
+package lockout
+
+import (
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/DATA-DOG/go-sqlmock.v1"
+	"strconv"
+	"testing"
+	"time"
+)
+
+var (
+	tKey    = UserKey("testuser")
+	tPolicy = Policy{Threshold: 3, Duration: 15 * time.Minute, BaseDelay: time.Second, MaxDelay: 8 * time.Second, CoolDown: time.Hour}
+
+	columns = []string{"Failures", "LastFailure", "LockedUntil"}
+	sel     = `SELECT \[Failures], \[LastFailure], \[LockedUntil] FROM \[auth]\.\[LoginFailures] WHERE \[Key] = \?`
+)
+
+func Test_Delay(t *testing.T) {
+	cases := []struct {
+		failures int
+		delay    time.Duration
+	}{
+		{0, 0},
+		{1, time.Second},
+		{2, 2 * time.Second},
+		{4, 8 * time.Second},
+		{10, 8 * time.Second},
+	}
+	for i, c := range cases {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			assert.Equal(t, c.delay, tPolicy.Delay(c.failures))
+		})
+	}
+	assert.Equal(t, time.Duration(0), IPPolicy.Delay(5))
+}
+
+func Test_Until(t *testing.T) {
+	now := time.Now().UTC()
+	a := &Attempts{Failures: 2, LastFailure: now}
+	assert.Equal(t, now.Add(2*time.Second), a.Until(tPolicy))
+	assert.False(t, a.Locked(now))
+
+	a.LockedUntil = now.Add(time.Minute)
+	assert.Equal(t, now.Add(time.Minute), a.Until(tPolicy))
+	assert.True(t, a.Locked(now))
+}
+
+func Test_Fetch(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("An error occured when opening a stub database connection. ERROR: %v\n", err)
+	}
+	defer db.Close()
+
+	t.Run("1", func(t *testing.T) {
+		last := time.Now().UTC()
+		mock.ExpectQuery(sel).WithArgs(tKey).WillReturnRows(sqlmock.NewRows(columns).AddRow(2, last, nil))
+
+		ac := new(AttemptsClient)
+		a := ac.Fetch(tKey, db)
+		assert.Nil(t, ac.Err())
+		assert.Equal(t, 2, a.Failures)
+		assert.True(t, a.LockedUntil.IsZero())
+
+		if err = mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("Expectations were not met. ERROR: %v\n", err)
+		}
+	})
+
+	t.Run("2", func(t *testing.T) {
+		mock.ExpectQuery(sel).WithArgs(tKey).WillReturnRows(sqlmock.NewRows(columns))
+
+		ac := new(AttemptsClient)
+		a := ac.Fetch(tKey, db)
+		assert.Nil(t, ac.Err())
+		assert.Equal(t, 0, a.Failures)
+	})
+
+	t.Run("3", func(t *testing.T) {
+		ac := new(AttemptsClient)
+		_ = ac.Fetch("", db)
+		assert.EqualError(t, ac.Err(), ErrorKeyParameterInvalid.Error())
+	})
+}
+
+func Test_Fail(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("An error occured when opening a stub database connection. ERROR: %v\n", err)
+	}
+	defer db.Close()
+
+	merge := `MERGE \[auth]\.\[LoginFailures] WITH \(HOLDLOCK\) AS \[t].+OUTPUT \[inserted]\.\[Failures], \[inserted]\.\[LastFailure], \[inserted]\.\[LockedUntil];`
+	now := time.Now().UTC()
+
+	cases := []struct {
+		policy   Policy
+		failures int
+		locked   bool
+		first    int
+	}{
+		{tPolicy, 1, false, 1},
+		{tPolicy, 0, true, 1},
+		{Policy{Threshold: 1, Duration: time.Minute}, 0, true, 0},
+	}
+	for i, c := range cases {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			var locked interface{}
+			if c.locked {
+				locked = now.Add(c.policy.Duration)
+			}
+			mock.ExpectQuery(merge).
+				WithArgs(tKey,
+					sqlmock.AnyArg(), c.policy.Threshold, sqlmock.AnyArg(),
+					sqlmock.AnyArg(),
+					sqlmock.AnyArg(), c.policy.Threshold, sqlmock.AnyArg(),
+					tKey, c.first, sqlmock.AnyArg(), sqlmock.AnyArg()).
+				WillReturnRows(sqlmock.NewRows(columns).AddRow(c.failures, now, locked))
+
+			ac := new(AttemptsClient)
+			a, err := ac.Fail(tKey, c.policy, db)
+			assert.Nil(t, err)
+			assert.Equal(t, c.failures, a.Failures)
+			assert.Equal(t, c.locked, a.Locked(time.Now()))
+
+			if err = mock.ExpectationsWereMet(); err != nil {
+				t.Errorf("Expectations were not met. ERROR: %v\n", err)
+			}
+		})
+	}
+
+	t.Run("error", func(t *testing.T) {
+		mock.ExpectQuery(merge).WillReturnError(errors.New("deadlock"))
+
+		ac := new(AttemptsClient)
+		_, err := ac.Fail(tKey, tPolicy, db)
+		assert.EqualError(t, err, "deadlock")
+		assert.Nil(t, ac.Err())
+	})
+
+	_, err = new(AttemptsClient).Fail("", tPolicy, db)
+	assert.Equal(t, ErrorKeyParameterInvalid, err)
+}
+
+func Test_Reset(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("An error occured when opening a stub database connection. ERROR: %v\n", err)
+	}
+	defer db.Close()
+
+	mock.ExpectExec(`DELETE FROM \[auth]\.\[LoginFailures] WHERE \[Key] = \?`).WithArgs(tKey).WillReturnResult(sqlmock.NewResult(0, 1))
+
+	ac := new(AttemptsClient)
+	ac.Reset(tKey, db)
+	assert.Nil(t, ac.Err())
+
+	if err = mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Expectations were not met. ERROR: %v\n", err)
+	}
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"fmt"
+	sq "github.com/Masterminds/squirrel"
+	"github.com/penutty/authservice/lockout"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+type MockLockoutClient struct {
+	err      error
+	attempts map[string]lockout.Attempts
+}
+
+func NewMockLockoutClient() *MockLockoutClient {
+	return &MockLockoutClient{attempts: make(map[string]lockout.Attempts)}
+}
+
+func (m *MockLockoutClient) Fetch(key string, db sq.BaseRunner) *lockout.Attempts {
+	a := m.attempts[key]
+	return &a
+}
+
+func (m *MockLockoutClient) Fail(key string, p lockout.Policy, db sq.BaseRunner) (*lockout.Attempts, error) {
+	a := m.attempts[key]
+	now := time.Now().UTC()
+	a.Failures++
+	a.LastFailure = now
+	if a.Failures >= p.Threshold {
+		a.Failures = 0
+		a.LockedUntil = now.Add(p.Duration)
+	}
+	m.attempts[key] = a
+	return &a, nil
+}
+
+func (m *MockLockoutClient) Reset(key string, db sq.BaseRunner) {
+	delete(m.attempts, key)
+}
+
+func (m *MockLockoutClient) Err() error {
+	return m.err
+}
+
+func Test_authHandler_delay(t *testing.T) {
+	a, _, _ := newVerifyApp()
+
+	rec := httptest.NewRecorder()
+	a.authHandler(rec, httptest.NewRequest(http.MethodPost, AuthEndpoint, NewAuthBody(tUser, "WrongPassword1!")))
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	rec = httptest.NewRecorder()
+	a.authHandler(rec, httptest.NewRequest(http.MethodPost, AuthEndpoint, NewAuthBody(tUser, tPassword)))
+	assert.Equal(t, http.StatusTooManyRequests, rec.Code)
+	assert.Equal(t, "1", rec.Header().Get("Retry-After"))
+}
+
+func Test_authHandler_lockout(t *testing.T) {
+	defer func(p lockout.Policy) { lockout.UserPolicy = p }(lockout.UserPolicy)
+	lockout.UserPolicy = lockout.Policy{Threshold: 3, Duration: 15 * time.Minute, CoolDown: time.Hour}
+	defer func(admins []string) { Administrators = admins }(Administrators)
+	Administrators = administrators("adminuser")
+
+	a, _, mail := newVerifyApp()
+
+	codes := []int{http.StatusBadRequest, http.StatusBadRequest, http.StatusBadRequest, http.StatusLocked}
+	for i, code := range codes {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			a.authHandler(rec, httptest.NewRequest(http.MethodPost, AuthEndpoint, NewAuthBody(tUser, "WrongPassword1!")))
+			assert.Equal(t, code, rec.Code)
+		})
+	}
+
+	rec := httptest.NewRecorder()
+	a.authHandler(rec, httptest.NewRequest(http.MethodPost, AuthEndpoint, NewAuthBody(tUser, tPassword)))
+	assert.Equal(t, http.StatusLocked, rec.Code)
+	assert.Equal(t, "900", rec.Header().Get("Retry-After"))
+
+	assert.Len(t, mail.Messages, 1)
+	assert.Equal(t, tEmail, mail.Last().To)
+	assert.Contains(t, mail.Last().Text, "192.0.2.1")
+
+	body := func() *strings.Reader {
+		return strings.NewReader(fmt.Sprintf("{\"UserID\": \"%s\"}", tUser))
+	}
+	adminToken, err := generateJwt("id-adminuser", nil, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	admin := httptest.NewRequest(http.MethodPost, UnlockEndpoint, body())
+	admin.Header.Set("Authorization", "Bearer "+adminToken)
+
+	testVars := []*RequestCodePair{
+		&RequestCodePair{NewBearerRequest(http.MethodPost, UnlockEndpoint, body()), http.StatusForbidden},
+		&RequestCodePair{admin, http.StatusNoContent},
+		&RequestCodePair{httptest.NewRequest(http.MethodGet, UnlockEndpoint, nil), http.StatusNotImplemented},
+	}
+	for i, v := range testVars {
+		t.Run("unlock"+strconv.Itoa(i), func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			a.unlockHandler(rec, v.req)
+			assert.Equal(t, v.code, rec.Code)
+		})
+	}
+
+	rec = httptest.NewRecorder()
+	a.authHandler(rec, httptest.NewRequest(http.MethodPost, AuthEndpoint, NewAuthBody(tUser, tPassword)))
+	assert.Equal(t, http.StatusOK, rec.Code)
+}
@@ -0,0 +1,52 @@
+package mailer
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+)
+
+var deliveries uint64
+
+// Maildir writes messages to a Maildir on disk instead of delivering them, for local development.
+// Messages appear in Dir/new and can be read with any Maildir capable mail client.
+type Maildir struct {
+	Dir  string
+	From string
+}
+
+// NewMaildir returns a Maildir driver writing to dir.
+func NewMaildir(dir, from string) *Maildir {
+	if from == "" {
+		from = "Auth-Service <noreply@localhost>"
+	}
+	return &Maildir{Dir: dir, From: from}
+}
+
+// Send writes m to Dir/tmp and moves it into Dir/new once complete.
+func (md *Maildir) Send(m *Message) error {
+	if m.To == "" {
+		return ErrorRecipientMissing
+	}
+	for _, sub := range []string{"tmp", "new", "cur"} {
+		if err := os.MkdirAll(filepath.Join(md.Dir, sub), 0700); err != nil {
+			return err
+		}
+	}
+
+	msg, err := m.bytes(md.From)
+	if err != nil {
+		return err
+	}
+
+	host, _ := os.Hostname()
+	name := fmt.Sprintf("%d.P%dQ%d.%s", time.Now().Unix(), os.Getpid(), atomic.AddUint64(&deliveries, 1), host)
+	tmp := filepath.Join(md.Dir, "tmp", name)
+	if err := ioutil.WriteFile(tmp, msg, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, filepath.Join(md.Dir, "new", name))
+}
@@ -0,0 +1,32 @@
+package mailer
+
+import (
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func Test_Maildir_Send(t *testing.T) {
+	dir, err := ioutil.TempDir("", "maildir")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	md := NewMaildir(dir, "")
+	assert.Nil(t, md.Send(&Message{To: tEmail, Subject: "First", Text: "Body"}))
+	assert.Nil(t, md.Send(&Message{To: tEmail, Subject: "Second", Text: "Body"}))
+	assert.EqualError(t, md.Send(new(Message)), ErrorRecipientMissing.Error())
+
+	tmp, _ := ioutil.ReadDir(filepath.Join(dir, "tmp"))
+	assert.Len(t, tmp, 0)
+	delivered, _ := ioutil.ReadDir(filepath.Join(dir, "new"))
+	assert.Len(t, delivered, 2)
+
+	b, err := ioutil.ReadFile(filepath.Join(dir, "new", delivered[0].Name()))
+	assert.Nil(t, err)
+	assert.True(t, strings.HasPrefix(string(b), "From: Auth-Service <noreply@localhost>\r\nTo: testemail@email.com\r\n"))
+}
@@ -0,0 +1,122 @@
+// Package mailer delivers outbound email for Auth-Service.
+// Drivers implement the Mailer interface so that flows sending email do not depend on how it is delivered:
+// SMTP relays through a mail server, Maildir writes messages to disk for local development and Memory
+// records them for tests. Queue wraps a driver to send asynchronously with retries persisted in Auth-Db.
+// Message bodies are rendered from per-locale templates, see template.go.
+package mailer
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/textproto"
+	"os"
+	"strings"
+	"time"
+)
+
+var (
+	ErrorRecipientMissing = errors.New("Message.To must contain a recipient.")
+	ErrorSenderMissing    = errors.New("Mailer sender address is not configured.")
+	ErrorDriverInvalid    = errors.New("MailDriver must be \"smtp\", \"maildir\" or \"memory\".")
+)
+
+// Message is an email to a single recipient. To may be any RFC 5322 address, e.g. "<user@example.com>".
+// HTML is optional; when present the message is sent as multipart/alternative.
+type Message struct {
+	To      string
+	Subject string
+	Text    string
+	HTML    string
+}
+
+type Mailer interface {
+	Send(*Message) error
+}
+
+// NewDriver returns the driver named by the MailDriver environment variable, defaulting to SMTP.
+func NewDriver() (Mailer, error) {
+	switch os.Getenv("MailDriver") {
+	case "", "smtp":
+		return NewSMTP(), nil
+	case "maildir":
+		return NewMaildir(os.Getenv("MailDir"), os.Getenv("SMTPFrom")), nil
+	case "memory":
+		return new(Memory), nil
+	}
+	return nil, ErrorDriverInvalid
+}
+
+// bytes returns m formatted as an RFC 5322 message from from.
+func (m *Message) bytes(from string) ([]byte, error) {
+	b := new(bytes.Buffer)
+	fmt.Fprintf(b, "From: %s\r\n", from)
+	fmt.Fprintf(b, "To: %s\r\n", m.To)
+	fmt.Fprintf(b, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", m.Subject))
+	fmt.Fprintf(b, "Date: %s\r\n", time.Now().UTC().Format(time.RFC1123Z))
+	fmt.Fprintf(b, "Message-ID: <%s@%s>\r\n", messageID(), domain(from))
+	fmt.Fprintf(b, "MIME-Version: 1.0\r\n")
+
+	if m.HTML == "" {
+		fmt.Fprintf(b, "Content-Type: text/plain; charset=UTF-8\r\n")
+		fmt.Fprintf(b, "Content-Transfer-Encoding: quoted-printable\r\n\r\n")
+		if err := writeQuotedPrintable(b, m.Text); err != nil {
+			return nil, err
+		}
+		return b.Bytes(), nil
+	}
+
+	mw := multipart.NewWriter(b)
+	fmt.Fprintf(b, "Content-Type: multipart/alternative; boundary=%s\r\n\r\n", mw.Boundary())
+	parts := []struct{ contentType, body string }{
+		{"text/plain; charset=UTF-8", m.Text},
+		{"text/html; charset=UTF-8", m.HTML},
+	}
+	for _, p := range parts {
+		h := textproto.MIMEHeader{}
+		h.Set("Content-Type", p.contentType)
+		h.Set("Content-Transfer-Encoding", "quoted-printable")
+		w, err := mw.CreatePart(h)
+		if err != nil {
+			return nil, err
+		}
+		if err := writeQuotedPrintable(w, p.body); err != nil {
+			return nil, err
+		}
+	}
+	if err := mw.Close(); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+type writer interface {
+	Write([]byte) (int, error)
+}
+
+func writeQuotedPrintable(w writer, s string) error {
+	qp := quotedprintable.NewWriter(w)
+	if _, err := qp.Write([]byte(strings.Replace(s, "\n", "\r\n", -1))); err != nil {
+		return err
+	}
+	return qp.Close()
+}
+
+func messageID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func domain(address string) string {
+	address = strings.TrimRight(address, ">")
+	if i := strings.LastIndex(address, "@"); i >= 0 {
+		return address[i+1:]
+	}
+	return "localhost"
+}
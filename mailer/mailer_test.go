@@ -0,0 +1,76 @@
+package mailer
+
+import (
+	"github.com/stretchr/testify/assert"
+	"mime"
+	"mime/multipart"
+	"net/mail"
+	"os"
+	"strings"
+	"testing"
+)
+
+var tEmail = "testemail@email.com"
+
+func Test_NewDriver(t *testing.T) {
+	defer os.Unsetenv("MailDriver")
+
+	os.Setenv("MailDriver", "memory")
+	d, err := NewDriver()
+	assert.Nil(t, err)
+	assert.IsType(t, new(Memory), d)
+
+	os.Setenv("MailDriver", "maildir")
+	d, err = NewDriver()
+	assert.Nil(t, err)
+	assert.IsType(t, new(Maildir), d)
+
+	os.Setenv("MailDriver", "smtp")
+	d, err = NewDriver()
+	assert.Nil(t, err)
+	assert.IsType(t, new(SMTP), d)
+
+	os.Setenv("MailDriver", "carrier-pigeon")
+	_, err = NewDriver()
+	assert.EqualError(t, err, ErrorDriverInvalid.Error())
+}
+
+func Test_bytes(t *testing.T) {
+	t.Run("text", func(t *testing.T) {
+		m := &Message{To: tEmail, Subject: "Subject", Text: "line 1\nline 2"}
+		b, err := m.bytes("noreply@email.com")
+		assert.Nil(t, err)
+
+		s := string(b)
+		assert.True(t, strings.HasPrefix(s, "From: noreply@email.com\r\nTo: testemail@email.com\r\nSubject: Subject\r\n"))
+		assert.Contains(t, s, "Content-Type: text/plain; charset=UTF-8\r\n")
+		assert.True(t, strings.HasSuffix(s, "\r\n\r\nline 1\r\nline 2"))
+	})
+
+	t.Run("multipart", func(t *testing.T) {
+		m := &Message{To: tEmail, Subject: "Grüße", Text: "Hello", HTML: "<p>Hello</p>"}
+		b, err := m.bytes("noreply@email.com")
+		assert.Nil(t, err)
+
+		msg, err := mail.ReadMessage(strings.NewReader(string(b)))
+		assert.Nil(t, err)
+		subject, err := new(mime.WordDecoder).DecodeHeader(msg.Header.Get("Subject"))
+		assert.Nil(t, err)
+		assert.Equal(t, "Grüße", subject)
+
+		mediaType, params, err := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+		assert.Nil(t, err)
+		assert.Equal(t, "multipart/alternative", mediaType)
+
+		r := multipart.NewReader(msg.Body, params["boundary"])
+		var types []string
+		for {
+			p, err := r.NextPart()
+			if err != nil {
+				break
+			}
+			types = append(types, p.Header.Get("Content-Type"))
+		}
+		assert.Equal(t, []string{"text/plain; charset=UTF-8", "text/html; charset=UTF-8"}, types)
+	})
+}
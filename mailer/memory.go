@@ -0,0 +1,32 @@
+package mailer
+
+import (
+	"sync"
+)
+
+// Memory records messages instead of delivering them. It is intended for tests.
+type Memory struct {
+	mu       sync.Mutex
+	Messages []*Message
+}
+
+// Send records m.
+func (mm *Memory) Send(m *Message) error {
+	if m.To == "" {
+		return ErrorRecipientMissing
+	}
+	mm.mu.Lock()
+	defer mm.mu.Unlock()
+	mm.Messages = append(mm.Messages, m)
+	return nil
+}
+
+// Last returns the most recently sent message, or nil if none was sent.
+func (mm *Memory) Last() *Message {
+	mm.mu.Lock()
+	defer mm.mu.Unlock()
+	if len(mm.Messages) == 0 {
+		return nil
+	}
+	return mm.Messages[len(mm.Messages)-1]
+}
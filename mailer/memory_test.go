@@ -0,0 +1,17 @@
+package mailer
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func Test_Memory(t *testing.T) {
+	m := new(Memory)
+	assert.Nil(t, m.Last())
+
+	assert.Nil(t, m.Send(&Message{To: tEmail, Subject: "Subject", Text: "Body"}))
+	assert.Equal(t, tEmail, m.Last().To)
+	assert.Len(t, m.Messages, 1)
+
+	assert.EqualError(t, m.Send(new(Message)), ErrorRecipientMissing.Error())
+}
@@ -0,0 +1,177 @@
+package mailer
+
+import (
+	"errors"
+	"fmt"
+	sq "github.com/Masterminds/squirrel"
+	"log"
+	"time"
+)
+
+var (
+	QueuePollInterval = 10 * time.Second
+	QueueBatchSize    = 20
+	QueueMaxAttempts  = 8
+	// QueueRetryDelay is the delay before the first retry; it doubles on every further attempt up to QueueMaxRetryDelay.
+	QueueRetryDelay    = 30 * time.Second
+	QueueMaxRetryDelay = time.Hour
+	// QueueLease is how long a claimed message is hidden from other workers while it is being sent.
+	QueueLease = 5 * time.Minute
+
+	ErrorMessageRowNotCreated = errors.New("Send failed to create one row in the auth.MailQueue table.")
+)
+
+// Queue is a Mailer that persists messages in auth.MailQueue and delivers them asynchronously through Driver.
+// Failed deliveries are retried with exponential backoff until QueueMaxAttempts is reached.
+// Several instances may process the same queue; each message is claimed before it is sent.
+type Queue struct {
+	Driver Mailer
+	DB     sq.BaseRunner
+}
+
+// NewQueue returns a Queue delivering through driver.
+func NewQueue(driver Mailer, db sq.BaseRunner) *Queue {
+	return &Queue{Driver: driver, DB: db}
+}
+
+// Send enqueues m for delivery.
+func (q *Queue) Send(m *Message) error {
+	if m.To == "" {
+		return ErrorRecipientMissing
+	}
+	now := time.Now().UTC()
+	insert := sq.Insert("[auth].[MailQueue]").
+		Columns("[Recipient]", "[Subject]", "[Text]", "[HTML]", "[Attempts]", "[NextAttempt]", "[Created]").
+		Values(m.To, m.Subject, m.Text, m.HTML, 0, now, now)
+	res, err := insert.RunWith(q.DB).Exec()
+	if err != nil {
+		log.Print(err)
+		return err
+	}
+	if cnt, err := res.RowsAffected(); err != nil || cnt != 1 {
+		log.Print(ErrorMessageRowNotCreated)
+		return ErrorMessageRowNotCreated
+	}
+	return nil
+}
+
+// Run processes the queue every QueuePollInterval until stop is closed.
+func (q *Queue) Run(stop <-chan struct{}) {
+	t := time.NewTicker(QueuePollInterval)
+	defer t.Stop()
+	for {
+		if _, err := q.Process(); err != nil {
+			log.Print(err)
+		}
+		select {
+		case <-stop:
+			return
+		case <-t.C:
+		}
+	}
+}
+
+type queued struct {
+	id       int64
+	attempts int
+	m        *Message
+}
+
+// Process delivers one batch of due messages and returns the number delivered.
+func (q *Queue) Process() (int, error) {
+	now := time.Now().UTC()
+	sel := sq.Select(fmt.Sprintf("TOP %d [ID]", QueueBatchSize), "[Recipient]", "[Subject]", "[Text]", "[HTML]", "[Attempts]").
+		From("[auth].[MailQueue]").
+		Where(sq.Eq{"[Sent]": nil}).
+		Where(sq.LtOrEq{"[NextAttempt]": now}).
+		Where(sq.Lt{"[Attempts]": QueueMaxAttempts}).
+		OrderBy("[NextAttempt]")
+	rows, err := sel.RunWith(q.DB).Query()
+	if err != nil {
+		return 0, err
+	}
+	var due []*queued
+	for rows.Next() {
+		e := &queued{m: new(Message)}
+		if err := rows.Scan(&e.id, &e.m.To, &e.m.Subject, &e.m.Text, &e.m.HTML, &e.attempts); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		due = append(due, e)
+	}
+	if err := rows.Err(); err != nil {
+		rows.Close()
+		return 0, err
+	}
+	rows.Close()
+
+	sent := 0
+	for _, e := range due {
+		claimed, err := q.claim(e, now)
+		if err != nil {
+			return sent, err
+		}
+		if !claimed {
+			continue
+		}
+		if err := q.Driver.Send(e.m); err != nil {
+			log.Print(err)
+			if err := q.retry(e, err); err != nil {
+				return sent, err
+			}
+			continue
+		}
+		// Messages carry reset and login links, so their bodies are purged once delivered.
+		update := sq.Update("[auth].[MailQueue]").
+			Set("[Sent]", time.Now().UTC()).
+			Set("[Text]", "").
+			Set("[HTML]", "").
+			Where(sq.Eq{"[ID]": e.id})
+		if _, err := update.RunWith(q.DB).Exec(); err != nil {
+			return sent, err
+		}
+		sent++
+	}
+	return sent, nil
+}
+
+// claim hides e from other workers for QueueLease and reports whether this worker won it.
+func (q *Queue) claim(e *queued, now time.Time) (bool, error) {
+	update := sq.Update("[auth].[MailQueue]").
+		Set("[NextAttempt]", now.Add(QueueLease)).
+		Where(sq.Eq{"[ID]": e.id, "[Attempts]": e.attempts, "[Sent]": nil}).
+		Where(sq.LtOrEq{"[NextAttempt]": now})
+	res, err := update.RunWith(q.DB).Exec()
+	if err != nil {
+		return false, err
+	}
+	cnt, err := res.RowsAffected()
+	return err == nil && cnt == 1, err
+}
+
+// retry records a failed attempt of e and schedules the next one. The body of a message that used its last
+// attempt is purged as it will never be delivered.
+func (q *Queue) retry(e *queued, cause error) error {
+	update := sq.Update("[auth].[MailQueue]").
+		Set("[Attempts]", e.attempts+1).
+		Set("[NextAttempt]", time.Now().UTC().Add(RetryDelay(e.attempts+1))).
+		Set("[LastError]", cause.Error()).
+		Where(sq.Eq{"[ID]": e.id})
+	if e.attempts+1 >= QueueMaxAttempts {
+		update = update.Set("[Text]", "").Set("[HTML]", "")
+	}
+	_, err := update.RunWith(q.DB).Exec()
+	return err
+}
+
+// RetryDelay returns the delay before the next delivery after attempts failed attempts.
+func RetryDelay(attempts int) time.Duration {
+	d := QueueRetryDelay
+	for i := 1; i < attempts && d < QueueMaxRetryDelay; i++ {
+		d *= 2
+	}
+	if d > QueueMaxRetryDelay {
+		d = QueueMaxRetryDelay
+	}
+	return d
+}
@@ -0,0 +1,123 @@
+package mailer
+
+import (
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/DATA-DOG/go-sqlmock.v1"
+	"strconv"
+	"testing"
+	"time"
+)
+
+type failingMailer struct{}
+
+func (failingMailer) Send(*Message) error {
+	return errors.New("connection refused")
+}
+
+func Test_Queue_Send(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("An error occured when opening a stub database connection. ERROR: %v\n", err)
+	}
+	defer db.Close()
+
+	mock.ExpectExec(`INSERT INTO \[auth]\.\[MailQueue] \(\[Recipient],\[Subject],\[Text],\[HTML],\[Attempts],\[NextAttempt],\[Created]\) VALUES \(\?,\?,\?,\?,\?,\?,\?\)`).
+		WithArgs(tEmail, "Subject", "Body", "", 0, sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+
+	q := NewQueue(new(Memory), db)
+	assert.Nil(t, q.Send(&Message{To: tEmail, Subject: "Subject", Text: "Body"}))
+	assert.EqualError(t, q.Send(new(Message)), ErrorRecipientMissing.Error())
+
+	if err = mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Expectations were not met. ERROR: %v\n", err)
+	}
+}
+
+func Test_Queue_Process(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("An error occured when opening a stub database connection. ERROR: %v\n", err)
+	}
+	defer db.Close()
+
+	columns := []string{"ID", "Recipient", "Subject", "Text", "HTML", "Attempts"}
+	selectDue := `SELECT TOP 20 \[ID], \[Recipient], \[Subject], \[Text], \[HTML], \[Attempts] FROM \[auth]\.\[MailQueue] WHERE \[Sent] IS NULL AND \[NextAttempt] <= \? AND \[Attempts] < \? ORDER BY \[NextAttempt]`
+	claim := `UPDATE \[auth]\.\[MailQueue] SET \[NextAttempt] = \? WHERE \[Attempts] = \? AND \[ID] = \? AND \[Sent] IS NULL AND \[NextAttempt] <= \?`
+
+	t.Run("sent", func(t *testing.T) {
+		mock.ExpectQuery(selectDue).
+			WithArgs(sqlmock.AnyArg(), QueueMaxAttempts).
+			WillReturnRows(sqlmock.NewRows(columns).
+				AddRow(1, tEmail, "First", "Body", "", 0).
+				AddRow(2, tEmail, "Second", "Body", "", 2))
+		mock.ExpectExec(claim).WithArgs(sqlmock.AnyArg(), 0, 1, sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec(`UPDATE \[auth]\.\[MailQueue] SET \[Sent] = \?, \[Text] = \?, \[HTML] = \? WHERE \[ID] = \?`).WithArgs(sqlmock.AnyArg(), "", "", 1).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec(claim).WithArgs(sqlmock.AnyArg(), 2, 2, sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(0, 0))
+
+		m := new(Memory)
+		n, err := NewQueue(m, db).Process()
+		assert.Nil(t, err)
+		assert.Equal(t, 1, n)
+		assert.Len(t, m.Messages, 1)
+		assert.Equal(t, "First", m.Last().Subject)
+
+		if err = mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("Expectations were not met. ERROR: %v\n", err)
+		}
+	})
+
+	t.Run("retry", func(t *testing.T) {
+		mock.ExpectQuery(selectDue).
+			WithArgs(sqlmock.AnyArg(), QueueMaxAttempts).
+			WillReturnRows(sqlmock.NewRows(columns).AddRow(3, tEmail, "Third", "Body", "", 1))
+		mock.ExpectExec(claim).WithArgs(sqlmock.AnyArg(), 1, 3, sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec(`UPDATE \[auth]\.\[MailQueue] SET \[Attempts] = \?, \[NextAttempt] = \?, \[LastError] = \? WHERE \[ID] = \?`).
+			WithArgs(2, sqlmock.AnyArg(), "connection refused", 3).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+
+		n, err := NewQueue(failingMailer{}, db).Process()
+		assert.Nil(t, err)
+		assert.Equal(t, 0, n)
+
+		if err = mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("Expectations were not met. ERROR: %v\n", err)
+		}
+	})
+
+	t.Run("exhausted", func(t *testing.T) {
+		mock.ExpectQuery(selectDue).
+			WithArgs(sqlmock.AnyArg(), QueueMaxAttempts).
+			WillReturnRows(sqlmock.NewRows(columns).AddRow(4, tEmail, "Fourth", "Body", "", QueueMaxAttempts-1))
+		mock.ExpectExec(claim).WithArgs(sqlmock.AnyArg(), QueueMaxAttempts-1, 4, sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec(`UPDATE \[auth]\.\[MailQueue] SET \[Attempts] = \?, \[NextAttempt] = \?, \[LastError] = \?, \[Text] = \?, \[HTML] = \? WHERE \[ID] = \?`).
+			WithArgs(QueueMaxAttempts, sqlmock.AnyArg(), "connection refused", "", "", 4).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+
+		n, err := NewQueue(failingMailer{}, db).Process()
+		assert.Nil(t, err)
+		assert.Equal(t, 0, n)
+
+		if err = mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("Expectations were not met. ERROR: %v\n", err)
+		}
+	})
+}
+
+func Test_RetryDelay(t *testing.T) {
+	cases := []struct {
+		attempts int
+		delay    time.Duration
+	}{
+		{1, 30 * time.Second},
+		{2, time.Minute},
+		{4, 4 * time.Minute},
+		{20, time.Hour},
+	}
+	for i, c := range cases {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			assert.Equal(t, c.delay, RetryDelay(c.attempts))
+		})
+	}
+}
@@ -0,0 +1,137 @@
+package mailer
+
+import (
+	"crypto/tls"
+	"errors"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"os"
+	"time"
+)
+
+var (
+	SMTPTimeout = 30 * time.Second
+
+	ErrorStartTLSUnsupported = errors.New("SMTP server does not support STARTTLS.")
+	ErrorAuthUnsupported     = errors.New("SMTP server does not support authentication.")
+)
+
+// SMTP delivers messages through an SMTP relay. Unless ImplicitTLS is set the connection is upgraded
+// with STARTTLS, which is required unless RequireTLS is false. Credentials are sent with AUTH PLAIN.
+type SMTP struct {
+	Addr        string
+	From        string
+	Username    string
+	Password    string
+	RequireTLS  bool
+	ImplicitTLS bool
+	TLSConfig   *tls.Config
+}
+
+// NewSMTP returns an SMTP driver configured from the SMTPAddr, SMTPFrom, SMTPUser, SMTPPassword,
+// SMTPRequireTLS and SMTPImplicitTLS environment variables.
+func NewSMTP() *SMTP {
+	return &SMTP{
+		Addr:        os.Getenv("SMTPAddr"),
+		From:        os.Getenv("SMTPFrom"),
+		Username:    os.Getenv("SMTPUser"),
+		Password:    os.Getenv("SMTPPassword"),
+		RequireTLS:  os.Getenv("SMTPRequireTLS") != "false",
+		ImplicitTLS: os.Getenv("SMTPImplicitTLS") == "true",
+	}
+}
+
+func (s *SMTP) tlsConfig(host string) *tls.Config {
+	if s.TLSConfig != nil {
+		c := s.TLSConfig.Clone()
+		if c.ServerName == "" {
+			c.ServerName = host
+		}
+		return c
+	}
+	return &tls.Config{ServerName: host}
+}
+
+// Send delivers m.
+func (s *SMTP) Send(m *Message) error {
+	if m.To == "" {
+		return ErrorRecipientMissing
+	}
+	if s.From == "" {
+		return ErrorSenderMissing
+	}
+	to, err := mail.ParseAddress(m.To)
+	if err != nil {
+		return err
+	}
+	from, err := mail.ParseAddress(s.From)
+	if err != nil {
+		return err
+	}
+	msg, err := m.bytes(s.From)
+	if err != nil {
+		return err
+	}
+
+	host, _, err := net.SplitHostPort(s.Addr)
+	if err != nil {
+		return err
+	}
+
+	var conn net.Conn
+	dialer := &net.Dialer{Timeout: SMTPTimeout}
+	if s.ImplicitTLS {
+		conn, err = tls.DialWithDialer(dialer, "tcp", s.Addr, s.tlsConfig(host))
+	} else {
+		conn, err = dialer.Dial("tcp", s.Addr)
+	}
+	if err != nil {
+		return err
+	}
+	conn.SetDeadline(time.Now().Add(SMTPTimeout))
+
+	c, err := smtp.NewClient(conn, host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer c.Close()
+
+	if !s.ImplicitTLS {
+		if ok, _ := c.Extension("STARTTLS"); ok {
+			if err := c.StartTLS(s.tlsConfig(host)); err != nil {
+				return err
+			}
+		} else if s.RequireTLS {
+			return ErrorStartTLSUnsupported
+		}
+	}
+
+	if s.Username != "" {
+		if ok, _ := c.Extension("AUTH"); !ok {
+			return ErrorAuthUnsupported
+		}
+		if err := c.Auth(smtp.PlainAuth("", s.Username, s.Password, host)); err != nil {
+			return err
+		}
+	}
+
+	if err := c.Mail(from.Address); err != nil {
+		return err
+	}
+	if err := c.Rcpt(to.Address); err != nil {
+		return err
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(msg); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return c.Quit()
+}
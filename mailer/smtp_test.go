@@ -0,0 +1,189 @@
+package mailer
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"github.com/stretchr/testify/assert"
+	"math/big"
+	"net"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// smtpServer is a minimal SMTP server accepting a single session.
+type smtpServer struct {
+	l        net.Listener
+	tls      *tls.Config
+	auth     bool
+	startTLS bool // session was upgraded
+	user     string
+	from     string
+	rcpt     string
+	data     string
+	done     chan struct{}
+}
+
+func newSMTPServer(t *testing.T, tlsConfig *tls.Config, auth bool) *smtpServer {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &smtpServer{l: l, tls: tlsConfig, auth: auth, done: make(chan struct{})}
+	go s.serve()
+	return s
+}
+
+func (s *smtpServer) serve() {
+	defer close(s.done)
+	conn, err := s.l.Accept()
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	c := textproto.NewConn(conn)
+	c.PrintfLine("220 localhost ESMTP")
+	for {
+		line, err := c.ReadLine()
+		if err != nil {
+			return
+		}
+		verb := strings.ToUpper(strings.SplitN(line, " ", 2)[0])
+		switch verb {
+		case "EHLO":
+			ext := []string{"250-localhost"}
+			if s.tls != nil && !s.startTLS {
+				ext = append(ext, "250-STARTTLS")
+			}
+			if s.auth {
+				ext = append(ext, "250-AUTH PLAIN")
+			}
+			ext = append(ext, "250 8BITMIME")
+			for _, e := range ext {
+				c.PrintfLine("%s", e)
+			}
+		case "STARTTLS":
+			c.PrintfLine("220 Ready to start TLS")
+			tc := tls.Server(conn, s.tls)
+			if err := tc.Handshake(); err != nil {
+				return
+			}
+			conn = tc
+			c = textproto.NewConn(conn)
+			s.startTLS = true
+		case "AUTH":
+			fields := strings.Fields(line)
+			b, _ := base64.StdEncoding.DecodeString(fields[len(fields)-1])
+			parts := strings.Split(string(b), "\x00")
+			if len(parts) != 3 || parts[2] != "secret" {
+				c.PrintfLine("535 Authentication failed")
+				continue
+			}
+			s.user = parts[1]
+			c.PrintfLine("235 Authentication succeeded")
+		case "MAIL":
+			s.from = line
+			c.PrintfLine("250 OK")
+		case "RCPT":
+			s.rcpt = line
+			c.PrintfLine("250 OK")
+		case "DATA":
+			c.PrintfLine("354 End data with <CR><LF>.<CR><LF>")
+			b, _ := c.ReadDotBytes()
+			s.data = string(b)
+			c.PrintfLine("250 OK")
+		case "QUIT":
+			c.PrintfLine("221 Bye")
+			return
+		default:
+			c.PrintfLine("502 Command not implemented")
+		}
+	}
+}
+
+func (s *smtpServer) wait() {
+	s.l.Close()
+	select {
+	case <-s.done:
+	case <-time.After(5 * time.Second):
+	}
+}
+
+func tlsConfigs(t *testing.T) (*tls.Config, *tls.Config) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "127.0.0.1"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+
+	server := &tls.Config{Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}}}
+	client := &tls.Config{RootCAs: pool}
+	return server, client
+}
+
+func Test_SMTP_Send(t *testing.T) {
+	serverTLS, clientTLS := tlsConfigs(t)
+
+	type test struct {
+		serverTLS *tls.Config
+		auth      bool
+		s         *SMTP
+		m         *Message
+		startTLS  bool
+		err       error
+	}
+	cases := []*test{
+		&test{serverTLS, true, &SMTP{From: "noreply@email.com", Username: "mailer", Password: "secret", RequireTLS: true, TLSConfig: clientTLS}, &Message{To: tEmail, Subject: "Subject", Text: "Body"}, true, nil},
+		&test{nil, false, &SMTP{From: "noreply@email.com"}, &Message{To: tEmail, Subject: "Subject", Text: "Body"}, false, nil},
+		&test{nil, false, &SMTP{From: "noreply@email.com", RequireTLS: true}, &Message{To: tEmail}, false, ErrorStartTLSUnsupported},
+		&test{serverTLS, false, &SMTP{From: "noreply@email.com", Username: "mailer", Password: "secret", TLSConfig: clientTLS}, &Message{To: tEmail}, true, ErrorAuthUnsupported},
+	}
+	for i, c := range cases {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			srv := newSMTPServer(t, c.serverTLS, c.auth)
+			c.s.Addr = srv.l.Addr().String()
+
+			err := c.s.Send(c.m)
+			srv.wait()
+			if c.err != nil {
+				assert.EqualError(t, err, c.err.Error())
+				return
+			}
+			assert.Nil(t, err)
+			assert.Equal(t, c.startTLS, srv.startTLS)
+			assert.Equal(t, c.s.Username, srv.user)
+			assert.Equal(t, "MAIL FROM:<noreply@email.com>", strings.SplitN(srv.from, " BODY", 2)[0])
+			assert.Equal(t, "RCPT TO:<"+tEmail+">", srv.rcpt)
+			assert.Contains(t, srv.data, "Subject: Subject\n")
+		})
+	}
+
+	t.Run("invalid", func(t *testing.T) {
+		s := &SMTP{Addr: "localhost:0"}
+		assert.EqualError(t, s.Send(&Message{To: tEmail}), ErrorSenderMissing.Error())
+		assert.EqualError(t, s.Send(new(Message)), ErrorRecipientMissing.Error())
+	})
+}
@@ -0,0 +1,112 @@
+package mailer
+
+import (
+	"bytes"
+	"errors"
+	htmltemplate "html/template"
+	"os"
+	"path/filepath"
+	"strings"
+	texttemplate "text/template"
+)
+
+var (
+	// TemplateDir holds the message templates, one file per template and locale named <name>.<locale>.tmpl.
+	// Each file defines a "subject" and a "text" template and optionally an "html" template.
+	TemplateDir = os.Getenv("GOPATH") + "/src/github.com/penutty/authservice/mailer/templates"
+
+	// DefaultLocale is used when no template exists for the requested locale.
+	DefaultLocale = "en"
+
+	ErrorTemplateMissing = errors.New("Mail template does not exist.")
+)
+
+type template struct {
+	text *texttemplate.Template
+	html *htmltemplate.Template
+}
+
+// Templates renders messages from localized templates.
+type Templates struct {
+	t map[string]*template
+}
+
+// LoadTemplates parses every template in dir.
+func LoadTemplates(dir string) (*Templates, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.tmpl"))
+	if err != nil {
+		return nil, err
+	}
+	ts := &Templates{t: make(map[string]*template)}
+	for _, p := range paths {
+		text, err := texttemplate.ParseFiles(p)
+		if err != nil {
+			return nil, err
+		}
+		t := &template{text: text}
+		if text.Lookup("html") != nil {
+			if t.html, err = htmltemplate.ParseFiles(p); err != nil {
+				return nil, err
+			}
+		}
+		ts.t[strings.ToLower(strings.TrimSuffix(filepath.Base(p), ".tmpl"))] = t
+	}
+	return ts, nil
+}
+
+// lookup returns the template for the most specific match of locale, e.g. "pt-BR", then "pt", then DefaultLocale.
+func (ts *Templates) lookup(name, locale string) *template {
+	locale = strings.ToLower(strings.Replace(locale, "_", "-", -1))
+	for locale != "" {
+		if t, ok := ts.t[strings.ToLower(name)+"."+locale]; ok {
+			return t
+		}
+		i := strings.LastIndex(locale, "-")
+		if i < 0 {
+			break
+		}
+		locale = locale[:i]
+	}
+	return ts.t[strings.ToLower(name)+"."+strings.ToLower(DefaultLocale)]
+}
+
+// Render returns a message without recipient rendered from template name in locale with data.
+func (ts *Templates) Render(name, locale string, data interface{}) (*Message, error) {
+	t := ts.lookup(name, locale)
+	if t == nil {
+		return nil, ErrorTemplateMissing
+	}
+
+	m := new(Message)
+	b := new(bytes.Buffer)
+	if err := t.text.ExecuteTemplate(b, "subject", data); err != nil {
+		return nil, err
+	}
+	m.Subject = strings.TrimSpace(b.String())
+
+	b.Reset()
+	if err := t.text.ExecuteTemplate(b, "text", data); err != nil {
+		return nil, err
+	}
+	m.Text = strings.TrimSpace(b.String()) + "\n"
+
+	if t.html != nil {
+		b.Reset()
+		if err := t.html.ExecuteTemplate(b, "html", data); err != nil {
+			return nil, err
+		}
+		m.HTML = strings.TrimSpace(b.String()) + "\n"
+	}
+	return m, nil
+}
+
+// Locale returns the first language tag of an Accept-Language header value, or DefaultLocale if there is none.
+func Locale(acceptLanguage string) string {
+	for _, tag := range strings.Split(acceptLanguage, ",") {
+		tag = strings.TrimSpace(strings.SplitN(tag, ";", 2)[0])
+		if tag != "" && tag != "*" {
+			return tag
+		}
+	}
+	return DefaultLocale
+}
@@ -0,0 +1,87 @@
+package mailer
+
+import (
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+)
+
+func Test_Templates_Render(t *testing.T) {
+	dir, err := ioutil.TempDir("", "templates")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	files := map[string]string{
+		"greeting.en.tmpl":    `{{define "subject"}}Hello {{.}}{{end}}{{define "text"}}Hello {{.}}{{end}}{{define "html"}}<p>Hello {{.}}</p>{{end}}`,
+		"greeting.pt.tmpl":    `{{define "subject"}}Olá {{.}}{{end}}{{define "text"}}Olá {{.}}{{end}}`,
+		"greeting.pt-BR.tmpl": `{{define "subject"}}Oi {{.}}{{end}}{{define "text"}}Oi {{.}}{{end}}`,
+	}
+	for name, content := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ts, err := LoadTemplates(dir)
+	assert.Nil(t, err)
+
+	type test struct {
+		name    string
+		locale  string
+		subject string
+		html    string
+		err     error
+	}
+	cases := []*test{
+		&test{"greeting", "en", "Hello <b>", "<p>Hello &lt;b&gt;</p>\n", nil},
+		&test{"greeting", "pt-BR", "Oi <b>", "", nil},
+		&test{"greeting", "pt_br", "Oi <b>", "", nil},
+		&test{"greeting", "pt-PT", "Olá <b>", "", nil},
+		&test{"greeting", "fr", "Hello <b>", "<p>Hello &lt;b&gt;</p>\n", nil},
+		&test{"greeting", "", "Hello <b>", "<p>Hello &lt;b&gt;</p>\n", nil},
+		&test{"farewell", "en", "", "", ErrorTemplateMissing},
+	}
+	for i, c := range cases {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			m, err := ts.Render(c.name, c.locale, "<b>")
+			if c.err != nil {
+				assert.EqualError(t, err, c.err.Error())
+				return
+			}
+			assert.Nil(t, err)
+			assert.Equal(t, c.subject, m.Subject)
+			assert.Equal(t, c.subject+"\n", m.Text)
+			assert.Equal(t, c.html, m.HTML)
+		})
+	}
+}
+
+func Test_LoadTemplates(t *testing.T) {
+	ts, err := LoadTemplates("templates")
+	assert.Nil(t, err)
+
+	m, err := ts.Render("login_code", "de-DE", map[string]interface{}{"Code": "123456", "Minutes": 10})
+	assert.Nil(t, err)
+	assert.Equal(t, "Ihr Auth-Service Anmeldecode", m.Subject)
+	assert.Contains(t, m.Text, "123456")
+	assert.Contains(t, m.HTML, "<strong>123456</strong>")
+}
+
+func Test_Locale(t *testing.T) {
+	cases := []struct{ header, locale string }{
+		{"", DefaultLocale},
+		{"de-DE,de;q=0.9,en;q=0.8", "de-DE"},
+		{" fr ; q=0.5", "fr"},
+		{"*", DefaultLocale},
+	}
+	for i, c := range cases {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			assert.Equal(t, c.locale, Locale(c.header))
+		})
+	}
+}
@@ -0,0 +1,330 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/dgrijalva/jwt-go"
+	"github.com/penutty/authservice/lockout"
+	"github.com/penutty/authservice/mfa"
+	"github.com/penutty/authservice/user"
+	"github.com/penutty/authservice/verification"
+	"net/http"
+	"time"
+)
+
+var (
+	MFAChallengeLifetime = 5 * time.Minute
+	// MFAChallengePolicy invalidates a challenge after Threshold wrong codes, for the rest of its lifetime.
+	MFAChallengePolicy = lockout.Policy{
+		Threshold: 5,
+		Duration:  MFAChallengeLifetime,
+		CoolDown:  MFAChallengeLifetime,
+	}
+
+	ErrorMFARequired         = errors.New("User must complete multi-factor authentication.")
+	ErrorMFAChallengeInvalid = errors.New("Form value \"MFAToken\" is invalid.")
+)
+
+// generateChallenge returns a short lived token proving the user with ID id passed the first
+// authentication step. It is only accepted by MFAEndpoint. Its "jti" claim identifies the challenge, so that
+// wrong codes presented with it can be counted.
+func generateChallenge(id string) (string, error) {
+	jti, err := user.NewID()
+	if err != nil {
+		return "", err
+	}
+	claims := jwt.MapClaims{
+		"iss": "Auth-Service",
+		"sub": id,
+		"aud": "Auth-Service",
+		"typ": "mfa",
+		"jti": jti,
+		"exp": time.Now().UTC().Add(MFAChallengeLifetime).Unix(),
+		"iat": time.Now().UTC().Unix(),
+	}
+	return signJwt(claims)
+}
+
+// parseChallenge returns the user ID and challenge ID of a token generated by generateChallenge.
+func parseChallenge(token string) (string, string, error) {
+	claims, err := verification.ParseAudience(token, "Auth-Service")
+	if err != nil {
+		logger(Warn).Println(err)
+		return "", "", ErrorMFAChallengeInvalid
+	}
+	sub, ok := claims["sub"].(string)
+	jti, _ := claims["jti"].(string)
+	if claims["typ"] != "mfa" || !ok || jti == "" {
+		return "", "", ErrorMFAChallengeInvalid
+	}
+	return sub, jti, nil
+}
+
+func (a *app) mfaHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodPost:
+		token, refresh, err := a.postMFA(r)
+		loginResponse(w, token, refresh, err)
+	default:
+		logger(Error).Println(ErrorMethodNotImplemented)
+		http.Error(w, http.StatusText(http.StatusNotImplemented), http.StatusNotImplemented)
+	}
+}
+
+// postMFA completes a login started at AuthEndpoint by verifying a TOTP code, or redeeming
+// a recovery code, against the challenge token. Wrong codes count as failed logins of the user, as wrong
+// passwords do at AuthEndpoint, and MFAChallengePolicy.Threshold of them invalidate the challenge.
+func (a *app) postMFA(r *http.Request) (string, string, error) {
+	type body struct {
+		MFAToken     string
+		Code         string
+		RecoveryCode string
+	}
+	b := new(body)
+	if err := json.NewDecoder(r.Body).Decode(b); err != nil {
+		return "", "", err
+	}
+
+	id, challenge, err := parseChallenge(b.MFAToken)
+	if err != nil {
+		return "", "", err
+	}
+	u, err := a.fetchUserByID(id)
+	if err != nil {
+		return "", "", err
+	}
+	if err := checkTenant(r, u); err != nil {
+		return "", "", err
+	}
+	key := user.NormalizeUserID(u.UserID())
+	if err := a.checkAttempts(key, r); err != nil {
+		return "", "", err
+	}
+	att := a.l.Fetch(lockout.ChallengeKey(challenge), user.AuthDB())
+	if err := a.l.Err(); err != nil {
+		return "", "", err
+	}
+	if att.Locked(time.Now().UTC()) {
+		return "", "", ErrorMFAChallengeInvalid
+	}
+
+	t := a.m.Fetch(u, user.AuthDB())
+	if err := a.m.Err(); err != nil {
+		return "", "", err
+	}
+	if !t.Enabled() {
+		return "", "", mfa.ErrorTOTPNotEnrolled
+	}
+
+	if b.RecoveryCode != "" {
+		err = a.m.Redeem(u.UserID(), b.RecoveryCode, user.AuthDB())
+	} else {
+		var step int64
+		if step, err = t.Verify(b.Code, time.Now()); err == nil {
+			err = a.m.Consume(t, step, user.AuthDB())
+		}
+	}
+	if err == mfa.ErrorCodeInvalid || err == mfa.ErrorCodeReused || err == mfa.ErrorRecoveryCodeInvalid {
+		a.failMFA(key, challenge, u, r)
+	}
+	if err != nil {
+		return "", "", err
+	}
+
+	a.l.Reset(lockout.UserKey(key), user.AuthDB())
+	if err := a.l.Err(); err != nil {
+		return "", "", err
+	}
+	return a.accessToken(u, r)
+}
+
+// failMFA records a wrong code of u, whose normalized UserID is key, presented from r with the challenge with
+// ID challenge.
+func (a *app) failMFA(key, challenge string, u *user.User, r *http.Request) {
+	a.failAttempt(key, u, r)
+	if _, err := a.l.Fail(lockout.ChallengeKey(challenge), MFAChallengePolicy, user.AuthDB()); err != nil {
+		logger(Error).Println(err)
+	}
+}
+
+// totpEnrollment is the response body of a TOTP enrollment.
+type totpEnrollment struct {
+	Secret string
+	URI    string
+	QRCode []byte
+}
+
+func (a *app) totpHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodPost:
+		e, err := a.postTOTP(r)
+		if err != nil {
+			genErrorHandler(w, err)
+			return
+		}
+		if r.Header.Get("Accept") == "image/png" {
+			w.Header().Set("Content-Type", "image/png")
+			w.WriteHeader(http.StatusCreated)
+			w.Write(e.QRCode)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(e)
+	default:
+		logger(Error).Println(ErrorMethodNotImplemented)
+		http.Error(w, http.StatusText(http.StatusNotImplemented), http.StatusNotImplemented)
+	}
+}
+
+// postTOTP starts TOTP enrollment for the authenticated user. The enrollment is not
+// enforced at AuthEndpoint until it is confirmed at TOTPConfirmEndpoint.
+func (a *app) postTOTP(r *http.Request) (*totpEnrollment, error) {
+	caller, err := a.authenticateLogin(r)
+	if err != nil {
+		return nil, err
+	}
+	t := a.m.Fetch(caller, user.AuthDB())
+	if err := a.m.Err(); err != nil {
+		return nil, err
+	}
+	if t.Enabled() {
+		return nil, mfa.ErrorTOTPEnrolled
+	}
+
+	t = a.m.Enroll(caller, user.AuthDB())
+	if err := a.m.Err(); err != nil {
+		return nil, err
+	}
+
+	png, err := mfa.QRCode(t.URI())
+	if err != nil {
+		return nil, err
+	}
+	return &totpEnrollment{Secret: t.Secret(), URI: t.URI(), QRCode: png}, nil
+}
+
+func (a *app) totpConfirmHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodPost:
+		codes, err := a.postTOTPConfirm(r)
+		if err != nil {
+			genErrorHandler(w, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(codes)
+	default:
+		logger(Error).Println(ErrorMethodNotImplemented)
+		http.Error(w, http.StatusText(http.StatusNotImplemented), http.StatusNotImplemented)
+	}
+}
+
+// recoveryCodes is the response body of operations on recovery codes.
+// Codes is only populated when new codes are generated.
+type recoveryCodes struct {
+	Codes     []string `json:",omitempty"`
+	Remaining int
+}
+
+// postTOTPConfirm enables a pending TOTP enrollment once the user presents its first valid code
+// and generates the user's recovery codes. The codes are stored first so that the user is never left
+// with TOTP enabled and no way to recover from losing the device.
+func (a *app) postTOTPConfirm(r *http.Request) (*recoveryCodes, error) {
+	caller, err := a.authenticateLogin(r)
+	if err != nil {
+		return nil, err
+	}
+	userID := caller.UserID()
+
+	type body struct {
+		Code string
+	}
+	b := new(body)
+	if err := json.NewDecoder(r.Body).Decode(b); err != nil {
+		return nil, err
+	}
+
+	t := a.m.Fetch(caller, user.AuthDB())
+	if err := a.m.Err(); err != nil {
+		return nil, err
+	}
+	if !t.Pending() {
+		return nil, mfa.ErrorTOTPNotEnrolled
+	}
+
+	step, err := t.Verify(b.Code, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	codes := a.m.Regenerate(userID, user.AuthDB())
+	if err := a.m.Err(); err != nil {
+		return nil, err
+	}
+	a.m.Confirm(t, step, user.AuthDB())
+	if err := a.m.Err(); err != nil {
+		return nil, err
+	}
+	return &recoveryCodes{Codes: codes, Remaining: len(codes)}, nil
+}
+
+func (a *app) recoveryCodesHandler(w http.ResponseWriter, r *http.Request) {
+	var (
+		codes *recoveryCodes
+		err   error
+	)
+	switch r.Method {
+	case http.MethodGet:
+		codes, err = a.getRecoveryCodes(r)
+	case http.MethodPost:
+		codes, err = a.postRecoveryCodes(r)
+	default:
+		logger(Error).Println(ErrorMethodNotImplemented)
+		http.Error(w, http.StatusText(http.StatusNotImplemented), http.StatusNotImplemented)
+		return
+	}
+	if err != nil {
+		genErrorHandler(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(codes)
+}
+
+// getRecoveryCodes returns the number of unused recovery codes of the authenticated user.
+func (a *app) getRecoveryCodes(r *http.Request) (*recoveryCodes, error) {
+	caller, err := a.authenticateLogin(r)
+	if err != nil {
+		return nil, err
+	}
+	userID := caller.UserID()
+
+	n := a.m.Remaining(userID, user.AuthDB())
+	if err := a.m.Err(); err != nil {
+		return nil, err
+	}
+	return &recoveryCodes{Remaining: n}, nil
+}
+
+// postRecoveryCodes replaces the recovery codes of the authenticated user, invalidating the old ones.
+func (a *app) postRecoveryCodes(r *http.Request) (*recoveryCodes, error) {
+	caller, err := a.authenticateLogin(r)
+	if err != nil {
+		return nil, err
+	}
+	userID := caller.UserID()
+
+	t := a.m.Fetch(caller, user.AuthDB())
+	if err := a.m.Err(); err != nil {
+		return nil, err
+	}
+	if !t.Enabled() {
+		return nil, mfa.ErrorTOTPNotEnrolled
+	}
+
+	codes := a.m.Regenerate(userID, user.AuthDB())
+	if err := a.m.Err(); err != nil {
+		return nil, err
+	}
+	return &recoveryCodes{Codes: codes, Remaining: len(codes)}, nil
+}
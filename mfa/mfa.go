@@ -0,0 +1,284 @@
+// Package mfa is dedicated to reading and writing multi-factor authentication data in Auth-Db.
+// The TOTP resource definition and methods are below; the RFC 6238 algorithm is in totp.go
+// and single-use recovery codes are in recovery.go.
+package mfa
+
+import (
+	"database/sql"
+	"errors"
+	sq "github.com/Masterminds/squirrel"
+	"github.com/penutty/authservice/internal/seal"
+	"github.com/penutty/authservice/user"
+	"log"
+	"os"
+	"time"
+)
+
+// SecretKeyEnv is the environment variable SecretKey is read from.
+const SecretKeyEnv = "TOTPSecretKey"
+
+var (
+	Issuer = "Auth-Service"
+	// SecretKey is the base64 encoded AES-256 key TOTP secrets are encrypted with at rest.
+	SecretKey = os.Getenv(SecretKeyEnv)
+
+	ErrorUserIDParameterInvalid = errors.New("TOTP.userID must be a valid userID.")
+	ErrorTOTPEnrolled           = errors.New("TOTP is already enabled for this user.")
+	ErrorTOTPNotEnrolled        = errors.New("TOTP is not enrolled for this user.")
+	ErrorCodeInvalid            = errors.New("TOTP code is invalid.")
+	ErrorCodeReused             = errors.New("TOTP code has already been used.")
+	ErrorTOTPRowNotWritten      = errors.New("Failed to write the expected rows in Auth-Db.")
+)
+
+type Client interface {
+	Enroller
+	Fetcher
+	Confirmer
+	Consumer
+	Regenerator
+	Redeemer
+	Counter
+	Err() error
+}
+
+type Enroller interface {
+	Enroll(*user.User, sq.BaseRunner) *TOTP
+}
+
+type Fetcher interface {
+	Fetch(*user.User, sq.BaseRunner) *TOTP
+}
+
+type Confirmer interface {
+	Confirm(*TOTP, int64, sq.BaseRunner)
+}
+
+type Consumer interface {
+	Consume(*TOTP, int64, sq.BaseRunner) error
+}
+
+type Regenerator interface {
+	Regenerate(string, *sql.DB) []string
+}
+
+type Redeemer interface {
+	Redeem(string, string, sq.BaseRunner) error
+}
+
+type Counter interface {
+	Remaining(string, sq.BaseRunner) int
+}
+
+type MFAClient struct {
+	err error
+}
+
+// Enroll generates a new TOTP secret for u and stores it, encrypted and unconfirmed, in the auth.TOTP table.
+// The secret is sealed to the ID of u rather than its UserID, which can be renamed.
+// Any previous unconfirmed enrollment is replaced. Enroll fails if the user has already confirmed TOTP.
+func (mc *MFAClient) Enroll(u *user.User, db sq.BaseRunner) (t *TOTP) {
+	if mc.err != nil {
+		return
+	}
+	userID := u.UserID()
+	if userID == "" || u.ID() == "" {
+		mc.err = ErrorUserIDParameterInvalid
+		return
+	}
+
+	secret, err := GenerateSecret()
+	if err != nil {
+		mc.err = err
+		return
+	}
+	sealed, err := seal.Seal(SecretKey, SecretKeyEnv, secret, []byte(u.ID()))
+	if err != nil {
+		mc.err = err
+		return
+	}
+
+	del := sq.Delete("[auth].[TOTP]").Where(sq.Eq{"[UserID]": userID, "[Confirmed]": false})
+	if _, err := del.RunWith(db).Exec(); err != nil {
+		log.Print(err)
+		mc.err = err
+		return
+	}
+
+	insert := sq.Insert("[auth].[TOTP]").Columns("[UserID]", "[Secret]", "[Confirmed]", "[LastStep]").Values(userID, sealed, false, 0)
+	res, err := insert.RunWith(db).Exec()
+	if err != nil {
+		log.Print(err)
+		mc.err = ErrorTOTPEnrolled
+		return
+	}
+	mc.checkRowsAffected(res)
+
+	t = &TOTP{userID: userID, secret: secret, enrolled: true}
+	return
+}
+
+// Fetch selects the TOTP enrollment of u from the auth.TOTP table in db.
+// A user without an enrollment yields a TOTP that is not Enabled. A secret sealed to the UserID of u, as
+// secrets were before they were sealed to IDs, is sealed again to the ID of u.
+func (mc *MFAClient) Fetch(u *user.User, db sq.BaseRunner) (t *TOTP) {
+	if mc.err != nil {
+		return
+	}
+	userID := u.UserID()
+	if userID == "" || u.ID() == "" {
+		mc.err = ErrorUserIDParameterInvalid
+		return
+	}
+
+	totp := sq.Select("[Secret], [Confirmed], [LastStep]").From("[auth].[TOTP]").Where(sq.Eq{"[UserID]": userID})
+
+	var sealed []byte
+	t = &TOTP{userID: userID}
+	err := totp.RunWith(db).QueryRow().Scan(&sealed, &t.confirmed, &t.lastStep)
+	if err == sql.ErrNoRows {
+		return
+	}
+	if err != nil {
+		log.Print(err)
+		mc.err = err
+		return
+	}
+
+	t.secret, err = seal.Open(SecretKey, SecretKeyEnv, sealed, []byte(u.ID()))
+	if err == seal.ErrorCiphertextInvalid {
+		if t.secret, err = seal.Open(SecretKey, SecretKeyEnv, sealed, []byte(userID)); err == nil {
+			mc.reseal(u, t.secret, db)
+		}
+	}
+	if err != nil {
+		log.Print(err)
+		mc.err = err
+	}
+	t.enrolled = true
+	return
+}
+
+// reseal seals secret, the TOTP secret of u, to the ID of u. A failure is only logged since secret can still be
+// opened the old way.
+func (mc *MFAClient) reseal(u *user.User, secret []byte, db sq.BaseRunner) {
+	sealed, err := seal.Seal(SecretKey, SecretKeyEnv, secret, []byte(u.ID()))
+	if err != nil {
+		log.Print(err)
+		return
+	}
+	update := sq.Update("[auth].[TOTP]").Set("[Secret]", sealed).Where(sq.Eq{"[UserID]": u.UserID()})
+	if _, err := update.RunWith(db).Exec(); err != nil {
+		log.Print(err)
+	}
+}
+
+// Confirm marks the enrollment of t as confirmed after the user proved possession with a code valid for step.
+func (mc *MFAClient) Confirm(t *TOTP, step int64, db sq.BaseRunner) {
+	if mc.err != nil {
+		return
+	}
+	update := sq.Update("[auth].[TOTP]").
+		Set("[Confirmed]", true).
+		Set("[LastStep]", step).
+		Where(sq.Eq{"[UserID]": t.userID, "[Confirmed]": false})
+	res, err := update.RunWith(db).Exec()
+	if err != nil {
+		log.Print(err)
+		mc.err = err
+		return
+	}
+	mc.checkRowsAffected(res)
+	if mc.err == nil {
+		t.confirmed, t.lastStep = true, step
+	}
+}
+
+// Consume records step as the last time step used by t. It fails with ErrorCodeReused if step, or a later step,
+// has already been used, so each code is accepted at most once even under concurrent requests.
+func (mc *MFAClient) Consume(t *TOTP, step int64, db sq.BaseRunner) error {
+	if mc.err != nil {
+		return mc.err
+	}
+	update := sq.Update("[auth].[TOTP]").
+		Set("[LastStep]", step).
+		Where(sq.And{sq.Eq{"[UserID]": t.userID}, sq.Lt{"[LastStep]": step}})
+	res, err := update.RunWith(db).Exec()
+	if err != nil {
+		log.Print(err)
+		mc.err = err
+		return err
+	}
+	if cnt, err := res.RowsAffected(); err != nil || cnt != 1 {
+		return ErrorCodeReused
+	}
+	t.lastStep = step
+	return nil
+}
+
+func (mc *MFAClient) checkRowsAffected(res sql.Result) {
+	cnt, err := res.RowsAffected()
+	if err != nil {
+		log.Print(err)
+		mc.err = err
+		return
+	}
+	if cnt != 1 {
+		log.Print(ErrorTOTPRowNotWritten)
+		mc.err = ErrorTOTPRowNotWritten
+	}
+}
+
+// Err returns the error status of a MFAClient instance.
+func (mc *MFAClient) Err() error {
+	return mc.err
+}
+
+// TOTP references a unique auth.TOTP row in the Auth-Db database.
+type TOTP struct {
+	userID    string
+	secret    []byte
+	enrolled  bool
+	confirmed bool
+	lastStep  int64
+}
+
+// NewTOTP is a constructor of the TOTP struct.
+func NewTOTP(userID string, secret []byte, confirmed bool, lastStep int64) *TOTP {
+	return &TOTP{userID: userID, secret: secret, enrolled: true, confirmed: confirmed, lastStep: lastStep}
+}
+
+// Enabled reports whether the user has confirmed TOTP and must present a code to log in.
+func (t *TOTP) Enabled() bool {
+	return t.enrolled && t.confirmed
+}
+
+// Pending reports whether the user has enrolled TOTP but not yet confirmed it.
+func (t *TOTP) Pending() bool {
+	return t.enrolled && !t.confirmed
+}
+
+// Secret returns the base32 encoded shared secret of t.
+func (t *TOTP) Secret() string {
+	return EncodeSecret(t.secret)
+}
+
+// URI returns the otpauth:// key URI of t.
+func (t *TOTP) URI() string {
+	return URI(Issuer, t.userID, t.secret)
+}
+
+// Verify returns the time step code is valid for at time now.
+// Codes for steps at or before the last used step are rejected with ErrorCodeReused.
+func (t *TOTP) Verify(code string, now time.Time) (int64, error) {
+	if !t.enrolled {
+		return 0, ErrorTOTPNotEnrolled
+	}
+	step, ok := Validate(t.secret, code, now)
+	if !ok {
+		return 0, ErrorCodeInvalid
+	}
+	if step <= t.lastStep {
+		return 0, ErrorCodeReused
+	}
+	return step, nil
+}
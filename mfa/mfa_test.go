@@ -0,0 +1,261 @@
+package mfa
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"encoding/base64"
+	"github.com/penutty/authservice/internal/seal"
+	"github.com/penutty/authservice/user"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/DATA-DOG/go-sqlmock.v1"
+	"testing"
+	"time"
+)
+
+var (
+	tUser = "testuser"
+	tID   = "01890a5d-ac96-774b-bcce-b302099a8057"
+)
+
+func init() {
+	SecretKey = base64.StdEncoding.EncodeToString([]byte("0123456789abcdef0123456789abcdef"))
+}
+
+// newUser returns the user userID with ID tID.
+func newUser(userID string) *user.User {
+	uc := new(user.UserClient)
+	u := uc.NewUser(userID, "<testemail@email.com>", "TestPassword123!")
+	u.SetID(tID)
+	return u
+}
+
+// captured is a sqlmock argument that matches any value and keeps it.
+type captured struct {
+	value []byte
+}
+
+func (c *captured) Match(v driver.Value) bool {
+	c.value, _ = v.([]byte)
+	return true
+}
+
+func Test_Enroll(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("An error occured when opening a stub database connection. ERROR: %v\n", err)
+	}
+	defer db.Close()
+
+	t.Run("1", func(t *testing.T) {
+		mock.ExpectExec(`DELETE FROM \[auth]\.\[TOTP] WHERE \[Confirmed] = \? AND \[UserID] = \?`).
+			WithArgs(false, tUser).
+			WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectExec(`INSERT INTO \[auth]\.\[TOTP] \(\[UserID],\[Secret],\[Confirmed],\[LastStep]\) VALUES \(\?,\?,\?,\?\)`).
+			WithArgs(tUser, sqlmock.AnyArg(), false, 0).
+			WillReturnResult(sqlmock.NewResult(1, 1))
+
+		mc := new(MFAClient)
+		totp := mc.Enroll(newUser(tUser), db)
+		assert.Nil(t, mc.Err())
+		assert.True(t, totp.Pending())
+		assert.Len(t, totp.Secret(), 32)
+
+		if err = mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("Expectations were not met. ERROR: %v\n", err)
+		}
+	})
+
+	t.Run("2", func(t *testing.T) {
+		mock.ExpectExec(`DELETE FROM \[auth]\.\[TOTP]`).WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectExec(`INSERT INTO \[auth]\.\[TOTP]`).WillReturnError(sql.ErrTxDone)
+
+		mc := new(MFAClient)
+		_ = mc.Enroll(newUser(tUser), db)
+		assert.EqualError(t, mc.Err(), ErrorTOTPEnrolled.Error())
+	})
+
+	t.Run("3", func(t *testing.T) {
+		mc := new(MFAClient)
+		_ = mc.Enroll(new(user.User), db)
+		assert.EqualError(t, mc.Err(), ErrorUserIDParameterInvalid.Error())
+	})
+}
+
+func Test_Fetch(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("An error occured when opening a stub database connection. ERROR: %v\n", err)
+	}
+	defer db.Close()
+
+	t.Run("1", func(t *testing.T) {
+		sealed, err := seal.Seal(SecretKey, SecretKeyEnv, tRFCSecret, []byte(tID))
+		if err != nil {
+			t.Fatal(err)
+		}
+		mock.ExpectQuery(`SELECT \[Secret], \[Confirmed], \[LastStep] FROM \[auth]\.\[TOTP] WHERE \[UserID] = \?`).
+			WithArgs(tUser).
+			WillReturnRows(sqlmock.NewRows([]string{"Secret", "Confirmed", "LastStep"}).AddRow(sealed, true, 10))
+
+		mc := new(MFAClient)
+		totp := mc.Fetch(newUser(tUser), db)
+		assert.Nil(t, mc.Err())
+		assert.True(t, totp.Enabled())
+		assert.Equal(t, EncodeSecret(tRFCSecret), totp.Secret())
+
+		if err = mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("Expectations were not met. ERROR: %v\n", err)
+		}
+	})
+
+	t.Run("2", func(t *testing.T) {
+		mock.ExpectQuery(`SELECT \[Secret], \[Confirmed], \[LastStep] FROM \[auth]\.\[TOTP]`).
+			WithArgs(tUser).
+			WillReturnError(sql.ErrNoRows)
+
+		mc := new(MFAClient)
+		totp := mc.Fetch(newUser(tUser), db)
+		assert.Nil(t, mc.Err())
+		assert.False(t, totp.Enabled())
+		assert.False(t, totp.Pending())
+	})
+
+	// A secret sealed to the UserID is sealed again to the ID.
+	t.Run("3", func(t *testing.T) {
+		sealed, err := seal.Seal(SecretKey, SecretKeyEnv, tRFCSecret, []byte(tUser))
+		if err != nil {
+			t.Fatal(err)
+		}
+		mock.ExpectQuery(`SELECT \[Secret], \[Confirmed], \[LastStep] FROM \[auth]\.\[TOTP]`).
+			WithArgs(tUser).
+			WillReturnRows(sqlmock.NewRows([]string{"Secret", "Confirmed", "LastStep"}).AddRow(sealed, true, 10))
+		resealed := new(captured)
+		mock.ExpectExec(`UPDATE \[auth]\.\[TOTP] SET \[Secret] = \? WHERE \[UserID] = \?`).
+			WithArgs(resealed, tUser).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+
+		mc := new(MFAClient)
+		totp := mc.Fetch(newUser(tUser), db)
+		assert.Nil(t, mc.Err())
+		assert.Equal(t, EncodeSecret(tRFCSecret), totp.Secret())
+		plain, err := seal.Open(SecretKey, SecretKeyEnv, resealed.value, []byte(tID))
+		assert.Nil(t, err)
+		assert.Equal(t, tRFCSecret, plain)
+
+		if err = mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("Expectations were not met. ERROR: %v\n", err)
+		}
+	})
+
+	t.Run("4", func(t *testing.T) {
+		mock.ExpectQuery(`SELECT \[Secret], \[Confirmed], \[LastStep] FROM \[auth]\.\[TOTP]`).
+			WithArgs(tUser).
+			WillReturnRows(sqlmock.NewRows([]string{"Secret", "Confirmed", "LastStep"}).AddRow([]byte("garbage"), true, 10))
+
+		mc := new(MFAClient)
+		_ = mc.Fetch(newUser(tUser), db)
+		assert.Equal(t, seal.ErrorCiphertextInvalid, mc.Err())
+	})
+}
+
+// A renamed user can still open the secret enrolled under the former UserID, as it is sealed to the ID.
+func Test_Fetch_renamed(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("An error occured when opening a stub database connection. ERROR: %v\n", err)
+	}
+	defer db.Close()
+
+	sealed := new(captured)
+	mock.ExpectExec(`DELETE FROM \[auth]\.\[TOTP]`).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(`INSERT INTO \[auth]\.\[TOTP]`).
+		WithArgs(tUser, sealed, false, 0).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mc := new(MFAClient)
+	enrolled := mc.Enroll(newUser(tUser), db)
+	assert.Nil(t, mc.Err())
+
+	renamed := "renameduser"
+	mock.ExpectQuery(`SELECT \[Secret], \[Confirmed], \[LastStep] FROM \[auth]\.\[TOTP]`).
+		WithArgs(renamed).
+		WillReturnRows(sqlmock.NewRows([]string{"Secret", "Confirmed", "LastStep"}).AddRow(sealed.value, true, 10))
+	totp := mc.Fetch(newUser(renamed), db)
+	assert.Nil(t, mc.Err())
+	assert.Equal(t, enrolled.Secret(), totp.Secret())
+
+	if err = mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Expectations were not met. ERROR: %v\n", err)
+	}
+}
+
+func Test_Confirm(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("An error occured when opening a stub database connection. ERROR: %v\n", err)
+	}
+	defer db.Close()
+
+	mock.ExpectExec(`UPDATE \[auth]\.\[TOTP] SET \[Confirmed] = \?, \[LastStep] = \? WHERE \[Confirmed] = \? AND \[UserID] = \?`).
+		WithArgs(true, 5, false, tUser).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	mc := new(MFAClient)
+	totp := NewTOTP(tUser, tRFCSecret, false, 0)
+	mc.Confirm(totp, 5, db)
+	assert.Nil(t, mc.Err())
+	assert.True(t, totp.Enabled())
+
+	if err = mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Expectations were not met. ERROR: %v\n", err)
+	}
+}
+
+func Test_Consume(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("An error occured when opening a stub database connection. ERROR: %v\n", err)
+	}
+	defer db.Close()
+
+	t.Run("1", func(t *testing.T) {
+		mock.ExpectExec(`UPDATE \[auth]\.\[TOTP] SET \[LastStep] = \? WHERE \(\[UserID] = \? AND \[LastStep] < \?\)`).
+			WithArgs(7, tUser, 7).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+
+		mc := new(MFAClient)
+		assert.Nil(t, mc.Consume(NewTOTP(tUser, tRFCSecret, true, 5), 7, db))
+		assert.Nil(t, mc.Err())
+	})
+
+	t.Run("2", func(t *testing.T) {
+		mock.ExpectExec(`UPDATE \[auth]\.\[TOTP] SET \[LastStep] = \?`).
+			WithArgs(7, tUser, 7).
+			WillReturnResult(sqlmock.NewResult(0, 0))
+
+		mc := new(MFAClient)
+		assert.EqualError(t, mc.Consume(NewTOTP(tUser, tRFCSecret, true, 5), 7, db), ErrorCodeReused.Error())
+		assert.Nil(t, mc.Err())
+	})
+
+	if err = mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Expectations were not met. ERROR: %v\n", err)
+	}
+}
+
+func Test_Verify(t *testing.T) {
+	now := time.Unix(1111111109, 0)
+	step := Step(now)
+
+	_, err := NewTOTP(tUser, tRFCSecret, true, 0).Verify("000000", now)
+	assert.EqualError(t, err, ErrorCodeInvalid.Error())
+
+	s, err := NewTOTP(tUser, tRFCSecret, true, 0).Verify("081804", now)
+	assert.Nil(t, err)
+	assert.Equal(t, step, s)
+
+	_, err = NewTOTP(tUser, tRFCSecret, true, step).Verify("081804", now)
+	assert.EqualError(t, err, ErrorCodeReused.Error())
+
+	_, err = new(TOTP).Verify("081804", now)
+	assert.EqualError(t, err, ErrorTOTPNotEnrolled.Error())
+}
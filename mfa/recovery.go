@@ -0,0 +1,144 @@
+package mfa
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	sq "github.com/Masterminds/squirrel"
+	"log"
+	"strings"
+)
+
+var (
+	RecoveryCodeCount = 10
+	RecoveryCodeSize  = 10
+
+	ErrorRecoveryCodeInvalid = errors.New("Recovery code is invalid or has already been used.")
+)
+
+// GenerateRecoveryCodes returns n random recovery codes formatted for display, e.g. "abcd-efgh-ijkl-mnop".
+func GenerateRecoveryCodes(n int) ([]string, error) {
+	codes := make([]string, n)
+	for i := range codes {
+		b := make([]byte, RecoveryCodeSize)
+		if _, err := rand.Read(b); err != nil {
+			return nil, err
+		}
+		raw := strings.ToLower(encoding.EncodeToString(b))
+
+		groups := make([]string, 0, len(raw)/4+1)
+		for len(raw) > 4 {
+			groups, raw = append(groups, raw[:4]), raw[4:]
+		}
+		codes[i] = strings.Join(append(groups, raw), "-")
+	}
+	return codes, nil
+}
+
+// HashRecoveryCode returns the hex encoded SHA-256 digest of code as stored in auth.RecoveryCodes.
+// Case, whitespace and hyphens are ignored so codes may be typed as displayed or not.
+func HashRecoveryCode(code string) string {
+	code = strings.ToLower(code)
+	code = strings.Map(func(r rune) rune {
+		if r == '-' || r == ' ' || r == '\t' {
+			return -1
+		}
+		return r
+	}, code)
+	h := sha256.Sum256([]byte(code))
+	return hex.EncodeToString(h[:])
+}
+
+// Regenerate replaces every recovery code of userID in the auth.RecoveryCodes table with RecoveryCodeCount new codes
+// in a single transaction, so that a failure leaves the old codes in place. The plain codes are returned so they can
+// be shown to the user once; only their hashes are stored.
+func (mc *MFAClient) Regenerate(userID string, db *sql.DB) (codes []string) {
+	if mc.err != nil {
+		return
+	}
+	if userID == "" {
+		mc.err = ErrorUserIDParameterInvalid
+		return
+	}
+
+	codes, err := GenerateRecoveryCodes(RecoveryCodeCount)
+	if err != nil {
+		mc.err = err
+		return
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		log.Print(err)
+		mc.err = err
+		return nil
+	}
+	if err := replaceRecoveryCodes(userID, codes, tx); err != nil {
+		log.Print(err)
+		tx.Rollback()
+		mc.err = err
+		return nil
+	}
+	if err := tx.Commit(); err != nil {
+		log.Print(err)
+		mc.err = err
+		return nil
+	}
+	return
+}
+
+// replaceRecoveryCodes deletes the recovery codes of userID and inserts the hashes of codes.
+func replaceRecoveryCodes(userID string, codes []string, tx sq.BaseRunner) error {
+	del := sq.Delete("[auth].[RecoveryCodes]").Where(sq.Eq{"[UserID]": userID})
+	if _, err := del.RunWith(tx).Exec(); err != nil {
+		return err
+	}
+
+	insert := sq.Insert("[auth].[RecoveryCodes]").Columns("[UserID]", "[Code]")
+	for _, c := range codes {
+		insert = insert.Values(userID, HashRecoveryCode(c))
+	}
+	res, err := insert.RunWith(tx).Exec()
+	if err != nil {
+		return err
+	}
+	if cnt, err := res.RowsAffected(); err != nil || cnt != int64(len(codes)) {
+		return ErrorTOTPRowNotWritten
+	}
+	return nil
+}
+
+// Redeem deletes code from the recovery codes of userID. It returns ErrorRecoveryCodeInvalid if the code does not
+// exist, so each code can be redeemed at most once. An invalid code is the caller's mistake and is not kept in Err;
+// database errors are returned and kept.
+func (mc *MFAClient) Redeem(userID, code string, db sq.BaseRunner) error {
+	if mc.err != nil {
+		return mc.err
+	}
+	del := sq.Delete("[auth].[RecoveryCodes]").Where(sq.Eq{"[UserID]": userID, "[Code]": HashRecoveryCode(code)})
+	res, err := del.RunWith(db).Exec()
+	if err != nil {
+		log.Print(err)
+		mc.err = err
+		return err
+	}
+	if cnt, err := res.RowsAffected(); err != nil || cnt != 1 {
+		return ErrorRecoveryCodeInvalid
+	}
+	return nil
+}
+
+// Remaining returns the number of unused recovery codes of userID.
+func (mc *MFAClient) Remaining(userID string, db sq.BaseRunner) (n int) {
+	if mc.err != nil {
+		return
+	}
+	count := sq.Select("COUNT(*)").From("[auth].[RecoveryCodes]").Where(sq.Eq{"[UserID]": userID})
+	if err := count.RunWith(db).QueryRow().Scan(&n); err != nil {
+		log.Print(err)
+		mc.err = err
+	}
+	return
+}
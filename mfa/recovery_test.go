@@ -0,0 +1,118 @@
+package mfa
+
+import (
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/DATA-DOG/go-sqlmock.v1"
+	"regexp"
+	"testing"
+)
+
+func Test_GenerateRecoveryCodes(t *testing.T) {
+	codes, err := GenerateRecoveryCodes(RecoveryCodeCount)
+	assert.Nil(t, err)
+	assert.Len(t, codes, RecoveryCodeCount)
+
+	seen := make(map[string]bool)
+	for _, c := range codes {
+		assert.Regexp(t, regexp.MustCompile(`^[a-z2-7]{4}-[a-z2-7]{4}-[a-z2-7]{4}-[a-z2-7]{4}$`), c)
+		assert.False(t, seen[c])
+		seen[c] = true
+	}
+}
+
+func Test_HashRecoveryCode(t *testing.T) {
+	h := HashRecoveryCode("abcd-efgh-ijkl-mnop")
+	assert.Len(t, h, 64)
+	assert.Equal(t, h, HashRecoveryCode("ABCD EFGH IJKL MNOP"))
+	assert.Equal(t, h, HashRecoveryCode("abcdefghijklmnop"))
+	assert.NotEqual(t, h, HashRecoveryCode("abcd-efgh-ijkl-mnoq"))
+}
+
+func Test_Regenerate(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("An error occured when opening a stub database connection. ERROR: %v\n", err)
+	}
+	defer db.Close()
+
+	mock.ExpectBegin()
+	mock.ExpectExec(`DELETE FROM \[auth]\.\[RecoveryCodes] WHERE \[UserID] = \?`).
+		WithArgs(tUser).
+		WillReturnResult(sqlmock.NewResult(0, 10))
+	mock.ExpectExec(`INSERT INTO \[auth]\.\[RecoveryCodes] \(\[UserID],\[Code]\) VALUES \(\?,\?\),`).
+		WillReturnResult(sqlmock.NewResult(0, int64(RecoveryCodeCount)))
+	mock.ExpectCommit()
+
+	mc := new(MFAClient)
+	codes := mc.Regenerate(tUser, db)
+	assert.Nil(t, mc.Err())
+	assert.Len(t, codes, RecoveryCodeCount)
+
+	// A failed insert rolls back the deletion of the old codes.
+	mock.ExpectBegin()
+	mock.ExpectExec(`DELETE FROM \[auth]\.\[RecoveryCodes] WHERE \[UserID] = \?`).
+		WithArgs(tUser).
+		WillReturnResult(sqlmock.NewResult(0, 10))
+	mock.ExpectExec(`INSERT INTO \[auth]\.\[RecoveryCodes]`).
+		WillReturnError(errors.New("insert failed"))
+	mock.ExpectRollback()
+
+	mc = new(MFAClient)
+	assert.Nil(t, mc.Regenerate(tUser, db))
+	assert.EqualError(t, mc.Err(), "insert failed")
+
+	if err = mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Expectations were not met. ERROR: %v\n", err)
+	}
+}
+
+func Test_Redeem(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("An error occured when opening a stub database connection. ERROR: %v\n", err)
+	}
+	defer db.Close()
+
+	code := "abcd-efgh-ijkl-mnop"
+
+	t.Run("1", func(t *testing.T) {
+		mock.ExpectExec(`DELETE FROM \[auth]\.\[RecoveryCodes] WHERE \[Code] = \? AND \[UserID] = \?`).
+			WithArgs(HashRecoveryCode(code), tUser).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+
+		mc := new(MFAClient)
+		assert.Nil(t, mc.Redeem(tUser, code, db))
+		assert.Nil(t, mc.Err())
+	})
+
+	t.Run("2", func(t *testing.T) {
+		mock.ExpectExec(`DELETE FROM \[auth]\.\[RecoveryCodes]`).
+			WithArgs(HashRecoveryCode(code), tUser).
+			WillReturnResult(sqlmock.NewResult(0, 0))
+
+		mc := new(MFAClient)
+		assert.Equal(t, ErrorRecoveryCodeInvalid, mc.Redeem(tUser, code, db))
+		assert.Nil(t, mc.Err())
+	})
+
+	if err = mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Expectations were not met. ERROR: %v\n", err)
+	}
+}
+
+func Test_Remaining(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("An error occured when opening a stub database connection. ERROR: %v\n", err)
+	}
+	defer db.Close()
+
+	mock.ExpectQuery(`SELECT COUNT\(\*\) FROM \[auth]\.\[RecoveryCodes] WHERE \[UserID] = \?`).
+		WithArgs(tUser).
+		WillReturnRows(sqlmock.NewRows([]string{""}).AddRow(7))
+
+	mc := new(MFAClient)
+	assert.Equal(t, 7, mc.Remaining(tUser, db))
+	assert.Nil(t, mc.Err())
+}
@@ -0,0 +1,100 @@
+package mfa
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"github.com/skip2/go-qrcode"
+	"net/url"
+	"time"
+)
+
+// TOTP parameters as defined by RFC 6238. Authenticator apps assume these defaults.
+var (
+	Period     int64 = 30
+	Digits           = 6
+	Skew       int64 = 1
+	SecretSize       = 20
+	QRCodeSize       = 256
+
+	encoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+)
+
+// GenerateSecret returns a random TOTP shared secret.
+func GenerateSecret() ([]byte, error) {
+	secret := make([]byte, SecretSize)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, err
+	}
+	return secret, nil
+}
+
+// EncodeSecret returns secret in the unpadded base32 form expected by authenticator apps.
+func EncodeSecret(secret []byte) string {
+	return encoding.EncodeToString(secret)
+}
+
+// Step returns the TOTP time step containing t.
+func Step(t time.Time) int64 {
+	return t.Unix() / Period
+}
+
+// Code returns the HOTP value of secret for a time step.
+func Code(secret []byte, step int64) string {
+	msg := make([]byte, 8)
+	binary.BigEndian.PutUint64(msg, uint64(step))
+
+	mac := hmac.New(sha1.New, secret)
+	mac.Write(msg)
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+
+	mod := uint32(1)
+	for i := 0; i < Digits; i++ {
+		mod *= 10
+	}
+	return fmt.Sprintf("%0*d", Digits, value%mod)
+}
+
+// Validate returns the time step code is valid for at time t, allowing Skew steps of clock drift.
+// ok is false if code does not match any step in the window.
+func Validate(secret []byte, code string, t time.Time) (step int64, ok bool) {
+	if len(code) != Digits {
+		return 0, false
+	}
+	current := Step(t)
+	for s := current - Skew; s <= current+Skew; s++ {
+		if hmac.Equal([]byte(Code(secret, s)), []byte(code)) {
+			return s, true
+		}
+	}
+	return 0, false
+}
+
+// URI returns the otpauth:// key URI of secret for account, as understood by authenticator apps.
+func URI(issuer, account string, secret []byte) string {
+	v := url.Values{}
+	v.Set("secret", EncodeSecret(secret))
+	v.Set("issuer", issuer)
+	v.Set("algorithm", "SHA1")
+	v.Set("digits", fmt.Sprint(Digits))
+	v.Set("period", fmt.Sprint(Period))
+
+	u := url.URL{
+		Scheme:   "otpauth",
+		Host:     "totp",
+		Path:     "/" + issuer + ":" + account,
+		RawQuery: v.Encode(),
+	}
+	return u.String()
+}
+
+// QRCode returns a PNG image of a QR code encoding uri.
+func QRCode(uri string) ([]byte, error) {
+	return qrcode.Encode(uri, qrcode.Medium, QRCodeSize)
+}
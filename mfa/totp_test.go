@@ -0,0 +1,76 @@
+package mfa
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// tRFCSecret is the SHA1 test secret from RFC 6238 Appendix B.
+var tRFCSecret = []byte("12345678901234567890")
+
+func Test_Code(t *testing.T) {
+	type timeCodePair struct {
+		unix int64
+		code string
+	}
+	testVars := []*timeCodePair{
+		&timeCodePair{59, "287082"},
+		&timeCodePair{1111111109, "081804"},
+		&timeCodePair{1111111111, "050471"},
+		&timeCodePair{1234567890, "005924"},
+		&timeCodePair{2000000000, "279037"},
+	}
+
+	for i, v := range testVars {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			assert.Equal(t, v.code, Code(tRFCSecret, Step(time.Unix(v.unix, 0))))
+		})
+	}
+}
+
+func Test_Validate(t *testing.T) {
+	now := time.Unix(1111111109, 0)
+
+	step, ok := Validate(tRFCSecret, "081804", now)
+	assert.True(t, ok)
+	assert.Equal(t, Step(now), step)
+
+	step, ok = Validate(tRFCSecret, "081804", now.Add(time.Duration(Period)*time.Second))
+	assert.True(t, ok)
+	assert.Equal(t, Step(now), step)
+
+	_, ok = Validate(tRFCSecret, "081804", now.Add(time.Duration(3*Period)*time.Second))
+	assert.False(t, ok)
+
+	_, ok = Validate(tRFCSecret, "81804", now)
+	assert.False(t, ok)
+}
+
+func Test_GenerateSecret(t *testing.T) {
+	a, err := GenerateSecret()
+	assert.Nil(t, err)
+	b, err := GenerateSecret()
+	assert.Nil(t, err)
+	assert.Len(t, a, SecretSize)
+	assert.NotEqual(t, a, b)
+}
+
+func Test_URI(t *testing.T) {
+	u, err := url.Parse(URI("Auth-Service", "testuser", tRFCSecret))
+	assert.Nil(t, err)
+	assert.Equal(t, "otpauth", u.Scheme)
+	assert.Equal(t, "totp", u.Host)
+	assert.Equal(t, "/Auth-Service:testuser", u.Path)
+	assert.Equal(t, "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ", u.Query().Get("secret"))
+	assert.Equal(t, "Auth-Service", u.Query().Get("issuer"))
+}
+
+func Test_QRCode(t *testing.T) {
+	png, err := QRCode(URI("Auth-Service", "testuser", tRFCSecret))
+	assert.Nil(t, err)
+	assert.True(t, bytes.HasPrefix(png, []byte("\x89PNG")))
+}
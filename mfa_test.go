@@ -0,0 +1,398 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	sq "github.com/Masterminds/squirrel"
+	"github.com/penutty/authservice/internal/seal"
+	"github.com/penutty/authservice/lockout"
+	"github.com/penutty/authservice/mfa"
+	"github.com/penutty/authservice/user"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+var tTOTPSecret = []byte("12345678901234567890")
+
+// MockMFAClient holds one TOTP enrollment. Like MFAClient seals the secret to the ID of the user, once enrolled
+// the mock refuses to open it for a user with another ID.
+type MockMFAClient struct {
+	err      error
+	enabled  bool
+	pending  bool
+	last     int64
+	recovery map[string]bool
+	owner    string
+}
+
+func (m *MockMFAClient) Enroll(u *user.User, db sq.BaseRunner) *mfa.TOTP {
+	m.pending, m.owner = true, u.ID()
+	return mfa.NewTOTP(u.UserID(), tTOTPSecret, false, 0)
+}
+
+func (m *MockMFAClient) Fetch(u *user.User, db sq.BaseRunner) *mfa.TOTP {
+	if !m.enabled && !m.pending {
+		return new(mfa.TOTP)
+	}
+	if m.owner != "" && m.owner != u.ID() {
+		m.err = seal.ErrorCiphertextInvalid
+		return nil
+	}
+	return mfa.NewTOTP(u.UserID(), tTOTPSecret, m.enabled, m.last)
+}
+
+func (m *MockMFAClient) Confirm(t *mfa.TOTP, step int64, db sq.BaseRunner) {
+	m.enabled, m.pending, m.last = true, false, step
+}
+
+func (m *MockMFAClient) Consume(t *mfa.TOTP, step int64, db sq.BaseRunner) error {
+	if step <= m.last {
+		return mfa.ErrorCodeReused
+	}
+	m.last = step
+	return nil
+}
+
+func (m *MockMFAClient) Regenerate(u string, db *sql.DB) []string {
+	codes, _ := mfa.GenerateRecoveryCodes(mfa.RecoveryCodeCount)
+	m.recovery = make(map[string]bool)
+	for _, c := range codes {
+		m.recovery[c] = true
+	}
+	return codes
+}
+
+func (m *MockMFAClient) Redeem(u, code string, db sq.BaseRunner) error {
+	if !m.recovery[code] {
+		return mfa.ErrorRecoveryCodeInvalid
+	}
+	delete(m.recovery, code)
+	return nil
+}
+
+func (m *MockMFAClient) Remaining(u string, db sq.BaseRunner) int {
+	return len(m.recovery)
+}
+
+func (m *MockMFAClient) Err() error {
+	return m.err
+}
+
+func NewBearerRequest(method, target string, body io.Reader) *http.Request {
+	r := httptest.NewRequest(method, target, body)
+	token, err := generateJwt(tID, nil, nil, nil)
+	if err != nil {
+		panic(err)
+	}
+	r.Header.Set("Authorization", "Bearer "+token)
+	return r
+}
+
+func NewMFABody(token, code string) *strings.Reader {
+	return strings.NewReader(fmt.Sprintf("{\"MFAToken\": \"%s\", \"Code\": \"%s\"}", token, code))
+}
+
+func NewCodeBody(code string) *strings.Reader {
+	return strings.NewReader(fmt.Sprintf("{\"Code\": \"%s\"}", code))
+}
+
+func currentCode() string {
+	return mfa.Code(tTOTPSecret, mfa.Step(time.Now()))
+}
+
+func Test_authHandler_mfa(t *testing.T) {
+	a := new(app)
+	a.rb = new(MockRBACClient)
+	a.g = new(MockGroupClient)
+	a.ss = NewMockSessionClient()
+	a.c = new(MockUserClient)
+	a.m = &MockMFAClient{enabled: true}
+	a.l = NewMockLockoutClient()
+
+	rec := httptest.NewRecorder()
+	a.authHandler(rec, httptest.NewRequest(http.MethodPost, AuthEndpoint, NewAuthBody(tUser, tPassword)))
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+	assert.Empty(t, rec.Header().Get("jwt"))
+
+	userID, _, err := parseChallenge(rec.Header().Get("mfa"))
+	assert.Nil(t, err)
+	assert.Equal(t, tID, userID)
+}
+
+func Test_mfaHandler(t *testing.T) {
+	a := new(app)
+	a.rb = new(MockRBACClient)
+	a.g = new(MockGroupClient)
+	a.ss = NewMockSessionClient()
+	a.c = new(MockUserClient)
+	a.m = &MockMFAClient{enabled: true}
+	a.l = NewMockLockoutClient()
+
+	challenge, err := generateChallenge(tID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	access, err := generateJwt(tID, nil, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	code := currentCode()
+
+	testVars := []*RequestCodePair{
+		&RequestCodePair{httptest.NewRequest(http.MethodPost, MFAEndpoint, NewMFABody(challenge, "000000")), http.StatusUnauthorized},
+		&RequestCodePair{httptest.NewRequest(http.MethodPost, MFAEndpoint, NewMFABody(access, code)), http.StatusUnauthorized},
+		&RequestCodePair{httptest.NewRequest(http.MethodPost, MFAEndpoint, NewMFABody(challenge, code)), http.StatusOK},
+		&RequestCodePair{httptest.NewRequest(http.MethodPost, MFAEndpoint, NewMFABody(challenge, code)), http.StatusUnauthorized},
+		&RequestCodePair{httptest.NewRequest(http.MethodPost, MFAEndpoint, strings.NewReader("")), http.StatusBadRequest},
+		&RequestCodePair{httptest.NewRequest(http.MethodGet, MFAEndpoint, nil), http.StatusNotImplemented},
+	}
+
+	for i, v := range testVars {
+		rec := httptest.NewRecorder()
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			// Every request is made once the delay after a wrong code has passed; see Test_mfaHandler_failures.
+			a.l.Reset(lockout.UserKey(tUser), nil)
+			a.mfaHandler(rec, v.req)
+			assert.Equal(t, v.code, rec.Code)
+			if v.code == http.StatusOK {
+				assert.NotEmpty(t, rec.Header().Get("jwt"))
+			}
+		})
+	}
+}
+
+func Test_mfaHandler_failures(t *testing.T) {
+	a, _, _ := newVerifyApp()
+	a.m = &MockMFAClient{enabled: true}
+	l := a.l.(*MockLockoutClient)
+	challenge, err := generateChallenge(tID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	post := func(challenge, code string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		a.mfaHandler(rec, httptest.NewRequest(http.MethodPost, MFAEndpoint, NewMFABody(challenge, code)))
+		return rec
+	}
+
+	// A wrong code is a failed login of the user, delaying the next attempt like a wrong password.
+	assert.Equal(t, http.StatusUnauthorized, post(challenge, "000000").Code)
+	assert.Equal(t, 1, l.attempts[lockout.UserKey(tUser)].Failures)
+	rec := post(challenge, currentCode())
+	assert.Equal(t, http.StatusTooManyRequests, rec.Code)
+	assert.NotEmpty(t, rec.Header().Get("Retry-After"))
+
+	// Enough wrong codes invalidate the challenge, even if the user is not delayed.
+	for i := 1; i < MFAChallengePolicy.Threshold; i++ {
+		l.Reset(lockout.UserKey(tUser), nil)
+		assert.Equal(t, http.StatusUnauthorized, post(challenge, "000000").Code)
+	}
+	l.Reset(lockout.UserKey(tUser), nil)
+	rec = post(challenge, currentCode())
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+	assert.Empty(t, rec.Header().Get("jwt"))
+
+	other, err := generateChallenge(tID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec = post(other, currentCode())
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.NotEmpty(t, rec.Header().Get("jwt"))
+	assert.Empty(t, l.attempts[lockout.UserKey(tUser)])
+}
+
+func Test_totpHandler(t *testing.T) {
+	t.Run("1", func(t *testing.T) {
+		a := new(app)
+		a.rb = new(MockRBACClient)
+		a.g = new(MockGroupClient)
+		a.ss = NewMockSessionClient()
+		a.c = new(MockUserClient)
+		a.m = new(MockMFAClient)
+
+		rec := httptest.NewRecorder()
+		a.totpHandler(rec, NewBearerRequest(http.MethodPost, TOTPEndpoint, nil))
+		assert.Equal(t, http.StatusCreated, rec.Code)
+
+		e := new(totpEnrollment)
+		if err := json.NewDecoder(rec.Body).Decode(e); err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, mfa.EncodeSecret(tTOTPSecret), e.Secret)
+		assert.True(t, strings.HasPrefix(e.URI, "otpauth://totp/"))
+		assert.NotEmpty(t, e.QRCode)
+	})
+
+	t.Run("2", func(t *testing.T) {
+		a := new(app)
+		a.rb = new(MockRBACClient)
+		a.g = new(MockGroupClient)
+		a.ss = NewMockSessionClient()
+		a.c = new(MockUserClient)
+		a.m = new(MockMFAClient)
+
+		r := NewBearerRequest(http.MethodPost, TOTPEndpoint, nil)
+		r.Header.Set("Accept", "image/png")
+		rec := httptest.NewRecorder()
+		a.totpHandler(rec, r)
+		assert.Equal(t, http.StatusCreated, rec.Code)
+		assert.Equal(t, "image/png", rec.Header().Get("Content-Type"))
+	})
+
+	t.Run("3", func(t *testing.T) {
+		a := new(app)
+		a.rb = new(MockRBACClient)
+		a.g = new(MockGroupClient)
+		a.ss = NewMockSessionClient()
+		a.c = new(MockUserClient)
+		a.m = &MockMFAClient{enabled: true}
+
+		rec := httptest.NewRecorder()
+		a.totpHandler(rec, NewBearerRequest(http.MethodPost, TOTPEndpoint, nil))
+		assert.Equal(t, http.StatusConflict, rec.Code)
+	})
+
+	t.Run("4", func(t *testing.T) {
+		a := new(app)
+		a.rb = new(MockRBACClient)
+		a.g = new(MockGroupClient)
+		a.ss = NewMockSessionClient()
+		a.c = new(MockUserClient)
+		a.m = new(MockMFAClient)
+
+		rec := httptest.NewRecorder()
+		a.totpHandler(rec, httptest.NewRequest(http.MethodPost, TOTPEndpoint, nil))
+		assert.Equal(t, http.StatusUnauthorized, rec.Code)
+	})
+}
+
+func Test_totpConfirmHandler(t *testing.T) {
+	a := new(app)
+	a.rb = new(MockRBACClient)
+	a.g = new(MockGroupClient)
+	a.ss = NewMockSessionClient()
+	a.c = new(MockUserClient)
+	a.m = new(MockMFAClient)
+
+	testVars := []*RequestCodePair{
+		&RequestCodePair{NewBearerRequest(http.MethodPost, TOTPConfirmEndpoint, NewCodeBody(currentCode())), http.StatusBadRequest},
+		&RequestCodePair{NewBearerRequest(http.MethodPost, TOTPEndpoint, nil), http.StatusCreated},
+		&RequestCodePair{NewBearerRequest(http.MethodPost, TOTPConfirmEndpoint, NewCodeBody("000000")), http.StatusUnauthorized},
+		&RequestCodePair{NewBearerRequest(http.MethodPost, TOTPConfirmEndpoint, NewCodeBody(currentCode())), http.StatusOK},
+		&RequestCodePair{NewBearerRequest(http.MethodPost, TOTPConfirmEndpoint, NewCodeBody(currentCode())), http.StatusBadRequest},
+	}
+
+	for i, v := range testVars {
+		rec := httptest.NewRecorder()
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			if v.req.URL.Path == TOTPEndpoint {
+				a.totpHandler(rec, v.req)
+			} else {
+				a.totpConfirmHandler(rec, v.req)
+			}
+			assert.Equal(t, v.code, rec.Code)
+		})
+	}
+	assert.True(t, a.m.(*MockMFAClient).enabled)
+	assert.Len(t, a.m.(*MockMFAClient).recovery, mfa.RecoveryCodeCount)
+}
+
+func NewRecoveryBody(token, code string) *strings.Reader {
+	return strings.NewReader(fmt.Sprintf("{\"MFAToken\": \"%s\", \"RecoveryCode\": \"%s\"}", token, code))
+}
+
+func Test_mfaHandler_recovery(t *testing.T) {
+	m := &MockMFAClient{enabled: true}
+	a := new(app)
+	a.rb = new(MockRBACClient)
+	a.g = new(MockGroupClient)
+	a.ss = NewMockSessionClient()
+	a.c = new(MockUserClient)
+	a.m = m
+	a.l = NewMockLockoutClient()
+	codes := m.Regenerate(tUser, nil)
+
+	challenge, err := generateChallenge(tID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rec := httptest.NewRecorder()
+	a.mfaHandler(rec, httptest.NewRequest(http.MethodPost, MFAEndpoint, NewRecoveryBody(challenge, codes[0])))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.NotEmpty(t, rec.Header().Get("jwt"))
+	assert.Len(t, m.recovery, mfa.RecoveryCodeCount-1)
+
+	rec = httptest.NewRecorder()
+	a.mfaHandler(rec, httptest.NewRequest(http.MethodPost, MFAEndpoint, NewRecoveryBody(challenge, codes[0])))
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+
+	// A reused code does not prevent redeeming the next one once the delay after it has passed.
+	a.l.Reset(lockout.UserKey(tUser), nil)
+	rec = httptest.NewRecorder()
+	a.mfaHandler(rec, httptest.NewRequest(http.MethodPost, MFAEndpoint, NewRecoveryBody(challenge, codes[1])))
+	assert.Equal(t, http.StatusOK, rec.Code)
+}
+
+func Test_recoveryCodesHandler(t *testing.T) {
+	m := &MockMFAClient{enabled: true}
+	a := new(app)
+	a.rb = new(MockRBACClient)
+	a.g = new(MockGroupClient)
+	a.ss = NewMockSessionClient()
+	a.c = new(MockUserClient)
+	a.m = m
+	old := m.Regenerate(tUser, nil)
+
+	t.Run("1", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		a.recoveryCodesHandler(rec, NewBearerRequest(http.MethodGet, RecoveryEndpoint, nil))
+		assert.Equal(t, http.StatusOK, rec.Code)
+
+		codes := new(recoveryCodes)
+		if err := json.NewDecoder(rec.Body).Decode(codes); err != nil {
+			t.Fatal(err)
+		}
+		assert.Empty(t, codes.Codes)
+		assert.Equal(t, mfa.RecoveryCodeCount, codes.Remaining)
+	})
+
+	t.Run("2", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		a.recoveryCodesHandler(rec, NewBearerRequest(http.MethodPost, RecoveryEndpoint, nil))
+		assert.Equal(t, http.StatusOK, rec.Code)
+
+		codes := new(recoveryCodes)
+		if err := json.NewDecoder(rec.Body).Decode(codes); err != nil {
+			t.Fatal(err)
+		}
+		assert.Len(t, codes.Codes, mfa.RecoveryCodeCount)
+		assert.False(t, m.recovery[old[0]])
+	})
+
+	t.Run("3", func(t *testing.T) {
+		a := new(app)
+		a.rb = new(MockRBACClient)
+		a.g = new(MockGroupClient)
+		a.ss = NewMockSessionClient()
+		a.c = new(MockUserClient)
+		a.m = new(MockMFAClient)
+		rec := httptest.NewRecorder()
+		a.recoveryCodesHandler(rec, NewBearerRequest(http.MethodPost, RecoveryEndpoint, nil))
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+	})
+
+	t.Run("4", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		a.recoveryCodesHandler(rec, NewBearerRequest(http.MethodDelete, RecoveryEndpoint, nil))
+		assert.Equal(t, http.StatusNotImplemented, rec.Code)
+	})
+}
@@ -0,0 +1,295 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/dgrijalva/jwt-go"
+	"github.com/penutty/authservice/env"
+	"github.com/penutty/authservice/history"
+	"github.com/penutty/authservice/mailer"
+	"github.com/penutty/authservice/reset"
+	"github.com/penutty/authservice/tenant"
+	"github.com/penutty/authservice/user"
+	"github.com/penutty/authservice/verification"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+)
+
+var (
+	// PasswordResetURL is the page reset links point to; the reset token is appended as the "token" query parameter.
+	PasswordResetURL = os.Getenv("PasswordResetURL")
+
+	// PasswordMaxAge is how long the password of any user is valid; zero means passwords do not expire.
+	PasswordMaxAge = env.Duration("PasswordMaxAge", 0)
+	// PrivilegedPasswordMaxAge is how long the password of an administrator is valid.
+	PrivilegedPasswordMaxAge = env.Duration("PrivilegedPasswordMaxAge", 90*24*time.Hour)
+	// PasswordChangeLifetime is how long a user whose password expired has to choose a new one.
+	PasswordChangeLifetime = 10 * time.Minute
+
+	ErrorPasswordExpired       = errors.New("Password expired and must be changed.")
+	ErrorPasswordChangeInvalid = errors.New("Form value \"Token\" is invalid.")
+)
+
+// passwordExpired reports whether the password of u, a user of t, is older than the maximum age applying to u.
+func passwordExpired(t *tenant.Tenant, u *user.User) bool {
+	maxAge := PasswordMaxAge
+	if t.PasswordMaxAge > 0 && (maxAge == 0 || t.PasswordMaxAge < maxAge) {
+		maxAge = t.PasswordMaxAge
+	}
+	if isAdministrator(u.ID()) && (maxAge == 0 || PrivilegedPasswordMaxAge < maxAge) {
+		maxAge = PrivilegedPasswordMaxAge
+	}
+	return maxAge > 0 && time.Since(u.PasswordChanged()) > maxAge
+}
+
+// generatePasswordChange returns a token allowing the user with ID id to replace an expired password
+// at PasswordExpiredEndpoint.
+func generatePasswordChange(id string) (string, error) {
+	claims := jwt.MapClaims{
+		"iss": "Auth-Service",
+		"sub": id,
+		"aud": "Auth-Service",
+		"typ": "password_expired",
+		"exp": time.Now().UTC().Add(PasswordChangeLifetime).Unix(),
+		"iat": time.Now().UTC().Unix(),
+	}
+	return signJwt(claims)
+}
+
+// parsePasswordChange returns the user ID of a token generated by generatePasswordChange.
+func parsePasswordChange(token string) (string, error) {
+	claims, err := verification.ParseAudience(token, "Auth-Service")
+	if err != nil {
+		logger(Warn).Println(err)
+		return "", ErrorPasswordChangeInvalid
+	}
+	sub, ok := claims["sub"].(string)
+	if claims["typ"] != "password_expired" || !ok {
+		return "", ErrorPasswordChangeInvalid
+	}
+	return sub, nil
+}
+
+// setPassword replaces the password of userID unless it is one of the previous passwords of the user
+// or too short for the tenant of the user.
+func (a *app) setPassword(userID, password string) error {
+	t, err := a.userTenant(userID)
+	if err != nil {
+		return err
+	}
+	if err := checkTenantPassword(t, password); err != nil {
+		return err
+	}
+	a.h.Check(userID, password, user.AuthDB())
+	if err := a.h.Err(); err != nil {
+		return err
+	}
+	a.c.SetPassword(userID, password, user.AuthDB())
+	if err := a.c.Err(); err != nil {
+		return err
+	}
+	a.h.Record(userID, password, user.AuthDB())
+	return a.h.Err()
+}
+
+func (a *app) passwordForgotHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodPost:
+		if err := a.postPasswordForgot(r); err != nil {
+			genErrorHandler(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusAccepted)
+	default:
+		logger(Error).Println(ErrorMethodNotImplemented)
+		http.Error(w, http.StatusText(http.StatusNotImplemented), http.StatusNotImplemented)
+	}
+}
+
+// postPasswordForgot emails a password reset link to the address of a user.
+// The response does not reveal whether the user exists.
+func (a *app) postPasswordForgot(r *http.Request) error {
+	type body struct {
+		UserID string
+	}
+	b := new(body)
+	if err := json.NewDecoder(r.Body).Decode(b); err != nil {
+		return err
+	}
+	if err := user.CheckUserID(b.UserID); err != nil {
+		return err
+	}
+
+	u := a.c.Fetch(qualify(r, b.UserID), user.AuthDB())
+	if err := a.c.Err(); err != nil {
+		logger(Warn).Println(err)
+		return nil
+	}
+
+	token := a.rs.Issue(u.UserID(), user.AuthDB())
+	if err := a.rs.Err(); err != nil {
+		return err
+	}
+	data := map[string]interface{}{
+		"URL":     PasswordResetURL + "?token=" + url.QueryEscape(token),
+		"Minutes": int(reset.TokenLifetime.Minutes()),
+	}
+	m, err := a.tmpl.Render("password_reset", mailer.Locale(r.Header.Get("Accept-Language")), data)
+	if err != nil {
+		return err
+	}
+	m.To = u.Email()
+	return a.mail.Send(m)
+}
+
+func (a *app) passwordResetHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodPost:
+		if err := a.postPasswordReset(r); err != nil {
+			genErrorHandler(w, err)
+			return
+		}
+	default:
+		logger(Error).Println(ErrorMethodNotImplemented)
+		http.Error(w, http.StatusText(http.StatusNotImplemented), http.StatusNotImplemented)
+	}
+}
+
+// postPasswordReset replaces the password of the user a reset Token was issued to
+// and revokes every token issued to that user.
+func (a *app) postPasswordReset(r *http.Request) error {
+	type body struct {
+		Token    string
+		Password string
+	}
+	b := new(body)
+	if err := json.NewDecoder(r.Body).Decode(b); err != nil {
+		return err
+	}
+	if err := user.CheckPassword(b.Password); err != nil {
+		return err
+	}
+
+	userID := a.rs.Redeem(b.Token, user.AuthDB())
+	if err := a.rs.Err(); err != nil {
+		return err
+	}
+
+	if err := a.setPassword(userID, b.Password); err != nil {
+		return err
+	}
+	a.c.RevokeTokens(userID, user.AuthDB())
+	return a.c.Err()
+}
+
+func (a *app) userPasswordHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodPut:
+		token, refresh, err := a.putUserPassword(r)
+		if err != nil {
+			genErrorHandler(w, err)
+			return
+		}
+		if token != "" {
+			w.Header().Set("jwt", token)
+			w.Header().Set("refresh_token", refresh)
+		}
+	default:
+		logger(Error).Println(ErrorMethodNotImplemented)
+		http.Error(w, http.StatusText(http.StatusNotImplemented), http.StatusNotImplemented)
+	}
+}
+
+// putUserPassword changes the password of the authenticated user. If RevokeSessions is set every
+// other token of the user is revoked and a new access token and refresh token for the caller are returned.
+func (a *app) putUserPassword(r *http.Request) (string, string, error) {
+	u, err := a.authenticateLogin(r)
+	if err != nil {
+		return "", "", err
+	}
+	userID := u.UserID()
+
+	type body struct {
+		Password       string
+		NewPassword    string
+		RevokeSessions bool
+	}
+	b := new(body)
+	if err := json.NewDecoder(r.Body).Decode(b); err != nil {
+		return "", "", err
+	}
+
+	if u.Password() != b.Password {
+		return "", "", ErrorInvalidPass
+	}
+	if b.NewPassword == b.Password {
+		return "", "", history.ErrorPasswordReused
+	}
+
+	if err := a.setPassword(userID, b.NewPassword); err != nil {
+		return "", "", err
+	}
+	if !b.RevokeSessions {
+		return "", "", nil
+	}
+
+	a.c.RevokeTokens(userID, user.AuthDB())
+	if err := a.c.Err(); err != nil {
+		return "", "", err
+	}
+	return a.accessToken(u, r)
+}
+
+func (a *app) passwordExpiredHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodPost:
+		token, refresh, err := a.postPasswordExpired(r)
+		loginResponse(w, token, refresh, err)
+	default:
+		logger(Error).Println(ErrorMethodNotImplemented)
+		http.Error(w, http.StatusText(http.StatusNotImplemented), http.StatusNotImplemented)
+	}
+}
+
+// postPasswordExpired replaces an expired password using the Token returned by AuthEndpoint
+// and completes the login.
+func (a *app) postPasswordExpired(r *http.Request) (string, string, error) {
+	type body struct {
+		Token    string
+		Password string
+	}
+	b := new(body)
+	if err := json.NewDecoder(r.Body).Decode(b); err != nil {
+		return "", "", err
+	}
+
+	id, err := parsePasswordChange(b.Token)
+	if err != nil {
+		return "", "", err
+	}
+	if err := user.CheckPassword(b.Password); err != nil {
+		return "", "", err
+	}
+
+	u := a.c.FetchByID(id, user.AuthDB())
+	if err := a.c.Err(); err != nil {
+		return "", "", err
+	}
+	if err := checkTenant(r, u); err != nil {
+		return "", "", err
+	}
+	userID := u.UserID()
+	if !passwordExpired(tenantOf(r), u) {
+		return "", "", ErrorPasswordChangeInvalid
+	}
+	if u.Password() == b.Password {
+		return "", "", history.ErrorPasswordReused
+	}
+
+	if err := a.setPassword(userID, b.Password); err != nil {
+		return "", "", err
+	}
+	return a.login(u, r)
+}
@@ -0,0 +1,262 @@
+package main
+
+import (
+	"fmt"
+	sq "github.com/Masterminds/squirrel"
+	"github.com/penutty/authservice/history"
+	"github.com/penutty/authservice/mailer"
+	"github.com/penutty/authservice/reset"
+	"github.com/penutty/authservice/tenant"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+type MockResetClient struct {
+	err    error
+	tokens map[string]string
+}
+
+func (m *MockResetClient) Issue(u string, db sq.BaseRunner) string {
+	token, _ := reset.GenerateToken()
+	m.tokens[token] = u
+	return token
+}
+
+func (m *MockResetClient) Redeem(token string, db sq.BaseRunner) string {
+	u, ok := m.tokens[token]
+	if !ok {
+		m.err = reset.ErrorTokenInvalid
+		return ""
+	}
+	delete(m.tokens, token)
+	return u
+}
+
+func (m *MockResetClient) Err() error {
+	return m.err
+}
+
+type MockHistoryClient struct {
+	err       error
+	passwords []string
+}
+
+func (m *MockHistoryClient) Check(u, password string, db sq.BaseRunner) {
+	for _, p := range m.passwords {
+		if p == password {
+			m.err = history.ErrorPasswordReused
+			return
+		}
+	}
+}
+
+func (m *MockHistoryClient) Record(u, password string, db sq.BaseRunner) {
+	m.passwords = append(m.passwords, password)
+}
+
+func (m *MockHistoryClient) Err() error {
+	return m.err
+}
+
+func newPasswordApp() (*app, *MockUserClient, *mailer.Memory) {
+	c := new(MockUserClient)
+	mail := new(mailer.Memory)
+	a := new(app)
+	a.rb = new(MockRBACClient)
+	a.g = new(MockGroupClient)
+	a.ss = NewMockSessionClient()
+	a.c = c
+	a.m = new(MockMFAClient)
+	a.h = &MockHistoryClient{passwords: []string{tPassword}}
+	a.rs = &MockResetClient{tokens: make(map[string]string)}
+	a.mail = mail
+	a.tmpl, _ = mailer.LoadTemplates("mailer/templates")
+	return a, c, mail
+}
+
+func NewResetBody(token, password string) *strings.Reader {
+	return strings.NewReader(fmt.Sprintf("{\"Token\": \"%s\", \"Password\": \"%s\"}", token, password))
+}
+
+func Test_passwordForgotHandler(t *testing.T) {
+	a, _, mail := newPasswordApp()
+	body := func(u string) *strings.Reader {
+		return strings.NewReader(fmt.Sprintf("{\"UserID\": \"%s\"}", u))
+	}
+
+	testVars := []*RequestCodePair{
+		&RequestCodePair{httptest.NewRequest(http.MethodPost, PasswordForgotEndpoint, body(tUser)), http.StatusAccepted},
+		&RequestCodePair{httptest.NewRequest(http.MethodPost, PasswordForgotEndpoint, body("fail")), http.StatusBadRequest},
+		&RequestCodePair{httptest.NewRequest(http.MethodGet, PasswordForgotEndpoint, nil), http.StatusNotImplemented},
+	}
+	for i, v := range testVars {
+		rec := httptest.NewRecorder()
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			a.passwordForgotHandler(rec, v.req)
+			assert.Equal(t, v.code, rec.Code)
+		})
+	}
+	assert.Len(t, mail.Messages, 1)
+	assert.Equal(t, tEmail, mail.Last().To)
+}
+
+func Test_passwordReset(t *testing.T) {
+	a, c, mail := newPasswordApp()
+
+	rec := httptest.NewRecorder()
+	body := strings.NewReader(fmt.Sprintf("{\"UserID\": \"%s\"}", tUser))
+	a.passwordForgotHandler(rec, httptest.NewRequest(http.MethodPost, PasswordForgotEndpoint, body))
+	assert.Equal(t, http.StatusAccepted, rec.Code)
+
+	link := mail.Last().Text[strings.Index(mail.Last().Text, "token=")+len("token="):]
+	token, err := url.QueryUnescape(strings.Fields(link)[0])
+	assert.Nil(t, err)
+
+	newPassword := "NewPassword456?"
+	testVars := []*RequestCodePair{
+		&RequestCodePair{httptest.NewRequest(http.MethodPost, PasswordResetEndpoint, NewResetBody(token, "weak")), http.StatusBadRequest},
+		&RequestCodePair{httptest.NewRequest(http.MethodPost, PasswordResetEndpoint, NewResetBody(token, newPassword)), http.StatusOK},
+		&RequestCodePair{httptest.NewRequest(http.MethodPost, PasswordResetEndpoint, NewResetBody(a.rs.Issue(tUser, nil), tPassword)), http.StatusBadRequest},
+		&RequestCodePair{httptest.NewRequest(http.MethodPost, PasswordResetEndpoint, NewResetBody(token, newPassword)), http.StatusUnauthorized},
+		&RequestCodePair{httptest.NewRequest(http.MethodGet, PasswordResetEndpoint, nil), http.StatusNotImplemented},
+	}
+	for i, v := range testVars {
+		rec := httptest.NewRecorder()
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			a.passwordResetHandler(rec, v.req)
+			assert.Equal(t, v.code, rec.Code)
+		})
+	}
+	assert.Equal(t, newPassword, c.password)
+	assert.False(t, c.revoked.IsZero())
+	assert.Contains(t, a.h.(*MockHistoryClient).passwords, newPassword)
+}
+
+func NewPasswordChangeBody(current, password string, revoke bool) *strings.Reader {
+	return strings.NewReader(fmt.Sprintf("{\"Password\": \"%s\", \"NewPassword\": \"%s\", \"RevokeSessions\": %t}", current, password, revoke))
+}
+
+func Test_userPasswordHandler(t *testing.T) {
+	newPassword := "NewPassword456?"
+
+	type test struct {
+		req     *http.Request
+		code    int
+		jwt     bool
+		revoked bool
+	}
+	cases := []*test{
+		&test{NewBearerRequest(http.MethodPut, UserPasswordEndpoint, NewPasswordChangeBody(tPassword, newPassword, false)), http.StatusOK, false, false},
+		&test{NewBearerRequest(http.MethodPut, UserPasswordEndpoint, NewPasswordChangeBody(tPassword, newPassword, true)), http.StatusOK, true, true},
+		&test{NewBearerRequest(http.MethodPut, UserPasswordEndpoint, NewPasswordChangeBody("WrongPassword1!", newPassword, false)), http.StatusBadRequest, false, false},
+		&test{NewBearerRequest(http.MethodPut, UserPasswordEndpoint, NewPasswordChangeBody(tPassword, tPassword, false)), http.StatusBadRequest, false, false},
+		&test{NewBearerRequest(http.MethodPut, UserPasswordEndpoint, NewPasswordChangeBody(tPassword, "OldPassword789#", false)), http.StatusBadRequest, false, false},
+		&test{NewBearerRequest(http.MethodPut, UserPasswordEndpoint, NewPasswordChangeBody(tPassword, "weak", false)), http.StatusBadRequest, false, false},
+		&test{httptest.NewRequest(http.MethodPut, UserPasswordEndpoint, NewPasswordChangeBody(tPassword, newPassword, false)), http.StatusUnauthorized, false, false},
+		&test{NewBearerRequest(http.MethodPost, UserPasswordEndpoint, nil), http.StatusNotImplemented, false, false},
+	}
+	for i, c := range cases {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			a, m, _ := newPasswordApp()
+			a.h.(*MockHistoryClient).passwords = append(a.h.(*MockHistoryClient).passwords, "OldPassword789#")
+			rec := httptest.NewRecorder()
+			a.userPasswordHandler(rec, c.req)
+			assert.Equal(t, c.code, rec.Code)
+			assert.Equal(t, c.jwt, rec.Header().Get("jwt") != "")
+			assert.Equal(t, c.revoked, !m.revoked.IsZero())
+			if c.code == http.StatusOK {
+				assert.Equal(t, newPassword, m.password)
+			}
+		})
+	}
+}
+
+func Test_passwordExpired(t *testing.T) {
+	defer func(maxAge time.Duration, admins []string) {
+		PasswordMaxAge, Administrators = maxAge, admins
+	}(PasswordMaxAge, Administrators)
+	Administrators = administrators(tOtherUser)
+
+	type test struct {
+		maxAge       time.Duration
+		tenantMaxAge time.Duration
+		userID       string
+		age          time.Duration
+		expired      bool
+	}
+	cases := []*test{
+		&test{0, 0, tUser, 365 * 24 * time.Hour, false},
+		&test{0, 0, tOtherUser, 365 * 24 * time.Hour, true},
+		&test{0, 0, tOtherUser, time.Hour, false},
+		&test{30 * 24 * time.Hour, 0, tUser, 31 * 24 * time.Hour, true},
+		&test{30 * 24 * time.Hour, 0, tUser, 29 * 24 * time.Hour, false},
+		&test{365 * 24 * time.Hour, 0, tOtherUser, 91 * 24 * time.Hour, true},
+		&test{0, 7 * 24 * time.Hour, tUser, 8 * 24 * time.Hour, true},
+		&test{30 * 24 * time.Hour, 7 * 24 * time.Hour, tUser, 8 * 24 * time.Hour, true},
+		&test{7 * 24 * time.Hour, 30 * 24 * time.Hour, tUser, 8 * 24 * time.Hour, true},
+		&test{0, 30 * 24 * time.Hour, tUser, 8 * 24 * time.Hour, false},
+	}
+	for i, c := range cases {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			PasswordMaxAge = c.maxAge
+			m := &MockUserClient{changed: time.Now().UTC().Add(-c.age)}
+			tn := &tenant.Tenant{ID: tTenant, PasswordMaxAge: c.tenantMaxAge}
+			assert.Equal(t, c.expired, passwordExpired(tn, m.Fetch(c.userID, nil)))
+		})
+	}
+}
+
+func NewAuthResponse(a *app) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	a.authHandler(rec, httptest.NewRequest(http.MethodPost, AuthEndpoint, NewAuthBody(tUser, tPassword)))
+	return rec
+}
+
+func Test_passwordExpiredHandler(t *testing.T) {
+	defer func(maxAge time.Duration) { PasswordMaxAge = maxAge }(PasswordMaxAge)
+	PasswordMaxAge = 30 * 24 * time.Hour
+
+	a, c, _ := newPasswordApp()
+	a.l = NewMockLockoutClient()
+	c.changed = time.Now().UTC().Add(-31 * 24 * time.Hour)
+
+	rec := NewAuthResponse(a)
+	assert.Equal(t, http.StatusForbidden, rec.Code)
+	assert.Empty(t, rec.Header().Get("jwt"))
+	token := rec.Header().Get("password_expired")
+	assert.NotEmpty(t, token)
+
+	challenge, err := generateChallenge(tID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	newPassword := "NewPassword456?"
+	testVars := []*RequestCodePair{
+		&RequestCodePair{httptest.NewRequest(http.MethodPost, PasswordExpiredEndpoint, NewResetBody(challenge, newPassword)), http.StatusUnauthorized},
+		&RequestCodePair{httptest.NewRequest(http.MethodPost, PasswordExpiredEndpoint, NewResetBody(token, "weak")), http.StatusBadRequest},
+		&RequestCodePair{httptest.NewRequest(http.MethodPost, PasswordExpiredEndpoint, NewResetBody(token, tPassword)), http.StatusBadRequest},
+		&RequestCodePair{httptest.NewRequest(http.MethodPost, PasswordExpiredEndpoint, NewResetBody(token, newPassword)), http.StatusOK},
+		&RequestCodePair{httptest.NewRequest(http.MethodPost, PasswordExpiredEndpoint, NewResetBody(token, "OtherPassword789#")), http.StatusUnauthorized},
+		&RequestCodePair{httptest.NewRequest(http.MethodGet, PasswordExpiredEndpoint, nil), http.StatusNotImplemented},
+	}
+	for i, v := range testVars {
+		rec := httptest.NewRecorder()
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			a.passwordExpiredHandler(rec, v.req)
+			assert.Equal(t, v.code, rec.Code)
+			if v.code == http.StatusOK {
+				assert.NotEmpty(t, rec.Header().Get("jwt"))
+			}
+		})
+	}
+	assert.Equal(t, newPassword, c.password)
+
+	rec = NewAuthResponse(a)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+}
@@ -0,0 +1,237 @@
+// Package passwordless is dedicated to reading and writing email login codes in Auth-Db.
+// A user may hold one outstanding login secret at a time: either a short numeric code or the
+// random secret embedded in a signed login link. Only a hash of the secret is stored.
+package passwordless
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	sq "github.com/Masterminds/squirrel"
+	"log"
+	"math/big"
+	"time"
+)
+
+var (
+	CodeDigits   = 6
+	CodeLifetime = 10 * time.Minute
+	LinkLifetime = 15 * time.Minute
+	MaxAttempts  = 5
+	// AttemptWindow bounds how long attempts carry over to reissued secrets, counted from the secret that started
+	// the count. Afterwards a reissued secret starts with MaxAttempts again, so that exhausting the attempts of a
+	// user and requesting new secrets cannot lock the user out of email login for good.
+	AttemptWindow = time.Hour
+
+	ErrorUserIDParameterInvalid = errors.New("Code.userID must be a valid userID.")
+	ErrorCodeInvalid            = errors.New("Login code is invalid.")
+	ErrorCodeExpired            = errors.New("Login code has expired.")
+	ErrorAttemptsExceeded       = errors.New("Too many invalid login code attempts; request a new code.")
+	ErrorCodeRowNotCreated      = errors.New("Issue failed to create one row in the auth.LoginCodes table.")
+)
+
+type Client interface {
+	Issuer
+	Redeemer
+	Err() error
+}
+
+type Issuer interface {
+	IssueCode(string, sq.BaseRunner) string
+	IssueLink(string, sq.BaseRunner) string
+}
+
+type Redeemer interface {
+	Redeem(string, string, sq.BaseRunner)
+}
+
+type CodeClient struct {
+	err error
+}
+
+// GenerateCode returns a random numeric code of CodeDigits digits.
+func GenerateCode() (string, error) {
+	max := new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(CodeDigits)), nil)
+	n, err := rand.Int(rand.Reader, max)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%0*d", CodeDigits, n), nil
+}
+
+// GenerateLinkSecret returns a random secret for a login link.
+func GenerateLinkSecret() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// HashSecret returns the hex encoded SHA-256 digest of a login secret as stored in auth.LoginCodes.
+func HashSecret(secret string) string {
+	h := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(h[:])
+}
+
+// IssueCode generates a numeric login code for userID, replacing any outstanding secret, and returns it.
+func (cc *CodeClient) IssueCode(userID string, db sq.BaseRunner) string {
+	code, err := GenerateCode()
+	if err != nil {
+		cc.err = err
+		return ""
+	}
+	cc.issue(userID, code, CodeLifetime, db)
+	return code
+}
+
+// IssueLink generates a login link secret for userID, replacing any outstanding secret, and returns it.
+func (cc *CodeClient) IssueLink(userID string, db sq.BaseRunner) string {
+	secret, err := GenerateLinkSecret()
+	if err != nil {
+		cc.err = err
+		return ""
+	}
+	cc.issue(userID, secret, LinkLifetime, db)
+	return secret
+}
+
+// issue replaces the outstanding secret of userID with secret. The attempts made against an outstanding secret
+// that has not expired carry over within AttemptWindow, so that requesting a new code does not grant new guesses.
+func (cc *CodeClient) issue(userID, secret string, lifetime time.Duration, db sq.BaseRunner) {
+	if cc.err != nil {
+		return
+	}
+	if userID == "" {
+		cc.err = ErrorUserIDParameterInvalid
+		return
+	}
+
+	now := time.Now().UTC()
+	attempts, window := 0, now
+	sel := sq.Select("[Attempts], [WindowStart]").From("[auth].[LoginCodes]").
+		Where(sq.Eq{"[UserID]": userID}).
+		Where(sq.Gt{"[Expires]": now}).
+		Where(sq.Gt{"[WindowStart]": now.Add(-AttemptWindow)})
+	if err := sel.RunWith(db).QueryRow().Scan(&attempts, &window); err != nil && err != sql.ErrNoRows {
+		log.Print(err)
+		cc.err = err
+		return
+	}
+
+	del := sq.Delete("[auth].[LoginCodes]").Where(sq.Eq{"[UserID]": userID})
+	if _, err := del.RunWith(db).Exec(); err != nil {
+		log.Print(err)
+		cc.err = err
+		return
+	}
+
+	insert := sq.Insert("[auth].[LoginCodes]").
+		Columns("[UserID]", "[Code]", "[Expires]", "[Attempts]", "[WindowStart]").
+		Values(userID, HashSecret(secret), now.Add(lifetime), attempts, window)
+	res, err := insert.RunWith(db).Exec()
+	if err != nil {
+		log.Print(err)
+		cc.err = err
+		return
+	}
+	if cnt, err := res.RowsAffected(); err != nil || cnt != 1 {
+		log.Print(ErrorCodeRowNotCreated)
+		cc.err = ErrorCodeRowNotCreated
+	}
+}
+
+// Redeem consumes the outstanding login secret of userID if it matches secret. Every attempt is counted
+// before the secret is compared, in a single UPDATE, so parallel guesses cannot exceed MaxAttempts. Once
+// expired the secret is discarded; once its attempts are exhausted it is kept until it expires so that its
+// count carries over to reissued secrets within AttemptWindow.
+func (cc *CodeClient) Redeem(userID, secret string, db sq.BaseRunner) {
+	if cc.err != nil {
+		return
+	}
+
+	now := time.Now().UTC()
+	count := sq.Update("[auth].[LoginCodes]").
+		Set("[Attempts]", sq.Expr("[Attempts] + 1")).
+		Where(sq.Eq{"[UserID]": userID}).
+		Where(sq.Lt{"[Attempts]": MaxAttempts}).
+		Where(sq.Gt{"[Expires]": now})
+	res, err := count.RunWith(db).Exec()
+	if err != nil {
+		log.Print(err)
+		cc.err = err
+		return
+	}
+	if cnt, err := res.RowsAffected(); err != nil || cnt != 1 {
+		cc.refuse(userID, now, db)
+		return
+	}
+
+	var hash string
+	sel := sq.Select("[Code]").From("[auth].[LoginCodes]").Where(sq.Eq{"[UserID]": userID})
+	err = sel.RunWith(db).QueryRow().Scan(&hash)
+	if err == sql.ErrNoRows {
+		cc.err = ErrorCodeInvalid
+		return
+	}
+	if err != nil {
+		log.Print(err)
+		cc.err = err
+		return
+	}
+	if subtle.ConstantTimeCompare([]byte(hash), []byte(HashSecret(secret))) != 1 {
+		cc.err = ErrorCodeInvalid
+		return
+	}
+
+	del := sq.Delete("[auth].[LoginCodes]").Where(sq.Eq{"[UserID]": userID, "[Code]": hash})
+	res, err = del.RunWith(db).Exec()
+	if err != nil {
+		log.Print(err)
+		cc.err = err
+		return
+	}
+	if cnt, err := res.RowsAffected(); err != nil || cnt != 1 {
+		cc.err = ErrorCodeInvalid
+	}
+}
+
+// refuse sets the error of an attempt that could not be counted: there is no outstanding secret, it has
+// expired, or its attempts are exhausted. Expired secrets are discarded.
+func (cc *CodeClient) refuse(userID string, now time.Time, db sq.BaseRunner) {
+	var (
+		expires  time.Time
+		attempts int
+	)
+	sel := sq.Select("[Expires], [Attempts]").From("[auth].[LoginCodes]").Where(sq.Eq{"[UserID]": userID})
+	err := sel.RunWith(db).QueryRow().Scan(&expires, &attempts)
+	switch {
+	case err == sql.ErrNoRows:
+		cc.err = ErrorCodeInvalid
+	case err != nil:
+		log.Print(err)
+		cc.err = err
+	case !now.Before(expires):
+		cc.discard(userID, db)
+		cc.err = ErrorCodeExpired
+	default:
+		cc.err = ErrorAttemptsExceeded
+	}
+}
+
+func (cc *CodeClient) discard(userID string, db sq.BaseRunner) {
+	del := sq.Delete("[auth].[LoginCodes]").Where(sq.Eq{"[UserID]": userID})
+	if _, err := del.RunWith(db).Exec(); err != nil {
+		log.Print(err)
+	}
+}
+
+// Err returns the error status of a CodeClient instance.
+func (cc *CodeClient) Err() error {
+	return cc.err
+}
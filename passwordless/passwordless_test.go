@@ -0,0 +1,177 @@
+package passwordless
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/DATA-DOG/go-sqlmock.v1"
+	"regexp"
+	"strconv"
+	"testing"
+	"time"
+)
+
+var (
+	tUser = "testuser"
+	tCode = "123456"
+)
+
+func Test_GenerateCode(t *testing.T) {
+	for i := 0; i < 20; i++ {
+		code, err := GenerateCode()
+		assert.Nil(t, err)
+		assert.Regexp(t, regexp.MustCompile(`^[0-9]{6}$`), code)
+	}
+}
+
+func Test_GenerateLinkSecret(t *testing.T) {
+	a, err := GenerateLinkSecret()
+	assert.Nil(t, err)
+	b, err := GenerateLinkSecret()
+	assert.Nil(t, err)
+	assert.Len(t, a, 43)
+	assert.NotEqual(t, a, b)
+}
+
+// recent matches a time within the last minute.
+type recent struct{}
+
+func (recent) Match(v driver.Value) bool {
+	t, ok := v.(time.Time)
+	return ok && time.Since(t) < time.Minute
+}
+
+// equal matches a time equal to t.
+type equal struct {
+	t time.Time
+}
+
+func (e equal) Match(v driver.Value) bool {
+	t, ok := v.(time.Time)
+	return ok && t.Equal(e.t)
+}
+
+func Test_IssueCode(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("An error occured when opening a stub database connection. ERROR: %v\n", err)
+	}
+	defer db.Close()
+
+	// A window that is over does not match the query, so it yields no rows like a missing or expired secret.
+	window := time.Now().UTC().Add(-time.Minute)
+	cases := []struct {
+		outstanding *sqlmock.Rows
+		attempts    int
+		window      sqlmock.Argument
+	}{
+		{sqlmock.NewRows([]string{"Attempts", "WindowStart"}), 0, recent{}},
+		{sqlmock.NewRows([]string{"Attempts", "WindowStart"}).AddRow(3, window), 3, equal{window}},
+	}
+	for i, c := range cases {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			mock.ExpectQuery(`SELECT \[Attempts], \[WindowStart] FROM \[auth]\.\[LoginCodes] WHERE \[UserID] = \? AND \[Expires] > \? AND \[WindowStart] > \?`).
+				WithArgs(tUser, sqlmock.AnyArg(), sqlmock.AnyArg()).
+				WillReturnRows(c.outstanding)
+			mock.ExpectExec(`DELETE FROM \[auth]\.\[LoginCodes] WHERE \[UserID] = \?`).
+				WithArgs(tUser).
+				WillReturnResult(sqlmock.NewResult(0, 1))
+			mock.ExpectExec(`INSERT INTO \[auth]\.\[LoginCodes] \(\[UserID],\[Code],\[Expires],\[Attempts],\[WindowStart]\) VALUES \(\?,\?,\?,\?,\?\)`).
+				WithArgs(tUser, sqlmock.AnyArg(), sqlmock.AnyArg(), c.attempts, c.window).
+				WillReturnResult(sqlmock.NewResult(1, 1))
+
+			cc := new(CodeClient)
+			code := cc.IssueCode(tUser, db)
+			assert.Nil(t, cc.Err())
+			assert.Len(t, code, CodeDigits)
+
+			if err = mock.ExpectationsWereMet(); err != nil {
+				t.Errorf("Expectations were not met. ERROR: %v\n", err)
+			}
+		})
+	}
+
+	t.Run(strconv.Itoa(len(cases)), func(t *testing.T) {
+		cc := new(CodeClient)
+		_ = cc.IssueLink("", db)
+		assert.EqualError(t, cc.Err(), ErrorUserIDParameterInvalid.Error())
+	})
+}
+
+func Test_Redeem(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("An error occured when opening a stub database connection. ERROR: %v\n", err)
+	}
+	defer db.Close()
+
+	columns := []string{"Expires", "Attempts"}
+	count := `UPDATE \[auth]\.\[LoginCodes] SET \[Attempts] = \[Attempts] \+ 1 WHERE \[UserID] = \? AND \[Attempts] < \? AND \[Expires] > \?`
+	future := time.Now().UTC().Add(time.Minute)
+
+	t.Run("valid", func(t *testing.T) {
+		mock.ExpectExec(count).
+			WithArgs(tUser, MaxAttempts, sqlmock.AnyArg()).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectQuery(`SELECT \[Code] FROM \[auth]\.\[LoginCodes] WHERE \[UserID] = \?`).
+			WithArgs(tUser).
+			WillReturnRows(sqlmock.NewRows([]string{"Code"}).AddRow(HashSecret(tCode)))
+		mock.ExpectExec(`DELETE FROM \[auth]\.\[LoginCodes] WHERE \[Code] = \? AND \[UserID] = \?`).
+			WithArgs(HashSecret(tCode), tUser).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+
+		cc := new(CodeClient)
+		cc.Redeem(tUser, tCode, db)
+		assert.Nil(t, cc.Err())
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		mock.ExpectExec(count).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectQuery(`SELECT \[Code] FROM \[auth]\.\[LoginCodes]`).
+			WillReturnRows(sqlmock.NewRows([]string{"Code"}).AddRow(HashSecret(tCode)))
+
+		cc := new(CodeClient)
+		cc.Redeem(tUser, "654321", db)
+		assert.EqualError(t, cc.Err(), ErrorCodeInvalid.Error())
+	})
+
+	t.Run("exceeded", func(t *testing.T) {
+		mock.ExpectExec(count).
+			WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectQuery(`SELECT \[Expires], \[Attempts] FROM \[auth]\.\[LoginCodes] WHERE \[UserID] = \?`).
+			WillReturnRows(sqlmock.NewRows(columns).AddRow(future, MaxAttempts))
+
+		cc := new(CodeClient)
+		cc.Redeem(tUser, tCode, db)
+		assert.EqualError(t, cc.Err(), ErrorAttemptsExceeded.Error())
+	})
+
+	t.Run("expired", func(t *testing.T) {
+		mock.ExpectExec(count).
+			WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectQuery(`SELECT \[Expires], \[Attempts] FROM \[auth]\.\[LoginCodes]`).
+			WillReturnRows(sqlmock.NewRows(columns).AddRow(time.Now().UTC().Add(-time.Minute), 0))
+		mock.ExpectExec(`DELETE FROM \[auth]\.\[LoginCodes] WHERE \[UserID] = \?`).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+
+		cc := new(CodeClient)
+		cc.Redeem(tUser, tCode, db)
+		assert.EqualError(t, cc.Err(), ErrorCodeExpired.Error())
+	})
+
+	t.Run("missing", func(t *testing.T) {
+		mock.ExpectExec(count).
+			WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectQuery(`SELECT \[Expires], \[Attempts] FROM \[auth]\.\[LoginCodes]`).
+			WillReturnError(sql.ErrNoRows)
+
+		cc := new(CodeClient)
+		cc.Redeem(tUser, tCode, db)
+		assert.EqualError(t, cc.Err(), ErrorCodeInvalid.Error())
+	})
+
+	if err = mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Expectations were not met. ERROR: %v\n", err)
+	}
+}
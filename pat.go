@@ -0,0 +1,185 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/penutty/authservice/audit"
+	"github.com/penutty/authservice/pat"
+	"github.com/penutty/authservice/user"
+	"net/http"
+	"time"
+)
+
+// Scopes a personal access token needs for the endpoints accepting one. Without them, it is refused with ErrorPATScope.
+const (
+	ScopeUserRead            = "user:read"
+	ScopeTokensRead          = "tokens:read"
+	ScopeSessionsRead        = "sessions:read"
+	ScopeStatusRead          = "status:read"
+	ScopeGroupsRead          = "groups:read"
+	ScopeRolesRead           = "roles:read"
+	ScopeServiceAccountsRead = "serviceaccounts:read"
+)
+
+var (
+	ErrorPATForbidden = errors.New("Personal access tokens cannot manage credentials, tokens or other users; use an access token.")
+	ErrorPATScope     = errors.New("Personal access token does not have the scope the request requires.")
+)
+
+// patResource is the metadata of a personal access token returned by UserTokensEndpoint. Token is only set in
+// the response creating it; Auth-Service cannot show it again.
+type patResource struct {
+	TokenID  string
+	Name     string
+	Scopes   []string
+	Created  time.Time
+	Expires  *time.Time `json:",omitempty"`
+	LastUsed *time.Time `json:",omitempty"`
+	Token    string     `json:",omitempty"`
+}
+
+func newPATResource(t *pat.Token) *patResource {
+	res := &patResource{TokenID: t.TokenID, Name: t.Name, Scopes: t.Scopes, Created: t.Created}
+	if res.Scopes == nil {
+		res.Scopes = []string{}
+	}
+	if !t.Expires.IsZero() {
+		res.Expires = &t.Expires
+	}
+	if !t.LastUsed.IsZero() {
+		res.LastUsed = &t.LastUsed
+	}
+	return res
+}
+
+func (a *app) userTokensHandler(w http.ResponseWriter, r *http.Request) {
+	var (
+		res interface{}
+		err error
+	)
+	switch r.Method {
+	case http.MethodGet:
+		res, err = a.getUserTokens(r)
+	case http.MethodPost:
+		res, err = a.postUserToken(r)
+		if err == nil {
+			w.Header().Set("Content-Type", "application/json")
+			w.Header().Set("Cache-Control", "no-store")
+			w.WriteHeader(http.StatusCreated)
+			if err := json.NewEncoder(w).Encode(res); err != nil {
+				logger(Error).Println(err)
+			}
+			return
+		}
+	case http.MethodDelete:
+		if err := a.deleteUserToken(r); err != nil {
+			genErrorHandler(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return
+	default:
+		logger(Error).Println(ErrorMethodNotImplemented)
+		http.Error(w, http.StatusText(http.StatusNotImplemented), http.StatusNotImplemented)
+		return
+	}
+	if err != nil {
+		genErrorHandler(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(res); err != nil {
+		logger(Error).Println(err)
+	}
+}
+
+// getUserTokens returns the metadata of the personal access tokens of the caller, or as an administrator of any user.
+func (a *app) getUserTokens(r *http.Request) ([]*patResource, error) {
+	_, u, err := a.targetUser(r, ScopeTokensRead)
+	if err != nil {
+		return nil, err
+	}
+	tokens := a.pt.List(u.ID(), user.AuthDB())
+	if err := a.pt.Err(); err != nil {
+		return nil, err
+	}
+	res := make([]*patResource, 0, len(tokens))
+	for _, t := range tokens {
+		res = append(res, newPATResource(t))
+	}
+	return res, nil
+}
+
+// postUserToken creates a personal access token of the caller with the Name, Scopes and optional Expires in the
+// body. A personal access token cannot create another one, so that a leaked token cannot outlive its revocation.
+// The creation is recorded in the audit log.
+func (a *app) postUserToken(r *http.Request) (*patResource, error) {
+	u, err := a.authenticateLogin(r)
+	if err != nil {
+		return nil, err
+	}
+
+	type body struct {
+		Name    string
+		Scopes  []string
+		Expires time.Time
+	}
+	b := new(body)
+	if err := json.NewDecoder(r.Body).Decode(b); err != nil {
+		return nil, err
+	}
+	t := &pat.Token{Owner: u.ID(), Name: b.Name, Scopes: b.Scopes, Expires: b.Expires.UTC()}
+	secret := a.pt.Create(t, user.AuthDB())
+	if err := a.pt.Err(); err != nil {
+		return nil, err
+	}
+
+	a.au.Record(audit.NewEntry(u.ID(), u.ID(), "pat_create", t.TokenID), user.AuthDB())
+	if err := a.au.Err(); err != nil {
+		logger(Error).Println(err)
+	}
+	res := newPATResource(t)
+	res.Token = secret
+	return res, nil
+}
+
+// deleteUserToken revokes the personal access token in the "TokenID" query parameter of the caller, or as an
+// administrator of any user. The revocation is recorded in the audit log.
+func (a *app) deleteUserToken(r *http.Request) error {
+	caller, u, err := a.loginTargetUser(r)
+	if err != nil {
+		return err
+	}
+	tokenID := r.URL.Query().Get("TokenID")
+	a.pt.Revoke(u.ID(), tokenID, user.AuthDB())
+	if err := a.pt.Err(); err != nil {
+		return err
+	}
+
+	a.au.Record(audit.NewEntry(caller.ID(), u.ID(), "pat_revoke", tokenID), user.AuthDB())
+	if err := a.au.Err(); err != nil {
+		logger(Error).Println(err)
+	}
+	return nil
+}
+
+// personalAccessToken returns the owner of the personal access token and its metadata. It returns ErrorTokenRevoked
+// if the token was created before the tokens of its owner were revoked, e.g. by a password reset.
+func (a *app) personalAccessToken(token string) (*user.User, *pat.Token, error) {
+	t, err := a.pt.Authenticate(token, user.AuthDB())
+	if err != nil {
+		return nil, nil, err
+	}
+	u := a.c.FetchByID(t.Owner, user.AuthDB())
+	if err := a.c.Err(); err != nil {
+		return nil, nil, err
+	}
+	revoked := a.c.Revoked(u.UserID(), user.AuthDB())
+	if err := a.c.Err(); err != nil {
+		return nil, nil, err
+	}
+	if t.Created.Before(revoked) {
+		return nil, nil, ErrorTokenRevoked
+	}
+	return u, t, nil
+}
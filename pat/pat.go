@@ -0,0 +1,311 @@
+// Package pat is dedicated to reading and writing personal access tokens in Auth-Db. Users create them for
+// scripts and CI in place of their passwords. A token is only known to its owner; Auth-Db holds its hash,
+// so tokens are looked up by their hash like password reset tokens.
+//
+// Tokens start with Prefix and end with a checksum of the rest, so that secret scanners can recognize them
+// with the regular expression `asp_[A-Za-z0-9_-]{40}[0-9a-f]{8}` and tell them from random strings.
+package pat
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	sq "github.com/Masterminds/squirrel"
+	"github.com/penutty/authservice/user"
+	"hash/crc32"
+	"log"
+	"regexp"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// Prefix starts every personal access token.
+const Prefix = "asp_"
+
+var (
+	NameMaxLength = 64
+	MaxScopes     = 32
+
+	ErrorNameInvalid     = errors.New("Name must be 1 to 64 characters.")
+	ErrorScopeInvalid    = errors.New("Scopes must be at most 32 names of lowercase letters, digits, ':', '.', '_' or '-'.")
+	ErrorExpiresInvalid  = errors.New("Expires must be in the future.")
+	ErrorTokenInvalid    = errors.New("Personal access token is invalid.")
+	ErrorTokenExpired    = errors.New("Personal access token has expired.")
+	ErrorTokenNotFound   = errors.New("Personal access token does not exist.")
+	ErrorNameInUse       = errors.New("Name is already used by another personal access token of the user.")
+	ErrorTokenNotCreated = errors.New("Create failed to create one row in the auth.PersonalAccessTokens table.")
+
+	scopeRegexp = regexp.MustCompile(`^[a-z0-9:._-]{1,64}$`)
+)
+
+// Token is the metadata of a personal access token of the user with ID Owner. Expires and LastUsed are zero
+// if the token does not expire or was never used.
+type Token struct {
+	TokenID  string
+	Owner    string
+	Name     string
+	Scopes   []string
+	Created  time.Time
+	Expires  time.Time
+	LastUsed time.Time
+}
+
+// Check returns an error if t is invalid.
+func (t *Token) Check() error {
+	if n := utf8.RuneCountInString(t.Name); n == 0 || n > NameMaxLength {
+		return ErrorNameInvalid
+	}
+	if len(t.Scopes) > MaxScopes {
+		return ErrorScopeInvalid
+	}
+	for _, s := range t.Scopes {
+		if !scopeRegexp.MatchString(s) {
+			return ErrorScopeInvalid
+		}
+	}
+	if !t.Expires.IsZero() && !t.Expires.After(time.Now().UTC()) {
+		return ErrorExpiresInvalid
+	}
+	return nil
+}
+
+// HasScope reports whether t was granted scope.
+func (t *Token) HasScope(scope string) bool {
+	for _, s := range t.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// Generate returns a new personal access token: Prefix, 40 random characters and their checksum.
+func Generate() (string, error) {
+	return GenerateWith(Prefix)
+}
+
+// GenerateWith returns a new token in the format of personal access tokens starting with prefix instead of
+// Prefix, for other secrets that secret scanners should recognize.
+func GenerateWith(prefix string) (string, error) {
+	b := make([]byte, 30)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	body := base64.RawURLEncoding.EncodeToString(b)
+	return prefix + body + checksum(prefix, body), nil
+}
+
+func checksum(prefix, body string) string {
+	return fmt.Sprintf("%08x", crc32.ChecksumIEEE([]byte(prefix+body)))
+}
+
+// Valid reports whether token has the form of a personal access token and a correct checksum.
+func Valid(token string) bool {
+	return ValidWith(token, Prefix)
+}
+
+// ValidWith reports whether token was generated by GenerateWith with prefix and is intact.
+func ValidWith(token, prefix string) bool {
+	if len(token) != len(prefix)+48 || !strings.HasPrefix(token, prefix) {
+		return false
+	}
+	body := token[len(prefix) : len(token)-8]
+	return token[len(token)-8:] == checksum(prefix, body)
+}
+
+// HashToken returns the hex encoded SHA-256 digest of a personal access token as stored in auth.PersonalAccessTokens.
+func HashToken(token string) string {
+	h := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(h[:])
+}
+
+type Client interface {
+	Creater
+	Lister
+	Revoker
+	Authenticator
+	Err() error
+}
+
+type Creater interface {
+	Create(*Token, sq.BaseRunner) string
+}
+
+type Lister interface {
+	List(string, sq.BaseRunner) []*Token
+}
+
+type Revoker interface {
+	Revoke(string, string, sq.BaseRunner)
+}
+
+type Authenticator interface {
+	Authenticate(string, sq.BaseRunner) (*Token, error)
+}
+
+type TokenClient struct {
+	err error
+}
+
+// Create inserts t into the auth.PersonalAccessTokens table in db and returns the token, which is not stored.
+// t is given a TokenID and its creation time.
+func (tc *TokenClient) Create(t *Token, db sq.BaseRunner) string {
+	if tc.err != nil {
+		return ""
+	}
+	if err := t.Check(); err != nil {
+		tc.err = err
+		return ""
+	}
+	token, err := Generate()
+	if err != nil {
+		tc.err = err
+		return ""
+	}
+	if t.TokenID, err = user.NewID(); err != nil {
+		tc.err = err
+		return ""
+	}
+	t.Created = time.Now().UTC()
+
+	var expires *time.Time
+	if !t.Expires.IsZero() {
+		expires = &t.Expires
+	}
+	insert := sq.Insert("[auth].[PersonalAccessTokens]").
+		Columns("[TokenID]", "[ID]", "[Name]", "[Hash]", "[Scopes]", "[Created]", "[Expires]").
+		Values(t.TokenID, t.Owner, t.Name, HashToken(token), strings.Join(t.Scopes, " "), t.Created, expires)
+	res, err := insert.RunWith(db).Exec()
+	if err != nil {
+		log.Print(err)
+		if strings.Contains(err.Error(), "UQ_PersonalAccessTokens_Name") {
+			tc.err = ErrorNameInUse
+		} else {
+			tc.err = err
+		}
+		return ""
+	}
+	if cnt, err := res.RowsAffected(); err != nil || cnt != 1 {
+		log.Print(ErrorTokenNotCreated)
+		tc.err = ErrorTokenNotCreated
+		return ""
+	}
+	return token
+}
+
+var columns = []string{"[TokenID]", "[ID]", "[Name]", "[Scopes]", "[Created]", "[Expires]", "[LastUsed]"}
+
+// scan reads a row of columns into a Token.
+func scan(row sq.RowScanner) (*Token, error) {
+	t := new(Token)
+	var (
+		scopes            string
+		expires, lastUsed *time.Time
+	)
+	if err := row.Scan(&t.TokenID, &t.Owner, &t.Name, &scopes, &t.Created, &expires, &lastUsed); err != nil {
+		return nil, err
+	}
+	t.Scopes = strings.Fields(scopes)
+	if expires != nil {
+		t.Expires = *expires
+	}
+	if lastUsed != nil {
+		t.LastUsed = *lastUsed
+	}
+	return t, nil
+}
+
+// List returns the personal access tokens of the user with ID owner, newest first.
+func (tc *TokenClient) List(owner string, db sq.BaseRunner) (tokens []*Token) {
+	if tc.err != nil {
+		return
+	}
+
+	sel := sq.Select(columns...).From("[auth].[PersonalAccessTokens]").Where(sq.Eq{"[ID]": owner}).OrderBy("[Created] DESC")
+	rows, err := sel.RunWith(db).Query()
+	if err != nil {
+		log.Print(err)
+		tc.err = err
+		return
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		t, err := scan(rows)
+		if err != nil {
+			log.Print(err)
+			tc.err = err
+			return nil
+		}
+		tokens = append(tokens, t)
+	}
+	if err := rows.Err(); err != nil {
+		log.Print(err)
+		tc.err = err
+		return nil
+	}
+	return
+}
+
+// Revoke deletes the personal access token with ID tokenID of the user with ID owner.
+func (tc *TokenClient) Revoke(owner, tokenID string, db sq.BaseRunner) {
+	if tc.err != nil {
+		return
+	}
+
+	del := sq.Delete("[auth].[PersonalAccessTokens]").Where(sq.Eq{"[TokenID]": tokenID, "[ID]": owner})
+	res, err := del.RunWith(db).Exec()
+	if err != nil {
+		log.Print(err)
+		tc.err = err
+		return
+	}
+	if cnt, err := res.RowsAffected(); err != nil || cnt != 1 {
+		tc.err = ErrorTokenNotFound
+	}
+}
+
+// Authenticate returns the metadata of token and records that it was used. It returns ErrorTokenInvalid if token
+// is unknown and ErrorTokenExpired if it has expired.
+func (tc *TokenClient) Authenticate(token string, db sq.BaseRunner) (*Token, error) {
+	if tc.err != nil {
+		return nil, tc.err
+	}
+	if !Valid(token) {
+		return nil, ErrorTokenInvalid
+	}
+
+	sel := sq.Select(columns...).From("[auth].[PersonalAccessTokens]").Where(sq.Eq{"[Hash]": HashToken(token)})
+	t, err := scan(sel.RunWith(db).QueryRow())
+	switch {
+	case err == sql.ErrNoRows:
+		return nil, ErrorTokenInvalid
+	case err != nil:
+		log.Print(err)
+		tc.err = err
+		return nil, err
+	}
+	now := time.Now().UTC()
+	if !t.Expires.IsZero() && now.After(t.Expires) {
+		return nil, ErrorTokenExpired
+	}
+
+	update := sq.Update("[auth].[PersonalAccessTokens]").Set("[LastUsed]", now).Where(sq.Eq{"[TokenID]": t.TokenID})
+	if _, err := update.RunWith(db).Exec(); err != nil {
+		log.Print(err)
+		tc.err = err
+		return nil, err
+	}
+	t.LastUsed = now
+	return t, nil
+}
+
+// Err returns the error status of a TokenClient instance.
+func (tc *TokenClient) Err() error {
+	return tc.err
+}
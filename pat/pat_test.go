@@ -0,0 +1,275 @@
+package pat
+
+import (
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/DATA-DOG/go-sqlmock.v1"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+var (
+	tOwner   = "01890a5d-ac96-774b-bcce-b302099a8057"
+	tTokenID = "01890a5d-ac96-774b-bcce-b302099a8058"
+
+	rowColumns = []string{"TokenID", "ID", "Name", "Scopes", "Created", "Expires", "LastUsed"}
+)
+
+func Test_Generate(t *testing.T) {
+	a, err := Generate()
+	assert.Nil(t, err)
+	b, err := Generate()
+	assert.Nil(t, err)
+	assert.NotEqual(t, a, b)
+	assert.True(t, regexp.MustCompile(`^asp_[A-Za-z0-9_-]{40}[0-9a-f]{8}$`).MatchString(a))
+	assert.True(t, Valid(a))
+	assert.Len(t, HashToken(a), 64)
+}
+
+func Test_Valid(t *testing.T) {
+	token, err := Generate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	flipped := []byte(token)
+	if flipped[10] == 'a' {
+		flipped[10] = 'b'
+	} else {
+		flipped[10] = 'a'
+	}
+
+	cases := []struct {
+		token string
+		valid bool
+	}{
+		{token, true},
+		{string(flipped), false},
+		{"xsp_" + token[4:], false},
+		{token[:len(token)-1], false},
+		{"", false},
+	}
+	for i, c := range cases {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			assert.Equal(t, c.valid, Valid(c.token))
+		})
+	}
+}
+
+func Test_Check(t *testing.T) {
+	cases := []struct {
+		t   *Token
+		err error
+	}{
+		{&Token{Name: "ci", Scopes: []string{"repo:read", "deploy.prod"}, Expires: time.Now().Add(time.Hour)}, nil},
+		{&Token{Name: "ci"}, nil},
+		{&Token{Name: ""}, ErrorNameInvalid},
+		{&Token{Name: strings.Repeat("a", 65)}, ErrorNameInvalid},
+		{&Token{Name: "ci", Scopes: []string{"Repo"}}, ErrorScopeInvalid},
+		{&Token{Name: "ci", Scopes: []string{"repo read"}}, ErrorScopeInvalid},
+		{&Token{Name: "ci", Scopes: make([]string, 33)}, ErrorScopeInvalid},
+		{&Token{Name: "ci", Expires: time.Now().Add(-time.Minute)}, ErrorExpiresInvalid},
+	}
+	for i, c := range cases {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			assert.Equal(t, c.err, c.t.Check())
+		})
+	}
+}
+
+func Test_HasScope(t *testing.T) {
+	tok := &Token{Scopes: []string{"repo:read", "user:read"}}
+	assert.True(t, tok.HasScope("user:read"))
+	assert.False(t, tok.HasScope("user"))
+	assert.False(t, tok.HasScope(""))
+	assert.False(t, new(Token).HasScope("user:read"))
+}
+
+func Test_Create(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("An error occured when opening a stub database connection. ERROR: %v\n", err)
+	}
+	defer db.Close()
+
+	insert := `INSERT INTO \[auth]\.\[PersonalAccessTokens] \(\[TokenID],\[ID],\[Name],\[Hash],\[Scopes],\[Created],\[Expires]\) VALUES \(\?,\?,\?,\?,\?,\?,\?\)`
+	cases := []struct {
+		err    error
+		expect error
+	}{
+		{nil, nil},
+		{errors.New("Violation of UNIQUE KEY constraint 'UQ_PersonalAccessTokens_Name'."), ErrorNameInUse},
+	}
+	for i, c := range cases {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			exec := mock.ExpectExec(insert).
+				WithArgs(sqlmock.AnyArg(), tOwner, "ci", sqlmock.AnyArg(), "repo:read ci", sqlmock.AnyArg(), nil)
+			if c.err != nil {
+				exec.WillReturnError(c.err)
+			} else {
+				exec.WillReturnResult(sqlmock.NewResult(0, 1))
+			}
+
+			tc := new(TokenClient)
+			tok := &Token{Owner: tOwner, Name: "ci", Scopes: []string{"repo:read", "ci"}}
+			token := tc.Create(tok, db)
+			assert.Equal(t, c.expect, tc.Err())
+			if c.expect == nil {
+				assert.True(t, Valid(token))
+				assert.Len(t, tok.TokenID, 36)
+				assert.False(t, tok.Created.IsZero())
+			} else {
+				assert.Empty(t, token)
+			}
+
+			if err = mock.ExpectationsWereMet(); err != nil {
+				t.Errorf("Expectations were not met. ERROR: %v\n", err)
+			}
+		})
+	}
+
+	t.Run(strconv.Itoa(len(cases)), func(t *testing.T) {
+		tc := new(TokenClient)
+		assert.Empty(t, tc.Create(&Token{Owner: tOwner}, db))
+		assert.Equal(t, ErrorNameInvalid, tc.Err())
+	})
+}
+
+func Test_List(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("An error occured when opening a stub database connection. ERROR: %v\n", err)
+	}
+	defer db.Close()
+
+	created := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	expires := created.AddDate(0, 1, 0)
+	mock.ExpectQuery(`SELECT \[TokenID], \[ID], \[Name], \[Scopes], \[Created], \[Expires], \[LastUsed] FROM \[auth]\.\[PersonalAccessTokens] WHERE \[ID] = \? ORDER BY \[Created] DESC`).
+		WithArgs(tOwner).
+		WillReturnRows(sqlmock.NewRows(rowColumns).
+			AddRow(tTokenID, tOwner, "ci", "repo:read ci", created, expires, nil).
+			AddRow(tTokenID, tOwner, "laptop", "", created, nil, created))
+
+	tc := new(TokenClient)
+	tokens := tc.List(tOwner, db)
+	assert.Nil(t, tc.Err())
+	assert.Equal(t, []*Token{
+		&Token{TokenID: tTokenID, Owner: tOwner, Name: "ci", Scopes: []string{"repo:read", "ci"}, Created: created, Expires: expires},
+		&Token{TokenID: tTokenID, Owner: tOwner, Name: "laptop", Scopes: []string{}, Created: created, LastUsed: created},
+	}, tokens)
+
+	if err = mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Expectations were not met. ERROR: %v\n", err)
+	}
+}
+
+func Test_Revoke(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("An error occured when opening a stub database connection. ERROR: %v\n", err)
+	}
+	defer db.Close()
+
+	cases := []struct {
+		affected int64
+		err      error
+	}{
+		{1, nil},
+		{0, ErrorTokenNotFound},
+	}
+	for i, c := range cases {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			mock.ExpectExec(`DELETE FROM \[auth]\.\[PersonalAccessTokens] WHERE \[ID] = \? AND \[TokenID] = \?`).
+				WithArgs(tOwner, tTokenID).
+				WillReturnResult(sqlmock.NewResult(0, c.affected))
+
+			tc := new(TokenClient)
+			tc.Revoke(tOwner, tTokenID, db)
+			assert.Equal(t, c.err, tc.Err())
+
+			if err = mock.ExpectationsWereMet(); err != nil {
+				t.Errorf("Expectations were not met. ERROR: %v\n", err)
+			}
+		})
+	}
+}
+
+func Test_Authenticate(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("An error occured when opening a stub database connection. ERROR: %v\n", err)
+	}
+	defer db.Close()
+
+	token, err := Generate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sel := `SELECT \[TokenID], \[ID], \[Name], \[Scopes], \[Created], \[Expires], \[LastUsed] FROM \[auth]\.\[PersonalAccessTokens] WHERE \[Hash] = \?`
+	update := `UPDATE \[auth]\.\[PersonalAccessTokens] SET \[LastUsed] = \? WHERE \[TokenID] = \?`
+	created := time.Now().UTC().Add(-time.Hour)
+
+	t.Run("valid", func(t *testing.T) {
+		mock.ExpectQuery(sel).WithArgs(HashToken(token)).
+			WillReturnRows(sqlmock.NewRows(rowColumns).AddRow(tTokenID, tOwner, "ci", "ci", created, nil, nil))
+		mock.ExpectExec(update).WithArgs(sqlmock.AnyArg(), tTokenID).WillReturnResult(sqlmock.NewResult(0, 1))
+
+		tc := new(TokenClient)
+		tok, err := tc.Authenticate(token, db)
+		assert.Nil(t, err)
+		assert.Equal(t, tOwner, tok.Owner)
+		assert.Equal(t, []string{"ci"}, tok.Scopes)
+		assert.False(t, tok.LastUsed.IsZero())
+
+		if err = mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("Expectations were not met. ERROR: %v\n", err)
+		}
+	})
+
+	t.Run("expired", func(t *testing.T) {
+		mock.ExpectQuery(sel).WithArgs(HashToken(token)).
+			WillReturnRows(sqlmock.NewRows(rowColumns).AddRow(tTokenID, tOwner, "ci", "", created, created.Add(time.Minute), nil))
+
+		tc := new(TokenClient)
+		tok, err := tc.Authenticate(token, db)
+		assert.Nil(t, tok)
+		assert.Equal(t, ErrorTokenExpired, err)
+		assert.Nil(t, tc.Err())
+
+		if err = mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("Expectations were not met. ERROR: %v\n", err)
+		}
+	})
+
+	t.Run("unknown", func(t *testing.T) {
+		mock.ExpectQuery(sel).WithArgs(HashToken(token)).WillReturnRows(sqlmock.NewRows(rowColumns))
+
+		tc := new(TokenClient)
+		tok, err := tc.Authenticate(token, db)
+		assert.Nil(t, tok)
+		assert.Equal(t, ErrorTokenInvalid, err)
+		assert.Nil(t, tc.Err())
+
+		if err = mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("Expectations were not met. ERROR: %v\n", err)
+		}
+	})
+
+	t.Run("malformed", func(t *testing.T) {
+		tc := new(TokenClient)
+		tok, err := tc.Authenticate(Prefix+"not-a-token", db)
+		assert.Nil(t, tok)
+		assert.Equal(t, ErrorTokenInvalid, err)
+		assert.Nil(t, tc.Err())
+	})
+}
+
+func Test_GenerateWith(t *testing.T) {
+	token, err := GenerateWith("ask_")
+	assert.Nil(t, err)
+	assert.True(t, ValidWith(token, "ask_"))
+	assert.False(t, ValidWith(token, Prefix))
+	assert.False(t, Valid(token))
+}
@@ -0,0 +1,304 @@
+package main
+
+import (
+	"encoding/json"
+	sq "github.com/Masterminds/squirrel"
+	"github.com/penutty/authservice/pat"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+var tTokenName = "laptop"
+
+// MockPATClient keeps personal access tokens in memory by their secret. TokenIDs are "tok-" followed by the name.
+type MockPATClient struct {
+	err    error
+	tokens map[string]*pat.Token
+}
+
+func NewMockPATClient() *MockPATClient {
+	return &MockPATClient{tokens: make(map[string]*pat.Token)}
+}
+
+func (m *MockPATClient) Create(t *pat.Token, db sq.BaseRunner) string {
+	if err := t.Check(); err != nil {
+		m.err = err
+		return ""
+	}
+	for _, o := range m.tokens {
+		if o.Owner == t.Owner && o.Name == t.Name {
+			m.err = pat.ErrorNameInUse
+			return ""
+		}
+	}
+	secret, err := pat.Generate()
+	if err != nil {
+		m.err = err
+		return ""
+	}
+	t.TokenID, t.Created = "tok-"+t.Name, time.Now().UTC()
+	m.tokens[secret] = t
+	return secret
+}
+
+func (m *MockPATClient) List(owner string, db sq.BaseRunner) (tokens []*pat.Token) {
+	for _, t := range m.tokens {
+		if t.Owner == owner {
+			tokens = append(tokens, t)
+		}
+	}
+	return
+}
+
+func (m *MockPATClient) Revoke(owner, tokenID string, db sq.BaseRunner) {
+	for secret, t := range m.tokens {
+		if t.Owner == owner && t.TokenID == tokenID {
+			delete(m.tokens, secret)
+			return
+		}
+	}
+	m.err = pat.ErrorTokenNotFound
+}
+
+func (m *MockPATClient) Authenticate(secret string, db sq.BaseRunner) (*pat.Token, error) {
+	if m.err != nil {
+		return nil, m.err
+	}
+	t, ok := m.tokens[secret]
+	if !pat.Valid(secret) || !ok {
+		return nil, pat.ErrorTokenInvalid
+	}
+	if !t.Expires.IsZero() && time.Now().UTC().After(t.Expires) {
+		return nil, pat.ErrorTokenExpired
+	}
+	t.LastUsed = time.Now().UTC()
+	return t, nil
+}
+
+func (m *MockPATClient) Err() error {
+	return m.err
+}
+
+// newPATApp returns an app whose MockPATClient holds the personal access token tTokenName of tUser, and its secret.
+// The token has the scope "repo:read" and scopes.
+func newPATApp(scopes ...string) (*app, *MockPATClient, string) {
+	a, _, _ := newVerifyApp()
+	a.au = new(MockAuditClient)
+	pt := NewMockPATClient()
+	a.pt = pt
+	secret := pt.Create(&pat.Token{Owner: tID, Name: tTokenName, Scopes: append([]string{"repo:read"}, scopes...)}, nil)
+	return a, pt, secret
+}
+
+func NewPATRequest(method, target, secret string, body io.Reader) *http.Request {
+	r := httptest.NewRequest(method, target, body)
+	r.Header.Set("Authorization", "Bearer "+secret)
+	return r
+}
+
+func Test_userTokensHandler(t *testing.T) {
+	defer func(admins []string) { Administrators = admins }(Administrators)
+
+	type test struct {
+		req    func(secret string) *http.Request
+		admins []string
+		code   int
+		tokens int
+		audit  int
+	}
+	body := func(name string) io.Reader {
+		return strings.NewReader(`{"Name": "` + name + `", "Scopes": ["repo:read", "ci"]}`)
+	}
+	cases := []*test{
+		&test{func(string) *http.Request { return NewBearerRequest(http.MethodPost, UserTokensEndpoint, body("ci")) }, nil, http.StatusCreated, 2, 1},
+		&test{func(string) *http.Request {
+			return NewBearerRequest(http.MethodPost, UserTokensEndpoint, body(tTokenName))
+		}, nil, http.StatusConflict, 1, 0},
+		&test{func(string) *http.Request { return NewBearerRequest(http.MethodPost, UserTokensEndpoint, body("")) }, nil, http.StatusBadRequest, 1, 0},
+		&test{func(s string) *http.Request { return NewPATRequest(http.MethodPost, UserTokensEndpoint, s, body("ci")) }, nil, http.StatusForbidden, 1, 0},
+		&test{func(string) *http.Request { return NewBearerRequest(http.MethodGet, UserTokensEndpoint, nil) }, nil, http.StatusOK, 1, 0},
+		&test{func(s string) *http.Request { return NewPATRequest(http.MethodGet, UserTokensEndpoint, s, nil) }, nil, http.StatusOK, 1, 0},
+		&test{func(string) *http.Request {
+			return NewBearerRequest(http.MethodGet, UserTokensEndpoint+"?UserID="+tOtherUser, nil)
+		}, nil, http.StatusForbidden, 1, 0},
+		&test{func(string) *http.Request {
+			return NewBearerRequest(http.MethodGet, UserTokensEndpoint+"?UserID="+tOtherUser, nil)
+		}, []string{tUser}, http.StatusOK, 1, 0},
+		&test{func(string) *http.Request {
+			return NewBearerRequest(http.MethodDelete, UserTokensEndpoint+"?TokenID=tok-"+tTokenName, nil)
+		}, nil, http.StatusNoContent, 0, 1},
+		&test{func(s string) *http.Request {
+			return NewPATRequest(http.MethodDelete, UserTokensEndpoint+"?TokenID=tok-"+tTokenName, s, nil)
+		}, nil, http.StatusForbidden, 1, 0},
+		&test{func(string) *http.Request {
+			return NewBearerRequest(http.MethodDelete, UserTokensEndpoint+"?TokenID=tok-other", nil)
+		}, nil, http.StatusNotFound, 1, 0},
+		&test{func(string) *http.Request { return httptest.NewRequest(http.MethodGet, UserTokensEndpoint, nil) }, nil, http.StatusUnauthorized, 1, 0},
+		&test{func(string) *http.Request { return NewBearerRequest(http.MethodPut, UserTokensEndpoint, nil) }, nil, http.StatusNotImplemented, 1, 0},
+	}
+	for i, c := range cases {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			Administrators = administrators(c.admins...)
+			a, pt, secret := newPATApp(ScopeTokensRead)
+			au := a.au.(*MockAuditClient)
+			rec := httptest.NewRecorder()
+			a.userTokensHandler(rec, c.req(secret))
+			assert.Equal(t, c.code, rec.Code)
+			assert.Len(t, pt.tokens, c.tokens)
+			assert.Len(t, au.entries, c.audit)
+
+			switch c.code {
+			case http.StatusCreated:
+				res := new(patResource)
+				assert.Nil(t, json.NewDecoder(rec.Body).Decode(res))
+				assert.True(t, pat.Valid(res.Token))
+				assert.Equal(t, []string{"repo:read", "ci"}, res.Scopes)
+				assert.Equal(t, "no-store", rec.Header().Get("Cache-Control"))
+			case http.StatusOK:
+				assert.NotContains(t, rec.Body.String(), secret)
+				var res []*patResource
+				assert.Nil(t, json.NewDecoder(rec.Body).Decode(&res))
+				if len(c.admins) > 0 {
+					assert.Empty(t, res)
+					return
+				}
+				assert.Len(t, res, 1)
+				assert.Equal(t, tTokenName, res[0].Name)
+				assert.Empty(t, res[0].Token)
+			}
+		})
+	}
+}
+
+func Test_authenticate_pat(t *testing.T) {
+	type test struct {
+		prepare func(*app, *MockPATClient, string) string
+		err     error
+	}
+	cases := []*test{
+		&test{func(a *app, pt *MockPATClient, s string) string { return s }, nil},
+		&test{func(a *app, pt *MockPATClient, s string) string {
+			secret, _ := pat.Generate()
+			return secret
+		}, ErrorBearerTokenInvalid},
+		&test{func(a *app, pt *MockPATClient, s string) string {
+			pt.tokens[s].Expires = time.Now().UTC().Add(-time.Minute)
+			return s
+		}, ErrorBearerTokenInvalid},
+		&test{func(a *app, pt *MockPATClient, s string) string {
+			a.c.(*MockUserClient).revoked = time.Now().UTC().Add(time.Second)
+			return s
+		}, ErrorBearerTokenInvalid},
+	}
+	for i, c := range cases {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			a, pt, secret := newPATApp()
+			secret = c.prepare(a, pt, secret)
+			u, tok, err := a.bearer(NewPATRequest(http.MethodGet, UserEndpoint, secret, nil))
+			assert.Equal(t, c.err, err)
+			if c.err != nil {
+				return
+			}
+			assert.Equal(t, tID, u.ID())
+			assert.Equal(t, tTokenName, tok.Name)
+			assert.False(t, tok.LastUsed.IsZero())
+		})
+	}
+
+	t.Run(strconv.Itoa(len(cases)), func(t *testing.T) {
+		a, pt, secret := newPATApp()
+		a.t = new(MockTenantClient)
+		r := tenantRequest(a, http.MethodGet, UserEndpoint, tTenantHost, nil)
+		r.Header.Set("Authorization", "Bearer "+secret)
+		_, _, err := a.bearer(r)
+		assert.Equal(t, ErrorBearerTokenInvalid, err)
+		assert.NotNil(t, pt.tokens[secret])
+	})
+
+	// A refused token does not affect the tokens authenticated after it.
+	t.Run(strconv.Itoa(len(cases)+1), func(t *testing.T) {
+		a, _, secret := newPATApp()
+		unknown, _ := pat.Generate()
+		_, _, err := a.bearer(NewPATRequest(http.MethodGet, UserEndpoint, unknown, nil))
+		assert.Equal(t, ErrorBearerTokenInvalid, err)
+		_, _, err = a.bearer(NewPATRequest(http.MethodGet, UserEndpoint, secret, nil))
+		assert.Nil(t, err)
+	})
+}
+
+func Test_authenticateLogin(t *testing.T) {
+	defer func(admins []string) { Administrators = admins }(Administrators)
+	Administrators = administrators(tUser)
+
+	// Personal access tokens may read the account of their owner, but not change its credentials, tokens or
+	// other users.
+	type test struct {
+		handler func(*app) http.HandlerFunc
+		req     func(secret string) *http.Request
+		code    int
+	}
+	cases := []*test{
+		&test{func(a *app) http.HandlerFunc { return a.userHandler }, func(s string) *http.Request {
+			return NewPATRequest(http.MethodGet, UserEndpoint, s, nil)
+		}, http.StatusOK},
+		&test{func(a *app) http.HandlerFunc { return a.userHandler }, func(s string) *http.Request {
+			return NewPATRequest(http.MethodGet, UserEndpoint+"?UserID="+tOtherUser, s, nil)
+		}, http.StatusForbidden},
+		&test{func(a *app) http.HandlerFunc { return a.userPasswordHandler }, func(s string) *http.Request {
+			return NewPATRequest(http.MethodPut, UserPasswordEndpoint, s, strings.NewReader(`{"Password": "`+tPassword+`", "NewPassword": "Another123!pass"}`))
+		}, http.StatusForbidden},
+		&test{func(a *app) http.HandlerFunc { return a.rolesHandler }, func(s string) *http.Request {
+			return NewPATRequest(http.MethodGet, RolesEndpoint, s, nil)
+		}, http.StatusForbidden},
+		&test{func(a *app) http.HandlerFunc { return a.rolesHandler }, func(string) *http.Request {
+			return NewBearerRequest(http.MethodGet, RolesEndpoint, nil)
+		}, http.StatusOK},
+	}
+	for i, c := range cases {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			a, _, secret := newPATApp(ScopeUserRead)
+			a.rb = NewMockRBACClient()
+			rec := httptest.NewRecorder()
+			c.handler(a)(rec, c.req(secret))
+			assert.Equal(t, c.code, rec.Code)
+		})
+	}
+}
+
+func Test_patScopes(t *testing.T) {
+	// Every endpoint accepting personal access tokens requires its scope; the "repo:read" of newPATApp is not enough.
+	type test struct {
+		handler func(*app) http.HandlerFunc
+		target  string
+		scope   string
+	}
+	cases := []*test{
+		&test{func(a *app) http.HandlerFunc { return a.userHandler }, UserEndpoint, ScopeUserRead},
+		&test{func(a *app) http.HandlerFunc { return a.userTokensHandler }, UserTokensEndpoint, ScopeTokensRead},
+		&test{func(a *app) http.HandlerFunc { return a.sessionsHandler }, SessionsEndpoint, ScopeSessionsRead},
+		&test{func(a *app) http.HandlerFunc { return a.statusHandler }, StatusEndpoint, ScopeStatusRead},
+		&test{func(a *app) http.HandlerFunc { return a.userGroupsHandler }, UserGroupsEndpoint, ScopeGroupsRead},
+		&test{func(a *app) http.HandlerFunc { return a.userRolesHandler }, UserRolesEndpoint, ScopeRolesRead},
+		&test{func(a *app) http.HandlerFunc { return a.serviceAccountsHandler }, ServiceAccountsEndpoint, ScopeServiceAccountsRead},
+	}
+	for i, c := range cases {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			for code, scopes := range map[int][]string{http.StatusForbidden: nil, http.StatusOK: []string{c.scope}} {
+				a, _, secret := newPATApp(scopes...)
+				a.g = NewMockGroupClient()
+				a.rb = NewMockRBACClient()
+				a.ss = NewMockSessionClient()
+				a.sa = NewMockServiceAccountClient()
+				rec := httptest.NewRecorder()
+				c.handler(a)(rec, NewPATRequest(http.MethodGet, c.target, secret, nil))
+				assert.Equal(t, code, rec.Code)
+			}
+		})
+	}
+}
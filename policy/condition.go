@@ -0,0 +1,288 @@
+package policy
+
+import (
+	"errors"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+var (
+	ErrorConditionInvalid = errors.New("Conditions must be of the form <operand> <operator> <operand>.")
+
+	operators = map[string]bool{"==": true, "!=": true, "<": true, "<=": true, ">": true, ">=": true, "in": true}
+	roots     = map[string]bool{"subject": true, "resource": true, "context": true}
+)
+
+// Condition compares two operands with one of the operators ==, !=, <, <=, >, >= and in, e.g.
+//
+//	resource.owner == subject.id
+//	'moderator' in subject.roles
+//	resource.status in ['draft', 'review']
+//	context.hour >= 8
+//
+// Operands are attributes of the request — "action" or a path into the "subject", "resource" or "context"
+// attributes — or literals: 'strings', numbers, true, false and [lists] of literals. < and friends compare
+// numbers or strings; in tests whether the left operand is an element of the list on the right. A condition
+// naming an attribute the request does not have, or comparing values of different types, does not hold.
+type Condition struct {
+	left, right *operand
+	op          string
+}
+
+// operand is a literal value or the path of an attribute.
+type operand struct {
+	path  []string
+	value interface{}
+}
+
+// ParseCondition compiles s.
+func ParseCondition(s string) (*Condition, error) {
+	tokens, err := lex(s)
+	if err != nil {
+		return nil, err
+	}
+	if len(tokens) < 3 {
+		return nil, ErrorConditionInvalid
+	}
+
+	c := new(Condition)
+	if c.left, tokens, err = parseOperand(tokens); err != nil {
+		return nil, err
+	}
+	if len(tokens) == 0 || !operators[tokens[0]] {
+		return nil, ErrorConditionInvalid
+	}
+	c.op = tokens[0]
+	if c.right, tokens, err = parseOperand(tokens[1:]); err != nil {
+		return nil, err
+	}
+	if len(tokens) != 0 {
+		return nil, ErrorConditionInvalid
+	}
+	return c, nil
+}
+
+// Holds reports whether c holds for req.
+func (c *Condition) Holds(req *Request) bool {
+	l, ok := c.left.resolve(req)
+	if !ok {
+		return false
+	}
+	r, ok := c.right.resolve(req)
+	if !ok {
+		return false
+	}
+
+	switch c.op {
+	case "==":
+		return reflect.DeepEqual(l, r)
+	case "!=":
+		return !reflect.DeepEqual(l, r)
+	case "in":
+		list, ok := r.([]interface{})
+		if !ok {
+			return false
+		}
+		for _, e := range list {
+			if reflect.DeepEqual(l, e) {
+				return true
+			}
+		}
+		return false
+	}
+
+	cmp, ok := compare(l, r)
+	if !ok {
+		return false
+	}
+	switch c.op {
+	case "<":
+		return cmp < 0
+	case "<=":
+		return cmp <= 0
+	case ">":
+		return cmp > 0
+	default:
+		return cmp >= 0
+	}
+}
+
+// resolve returns the value of o in req and whether req has it.
+func (o *operand) resolve(req *Request) (interface{}, bool) {
+	if o.path == nil {
+		return o.value, true
+	}
+
+	var v interface{}
+	switch o.path[0] {
+	case "action":
+		v = req.Action
+	case "subject":
+		v = req.Subject
+	case "resource":
+		v = req.Resource
+	case "context":
+		v = req.Context
+	}
+	for _, name := range o.path[1:] {
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		if v, ok = m[name]; !ok {
+			return nil, false
+		}
+	}
+	if v == nil {
+		return nil, false
+	}
+	return normalize(v), true
+}
+
+// normalize converts v to the types JSON values are decoded to, so that attributes set in Go compare equal to literals.
+func normalize(v interface{}) interface{} {
+	switch n := v.(type) {
+	case int:
+		return float64(n)
+	case int32:
+		return float64(n)
+	case int64:
+		return float64(n)
+	case float32:
+		return float64(n)
+	case []string:
+		l := make([]interface{}, len(n))
+		for i, s := range n {
+			l[i] = s
+		}
+		return l
+	case []interface{}:
+		l := make([]interface{}, len(n))
+		for i, e := range n {
+			l[i] = normalize(e)
+		}
+		return l
+	}
+	return v
+}
+
+// compare returns the order of l and r if both are numbers or both are strings.
+func compare(l, r interface{}) (int, bool) {
+	switch lv := l.(type) {
+	case float64:
+		rv, ok := r.(float64)
+		switch {
+		case !ok:
+			return 0, false
+		case lv < rv:
+			return -1, true
+		case lv > rv:
+			return 1, true
+		}
+		return 0, true
+	case string:
+		rv, ok := r.(string)
+		if !ok {
+			return 0, false
+		}
+		return strings.Compare(lv, rv), true
+	}
+	return 0, false
+}
+
+// parseOperand parses the operand at the start of tokens and returns the remaining tokens.
+func parseOperand(tokens []string) (*operand, []string, error) {
+	if len(tokens) == 0 {
+		return nil, nil, ErrorConditionInvalid
+	}
+	if tokens[0] != "[" {
+		o, err := parseTerm(tokens[0], true)
+		return o, tokens[1:], err
+	}
+
+	list := []interface{}{}
+	tokens = tokens[1:]
+	for len(tokens) > 0 && tokens[0] != "]" {
+		o, err := parseTerm(tokens[0], false)
+		if err != nil {
+			return nil, nil, err
+		}
+		list = append(list, o.value)
+		tokens = tokens[1:]
+		if len(tokens) > 0 && tokens[0] == "," {
+			tokens = tokens[1:]
+		} else if len(tokens) > 0 && tokens[0] != "]" {
+			return nil, nil, ErrorConditionInvalid
+		}
+	}
+	if len(tokens) == 0 {
+		return nil, nil, ErrorConditionInvalid
+	}
+	return &operand{value: list}, tokens[1:], nil
+}
+
+// parseTerm parses a literal, or an attribute path if paths is true.
+func parseTerm(t string, paths bool) (*operand, error) {
+	switch {
+	case strings.HasPrefix(t, "'"):
+		return &operand{value: strings.Replace(t[1:len(t)-1], `\'`, "'", -1)}, nil
+	case t == "true" || t == "false":
+		return &operand{value: t == "true"}, nil
+	}
+	if n, err := strconv.ParseFloat(t, 64); err == nil {
+		return &operand{value: n}, nil
+	}
+
+	path := strings.Split(t, ".")
+	if !paths || !(t == "action" || roots[path[0]] && len(path) > 1) {
+		return nil, ErrorConditionInvalid
+	}
+	for _, name := range path {
+		if name == "" {
+			return nil, ErrorConditionInvalid
+		}
+	}
+	return &operand{path: path}, nil
+}
+
+// lex splits s into quoted strings, operators, brackets, commas and words.
+func lex(s string) ([]string, error) {
+	var tokens []string
+	for i := 0; i < len(s); {
+		switch c := s[i]; {
+		case c == ' ' || c == '\t':
+			i++
+		case c == '\'':
+			j := i + 1
+			for ; j < len(s) && s[j] != '\''; j++ {
+				if s[j] == '\\' {
+					j++
+				}
+			}
+			if j >= len(s) {
+				return nil, ErrorConditionInvalid
+			}
+			tokens = append(tokens, s[i:j+1])
+			i = j + 1
+		case c == '[' || c == ']' || c == ',':
+			tokens = append(tokens, s[i:i+1])
+			i++
+		case strings.IndexByte("=!<>", c) >= 0:
+			j := i + 1
+			if j < len(s) && s[j] == '=' {
+				j++
+			}
+			tokens = append(tokens, s[i:j])
+			i = j
+		default:
+			j := i
+			for j < len(s) && strings.IndexByte(" \t'[],=!<>", s[j]) < 0 {
+				j++
+			}
+			tokens = append(tokens, s[i:j])
+			i = j
+		}
+	}
+	return tokens, nil
+}
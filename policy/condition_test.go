@@ -0,0 +1,83 @@
+package policy
+
+import (
+	"github.com/stretchr/testify/assert"
+	"strconv"
+	"testing"
+)
+
+func Test_ParseCondition(t *testing.T) {
+	type test struct {
+		s   string
+		err error
+	}
+	cases := []*test{
+		&test{"resource.owner == subject.id", nil},
+		&test{"'moderator' in subject.roles", nil},
+		&test{"resource.status in ['draft', 'review']", nil},
+		&test{"context.hour>=8", nil},
+		&test{"action != 'moments:delete'", nil},
+		&test{`resource.title == 'it\'s'`, nil},
+		&test{"resource.owner", ErrorConditionInvalid},
+		&test{"resource.owner = subject.id", ErrorConditionInvalid},
+		&test{"owner == subject.id", ErrorConditionInvalid},
+		&test{"subject == 'x'", ErrorConditionInvalid},
+		&test{"resource..owner == 'x'", ErrorConditionInvalid},
+		&test{"resource.owner == 'x", ErrorConditionInvalid},
+		&test{"resource.owner == 'x' == 'y'", ErrorConditionInvalid},
+		&test{"resource.status in ['draft' 'review']", ErrorConditionInvalid},
+		&test{"resource.status in ['draft', subject.id]", ErrorConditionInvalid},
+		&test{"resource.status in ['draft'", ErrorConditionInvalid},
+	}
+	for i, c := range cases {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			_, err := ParseCondition(c.s)
+			assert.Equal(t, c.err, err)
+		})
+	}
+}
+
+func Test_Condition_Holds(t *testing.T) {
+	req := &Request{
+		Subject:  map[string]interface{}{"id": "u1", "roles": []string{"moderator"}, "level": 3, "profile": map[string]interface{}{"country": "DE"}},
+		Action:   "moments:edit",
+		Resource: map[string]interface{}{"owner": "u1", "status": "draft", "title": "it's", "size": 12.5},
+		Context:  map[string]interface{}{"hour": float64(9), "ip": nil},
+	}
+
+	type test struct {
+		s     string
+		holds bool
+	}
+	cases := []*test{
+		&test{"resource.owner == subject.id", true},
+		&test{"resource.owner != subject.id", false},
+		&test{"'moderator' in subject.roles", true},
+		&test{"'admin' in subject.roles", false},
+		&test{"resource.status in ['draft', 'review']", true},
+		&test{"resource.status in 'draft'", false},
+		&test{"context.hour >= 8", true},
+		&test{"context.hour < 8", false},
+		&test{"subject.level == 3", true},
+		&test{"subject.level > 2.5", true},
+		&test{"resource.size <= 12.5", true},
+		&test{"resource.status < 'review'", true},
+		&test{"resource.status > 3", false},
+		&test{"subject.profile.country == 'DE'", true},
+		&test{"action == 'moments:edit'", true},
+		&test{`resource.title == 'it\'s'`, true},
+		&test{"resource.missing == subject.missing", false},
+		&test{"resource.missing != 'x'", false},
+		&test{"context.ip == context.ip", false},
+		&test{"subject.id.x == 'u1'", false},
+	}
+	for i, c := range cases {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			cond, err := ParseCondition(c.s)
+			if err != nil {
+				t.Fatal(err)
+			}
+			assert.Equal(t, c.holds, cond.Holds(req))
+		})
+	}
+}
@@ -0,0 +1,172 @@
+// Package policy decides whether a subject may perform an action on a resource from attribute-based rules.
+// Policies are JSON files of rules, e.g.
+//
+//	{"Name": "moments", "Rules": [{"ID": "owner", "Effect": "allow", "Actions": ["moments:edit"], "Resource": "moment",
+//	  "When": ["resource.owner == subject.id", "resource.tenant == subject.tenant"]}]}
+//
+// A rule applies to a request if one of its Actions matches the action, its Resource matches the "type" attribute
+// of the resource and every condition in When holds; see Condition for the condition language. A request is
+// allowed if an "allow" rule and no "deny" rule applies to it, and denied otherwise.
+package policy
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	EffectAllow = "allow"
+	EffectDeny  = "deny"
+)
+
+var (
+	// Dir holds the policies, one JSON file per policy.
+	Dir = os.Getenv("GOPATH") + "/src/github.com/penutty/authservice/policy/policies"
+
+	ErrorPolicyInvalid = errors.New("Policies need a Name and rules with an ID, an Effect of \"allow\" or \"deny\" and Actions.")
+)
+
+// Rule allows or denies the Actions on resources of type Resource, or of any type if Resource is "" or "*",
+// when every condition in When holds. Actions are exact, "*" or a prefix followed by "*", e.g. "moments:*".
+type Rule struct {
+	ID       string
+	Effect   string
+	Actions  []string
+	Resource string
+	When     []string
+
+	conditions []*Condition
+}
+
+// Policy is a named set of rules.
+type Policy struct {
+	Name  string
+	Rules []*Rule
+}
+
+// Request is what a decision is made about. Attributes are JSON values: strings, float64 numbers, bools, lists and objects.
+type Request struct {
+	Subject  map[string]interface{}
+	Action   string
+	Resource map[string]interface{}
+	Context  map[string]interface{}
+}
+
+// Decision is the outcome of a Request and the policy and rule that caused it, empty if no rule applied.
+type Decision struct {
+	Allowed bool
+	Policy  string
+	Rule    string
+}
+
+// Policies is a set of compiled policies.
+type Policies struct {
+	p []*Policy
+}
+
+// Parse compiles the policy in b.
+func Parse(b []byte) (*Policy, error) {
+	p := new(Policy)
+	if err := json.Unmarshal(b, p); err != nil {
+		return nil, err
+	}
+	if p.Name == "" {
+		return nil, ErrorPolicyInvalid
+	}
+	for _, r := range p.Rules {
+		if r.ID == "" || (r.Effect != EffectAllow && r.Effect != EffectDeny) || len(r.Actions) == 0 {
+			return nil, ErrorPolicyInvalid
+		}
+		for _, w := range r.When {
+			c, err := ParseCondition(w)
+			if err != nil {
+				return nil, err
+			}
+			r.conditions = append(r.conditions, c)
+		}
+	}
+	return p, nil
+}
+
+// New returns Policies deciding with ps.
+func New(ps ...*Policy) *Policies {
+	return &Policies{p: ps}
+}
+
+// Load compiles every policy in dir, i.e. every file named *.json.
+func Load(dir string) (*Policies, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	ps := new(Policies)
+	for _, path := range paths {
+		b, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		p, err := Parse(b)
+		if err != nil {
+			log.Printf("%s: %v", path, err)
+			return nil, err
+		}
+		ps.p = append(ps.p, p)
+	}
+	return ps, nil
+}
+
+// Decide returns the decision on req. A "deny" rule overrides any "allow" rule; without an applying
+// "allow" rule req is denied.
+func (ps *Policies) Decide(req *Request) *Decision {
+	var allow *Decision
+	for _, p := range ps.p {
+		for _, r := range p.Rules {
+			if !r.applies(req) {
+				continue
+			}
+			if r.Effect == EffectDeny {
+				return &Decision{Allowed: false, Policy: p.Name, Rule: r.ID}
+			}
+			if allow == nil {
+				allow = &Decision{Allowed: true, Policy: p.Name, Rule: r.ID}
+			}
+		}
+	}
+	if allow == nil {
+		return new(Decision)
+	}
+	return allow
+}
+
+// applies reports whether r applies to req.
+func (r *Rule) applies(req *Request) bool {
+	if r.Resource != "" && r.Resource != "*" {
+		if t, _ := req.Resource["type"].(string); t != r.Resource {
+			return false
+		}
+	}
+	if !matchAction(r.Actions, req.Action) {
+		return false
+	}
+	for _, c := range r.conditions {
+		if !c.Holds(req) {
+			return false
+		}
+	}
+	return true
+}
+
+// matchAction reports whether one of patterns matches action.
+func matchAction(patterns []string, action string) bool {
+	for _, p := range patterns {
+		if p == action || p == "*" || strings.HasSuffix(p, "*") && strings.HasPrefix(action, strings.TrimSuffix(p, "*")) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,95 @@
+package policy
+
+import (
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+)
+
+func Test_Parse(t *testing.T) {
+	type test struct {
+		json string
+		err  error
+	}
+	cases := []*test{
+		&test{`{"Name": "p", "Rules": [{"ID": "r", "Effect": "allow", "Actions": ["*"], "When": ["subject.id == 'u1'"]}]}`, nil},
+		&test{`{"Name": "p"}`, nil},
+		&test{`{"Rules": [{"ID": "r", "Effect": "allow", "Actions": ["*"]}]}`, ErrorPolicyInvalid},
+		&test{`{"Name": "p", "Rules": [{"Effect": "allow", "Actions": ["*"]}]}`, ErrorPolicyInvalid},
+		&test{`{"Name": "p", "Rules": [{"ID": "r", "Effect": "permit", "Actions": ["*"]}]}`, ErrorPolicyInvalid},
+		&test{`{"Name": "p", "Rules": [{"ID": "r", "Effect": "deny"}]}`, ErrorPolicyInvalid},
+		&test{`{"Name": "p", "Rules": [{"ID": "r", "Effect": "deny", "Actions": ["*"], "When": ["subject.id"]}]}`, ErrorConditionInvalid},
+	}
+	for i, c := range cases {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			_, err := Parse([]byte(c.json))
+			assert.Equal(t, c.err, err)
+		})
+	}
+}
+
+func Test_Load(t *testing.T) {
+	ps, err := Load("policies")
+	assert.Nil(t, err)
+	assert.NotEmpty(t, ps.p)
+
+	dir, err := ioutil.TempDir("", "policy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err := ioutil.WriteFile(filepath.Join(dir, "bad.json"), []byte(`{"Name": "bad", "Rules": [{"ID": "r"}]}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	_, err = Load(dir)
+	assert.Equal(t, ErrorPolicyInvalid, err)
+}
+
+func Test_Decide(t *testing.T) {
+	ps, err := Load("policies")
+	if err != nil {
+		t.Fatal(err)
+	}
+	moment := func(attrs map[string]interface{}) map[string]interface{} {
+		r := map[string]interface{}{"type": "moment", "owner": "u1", "tenant": "acme"}
+		for k, v := range attrs {
+			r[k] = v
+		}
+		return r
+	}
+	owner := map[string]interface{}{"id": "u1", "tenant": "acme"}
+	moderator := map[string]interface{}{"id": "u2", "tenant": "acme", "roles": []interface{}{"moderator"}}
+
+	type test struct {
+		req     *Request
+		allowed bool
+		rule    string
+	}
+	cases := []*test{
+		&test{&Request{Subject: owner, Action: "moments:edit", Resource: moment(nil)}, true, "owner"},
+		&test{&Request{Subject: owner, Action: "moments:edit", Resource: moment(map[string]interface{}{"tenant": "other"})}, false, ""},
+		&test{&Request{Subject: owner, Action: "moments:edit", Resource: moment(map[string]interface{}{"locked": true})}, false, "locked"},
+		&test{&Request{Subject: owner, Action: "moments:share", Resource: moment(nil)}, false, ""},
+		&test{&Request{Subject: moderator, Action: "moments:share", Resource: moment(nil)}, true, "moderator"},
+		&test{&Request{Subject: moderator, Action: "moments:edit", Resource: moment(map[string]interface{}{"locked": true})}, false, "locked"},
+		&test{&Request{Subject: map[string]interface{}{"id": "u3"}, Action: "moments:read", Resource: moment(map[string]interface{}{"visibility": "public"})}, true, "public"},
+		&test{&Request{Subject: map[string]interface{}{"id": "u3"}, Action: "moments:read", Resource: moment(nil)}, false, ""},
+		&test{&Request{Subject: owner, Action: "moments:edit", Resource: map[string]interface{}{"type": "album", "owner": "u1", "tenant": "acme"}}, false, ""},
+		&test{&Request{Action: "moments:read"}, false, ""},
+	}
+	for i, c := range cases {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			d := ps.Decide(c.req)
+			assert.Equal(t, c.allowed, d.Allowed)
+			assert.Equal(t, c.rule, d.Rule)
+			if c.rule != "" {
+				assert.Equal(t, "moments", d.Policy)
+			}
+		})
+	}
+
+	assert.False(t, New().Decide(&Request{Action: "moments:read"}).Allowed)
+}
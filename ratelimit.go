@@ -0,0 +1,109 @@
+package main
+
+import (
+	"github.com/penutty/authservice/pat"
+	"github.com/penutty/authservice/ratelimit"
+	"github.com/penutty/authservice/user"
+	"github.com/penutty/authservice/verification"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+var (
+	// RateLimitConfig is the path of a JSON file of rate limit rules replacing DefaultRateLimits.
+	RateLimitConfig = os.Getenv("RateLimitConfig")
+	// RateLimitStore is "sql" to share buckets between instances through Auth-Db, otherwise buckets are kept in memory.
+	RateLimitStore = os.Getenv("RateLimitStore")
+	// RateLimitSweepInterval is how often expired buckets are deleted from Auth-Db.
+	RateLimitSweepInterval = 10 * time.Minute
+
+	// DefaultRateLimits are the rate limit rules used unless RateLimitConfig is set.
+	DefaultRateLimits = []*ratelimit.Rule{
+		&ratelimit.Rule{Endpoint: AuthEndpoint, Method: http.MethodPost, Key: "ip", Limit: 10, Period: time.Minute, Burst: 20},
+		&ratelimit.Rule{Endpoint: MFAEndpoint, Method: http.MethodPost, Key: "ip", Limit: 10, Period: time.Minute, Burst: 20},
+		&ratelimit.Rule{Endpoint: UserEndpoint, Method: http.MethodPost, Key: "ip", Limit: 5, Period: time.Hour, Burst: 10},
+		&ratelimit.Rule{Endpoint: EmailLoginEndpoint, Method: http.MethodPost, Key: "ip", Limit: 5, Period: time.Minute},
+		&ratelimit.Rule{Endpoint: VerifyResendEndpoint, Method: http.MethodPost, Key: "ip", Limit: 5, Period: time.Minute},
+		&ratelimit.Rule{Endpoint: PasswordForgotEndpoint, Method: http.MethodPost, Key: "ip", Limit: 5, Period: time.Minute},
+		&ratelimit.Rule{Endpoint: PasswordExpiredEndpoint, Method: http.MethodPost, Key: "ip", Limit: 10, Period: time.Minute},
+		&ratelimit.Rule{Endpoint: TokenEndpoint, Method: http.MethodPost, Key: "client", Limit: 60, Period: time.Minute, Burst: 120},
+		&ratelimit.Rule{Endpoint: "*", Key: "user", Limit: 120, Period: time.Minute, Burst: 240},
+		&ratelimit.Rule{Endpoint: "*", Key: "ip", Limit: 300, Period: time.Minute, Burst: 600},
+	}
+
+	// rateLimitKeys are the kinds of keys rate limit rules may name, besides "client"; see clientKey.
+	rateLimitKeys = map[string]ratelimit.KeyFunc{
+		"ip":   clientIP,
+		"user": bearerSubject,
+	}
+)
+
+// bearerSubject returns the subject of the access token in the Authorization header of r, or the hash of the
+// personal access token in it, which is only looked up by the handler authenticating the request.
+// Revocation is not checked; the handler authenticating the request does.
+func bearerSubject(r *http.Request) string {
+	h := r.Header.Get("Authorization")
+	if !strings.HasPrefix(h, "Bearer ") {
+		return ""
+	}
+	token := strings.TrimPrefix(h, "Bearer ")
+	if pat.Valid(token) {
+		return "pat:" + pat.HashToken(token)
+	}
+	claims, err := verification.ParseAudience(token, "Moment-Service")
+	if err != nil {
+		return ""
+	}
+	sub, _ := claims["sub"].(string)
+	return sub
+}
+
+// clientKey returns the client ID of the Basic credentials of r once they authenticate the client, and the source
+// IP of r until then, so that made-up client IDs do not get buckets of their own.
+func (a *app) clientKey(r *http.Request) string {
+	p, err := a.authenticateClient(r)
+	if err != nil {
+		return "ip:" + clientIP(r)
+	}
+	return p.ClientID()
+}
+
+// newLimiter returns the rate limiter configured by RateLimitConfig and RateLimitStore. Clients are authenticated
+// for "client" keys with new clients of a for every request.
+func newLimiter(a *app) (*ratelimit.Limiter, error) {
+	keys := map[string]ratelimit.KeyFunc{
+		"client": func(r *http.Request) string { return a.withClients().clientKey(r) },
+	}
+	for name, f := range rateLimitKeys {
+		keys[name] = f
+	}
+	l := &ratelimit.Limiter{Rules: DefaultRateLimits, Keys: keys}
+	if RateLimitConfig != "" {
+		rules, err := ratelimit.LoadRules(RateLimitConfig)
+		if err != nil {
+			return nil, err
+		}
+		l.Rules = rules
+	}
+
+	switch RateLimitStore {
+	case "sql":
+		s := ratelimit.NewSQL(user.AuthDB())
+		go sweepRateLimits(s)
+		l.Store = s
+	default:
+		l.Store = ratelimit.NewMemory()
+	}
+	return l, nil
+}
+
+// sweepRateLimits deletes expired buckets from s every RateLimitSweepInterval.
+func sweepRateLimits(s *ratelimit.SQL) {
+	for now := range time.Tick(RateLimitSweepInterval) {
+		if err := s.Sweep(now.UTC()); err != nil {
+			logger(Warn).Println(err)
+		}
+	}
+}
@@ -0,0 +1,50 @@
+package ratelimit
+
+import (
+	"sync"
+	"time"
+)
+
+// sweepInterval is how often Memory forgets full buckets.
+var sweepInterval = time.Minute
+
+// Memory keeps buckets in memory. Buckets are not shared between instances.
+type Memory struct {
+	mu      sync.Mutex
+	buckets map[string]*memoryBucket
+	swept   time.Time
+}
+
+type memoryBucket struct {
+	bucket
+	full time.Time
+}
+
+// NewMemory returns an empty Memory store.
+func NewMemory() *Memory {
+	return &Memory{buckets: make(map[string]*memoryBucket)}
+}
+
+// Take takes a token from the bucket of key under r.
+func (m *Memory) Take(key string, r *Rule, now time.Time) (Result, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if now.Sub(m.swept) > sweepInterval {
+		for k, b := range m.buckets {
+			if now.After(b.full) {
+				delete(m.buckets, k)
+			}
+		}
+		m.swept = now
+	}
+
+	b, ok := m.buckets[key]
+	if !ok {
+		b = new(memoryBucket)
+		m.buckets[key] = b
+	}
+	res := b.take(r, now)
+	b.full = now.Add(res.Reset)
+	return res, nil
+}
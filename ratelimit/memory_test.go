@@ -0,0 +1,32 @@
+package ratelimit
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func Test_Memory_Take(t *testing.T) {
+	m := NewMemory()
+	now := time.Now().UTC()
+
+	for i := 0; i < 3; i++ {
+		res, err := m.Take("a", tRule, now)
+		assert.Nil(t, err)
+		assert.True(t, res.Allowed)
+	}
+	res, err := m.Take("a", tRule, now)
+	assert.Nil(t, err)
+	assert.False(t, res.Allowed)
+
+	res, err = m.Take("b", tRule, now)
+	assert.Nil(t, err)
+	assert.True(t, res.Allowed)
+	assert.Len(t, m.buckets, 2)
+
+	// Both buckets are full again and are swept.
+	res, err = m.Take("c", tRule, now.Add(time.Hour))
+	assert.Nil(t, err)
+	assert.True(t, res.Allowed)
+	assert.Len(t, m.buckets, 1)
+}
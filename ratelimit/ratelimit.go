@@ -0,0 +1,193 @@
+// Package ratelimit throttles requests with token buckets.
+// Rules select requests by endpoint and method and name the key a bucket is kept for, e.g. the client IP,
+// the authenticated UserID or the OAuth client ID. Buckets are kept in a Store: Memory for a single
+// instance, SQL to share them between instances through Auth-Db.
+package ratelimit
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+var (
+	ErrorRuleInvalid = errors.New("Rate limit rules need a Key, a positive Limit and a positive Period.")
+)
+
+// Rule allows Limit requests per Period with bursts of up to Burst requests for each key of kind Key.
+// Endpoint is a request path or "*" for every path; Method is optional.
+type Rule struct {
+	Endpoint string
+	Method   string
+	Key      string
+	Limit    int
+	Period   time.Duration
+	Burst    int
+}
+
+// rate returns the tokens added to a bucket per second.
+func (r *Rule) rate() float64 {
+	return float64(r.Limit) / r.Period.Seconds()
+}
+
+func (r *Rule) burst() float64 {
+	if r.Burst > 0 {
+		return float64(r.Burst)
+	}
+	return float64(r.Limit)
+}
+
+// matches reports whether r applies to req.
+func (r *Rule) matches(req *http.Request) bool {
+	return (r.Endpoint == "*" || r.Endpoint == req.URL.Path) && (r.Method == "" || r.Method == req.Method)
+}
+
+// LoadRules reads rules from a JSON file, e.g. [{"Endpoint": "/auth", "Key": "ip", "Limit": 10, "Period": "1m"}].
+func LoadRules(path string) ([]*Rule, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var raw []struct {
+		Endpoint string
+		Method   string
+		Key      string
+		Limit    int
+		Period   string
+		Burst    int
+	}
+	if err := json.Unmarshal(b, &raw); err != nil {
+		return nil, err
+	}
+
+	rules := make([]*Rule, len(raw))
+	for i, r := range raw {
+		period, err := time.ParseDuration(r.Period)
+		if err != nil {
+			return nil, err
+		}
+		if r.Endpoint == "" {
+			r.Endpoint = "*"
+		}
+		rules[i] = &Rule{Endpoint: r.Endpoint, Method: r.Method, Key: r.Key, Limit: r.Limit, Period: period, Burst: r.Burst}
+		if r.Key == "" || r.Limit <= 0 || period <= 0 || r.Burst < 0 {
+			return nil, ErrorRuleInvalid
+		}
+	}
+	return rules, nil
+}
+
+// Result is the outcome of taking a token from a bucket.
+type Result struct {
+	Allowed   bool
+	Limit     int
+	Remaining int
+	// Reset is the time until the bucket is full again.
+	Reset time.Duration
+	// RetryAfter is the time until the next token when the request was not allowed.
+	RetryAfter time.Duration
+}
+
+// bucket is the state of a token bucket.
+type bucket struct {
+	tokens  float64
+	updated time.Time
+}
+
+// take refills b for rule r up to now and takes a token if one is available.
+func (b *bucket) take(r *Rule, now time.Time) Result {
+	if b.updated.IsZero() {
+		b.tokens = r.burst()
+	} else if elapsed := now.Sub(b.updated).Seconds(); elapsed > 0 {
+		b.tokens = math.Min(r.burst(), b.tokens+elapsed*r.rate())
+	}
+	b.updated = now
+
+	res := Result{Limit: r.Limit}
+	if b.tokens >= 1 {
+		b.tokens--
+		res.Allowed = true
+	} else {
+		res.RetryAfter = seconds((1 - b.tokens) / r.rate())
+	}
+	res.Remaining = int(math.Floor(b.tokens))
+	res.Reset = seconds((r.burst() - b.tokens) / r.rate())
+	return res
+}
+
+func seconds(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second))
+}
+
+// Store keeps token buckets.
+type Store interface {
+	// Take takes a token from the bucket of key under r.
+	Take(key string, r *Rule, now time.Time) (Result, error)
+}
+
+// KeyFunc returns the key of a request, or "" if the request has none, e.g. an unauthenticated request has no UserID.
+type KeyFunc func(*http.Request) string
+
+// Limiter is a middleware rejecting requests that exceed a rule with 429 Too Many Requests.
+type Limiter struct {
+	Store Store
+	Rules []*Rule
+	Keys  map[string]KeyFunc
+}
+
+// Wrap returns a handler that applies the rules of l before passing requests to h.
+// Store errors are logged and let the request through.
+func (l *Limiter) Wrap(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var (
+			report *Result
+			now    = time.Now().UTC()
+		)
+		for i, rule := range l.Rules {
+			if !rule.matches(r) {
+				continue
+			}
+			keyFunc, ok := l.Keys[rule.Key]
+			if !ok {
+				continue
+			}
+			key := keyFunc(r)
+			if key == "" {
+				continue
+			}
+
+			res, err := l.Store.Take(strconv.Itoa(i)+":"+rule.Key+":"+key, rule, now)
+			if err != nil {
+				log.Print(err)
+				continue
+			}
+			if report == nil || !res.Allowed || (report.Allowed && res.Remaining < report.Remaining) {
+				report = &res
+			}
+			if !res.Allowed {
+				break
+			}
+		}
+
+		if report != nil {
+			w.Header().Set("RateLimit-Limit", strconv.Itoa(report.Limit))
+			w.Header().Set("RateLimit-Remaining", strconv.Itoa(report.Remaining))
+			w.Header().Set("RateLimit-Reset", strconv.Itoa(ceil(report.Reset)))
+			if !report.Allowed {
+				w.Header().Set("Retry-After", strconv.Itoa(ceil(report.RetryAfter)))
+				http.Error(w, http.StatusText(http.StatusTooManyRequests), http.StatusTooManyRequests)
+				return
+			}
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+func ceil(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
@@ -0,0 +1,125 @@
+package ratelimit
+
+import (
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+)
+
+var tRule = &Rule{Endpoint: "/auth", Key: "ip", Limit: 2, Period: time.Second, Burst: 3}
+
+func Test_bucket_take(t *testing.T) {
+	now := time.Now().UTC()
+	b := new(bucket)
+
+	type test struct {
+		at        time.Duration
+		allowed   bool
+		remaining int
+	}
+	cases := []*test{
+		&test{0, true, 2},
+		&test{0, true, 1},
+		&test{0, true, 0},
+		&test{0, false, 0},
+		&test{500 * time.Millisecond, true, 0},
+		&test{10 * time.Second, true, 2},
+	}
+	for i, c := range cases {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			res := b.take(tRule, now.Add(c.at))
+			assert.Equal(t, c.allowed, res.Allowed)
+			assert.Equal(t, c.remaining, res.Remaining)
+			assert.Equal(t, 2, res.Limit)
+			if !c.allowed {
+				assert.Equal(t, 500*time.Millisecond, res.RetryAfter)
+			}
+		})
+	}
+}
+
+func Test_LoadRules(t *testing.T) {
+	f, err := ioutil.TempFile("", "ratelimit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+
+	type test struct {
+		json string
+		err  bool
+	}
+	cases := []*test{
+		&test{`[{"Endpoint": "/auth", "Method": "POST", "Key": "ip", "Limit": 10, "Period": "1m", "Burst": 5}, {"Key": "user", "Limit": 100, "Period": "1h"}]`, false},
+		&test{`[{"Key": "ip", "Limit": 10, "Period": "soon"}]`, true},
+		&test{`[{"Key": "ip", "Limit": 0, "Period": "1m"}]`, true},
+		&test{`[{"Limit": 10, "Period": "1m"}]`, true},
+	}
+	for i, c := range cases {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			if err := ioutil.WriteFile(f.Name(), []byte(c.json), 0600); err != nil {
+				t.Fatal(err)
+			}
+			rules, err := LoadRules(f.Name())
+			if c.err {
+				assert.Error(t, err)
+				return
+			}
+			assert.Nil(t, err)
+			assert.Len(t, rules, 2)
+			assert.Equal(t, time.Minute, rules[0].Period)
+			assert.Equal(t, "*", rules[1].Endpoint)
+		})
+	}
+}
+
+func Test_Limiter_Wrap(t *testing.T) {
+	l := &Limiter{
+		Store: NewMemory(),
+		Rules: []*Rule{
+			&Rule{Endpoint: "/auth", Method: http.MethodPost, Key: "ip", Limit: 1, Period: time.Minute, Burst: 2},
+			&Rule{Endpoint: "*", Key: "user", Limit: 1, Period: time.Minute},
+		},
+		Keys: map[string]KeyFunc{
+			"ip":   func(r *http.Request) string { return r.RemoteAddr },
+			"user": func(r *http.Request) string { return r.Header.Get("User") },
+		},
+	}
+	h := l.Wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	type test struct {
+		method    string
+		path      string
+		user      string
+		code      int
+		remaining string
+	}
+	cases := []*test{
+		&test{http.MethodPost, "/auth", "", http.StatusOK, "1"},
+		&test{http.MethodPost, "/auth", "", http.StatusOK, "0"},
+		&test{http.MethodPost, "/auth", "", http.StatusTooManyRequests, "0"},
+		&test{http.MethodGet, "/auth", "", http.StatusOK, ""},
+		&test{http.MethodGet, "/user", "testuser", http.StatusOK, "0"},
+		&test{http.MethodGet, "/user", "testuser", http.StatusTooManyRequests, "0"},
+		&test{http.MethodGet, "/user", "otheruser", http.StatusOK, "0"},
+	}
+	for i, c := range cases {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			r := httptest.NewRequest(c.method, c.path, nil)
+			r.Header.Set("User", c.user)
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, r)
+			assert.Equal(t, c.code, rec.Code)
+			assert.Equal(t, c.remaining, rec.Header().Get("RateLimit-Remaining"))
+			if c.code == http.StatusTooManyRequests {
+				assert.Equal(t, "60", rec.Header().Get("Retry-After"))
+				assert.Equal(t, "1", rec.Header().Get("RateLimit-Limit"))
+			}
+		})
+	}
+}
@@ -0,0 +1,61 @@
+package ratelimit
+
+import (
+	"database/sql"
+	sq "github.com/Masterminds/squirrel"
+	"time"
+)
+
+// SQL keeps buckets in the auth.RateLimits table so that every instance sees the same buckets.
+type SQL struct {
+	DB *sql.DB
+}
+
+// NewSQL returns a SQL store using db.
+func NewSQL(db *sql.DB) *SQL {
+	return &SQL{DB: db}
+}
+
+// Take takes a token from the bucket of key under r. The row of key is locked for the transaction.
+func (s *SQL) Take(key string, r *Rule, now time.Time) (Result, error) {
+	tx, err := s.DB.Begin()
+	if err != nil {
+		return Result{}, err
+	}
+
+	b := new(bucket)
+	sel := sq.Select("[Tokens], [Updated]").From("[auth].[RateLimits] WITH (UPDLOCK, HOLDLOCK)").Where(sq.Eq{"[Key]": key})
+	err = sel.RunWith(tx).QueryRow().Scan(&b.tokens, &b.updated)
+	exists := err == nil
+	if err != nil && err != sql.ErrNoRows {
+		tx.Rollback()
+		return Result{}, err
+	}
+
+	res := b.take(r, now)
+	if exists {
+		update := sq.Update("[auth].[RateLimits]").
+			Set("[Tokens]", b.tokens).
+			Set("[Updated]", b.updated).
+			Set("[Expires]", now.Add(res.Reset)).
+			Where(sq.Eq{"[Key]": key})
+		_, err = update.RunWith(tx).Exec()
+	} else {
+		insert := sq.Insert("[auth].[RateLimits]").
+			Columns("[Key]", "[Tokens]", "[Updated]", "[Expires]").
+			Values(key, b.tokens, b.updated, now.Add(res.Reset))
+		_, err = insert.RunWith(tx).Exec()
+	}
+	if err != nil {
+		tx.Rollback()
+		return Result{}, err
+	}
+	return res, tx.Commit()
+}
+
+// Sweep deletes the buckets that are full again.
+func (s *SQL) Sweep(now time.Time) error {
+	del := sq.Delete("[auth].[RateLimits]").Where(sq.Lt{"[Expires]": now})
+	_, err := del.RunWith(s.DB).Exec()
+	return err
+}
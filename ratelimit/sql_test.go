@@ -0,0 +1,72 @@
+package ratelimit
+
+import (
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/DATA-DOG/go-sqlmock.v1"
+	"testing"
+	"time"
+)
+
+func Test_SQL_Take(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("An error occured when opening a stub database connection. ERROR: %v\n", err)
+	}
+	defer db.Close()
+
+	sel := `SELECT \[Tokens], \[Updated] FROM \[auth]\.\[RateLimits] WITH \(UPDLOCK, HOLDLOCK\) WHERE \[Key] = \?`
+	now := time.Now().UTC()
+	s := NewSQL(db)
+
+	t.Run("new", func(t *testing.T) {
+		mock.ExpectBegin()
+		mock.ExpectQuery(sel).WithArgs("key").WillReturnRows(sqlmock.NewRows([]string{"Tokens", "Updated"}))
+		mock.ExpectExec(`INSERT INTO \[auth]\.\[RateLimits] \(\[Key],\[Tokens],\[Updated],\[Expires]\) VALUES \(\?,\?,\?,\?\)`).
+			WithArgs("key", 2.0, now, now.Add(500*time.Millisecond)).
+			WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectCommit()
+
+		res, err := s.Take("key", tRule, now)
+		assert.Nil(t, err)
+		assert.True(t, res.Allowed)
+		assert.Equal(t, 2, res.Remaining)
+
+		if err = mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("Expectations were not met. ERROR: %v\n", err)
+		}
+	})
+
+	t.Run("empty", func(t *testing.T) {
+		mock.ExpectBegin()
+		mock.ExpectQuery(sel).WithArgs("key").WillReturnRows(sqlmock.NewRows([]string{"Tokens", "Updated"}).AddRow(0.5, now))
+		mock.ExpectExec(`UPDATE \[auth]\.\[RateLimits] SET \[Tokens] = \?, \[Updated] = \?, \[Expires] = \? WHERE \[Key] = \?`).
+			WithArgs(0.5, now, sqlmock.AnyArg(), "key").
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+
+		res, err := s.Take("key", tRule, now)
+		assert.Nil(t, err)
+		assert.False(t, res.Allowed)
+		assert.Equal(t, 250*time.Millisecond, res.RetryAfter)
+
+		if err = mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("Expectations were not met. ERROR: %v\n", err)
+		}
+	})
+}
+
+func Test_SQL_Sweep(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("An error occured when opening a stub database connection. ERROR: %v\n", err)
+	}
+	defer db.Close()
+
+	now := time.Now().UTC()
+	mock.ExpectExec(`DELETE FROM \[auth]\.\[RateLimits] WHERE \[Expires] < \?`).WithArgs(now).WillReturnResult(sqlmock.NewResult(0, 3))
+	assert.Nil(t, NewSQL(db).Sweep(now))
+
+	if err = mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Expectations were not met. ERROR: %v\n", err)
+	}
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"github.com/penutty/authservice/pat"
+	"github.com/penutty/authservice/ratelimit"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+)
+
+func Test_rateLimitKeys(t *testing.T) {
+	token, err := generateJwt(tID, nil, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	challenge, err := generateChallenge(tID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	secret, err := pat.Generate()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	basic := func(clientID, secret string) string {
+		r := httptest.NewRequest(http.MethodPost, TokenEndpoint, nil)
+		r.SetBasicAuth(clientID, secret)
+		return r.Header.Get("Authorization")
+	}
+
+	type test struct {
+		header string
+		user   string
+		client string
+	}
+	cases := []*test{
+		&test{"Bearer " + token, tID, "ip:192.0.2.1"},
+		&test{"Bearer " + challenge, "", "ip:192.0.2.1"},
+		&test{"Bearer not.a.token", "", "ip:192.0.2.1"},
+		&test{"Bearer " + secret, "pat:" + pat.HashToken(secret), "ip:192.0.2.1"},
+		&test{"Bearer " + pat.Prefix + "not-a-token", "", "ip:192.0.2.1"},
+		&test{basic(tClientID, tSecret), "", tClientID},
+		&test{basic(tClientID, "not-the-secret"), "", "ip:192.0.2.1"},
+		&test{"", "", "ip:192.0.2.1"},
+	}
+	for i, c := range cases {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			a := new(app)
+			a.x = new(MockExchangeClient)
+			r := httptest.NewRequest(http.MethodPost, TokenEndpoint, nil)
+			r.Header.Set("Authorization", c.header)
+			assert.Equal(t, c.user, bearerSubject(r))
+			assert.Equal(t, c.client, a.clientKey(r))
+			assert.Equal(t, "192.0.2.1", rateLimitKeys["ip"](r))
+		})
+	}
+}
+
+func Test_newLimiter(t *testing.T) {
+	l, err := newLimiter(new(app))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.IsType(t, new(ratelimit.Memory), l.Store)
+
+	h := l.Wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	for i := 0; i < 10; i++ {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, UserEndpoint, nil))
+		assert.Equal(t, http.StatusOK, rec.Code)
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, UserEndpoint, nil))
+	assert.Equal(t, http.StatusTooManyRequests, rec.Code)
+	assert.NotEmpty(t, rec.Header().Get("Retry-After"))
+	assert.Equal(t, "5", rec.Header().Get("RateLimit-Limit"))
+}
@@ -0,0 +1,428 @@
+// Package rbac is dedicated to reading and writing roles, the permissions they grant and the roles of
+// users and groups in Auth-Db. Users are referenced by their immutable ID.
+package rbac
+
+import (
+	"errors"
+	sq "github.com/Masterminds/squirrel"
+	"github.com/penutty/authservice/group"
+	"log"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+var (
+	DescriptionMaxLength = 256
+
+	ErrorRoleInvalid       = errors.New("Role must be 1 to 64 lowercase letters, digits, '_', '-', '.' or ':'.")
+	ErrorPermissionInvalid = errors.New("Permission must be 1 to 128 lowercase letters, digits, '_', '-', '.', ':' or '*'.")
+	ErrorDescriptionLong   = errors.New("Description too long.")
+	ErrorRoleNotFound      = errors.New("Role does not exist.")
+
+	roleRegexp       = regexp.MustCompile(`^[a-z0-9_.:-]{1,64}$`)
+	permissionRegexp = regexp.MustCompile(`^[a-z0-9_.:*-]{1,128}$`)
+)
+
+// Role is a named set of permissions, e.g. "moments:read".
+type Role struct {
+	Name        string
+	Description string
+	Permissions []string
+}
+
+// CheckRole returns an error if role is not a valid role name.
+func CheckRole(role string) error {
+	if !roleRegexp.MatchString(role) {
+		return ErrorRoleInvalid
+	}
+	return nil
+}
+
+// Check returns an error if r is invalid.
+func (r *Role) Check() error {
+	if err := CheckRole(r.Name); err != nil {
+		return err
+	}
+	if utf8.RuneCountInString(r.Description) > DescriptionMaxLength {
+		return ErrorDescriptionLong
+	}
+	for _, p := range r.Permissions {
+		if !permissionRegexp.MatchString(p) {
+			return ErrorPermissionInvalid
+		}
+	}
+	return nil
+}
+
+type Client interface {
+	Saver
+	Deleter
+	Lister
+	Assigner
+	Unassigner
+	GroupAssigner
+	GroupUnassigner
+	Granter
+	HolderLister
+	Err() error
+}
+
+type Saver interface {
+	Save(*Role, sq.BaseRunner)
+}
+
+type Deleter interface {
+	Delete(string, sq.BaseRunner)
+}
+
+type Lister interface {
+	List(sq.BaseRunner) []*Role
+}
+
+type Assigner interface {
+	Assign(string, string, sq.BaseRunner)
+}
+
+type Unassigner interface {
+	Unassign(string, string, sq.BaseRunner)
+}
+
+type GroupAssigner interface {
+	AssignGroup(string, string, sq.BaseRunner)
+}
+
+type GroupUnassigner interface {
+	UnassignGroup(string, string, sq.BaseRunner)
+}
+
+type Granter interface {
+	Grants(string, []string, sq.BaseRunner) ([]string, []string)
+}
+
+type HolderLister interface {
+	Holders(string, sq.BaseRunner) ([]string, []string)
+}
+
+type RoleClient struct {
+	err error
+}
+
+// Save creates r or replaces its description and permissions in the auth.Roles and auth.RolePermissions tables in db.
+func (rc *RoleClient) Save(r *Role, db sq.BaseRunner) {
+	if rc.err != nil {
+		return
+	}
+	if err := r.Check(); err != nil {
+		rc.err = err
+		return
+	}
+
+	update := sq.Update("[auth].[Roles]").Set("[Description]", r.Description).Where(sq.Eq{"[Role]": r.Name})
+	res, err := update.RunWith(db).Exec()
+	if err != nil {
+		log.Print(err)
+		rc.err = err
+		return
+	}
+	if cnt, err := res.RowsAffected(); err != nil || cnt == 0 {
+		insert := sq.Insert("[auth].[Roles]").Columns("[Role]", "[Description]").Values(r.Name, r.Description)
+		if _, err := insert.RunWith(db).Exec(); err != nil {
+			log.Print(err)
+			rc.err = err
+			return
+		}
+	}
+
+	del := sq.Delete("[auth].[RolePermissions]").Where(sq.Eq{"[Role]": r.Name})
+	if _, err := del.RunWith(db).Exec(); err != nil {
+		log.Print(err)
+		rc.err = err
+		return
+	}
+	if len(r.Permissions) == 0 {
+		return
+	}
+	insert := sq.Insert("[auth].[RolePermissions]").Columns("[Role]", "[Permission]")
+	for _, p := range dedupe(r.Permissions) {
+		insert = insert.Values(r.Name, p)
+	}
+	if _, err := insert.RunWith(db).Exec(); err != nil {
+		log.Print(err)
+		rc.err = err
+	}
+}
+
+// Delete removes role from the auth.Roles table in db, taking it away from every user that has it.
+func (rc *RoleClient) Delete(role string, db sq.BaseRunner) {
+	if rc.err != nil {
+		return
+	}
+
+	del := sq.Delete("[auth].[Roles]").Where(sq.Eq{"[Role]": role})
+	res, err := del.RunWith(db).Exec()
+	if err != nil {
+		log.Print(err)
+		rc.err = err
+		return
+	}
+	if cnt, err := res.RowsAffected(); err != nil || cnt != 1 {
+		rc.err = ErrorRoleNotFound
+	}
+}
+
+// List returns every role with its permissions, ordered by name.
+func (rc *RoleClient) List(db sq.BaseRunner) (roles []*Role) {
+	if rc.err != nil {
+		return
+	}
+
+	sel := sq.Select("r.[Role]", "r.[Description]", "p.[Permission]").
+		From("[auth].[Roles] r").
+		LeftJoin("[auth].[RolePermissions] p ON p.[Role] = r.[Role]").
+		OrderBy("r.[Role]", "p.[Permission]")
+	rows, err := sel.RunWith(db).Query()
+	if err != nil {
+		log.Print(err)
+		rc.err = err
+		return
+	}
+	defer rows.Close()
+
+	var last *Role
+	for rows.Next() {
+		var (
+			name, description string
+			permission        *string
+		)
+		if err := rows.Scan(&name, &description, &permission); err != nil {
+			log.Print(err)
+			rc.err = err
+			return
+		}
+		if last == nil || last.Name != name {
+			last = &Role{Name: name, Description: description, Permissions: []string{}}
+			roles = append(roles, last)
+		}
+		if permission != nil {
+			last.Permissions = append(last.Permissions, *permission)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		log.Print(err)
+		rc.err = err
+	}
+	return
+}
+
+// Assign gives role to the user with ID id. Assigning a role the user already has does nothing.
+func (rc *RoleClient) Assign(id, role string, db sq.BaseRunner) {
+	if rc.err != nil {
+		return
+	}
+	if err := CheckRole(role); err != nil {
+		rc.err = err
+		return
+	}
+
+	insert := sq.Insert("[auth].[UserRoles]").Columns("[ID]", "[Role]").Values(id, role)
+	if _, err := insert.RunWith(db).Exec(); err != nil {
+		switch {
+		case strings.Contains(err.Error(), "PK_UserRoles"):
+		case strings.Contains(err.Error(), "FK_UserRoles_Roles"):
+			rc.err = ErrorRoleNotFound
+		default:
+			log.Print(err)
+			rc.err = err
+		}
+	}
+}
+
+// Unassign takes role away from the user with ID id.
+func (rc *RoleClient) Unassign(id, role string, db sq.BaseRunner) {
+	if rc.err != nil {
+		return
+	}
+
+	del := sq.Delete("[auth].[UserRoles]").Where(sq.Eq{"[ID]": id, "[Role]": role})
+	res, err := del.RunWith(db).Exec()
+	if err != nil {
+		log.Print(err)
+		rc.err = err
+		return
+	}
+	if cnt, err := res.RowsAffected(); err != nil || cnt != 1 {
+		rc.err = ErrorRoleNotFound
+	}
+}
+
+// AssignGroup gives role to every member of the group name and fails with group.ErrorGroupNotFound if it does not exist.
+// Assigning a role the group already has does nothing.
+func (rc *RoleClient) AssignGroup(name, role string, db sq.BaseRunner) {
+	if rc.err != nil {
+		return
+	}
+	if err := CheckRole(role); err != nil {
+		rc.err = err
+		return
+	}
+
+	insert := sq.Insert("[auth].[GroupRoles]").Columns("[Group]", "[Role]").Values(name, role)
+	if _, err := insert.RunWith(db).Exec(); err != nil {
+		switch {
+		case strings.Contains(err.Error(), "PK_GroupRoles"):
+		case strings.Contains(err.Error(), "FK_GroupRoles_Roles"):
+			rc.err = ErrorRoleNotFound
+		case strings.Contains(err.Error(), "FK_GroupRoles_Groups"):
+			rc.err = group.ErrorGroupNotFound
+		default:
+			log.Print(err)
+			rc.err = err
+		}
+	}
+}
+
+// UnassignGroup takes role away from the group name.
+func (rc *RoleClient) UnassignGroup(name, role string, db sq.BaseRunner) {
+	if rc.err != nil {
+		return
+	}
+
+	del := sq.Delete("[auth].[GroupRoles]").Where(sq.Eq{"[Group]": name, "[Role]": role})
+	res, err := del.RunWith(db).Exec()
+	if err != nil {
+		log.Print(err)
+		rc.err = err
+		return
+	}
+	if cnt, err := res.RowsAffected(); err != nil || cnt != 1 {
+		rc.err = ErrorRoleNotFound
+	}
+}
+
+// Grants returns the roles of the user with ID id, including the roles of groups, and the permissions
+// they grant, both sorted and without duplicates. groups must already include the groups the user is
+// a member of through subgroups; see group.Memberships.
+func (rc *RoleClient) Grants(id string, groups []string, db sq.BaseRunner) (roles []string, permissions []string) {
+	if rc.err != nil {
+		return
+	}
+
+	sel := sq.Select("ur.[Role]", "rp.[Permission]").
+		From("[auth].[UserRoles] ur").
+		LeftJoin("[auth].[RolePermissions] rp ON rp.[Role] = ur.[Role]").
+		Where(sq.Eq{"ur.[ID]": id})
+	if roles, permissions = rc.grants(sel, db); rc.err != nil || len(groups) == 0 {
+		return dedupe(roles), dedupe(permissions)
+	}
+
+	sel = sq.Select("gr.[Role]", "rp.[Permission]").
+		From("[auth].[GroupRoles] gr").
+		LeftJoin("[auth].[RolePermissions] rp ON rp.[Role] = gr.[Role]").
+		Where(sq.Eq{"gr.[Group]": groups})
+	groupRoles, groupPermissions := rc.grants(sel, db)
+	if rc.err != nil {
+		return nil, nil
+	}
+	return dedupe(append(roles, groupRoles...)), dedupe(append(permissions, groupPermissions...))
+}
+
+// grants returns the roles and permissions in the rows selected by sel.
+func (rc *RoleClient) grants(sel sq.SelectBuilder, db sq.BaseRunner) (roles []string, permissions []string) {
+	rows, err := sel.RunWith(db).Query()
+	if err != nil {
+		log.Print(err)
+		rc.err = err
+		return
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			role       string
+			permission *string
+		)
+		if err := rows.Scan(&role, &permission); err != nil {
+			log.Print(err)
+			rc.err = err
+			return nil, nil
+		}
+		roles = append(roles, role)
+		if permission != nil {
+			permissions = append(permissions, *permission)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		log.Print(err)
+		rc.err = err
+		return nil, nil
+	}
+	return
+}
+
+// Holders returns the IDs of the users role is assigned to and the groups it is assigned to, both sorted.
+// Members of the groups hold role too; see group.MemberIDs.
+func (rc *RoleClient) Holders(role string, db sq.BaseRunner) (ids []string, groups []string) {
+	if rc.err != nil {
+		return
+	}
+
+	sel := sq.Select("[ID]").From("[auth].[UserRoles]").Where(sq.Eq{"[Role]": role}).OrderBy("[ID]")
+	if ids = rc.strings(sel, db); rc.err != nil {
+		return nil, nil
+	}
+	sel = sq.Select("[Group]").From("[auth].[GroupRoles]").Where(sq.Eq{"[Role]": role}).OrderBy("[Group]")
+	if groups = rc.strings(sel, db); rc.err != nil {
+		return nil, nil
+	}
+	return
+}
+
+// strings returns the first column of the rows selected by sel.
+func (rc *RoleClient) strings(sel sq.SelectBuilder, db sq.BaseRunner) (s []string) {
+	rows, err := sel.RunWith(db).Query()
+	if err != nil {
+		log.Print(err)
+		rc.err = err
+		return
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var v string
+		if err := rows.Scan(&v); err != nil {
+			log.Print(err)
+			rc.err = err
+			return nil
+		}
+		s = append(s, v)
+	}
+	if err := rows.Err(); err != nil {
+		log.Print(err)
+		rc.err = err
+		return nil
+	}
+	return
+}
+
+// dedupe returns the distinct elements of s, sorted.
+func dedupe(s []string) []string {
+	if len(s) == 0 {
+		return s
+	}
+	sorted := append([]string(nil), s...)
+	sort.Strings(sorted)
+	out := sorted[:1]
+	for _, v := range sorted[1:] {
+		if v != out[len(out)-1] {
+			out = append(out, v)
+		}
+	}
+	return out
+}
+
+// Err returns the error status of a RoleClient.
+func (rc *RoleClient) Err() error {
+	return rc.err
+}
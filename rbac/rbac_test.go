@@ -0,0 +1,351 @@
+package rbac
+
+import (
+	"errors"
+	"github.com/penutty/authservice/group"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/DATA-DOG/go-sqlmock.v1"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+var (
+	tID    = "01890a5d-ac96-774b-bcce-b302099a8057"
+	tRole  = "editor"
+	tGroup = "engineering"
+)
+
+func Test_Check(t *testing.T) {
+	type test struct {
+		role *Role
+		err  error
+	}
+	cases := []*test{
+		&test{&Role{Name: tRole, Permissions: []string{"moments:read", "moments:*"}}, nil},
+		&test{&Role{Name: "Editor"}, ErrorRoleInvalid},
+		&test{&Role{Name: ""}, ErrorRoleInvalid},
+		&test{&Role{Name: tRole, Permissions: []string{"moments read"}}, ErrorPermissionInvalid},
+		&test{&Role{Name: tRole, Description: strings.Repeat("d", 257)}, ErrorDescriptionLong},
+	}
+	for i, c := range cases {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			assert.Equal(t, c.err, c.role.Check())
+		})
+	}
+}
+
+func Test_Save(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("An error occured when opening a stub database connection. ERROR: %v\n", err)
+	}
+	defer db.Close()
+
+	t.Run("1", func(t *testing.T) {
+		mock.ExpectExec(`UPDATE \[auth]\.\[Roles] SET \[Description] = \? WHERE \[Role] = \?`).
+			WithArgs("Edits moments", tRole).
+			WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectExec(`INSERT INTO \[auth]\.\[Roles] \(\[Role],\[Description]\) VALUES \(\?,\?\)`).
+			WithArgs(tRole, "Edits moments").
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec(`DELETE FROM \[auth]\.\[RolePermissions] WHERE \[Role] = \?`).
+			WithArgs(tRole).
+			WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectExec(`INSERT INTO \[auth]\.\[RolePermissions] \(\[Role],\[Permission]\) VALUES \(\?,\?\),\(\?,\?\)`).
+			WithArgs(tRole, "moments:read", tRole, "moments:write").
+			WillReturnResult(sqlmock.NewResult(0, 2))
+
+		rc := new(RoleClient)
+		rc.Save(&Role{Name: tRole, Description: "Edits moments", Permissions: []string{"moments:write", "moments:read", "moments:write"}}, db)
+		assert.Nil(t, rc.Err())
+
+		if err = mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("Expectations were not met. ERROR: %v\n", err)
+		}
+	})
+
+	t.Run("2", func(t *testing.T) {
+		mock.ExpectExec(`UPDATE \[auth]\.\[Roles]`).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec(`DELETE FROM \[auth]\.\[RolePermissions]`).
+			WillReturnResult(sqlmock.NewResult(0, 2))
+
+		rc := new(RoleClient)
+		rc.Save(&Role{Name: tRole}, db)
+		assert.Nil(t, rc.Err())
+
+		if err = mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("Expectations were not met. ERROR: %v\n", err)
+		}
+	})
+
+	t.Run("3", func(t *testing.T) {
+		rc := new(RoleClient)
+		rc.Save(&Role{Name: "Editor"}, db)
+		assert.Equal(t, ErrorRoleInvalid, rc.Err())
+	})
+}
+
+func Test_Delete(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("An error occured when opening a stub database connection. ERROR: %v\n", err)
+	}
+	defer db.Close()
+
+	cases := []struct {
+		affected int64
+		err      error
+	}{
+		{1, nil},
+		{0, ErrorRoleNotFound},
+	}
+	for i, c := range cases {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			mock.ExpectExec(`DELETE FROM \[auth]\.\[Roles] WHERE \[Role] = \?`).
+				WithArgs(tRole).
+				WillReturnResult(sqlmock.NewResult(0, c.affected))
+
+			rc := new(RoleClient)
+			rc.Delete(tRole, db)
+			assert.Equal(t, c.err, rc.Err())
+
+			if err = mock.ExpectationsWereMet(); err != nil {
+				t.Errorf("Expectations were not met. ERROR: %v\n", err)
+			}
+		})
+	}
+}
+
+func Test_List(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("An error occured when opening a stub database connection. ERROR: %v\n", err)
+	}
+	defer db.Close()
+
+	mock.ExpectQuery(`SELECT r\.\[Role], r\.\[Description], p\.\[Permission] FROM \[auth]\.\[Roles] r LEFT JOIN \[auth]\.\[RolePermissions] p ON p\.\[Role] = r\.\[Role] ORDER BY r\.\[Role], p\.\[Permission]`).
+		WillReturnRows(sqlmock.NewRows([]string{"Role", "Description", "Permission"}).
+			AddRow(tRole, "Edits moments", "moments:read").
+			AddRow(tRole, "Edits moments", "moments:write").
+			AddRow("viewer", "", nil))
+
+	rc := new(RoleClient)
+	roles := rc.List(db)
+	assert.Nil(t, rc.Err())
+	assert.Equal(t, []*Role{
+		&Role{Name: tRole, Description: "Edits moments", Permissions: []string{"moments:read", "moments:write"}},
+		&Role{Name: "viewer", Permissions: []string{}},
+	}, roles)
+
+	if err = mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Expectations were not met. ERROR: %v\n", err)
+	}
+}
+
+func Test_Assign(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("An error occured when opening a stub database connection. ERROR: %v\n", err)
+	}
+	defer db.Close()
+
+	cases := []struct {
+		err    error
+		expect error
+	}{
+		{nil, nil},
+		{errors.New("Violation of PRIMARY KEY constraint 'PK_UserRoles'."), nil},
+		{errors.New("The INSERT statement conflicted with the FOREIGN KEY constraint \"FK_UserRoles_Roles\"."), ErrorRoleNotFound},
+	}
+	for i, c := range cases {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			exec := mock.ExpectExec(`INSERT INTO \[auth]\.\[UserRoles] \(\[ID],\[Role]\) VALUES \(\?,\?\)`).WithArgs(tID, tRole)
+			if c.err != nil {
+				exec.WillReturnError(c.err)
+			} else {
+				exec.WillReturnResult(sqlmock.NewResult(0, 1))
+			}
+
+			rc := new(RoleClient)
+			rc.Assign(tID, tRole, db)
+			assert.Equal(t, c.expect, rc.Err())
+
+			if err = mock.ExpectationsWereMet(); err != nil {
+				t.Errorf("Expectations were not met. ERROR: %v\n", err)
+			}
+		})
+	}
+
+	t.Run(strconv.Itoa(len(cases)), func(t *testing.T) {
+		rc := new(RoleClient)
+		rc.Assign(tID, "Editor", db)
+		assert.Equal(t, ErrorRoleInvalid, rc.Err())
+	})
+}
+
+func Test_Unassign(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("An error occured when opening a stub database connection. ERROR: %v\n", err)
+	}
+	defer db.Close()
+
+	cases := []struct {
+		affected int64
+		err      error
+	}{
+		{1, nil},
+		{0, ErrorRoleNotFound},
+	}
+	for i, c := range cases {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			mock.ExpectExec(`DELETE FROM \[auth]\.\[UserRoles] WHERE \[ID] = \? AND \[Role] = \?`).
+				WithArgs(tID, tRole).
+				WillReturnResult(sqlmock.NewResult(0, c.affected))
+
+			rc := new(RoleClient)
+			rc.Unassign(tID, tRole, db)
+			assert.Equal(t, c.err, rc.Err())
+
+			if err = mock.ExpectationsWereMet(); err != nil {
+				t.Errorf("Expectations were not met. ERROR: %v\n", err)
+			}
+		})
+	}
+}
+
+func Test_AssignGroup(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("An error occured when opening a stub database connection. ERROR: %v\n", err)
+	}
+	defer db.Close()
+
+	cases := []struct {
+		err    error
+		expect error
+	}{
+		{nil, nil},
+		{errors.New("Violation of PRIMARY KEY constraint 'PK_GroupRoles'."), nil},
+		{errors.New("The INSERT statement conflicted with the FOREIGN KEY constraint \"FK_GroupRoles_Roles\"."), ErrorRoleNotFound},
+		{errors.New("The INSERT statement conflicted with the FOREIGN KEY constraint \"FK_GroupRoles_Groups\"."), group.ErrorGroupNotFound},
+	}
+	for i, c := range cases {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			exec := mock.ExpectExec(`INSERT INTO \[auth]\.\[GroupRoles] \(\[Group],\[Role]\) VALUES \(\?,\?\)`).WithArgs(tGroup, tRole)
+			if c.err != nil {
+				exec.WillReturnError(c.err)
+			} else {
+				exec.WillReturnResult(sqlmock.NewResult(0, 1))
+			}
+
+			rc := new(RoleClient)
+			rc.AssignGroup(tGroup, tRole, db)
+			assert.Equal(t, c.expect, rc.Err())
+
+			if err = mock.ExpectationsWereMet(); err != nil {
+				t.Errorf("Expectations were not met. ERROR: %v\n", err)
+			}
+		})
+	}
+}
+
+func Test_UnassignGroup(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("An error occured when opening a stub database connection. ERROR: %v\n", err)
+	}
+	defer db.Close()
+
+	cases := []struct {
+		affected int64
+		err      error
+	}{
+		{1, nil},
+		{0, ErrorRoleNotFound},
+	}
+	for i, c := range cases {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			mock.ExpectExec(`DELETE FROM \[auth]\.\[GroupRoles] WHERE \[Group] = \? AND \[Role] = \?`).
+				WithArgs(tGroup, tRole).
+				WillReturnResult(sqlmock.NewResult(0, c.affected))
+
+			rc := new(RoleClient)
+			rc.UnassignGroup(tGroup, tRole, db)
+			assert.Equal(t, c.err, rc.Err())
+
+			if err = mock.ExpectationsWereMet(); err != nil {
+				t.Errorf("Expectations were not met. ERROR: %v\n", err)
+			}
+		})
+	}
+}
+
+func Test_Grants(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("An error occured when opening a stub database connection. ERROR: %v\n", err)
+	}
+	defer db.Close()
+
+	mock.ExpectQuery(`SELECT ur\.\[Role], rp\.\[Permission] FROM \[auth]\.\[UserRoles] ur LEFT JOIN \[auth]\.\[RolePermissions] rp ON rp\.\[Role] = ur\.\[Role] WHERE ur\.\[ID] = \?`).
+		WithArgs(tID).
+		WillReturnRows(sqlmock.NewRows([]string{"Role", "Permission"}).
+			AddRow(tRole, "moments:write").
+			AddRow(tRole, "moments:read").
+			AddRow("viewer", "moments:read").
+			AddRow("empty", nil))
+
+	rc := new(RoleClient)
+	roles, permissions := rc.Grants(tID, nil, db)
+	assert.Nil(t, rc.Err())
+	assert.Equal(t, []string{"editor", "empty", "viewer"}, roles)
+	assert.Equal(t, []string{"moments:read", "moments:write"}, permissions)
+
+	mock.ExpectQuery(`SELECT ur\.\[Role], rp\.\[Permission] FROM \[auth]\.\[UserRoles] ur .* WHERE ur\.\[ID] = \?`).
+		WithArgs(tID).
+		WillReturnRows(sqlmock.NewRows([]string{"Role", "Permission"}).
+			AddRow(tRole, "moments:read"))
+	mock.ExpectQuery(`SELECT gr\.\[Role], rp\.\[Permission] FROM \[auth]\.\[GroupRoles] gr LEFT JOIN \[auth]\.\[RolePermissions] rp ON rp\.\[Role] = gr\.\[Role] WHERE gr\.\[Group] IN \(\?,\?\)`).
+		WithArgs(tGroup, "staff").
+		WillReturnRows(sqlmock.NewRows([]string{"Role", "Permission"}).
+			AddRow(tRole, "moments:write").
+			AddRow("auditor", "audit:read"))
+
+	rc = new(RoleClient)
+	roles, permissions = rc.Grants(tID, []string{tGroup, "staff"}, db)
+	assert.Nil(t, rc.Err())
+	assert.Equal(t, []string{"auditor", "editor"}, roles)
+	assert.Equal(t, []string{"audit:read", "moments:read", "moments:write"}, permissions)
+
+	if err = mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Expectations were not met. ERROR: %v\n", err)
+	}
+}
+
+func Test_Holders(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("An error occured when opening a stub database connection. ERROR: %v\n", err)
+	}
+	defer db.Close()
+
+	mock.ExpectQuery(`SELECT \[ID] FROM \[auth]\.\[UserRoles] WHERE \[Role] = \? ORDER BY \[ID]`).
+		WithArgs(tRole).
+		WillReturnRows(sqlmock.NewRows([]string{"ID"}).AddRow(tID))
+	mock.ExpectQuery(`SELECT \[Group] FROM \[auth]\.\[GroupRoles] WHERE \[Role] = \? ORDER BY \[Group]`).
+		WithArgs(tRole).
+		WillReturnRows(sqlmock.NewRows([]string{"Group"}).AddRow(tGroup))
+
+	rc := new(RoleClient)
+	ids, groups := rc.Holders(tRole, db)
+	assert.Nil(t, rc.Err())
+	assert.Equal(t, []string{tID}, ids)
+	assert.Equal(t, []string{tGroup}, groups)
+
+	if err = mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Expectations were not met. ERROR: %v\n", err)
+	}
+}
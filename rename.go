@@ -0,0 +1,65 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/penutty/authservice/audit"
+	"github.com/penutty/authservice/user"
+	"net/http"
+)
+
+func (a *app) renameHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodPost:
+		res, err := a.postRename(r)
+		if err != nil {
+			genErrorHandler(w, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(res)
+	default:
+		logger(Error).Println(ErrorMethodNotImplemented)
+		http.Error(w, http.StatusText(http.StatusNotImplemented), http.StatusNotImplemented)
+	}
+}
+
+// postRename changes the UserID of the caller, or as an administrator of any user, to the UserID in the body.
+// The body must contain the password of the caller. Tokens stay valid since their subject is the ID of the user;
+// the former UserID stays reserved for the user for user.RenameGracePeriod. The change is recorded in the audit log.
+func (a *app) postRename(r *http.Request) (*userResource, error) {
+	caller, u, err := a.loginTargetUser(r)
+	if err != nil {
+		return nil, err
+	}
+
+	type body struct {
+		UserID   string
+		Password string
+	}
+	b := new(body)
+	if err := json.NewDecoder(r.Body).Decode(b); err != nil {
+		return nil, err
+	}
+	if caller.Password() != b.Password {
+		return nil, ErrorInvalidPass
+	}
+
+	// Fetching the TOTP enrollment seals its secret to the ID of u if it is still sealed to the UserID being renamed.
+	a.m.Fetch(u, user.AuthDB())
+	if err := a.m.Err(); err != nil {
+		return nil, err
+	}
+
+	from := u.UserID()
+	a.c.Rename(u, qualify(r, b.UserID), user.AuthDB())
+	if err := a.c.Err(); err != nil {
+		return nil, err
+	}
+
+	a.au.Record(audit.NewEntry(caller.ID(), u.ID(), "rename", fmt.Sprintf("%s -> %s", from, u.UserID())), user.AuthDB())
+	if err := a.au.Err(); err != nil {
+		logger(Error).Println(err)
+	}
+	return newUserResource(u), nil
+}
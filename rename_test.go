@@ -0,0 +1,86 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func NewRenameBody(u, p string) *strings.Reader {
+	return strings.NewReader(fmt.Sprintf("{\"UserID\": \"%s\", \"Password\": \"%s\"}", u, p))
+}
+
+func Test_renameHandler(t *testing.T) {
+	defer func(admins []string) { Administrators = admins }(Administrators)
+
+	newUser := "renameduser"
+	type test struct {
+		req     *http.Request
+		admins  []string
+		code    int
+		id      string
+		renamed string
+	}
+	cases := []*test{
+		&test{NewBearerRequest(http.MethodPost, RenameEndpoint, NewRenameBody(newUser, tPassword)), nil, http.StatusOK, tID, newUser},
+		&test{NewBearerRequest(http.MethodPost, RenameEndpoint, NewRenameBody(newUser, "WrongPassword1!")), nil, http.StatusBadRequest, "", ""},
+		&test{NewBearerRequest(http.MethodPost, RenameEndpoint, NewRenameBody(tOtherUser, tPassword)), nil, http.StatusConflict, "", ""},
+		&test{NewBearerRequest(http.MethodPost, RenameEndpoint, NewRenameBody("bad!", tPassword)), nil, http.StatusBadRequest, "", ""},
+		&test{NewBearerRequest(http.MethodPost, RenameEndpoint+"?UserID="+tOtherUser, NewRenameBody(newUser, tPassword)), nil, http.StatusForbidden, "", ""},
+		&test{NewBearerRequest(http.MethodPost, RenameEndpoint+"?UserID="+tOtherUser, NewRenameBody(newUser, tPassword)), []string{tUser}, http.StatusOK, "id-" + tOtherUser, newUser},
+		&test{httptest.NewRequest(http.MethodPost, RenameEndpoint, NewRenameBody(newUser, tPassword)), nil, http.StatusUnauthorized, "", ""},
+		&test{NewBearerRequest(http.MethodGet, RenameEndpoint, nil), nil, http.StatusNotImplemented, "", ""},
+	}
+	for i, c := range cases {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			Administrators = administrators(c.admins...)
+			a, m, _ := newVerifyApp()
+			au := new(MockAuditClient)
+			a.au = au
+			rec := httptest.NewRecorder()
+			a.renameHandler(rec, c.req)
+			assert.Equal(t, c.code, rec.Code)
+			assert.Equal(t, c.renamed, m.renamed)
+			if c.code != http.StatusOK {
+				assert.Empty(t, au.entries)
+				return
+			}
+
+			res := new(userResource)
+			assert.Nil(t, json.NewDecoder(rec.Body).Decode(res))
+			assert.Equal(t, c.id, res.ID)
+			assert.Equal(t, c.renamed, res.UserID)
+			assert.Len(t, au.entries, 1)
+			assert.Equal(t, tID, au.entries[0].Actor)
+			assert.Equal(t, c.id, au.entries[0].Subject)
+			assert.Equal(t, "rename", au.entries[0].Action)
+		})
+	}
+}
+
+// TOTP enrolled before a rename still completes a login afterwards.
+func Test_renameHandler_mfa(t *testing.T) {
+	a, m, _ := newVerifyApp()
+	a.au = new(MockAuditClient)
+	mc := new(MockMFAClient)
+	a.m = mc
+	mc.Confirm(mc.Enroll(m.FetchByID(tID, nil), nil), 0, nil)
+
+	rec := httptest.NewRecorder()
+	a.renameHandler(rec, NewBearerRequest(http.MethodPost, RenameEndpoint, NewRenameBody("renameduser", tPassword)))
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	challenge, err := generateChallenge(tID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec = httptest.NewRecorder()
+	a.mfaHandler(rec, httptest.NewRequest(http.MethodPost, MFAEndpoint, NewMFABody(challenge, currentCode())))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.NotEmpty(t, rec.Header().Get("jwt"))
+}
@@ -0,0 +1,140 @@
+// Package reset is dedicated to reading and writing password reset tokens in Auth-Db.
+// A user may hold one outstanding reset token at a time. Tokens are looked up by their hash;
+// the token itself is only ever known to the recipient of the reset email.
+package reset
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	sq "github.com/Masterminds/squirrel"
+	"log"
+	"time"
+)
+
+var (
+	TokenLifetime = 30 * time.Minute
+
+	ErrorUserIDParameterInvalid = errors.New("Reset.userID must be a valid userID.")
+	ErrorTokenInvalid           = errors.New("Password reset token is invalid.")
+	ErrorTokenExpired           = errors.New("Password reset token has expired.")
+	ErrorResetRowNotCreated     = errors.New("Issue failed to create one row in the auth.PasswordResets table.")
+)
+
+type Client interface {
+	Issuer
+	Redeemer
+	Err() error
+}
+
+type Issuer interface {
+	Issue(string, sq.BaseRunner) string
+}
+
+type Redeemer interface {
+	Redeem(string, sq.BaseRunner) string
+}
+
+type ResetClient struct {
+	err error
+}
+
+// GenerateToken returns a random password reset token.
+func GenerateToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// HashToken returns the hex encoded SHA-256 digest of a reset token as stored in auth.PasswordResets.
+func HashToken(token string) string {
+	h := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(h[:])
+}
+
+// Issue generates a reset token for userID, replacing any outstanding token, and returns it.
+func (rc *ResetClient) Issue(userID string, db sq.BaseRunner) string {
+	if rc.err != nil {
+		return ""
+	}
+	if userID == "" {
+		rc.err = ErrorUserIDParameterInvalid
+		return ""
+	}
+	token, err := GenerateToken()
+	if err != nil {
+		rc.err = err
+		return ""
+	}
+
+	del := sq.Delete("[auth].[PasswordResets]").Where(sq.Eq{"[UserID]": userID})
+	if _, err := del.RunWith(db).Exec(); err != nil {
+		log.Print(err)
+		rc.err = err
+		return ""
+	}
+
+	insert := sq.Insert("[auth].[PasswordResets]").
+		Columns("[UserID]", "[Token]", "[Expires]").
+		Values(userID, HashToken(token), time.Now().UTC().Add(TokenLifetime))
+	res, err := insert.RunWith(db).Exec()
+	if err != nil {
+		log.Print(err)
+		rc.err = err
+		return ""
+	}
+	if cnt, err := res.RowsAffected(); err != nil || cnt != 1 {
+		log.Print(ErrorResetRowNotCreated)
+		rc.err = ErrorResetRowNotCreated
+		return ""
+	}
+	return token
+}
+
+// Redeem consumes a reset token and returns the userID it was issued to.
+func (rc *ResetClient) Redeem(token string, db sq.BaseRunner) (userID string) {
+	if rc.err != nil {
+		return
+	}
+
+	var expires time.Time
+	hash := HashToken(token)
+	sel := sq.Select("[UserID], [Expires]").From("[auth].[PasswordResets]").Where(sq.Eq{"[Token]": hash})
+	err := sel.RunWith(db).QueryRow().Scan(&userID, &expires)
+	if err == sql.ErrNoRows {
+		rc.err = ErrorTokenInvalid
+		return
+	}
+	if err != nil {
+		log.Print(err)
+		rc.err = err
+		return
+	}
+
+	del := sq.Delete("[auth].[PasswordResets]").Where(sq.Eq{"[Token]": hash})
+	res, err := del.RunWith(db).Exec()
+	if err != nil {
+		log.Print(err)
+		rc.err = err
+		return ""
+	}
+	if cnt, err := res.RowsAffected(); err != nil || cnt != 1 {
+		rc.err = ErrorTokenInvalid
+		return ""
+	}
+	if time.Now().UTC().After(expires) {
+		rc.err = ErrorTokenExpired
+		return ""
+	}
+	return
+}
+
+// Err returns the error status of a ResetClient instance.
+func (rc *ResetClient) Err() error {
+	return rc.err
+}
@@ -0,0 +1,119 @@
+package reset
+
+import (
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/DATA-DOG/go-sqlmock.v1"
+	"testing"
+	"time"
+)
+
+var tUser = "testuser"
+
+func Test_GenerateToken(t *testing.T) {
+	a, err := GenerateToken()
+	assert.Nil(t, err)
+	b, err := GenerateToken()
+	assert.Nil(t, err)
+	assert.Len(t, a, 43)
+	assert.NotEqual(t, a, b)
+	assert.Len(t, HashToken(a), 64)
+}
+
+func Test_Issue(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("An error occured when opening a stub database connection. ERROR: %v\n", err)
+	}
+	defer db.Close()
+
+	t.Run("1", func(t *testing.T) {
+		mock.ExpectExec(`DELETE FROM \[auth]\.\[PasswordResets] WHERE \[UserID] = \?`).
+			WithArgs(tUser).
+			WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectExec(`INSERT INTO \[auth]\.\[PasswordResets] \(\[UserID],\[Token],\[Expires]\) VALUES \(\?,\?,\?\)`).
+			WithArgs(tUser, sqlmock.AnyArg(), sqlmock.AnyArg()).
+			WillReturnResult(sqlmock.NewResult(1, 1))
+
+		rc := new(ResetClient)
+		token := rc.Issue(tUser, db)
+		assert.Nil(t, rc.Err())
+		assert.NotEmpty(t, token)
+
+		if err = mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("Expectations were not met. ERROR: %v\n", err)
+		}
+	})
+
+	t.Run("2", func(t *testing.T) {
+		rc := new(ResetClient)
+		_ = rc.Issue("", db)
+		assert.EqualError(t, rc.Err(), ErrorUserIDParameterInvalid.Error())
+	})
+}
+
+func Test_Redeem(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("An error occured when opening a stub database connection. ERROR: %v\n", err)
+	}
+	defer db.Close()
+
+	columns := []string{"UserID", "Expires"}
+	token := "token"
+	sel := `SELECT \[UserID], \[Expires] FROM \[auth]\.\[PasswordResets] WHERE \[Token] = \?`
+	del := `DELETE FROM \[auth]\.\[PasswordResets] WHERE \[Token] = \?`
+
+	t.Run("valid", func(t *testing.T) {
+		mock.ExpectQuery(sel).WithArgs(HashToken(token)).
+			WillReturnRows(sqlmock.NewRows(columns).AddRow(tUser, time.Now().UTC().Add(time.Minute)))
+		mock.ExpectExec(del).WithArgs(HashToken(token)).WillReturnResult(sqlmock.NewResult(0, 1))
+
+		rc := new(ResetClient)
+		assert.Equal(t, tUser, rc.Redeem(token, db))
+		assert.Nil(t, rc.Err())
+
+		if err = mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("Expectations were not met. ERROR: %v\n", err)
+		}
+	})
+
+	t.Run("expired", func(t *testing.T) {
+		mock.ExpectQuery(sel).WithArgs(HashToken(token)).
+			WillReturnRows(sqlmock.NewRows(columns).AddRow(tUser, time.Now().UTC().Add(-time.Minute)))
+		mock.ExpectExec(del).WithArgs(HashToken(token)).WillReturnResult(sqlmock.NewResult(0, 1))
+
+		rc := new(ResetClient)
+		assert.Empty(t, rc.Redeem(token, db))
+		assert.EqualError(t, rc.Err(), ErrorTokenExpired.Error())
+
+		if err = mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("Expectations were not met. ERROR: %v\n", err)
+		}
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		mock.ExpectQuery(sel).WithArgs(HashToken(token)).WillReturnRows(sqlmock.NewRows(columns))
+
+		rc := new(ResetClient)
+		assert.Empty(t, rc.Redeem(token, db))
+		assert.EqualError(t, rc.Err(), ErrorTokenInvalid.Error())
+
+		if err = mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("Expectations were not met. ERROR: %v\n", err)
+		}
+	})
+
+	t.Run("reused", func(t *testing.T) {
+		mock.ExpectQuery(sel).WithArgs(HashToken(token)).
+			WillReturnRows(sqlmock.NewRows(columns).AddRow(tUser, time.Now().UTC().Add(time.Minute)))
+		mock.ExpectExec(del).WithArgs(HashToken(token)).WillReturnResult(sqlmock.NewResult(0, 0))
+
+		rc := new(ResetClient)
+		assert.Empty(t, rc.Redeem(token, db))
+		assert.EqualError(t, rc.Err(), ErrorTokenInvalid.Error())
+
+		if err = mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("Expectations were not met. ERROR: %v\n", err)
+		}
+	})
+}
@@ -0,0 +1,237 @@
+package main
+
+import (
+	"encoding/json"
+	"github.com/penutty/authservice/audit"
+	"github.com/penutty/authservice/rbac"
+	"github.com/penutty/authservice/user"
+	"net/http"
+)
+
+// userRolesResource is the representation of the roles of a user returned by UserRolesEndpoint.
+type userRolesResource struct {
+	UserID      string
+	Roles       []string
+	Permissions []string
+}
+
+// administrator authenticates r and returns ErrorUserForbidden unless the caller is an administrator.
+func (a *app) administrator(r *http.Request) (*user.User, error) {
+	caller, err := a.authenticateLogin(r)
+	if err != nil {
+		return nil, err
+	}
+	if !isAdministrator(caller.ID()) {
+		return nil, ErrorUserForbidden
+	}
+	return caller, nil
+}
+
+func (a *app) rolesHandler(w http.ResponseWriter, r *http.Request) {
+	var (
+		res interface{}
+		err error
+	)
+	switch r.Method {
+	case http.MethodGet:
+		res, err = a.getRoles(r)
+	case http.MethodPut:
+		res, err = a.putRole(r)
+	case http.MethodDelete:
+		if err := a.deleteRole(r); err != nil {
+			genErrorHandler(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return
+	default:
+		logger(Error).Println(ErrorMethodNotImplemented)
+		http.Error(w, http.StatusText(http.StatusNotImplemented), http.StatusNotImplemented)
+		return
+	}
+	if err != nil {
+		genErrorHandler(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(res); err != nil {
+		logger(Error).Println(err)
+	}
+}
+
+// getRoles lets an administrator list every role with its permissions.
+func (a *app) getRoles(r *http.Request) ([]*rbac.Role, error) {
+	if _, err := a.defaultAdministrator(r); err != nil {
+		return nil, err
+	}
+	roles := a.rb.List(user.AuthDB())
+	if err := a.rb.Err(); err != nil {
+		return nil, err
+	}
+	if roles == nil {
+		roles = []*rbac.Role{}
+	}
+	return roles, nil
+}
+
+// putRole lets an administrator create a role or replace its description and permissions.
+// Tokens issued before the change keep the permissions they were issued with until they expire.
+func (a *app) putRole(r *http.Request) (*rbac.Role, error) {
+	if _, err := a.defaultAdministrator(r); err != nil {
+		return nil, err
+	}
+
+	role := new(rbac.Role)
+	if err := json.NewDecoder(r.Body).Decode(role); err != nil {
+		return nil, err
+	}
+	if role.Permissions == nil {
+		role.Permissions = []string{}
+	}
+	a.rb.Save(role, user.AuthDB())
+	if err := a.rb.Err(); err != nil {
+		return nil, err
+	}
+	return role, nil
+}
+
+// deleteRole lets an administrator delete the role in the "Role" query parameter, taking it away from every user.
+// Every token of a user who held the role, directly or through a group, is revoked.
+func (a *app) deleteRole(r *http.Request) error {
+	if _, err := a.defaultAdministrator(r); err != nil {
+		return err
+	}
+	role := r.URL.Query().Get("Role")
+	holders, err := a.roleHolders(role)
+	if err != nil {
+		return err
+	}
+	a.rb.Delete(role, user.AuthDB())
+	if err := a.rb.Err(); err != nil {
+		return err
+	}
+	a.c.RevokeTokensByID(holders, user.AuthDB())
+	return a.c.Err()
+}
+
+// roleHolders returns the IDs of the users who hold role, directly or through their groups.
+func (a *app) roleHolders(role string) ([]string, error) {
+	ids, groups := a.rb.Holders(role, user.AuthDB())
+	if err := a.rb.Err(); err != nil {
+		return nil, err
+	}
+	members := a.g.MemberIDs(groups, user.AuthDB())
+	if err := a.g.Err(); err != nil {
+		return nil, err
+	}
+	return append(ids, members...), nil
+}
+
+func (a *app) userRolesHandler(w http.ResponseWriter, r *http.Request) {
+	var (
+		res *userRolesResource
+		err error
+	)
+	switch r.Method {
+	case http.MethodGet:
+		res, err = a.getUserRoles(r)
+	case http.MethodPost, http.MethodDelete:
+		res, err = a.changeUserRoles(r)
+	default:
+		logger(Error).Println(ErrorMethodNotImplemented)
+		http.Error(w, http.StatusText(http.StatusNotImplemented), http.StatusNotImplemented)
+		return
+	}
+	if err != nil {
+		genErrorHandler(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(res); err != nil {
+		logger(Error).Println(err)
+	}
+}
+
+// getUserRoles returns the roles and permissions of the caller, or as an administrator of any user.
+func (a *app) getUserRoles(r *http.Request) (*userRolesResource, error) {
+	_, u, err := a.targetUser(r, ScopeRolesRead)
+	if err != nil {
+		return nil, err
+	}
+	return a.userRoles(u)
+}
+
+// grants returns the groups of u, including those through subgroups, and the roles and permissions u has
+// directly or through them.
+func (a *app) grants(u *user.User) (groups, roles, permissions []string, err error) {
+	groups = a.g.Memberships(u.ID(), user.AuthDB())
+	if err := a.g.Err(); err != nil {
+		return nil, nil, nil, err
+	}
+	roles, permissions = a.rb.Grants(u.ID(), groups, user.AuthDB())
+	if err := a.rb.Err(); err != nil {
+		return nil, nil, nil, err
+	}
+	return
+}
+
+// userRoles returns the roles and permissions of u, including those of its groups.
+func (a *app) userRoles(u *user.User) (*userRolesResource, error) {
+	_, roles, permissions, err := a.grants(u)
+	if err != nil {
+		return nil, err
+	}
+	res := &userRolesResource{UserID: u.UserID(), Roles: roles, Permissions: permissions}
+	if res.Roles == nil {
+		res.Roles = []string{}
+	}
+	if res.Permissions == nil {
+		res.Permissions = []string{}
+	}
+	return res, nil
+}
+
+// changeUserRoles lets an administrator assign (POST) or unassign (DELETE) the Role in the body to the user
+// with the UserID in the body. Every token of a user losing a role is revoked. The change is recorded in the audit log.
+func (a *app) changeUserRoles(r *http.Request) (*userRolesResource, error) {
+	caller, err := a.defaultAdministrator(r)
+	if err != nil {
+		return nil, err
+	}
+
+	type body struct {
+		UserID string
+		Role   string
+	}
+	b := new(body)
+	if err := json.NewDecoder(r.Body).Decode(b); err != nil {
+		return nil, err
+	}
+	u, err := a.fetchUser(qualify(r, b.UserID))
+	if err != nil {
+		return nil, err
+	}
+
+	action := "role_assign"
+	if r.Method == http.MethodPost {
+		a.rb.Assign(u.ID(), b.Role, user.AuthDB())
+	} else {
+		action = "role_unassign"
+		a.rb.Unassign(u.ID(), b.Role, user.AuthDB())
+	}
+	if err := a.rb.Err(); err != nil {
+		return nil, err
+	}
+	if r.Method == http.MethodDelete {
+		a.c.RevokeTokens(u.UserID(), user.AuthDB())
+		if err := a.c.Err(); err != nil {
+			return nil, err
+		}
+	}
+
+	a.au.Record(audit.NewEntry(caller.ID(), u.ID(), action, b.Role), user.AuthDB())
+	if err := a.au.Err(); err != nil {
+		logger(Error).Println(err)
+	}
+	return a.userRoles(u)
+}
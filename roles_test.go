@@ -0,0 +1,272 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	sq "github.com/Masterminds/squirrel"
+	"github.com/penutty/authservice/group"
+	"github.com/penutty/authservice/rbac"
+	"github.com/penutty/authservice/verification"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+var tRole = "editor"
+
+type MockRBACClient struct {
+	err    error
+	roles  map[string]*rbac.Role
+	users  map[string][]string
+	groups map[string][]string
+}
+
+func NewMockRBACClient() *MockRBACClient {
+	return &MockRBACClient{
+		roles:  map[string]*rbac.Role{tRole: &rbac.Role{Name: tRole, Permissions: []string{"moments:read", "moments:write"}}},
+		users:  make(map[string][]string),
+		groups: make(map[string][]string),
+	}
+}
+
+func (m *MockRBACClient) Save(r *rbac.Role, db sq.BaseRunner) {
+	if err := r.Check(); err != nil {
+		m.err = err
+		return
+	}
+	m.roles[r.Name] = r
+}
+
+func (m *MockRBACClient) Delete(role string, db sq.BaseRunner) {
+	if _, ok := m.roles[role]; !ok {
+		m.err = rbac.ErrorRoleNotFound
+		return
+	}
+	delete(m.roles, role)
+}
+
+func (m *MockRBACClient) List(db sq.BaseRunner) (roles []*rbac.Role) {
+	for _, r := range m.roles {
+		roles = append(roles, r)
+	}
+	return
+}
+
+func (m *MockRBACClient) Assign(id, role string, db sq.BaseRunner) {
+	if _, ok := m.roles[role]; !ok {
+		m.err = rbac.ErrorRoleNotFound
+		return
+	}
+	m.users[id] = append(m.users[id], role)
+}
+
+func (m *MockRBACClient) Unassign(id, role string, db sq.BaseRunner) {
+	for i, r := range m.users[id] {
+		if r == role {
+			m.users[id] = append(m.users[id][:i], m.users[id][i+1:]...)
+			return
+		}
+	}
+	m.err = rbac.ErrorRoleNotFound
+}
+
+func (m *MockRBACClient) AssignGroup(name, role string, db sq.BaseRunner) {
+	if _, ok := m.roles[role]; !ok {
+		m.err = rbac.ErrorRoleNotFound
+		return
+	}
+	if name != tGroup && name != tParentGroup {
+		m.err = group.ErrorGroupNotFound
+		return
+	}
+	m.groups[name] = append(m.groups[name], role)
+}
+
+func (m *MockRBACClient) UnassignGroup(name, role string, db sq.BaseRunner) {
+	for i, r := range m.groups[name] {
+		if r == role {
+			m.groups[name] = append(m.groups[name][:i], m.groups[name][i+1:]...)
+			return
+		}
+	}
+	m.err = rbac.ErrorRoleNotFound
+}
+
+func (m *MockRBACClient) Grants(id string, groups []string, db sq.BaseRunner) (roles []string, permissions []string) {
+	if m.users == nil {
+		return
+	}
+	granted := append([]string(nil), m.users[id]...)
+	for _, g := range groups {
+		granted = append(granted, m.groups[g]...)
+	}
+	seen := make(map[string]bool)
+	for _, r := range granted {
+		if seen[r] {
+			continue
+		}
+		seen[r] = true
+		roles = append(roles, r)
+		permissions = append(permissions, m.roles[r].Permissions...)
+	}
+	return
+}
+
+func (m *MockRBACClient) Holders(role string, db sq.BaseRunner) (ids []string, groups []string) {
+	for id, roles := range m.users {
+		for _, r := range roles {
+			if r == role {
+				ids = append(ids, id)
+			}
+		}
+	}
+	for name, roles := range m.groups {
+		for _, r := range roles {
+			if r == role {
+				groups = append(groups, name)
+			}
+		}
+	}
+	sort.Strings(ids)
+	sort.Strings(groups)
+	return
+}
+
+func (m *MockRBACClient) Err() error {
+	return m.err
+}
+
+func NewUserRoleBody(u, role string) *strings.Reader {
+	return strings.NewReader(fmt.Sprintf("{\"UserID\": \"%s\", \"Role\": \"%s\"}", u, role))
+}
+
+func Test_accessToken_roles(t *testing.T) {
+	a, m, _ := newVerifyApp()
+	rb := NewMockRBACClient()
+	rb.users[tID] = []string{tRole}
+	a.rb = rb
+
+	token, _, err := a.accessToken(m.Fetch(tUser, nil), NewAuthRequest())
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims, err := verification.ParseAudience(token, "Moment-Service")
+	assert.Nil(t, err)
+	assert.Equal(t, []string{tRole}, verification.Roles(claims))
+	assert.Nil(t, verification.Check(claims, "moments:read", "moments:write"))
+	assert.Equal(t, verification.ErrorPermissionDenied, verification.Check(claims, "moments:delete"))
+
+	token, _, err = a.accessToken(m.Fetch(tOtherUser, nil), NewAuthRequest())
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims, err = verification.ParseAudience(token, "Moment-Service")
+	assert.Nil(t, err)
+	assert.NotContains(t, claims, "roles")
+	assert.NotContains(t, claims, "permissions")
+}
+
+func Test_rolesHandler(t *testing.T) {
+	defer func(admins []string) { Administrators = admins }(Administrators)
+
+	type test struct {
+		req    *http.Request
+		admins []string
+		code   int
+		roles  int
+	}
+	cases := []*test{
+		&test{NewBearerRequest(http.MethodGet, RolesEndpoint, nil), nil, http.StatusForbidden, 1},
+		&test{NewBearerRequest(http.MethodGet, RolesEndpoint, nil), []string{tUser}, http.StatusOK, 1},
+		&test{NewBearerRequest(http.MethodPut, RolesEndpoint, strings.NewReader(`{"Name": "viewer", "Permissions": ["moments:read"]}`)), nil, http.StatusForbidden, 1},
+		&test{NewBearerRequest(http.MethodPut, RolesEndpoint, strings.NewReader(`{"Name": "viewer", "Permissions": ["moments:read"]}`)), []string{tUser}, http.StatusOK, 2},
+		&test{NewBearerRequest(http.MethodPut, RolesEndpoint, strings.NewReader(`{"Name": "Viewer"}`)), []string{tUser}, http.StatusBadRequest, 1},
+		&test{NewBearerRequest(http.MethodDelete, RolesEndpoint+"?Role="+tRole, nil), []string{tUser}, http.StatusNoContent, 0},
+		&test{NewBearerRequest(http.MethodDelete, RolesEndpoint+"?Role=viewer", nil), []string{tUser}, http.StatusNotFound, 1},
+		&test{httptest.NewRequest(http.MethodGet, RolesEndpoint, nil), []string{tUser}, http.StatusUnauthorized, 1},
+		&test{NewBearerRequest(http.MethodPost, RolesEndpoint, nil), []string{tUser}, http.StatusNotImplemented, 1},
+	}
+	for i, c := range cases {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			Administrators = administrators(c.admins...)
+			a, _, _ := newVerifyApp()
+			rb := NewMockRBACClient()
+			a.rb = rb
+			rec := httptest.NewRecorder()
+			a.rolesHandler(rec, c.req)
+			assert.Equal(t, c.code, rec.Code)
+			assert.Len(t, rb.roles, c.roles)
+		})
+	}
+}
+
+func Test_deleteRole_revokes(t *testing.T) {
+	defer func(admins []string) { Administrators = admins }(Administrators)
+	Administrators = administrators(tUser)
+
+	a, m, _ := newVerifyApp()
+	rb := NewMockRBACClient()
+	rb.users["id-"+tOtherUser] = []string{tRole}
+	rb.groups[tParentGroup] = []string{tRole}
+	a.rb = rb
+	a.g = NewMockGroupClient()
+
+	rec := httptest.NewRecorder()
+	a.rolesHandler(rec, NewBearerRequest(http.MethodDelete, RolesEndpoint+"?Role="+tRole, nil))
+	assert.Equal(t, http.StatusNoContent, rec.Code)
+	// tUser holds the role as a member of tGroup, a subgroup of tParentGroup.
+	assert.Equal(t, []string{"id-" + tOtherUser, tID}, m.revokedIDs)
+}
+
+func Test_userRolesHandler(t *testing.T) {
+	defer func(admins []string) { Administrators = admins }(Administrators)
+
+	type test struct {
+		req     *http.Request
+		admins  []string
+		code    int
+		roles   []string
+		revoked bool
+		audit   int
+	}
+	cases := []*test{
+		&test{NewBearerRequest(http.MethodGet, UserRolesEndpoint, nil), nil, http.StatusOK, []string{tRole}, false, 0},
+		&test{NewBearerRequest(http.MethodGet, UserRolesEndpoint+"?UserID="+tOtherUser, nil), nil, http.StatusForbidden, nil, false, 0},
+		&test{NewBearerRequest(http.MethodGet, UserRolesEndpoint+"?UserID="+tOtherUser, nil), []string{tUser}, http.StatusOK, []string{}, false, 0},
+		&test{NewBearerRequest(http.MethodPost, UserRolesEndpoint, NewUserRoleBody(tOtherUser, tRole)), nil, http.StatusForbidden, nil, false, 0},
+		&test{NewBearerRequest(http.MethodPost, UserRolesEndpoint, NewUserRoleBody(tOtherUser, tRole)), []string{tUser}, http.StatusOK, []string{tRole}, false, 1},
+		&test{NewBearerRequest(http.MethodPost, UserRolesEndpoint, NewUserRoleBody(tOtherUser, "viewer")), []string{tUser}, http.StatusNotFound, nil, false, 0},
+		&test{NewBearerRequest(http.MethodPost, UserRolesEndpoint, NewUserRoleBody(tUserMissing, tRole)), []string{tUser}, http.StatusNotFound, nil, false, 0},
+		&test{NewBearerRequest(http.MethodDelete, UserRolesEndpoint, NewUserRoleBody(tUser, tRole)), []string{tUser}, http.StatusOK, []string{}, true, 1},
+		&test{NewBearerRequest(http.MethodDelete, UserRolesEndpoint, NewUserRoleBody(tOtherUser, tRole)), []string{tUser}, http.StatusNotFound, nil, false, 0},
+		&test{httptest.NewRequest(http.MethodGet, UserRolesEndpoint, nil), nil, http.StatusUnauthorized, nil, false, 0},
+		&test{NewBearerRequest(http.MethodPut, UserRolesEndpoint, nil), nil, http.StatusNotImplemented, nil, false, 0},
+	}
+	for i, c := range cases {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			Administrators = administrators(c.admins...)
+			a, m, _ := newVerifyApp()
+			rb := NewMockRBACClient()
+			rb.users[tID] = []string{tRole}
+			a.rb = rb
+			au := new(MockAuditClient)
+			a.au = au
+			rec := httptest.NewRecorder()
+			a.userRolesHandler(rec, c.req)
+			assert.Equal(t, c.code, rec.Code)
+			assert.Equal(t, c.revoked, !m.revoked.IsZero())
+			assert.Len(t, au.entries, c.audit)
+			if c.code != http.StatusOK {
+				return
+			}
+
+			res := new(userRolesResource)
+			assert.Nil(t, json.NewDecoder(rec.Body).Decode(res))
+			assert.Equal(t, c.roles, res.Roles)
+		})
+	}
+}
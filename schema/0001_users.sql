@@ -0,0 +1,10 @@
+IF NOT EXISTS (SELECT * FROM sys.schemas WHERE name = 'auth')
+	EXEC('CREATE SCHEMA [auth]');
+GO
+
+CREATE TABLE [auth].[Users] (
+	[UserID]   NVARCHAR(64)  NOT NULL PRIMARY KEY,
+	[Email]    NVARCHAR(128) NOT NULL,
+	[Password] NVARCHAR(64)  NOT NULL
+);
+GO
@@ -0,0 +1,17 @@
+-- Clients that may call TokenEndpoint. [Secret] is the hex encoded SHA-256 digest of the client secret.
+CREATE TABLE [auth].[Clients] (
+	[ClientID] NVARCHAR(64) NOT NULL PRIMARY KEY,
+	[Secret]   CHAR(64)     NOT NULL
+);
+GO
+
+-- Audiences a client may exchange subject tokens for, the space delimited scopes it may request
+-- for each and the lifetime in seconds of issued tokens (0 uses exchange.DefaultLifetime).
+CREATE TABLE [auth].[ExchangePolicies] (
+	[ClientID] NVARCHAR(64)   NOT NULL REFERENCES [auth].[Clients] ([ClientID]) ON DELETE CASCADE,
+	[Audience] NVARCHAR(128)  NOT NULL,
+	[Scopes]   NVARCHAR(1024) NOT NULL DEFAULT '',
+	[Lifetime] INT            NOT NULL DEFAULT 0,
+	PRIMARY KEY ([ClientID], [Audience])
+);
+GO
@@ -0,0 +1,9 @@
+-- TOTP enrollments. [Secret] is the shared secret sealed with AES-256-GCM under TOTPSecretKey.
+-- [LastStep] is the most recent time step a code was accepted for, preventing code replay.
+CREATE TABLE [auth].[TOTP] (
+	[UserID]    NVARCHAR(64)   NOT NULL PRIMARY KEY REFERENCES [auth].[Users] ([UserID]) ON DELETE CASCADE,
+	[Secret]    VARBINARY(128) NOT NULL,
+	[Confirmed] BIT            NOT NULL DEFAULT 0,
+	[LastStep]  BIGINT         NOT NULL DEFAULT 0
+);
+GO
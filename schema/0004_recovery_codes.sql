@@ -0,0 +1,7 @@
+-- Single-use MFA recovery codes. [Code] is the hex encoded SHA-256 digest of the normalized code.
+CREATE TABLE [auth].[RecoveryCodes] (
+	[UserID] NVARCHAR(64) NOT NULL REFERENCES [auth].[Users] ([UserID]) ON DELETE CASCADE,
+	[Code]   CHAR(64)     NOT NULL,
+	PRIMARY KEY ([UserID], [Code])
+);
+GO
@@ -0,0 +1,25 @@
+-- WebAuthn credentials. A user may register any number of credentials.
+-- [PublicKey] is the COSE_Key of the credential and [SignCount] the last signature counter reported by its authenticator.
+CREATE TABLE [auth].[Credentials] (
+	[CredentialID] VARBINARY(1023) NOT NULL PRIMARY KEY,
+	[UserID]       NVARCHAR(64)    NOT NULL REFERENCES [auth].[Users] ([UserID]) ON DELETE CASCADE,
+	[PublicKey]    VARBINARY(1024) NOT NULL,
+	[SignCount]    BIGINT          NOT NULL DEFAULT 0,
+	[AAGUID]       BINARY(16)      NOT NULL,
+	[Format]       NVARCHAR(32)    NOT NULL,
+	[Created]      DATETIME2       NOT NULL,
+	[LastUsed]     DATETIME2       NULL
+);
+GO
+
+CREATE INDEX [IX_Credentials_UserID] ON [auth].[Credentials] ([UserID]);
+GO
+
+-- Outstanding registration and assertion challenges. [UserID] is empty for discoverable credential logins.
+CREATE TABLE [auth].[WebAuthnChallenges] (
+	[Challenge] VARBINARY(64) NOT NULL PRIMARY KEY,
+	[UserID]    NVARCHAR(64)  NOT NULL DEFAULT '',
+	[Ceremony]  NVARCHAR(16)  NOT NULL,
+	[Expires]   DATETIME2     NOT NULL
+);
+GO
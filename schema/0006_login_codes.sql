@@ -0,0 +1,9 @@
+-- Outstanding email login secrets, one per user. [Code] is the hex encoded SHA-256 digest of the
+-- numeric login code or login link secret; [Attempts] counts invalid redemption attempts.
+CREATE TABLE [auth].[LoginCodes] (
+	[UserID]   NVARCHAR(64) NOT NULL PRIMARY KEY REFERENCES [auth].[Users] ([UserID]) ON DELETE CASCADE,
+	[Code]     CHAR(64)     NOT NULL,
+	[Expires]  DATETIME2    NOT NULL,
+	[Attempts] INT          NOT NULL DEFAULT 0
+);
+GO
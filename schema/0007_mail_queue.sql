@@ -0,0 +1,18 @@
+-- Outbound email awaiting asynchronous delivery. [Sent] is set once delivered; failed deliveries
+-- increment [Attempts] and are retried at [NextAttempt] with exponential backoff.
+CREATE TABLE [auth].[MailQueue] (
+	[ID]          BIGINT IDENTITY(1,1) NOT NULL PRIMARY KEY,
+	[Recipient]   NVARCHAR(320)  NOT NULL,
+	[Subject]     NVARCHAR(998)  NOT NULL,
+	[Text]        NVARCHAR(MAX)  NOT NULL,
+	[HTML]        NVARCHAR(MAX)  NOT NULL DEFAULT '',
+	[Attempts]    INT            NOT NULL DEFAULT 0,
+	[NextAttempt] DATETIME2      NOT NULL,
+	[LastError]   NVARCHAR(MAX)  NULL,
+	[Created]     DATETIME2      NOT NULL,
+	[Sent]        DATETIME2      NULL
+);
+GO
+
+CREATE INDEX [IX_MailQueue_Due] ON [auth].[MailQueue] ([Sent], [NextAttempt]);
+GO
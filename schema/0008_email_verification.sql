@@ -0,0 +1,5 @@
+-- Email address verification state of users. [VerificationSent] throttles verification emails.
+ALTER TABLE [auth].[Users] ADD
+	[Verified]         BIT       NOT NULL DEFAULT 0,
+	[VerificationSent] DATETIME2 NULL;
+GO
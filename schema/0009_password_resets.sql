@@ -0,0 +1,11 @@
+-- Outstanding password reset tokens, one per user. [Token] is the hex encoded SHA-256 digest of the token.
+CREATE TABLE [auth].[PasswordResets] (
+	[UserID]  NVARCHAR(64) NOT NULL PRIMARY KEY REFERENCES [auth].[Users] ([UserID]) ON DELETE CASCADE,
+	[Token]   CHAR(64)     NOT NULL UNIQUE,
+	[Expires] DATETIME2    NOT NULL
+);
+GO
+
+-- Access tokens issued before [TokensRevoked] are rejected.
+ALTER TABLE [auth].[Users] ADD [TokensRevoked] DATETIME2 NULL;
+GO
@@ -0,0 +1,5 @@
+-- Profile fields of users.
+ALTER TABLE [auth].[Users] ADD
+	[DisplayName] NVARCHAR(128) NOT NULL DEFAULT '',
+	[Locale]      NVARCHAR(35)  NOT NULL DEFAULT '';
+GO
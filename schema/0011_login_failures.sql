@@ -0,0 +1,9 @@
+-- Failed login attempts per key, e.g. "user:<UserID>" or "ip:<address>".
+-- A key is locked while [LockedUntil] is in the future.
+CREATE TABLE [auth].[LoginFailures] (
+	[Key]         NVARCHAR(128) NOT NULL PRIMARY KEY,
+	[Failures]    INT           NOT NULL,
+	[LastFailure] DATETIME2     NOT NULL,
+	[LockedUntil] DATETIME2     NULL
+);
+GO
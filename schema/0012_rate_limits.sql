@@ -0,0 +1,12 @@
+-- Token buckets of the rate limiter shared between instances. A bucket is full again at [Expires]
+-- and may then be deleted.
+CREATE TABLE [auth].[RateLimits] (
+	[Key]     NVARCHAR(256) NOT NULL PRIMARY KEY,
+	[Tokens]  FLOAT         NOT NULL,
+	[Updated] DATETIME2     NOT NULL,
+	[Expires] DATETIME2     NOT NULL
+);
+GO
+
+CREATE INDEX [IX_RateLimits_Expires] ON [auth].[RateLimits] ([Expires]);
+GO
@@ -0,0 +1,18 @@
+-- Previous passwords of users. [Hash] is the hex encoded SHA-256 digest of [Salt] followed by the password.
+CREATE TABLE [auth].[PasswordHistory] (
+	[ID]      INT IDENTITY (1, 1) NOT NULL PRIMARY KEY,
+	[UserID]  NVARCHAR(64) NOT NULL REFERENCES [auth].[Users] ([UserID]) ON DELETE CASCADE,
+	[Salt]    CHAR(32)     NOT NULL,
+	[Hash]    CHAR(64)     NOT NULL,
+	[Created] DATETIME2    NOT NULL
+);
+GO
+
+CREATE INDEX [IX_PasswordHistory_UserID] ON [auth].[PasswordHistory] ([UserID], [Created]);
+GO
+
+-- Passwords older than the configured maximum age must be changed at the next login.
+-- Existing passwords count from the migration.
+ALTER TABLE [auth].[Users] ADD [PasswordChanged] DATETIME2 NOT NULL
+	CONSTRAINT [DF_Users_PasswordChanged] DEFAULT SYSUTCDATETIME();
+GO
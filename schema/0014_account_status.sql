@@ -0,0 +1,23 @@
+-- Account status. Only active users and users pending verification may log in; a suspension ends at
+-- [StatusUntil] if it is set. Existing users are active.
+ALTER TABLE [auth].[Users] ADD
+	[Status]        NVARCHAR(32)  NOT NULL CONSTRAINT [DF_Users_Status] DEFAULT 'active',
+	[StatusReason]  NVARCHAR(256) NOT NULL CONSTRAINT [DF_Users_StatusReason] DEFAULT '',
+	[StatusChanged] DATETIME2     NOT NULL CONSTRAINT [DF_Users_StatusChanged] DEFAULT SYSUTCDATETIME(),
+	[StatusUntil]   DATETIME2     NULL,
+	CONSTRAINT [CK_Users_Status] CHECK ([Status] IN ('active', 'pending_verification', 'suspended', 'disabled', 'pending_deletion'));
+GO
+
+-- Who did what to which user. [Subject] does not reference [auth].[Users] so that entries outlive the user.
+CREATE TABLE [auth].[AuditLog] (
+	[ID]      INT IDENTITY (1, 1) NOT NULL PRIMARY KEY,
+	[Actor]   NVARCHAR(64)  NOT NULL,
+	[Subject] NVARCHAR(64)  NOT NULL,
+	[Action]  NVARCHAR(64)  NOT NULL,
+	[Detail]  NVARCHAR(512) NOT NULL,
+	[Created] DATETIME2     NOT NULL
+);
+GO
+
+CREATE INDEX [IX_AuditLog_Subject] ON [auth].[AuditLog] ([Subject], [Created]);
+GO
@@ -0,0 +1,23 @@
+-- Case-folded lookup keys of UserIDs and email addresses. [EmailKey] is the address without a display
+-- name or angle brackets. Existing users whose keys collide must be merged or renamed before the
+-- unique constraints can be added.
+ALTER TABLE [auth].[Users] ADD
+	[UserIDKey] NVARCHAR(64)  NULL,
+	[EmailKey]  NVARCHAR(128) NULL;
+GO
+
+UPDATE [auth].[Users] SET
+	[UserIDKey] = LOWER([UserID]),
+	[EmailKey]  = LOWER(CASE WHEN CHARINDEX('<', [Email]) > 0
+		THEN SUBSTRING([Email], CHARINDEX('<', [Email]) + 1, CHARINDEX('>', [Email]) - CHARINDEX('<', [Email]) - 1)
+		ELSE [Email] END);
+GO
+
+ALTER TABLE [auth].[Users] ALTER COLUMN [UserIDKey] NVARCHAR(64) NOT NULL;
+ALTER TABLE [auth].[Users] ALTER COLUMN [EmailKey] NVARCHAR(128) NOT NULL;
+GO
+
+ALTER TABLE [auth].[Users] ADD
+	CONSTRAINT [UQ_Users_UserIDKey] UNIQUE ([UserIDKey]),
+	CONSTRAINT [UQ_Users_EmailKey] UNIQUE ([EmailKey]);
+GO
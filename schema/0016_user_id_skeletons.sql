@@ -0,0 +1,7 @@
+-- Skeletons of UserIDs: the form visually confusable UserIDs share, see user.Skeleton. Existing users
+-- have no skeleton until it is set by the backfill of 0026.
+ALTER TABLE [auth].[Users] ADD [UserIDSkeleton] NVARCHAR(256) NULL;
+GO
+
+CREATE UNIQUE INDEX [UQ_Users_UserIDSkeleton] ON [auth].[Users] ([UserIDSkeleton]) WHERE [UserIDSkeleton] IS NOT NULL;
+GO
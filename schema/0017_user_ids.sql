@@ -0,0 +1,30 @@
+-- Immutable identifiers of users, used as the "sub" claim of tokens. New users get a UUIDv7, see
+-- user.NewID; existing users are given a random UUID. [UserID] is a login handle that may change.
+ALTER TABLE [auth].[Users] ADD [ID] CHAR(36) NULL;
+GO
+
+UPDATE [auth].[Users] SET [ID] = LOWER(CONVERT(CHAR(36), NEWID()));
+GO
+
+ALTER TABLE [auth].[Users] ALTER COLUMN [ID] CHAR(36) NOT NULL;
+ALTER TABLE [auth].[Users] ADD CONSTRAINT [UQ_Users_ID] UNIQUE ([ID]);
+GO
+
+-- Renaming a user updates [UserID], so every foreign key referencing it is recreated to cascade updates.
+DECLARE @sql NVARCHAR(MAX) = '';
+SELECT @sql = @sql +
+	'ALTER TABLE [auth].' + QUOTENAME(OBJECT_NAME(fk.parent_object_id)) + ' DROP CONSTRAINT ' + QUOTENAME(fk.name) + '; ' +
+	'ALTER TABLE [auth].' + QUOTENAME(OBJECT_NAME(fk.parent_object_id)) + ' ADD CONSTRAINT ' + QUOTENAME(fk.name) +
+	' FOREIGN KEY ([UserID]) REFERENCES [auth].[Users] ([UserID]) ON DELETE CASCADE ON UPDATE CASCADE; '
+FROM sys.foreign_keys fk
+WHERE fk.referenced_object_id = OBJECT_ID('[auth].[Users]');
+EXEC sp_executesql @sql;
+GO
+
+-- Former UserIDs, by lookup key, reserved for the user that gave them up until [Expires].
+CREATE TABLE [auth].[RetiredUserIDs] (
+	[UserIDKey] NVARCHAR(64) NOT NULL PRIMARY KEY,
+	[ID]        CHAR(36)     NOT NULL REFERENCES [auth].[Users] ([ID]) ON DELETE CASCADE,
+	[Expires]   DATETIME2    NOT NULL
+);
+GO
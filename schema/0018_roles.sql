@@ -0,0 +1,21 @@
+-- Roles, the permissions they grant and the roles of users, by user ID. The roles and permissions of a
+-- user are copied into its access tokens, see rbac.Grants.
+CREATE TABLE [auth].[Roles] (
+	[Role]        NVARCHAR(64)  NOT NULL PRIMARY KEY,
+	[Description] NVARCHAR(256) NOT NULL DEFAULT ''
+);
+GO
+
+CREATE TABLE [auth].[RolePermissions] (
+	[Role]       NVARCHAR(64)  NOT NULL REFERENCES [auth].[Roles] ([Role]) ON DELETE CASCADE,
+	[Permission] NVARCHAR(128) NOT NULL,
+	CONSTRAINT [PK_RolePermissions] PRIMARY KEY ([Role], [Permission])
+);
+GO
+
+CREATE TABLE [auth].[UserRoles] (
+	[ID]   CHAR(36)     NOT NULL REFERENCES [auth].[Users] ([ID]) ON DELETE CASCADE,
+	[Role] NVARCHAR(64) NOT NULL CONSTRAINT [FK_UserRoles_Roles] REFERENCES [auth].[Roles] ([Role]) ON DELETE CASCADE,
+	CONSTRAINT [PK_UserRoles] PRIMARY KEY ([ID], [Role])
+);
+GO
@@ -0,0 +1,37 @@
+-- Groups of users. A group may be a member of other groups through [auth].[GroupSubgroups]: the members
+-- of [Subgroup] are members of [Group] too. Cycles are refused when subgroups are added, see group.AddSubgroup.
+CREATE TABLE [auth].[Groups] (
+	[Group]       NVARCHAR(64)  NOT NULL PRIMARY KEY,
+	[Description] NVARCHAR(256) NOT NULL DEFAULT ''
+);
+GO
+
+CREATE TABLE [auth].[GroupMembers] (
+	[Group] NVARCHAR(64) NOT NULL CONSTRAINT [FK_GroupMembers_Groups] REFERENCES [auth].[Groups] ([Group]) ON DELETE CASCADE,
+	[ID]    CHAR(36)     NOT NULL REFERENCES [auth].[Users] ([ID]) ON DELETE CASCADE,
+	CONSTRAINT [PK_GroupMembers] PRIMARY KEY ([Group], [ID])
+);
+GO
+
+CREATE INDEX [IX_GroupMembers_ID] ON [auth].[GroupMembers] ([ID]);
+GO
+
+-- Both columns reference [auth].[Groups], so deleting a group cannot cascade along both paths; the
+-- rows of a deleted group are removed by group.Delete.
+CREATE TABLE [auth].[GroupSubgroups] (
+	[Group]    NVARCHAR(64) NOT NULL CONSTRAINT [FK_GroupSubgroups_Groups] REFERENCES [auth].[Groups] ([Group]),
+	[Subgroup] NVARCHAR(64) NOT NULL CONSTRAINT [FK_GroupSubgroups_Subgroups] REFERENCES [auth].[Groups] ([Group]),
+	CONSTRAINT [PK_GroupSubgroups] PRIMARY KEY ([Group], [Subgroup])
+);
+GO
+
+CREATE INDEX [IX_GroupSubgroups_Subgroup] ON [auth].[GroupSubgroups] ([Subgroup]);
+GO
+
+-- Roles granted to every member of a group.
+CREATE TABLE [auth].[GroupRoles] (
+	[Group] NVARCHAR(64) NOT NULL CONSTRAINT [FK_GroupRoles_Groups] REFERENCES [auth].[Groups] ([Group]) ON DELETE CASCADE,
+	[Role]  NVARCHAR(64) NOT NULL CONSTRAINT [FK_GroupRoles_Roles] REFERENCES [auth].[Roles] ([Role]) ON DELETE CASCADE,
+	CONSTRAINT [PK_GroupRoles] PRIMARY KEY ([Group], [Role])
+);
+GO
@@ -0,0 +1,32 @@
+-- Tenants are isolated sets of users and clients. The UserIDs of tenants other than 'default' are prefixed
+-- with the tenant ID and '/', see user.Qualify, so [UserIDKey] and [UserIDSkeleton] stay unique per tenant.
+-- [PasswordMaxAge] is in seconds; zero values defer to the global password policy.
+CREATE TABLE [auth].[Tenants] (
+	[TenantID]          NVARCHAR(32)  NOT NULL PRIMARY KEY,
+	[Name]              NVARCHAR(256) NOT NULL DEFAULT '',
+	[Host]              NVARCHAR(256) NULL,
+	[PasswordMinLength] INT           NOT NULL DEFAULT 0,
+	[PasswordMaxAge]    BIGINT        NOT NULL DEFAULT 0
+);
+GO
+
+CREATE UNIQUE INDEX [UQ_Tenants_Host] ON [auth].[Tenants] ([Host]) WHERE [Host] IS NOT NULL;
+GO
+
+INSERT INTO [auth].[Tenants] ([TenantID], [Name]) VALUES ('default', 'Default');
+GO
+
+ALTER TABLE [auth].[Users] ADD [TenantID] NVARCHAR(32) NOT NULL
+	CONSTRAINT [DF_Users_TenantID] DEFAULT 'default'
+	CONSTRAINT [FK_Users_Tenants] REFERENCES [auth].[Tenants] ([TenantID]);
+GO
+
+-- Email addresses are unique within a tenant only.
+ALTER TABLE [auth].[Users] DROP CONSTRAINT [UQ_Users_EmailKey];
+ALTER TABLE [auth].[Users] ADD CONSTRAINT [UQ_Users_EmailKey] UNIQUE ([TenantID], [EmailKey]);
+GO
+
+ALTER TABLE [auth].[Clients] ADD [TenantID] NVARCHAR(32) NOT NULL
+	CONSTRAINT [DF_Clients_TenantID] DEFAULT 'default'
+	CONSTRAINT [FK_Clients_Tenants] REFERENCES [auth].[Tenants] ([TenantID]);
+GO
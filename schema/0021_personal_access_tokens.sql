@@ -0,0 +1,14 @@
+-- Personal access tokens of users, see package pat. Only the SHA-256 hash of a token is stored; [Scopes] is
+-- space delimited. Tokens are deleted with their user.
+CREATE TABLE [auth].[PersonalAccessTokens] (
+	[TokenID]  CHAR(36)       NOT NULL PRIMARY KEY,
+	[ID]       CHAR(36)       NOT NULL REFERENCES [auth].[Users] ([ID]) ON DELETE CASCADE,
+	[Name]     NVARCHAR(64)   NOT NULL,
+	[Hash]     CHAR(64)       NOT NULL CONSTRAINT [UQ_PersonalAccessTokens_Hash] UNIQUE,
+	[Scopes]   NVARCHAR(2100) NOT NULL DEFAULT '',
+	[Created]  DATETIME2      NOT NULL,
+	[Expires]  DATETIME2      NULL,
+	[LastUsed] DATETIME2      NULL,
+	CONSTRAINT [UQ_PersonalAccessTokens_Name] UNIQUE ([ID], [Name])
+);
+GO
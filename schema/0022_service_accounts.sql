@@ -0,0 +1,35 @@
+-- Service accounts of non-human callers, see package serviceaccount. [Team] is the group whose members manage
+-- the account; a group owning service accounts cannot be deleted. [Scopes] is space delimited.
+CREATE TABLE [auth].[ServiceAccounts] (
+	[ID]          CHAR(36)       NOT NULL PRIMARY KEY,
+	[TenantID]    NVARCHAR(32)   NOT NULL CONSTRAINT [FK_ServiceAccounts_Tenants] REFERENCES [auth].[Tenants] ([TenantID]),
+	[Name]        NVARCHAR(64)   NOT NULL,
+	[Team]        NVARCHAR(64)   NOT NULL CONSTRAINT [FK_ServiceAccounts_Groups] REFERENCES [auth].[Groups] ([Group]),
+	[Description] NVARCHAR(256)  NOT NULL DEFAULT '',
+	[Scopes]      NVARCHAR(2100) NOT NULL DEFAULT '',
+	[Disabled]    BIT            NOT NULL DEFAULT 0,
+	[Created]     DATETIME2      NOT NULL,
+	CONSTRAINT [UQ_ServiceAccounts_Name] UNIQUE ([TenantID], [Name])
+);
+GO
+
+CREATE INDEX [IX_ServiceAccounts_Team] ON [auth].[ServiceAccounts] ([Team]);
+GO
+
+-- [Hash] is the SHA-256 hash of client secrets and API keys; [PublicKey] the PEM encoded public key verifying
+-- the JWT assertions of credentials of type 'key'.
+CREATE TABLE [auth].[ServiceAccountCredentials] (
+	[CredentialID] CHAR(36)      NOT NULL PRIMARY KEY,
+	[ID]           CHAR(36)      NOT NULL CONSTRAINT [FK_ServiceAccountCredentials_ServiceAccounts] REFERENCES [auth].[ServiceAccounts] ([ID]) ON DELETE CASCADE,
+	[Type]         NVARCHAR(16)  NOT NULL CONSTRAINT [CK_ServiceAccountCredentials_Type] CHECK ([Type] IN ('secret', 'key', 'api_key')),
+	[Hash]         CHAR(64)      NULL,
+	[PublicKey]    NVARCHAR(MAX) NULL,
+	[Created]      DATETIME2     NOT NULL,
+	[Expires]      DATETIME2     NULL,
+	[LastUsed]     DATETIME2     NULL
+);
+GO
+
+CREATE UNIQUE INDEX [UQ_ServiceAccountCredentials_Hash] ON [auth].[ServiceAccountCredentials] ([Hash]) WHERE [Hash] IS NOT NULL;
+CREATE INDEX [IX_ServiceAccountCredentials_ID] ON [auth].[ServiceAccountCredentials] ([ID]);
+GO
@@ -0,0 +1,14 @@
+-- HMAC signing keys of service accounts, see package signing. Auth-Service needs the secret to verify
+-- signatures, so [Secret] is sealed with AES-256-GCM under SigningSecretKey rather than hashed.
+CREATE TABLE [auth].[SigningKeys] (
+	[KeyID]    CHAR(36)       NOT NULL PRIMARY KEY,
+	[ID]       CHAR(36)       NOT NULL CONSTRAINT [FK_SigningKeys_ServiceAccounts] REFERENCES [auth].[ServiceAccounts] ([ID]) ON DELETE CASCADE,
+	[Secret]   VARBINARY(128) NOT NULL,
+	[Created]  DATETIME2      NOT NULL,
+	[Expires]  DATETIME2      NULL,
+	[LastUsed] DATETIME2      NULL
+);
+GO
+
+CREATE INDEX [IX_SigningKeys_ID] ON [auth].[SigningKeys] ([ID]);
+GO
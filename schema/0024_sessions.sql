@@ -0,0 +1,16 @@
+-- Sessions of users, see package session. A row is inserted by every login and deleted when the session is
+-- revoked, which invalidates the tokens carrying its [SessionID] in their "sid" claim. Sessions are deleted
+-- with their user.
+CREATE TABLE [auth].[Sessions] (
+	[SessionID]  CHAR(36)      NOT NULL PRIMARY KEY,
+	[ID]         CHAR(36)      NOT NULL REFERENCES [auth].[Users] ([ID]) ON DELETE CASCADE,
+	[DeviceName] NVARCHAR(64)  NOT NULL DEFAULT '',
+	[UserAgent]  NVARCHAR(256) NOT NULL DEFAULT '',
+	[IP]         NVARCHAR(45)  NOT NULL,
+	[Created]    DATETIME2     NOT NULL,
+	[LastSeen]   DATETIME2     NOT NULL
+);
+GO
+
+CREATE INDEX [IX_Sessions_ID] ON [auth].[Sessions] ([ID], [Created]);
+GO
@@ -0,0 +1,10 @@
+-- Previous passwords are kept as bcrypt hashes, see package history, which carry their own salt. The salted
+-- SHA-256 digests kept so far are fast to brute force and are dropped along with their salts.
+DELETE FROM [auth].[PasswordHistory];
+GO
+
+ALTER TABLE [auth].[PasswordHistory] DROP COLUMN [Salt];
+GO
+
+ALTER TABLE [auth].[PasswordHistory] ALTER COLUMN [Hash] CHAR(60) NOT NULL;
+GO
@@ -0,0 +1,7 @@
+-- Backfills the skeletons of users created before 0016, see user.Skeleton. Their UserIDs are ASCII letters and
+-- digits, whose skeleton is the lower case UserID with 0 read as o, 1 as l, rn as m and vv as w. Existing
+-- users whose skeletons collide must be renamed before the backfill can be run.
+UPDATE [auth].[Users] SET
+	[UserIDSkeleton] = REPLACE(REPLACE(REPLACE(REPLACE(LOWER([UserID]), '0', 'o'), '1', 'l'), 'rn', 'm'), 'vv', 'w')
+WHERE [UserIDSkeleton] IS NULL;
+GO
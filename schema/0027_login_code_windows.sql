@@ -0,0 +1,4 @@
+-- Start of the window in which invalid attempts carry over to reissued login secrets, see
+-- passwordless.AttemptWindow. Outstanding secrets start their window when the column is added.
+ALTER TABLE [auth].[LoginCodes] ADD [WindowStart] DATETIME2 NOT NULL DEFAULT SYSUTCDATETIME();
+GO
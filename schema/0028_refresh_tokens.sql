@@ -0,0 +1,13 @@
+-- Refresh tokens of sessions, see package session. Only the SHA-256 digest of a token is stored. A session's
+-- tokens form its family: each is used once and replaced by the next, and used tokens are kept until their
+-- session is deleted so that presenting one again can revoke the session. They are deleted with their session.
+CREATE TABLE [auth].[RefreshTokens] (
+	[Hash]      CHAR(64)  NOT NULL PRIMARY KEY,
+	[SessionID] CHAR(36)  NOT NULL REFERENCES [auth].[Sessions] ([SessionID]) ON DELETE CASCADE,
+	[Expires]   DATETIME2 NOT NULL,
+	[Used]      BIT       NOT NULL DEFAULT 0
+);
+GO
+
+CREATE INDEX [IX_RefreshTokens_SessionID] ON [auth].[RefreshTokens] ([SessionID]);
+GO
@@ -0,0 +1,93 @@
+package serviceaccount
+
+import (
+	"errors"
+	"github.com/dgrijalva/jwt-go"
+	"time"
+)
+
+var (
+	// AssertionMaxLifetime bounds how far in the future assertions may expire, as they can be replayed until then.
+	AssertionMaxLifetime = 5 * time.Minute
+
+	ErrorPublicKeyInvalid = errors.New("PublicKey must be a PEM encoded RSA or ECDSA public key.")
+	ErrorAssertionInvalid = errors.New("Assertion is invalid.")
+)
+
+// ParsePublicKey parses a PEM encoded RSA or ECDSA public key.
+func ParsePublicKey(pem string) (interface{}, error) {
+	if key, err := jwt.ParseRSAPublicKeyFromPEM([]byte(pem)); err == nil {
+		return key, nil
+	}
+	if key, err := jwt.ParseECPublicKeyFromPEM([]byte(pem)); err == nil {
+		return key, nil
+	}
+	return nil, ErrorPublicKeyInvalid
+}
+
+// AssertionSubject returns the subject of an RFC 7523 JWT bearer assertion, the ID of the service account it
+// claims to be signed by, without verifying it.
+func AssertionSubject(assertion string) (string, error) {
+	claims := jwt.MapClaims{}
+	if _, _, err := new(jwt.Parser).ParseUnverified(assertion, claims); err != nil {
+		return "", ErrorAssertionInvalid
+	}
+	sub, _ := claims["sub"].(string)
+	if sub == "" {
+		return "", ErrorAssertionInvalid
+	}
+	return sub, nil
+}
+
+// VerifyAssertion returns ErrorAssertionInvalid unless assertion is a JWT bearer assertion of the service account
+// with ID id: signed with RS256 or ES256 by one of keys, the one named by its "kid" header if it has one, issued
+// and subject id, for one of audiences and expiring within AssertionMaxLifetime.
+func VerifyAssertion(assertion, id string, keys []*Credential, audiences ...string) error {
+	for _, c := range keys {
+		claims := jwt.MapClaims{}
+		token, err := jwt.ParseWithClaims(assertion, claims, func(t *jwt.Token) (interface{}, error) {
+			if kid, ok := t.Header["kid"].(string); ok && kid != c.CredentialID {
+				return nil, ErrorAssertionInvalid
+			}
+			switch t.Method {
+			case jwt.SigningMethodRS256, jwt.SigningMethodES256:
+			default:
+				return nil, ErrorAssertionInvalid
+			}
+			return ParsePublicKey(c.PublicKey)
+		})
+		if err != nil || !token.Valid {
+			continue
+		}
+
+		if claims["iss"] != id || claims["sub"] != id {
+			return ErrorAssertionInvalid
+		}
+		exp, ok := claims["exp"].(float64)
+		if !ok || time.Unix(int64(exp), 0).After(time.Now().Add(AssertionMaxLifetime)) {
+			return ErrorAssertionInvalid
+		}
+		for _, aud := range audiences {
+			if aud != "" && hasAudience(claims, aud) {
+				return nil
+			}
+		}
+		return ErrorAssertionInvalid
+	}
+	return ErrorAssertionInvalid
+}
+
+// hasAudience reports whether aud is the "aud" claim of claims or one of its values.
+func hasAudience(claims jwt.MapClaims, aud string) bool {
+	switch v := claims["aud"].(type) {
+	case string:
+		return v == aud
+	case []interface{}:
+		for _, a := range v {
+			if a == aud {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,108 @@
+package serviceaccount
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"github.com/dgrijalva/jwt-go"
+	"github.com/stretchr/testify/assert"
+	"strconv"
+	"testing"
+	"time"
+)
+
+const tAudience = "https://auth.example.com/token"
+
+func newRSAKey(t *testing.T) *rsa.PrivateKey {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func publicKeyPEM(t *testing.T, key interface{}) string {
+	var pub interface{}
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		pub = &k.PublicKey
+	case *ecdsa.PrivateKey:
+		pub = &k.PublicKey
+	}
+	der, err := x509.MarshalPKIXPublicKey(pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}))
+}
+
+func assertion(t *testing.T, method jwt.SigningMethod, key interface{}, kid string, claims jwt.MapClaims) string {
+	token := jwt.NewWithClaims(method, claims)
+	if kid != "" {
+		token.Header["kid"] = kid
+	}
+	s, err := token.SignedString(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func Test_AssertionSubject(t *testing.T) {
+	key := newRSAKey(t)
+	cases := []struct {
+		assertion string
+		sub       string
+		err       error
+	}{
+		{assertion(t, jwt.SigningMethodRS256, key, "", jwt.MapClaims{"sub": tID}), tID, nil},
+		{assertion(t, jwt.SigningMethodRS256, key, "", jwt.MapClaims{"iss": tID}), "", ErrorAssertionInvalid},
+		{"not.a.jwt", "", ErrorAssertionInvalid},
+	}
+	for i, c := range cases {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			sub, err := AssertionSubject(c.assertion)
+			assert.Equal(t, c.err, err)
+			assert.Equal(t, c.sub, sub)
+		})
+	}
+}
+
+func Test_VerifyAssertion(t *testing.T) {
+	rsaKey, other := newRSAKey(t), newRSAKey(t)
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys := []*Credential{
+		&Credential{CredentialID: tCredentialID, Type: TypeKey, PublicKey: publicKeyPEM(t, rsaKey)},
+		&Credential{CredentialID: "ec", Type: TypeKey, PublicKey: publicKeyPEM(t, ecKey)},
+	}
+	claims := func(iss string, aud interface{}, lifetime time.Duration) jwt.MapClaims {
+		return jwt.MapClaims{"iss": iss, "sub": tID, "aud": aud, "exp": time.Now().Add(lifetime).Unix()}
+	}
+
+	cases := []struct {
+		assertion string
+		err       error
+	}{
+		{assertion(t, jwt.SigningMethodRS256, rsaKey, "", claims(tID, tAudience, time.Minute)), nil},
+		{assertion(t, jwt.SigningMethodRS256, rsaKey, tCredentialID, claims(tID, []string{"other", tAudience}, time.Minute)), nil},
+		{assertion(t, jwt.SigningMethodES256, ecKey, "ec", claims(tID, tAudience, time.Minute)), nil},
+		{assertion(t, jwt.SigningMethodRS256, rsaKey, "ec", claims(tID, tAudience, time.Minute)), ErrorAssertionInvalid},
+		{assertion(t, jwt.SigningMethodRS256, other, "", claims(tID, tAudience, time.Minute)), ErrorAssertionInvalid},
+		{assertion(t, jwt.SigningMethodRS512, rsaKey, "", claims(tID, tAudience, time.Minute)), ErrorAssertionInvalid},
+		{assertion(t, jwt.SigningMethodRS256, rsaKey, "", claims("other", tAudience, time.Minute)), ErrorAssertionInvalid},
+		{assertion(t, jwt.SigningMethodRS256, rsaKey, "", claims(tID, "other", time.Minute)), ErrorAssertionInvalid},
+		{assertion(t, jwt.SigningMethodRS256, rsaKey, "", claims(tID, tAudience, time.Hour)), ErrorAssertionInvalid},
+		{assertion(t, jwt.SigningMethodRS256, rsaKey, "", claims(tID, tAudience, -time.Minute)), ErrorAssertionInvalid},
+	}
+	for i, c := range cases {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			assert.Equal(t, c.err, VerifyAssertion(c.assertion, tID, keys, "", tAudience))
+		})
+	}
+}
@@ -0,0 +1,550 @@
+// Package serviceaccount is dedicated to reading and writing service accounts and their credentials in Auth-Db.
+// Service accounts are the identities of non-human callers. They have no password or email address, are owned
+// by a team, the group of users managing them, and authenticate with client secrets, signed JWT assertions
+// (RFC 7523) verified with their registered public keys, or API keys.
+//
+// Client secrets and API keys are generated like personal access tokens, see package pat, starting with
+// SecretPrefix and APIKeyPrefix. Auth-Db only holds their hashes.
+package serviceaccount
+
+import (
+	"database/sql"
+	"errors"
+	sq "github.com/Masterminds/squirrel"
+	"github.com/penutty/authservice/group"
+	"github.com/penutty/authservice/pat"
+	"github.com/penutty/authservice/user"
+	"log"
+	"regexp"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	SecretPrefix = "ass_"
+	APIKeyPrefix = "ask_"
+
+	// Credential types.
+	TypeSecret = "secret"
+	TypeKey    = "key"
+	TypeAPIKey = "api_key"
+)
+
+var (
+	DescriptionMaxLength = 256
+	MaxScopes            = 32
+
+	ErrorNameInvalid        = errors.New("Name must be 1 to 64 lowercase letters, digits, '_', '-' or '.'.")
+	ErrorDescriptionLong    = errors.New("Description too long.")
+	ErrorScopeInvalid       = errors.New("Scopes must be at most 32 names of lowercase letters, digits, ':', '.', '_' or '-'.")
+	ErrorAccountNotFound    = errors.New("Service account does not exist.")
+	ErrorAccountDisabled    = errors.New("Service account is disabled.")
+	ErrorNameInUse          = errors.New("Name is already used by another service account of the tenant.")
+	ErrorTeamNotFound       = errors.New("Team does not exist.")
+	ErrorTypeInvalid        = errors.New("Type must be \"secret\", \"key\" or \"api_key\".")
+	ErrorExpiresInvalid     = errors.New("Expires must be in the future.")
+	ErrorCredentialNotFound = errors.New("Credential does not exist.")
+	ErrorCredentialInvalid  = errors.New("Service account credential is invalid.")
+	ErrorScopeNotPermitted  = errors.New("Service account may not request the requested scope.")
+
+	nameRegexp  = regexp.MustCompile(`^[a-z0-9_.-]{1,64}$`)
+	scopeRegexp = regexp.MustCompile(`^[a-z0-9:._-]{1,64}$`)
+)
+
+// Account is a service account of the tenant TenantID. Scopes are the scopes its tokens may be issued with.
+type Account struct {
+	ID          string
+	TenantID    string
+	Name        string
+	Team        string
+	Description string
+	Scopes      []string
+	Disabled    bool
+	Created     time.Time
+}
+
+// Check returns an error if a is invalid.
+func (a *Account) Check() error {
+	if !nameRegexp.MatchString(a.Name) {
+		return ErrorNameInvalid
+	}
+	if err := group.CheckGroup(a.Team); err != nil {
+		return err
+	}
+	if utf8.RuneCountInString(a.Description) > DescriptionMaxLength {
+		return ErrorDescriptionLong
+	}
+	if len(a.Scopes) > MaxScopes {
+		return ErrorScopeInvalid
+	}
+	for _, s := range a.Scopes {
+		if !scopeRegexp.MatchString(s) {
+			return ErrorScopeInvalid
+		}
+	}
+	return nil
+}
+
+// Narrow returns the scopes of a token of a: requested, which must be scopes of a, or every scope of a if
+// none are requested.
+func (a *Account) Narrow(requested []string) ([]string, error) {
+	if len(requested) == 0 {
+		return a.Scopes, nil
+	}
+	for _, s := range requested {
+		permitted := false
+		for _, p := range a.Scopes {
+			if s == p {
+				permitted = true
+				break
+			}
+		}
+		if !permitted {
+			return nil, ErrorScopeNotPermitted
+		}
+	}
+	return requested, nil
+}
+
+// Credential is a credential of the service account with ID Account. PublicKey is the PEM encoded public key
+// of credentials of TypeKey. Expires and LastUsed are zero if the credential does not expire or was never used.
+type Credential struct {
+	CredentialID string
+	Account      string
+	Type         string
+	PublicKey    string `json:",omitempty"`
+	Created      time.Time
+	Expires      time.Time
+	LastUsed     time.Time
+}
+
+// Check returns an error if c is invalid.
+func (c *Credential) Check() error {
+	switch c.Type {
+	case TypeSecret, TypeAPIKey:
+	case TypeKey:
+		if _, err := ParsePublicKey(c.PublicKey); err != nil {
+			return err
+		}
+	default:
+		return ErrorTypeInvalid
+	}
+	if !c.Expires.IsZero() && !c.Expires.After(time.Now().UTC()) {
+		return ErrorExpiresInvalid
+	}
+	return nil
+}
+
+type Client interface {
+	Creater
+	Updater
+	Deleter
+	Fetcher
+	Lister
+	CredentialAdder
+	CredentialLister
+	CredentialRevoker
+	Authenticator
+	Err() error
+}
+
+type Creater interface {
+	Create(*Account, sq.BaseRunner)
+}
+
+type Updater interface {
+	Update(*Account, sq.BaseRunner)
+}
+
+type Deleter interface {
+	Delete(string, sq.BaseRunner)
+}
+
+type Fetcher interface {
+	Fetch(string, sq.BaseRunner) *Account
+}
+
+type Lister interface {
+	List(string, sq.BaseRunner) []*Account
+}
+
+type CredentialAdder interface {
+	AddCredential(*Credential, sq.BaseRunner) string
+}
+
+type CredentialLister interface {
+	Credentials(string, sq.BaseRunner) []*Credential
+}
+
+type CredentialRevoker interface {
+	RevokeCredential(string, string, sq.BaseRunner)
+}
+
+type Authenticator interface {
+	AuthenticateSecret(string, string, sq.BaseRunner) (*Account, error)
+	AuthenticateAPIKey(string, sq.BaseRunner) (*Account, error)
+	Keys(string, sq.BaseRunner) []*Credential
+}
+
+type AccountClient struct {
+	err error
+}
+
+// conflict maps constraint violations of err to the errors of this package.
+func conflict(err error) error {
+	switch {
+	case strings.Contains(err.Error(), "UQ_ServiceAccounts_Name"):
+		return ErrorNameInUse
+	case strings.Contains(err.Error(), "FK_ServiceAccounts_Groups"):
+		return ErrorTeamNotFound
+	}
+	return err
+}
+
+// Create inserts a into the auth.ServiceAccounts table in db. a is given an ID and its creation time.
+func (ac *AccountClient) Create(a *Account, db sq.BaseRunner) {
+	if ac.err != nil {
+		return
+	}
+	if err := a.Check(); err != nil {
+		ac.err = err
+		return
+	}
+	id, err := user.NewID()
+	if err != nil {
+		ac.err = err
+		return
+	}
+	a.ID, a.Created = id, time.Now().UTC()
+
+	insert := sq.Insert("[auth].[ServiceAccounts]").
+		Columns("[ID]", "[TenantID]", "[Name]", "[Team]", "[Description]", "[Scopes]", "[Disabled]", "[Created]").
+		Values(a.ID, a.TenantID, a.Name, a.Team, a.Description, strings.Join(a.Scopes, " "), a.Disabled, a.Created)
+	if _, err := insert.RunWith(db).Exec(); err != nil {
+		log.Print(err)
+		ac.err = conflict(err)
+	}
+}
+
+// Update replaces the name, team, description, scopes and disabled flag of the service account with the ID of a.
+func (ac *AccountClient) Update(a *Account, db sq.BaseRunner) {
+	if ac.err != nil {
+		return
+	}
+	if err := a.Check(); err != nil {
+		ac.err = err
+		return
+	}
+
+	update := sq.Update("[auth].[ServiceAccounts]").
+		Set("[Name]", a.Name).
+		Set("[Team]", a.Team).
+		Set("[Description]", a.Description).
+		Set("[Scopes]", strings.Join(a.Scopes, " ")).
+		Set("[Disabled]", a.Disabled).
+		Where(sq.Eq{"[ID]": a.ID})
+	res, err := update.RunWith(db).Exec()
+	if err != nil {
+		log.Print(err)
+		ac.err = conflict(err)
+		return
+	}
+	if cnt, err := res.RowsAffected(); err != nil || cnt != 1 {
+		ac.err = ErrorAccountNotFound
+	}
+}
+
+// Delete removes the service account with ID id and its credentials from db.
+func (ac *AccountClient) Delete(id string, db sq.BaseRunner) {
+	if ac.err != nil {
+		return
+	}
+
+	res, err := sq.Delete("[auth].[ServiceAccounts]").Where(sq.Eq{"[ID]": id}).RunWith(db).Exec()
+	if err != nil {
+		log.Print(err)
+		ac.err = err
+		return
+	}
+	if cnt, err := res.RowsAffected(); err != nil || cnt != 1 {
+		ac.err = ErrorAccountNotFound
+	}
+}
+
+var accountColumns = []string{"[ID]", "[TenantID]", "[Name]", "[Team]", "[Description]", "[Scopes]", "[Disabled]", "[Created]"}
+
+// scanAccount reads a row of accountColumns into an Account.
+func scanAccount(row sq.RowScanner) (*Account, error) {
+	a := new(Account)
+	var scopes string
+	if err := row.Scan(&a.ID, &a.TenantID, &a.Name, &a.Team, &a.Description, &scopes, &a.Disabled, &a.Created); err != nil {
+		return nil, err
+	}
+	a.Scopes = strings.Fields(scopes)
+	return a, nil
+}
+
+// Fetch selects the service account with ID id from db.
+// It returns nil without an error if there is no such service account.
+func (ac *AccountClient) Fetch(id string, db sq.BaseRunner) *Account {
+	if ac.err != nil {
+		return nil
+	}
+
+	sel := sq.Select(accountColumns...).From("[auth].[ServiceAccounts]").Where(sq.Eq{"[ID]": id})
+	a, err := scanAccount(sel.RunWith(db).QueryRow())
+	switch {
+	case err == sql.ErrNoRows:
+		return nil
+	case err != nil:
+		log.Print(err)
+		ac.err = err
+		return nil
+	}
+	return a
+}
+
+// List returns the service accounts of the tenant with ID tenantID ordered by name.
+func (ac *AccountClient) List(tenantID string, db sq.BaseRunner) (accounts []*Account) {
+	if ac.err != nil {
+		return
+	}
+
+	sel := sq.Select(accountColumns...).From("[auth].[ServiceAccounts]").Where(sq.Eq{"[TenantID]": tenantID}).OrderBy("[Name]")
+	rows, err := sel.RunWith(db).Query()
+	if err != nil {
+		log.Print(err)
+		ac.err = err
+		return
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		a, err := scanAccount(rows)
+		if err != nil {
+			log.Print(err)
+			ac.err = err
+			return nil
+		}
+		accounts = append(accounts, a)
+	}
+	if err := rows.Err(); err != nil {
+		log.Print(err)
+		ac.err = err
+		return nil
+	}
+	return
+}
+
+// AddCredential inserts c into the auth.ServiceAccountCredentials table in db. c is given a CredentialID and
+// its creation time. The client secret or API key generated for credentials of those types is returned and
+// cannot be retrieved later.
+func (ac *AccountClient) AddCredential(c *Credential, db sq.BaseRunner) (secret string) {
+	if ac.err != nil {
+		return
+	}
+	if err := c.Check(); err != nil {
+		ac.err = err
+		return
+	}
+	var (
+		hash, publicKey *string
+		err             error
+	)
+	switch c.Type {
+	case TypeSecret:
+		secret, err = pat.GenerateWith(SecretPrefix)
+	case TypeAPIKey:
+		secret, err = pat.GenerateWith(APIKeyPrefix)
+	case TypeKey:
+		publicKey = &c.PublicKey
+	}
+	if err != nil {
+		ac.err = err
+		return ""
+	}
+	if secret != "" {
+		h := pat.HashToken(secret)
+		hash = &h
+	}
+	if c.CredentialID, err = user.NewID(); err != nil {
+		ac.err = err
+		return ""
+	}
+	c.Created = time.Now().UTC()
+
+	var expires *time.Time
+	if !c.Expires.IsZero() {
+		expires = &c.Expires
+	}
+	insert := sq.Insert("[auth].[ServiceAccountCredentials]").
+		Columns("[CredentialID]", "[ID]", "[Type]", "[Hash]", "[PublicKey]", "[Created]", "[Expires]").
+		Values(c.CredentialID, c.Account, c.Type, hash, publicKey, c.Created, expires)
+	if _, err := insert.RunWith(db).Exec(); err != nil {
+		log.Print(err)
+		if strings.Contains(err.Error(), "FK_ServiceAccountCredentials_ServiceAccounts") {
+			ac.err = ErrorAccountNotFound
+		} else {
+			ac.err = err
+		}
+		return ""
+	}
+	return secret
+}
+
+var credentialColumns = []string{"[CredentialID]", "[ID]", "[Type]", "[PublicKey]", "[Created]", "[Expires]", "[LastUsed]"}
+
+// scanCredential reads a row of credentialColumns into a Credential.
+func scanCredential(row sq.RowScanner) (*Credential, error) {
+	c := new(Credential)
+	var (
+		publicKey         *string
+		expires, lastUsed *time.Time
+	)
+	if err := row.Scan(&c.CredentialID, &c.Account, &c.Type, &publicKey, &c.Created, &expires, &lastUsed); err != nil {
+		return nil, err
+	}
+	if publicKey != nil {
+		c.PublicKey = *publicKey
+	}
+	if expires != nil {
+		c.Expires = *expires
+	}
+	if lastUsed != nil {
+		c.LastUsed = *lastUsed
+	}
+	return c, nil
+}
+
+func (ac *AccountClient) credentials(sel sq.SelectBuilder, db sq.BaseRunner) (credentials []*Credential) {
+	rows, err := sel.RunWith(db).Query()
+	if err != nil {
+		log.Print(err)
+		ac.err = err
+		return
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		c, err := scanCredential(rows)
+		if err != nil {
+			log.Print(err)
+			ac.err = err
+			return nil
+		}
+		credentials = append(credentials, c)
+	}
+	if err := rows.Err(); err != nil {
+		log.Print(err)
+		ac.err = err
+		return nil
+	}
+	return
+}
+
+// Credentials returns the credentials of the service account with ID id, newest first.
+func (ac *AccountClient) Credentials(id string, db sq.BaseRunner) []*Credential {
+	if ac.err != nil {
+		return nil
+	}
+
+	sel := sq.Select(credentialColumns...).From("[auth].[ServiceAccountCredentials]").Where(sq.Eq{"[ID]": id}).OrderBy("[Created] DESC")
+	return ac.credentials(sel, db)
+}
+
+// RevokeCredential deletes the credential with ID credentialID of the service account with ID id.
+func (ac *AccountClient) RevokeCredential(id, credentialID string, db sq.BaseRunner) {
+	if ac.err != nil {
+		return
+	}
+
+	del := sq.Delete("[auth].[ServiceAccountCredentials]").Where(sq.Eq{"[CredentialID]": credentialID, "[ID]": id})
+	res, err := del.RunWith(db).Exec()
+	if err != nil {
+		log.Print(err)
+		ac.err = err
+		return
+	}
+	if cnt, err := res.RowsAffected(); err != nil || cnt != 1 {
+		ac.err = ErrorCredentialNotFound
+	}
+}
+
+// Keys returns the public key credentials of the service account with ID id that have not expired.
+func (ac *AccountClient) Keys(id string, db sq.BaseRunner) []*Credential {
+	if ac.err != nil {
+		return nil
+	}
+
+	sel := sq.Select(credentialColumns...).From("[auth].[ServiceAccountCredentials]").
+		Where(sq.Eq{"[ID]": id, "[Type]": TypeKey}).
+		Where(sq.Or{sq.Eq{"[Expires]": nil}, sq.Gt{"[Expires]": time.Now().UTC()}})
+	return ac.credentials(sel, db)
+}
+
+// AuthenticateSecret returns the service account with ID id if secret is one of its client secrets. It returns
+// ErrorCredentialInvalid if it is not and ErrorAccountDisabled if the service account is disabled.
+func (ac *AccountClient) AuthenticateSecret(id, secret string, db sq.BaseRunner) (*Account, error) {
+	if ac.err != nil {
+		return nil, ac.err
+	}
+	if !pat.ValidWith(secret, SecretPrefix) {
+		return nil, ErrorCredentialInvalid
+	}
+	return ac.authenticate(sq.Eq{"[ID]": id, "[Type]": TypeSecret, "[Hash]": pat.HashToken(secret)}, db)
+}
+
+// AuthenticateAPIKey returns the service account key is an API key of. It returns ErrorCredentialInvalid if key
+// is not an API key and ErrorAccountDisabled if the service account is disabled.
+func (ac *AccountClient) AuthenticateAPIKey(key string, db sq.BaseRunner) (*Account, error) {
+	if ac.err != nil {
+		return nil, ac.err
+	}
+	if !pat.ValidWith(key, APIKeyPrefix) {
+		return nil, ErrorCredentialInvalid
+	}
+	return ac.authenticate(sq.Eq{"[Type]": TypeAPIKey, "[Hash]": pat.HashToken(key)}, db)
+}
+
+// authenticate returns the enabled service account of the unexpired credential matching pred, recording that
+// the credential was used.
+func (ac *AccountClient) authenticate(pred sq.Eq, db sq.BaseRunner) (*Account, error) {
+	sel := sq.Select(credentialColumns...).From("[auth].[ServiceAccountCredentials]").Where(pred)
+	c, err := scanCredential(sel.RunWith(db).QueryRow())
+	switch {
+	case err == sql.ErrNoRows:
+		return nil, ErrorCredentialInvalid
+	case err != nil:
+		log.Print(err)
+		ac.err = err
+		return nil, err
+	}
+	now := time.Now().UTC()
+	if !c.Expires.IsZero() && now.After(c.Expires) {
+		return nil, ErrorCredentialInvalid
+	}
+
+	a := ac.Fetch(c.Account, db)
+	switch {
+	case ac.err != nil:
+		return nil, ac.err
+	case a == nil:
+		return nil, ErrorCredentialInvalid
+	case a.Disabled:
+		return nil, ErrorAccountDisabled
+	}
+
+	update := sq.Update("[auth].[ServiceAccountCredentials]").Set("[LastUsed]", now).Where(sq.Eq{"[CredentialID]": c.CredentialID})
+	if _, err := update.RunWith(db).Exec(); err != nil {
+		log.Print(err)
+		ac.err = err
+		return nil, err
+	}
+	return a, nil
+}
+
+// Err returns the error status of an AccountClient instance.
+func (ac *AccountClient) Err() error {
+	return ac.err
+}
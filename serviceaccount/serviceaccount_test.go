@@ -0,0 +1,364 @@
+package serviceaccount
+
+import (
+	"errors"
+	"github.com/penutty/authservice/pat"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/DATA-DOG/go-sqlmock.v1"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+var (
+	tID           = "01890a5d-ac96-774b-bcce-b302099a8057"
+	tCredentialID = "01890a5d-ac96-774b-bcce-b302099a8058"
+	tTeam         = "platform"
+
+	accountRows    = []string{"ID", "TenantID", "Name", "Team", "Description", "Scopes", "Disabled", "Created"}
+	credentialRows = []string{"CredentialID", "ID", "Type", "PublicKey", "Created", "Expires", "LastUsed"}
+
+	selectAccount    = `SELECT \[ID], \[TenantID], \[Name], \[Team], \[Description], \[Scopes], \[Disabled], \[Created] FROM \[auth]\.\[ServiceAccounts] WHERE `
+	selectCredential = `SELECT \[CredentialID], \[ID], \[Type], \[PublicKey], \[Created], \[Expires], \[LastUsed] FROM \[auth]\.\[ServiceAccountCredentials] WHERE `
+)
+
+func Test_Account_Check(t *testing.T) {
+	cases := []struct {
+		a   *Account
+		err error
+	}{
+		{&Account{Name: "ci-deployer", Team: tTeam, Scopes: []string{"deploy:prod"}}, nil},
+		{&Account{Name: "", Team: tTeam}, ErrorNameInvalid},
+		{&Account{Name: "CI", Team: tTeam}, ErrorNameInvalid},
+		{&Account{Name: "ci", Team: "Platform"}, errors.New("Group must be 1 to 64 lowercase letters, digits, '_', '-', '.' or ':'.")},
+		{&Account{Name: "ci", Team: tTeam, Description: strings.Repeat("a", 257)}, ErrorDescriptionLong},
+		{&Account{Name: "ci", Team: tTeam, Scopes: []string{"deploy prod"}}, ErrorScopeInvalid},
+	}
+	for i, c := range cases {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			err := c.a.Check()
+			if c.err == nil {
+				assert.Nil(t, err)
+			} else {
+				assert.EqualError(t, err, c.err.Error())
+			}
+		})
+	}
+}
+
+func Test_Narrow(t *testing.T) {
+	a := &Account{Scopes: []string{"deploy:prod", "metrics:read"}}
+	cases := []struct {
+		requested []string
+		scopes    []string
+		err       error
+	}{
+		{nil, []string{"deploy:prod", "metrics:read"}, nil},
+		{[]string{"metrics:read"}, []string{"metrics:read"}, nil},
+		{[]string{"metrics:write"}, nil, ErrorScopeNotPermitted},
+	}
+	for i, c := range cases {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			scopes, err := a.Narrow(c.requested)
+			assert.Equal(t, c.err, err)
+			assert.Equal(t, c.scopes, scopes)
+		})
+	}
+}
+
+func Test_Credential_Check(t *testing.T) {
+	cases := []struct {
+		c   *Credential
+		err error
+	}{
+		{&Credential{Type: TypeSecret}, nil},
+		{&Credential{Type: TypeAPIKey, Expires: time.Now().Add(time.Hour)}, nil},
+		{&Credential{Type: TypeKey, PublicKey: publicKeyPEM(t, newRSAKey(t))}, nil},
+		{&Credential{Type: TypeKey, PublicKey: "not a key"}, ErrorPublicKeyInvalid},
+		{&Credential{Type: "password"}, ErrorTypeInvalid},
+		{&Credential{Type: TypeSecret, Expires: time.Now().Add(-time.Minute)}, ErrorExpiresInvalid},
+	}
+	for i, c := range cases {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			assert.Equal(t, c.err, c.c.Check())
+		})
+	}
+}
+
+func Test_Create(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("An error occured when opening a stub database connection. ERROR: %v\n", err)
+	}
+	defer db.Close()
+
+	insert := `INSERT INTO \[auth]\.\[ServiceAccounts] \(\[ID],\[TenantID],\[Name],\[Team],\[Description],\[Scopes],\[Disabled],\[Created]\) VALUES \(\?,\?,\?,\?,\?,\?,\?,\?\)`
+	cases := []struct {
+		err    error
+		expect error
+	}{
+		{nil, nil},
+		{errors.New("Violation of UNIQUE KEY constraint 'UQ_ServiceAccounts_Name'."), ErrorNameInUse},
+		{errors.New("The INSERT statement conflicted with the FOREIGN KEY constraint \"FK_ServiceAccounts_Groups\"."), ErrorTeamNotFound},
+	}
+	for i, c := range cases {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			exec := mock.ExpectExec(insert).
+				WithArgs(sqlmock.AnyArg(), "default", "ci", tTeam, "", "deploy:prod metrics:read", false, sqlmock.AnyArg())
+			if c.err != nil {
+				exec.WillReturnError(c.err)
+			} else {
+				exec.WillReturnResult(sqlmock.NewResult(0, 1))
+			}
+
+			ac := new(AccountClient)
+			a := &Account{TenantID: "default", Name: "ci", Team: tTeam, Scopes: []string{"deploy:prod", "metrics:read"}}
+			ac.Create(a, db)
+			assert.Equal(t, c.expect, ac.Err())
+			assert.Len(t, a.ID, 36)
+
+			if err = mock.ExpectationsWereMet(); err != nil {
+				t.Errorf("Expectations were not met. ERROR: %v\n", err)
+			}
+		})
+	}
+}
+
+func Test_Update(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("An error occured when opening a stub database connection. ERROR: %v\n", err)
+	}
+	defer db.Close()
+
+	cases := []struct {
+		affected int64
+		err      error
+	}{
+		{1, nil},
+		{0, ErrorAccountNotFound},
+	}
+	for i, c := range cases {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			mock.ExpectExec(`UPDATE \[auth]\.\[ServiceAccounts] SET \[Name] = \?, \[Team] = \?, \[Description] = \?, \[Scopes] = \?, \[Disabled] = \? WHERE \[ID] = \?`).
+				WithArgs("ci", tTeam, "Deploys", "", true, tID).
+				WillReturnResult(sqlmock.NewResult(0, c.affected))
+
+			ac := new(AccountClient)
+			ac.Update(&Account{ID: tID, Name: "ci", Team: tTeam, Description: "Deploys", Disabled: true}, db)
+			assert.Equal(t, c.err, ac.Err())
+
+			if err = mock.ExpectationsWereMet(); err != nil {
+				t.Errorf("Expectations were not met. ERROR: %v\n", err)
+			}
+		})
+	}
+}
+
+func Test_Delete(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("An error occured when opening a stub database connection. ERROR: %v\n", err)
+	}
+	defer db.Close()
+
+	mock.ExpectExec(`DELETE FROM \[auth]\.\[ServiceAccounts] WHERE \[ID] = \?`).WithArgs(tID).WillReturnResult(sqlmock.NewResult(0, 0))
+
+	ac := new(AccountClient)
+	ac.Delete(tID, db)
+	assert.Equal(t, ErrorAccountNotFound, ac.Err())
+
+	if err = mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Expectations were not met. ERROR: %v\n", err)
+	}
+}
+
+func Test_Fetch(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("An error occured when opening a stub database connection. ERROR: %v\n", err)
+	}
+	defer db.Close()
+
+	created := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	mock.ExpectQuery(selectAccount + `\[ID] = \?`).WithArgs(tID).
+		WillReturnRows(sqlmock.NewRows(accountRows).AddRow(tID, "default", "ci", tTeam, "", "deploy:prod", false, created))
+	ac := new(AccountClient)
+	assert.Equal(t, &Account{ID: tID, TenantID: "default", Name: "ci", Team: tTeam, Scopes: []string{"deploy:prod"}, Created: created}, ac.Fetch(tID, db))
+	assert.Nil(t, ac.Err())
+
+	mock.ExpectQuery(selectAccount + `\[ID] = \?`).WithArgs("missing").WillReturnRows(sqlmock.NewRows(accountRows))
+	ac = new(AccountClient)
+	assert.Nil(t, ac.Fetch("missing", db))
+	assert.Nil(t, ac.Err())
+
+	mock.ExpectQuery(selectAccount + `\[TenantID] = \? ORDER BY \[Name]`).WithArgs("default").
+		WillReturnRows(sqlmock.NewRows(accountRows).
+			AddRow(tID, "default", "ci", tTeam, "", "", false, created).
+			AddRow(tCredentialID, "default", "metrics", tTeam, "", "metrics:read", true, created))
+	ac = new(AccountClient)
+	accounts := ac.List("default", db)
+	assert.Nil(t, ac.Err())
+	assert.Len(t, accounts, 2)
+	assert.True(t, accounts[1].Disabled)
+
+	if err = mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Expectations were not met. ERROR: %v\n", err)
+	}
+}
+
+func Test_AddCredential(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("An error occured when opening a stub database connection. ERROR: %v\n", err)
+	}
+	defer db.Close()
+
+	insert := `INSERT INTO \[auth]\.\[ServiceAccountCredentials] \(\[CredentialID],\[ID],\[Type],\[Hash],\[PublicKey],\[Created],\[Expires]\) VALUES \(\?,\?,\?,\?,\?,\?,\?\)`
+	key := publicKeyPEM(t, newRSAKey(t))
+	cases := []struct {
+		c      *Credential
+		prefix string
+	}{
+		{&Credential{Account: tID, Type: TypeSecret}, SecretPrefix},
+		{&Credential{Account: tID, Type: TypeAPIKey}, APIKeyPrefix},
+		{&Credential{Account: tID, Type: TypeKey, PublicKey: key}, ""},
+	}
+	for i, c := range cases {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			var hash, publicKey interface{} = sqlmock.AnyArg(), nil
+			if c.c.Type == TypeKey {
+				hash, publicKey = nil, key
+			}
+			mock.ExpectExec(insert).
+				WithArgs(sqlmock.AnyArg(), tID, c.c.Type, hash, publicKey, sqlmock.AnyArg(), nil).
+				WillReturnResult(sqlmock.NewResult(0, 1))
+
+			ac := new(AccountClient)
+			secret := ac.AddCredential(c.c, db)
+			assert.Nil(t, ac.Err())
+			assert.Len(t, c.c.CredentialID, 36)
+			if c.prefix == "" {
+				assert.Empty(t, secret)
+			} else {
+				assert.True(t, pat.ValidWith(secret, c.prefix))
+			}
+
+			if err = mock.ExpectationsWereMet(); err != nil {
+				t.Errorf("Expectations were not met. ERROR: %v\n", err)
+			}
+		})
+	}
+}
+
+func Test_Credentials(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("An error occured when opening a stub database connection. ERROR: %v\n", err)
+	}
+	defer db.Close()
+
+	created := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	mock.ExpectQuery(selectCredential + `\[ID] = \? ORDER BY \[Created] DESC`).WithArgs(tID).
+		WillReturnRows(sqlmock.NewRows(credentialRows).
+			AddRow(tCredentialID, tID, TypeSecret, nil, created, nil, created).
+			AddRow(tCredentialID, tID, TypeKey, "pem", created, created, nil))
+	ac := new(AccountClient)
+	assert.Equal(t, []*Credential{
+		&Credential{CredentialID: tCredentialID, Account: tID, Type: TypeSecret, Created: created, LastUsed: created},
+		&Credential{CredentialID: tCredentialID, Account: tID, Type: TypeKey, PublicKey: "pem", Created: created, Expires: created},
+	}, ac.Credentials(tID, db))
+	assert.Nil(t, ac.Err())
+
+	mock.ExpectQuery(selectCredential+`\[ID] = \? AND \[Type] = \? AND \(\[Expires] IS NULL OR \[Expires] > \?\)`).
+		WithArgs(tID, TypeKey, sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows(credentialRows).AddRow(tCredentialID, tID, TypeKey, "pem", created, nil, nil))
+	ac = new(AccountClient)
+	assert.Len(t, ac.Keys(tID, db), 1)
+	assert.Nil(t, ac.Err())
+
+	mock.ExpectExec(`DELETE FROM \[auth]\.\[ServiceAccountCredentials] WHERE \[CredentialID] = \? AND \[ID] = \?`).
+		WithArgs(tCredentialID, tID).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	ac = new(AccountClient)
+	ac.RevokeCredential(tID, tCredentialID, db)
+	assert.Equal(t, ErrorCredentialNotFound, ac.Err())
+
+	if err = mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Expectations were not met. ERROR: %v\n", err)
+	}
+}
+
+func Test_Authenticate(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("An error occured when opening a stub database connection. ERROR: %v\n", err)
+	}
+	defer db.Close()
+
+	secret, _ := pat.GenerateWith(SecretPrefix)
+	key, _ := pat.GenerateWith(APIKeyPrefix)
+	created := time.Now().UTC().Add(-time.Hour)
+	update := `UPDATE \[auth]\.\[ServiceAccountCredentials] SET \[LastUsed] = \? WHERE \[CredentialID] = \?`
+
+	t.Run("secret", func(t *testing.T) {
+		mock.ExpectQuery(selectCredential+`\[Hash] = \? AND \[ID] = \? AND \[Type] = \?`).
+			WithArgs(pat.HashToken(secret), tID, TypeSecret).
+			WillReturnRows(sqlmock.NewRows(credentialRows).AddRow(tCredentialID, tID, TypeSecret, nil, created, nil, nil))
+		mock.ExpectQuery(selectAccount + `\[ID] = \?`).WithArgs(tID).
+			WillReturnRows(sqlmock.NewRows(accountRows).AddRow(tID, "default", "ci", tTeam, "", "", false, created))
+		mock.ExpectExec(update).WithArgs(sqlmock.AnyArg(), tCredentialID).WillReturnResult(sqlmock.NewResult(0, 1))
+
+		ac := new(AccountClient)
+		acct, err := ac.AuthenticateSecret(tID, secret, db)
+		assert.Nil(t, err)
+		assert.Equal(t, tID, acct.ID)
+
+		if err = mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("Expectations were not met. ERROR: %v\n", err)
+		}
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		mock.ExpectQuery(selectCredential+`\[Hash] = \? AND \[Type] = \?`).
+			WithArgs(pat.HashToken(key), TypeAPIKey).
+			WillReturnRows(sqlmock.NewRows(credentialRows).AddRow(tCredentialID, tID, TypeAPIKey, nil, created, nil, nil))
+		mock.ExpectQuery(selectAccount + `\[ID] = \?`).WithArgs(tID).
+			WillReturnRows(sqlmock.NewRows(accountRows).AddRow(tID, "default", "ci", tTeam, "", "", true, created))
+
+		ac := new(AccountClient)
+		acct, err := ac.AuthenticateAPIKey(key, db)
+		assert.Nil(t, acct)
+		assert.Equal(t, ErrorAccountDisabled, err)
+		assert.Nil(t, ac.Err())
+
+		if err = mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("Expectations were not met. ERROR: %v\n", err)
+		}
+	})
+
+	t.Run("expired", func(t *testing.T) {
+		mock.ExpectQuery(selectCredential+`\[Hash] = \? AND \[Type] = \?`).
+			WithArgs(pat.HashToken(key), TypeAPIKey).
+			WillReturnRows(sqlmock.NewRows(credentialRows).AddRow(tCredentialID, tID, TypeAPIKey, nil, created, created, nil))
+
+		ac := new(AccountClient)
+		acct, err := ac.AuthenticateAPIKey(key, db)
+		assert.Nil(t, acct)
+		assert.Equal(t, ErrorCredentialInvalid, err)
+		assert.Nil(t, ac.Err())
+
+		if err = mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("Expectations were not met. ERROR: %v\n", err)
+		}
+	})
+
+	t.Run("malformed", func(t *testing.T) {
+		ac := new(AccountClient)
+		acct, err := ac.AuthenticateSecret(tID, key, db)
+		assert.Nil(t, acct)
+		assert.Equal(t, ErrorCredentialInvalid, err)
+		assert.Nil(t, ac.Err())
+	})
+}
@@ -0,0 +1,414 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/dgrijalva/jwt-go"
+	"github.com/penutty/authservice/audit"
+	"github.com/penutty/authservice/serviceaccount"
+	"github.com/penutty/authservice/user"
+	"github.com/penutty/authservice/verification"
+	"net/http"
+	"time"
+)
+
+var (
+	ErrorScopeForbidden = errors.New("Service accounts may only be granted scopes the caller holds as permissions.")
+)
+
+// serviceAccountResource is the representation of a service account accepted and returned by ServiceAccountsEndpoint.
+type serviceAccountResource struct {
+	ID          string
+	Name        string
+	Team        string
+	Description string
+	Scopes      []string
+	Disabled    bool
+	Created     time.Time
+}
+
+func newServiceAccountResource(acct *serviceaccount.Account) *serviceAccountResource {
+	res := &serviceAccountResource{
+		ID:          acct.ID,
+		Name:        acct.Name,
+		Team:        acct.Team,
+		Description: acct.Description,
+		Scopes:      acct.Scopes,
+		Disabled:    acct.Disabled,
+		Created:     acct.Created,
+	}
+	if res.Scopes == nil {
+		res.Scopes = []string{}
+	}
+	return res
+}
+
+// credentialResource is the metadata of a service account credential returned by ServiceAccountCredentialsEndpoint.
+// Secret is only set in the response adding a client secret or API key; Auth-Service cannot show it again.
+type credentialResource struct {
+	CredentialID string
+	Type         string
+	PublicKey    string `json:",omitempty"`
+	Created      time.Time
+	Expires      *time.Time `json:",omitempty"`
+	LastUsed     *time.Time `json:",omitempty"`
+	Secret       string     `json:",omitempty"`
+}
+
+func newCredentialResource(c *serviceaccount.Credential) *credentialResource {
+	res := &credentialResource{CredentialID: c.CredentialID, Type: c.Type, PublicKey: c.PublicKey, Created: c.Created}
+	if !c.Expires.IsZero() {
+		res.Expires = &c.Expires
+	}
+	if !c.LastUsed.IsZero() {
+		res.LastUsed = &c.LastUsed
+	}
+	return res
+}
+
+// teamMember authenticates r and returns ErrorUserForbidden unless the caller is an administrator or a member
+// of team, directly or through a subgroup.
+func (a *app) teamMember(r *http.Request, team string) (*user.User, error) {
+	caller, err := a.authenticateLogin(r)
+	if err != nil {
+		return nil, err
+	}
+	if err := a.checkTeam(caller, team); err != nil {
+		return nil, err
+	}
+	return caller, nil
+}
+
+// checkTeam returns ErrorUserForbidden unless caller is an administrator or a member of team.
+func (a *app) checkTeam(caller *user.User, team string) error {
+	if isAdministrator(caller.ID()) {
+		return nil
+	}
+	groups := a.g.Memberships(caller.ID(), user.AuthDB())
+	if err := a.g.Err(); err != nil {
+		return err
+	}
+	for _, g := range groups {
+		if g == team {
+			return nil
+		}
+	}
+	return ErrorUserForbidden
+}
+
+// checkScopes returns ErrorScopeForbidden unless caller is an administrator or holds every one of scopes as a
+// permission, so that a service account cannot be used to gain permissions its team members do not have.
+func (a *app) checkScopes(caller *user.User, scopes []string) error {
+	if len(scopes) == 0 || isAdministrator(caller.ID()) {
+		return nil
+	}
+	_, _, permissions, err := a.grants(caller)
+	if err != nil {
+		return err
+	}
+	if verification.Check(jwt.MapClaims{"permissions": permissions}, scopes...) != nil {
+		return ErrorScopeForbidden
+	}
+	return nil
+}
+
+// serviceAccount authenticates r and returns the caller and the service account with ID id of the tenant of r,
+// which the caller must be allowed to manage.
+func (a *app) serviceAccount(r *http.Request, id string) (*user.User, *serviceaccount.Account, error) {
+	caller, err := a.authenticateLogin(r)
+	if err != nil {
+		return nil, nil, err
+	}
+	acct := a.sa.Fetch(id, user.AuthDB())
+	if err := a.sa.Err(); err != nil {
+		return nil, nil, err
+	}
+	if acct == nil || acct.TenantID != tenantOf(r).ID {
+		return nil, nil, serviceaccount.ErrorAccountNotFound
+	}
+	if err := a.checkTeam(caller, acct.Team); err != nil {
+		return nil, nil, err
+	}
+	return caller, acct, nil
+}
+
+func (a *app) serviceAccountsHandler(w http.ResponseWriter, r *http.Request) {
+	var (
+		res  interface{}
+		err  error
+		code = http.StatusOK
+	)
+	switch r.Method {
+	case http.MethodGet:
+		res, err = a.getServiceAccounts(r)
+	case http.MethodPost:
+		res, err = a.postServiceAccount(r)
+		code = http.StatusCreated
+	case http.MethodPut:
+		res, err = a.putServiceAccount(r)
+	case http.MethodDelete:
+		if err := a.deleteServiceAccount(r); err != nil {
+			genErrorHandler(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return
+	default:
+		logger(Error).Println(ErrorMethodNotImplemented)
+		http.Error(w, http.StatusText(http.StatusNotImplemented), http.StatusNotImplemented)
+		return
+	}
+	if err != nil {
+		genErrorHandler(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	if err := json.NewEncoder(w).Encode(res); err != nil {
+		logger(Error).Println(err)
+	}
+}
+
+// getServiceAccounts lists the service accounts of the tenant of r: every one to an administrator, otherwise
+// those of the teams of the caller.
+func (a *app) getServiceAccounts(r *http.Request) ([]*serviceAccountResource, error) {
+	caller, err := a.authenticate(r, ScopeServiceAccountsRead)
+	if err != nil {
+		return nil, err
+	}
+	accounts := a.sa.List(tenantOf(r).ID, user.AuthDB())
+	if err := a.sa.Err(); err != nil {
+		return nil, err
+	}
+
+	teams := map[string]bool{}
+	admin := isAdministrator(caller.ID())
+	if !admin {
+		groups := a.g.Memberships(caller.ID(), user.AuthDB())
+		if err := a.g.Err(); err != nil {
+			return nil, err
+		}
+		for _, g := range groups {
+			teams[g] = true
+		}
+	}
+	res := make([]*serviceAccountResource, 0, len(accounts))
+	for _, acct := range accounts {
+		if admin || teams[acct.Team] {
+			res = append(res, newServiceAccountResource(acct))
+		}
+	}
+	return res, nil
+}
+
+// postServiceAccount creates a service account of the tenant of r owned by the Team in the body, of which the
+// caller must be a member unless it is an administrator. Its Scopes must be permissions of the caller, see
+// checkScopes. The creation is recorded in the audit log.
+func (a *app) postServiceAccount(r *http.Request) (*serviceAccountResource, error) {
+	b := new(serviceAccountResource)
+	if err := json.NewDecoder(r.Body).Decode(b); err != nil {
+		return nil, err
+	}
+	caller, err := a.teamMember(r, b.Team)
+	if err != nil {
+		return nil, err
+	}
+	if err := a.checkScopes(caller, b.Scopes); err != nil {
+		return nil, err
+	}
+
+	acct := &serviceaccount.Account{
+		TenantID:    tenantOf(r).ID,
+		Name:        b.Name,
+		Team:        b.Team,
+		Description: b.Description,
+		Scopes:      b.Scopes,
+		Disabled:    b.Disabled,
+	}
+	a.sa.Create(acct, user.AuthDB())
+	if err := a.sa.Err(); err != nil {
+		return nil, err
+	}
+
+	a.au.Record(audit.NewEntry(caller.ID(), acct.ID, "service_account_create", acct.Name), user.AuthDB())
+	if err := a.au.Err(); err != nil {
+		logger(Error).Println(err)
+	}
+	return newServiceAccountResource(acct), nil
+}
+
+// putServiceAccount replaces the name, team, description, scopes and disabled flag of the service account with
+// the ID in the body. Moving an account to another team requires membership of both, and added scopes must be
+// permissions of the caller, see checkScopes. Tokens issued before an account is disabled stay valid until they
+// expire. The change is recorded in the audit log.
+func (a *app) putServiceAccount(r *http.Request) (*serviceAccountResource, error) {
+	b := new(serviceAccountResource)
+	if err := json.NewDecoder(r.Body).Decode(b); err != nil {
+		return nil, err
+	}
+	caller, acct, err := a.serviceAccount(r, b.ID)
+	if err != nil {
+		return nil, err
+	}
+	if b.Team != acct.Team {
+		if err := a.checkTeam(caller, b.Team); err != nil {
+			return nil, err
+		}
+	}
+	held := make(map[string]bool)
+	for _, s := range acct.Scopes {
+		held[s] = true
+	}
+	var added []string
+	for _, s := range b.Scopes {
+		if !held[s] {
+			added = append(added, s)
+		}
+	}
+	if err := a.checkScopes(caller, added); err != nil {
+		return nil, err
+	}
+
+	acct.Name, acct.Team, acct.Description, acct.Scopes, acct.Disabled = b.Name, b.Team, b.Description, b.Scopes, b.Disabled
+	a.sa.Update(acct, user.AuthDB())
+	if err := a.sa.Err(); err != nil {
+		return nil, err
+	}
+
+	a.au.Record(audit.NewEntry(caller.ID(), acct.ID, "service_account_update", acct.Name), user.AuthDB())
+	if err := a.au.Err(); err != nil {
+		logger(Error).Println(err)
+	}
+	return newServiceAccountResource(acct), nil
+}
+
+// deleteServiceAccount deletes the service account in the "ID" query parameter with its credentials.
+// The deletion is recorded in the audit log.
+func (a *app) deleteServiceAccount(r *http.Request) error {
+	caller, acct, err := a.serviceAccount(r, r.URL.Query().Get("ID"))
+	if err != nil {
+		return err
+	}
+	a.sa.Delete(acct.ID, user.AuthDB())
+	if err := a.sa.Err(); err != nil {
+		return err
+	}
+
+	a.au.Record(audit.NewEntry(caller.ID(), acct.ID, "service_account_delete", acct.Name), user.AuthDB())
+	if err := a.au.Err(); err != nil {
+		logger(Error).Println(err)
+	}
+	return nil
+}
+
+func (a *app) serviceAccountCredentialsHandler(w http.ResponseWriter, r *http.Request) {
+	var (
+		res interface{}
+		err error
+	)
+	switch r.Method {
+	case http.MethodGet:
+		res, err = a.getServiceAccountCredentials(r)
+	case http.MethodPost:
+		res, err = a.postServiceAccountCredential(r)
+		if err == nil {
+			w.Header().Set("Content-Type", "application/json")
+			w.Header().Set("Cache-Control", "no-store")
+			w.WriteHeader(http.StatusCreated)
+			if err := json.NewEncoder(w).Encode(res); err != nil {
+				logger(Error).Println(err)
+			}
+			return
+		}
+	case http.MethodDelete:
+		if err := a.deleteServiceAccountCredential(r); err != nil {
+			genErrorHandler(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return
+	default:
+		logger(Error).Println(ErrorMethodNotImplemented)
+		http.Error(w, http.StatusText(http.StatusNotImplemented), http.StatusNotImplemented)
+		return
+	}
+	if err != nil {
+		genErrorHandler(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(res); err != nil {
+		logger(Error).Println(err)
+	}
+}
+
+// getServiceAccountCredentials returns the metadata of the credentials of the service account in the "ID" query parameter.
+func (a *app) getServiceAccountCredentials(r *http.Request) ([]*credentialResource, error) {
+	_, acct, err := a.serviceAccount(r, r.URL.Query().Get("ID"))
+	if err != nil {
+		return nil, err
+	}
+	credentials := a.sa.Credentials(acct.ID, user.AuthDB())
+	if err := a.sa.Err(); err != nil {
+		return nil, err
+	}
+	res := make([]*credentialResource, 0, len(credentials))
+	for _, c := range credentials {
+		res = append(res, newCredentialResource(c))
+	}
+	return res, nil
+}
+
+// postServiceAccountCredential adds a credential of the Type in the body to the service account with the ID in
+// the body: a generated client secret or API key, returned once, or the PublicKey in the body verifying its
+// JWT assertions. The addition is recorded in the audit log.
+func (a *app) postServiceAccountCredential(r *http.Request) (*credentialResource, error) {
+	type body struct {
+		ID        string
+		Type      string
+		PublicKey string
+		Expires   time.Time
+	}
+	b := new(body)
+	if err := json.NewDecoder(r.Body).Decode(b); err != nil {
+		return nil, err
+	}
+	caller, acct, err := a.serviceAccount(r, b.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &serviceaccount.Credential{Account: acct.ID, Type: b.Type, PublicKey: b.PublicKey, Expires: b.Expires.UTC()}
+	secret := a.sa.AddCredential(c, user.AuthDB())
+	if err := a.sa.Err(); err != nil {
+		return nil, err
+	}
+
+	a.au.Record(audit.NewEntry(caller.ID(), acct.ID, "service_account_credential_add", c.CredentialID), user.AuthDB())
+	if err := a.au.Err(); err != nil {
+		logger(Error).Println(err)
+	}
+	res := newCredentialResource(c)
+	res.Secret = secret
+	return res, nil
+}
+
+// deleteServiceAccountCredential revokes the credential in the "CredentialID" query parameter of the service
+// account in the "ID" query parameter. The revocation is recorded in the audit log.
+func (a *app) deleteServiceAccountCredential(r *http.Request) error {
+	caller, acct, err := a.serviceAccount(r, r.URL.Query().Get("ID"))
+	if err != nil {
+		return err
+	}
+	credentialID := r.URL.Query().Get("CredentialID")
+	a.sa.RevokeCredential(acct.ID, credentialID, user.AuthDB())
+	if err := a.sa.Err(); err != nil {
+		return err
+	}
+
+	a.au.Record(audit.NewEntry(caller.ID(), acct.ID, "service_account_credential_revoke", credentialID), user.AuthDB())
+	if err := a.au.Err(); err != nil {
+		logger(Error).Println(err)
+	}
+	return nil
+}
@@ -0,0 +1,311 @@
+package main
+
+import (
+	"encoding/json"
+	sq "github.com/Masterminds/squirrel"
+	"github.com/penutty/authservice/pat"
+	"github.com/penutty/authservice/serviceaccount"
+	"github.com/penutty/authservice/tenant"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+var (
+	tAccount      = "sa-ci"
+	tOtherAccount = "sa-billing"
+)
+
+// MockServiceAccountClient keeps service accounts in memory by their ID, "sa-" followed by the name, and their
+// client secrets and API keys by the secret. CredentialIDs are "cred-" followed by a sequence number.
+type MockServiceAccountClient struct {
+	err         error
+	accounts    map[string]*serviceaccount.Account
+	credentials map[string]*serviceaccount.Credential
+	secrets     map[string]string
+}
+
+// NewMockServiceAccountClient returns a MockServiceAccountClient with the account tAccount of tGroup and
+// tOtherAccount of a team tUser is no member of.
+func NewMockServiceAccountClient() *MockServiceAccountClient {
+	m := &MockServiceAccountClient{
+		accounts:    make(map[string]*serviceaccount.Account),
+		credentials: make(map[string]*serviceaccount.Credential),
+		secrets:     make(map[string]string),
+	}
+	m.Create(&serviceaccount.Account{TenantID: tenant.Default, Name: "ci", Team: tGroup, Scopes: []string{"deploy:prod"}}, nil)
+	m.Create(&serviceaccount.Account{TenantID: tenant.Default, Name: "billing", Team: "finance"}, nil)
+	return m
+}
+
+func (m *MockServiceAccountClient) Create(acct *serviceaccount.Account, db sq.BaseRunner) {
+	if err := acct.Check(); err != nil {
+		m.err = err
+		return
+	}
+	for _, o := range m.accounts {
+		if o.TenantID == acct.TenantID && o.Name == acct.Name {
+			m.err = serviceaccount.ErrorNameInUse
+			return
+		}
+	}
+	acct.ID, acct.Created = "sa-"+acct.Name, time.Now().UTC()
+	m.accounts[acct.ID] = acct
+}
+
+func (m *MockServiceAccountClient) Update(acct *serviceaccount.Account, db sq.BaseRunner) {
+	if err := acct.Check(); err != nil {
+		m.err = err
+		return
+	}
+	if _, ok := m.accounts[acct.ID]; !ok {
+		m.err = serviceaccount.ErrorAccountNotFound
+		return
+	}
+	m.accounts[acct.ID] = acct
+}
+
+func (m *MockServiceAccountClient) Delete(id string, db sq.BaseRunner) {
+	if _, ok := m.accounts[id]; !ok {
+		m.err = serviceaccount.ErrorAccountNotFound
+		return
+	}
+	delete(m.accounts, id)
+}
+
+func (m *MockServiceAccountClient) Fetch(id string, db sq.BaseRunner) *serviceaccount.Account {
+	acct, ok := m.accounts[id]
+	if m.err != nil || !ok {
+		return nil
+	}
+	fetched := *acct
+	return &fetched
+}
+
+func (m *MockServiceAccountClient) List(tenantID string, db sq.BaseRunner) (accounts []*serviceaccount.Account) {
+	for _, acct := range m.accounts {
+		if acct.TenantID == tenantID {
+			accounts = append(accounts, acct)
+		}
+	}
+	return
+}
+
+func (m *MockServiceAccountClient) AddCredential(c *serviceaccount.Credential, db sq.BaseRunner) string {
+	if err := c.Check(); err != nil {
+		m.err = err
+		return ""
+	}
+	if _, ok := m.accounts[c.Account]; !ok {
+		m.err = serviceaccount.ErrorAccountNotFound
+		return ""
+	}
+	c.CredentialID, c.Created = "cred-"+strconv.Itoa(len(m.credentials)), time.Now().UTC()
+	m.credentials[c.CredentialID] = c
+
+	var secret string
+	switch c.Type {
+	case serviceaccount.TypeSecret:
+		secret, m.err = pat.GenerateWith(serviceaccount.SecretPrefix)
+	case serviceaccount.TypeAPIKey:
+		secret, m.err = pat.GenerateWith(serviceaccount.APIKeyPrefix)
+	default:
+		return ""
+	}
+	m.secrets[secret] = c.CredentialID
+	return secret
+}
+
+func (m *MockServiceAccountClient) Credentials(id string, db sq.BaseRunner) (credentials []*serviceaccount.Credential) {
+	for _, c := range m.credentials {
+		if c.Account == id {
+			credentials = append(credentials, c)
+		}
+	}
+	return
+}
+
+func (m *MockServiceAccountClient) RevokeCredential(id, credentialID string, db sq.BaseRunner) {
+	if c, ok := m.credentials[credentialID]; !ok || c.Account != id {
+		m.err = serviceaccount.ErrorCredentialNotFound
+		return
+	}
+	delete(m.credentials, credentialID)
+}
+
+func (m *MockServiceAccountClient) AuthenticateSecret(id, secret string, db sq.BaseRunner) (*serviceaccount.Account, error) {
+	return m.authenticate(secret, serviceaccount.TypeSecret, id)
+}
+
+func (m *MockServiceAccountClient) AuthenticateAPIKey(key string, db sq.BaseRunner) (*serviceaccount.Account, error) {
+	return m.authenticate(key, serviceaccount.TypeAPIKey, "")
+}
+
+func (m *MockServiceAccountClient) authenticate(secret, typ, id string) (*serviceaccount.Account, error) {
+	if m.err != nil {
+		return nil, m.err
+	}
+	c, ok := m.credentials[m.secrets[secret]]
+	if !ok || c.Type != typ || (id != "" && c.Account != id) {
+		return nil, serviceaccount.ErrorCredentialInvalid
+	}
+	acct := m.accounts[c.Account]
+	if acct.Disabled {
+		return nil, serviceaccount.ErrorAccountDisabled
+	}
+	c.LastUsed = time.Now().UTC()
+	return acct, nil
+}
+
+func (m *MockServiceAccountClient) Keys(id string, db sq.BaseRunner) (keys []*serviceaccount.Credential) {
+	for _, c := range m.Credentials(id, db) {
+		if c.Type == serviceaccount.TypeKey {
+			keys = append(keys, c)
+		}
+	}
+	return
+}
+
+func (m *MockServiceAccountClient) Err() error {
+	return m.err
+}
+
+// newServiceAccountApp returns an app where tUser is a member of tGroup, which owns tAccount.
+func newServiceAccountApp() (*app, *MockServiceAccountClient) {
+	a, _, _ := newVerifyApp()
+	a.au = new(MockAuditClient)
+	a.g = NewMockGroupClient()
+	a.ss = NewMockSessionClient()
+	sa := NewMockServiceAccountClient()
+	a.sa = sa
+	return a, sa
+}
+
+func Test_serviceAccountsHandler(t *testing.T) {
+	defer func(admins []string) { Administrators = admins }(Administrators)
+
+	type test struct {
+		req      *http.Request
+		admins   []string
+		code     int
+		accounts int
+		audit    int
+	}
+	body := func(s string) io.Reader {
+		return strings.NewReader(s)
+	}
+	cases := []*test{
+		&test{NewBearerRequest(http.MethodGet, ServiceAccountsEndpoint, nil), nil, http.StatusOK, 2, 0},
+		&test{NewBearerRequest(http.MethodGet, ServiceAccountsEndpoint, nil), []string{tUser}, http.StatusOK, 2, 0},
+		&test{NewBearerRequest(http.MethodPost, ServiceAccountsEndpoint, body(`{"Name": "deployer", "Team": "`+tGroup+`"}`)), nil, http.StatusCreated, 3, 1},
+		&test{NewBearerRequest(http.MethodPost, ServiceAccountsEndpoint, body(`{"Name": "deployer", "Team": "`+tParentGroup+`"}`)), nil, http.StatusCreated, 3, 1},
+		&test{NewBearerRequest(http.MethodPost, ServiceAccountsEndpoint, body(`{"Name": "deployer", "Team": "finance"}`)), nil, http.StatusForbidden, 2, 0},
+		&test{NewBearerRequest(http.MethodPost, ServiceAccountsEndpoint, body(`{"Name": "deployer", "Team": "finance"}`)), []string{tUser}, http.StatusCreated, 3, 1},
+		&test{NewBearerRequest(http.MethodPost, ServiceAccountsEndpoint, body(`{"Name": "deployer", "Team": "`+tGroup+`", "Scopes": ["moments:read"]}`)), nil, http.StatusCreated, 3, 1},
+		&test{NewBearerRequest(http.MethodPost, ServiceAccountsEndpoint, body(`{"Name": "deployer", "Team": "`+tGroup+`", "Scopes": ["deploy:prod"]}`)), nil, http.StatusForbidden, 2, 0},
+		&test{NewBearerRequest(http.MethodPost, ServiceAccountsEndpoint, body(`{"Name": "deployer", "Team": "`+tGroup+`", "Scopes": ["deploy:prod"]}`)), []string{tUser}, http.StatusCreated, 3, 1},
+		&test{NewBearerRequest(http.MethodPost, ServiceAccountsEndpoint, body(`{"Name": "ci", "Team": "`+tGroup+`"}`)), nil, http.StatusConflict, 2, 0},
+		&test{NewBearerRequest(http.MethodPost, ServiceAccountsEndpoint, body(`{"Name": "Deployer", "Team": "`+tGroup+`"}`)), nil, http.StatusBadRequest, 2, 0},
+		&test{NewBearerRequest(http.MethodPut, ServiceAccountsEndpoint, body(`{"ID": "`+tAccount+`", "Name": "ci", "Team": "`+tGroup+`", "Disabled": true}`)), nil, http.StatusOK, 2, 1},
+		&test{NewBearerRequest(http.MethodPut, ServiceAccountsEndpoint, body(`{"ID": "`+tAccount+`", "Name": "ci", "Team": "`+tGroup+`", "Scopes": ["deploy:prod"], "Description": "CI"}`)), nil, http.StatusOK, 2, 1},
+		&test{NewBearerRequest(http.MethodPut, ServiceAccountsEndpoint, body(`{"ID": "`+tAccount+`", "Name": "ci", "Team": "`+tGroup+`", "Scopes": ["deploy:prod", "deploy:staging"]}`)), nil, http.StatusForbidden, 2, 0},
+		&test{NewBearerRequest(http.MethodPut, ServiceAccountsEndpoint, body(`{"ID": "`+tAccount+`", "Name": "ci", "Team": "finance"}`)), nil, http.StatusForbidden, 2, 0},
+		&test{NewBearerRequest(http.MethodPut, ServiceAccountsEndpoint, body(`{"ID": "`+tOtherAccount+`", "Name": "billing", "Team": "`+tGroup+`"}`)), nil, http.StatusForbidden, 2, 0},
+		&test{NewBearerRequest(http.MethodDelete, ServiceAccountsEndpoint+"?ID="+tAccount, nil), nil, http.StatusNoContent, 1, 1},
+		&test{NewBearerRequest(http.MethodDelete, ServiceAccountsEndpoint+"?ID="+tOtherAccount, nil), nil, http.StatusForbidden, 2, 0},
+		&test{NewBearerRequest(http.MethodDelete, ServiceAccountsEndpoint+"?ID=sa-missing", nil), nil, http.StatusNotFound, 2, 0},
+		&test{httptest.NewRequest(http.MethodGet, ServiceAccountsEndpoint, nil), nil, http.StatusUnauthorized, 2, 0},
+		&test{NewBearerRequest(http.MethodPatch, ServiceAccountsEndpoint, nil), nil, http.StatusNotImplemented, 2, 0},
+	}
+	for i, c := range cases {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			Administrators = administrators(c.admins...)
+			a, sa := newServiceAccountApp()
+			rb := NewMockRBACClient()
+			rb.users[tID] = []string{tRole}
+			a.rb = rb
+			au := a.au.(*MockAuditClient)
+			rec := httptest.NewRecorder()
+			a.serviceAccountsHandler(rec, c.req)
+			assert.Equal(t, c.code, rec.Code)
+			assert.Len(t, sa.accounts, c.accounts)
+			assert.Len(t, au.entries, c.audit)
+
+			if c.req.Method == http.MethodGet && c.code == http.StatusOK {
+				var res []*serviceAccountResource
+				assert.Nil(t, json.NewDecoder(rec.Body).Decode(&res))
+				if len(c.admins) > 0 {
+					assert.Len(t, res, 2)
+					return
+				}
+				assert.Len(t, res, 1)
+				assert.Equal(t, tAccount, res[0].ID)
+			}
+		})
+	}
+
+	t.Run(strconv.Itoa(len(cases)), func(t *testing.T) {
+		Administrators = nil
+		a, sa := newServiceAccountApp()
+		sa.accounts[tAccount].TenantID = tTenant
+		rec := httptest.NewRecorder()
+		a.serviceAccountsHandler(rec, NewBearerRequest(http.MethodDelete, ServiceAccountsEndpoint+"?ID="+tAccount, nil))
+		assert.Equal(t, http.StatusNotFound, rec.Code)
+		assert.Len(t, sa.accounts, 2)
+	})
+}
+
+func Test_serviceAccountCredentialsHandler(t *testing.T) {
+	type test struct {
+		req         *http.Request
+		code        int
+		credentials int
+		audit       int
+	}
+	body := func(id, typ string) io.Reader {
+		return strings.NewReader(`{"ID": "` + id + `", "Type": "` + typ + `"}`)
+	}
+	cases := []*test{
+		&test{NewBearerRequest(http.MethodPost, ServiceAccountCredentialsEndpoint, body(tAccount, serviceaccount.TypeSecret)), http.StatusCreated, 2, 1},
+		&test{NewBearerRequest(http.MethodPost, ServiceAccountCredentialsEndpoint, body(tAccount, serviceaccount.TypeAPIKey)), http.StatusCreated, 2, 1},
+		&test{NewBearerRequest(http.MethodPost, ServiceAccountCredentialsEndpoint, body(tAccount, serviceaccount.TypeKey)), http.StatusBadRequest, 1, 0},
+		&test{NewBearerRequest(http.MethodPost, ServiceAccountCredentialsEndpoint, body(tOtherAccount, serviceaccount.TypeSecret)), http.StatusForbidden, 1, 0},
+		&test{NewBearerRequest(http.MethodGet, ServiceAccountCredentialsEndpoint+"?ID="+tAccount, nil), http.StatusOK, 1, 0},
+		&test{NewBearerRequest(http.MethodGet, ServiceAccountCredentialsEndpoint+"?ID=sa-missing", nil), http.StatusNotFound, 1, 0},
+		&test{NewBearerRequest(http.MethodDelete, ServiceAccountCredentialsEndpoint+"?ID="+tAccount+"&CredentialID=cred-0", nil), http.StatusNoContent, 0, 1},
+		&test{NewBearerRequest(http.MethodDelete, ServiceAccountCredentialsEndpoint+"?ID="+tAccount+"&CredentialID=cred-9", nil), http.StatusNotFound, 1, 0},
+		&test{NewBearerRequest(http.MethodPut, ServiceAccountCredentialsEndpoint, nil), http.StatusNotImplemented, 1, 0},
+	}
+	for i, c := range cases {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			a, sa := newServiceAccountApp()
+			secret := sa.AddCredential(&serviceaccount.Credential{Account: tAccount, Type: serviceaccount.TypeSecret}, nil)
+			au := a.au.(*MockAuditClient)
+			rec := httptest.NewRecorder()
+			a.serviceAccountCredentialsHandler(rec, c.req)
+			assert.Equal(t, c.code, rec.Code)
+			assert.Len(t, sa.credentials, c.credentials)
+			assert.Len(t, au.entries, c.audit)
+			assert.NotContains(t, rec.Body.String(), secret)
+
+			switch c.code {
+			case http.StatusCreated:
+				res := new(credentialResource)
+				assert.Nil(t, json.NewDecoder(rec.Body).Decode(res))
+				assert.True(t, pat.ValidWith(res.Secret, serviceaccount.SecretPrefix) || pat.ValidWith(res.Secret, serviceaccount.APIKeyPrefix))
+				assert.Equal(t, "no-store", rec.Header().Get("Cache-Control"))
+			case http.StatusOK:
+				var res []*credentialResource
+				assert.Nil(t, json.NewDecoder(rec.Body).Decode(&res))
+				assert.Len(t, res, 1)
+				assert.Equal(t, "cred-0", res[0].CredentialID)
+				assert.Empty(t, res[0].Secret)
+			}
+		})
+	}
+}
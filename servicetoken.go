@@ -0,0 +1,129 @@
+package main
+
+import (
+	"errors"
+	"github.com/dgrijalva/jwt-go"
+	"github.com/penutty/authservice/env"
+	"github.com/penutty/authservice/serviceaccount"
+	"github.com/penutty/authservice/user"
+	"github.com/penutty/authservice/verification"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// Grant types of service accounts: RFC 6749 client credentials and RFC 7523 JWT bearer assertions.
+const (
+	GrantTypeClientCredentials = "client_credentials"
+	GrantTypeJWTBearer         = "urn:ietf:params:oauth:grant-type:jwt-bearer"
+)
+
+var (
+	// ServiceTokenLifetime is the lifetime of the access tokens of service accounts.
+	ServiceTokenLifetime = env.PositiveDuration("ServiceTokenLifetime", time.Hour)
+	// TokenEndpointURL is the absolute URL of TokenEndpoint. JWT bearer assertions must name it or the issuer of
+	// the tenant of the service account as their audience.
+	TokenEndpointURL = os.Getenv("TokenEndpointURL")
+
+	ErrorAssertionMissing = errors.New("Form value \"assertion\" is required.")
+	ErrorServiceAccount   = errors.New("Token was issued to a service account.")
+)
+
+// clientCredentialsGrant issues an access token to the service account authenticating r with its ID and a
+// client secret as HTTP Basic credentials.
+func (a *app) clientCredentialsGrant(r *http.Request) (*tokenResponse, error) {
+	id, secret, ok := r.BasicAuth()
+	if !ok {
+		return nil, ErrorClientUnauthenticated
+	}
+	acct, err := a.sa.AuthenticateSecret(id, secret, user.AuthDB())
+	if err != nil {
+		return nil, err
+	}
+	if acct.TenantID != tenantOf(r).ID {
+		logger(Warn).Println(ErrorTenantMismatch)
+		return nil, serviceaccount.ErrorCredentialInvalid
+	}
+	return a.serviceToken(acct, strings.Fields(r.PostForm.Get("scope")))
+}
+
+// jwtBearerGrant issues an access token to the service account that signed the "assertion" form value with one
+// of its keys, see serviceaccount.VerifyAssertion.
+func (a *app) jwtBearerGrant(r *http.Request) (*tokenResponse, error) {
+	assertion := r.PostForm.Get("assertion")
+	if assertion == "" {
+		return nil, ErrorAssertionMissing
+	}
+	id, err := serviceaccount.AssertionSubject(assertion)
+	if err != nil {
+		return nil, err
+	}
+	acct, err := a.activeServiceAccount(id)
+	if err != nil {
+		logger(Warn).Println(err)
+		return nil, serviceaccount.ErrorAssertionInvalid
+	}
+	if acct.TenantID != tenantOf(r).ID {
+		logger(Warn).Println(ErrorTenantMismatch)
+		return nil, serviceaccount.ErrorAssertionInvalid
+	}
+	keys := a.sa.Keys(id, user.AuthDB())
+	if err := a.sa.Err(); err != nil {
+		return nil, err
+	}
+	if err := serviceaccount.VerifyAssertion(assertion, id, keys, verification.IssuerFor(acct.TenantID), TokenEndpointURL); err != nil {
+		return nil, err
+	}
+	return a.serviceToken(acct, strings.Fields(r.PostForm.Get("scope")))
+}
+
+// serviceToken issues an access token to acct with the requested scopes, every scope of acct if none are
+// requested. Its "account_type" claim tells it from the tokens of users; see verification.ServiceAccount.
+func (a *app) serviceToken(acct *serviceaccount.Account, requested []string) (*tokenResponse, error) {
+	scopes, err := acct.Narrow(requested)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UTC()
+	claims := jwt.MapClaims{
+		"iss":          verification.IssuerFor(acct.TenantID),
+		"sub":          acct.ID,
+		"aud":          "Moment-Service",
+		"exp":          now.Add(ServiceTokenLifetime).Unix(),
+		"iat":          now.Unix(),
+		"tid":          acct.TenantID,
+		"client_id":    acct.ID,
+		"account_type": verification.AccountTypeService,
+	}
+	if len(scopes) > 0 {
+		claims["scope"] = strings.Join(scopes, " ")
+	}
+	token, err := signJwt(claims)
+	if err != nil {
+		return nil, err
+	}
+
+	return &tokenResponse{
+		AccessToken: token,
+		TokenType:   "Bearer",
+		ExpiresIn:   int64(ServiceTokenLifetime.Seconds()),
+		Scope:       strings.Join(scopes, " "),
+	}, nil
+}
+
+// activeServiceAccount returns the service account with ID id unless it is disabled.
+func (a *app) activeServiceAccount(id string) (*serviceaccount.Account, error) {
+	acct := a.sa.Fetch(id, user.AuthDB())
+	if err := a.sa.Err(); err != nil {
+		return nil, err
+	}
+	if acct == nil {
+		return nil, serviceaccount.ErrorAccountNotFound
+	}
+	if acct.Disabled {
+		return nil, serviceaccount.ErrorAccountDisabled
+	}
+	return acct, nil
+}
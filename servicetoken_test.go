@@ -0,0 +1,206 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"github.com/dgrijalva/jwt-go"
+	"github.com/penutty/authservice/serviceaccount"
+	"github.com/penutty/authservice/tenant"
+	"github.com/penutty/authservice/verification"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// newAssertion returns a JWT bearer assertion of tAccount for audience signed by key.
+func newAssertion(t *testing.T, key *rsa.PrivateKey, audience string, lifetime time.Duration) string {
+	claims := jwt.MapClaims{"iss": tAccount, "sub": tAccount, "aud": audience, "exp": time.Now().Add(lifetime).Unix()}
+	s, err := jwt.NewWithClaims(jwt.SigningMethodRS256, claims).SignedString(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+// addKey generates an RSA key and adds its public key to tAccount.
+func addKey(t *testing.T, sa *MockServiceAccountClient) *rsa.PrivateKey {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pub := string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}))
+	sa.AddCredential(&serviceaccount.Credential{Account: tAccount, Type: serviceaccount.TypeKey, PublicKey: pub}, nil)
+	return key
+}
+
+func Test_clientCredentialsGrant(t *testing.T) {
+	type test struct {
+		clientID string
+		secret   func(secret string) string
+		scope    string
+		prepare  func(*MockServiceAccountClient)
+		code     int
+		error    string
+	}
+	issued := func(s string) string { return s }
+	cases := []*test{
+		&test{tAccount, issued, "", nil, http.StatusOK, ""},
+		&test{tAccount, issued, "deploy:prod", nil, http.StatusOK, ""},
+		&test{tAccount, issued, "metrics:write", nil, http.StatusBadRequest, "invalid_scope"},
+		&test{tAccount, func(s string) string { return s[:len(s)-1] + "x" }, "", nil, http.StatusUnauthorized, "invalid_client"},
+		&test{tOtherAccount, issued, "", nil, http.StatusUnauthorized, "invalid_client"},
+		&test{"", issued, "", nil, http.StatusUnauthorized, "invalid_client"},
+		&test{tAccount, issued, "", func(sa *MockServiceAccountClient) { sa.accounts[tAccount].Disabled = true }, http.StatusUnauthorized, "invalid_client"},
+		&test{tAccount, issued, "", func(sa *MockServiceAccountClient) { sa.accounts[tAccount].TenantID = tTenant }, http.StatusUnauthorized, "invalid_client"},
+	}
+	for i, c := range cases {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			a, sa := newServiceAccountApp()
+			secret := sa.AddCredential(&serviceaccount.Credential{Account: tAccount, Type: serviceaccount.TypeSecret}, nil)
+			if c.prepare != nil {
+				c.prepare(sa)
+			}
+			form := url.Values{"grant_type": {GrantTypeClientCredentials}, "scope": {c.scope}}
+			rec := httptest.NewRecorder()
+			a.tokenHandler(rec, NewExchangeRequest(c.clientID, c.secret(secret), form))
+			assert.Equal(t, c.code, rec.Code)
+
+			if c.code != http.StatusOK {
+				res := make(map[string]string)
+				assert.Nil(t, json.NewDecoder(rec.Body).Decode(&res))
+				assert.Equal(t, c.error, res["error"])
+				return
+			}
+			res := new(tokenResponse)
+			assert.Nil(t, json.NewDecoder(rec.Body).Decode(res))
+			assert.Equal(t, "deploy:prod", res.Scope)
+			claims, err := verification.ParseAudience(res.AccessToken, "Moment-Service")
+			assert.Nil(t, err)
+			assert.True(t, verification.ServiceAccount(claims))
+			assert.Equal(t, tAccount, claims["sub"])
+			assert.Equal(t, tenant.Default, verification.Tenant(claims))
+		})
+	}
+}
+
+func Test_jwtBearerGrant(t *testing.T) {
+	type test struct {
+		assertion func(key, other *rsa.PrivateKey) string
+		prepare   func(*MockServiceAccountClient)
+		code      int
+	}
+	issuer := verification.IssuerFor(tenant.Default)
+	cases := []*test{
+		&test{func(key, _ *rsa.PrivateKey) string { return newAssertion(t, key, issuer, time.Minute) }, nil, http.StatusOK},
+		&test{func(_, other *rsa.PrivateKey) string { return newAssertion(t, other, issuer, time.Minute) }, nil, http.StatusBadRequest},
+		&test{func(key, _ *rsa.PrivateKey) string {
+			return newAssertion(t, key, "https://other.example.com", time.Minute)
+		}, nil, http.StatusBadRequest},
+		&test{func(key, _ *rsa.PrivateKey) string { return newAssertion(t, key, issuer, time.Hour) }, nil, http.StatusBadRequest},
+		&test{func(key, _ *rsa.PrivateKey) string { return newAssertion(t, key, issuer, time.Minute) }, func(sa *MockServiceAccountClient) {
+			sa.accounts[tAccount].Disabled = true
+		}, http.StatusBadRequest},
+		&test{func(key, _ *rsa.PrivateKey) string { return newAssertion(t, key, issuer, time.Minute) }, func(sa *MockServiceAccountClient) {
+			delete(sa.accounts, tAccount)
+		}, http.StatusBadRequest},
+		&test{func(*rsa.PrivateKey, *rsa.PrivateKey) string { return "not.a.token" }, nil, http.StatusBadRequest},
+		&test{func(*rsa.PrivateKey, *rsa.PrivateKey) string { return "" }, nil, http.StatusBadRequest},
+	}
+	other, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, c := range cases {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			a, sa := newServiceAccountApp()
+			key := addKey(t, sa)
+			if c.prepare != nil {
+				c.prepare(sa)
+			}
+			form := url.Values{"grant_type": {GrantTypeJWTBearer}, "assertion": {c.assertion(key, other)}}
+			rec := httptest.NewRecorder()
+			a.tokenHandler(rec, NewExchangeRequest("", "", form))
+			assert.Equal(t, c.code, rec.Code)
+		})
+	}
+}
+
+func Test_serviceToken_humanFlows(t *testing.T) {
+	a, sa := newServiceAccountApp()
+	res, err := a.serviceToken(sa.accounts[tAccount], nil)
+	assert.Nil(t, err)
+
+	r := httptest.NewRequest(http.MethodGet, UserTokensEndpoint, nil)
+	r.Header.Set("Authorization", "Bearer "+res.AccessToken)
+	_, err = a.authenticate(r, ScopeUserRead)
+	assert.NotNil(t, err)
+
+	a.x = new(MockExchangeClient)
+	rec := httptest.NewRecorder()
+	a.tokenHandler(rec, NewExchangeRequest(tClientID, tSecret, NewExchangeForm(res.AccessToken, tAudience, "")))
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+}
+
+func Test_introspectionHandler_serviceAccount(t *testing.T) {
+	type test struct {
+		token   func(a *app, sa *MockServiceAccountClient) string
+		prepare func(*MockServiceAccountClient)
+		active  bool
+		typ     string
+	}
+	serviceJwt := func(a *app, sa *MockServiceAccountClient) string {
+		res, err := a.serviceToken(sa.accounts[tAccount], nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return res.AccessToken
+	}
+	apiKey := func(a *app, sa *MockServiceAccountClient) string {
+		return sa.AddCredential(&serviceaccount.Credential{Account: tAccount, Type: serviceaccount.TypeAPIKey}, nil)
+	}
+	disable := func(sa *MockServiceAccountClient) { sa.accounts[tAccount].Disabled = true }
+	cases := []*test{
+		&test{serviceJwt, nil, true, "Bearer"},
+		&test{serviceJwt, disable, false, ""},
+		&test{apiKey, nil, true, TokenTypeAPIKey},
+		&test{apiKey, disable, false, ""},
+		&test{func(a *app, sa *MockServiceAccountClient) string {
+			return sa.AddCredential(&serviceaccount.Credential{Account: tAccount, Type: serviceaccount.TypeSecret}, nil)
+		}, nil, false, ""},
+	}
+	for i, c := range cases {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			a, sa := newServiceAccountApp()
+			a.x = new(MockExchangeClient)
+			token := c.token(a, sa)
+			if c.prepare != nil {
+				c.prepare(sa)
+			}
+			rec := httptest.NewRecorder()
+			a.introspectionHandler(rec, NewIntrospectionRequest(tClientID, tSecret, token))
+			assert.Equal(t, http.StatusOK, rec.Code)
+
+			res := new(verification.Introspection)
+			assert.Nil(t, json.NewDecoder(rec.Body).Decode(res))
+			assert.Equal(t, c.active, res.Active)
+			if !c.active {
+				return
+			}
+			assert.Equal(t, c.typ, res.TokenType)
+			assert.Equal(t, tAccount, res.Sub)
+			assert.Equal(t, "ci", res.Username)
+			assert.Equal(t, []string{"deploy:prod"}, res.Scopes())
+		})
+	}
+}
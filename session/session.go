@@ -0,0 +1,310 @@
+// Package session is dedicated to reading and writing the sessions of users in Auth-Db. Every login starts
+// a session, which names the device the user logged in from. The access tokens issued by the login carry its
+// SessionID, and so do the tokens exchanged for them, so that revoking the session invalidates all of them.
+//
+// A session also has a family of refresh tokens. Each refresh token is redeemed once for the next one; a refresh
+// token presented again was stolen or replayed, so redeeming it revokes the whole session.
+package session
+
+import (
+	"database/sql"
+	"errors"
+	sq "github.com/Masterminds/squirrel"
+	"github.com/penutty/authservice/pat"
+	"github.com/penutty/authservice/user"
+	"log"
+	"time"
+	"unicode/utf8"
+)
+
+var (
+	DeviceNameMaxLength = 64
+	UserAgentMaxLength  = 256
+
+	ErrorSessionNotFound     = errors.New("Session does not exist.")
+	ErrorRefreshTokenInvalid = errors.New("Refresh token is invalid or expired.")
+	ErrorRefreshTokenReused  = errors.New("Refresh token was already used; its session was revoked.")
+)
+
+// RefreshTokenPrefix starts every refresh token. Refresh tokens are generated like personal access tokens, see
+// package pat, so that secret scanners recognize them.
+const RefreshTokenPrefix = "asr_"
+
+// Session is a login of the user with ID Owner from the device named DeviceName, if the client named it,
+// with UserAgent from IP. LastSeen is the last time a token of the session was used.
+type Session struct {
+	SessionID  string
+	Owner      string
+	DeviceName string
+	UserAgent  string
+	IP         string
+	Created    time.Time
+	LastSeen   time.Time
+}
+
+// truncate returns the first n runes of s.
+func truncate(s string, n int) string {
+	if utf8.RuneCountInString(s) <= n {
+		return s
+	}
+	return string([]rune(s)[:n])
+}
+
+type Client interface {
+	Creater
+	Lister
+	Fetcher
+	Revoker
+	Toucher
+	Refresher
+	Err() error
+}
+
+type Creater interface {
+	Create(*Session, sq.BaseRunner)
+}
+
+type Lister interface {
+	List(string, time.Time, sq.BaseRunner) []*Session
+}
+
+type Fetcher interface {
+	Fetch(string, sq.BaseRunner) *Session
+}
+
+type Revoker interface {
+	Revoke(string, string, sq.BaseRunner) error
+	RevokeAll(string, sq.BaseRunner)
+}
+
+type Toucher interface {
+	Touch(string, sq.BaseRunner)
+}
+
+type Refresher interface {
+	Issue(string, time.Time, sq.BaseRunner) string
+	Redeem(string, sq.BaseRunner) (string, error)
+}
+
+type SessionClient struct {
+	err error
+}
+
+// Create inserts s into the auth.Sessions table in db. s is given a SessionID and its creation time, which is
+// also the time it was last seen. DeviceName and UserAgent are truncated to their maximum lengths as clients
+// are free to send anything.
+func (sc *SessionClient) Create(s *Session, db sq.BaseRunner) {
+	if sc.err != nil {
+		return
+	}
+	id, err := user.NewID()
+	if err != nil {
+		sc.err = err
+		return
+	}
+	s.SessionID, s.Created = id, time.Now().UTC()
+	s.LastSeen = s.Created
+	s.DeviceName = truncate(s.DeviceName, DeviceNameMaxLength)
+	s.UserAgent = truncate(s.UserAgent, UserAgentMaxLength)
+
+	insert := sq.Insert("[auth].[Sessions]").
+		Columns("[SessionID]", "[ID]", "[DeviceName]", "[UserAgent]", "[IP]", "[Created]", "[LastSeen]").
+		Values(s.SessionID, s.Owner, s.DeviceName, s.UserAgent, s.IP, s.Created, s.LastSeen)
+	if _, err := insert.RunWith(db).Exec(); err != nil {
+		log.Print(err)
+		sc.err = err
+	}
+}
+
+var sessionColumns = []string{"[SessionID]", "[ID]", "[DeviceName]", "[UserAgent]", "[IP]", "[Created]", "[LastSeen]"}
+
+// scanSession reads a row of sessionColumns into a Session.
+func scanSession(row sq.RowScanner) (*Session, error) {
+	s := new(Session)
+	if err := row.Scan(&s.SessionID, &s.Owner, &s.DeviceName, &s.UserAgent, &s.IP, &s.Created, &s.LastSeen); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// List returns the sessions of the user with ID owner started after since, most recently seen first.
+func (sc *SessionClient) List(owner string, since time.Time, db sq.BaseRunner) (sessions []*Session) {
+	if sc.err != nil {
+		return
+	}
+
+	sel := sq.Select(sessionColumns...).From("[auth].[Sessions]").
+		Where(sq.Eq{"[ID]": owner}).
+		Where(sq.Gt{"[Created]": since}).
+		OrderBy("[LastSeen] DESC")
+	rows, err := sel.RunWith(db).Query()
+	if err != nil {
+		log.Print(err)
+		sc.err = err
+		return
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		s, err := scanSession(rows)
+		if err != nil {
+			log.Print(err)
+			sc.err = err
+			return nil
+		}
+		sessions = append(sessions, s)
+	}
+	if err := rows.Err(); err != nil {
+		log.Print(err)
+		sc.err = err
+		return nil
+	}
+	return
+}
+
+// Fetch selects the session with ID sessionID from db.
+// It returns nil without an error if there is no such session, e.g. because it was revoked.
+func (sc *SessionClient) Fetch(sessionID string, db sq.BaseRunner) *Session {
+	if sc.err != nil {
+		return nil
+	}
+
+	sel := sq.Select(sessionColumns...).From("[auth].[Sessions]").Where(sq.Eq{"[SessionID]": sessionID})
+	s, err := scanSession(sel.RunWith(db).QueryRow())
+	switch {
+	case err == sql.ErrNoRows:
+		return nil
+	case err != nil:
+		log.Print(err)
+		sc.err = err
+		return nil
+	}
+	return s
+}
+
+// Revoke deletes the session with ID sessionID of the user with ID owner. It returns ErrorSessionNotFound if
+// owner has no such session.
+func (sc *SessionClient) Revoke(owner, sessionID string, db sq.BaseRunner) error {
+	if sc.err != nil {
+		return sc.err
+	}
+
+	res, err := sq.Delete("[auth].[Sessions]").Where(sq.Eq{"[SessionID]": sessionID, "[ID]": owner}).RunWith(db).Exec()
+	if err != nil {
+		log.Print(err)
+		sc.err = err
+		return err
+	}
+	if cnt, err := res.RowsAffected(); err != nil || cnt != 1 {
+		return ErrorSessionNotFound
+	}
+	return nil
+}
+
+// RevokeAll deletes every session of the user with ID owner.
+func (sc *SessionClient) RevokeAll(owner string, db sq.BaseRunner) {
+	if sc.err != nil {
+		return
+	}
+
+	if _, err := sq.Delete("[auth].[Sessions]").Where(sq.Eq{"[ID]": owner}).RunWith(db).Exec(); err != nil {
+		log.Print(err)
+		sc.err = err
+	}
+}
+
+// Touch records that the session with ID sessionID was seen now.
+func (sc *SessionClient) Touch(sessionID string, db sq.BaseRunner) {
+	if sc.err != nil {
+		return
+	}
+
+	update := sq.Update("[auth].[Sessions]").Set("[LastSeen]", time.Now().UTC()).Where(sq.Eq{"[SessionID]": sessionID})
+	if _, err := update.RunWith(db).Exec(); err != nil {
+		log.Print(err)
+		sc.err = err
+	}
+}
+
+// Issue returns a new refresh token of the session with ID sessionID that expires at expires. Only its hash is
+// stored in the auth.RefreshTokens table in db.
+func (sc *SessionClient) Issue(sessionID string, expires time.Time, db sq.BaseRunner) string {
+	if sc.err != nil {
+		return ""
+	}
+	token, err := pat.GenerateWith(RefreshTokenPrefix)
+	if err != nil {
+		sc.err = err
+		return ""
+	}
+
+	insert := sq.Insert("[auth].[RefreshTokens]").
+		Columns("[Hash]", "[SessionID]", "[Expires]", "[Used]").
+		Values(pat.HashToken(token), sessionID, expires.UTC(), false)
+	if _, err := insert.RunWith(db).Exec(); err != nil {
+		log.Print(err)
+		sc.err = err
+		return ""
+	}
+	return token
+}
+
+// Redeem marks the refresh token as used and returns the SessionID of its session. It returns
+// ErrorRefreshTokenInvalid if there is no such token or it expired. A token that was already used is evidence
+// that the family of the session leaked, so its session is deleted and ErrorRefreshTokenReused returned.
+func (sc *SessionClient) Redeem(token string, db sq.BaseRunner) (string, error) {
+	if sc.err != nil {
+		return "", sc.err
+	}
+	if !pat.ValidWith(token, RefreshTokenPrefix) {
+		return "", ErrorRefreshTokenInvalid
+	}
+
+	hash := pat.HashToken(token)
+	var sessionID string
+	var expires time.Time
+	var used bool
+	sel := sq.Select("[SessionID]", "[Expires]", "[Used]").From("[auth].[RefreshTokens]").Where(sq.Eq{"[Hash]": hash})
+	err := sel.RunWith(db).QueryRow().Scan(&sessionID, &expires, &used)
+	switch {
+	case err == sql.ErrNoRows:
+		return "", ErrorRefreshTokenInvalid
+	case err != nil:
+		log.Print(err)
+		sc.err = err
+		return "", err
+	case used:
+		return "", sc.revokeFamily(sessionID, db)
+	case time.Now().UTC().After(expires):
+		return "", ErrorRefreshTokenInvalid
+	}
+
+	// Of concurrent redemptions of the same token only one marks it as used; the others are reuse.
+	update := sq.Update("[auth].[RefreshTokens]").Set("[Used]", true).Where(sq.Eq{"[Hash]": hash, "[Used]": false})
+	res, err := update.RunWith(db).Exec()
+	if err != nil {
+		log.Print(err)
+		sc.err = err
+		return "", err
+	}
+	if cnt, err := res.RowsAffected(); err != nil || cnt != 1 {
+		return "", sc.revokeFamily(sessionID, db)
+	}
+	return sessionID, nil
+}
+
+// revokeFamily deletes the session with ID sessionID, and with it all of its refresh tokens, because one of them
+// was reused. It returns ErrorRefreshTokenReused unless the deletion fails.
+func (sc *SessionClient) revokeFamily(sessionID string, db sq.BaseRunner) error {
+	if _, err := sq.Delete("[auth].[Sessions]").Where(sq.Eq{"[SessionID]": sessionID}).RunWith(db).Exec(); err != nil {
+		log.Print(err)
+		sc.err = err
+		return err
+	}
+	return ErrorRefreshTokenReused
+}
+
+// Err returns the error status of a SessionClient instance.
+func (sc *SessionClient) Err() error {
+	return sc.err
+}
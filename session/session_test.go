@@ -0,0 +1,257 @@
+package session
+
+import (
+	"database/sql"
+	"github.com/penutty/authservice/pat"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/DATA-DOG/go-sqlmock.v1"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+var (
+	tOwner      = "01890a5d-ac96-774b-bcce-b302099a8057"
+	tSessionID  = "01890a5d-ac96-774b-bcce-b302099a8060"
+	sessionRows = []string{"SessionID", "ID", "DeviceName", "UserAgent", "IP", "Created", "LastSeen"}
+)
+
+func Test_Create(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("An error occured when opening a stub database connection. ERROR: %v\n", err)
+	}
+	defer db.Close()
+
+	agent := strings.Repeat("a", UserAgentMaxLength+10)
+	mock.ExpectExec(`INSERT INTO \[auth]\.\[Sessions] \(\[SessionID],\[ID],\[DeviceName],\[UserAgent],\[IP],\[Created],\[LastSeen]\) VALUES \(\?,\?,\?,\?,\?,\?,\?\)`).
+		WithArgs(sqlmock.AnyArg(), tOwner, "laptop", agent[:UserAgentMaxLength], "192.0.2.1", sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	sc := new(SessionClient)
+	s := &Session{Owner: tOwner, DeviceName: "laptop", UserAgent: agent, IP: "192.0.2.1"}
+	sc.Create(s, db)
+	assert.Nil(t, sc.Err())
+	assert.Len(t, s.SessionID, 36)
+	assert.Equal(t, s.Created, s.LastSeen)
+	assert.Len(t, s.UserAgent, UserAgentMaxLength)
+
+	if err = mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Expectations were not met. ERROR: %v\n", err)
+	}
+}
+
+func Test_List(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("An error occured when opening a stub database connection. ERROR: %v\n", err)
+	}
+	defer db.Close()
+
+	since := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	created := since.AddDate(0, 0, 1)
+	mock.ExpectQuery(`SELECT \[SessionID], \[ID], \[DeviceName], \[UserAgent], \[IP], \[Created], \[LastSeen] FROM \[auth]\.\[Sessions] WHERE \[ID] = \? AND \[Created] > \? ORDER BY \[LastSeen] DESC`).
+		WithArgs(tOwner, since).
+		WillReturnRows(sqlmock.NewRows(sessionRows).AddRow(tSessionID, tOwner, "laptop", "curl/8.0", "192.0.2.1", created, created))
+
+	sc := new(SessionClient)
+	expected := []*Session{&Session{tSessionID, tOwner, "laptop", "curl/8.0", "192.0.2.1", created, created}}
+	assert.Equal(t, expected, sc.List(tOwner, since, db))
+	assert.Nil(t, sc.Err())
+
+	if err = mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Expectations were not met. ERROR: %v\n", err)
+	}
+}
+
+func Test_Fetch(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("An error occured when opening a stub database connection. ERROR: %v\n", err)
+	}
+	defer db.Close()
+
+	created := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	cases := []bool{true, false}
+	for i, found := range cases {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			rows := sqlmock.NewRows(sessionRows)
+			if found {
+				rows.AddRow(tSessionID, tOwner, "", "", "192.0.2.1", created, created)
+			}
+			mock.ExpectQuery(`SELECT \[SessionID], \[ID], \[DeviceName], \[UserAgent], \[IP], \[Created], \[LastSeen] FROM \[auth]\.\[Sessions] WHERE \[SessionID] = \?`).
+				WithArgs(tSessionID).
+				WillReturnRows(rows)
+
+			sc := new(SessionClient)
+			s := sc.Fetch(tSessionID, db)
+			assert.Nil(t, sc.Err())
+			if found {
+				assert.Equal(t, tOwner, s.Owner)
+			} else {
+				assert.Nil(t, s)
+			}
+
+			if err = mock.ExpectationsWereMet(); err != nil {
+				t.Errorf("Expectations were not met. ERROR: %v\n", err)
+			}
+		})
+	}
+}
+
+func Test_Revoke(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("An error occured when opening a stub database connection. ERROR: %v\n", err)
+	}
+	defer db.Close()
+
+	cases := []struct {
+		affected int64
+		err      error
+	}{
+		{1, nil},
+		{0, ErrorSessionNotFound},
+	}
+	for i, c := range cases {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			mock.ExpectExec(`DELETE FROM \[auth]\.\[Sessions] WHERE \[ID] = \? AND \[SessionID] = \?`).
+				WithArgs(tOwner, tSessionID).
+				WillReturnResult(sqlmock.NewResult(0, c.affected))
+
+			sc := new(SessionClient)
+			assert.Equal(t, c.err, sc.Revoke(tOwner, tSessionID, db))
+			assert.Nil(t, sc.Err())
+
+			if err = mock.ExpectationsWereMet(); err != nil {
+				t.Errorf("Expectations were not met. ERROR: %v\n", err)
+			}
+		})
+	}
+}
+
+func Test_RevokeAll(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("An error occured when opening a stub database connection. ERROR: %v\n", err)
+	}
+	defer db.Close()
+
+	mock.ExpectExec(`DELETE FROM \[auth]\.\[Sessions] WHERE \[ID] = \?`).
+		WithArgs(tOwner).
+		WillReturnResult(sqlmock.NewResult(0, 2))
+
+	sc := new(SessionClient)
+	sc.RevokeAll(tOwner, db)
+	assert.Nil(t, sc.Err())
+
+	if err = mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Expectations were not met. ERROR: %v\n", err)
+	}
+}
+
+func Test_Touch(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("An error occured when opening a stub database connection. ERROR: %v\n", err)
+	}
+	defer db.Close()
+
+	mock.ExpectExec(`UPDATE \[auth]\.\[Sessions] SET \[LastSeen] = \? WHERE \[SessionID] = \?`).
+		WithArgs(sqlmock.AnyArg(), tSessionID).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	sc := new(SessionClient)
+	sc.Touch(tSessionID, db)
+	assert.Nil(t, sc.Err())
+
+	if err = mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Expectations were not met. ERROR: %v\n", err)
+	}
+}
+
+func Test_Issue(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("An error occured when opening a stub database connection. ERROR: %v\n", err)
+	}
+	defer db.Close()
+
+	expires := time.Date(2026, 2, 1, 0, 0, 0, 0, time.UTC)
+	mock.ExpectExec(`INSERT INTO \[auth]\.\[RefreshTokens] \(\[Hash],\[SessionID],\[Expires],\[Used]\) VALUES \(\?,\?,\?,\?\)`).
+		WithArgs(sqlmock.AnyArg(), tSessionID, expires, false).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	sc := new(SessionClient)
+	token := sc.Issue(tSessionID, expires, db)
+	assert.Nil(t, sc.Err())
+	assert.True(t, pat.ValidWith(token, RefreshTokenPrefix))
+
+	if err = mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Expectations were not met. ERROR: %v\n", err)
+	}
+}
+
+func Test_Redeem(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("An error occured when opening a stub database connection. ERROR: %v\n", err)
+	}
+	defer db.Close()
+
+	token, err := pat.GenerateWith(RefreshTokenPrefix)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hash := pat.HashToken(token)
+	future, past := time.Now().UTC().Add(time.Hour), time.Now().UTC().Add(-time.Hour)
+	selectToken := func(used bool, expires time.Time) {
+		mock.ExpectQuery(`SELECT \[SessionID], \[Expires], \[Used] FROM \[auth]\.\[RefreshTokens] WHERE \[Hash] = \?`).
+			WithArgs(hash).
+			WillReturnRows(sqlmock.NewRows([]string{"SessionID", "Expires", "Used"}).AddRow(tSessionID, expires, used))
+	}
+	markUsed := func(affected int64) {
+		mock.ExpectExec(`UPDATE \[auth]\.\[RefreshTokens] SET \[Used] = \? WHERE \[Hash] = \? AND \[Used] = \?`).
+			WithArgs(true, hash, false).
+			WillReturnResult(sqlmock.NewResult(0, affected))
+	}
+	revoke := func() {
+		mock.ExpectExec(`DELETE FROM \[auth]\.\[Sessions] WHERE \[SessionID] = \?`).
+			WithArgs(tSessionID).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+	}
+
+	cases := []struct {
+		token     string
+		expect    func()
+		sessionID string
+		err       error
+	}{
+		{"asr_malformed", func() {}, "", ErrorRefreshTokenInvalid},
+		{token, func() {
+			mock.ExpectQuery(`SELECT \[SessionID], \[Expires], \[Used] FROM \[auth]\.\[RefreshTokens] WHERE \[Hash] = \?`).
+				WithArgs(hash).
+				WillReturnError(sql.ErrNoRows)
+		}, "", ErrorRefreshTokenInvalid},
+		{token, func() { selectToken(false, past) }, "", ErrorRefreshTokenInvalid},
+		{token, func() { selectToken(false, future); markUsed(1) }, tSessionID, nil},
+		{token, func() { selectToken(true, future); revoke() }, "", ErrorRefreshTokenReused},
+		{token, func() { selectToken(false, future); markUsed(0); revoke() }, "", ErrorRefreshTokenReused},
+	}
+	for i, c := range cases {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			c.expect()
+
+			sc := new(SessionClient)
+			sessionID, err := sc.Redeem(c.token, db)
+			assert.Equal(t, c.err, err)
+			assert.Equal(t, c.sessionID, sessionID)
+			assert.Nil(t, sc.Err())
+
+			if err = mock.ExpectationsWereMet(); err != nil {
+				t.Errorf("Expectations were not met. ERROR: %v\n", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/dgrijalva/jwt-go"
+	"github.com/penutty/authservice/audit"
+	"github.com/penutty/authservice/env"
+	"github.com/penutty/authservice/session"
+	"github.com/penutty/authservice/user"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// GrantTypeRefreshToken is the OAuth 2.0 refresh token grant of TokenEndpoint.
+const GrantTypeRefreshToken = "refresh_token"
+
+var (
+	// AccessTokenLifetime is how long access tokens are valid.
+	AccessTokenLifetime = env.PositiveDuration("AccessTokenLifetime", 7*24*time.Hour)
+	// RefreshTokenLifetime is how long after a login the refresh tokens of its session can be redeemed. Redeeming
+	// them does not extend it, so the last access token of a session expires AccessTokenLifetime later.
+	RefreshTokenLifetime = env.PositiveDuration("RefreshTokenLifetime", 30*24*time.Hour)
+	// SessionSeenInterval is how often using a token of a session updates the time it was last seen.
+	SessionSeenInterval = time.Minute
+
+	ErrorSessionRevoked      = errors.New("Session of the token was revoked.")
+	ErrorRefreshTokenMissing = errors.New("Form value \"refresh_token\" is required.")
+)
+
+// sessionResource is a session returned by SessionsEndpoint.
+type sessionResource struct {
+	SessionID  string
+	DeviceName string
+	UserAgent  string
+	IP         string
+	Created    time.Time
+	LastSeen   time.Time
+}
+
+func newSessionResource(s *session.Session) *sessionResource {
+	return &sessionResource{s.SessionID, s.DeviceName, s.UserAgent, s.IP, s.Created, s.LastSeen}
+}
+
+// startSession records the login of u in request r as a new session and returns its ID and its first refresh
+// token. Clients may name the device in the "Device-Name" header.
+func (a *app) startSession(u *user.User, r *http.Request) (string, string, error) {
+	s := &session.Session{Owner: u.ID(), DeviceName: r.Header.Get("Device-Name"), UserAgent: r.UserAgent(), IP: clientIP(r)}
+	a.ss.Create(s, user.AuthDB())
+	refresh := a.ss.Issue(s.SessionID, s.Created.Add(RefreshTokenLifetime), user.AuthDB())
+	if err := a.ss.Err(); err != nil {
+		return "", "", err
+	}
+	return s.SessionID, refresh, nil
+}
+
+// refreshGrant implements the OAuth 2.0 refresh token grant. The refresh token is redeemed for an access token
+// in the same session and the next refresh token of the session; see package session for how reuse of a refresh
+// token revokes the session. Sessions started before the tokens of their user were revoked cannot be refreshed.
+func (a *app) refreshGrant(r *http.Request) (*tokenResponse, error) {
+	token := r.PostForm.Get("refresh_token")
+	if token == "" {
+		return nil, ErrorRefreshTokenMissing
+	}
+
+	sid, err := a.ss.Redeem(token, user.AuthDB())
+	if err != nil {
+		return nil, err
+	}
+	s := a.ss.Fetch(sid, user.AuthDB())
+	if err := a.ss.Err(); err != nil {
+		return nil, err
+	}
+	if s == nil {
+		return nil, session.ErrorRefreshTokenInvalid
+	}
+	u := a.c.FetchByID(s.Owner, user.AuthDB())
+	if err := a.c.Err(); err != nil {
+		return nil, err
+	}
+	if err := checkTenant(r, u); err != nil {
+		return nil, err
+	}
+	revoked := a.c.Revoked(u.UserID(), user.AuthDB())
+	if err := a.c.Err(); err != nil {
+		return nil, err
+	}
+	if s.Created.Before(revoked) {
+		return nil, ErrorTokenRevoked
+	}
+
+	claims, roles, permissions, err := a.accessClaims(u)
+	if err != nil {
+		return nil, err
+	}
+	claims["sid"] = sid
+	access, err := generateJwt(u.ID(), roles, permissions, claims)
+	if err != nil {
+		return nil, err
+	}
+	refresh := a.ss.Issue(sid, s.Created.Add(RefreshTokenLifetime), user.AuthDB())
+	a.ss.Touch(sid, user.AuthDB())
+	if err := a.ss.Err(); err != nil {
+		return nil, err
+	}
+
+	return &tokenResponse{
+		AccessToken:  access,
+		TokenType:    "Bearer",
+		ExpiresIn:    int64(AccessTokenLifetime.Seconds()),
+		RefreshToken: refresh,
+	}, nil
+}
+
+// checkSession returns ErrorSessionRevoked unless the session in the "sid" claim of claims, issued to u, is still
+// active. Claims without a session, e.g. those of tokens issued before sessions were recorded, are accepted.
+// The session is marked as seen at most once per SessionSeenInterval.
+func (a *app) checkSession(u *user.User, claims jwt.MapClaims) error {
+	sid, _ := claims["sid"].(string)
+	if sid == "" {
+		return nil
+	}
+	s := a.ss.Fetch(sid, user.AuthDB())
+	if err := a.ss.Err(); err != nil {
+		return err
+	}
+	if s == nil || s.Owner != u.ID() {
+		return ErrorSessionRevoked
+	}
+
+	if time.Since(s.LastSeen) > SessionSeenInterval {
+		a.ss.Touch(sid, user.AuthDB())
+		if err := a.ss.Err(); err != nil {
+			logger(Error).Println(err)
+		}
+	}
+	return nil
+}
+
+func (a *app) sessionsHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		res, err := a.getSessions(r)
+		if err != nil {
+			genErrorHandler(w, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(res); err != nil {
+			logger(Error).Println(err)
+		}
+	case http.MethodDelete:
+		if err := a.deleteSession(r); err != nil {
+			genErrorHandler(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		logger(Error).Println(ErrorMethodNotImplemented)
+		http.Error(w, http.StatusText(http.StatusNotImplemented), http.StatusNotImplemented)
+	}
+}
+
+// getSessions returns the active sessions of the caller, or as an administrator of any user, most recently seen
+// first. Sessions started more than RefreshTokenLifetime and AccessTokenLifetime ago have no valid tokens left
+// and are not listed.
+func (a *app) getSessions(r *http.Request) ([]*sessionResource, error) {
+	_, u, err := a.targetUser(r, ScopeSessionsRead)
+	if err != nil {
+		return nil, err
+	}
+	sessions := a.ss.List(u.ID(), time.Now().UTC().Add(-RefreshTokenLifetime-AccessTokenLifetime), user.AuthDB())
+	if err := a.ss.Err(); err != nil {
+		return nil, err
+	}
+	res := make([]*sessionResource, 0, len(sessions))
+	for _, s := range sessions {
+		res = append(res, newSessionResource(s))
+	}
+	return res, nil
+}
+
+// deleteSession revokes the session whose ID follows SessionsEndpoint in the path of r, of the caller or as an
+// administrator of any user. Its refresh tokens are deleted with it, and Auth-Service refuses its access tokens,
+// including those exchanged for them, from then on. Services that verify access tokens offline do not see the
+// revocation and accept them until they expire, see AccessTokenLifetime, unless they introspect them.
+// The revocation is recorded in the audit log.
+func (a *app) deleteSession(r *http.Request) error {
+	caller, u, err := a.loginTargetUser(r)
+	if err != nil {
+		return err
+	}
+	sessionID := strings.TrimPrefix(r.URL.Path, SessionsEndpoint+"/")
+	if sessionID == r.URL.Path || sessionID == "" {
+		return session.ErrorSessionNotFound
+	}
+	if err := a.ss.Revoke(u.ID(), sessionID, user.AuthDB()); err != nil {
+		return err
+	}
+
+	a.au.Record(audit.NewEntry(caller.ID(), u.ID(), "session_revoke", sessionID), user.AuthDB())
+	if err := a.au.Err(); err != nil {
+		logger(Error).Println(err)
+	}
+	return nil
+}
@@ -0,0 +1,336 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	sq "github.com/Masterminds/squirrel"
+	"github.com/penutty/authservice/session"
+	"github.com/penutty/authservice/user"
+	"github.com/penutty/authservice/verification"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// MockSessionClient keeps sessions in memory by SessionID, and their refresh tokens by token. SessionIDs are
+// "sess-" and refresh tokens "refresh-" followed by a sequence number.
+type MockSessionClient struct {
+	err      error
+	next     int
+	sessions map[string]*session.Session
+	refresh  map[string]*mockRefreshToken
+}
+
+type mockRefreshToken struct {
+	sessionID string
+	expires   time.Time
+	used      bool
+}
+
+func NewMockSessionClient() *MockSessionClient {
+	return &MockSessionClient{sessions: make(map[string]*session.Session), refresh: make(map[string]*mockRefreshToken)}
+}
+
+func (m *MockSessionClient) Create(s *session.Session, db sq.BaseRunner) {
+	s.SessionID, s.Created = "sess-"+strconv.Itoa(m.next), time.Now().UTC()
+	s.LastSeen = s.Created
+	m.sessions[s.SessionID] = s
+	m.next++
+}
+
+func (m *MockSessionClient) List(owner string, since time.Time, db sq.BaseRunner) (sessions []*session.Session) {
+	for _, s := range m.sessions {
+		if s.Owner == owner && s.Created.After(since) {
+			sessions = append(sessions, s)
+		}
+	}
+	return
+}
+
+func (m *MockSessionClient) Fetch(sessionID string, db sq.BaseRunner) *session.Session {
+	if m.err != nil {
+		return nil
+	}
+	return m.sessions[sessionID]
+}
+
+func (m *MockSessionClient) Revoke(owner, sessionID string, db sq.BaseRunner) error {
+	if m.err != nil {
+		return m.err
+	}
+	if s, ok := m.sessions[sessionID]; !ok || s.Owner != owner {
+		return session.ErrorSessionNotFound
+	}
+	delete(m.sessions, sessionID)
+	return nil
+}
+
+func (m *MockSessionClient) RevokeAll(owner string, db sq.BaseRunner) {
+	for id, s := range m.sessions {
+		if s.Owner == owner {
+			delete(m.sessions, id)
+		}
+	}
+}
+
+func (m *MockSessionClient) Touch(sessionID string, db sq.BaseRunner) {
+	m.sessions[sessionID].LastSeen = time.Now().UTC()
+}
+
+func (m *MockSessionClient) Issue(sessionID string, expires time.Time, db sq.BaseRunner) string {
+	token := "refresh-" + strconv.Itoa(len(m.refresh))
+	m.refresh[token] = &mockRefreshToken{sessionID, expires, false}
+	return token
+}
+
+// Redeem follows SessionClient.Redeem. The refresh tokens of deleted sessions are invalid, as they are deleted with them.
+func (m *MockSessionClient) Redeem(token string, db sq.BaseRunner) (string, error) {
+	t, ok := m.refresh[token]
+	if !ok || m.sessions[t.sessionID] == nil || time.Now().After(t.expires) {
+		return "", session.ErrorRefreshTokenInvalid
+	}
+	if t.used {
+		delete(m.sessions, t.sessionID)
+		return "", session.ErrorRefreshTokenReused
+	}
+	t.used = true
+	return t.sessionID, nil
+}
+
+func (m *MockSessionClient) Err() error {
+	return m.err
+}
+
+// NewAuthRequest returns a login request from a named device.
+func NewAuthRequest() *http.Request {
+	r := httptest.NewRequest(http.MethodPost, AuthEndpoint, nil)
+	r.Header.Set("Device-Name", "laptop")
+	r.Header.Set("User-Agent", "curl/8.0")
+	return r
+}
+
+// newSessionApp returns an app where tUser has the session "sess-0" and tOtherUser the session "sess-1".
+func newSessionApp() (*app, *MockSessionClient) {
+	a, m, _ := newVerifyApp()
+	a.au = new(MockAuditClient)
+	ss := NewMockSessionClient()
+	a.ss = ss
+	for _, userID := range []string{tUser, tOtherUser} {
+		if _, _, err := a.accessToken(m.Fetch(userID, nil), NewAuthRequest()); err != nil {
+			panic(err)
+		}
+	}
+	return a, ss
+}
+
+func Test_sessionsHandler(t *testing.T) {
+	defer func(admins []string) { Administrators = admins }(Administrators)
+
+	type test struct {
+		req      *http.Request
+		admins   []string
+		code     int
+		sessions int
+		audit    int
+	}
+	cases := []*test{
+		&test{NewBearerRequest(http.MethodGet, SessionsEndpoint, nil), nil, http.StatusOK, 2, 0},
+		&test{NewBearerRequest(http.MethodGet, SessionsEndpoint+"?UserID="+tOtherUser, nil), nil, http.StatusForbidden, 2, 0},
+		&test{NewBearerRequest(http.MethodGet, SessionsEndpoint+"?UserID="+tOtherUser, nil), []string{tUser}, http.StatusOK, 2, 0},
+		&test{NewBearerRequest(http.MethodDelete, SessionsEndpoint+"/sess-0", nil), nil, http.StatusNoContent, 1, 1},
+		&test{NewBearerRequest(http.MethodDelete, SessionsEndpoint+"/sess-1", nil), nil, http.StatusNotFound, 2, 0},
+		&test{NewBearerRequest(http.MethodDelete, SessionsEndpoint+"/sess-1?UserID="+tOtherUser, nil), nil, http.StatusForbidden, 2, 0},
+		&test{NewBearerRequest(http.MethodDelete, SessionsEndpoint+"/sess-1?UserID="+tOtherUser, nil), []string{tUser}, http.StatusNoContent, 1, 1},
+		&test{NewBearerRequest(http.MethodDelete, SessionsEndpoint, nil), nil, http.StatusNotFound, 2, 0},
+		&test{httptest.NewRequest(http.MethodGet, SessionsEndpoint, nil), nil, http.StatusUnauthorized, 2, 0},
+		&test{NewBearerRequest(http.MethodPut, SessionsEndpoint, nil), nil, http.StatusNotImplemented, 2, 0},
+	}
+	for i, c := range cases {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			Administrators = administrators(c.admins...)
+			a, ss := newSessionApp()
+			au := a.au.(*MockAuditClient)
+			rec := httptest.NewRecorder()
+			a.sessionsHandler(rec, c.req)
+			assert.Equal(t, c.code, rec.Code)
+			assert.Len(t, ss.sessions, c.sessions)
+			assert.Len(t, au.entries, c.audit)
+			if c.audit > 0 {
+				assert.Equal(t, "session_revoke", au.entries[0].Action)
+			}
+
+			if c.code == http.StatusOK {
+				var res []*sessionResource
+				assert.Nil(t, json.NewDecoder(rec.Body).Decode(&res))
+				assert.Len(t, res, 1)
+				assert.Equal(t, "laptop", res[0].DeviceName)
+				assert.Equal(t, "curl/8.0", res[0].UserAgent)
+				assert.Equal(t, "192.0.2.1", res[0].IP)
+			}
+		})
+	}
+}
+
+func Test_getSessions_expired(t *testing.T) {
+	a, ss := newSessionApp()
+
+	// A session whose access tokens expired is listed while it can still be refreshed.
+	ss.sessions["sess-0"].Created = time.Now().UTC().Add(-AccessTokenLifetime - time.Minute)
+	res, err := a.getSessions(NewBearerRequest(http.MethodGet, SessionsEndpoint, nil))
+	assert.Nil(t, err)
+	assert.Len(t, res, 1)
+
+	ss.sessions["sess-0"].Created = time.Now().UTC().Add(-RefreshTokenLifetime - AccessTokenLifetime - time.Minute)
+	res, err = a.getSessions(NewBearerRequest(http.MethodGet, SessionsEndpoint, nil))
+	assert.Nil(t, err)
+	assert.Empty(t, res)
+}
+
+func NewRefreshRequest(token string) *http.Request {
+	return NewExchangeRequest("", "", url.Values{"grant_type": {GrantTypeRefreshToken}, "refresh_token": {token}})
+}
+
+func Test_refreshGrant(t *testing.T) {
+	a, ss := newSessionApp()
+	refresh := func(token string) (*httptest.ResponseRecorder, *tokenResponse) {
+		rec := httptest.NewRecorder()
+		a.tokenHandler(rec, NewRefreshRequest(token))
+		resp := new(tokenResponse)
+		if rec.Code == http.StatusOK {
+			if err := json.NewDecoder(rec.Body).Decode(resp); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return rec, resp
+	}
+
+	// The refresh token issued by the login of sess-0 is redeemed for a token of the same session and the next
+	// refresh token, and the refresh is seen as activity of the session.
+	ss.sessions["sess-0"].LastSeen = time.Now().UTC().Add(-time.Hour)
+	rec, resp := refresh("refresh-0")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "Bearer", resp.TokenType)
+	assert.Equal(t, int64(AccessTokenLifetime.Seconds()), resp.ExpiresIn)
+	claims, err := verification.ParseAudience(resp.AccessToken, "Moment-Service")
+	assert.Nil(t, err)
+	assert.Equal(t, tID, claims["sub"])
+	assert.Equal(t, "sess-0", claims["sid"])
+	assert.Equal(t, "refresh-2", resp.RefreshToken)
+	assert.Equal(t, "sess-0", ss.refresh["refresh-2"].sessionID)
+	assert.WithinDuration(t, time.Now().UTC(), ss.sessions["sess-0"].LastSeen, time.Second)
+
+	rec, resp = refresh("refresh-2")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "refresh-3", resp.RefreshToken)
+
+	// Presenting a redeemed refresh token again revokes the session: the latest refresh token of the family and
+	// the access tokens of the session are refused, while other sessions are untouched.
+	rec, _ = refresh("refresh-0")
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	assert.JSONEq(t, `{"error": "invalid_grant"}`, rec.Body.String())
+	assert.Nil(t, ss.sessions["sess-0"])
+	rec, _ = refresh("refresh-3")
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	_, err = a.subject(claims)
+	assert.Equal(t, ErrorSessionRevoked, err)
+	assert.NotNil(t, ss.sessions["sess-1"])
+	rec, _ = refresh("refresh-1")
+	assert.Equal(t, http.StatusOK, rec.Code)
+}
+
+func Test_refreshGrant_refused(t *testing.T) {
+	type test struct {
+		token string
+		setup func(*app, *MockSessionClient)
+		code  int
+		oauth string
+	}
+	cases := []*test{
+		&test{"", func(*app, *MockSessionClient) {}, http.StatusBadRequest, "invalid_request"},
+		&test{"refresh-9", func(*app, *MockSessionClient) {}, http.StatusBadRequest, "invalid_grant"},
+		// Revoking the session deletes its refresh tokens.
+		&test{"refresh-0", func(a *app, ss *MockSessionClient) { ss.Revoke(tID, "sess-0", nil) }, http.StatusBadRequest, "invalid_grant"},
+		&test{"refresh-0", func(a *app, ss *MockSessionClient) {
+			ss.refresh["refresh-0"].expires = time.Now().UTC().Add(-time.Minute)
+		}, http.StatusBadRequest, "invalid_grant"},
+		// Sessions started before the tokens of the user were revoked, e.g. by a password change, are over.
+		&test{"refresh-0", func(a *app, ss *MockSessionClient) { a.c.RevokeTokens(tUser, nil) }, http.StatusBadRequest, "invalid_grant"},
+		&test{"refresh-0", func(a *app, ss *MockSessionClient) {
+			a.c.ChangeStatus(tUser, user.StatusSuspended, "", time.Time{}, nil)
+		}, http.StatusBadRequest, "invalid_grant"},
+	}
+	for i, c := range cases {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			a, ss := newSessionApp()
+			c.setup(a, ss)
+			rec := httptest.NewRecorder()
+			a.tokenHandler(rec, NewRefreshRequest(c.token))
+			assert.Equal(t, c.code, rec.Code)
+			assert.JSONEq(t, fmt.Sprintf("{\"error\": \"%s\"}", c.oauth), rec.Body.String())
+		})
+	}
+}
+
+func Test_accessToken_session(t *testing.T) {
+	a, ss := newSessionApp()
+	token, _, err := a.accessToken(a.c.Fetch(tUser, nil), NewAuthRequest())
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims, err := verification.ParseAudience(token, "Moment-Service")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "sess-2", claims["sid"])
+
+	bearer := func() *http.Request {
+		r := httptest.NewRequest(http.MethodGet, SessionsEndpoint, nil)
+		r.Header.Set("Authorization", "Bearer "+token)
+		return r
+	}
+	ss.sessions["sess-2"].LastSeen = time.Now().UTC().Add(-time.Hour)
+	_, err = a.authenticate(bearer(), ScopeUserRead)
+	assert.Nil(t, err)
+	assert.WithinDuration(t, time.Now().UTC(), ss.sessions["sess-2"].LastSeen, time.Second)
+
+	// A session cannot be used by another user.
+	ss.sessions["sess-2"].Owner = "id-" + tOtherUser
+	_, err = a.authenticate(bearer(), ScopeUserRead)
+	assert.Equal(t, ErrorBearerTokenInvalid, err)
+	ss.sessions["sess-2"].Owner = tID
+
+	// Once the session is revoked its tokens, and those exchanged for them, are refused.
+	a.x = new(MockExchangeClient)
+	rec := httptest.NewRecorder()
+	a.tokenHandler(rec, NewExchangeRequest(tClientID, tSecret, NewExchangeForm(token, tAudience, "")))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	resp := new(tokenResponse)
+	if err := json.NewDecoder(rec.Body).Decode(resp); err != nil {
+		t.Fatal(err)
+	}
+	exchanged, err := verification.ParseAudience(resp.AccessToken, tAudience)
+	assert.Nil(t, err)
+	assert.Equal(t, "sess-2", exchanged["sid"])
+
+	rec = httptest.NewRecorder()
+	a.sessionsHandler(rec, NewBearerRequest(http.MethodDelete, SessionsEndpoint+"/sess-2", nil))
+	assert.Equal(t, http.StatusNoContent, rec.Code)
+
+	_, err = a.authenticate(bearer(), ScopeUserRead)
+	assert.Equal(t, ErrorBearerTokenInvalid, err)
+	_, err = a.subject(exchanged)
+	assert.Equal(t, ErrorSessionRevoked, err)
+
+	// Refusing the revoked session does not keep the user from logging in again.
+	_, _, err = a.accessToken(a.c.Fetch(tUser, nil), NewAuthRequest())
+	assert.Nil(t, err)
+	assert.Nil(t, ss.Err())
+
+	rec = httptest.NewRecorder()
+	a.tokenHandler(rec, NewExchangeRequest(tClientID, tSecret, NewExchangeForm(token, tAudience, "")))
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+}
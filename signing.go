@@ -0,0 +1,214 @@
+package main
+
+import (
+	"encoding/json"
+	"github.com/penutty/authservice/audit"
+	"github.com/penutty/authservice/signing"
+	"github.com/penutty/authservice/user"
+	"github.com/penutty/authservice/verification"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// signingKeyResource is the metadata of a signing key returned by ServiceAccountSigningKeysEndpoint.
+// Secret is only set in the response creating the key; Auth-Service cannot show it again.
+type signingKeyResource struct {
+	KeyID    string
+	Created  time.Time
+	Expires  *time.Time `json:",omitempty"`
+	LastUsed *time.Time `json:",omitempty"`
+	Secret   string     `json:",omitempty"`
+}
+
+func newSigningKeyResource(k *signing.Key) *signingKeyResource {
+	res := &signingKeyResource{KeyID: k.KeyID, Created: k.Created}
+	if !k.Expires.IsZero() {
+		res.Expires = &k.Expires
+	}
+	if !k.LastUsed.IsZero() {
+		res.LastUsed = &k.LastUsed
+	}
+	return res
+}
+
+func (a *app) serviceAccountSigningKeysHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		res, err := a.getSigningKeys(r)
+		if err != nil {
+			genErrorHandler(w, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(res); err != nil {
+			logger(Error).Println(err)
+		}
+	case http.MethodPost:
+		res, err := a.postSigningKey(r)
+		if err != nil {
+			genErrorHandler(w, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		w.WriteHeader(http.StatusCreated)
+		if err := json.NewEncoder(w).Encode(res); err != nil {
+			logger(Error).Println(err)
+		}
+	case http.MethodDelete:
+		if err := a.deleteSigningKey(r); err != nil {
+			genErrorHandler(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		logger(Error).Println(ErrorMethodNotImplemented)
+		http.Error(w, http.StatusText(http.StatusNotImplemented), http.StatusNotImplemented)
+	}
+}
+
+// getSigningKeys returns the metadata of the signing keys of the service account in the "ID" query parameter.
+func (a *app) getSigningKeys(r *http.Request) ([]*signingKeyResource, error) {
+	_, acct, err := a.serviceAccount(r, r.URL.Query().Get("ID"))
+	if err != nil {
+		return nil, err
+	}
+	keys := a.sk.List(acct.ID, user.AuthDB())
+	if err := a.sk.Err(); err != nil {
+		return nil, err
+	}
+	res := make([]*signingKeyResource, 0, len(keys))
+	for _, k := range keys {
+		res = append(res, newSigningKeyResource(k))
+	}
+	return res, nil
+}
+
+// postSigningKey creates a signing key of the service account with the ID in the body, expiring at the optional
+// Expires in the body. Its secret is returned once. The creation is recorded in the audit log.
+func (a *app) postSigningKey(r *http.Request) (*signingKeyResource, error) {
+	type body struct {
+		ID      string
+		Expires time.Time
+	}
+	b := new(body)
+	if err := json.NewDecoder(r.Body).Decode(b); err != nil {
+		return nil, err
+	}
+	caller, acct, err := a.serviceAccount(r, b.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	k := &signing.Key{Owner: acct.ID, Expires: b.Expires.UTC()}
+	secret := a.sk.Create(k, user.AuthDB())
+	if err := a.sk.Err(); err != nil {
+		return nil, err
+	}
+
+	a.au.Record(audit.NewEntry(caller.ID(), acct.ID, "signing_key_create", k.KeyID), user.AuthDB())
+	if err := a.au.Err(); err != nil {
+		logger(Error).Println(err)
+	}
+	res := newSigningKeyResource(k)
+	res.Secret = secret
+	return res, nil
+}
+
+// deleteSigningKey revokes the signing key in the "KeyID" query parameter of the service account in the "ID"
+// query parameter. The revocation is recorded in the audit log.
+func (a *app) deleteSigningKey(r *http.Request) error {
+	caller, acct, err := a.serviceAccount(r, r.URL.Query().Get("ID"))
+	if err != nil {
+		return err
+	}
+	keyID := r.URL.Query().Get("KeyID")
+	a.sk.Revoke(acct.ID, keyID, user.AuthDB())
+	if err := a.sk.Err(); err != nil {
+		return err
+	}
+
+	a.au.Record(audit.NewEntry(caller.ID(), acct.ID, "signing_key_revoke", keyID), user.AuthDB())
+	if err := a.au.Err(); err != nil {
+		logger(Error).Println(err)
+	}
+	return nil
+}
+
+// signatureVerificationHandler checks signatures of requests signed by service accounts for services using
+// verification.SignatureVerifier, which enforces the replay window and nonces before asking. Callers authenticate
+// as exchange clients with HTTP Basic authentication and only learn about service accounts of their tenant.
+func (a *app) signatureVerificationHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		logger(Error).Println(ErrorMethodNotImplemented)
+		http.Error(w, http.StatusText(http.StatusNotImplemented), http.StatusNotImplemented)
+		return
+	}
+
+	res, err := a.postSignatureVerification(r)
+	if err != nil {
+		tokenErrorHandler(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	if err := json.NewEncoder(w).Encode(res); err != nil {
+		logger(Error).Println(err)
+	}
+}
+
+// postSignatureVerification returns the introspection response about the service account whose signing key made
+// the signature in the body. Invalid signatures, strings to sign outside verification.ReplayWindow, expired keys
+// and keys of disabled service accounts or of another tenant than the client are inactive.
+func (a *app) postSignatureVerification(r *http.Request) (*verification.Introspection, error) {
+	client, err := a.authenticateClient(r)
+	if err != nil {
+		return nil, err
+	}
+	c := new(verification.SignatureCheck)
+	if err := json.NewDecoder(r.Body).Decode(c); err != nil {
+		return nil, err
+	}
+
+	res, err := a.verifySignature(c)
+	if err != nil {
+		logger(Warn).Println(err)
+		return &verification.Introspection{}, nil
+	}
+	if res.Tenant != client.TenantID() {
+		logger(Warn).Println(ErrorTenantMismatch)
+		return &verification.Introspection{}, nil
+	}
+	return res, nil
+}
+
+// verifySignature returns the introspection response about the service account that made the signature of c.
+func (a *app) verifySignature(c *verification.SignatureCheck) (*verification.Introspection, error) {
+	ts, err := signing.Timestamp(c.StringToSign)
+	if err != nil {
+		return nil, err
+	}
+	if skew := time.Since(ts); skew > verification.ReplayWindow || skew < -verification.ReplayWindow {
+		return nil, verification.ErrorTimestampSkewed
+	}
+	k, err := a.sk.Verify(c.KeyID, c.StringToSign, c.Signature, user.AuthDB())
+	if err != nil {
+		return nil, err
+	}
+	acct, err := a.activeServiceAccount(k.Owner)
+	if err != nil {
+		return nil, err
+	}
+	return &verification.Introspection{
+		Active:    true,
+		Scope:     strings.Join(acct.Scopes, " "),
+		ClientID:  acct.ID,
+		Username:  acct.Name,
+		TokenType: verification.TokenTypeSignature,
+		Iat:       ts.Unix(),
+		Sub:       acct.ID,
+		Iss:       verification.IssuerFor(acct.TenantID),
+		Tenant:    acct.TenantID,
+	}, nil
+}
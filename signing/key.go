@@ -0,0 +1,226 @@
+package signing
+
+import (
+	"database/sql"
+	"errors"
+	sq "github.com/Masterminds/squirrel"
+	"github.com/penutty/authservice/internal/seal"
+	"github.com/penutty/authservice/pat"
+	"github.com/penutty/authservice/user"
+	"log"
+	"os"
+	"time"
+)
+
+// SecretPrefix starts every signing secret. Secrets are generated like personal access tokens, see package pat,
+// so that secret scanners recognize them.
+const SecretPrefix = "ahs_"
+
+// SecretKeyEnv is the environment variable SecretKey is read from.
+const SecretKeyEnv = "SigningSecretKey"
+
+var (
+	// SecretKey is the base64 encoded AES-256 key the secrets of signing keys are encrypted with at rest.
+	SecretKey = os.Getenv(SecretKeyEnv)
+
+	ErrorExpiresInvalid = errors.New("Expires must be in the future.")
+	ErrorKeyNotFound    = errors.New("Signing key does not exist.")
+)
+
+// Key is the metadata of a signing key of the service account with ID Owner. Expires and LastUsed are zero
+// if the key does not expire or was never used.
+type Key struct {
+	KeyID    string
+	Owner    string
+	Created  time.Time
+	Expires  time.Time
+	LastUsed time.Time
+}
+
+// Check returns an error if k is invalid.
+func (k *Key) Check() error {
+	if !k.Expires.IsZero() && !k.Expires.After(time.Now().UTC()) {
+		return ErrorExpiresInvalid
+	}
+	return nil
+}
+
+type Client interface {
+	Creater
+	Lister
+	Revoker
+	Verifier
+	Err() error
+}
+
+type Creater interface {
+	Create(*Key, sq.BaseRunner) string
+}
+
+type Lister interface {
+	List(string, sq.BaseRunner) []*Key
+}
+
+type Revoker interface {
+	Revoke(string, string, sq.BaseRunner)
+}
+
+type Verifier interface {
+	Verify(string, string, string, sq.BaseRunner) (*Key, error)
+}
+
+type KeyClient struct {
+	err error
+}
+
+// Create inserts k into the auth.SigningKeys table in db with a new secret, which is returned and cannot be
+// retrieved later. k is given a KeyID and its creation time. The secret is encrypted with SecretKey.
+func (kc *KeyClient) Create(k *Key, db sq.BaseRunner) string {
+	if kc.err != nil {
+		return ""
+	}
+	if err := k.Check(); err != nil {
+		kc.err = err
+		return ""
+	}
+	secret, err := pat.GenerateWith(SecretPrefix)
+	if err != nil {
+		kc.err = err
+		return ""
+	}
+	if k.KeyID, err = user.NewID(); err != nil {
+		kc.err = err
+		return ""
+	}
+	sealed, err := seal.Seal(SecretKey, SecretKeyEnv, []byte(secret), []byte(k.KeyID))
+	if err != nil {
+		kc.err = err
+		return ""
+	}
+	k.Created = time.Now().UTC()
+
+	var expires *time.Time
+	if !k.Expires.IsZero() {
+		expires = &k.Expires
+	}
+	insert := sq.Insert("[auth].[SigningKeys]").
+		Columns("[KeyID]", "[ID]", "[Secret]", "[Created]", "[Expires]").
+		Values(k.KeyID, k.Owner, sealed, k.Created, expires)
+	if _, err := insert.RunWith(db).Exec(); err != nil {
+		log.Print(err)
+		kc.err = err
+		return ""
+	}
+	return secret
+}
+
+var keyColumns = []string{"[KeyID]", "[ID]", "[Created]", "[Expires]", "[LastUsed]"}
+
+// scanKey reads a row of keyColumns followed by dest into a Key.
+func scanKey(row sq.RowScanner, dest ...interface{}) (*Key, error) {
+	k := new(Key)
+	var expires, lastUsed *time.Time
+	if err := row.Scan(append([]interface{}{&k.KeyID, &k.Owner, &k.Created, &expires, &lastUsed}, dest...)...); err != nil {
+		return nil, err
+	}
+	if expires != nil {
+		k.Expires = *expires
+	}
+	if lastUsed != nil {
+		k.LastUsed = *lastUsed
+	}
+	return k, nil
+}
+
+// List returns the signing keys of the service account with ID owner, newest first.
+func (kc *KeyClient) List(owner string, db sq.BaseRunner) (keys []*Key) {
+	if kc.err != nil {
+		return
+	}
+
+	sel := sq.Select(keyColumns...).From("[auth].[SigningKeys]").Where(sq.Eq{"[ID]": owner}).OrderBy("[Created] DESC")
+	rows, err := sel.RunWith(db).Query()
+	if err != nil {
+		log.Print(err)
+		kc.err = err
+		return
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		k, err := scanKey(rows)
+		if err != nil {
+			log.Print(err)
+			kc.err = err
+			return nil
+		}
+		keys = append(keys, k)
+	}
+	if err := rows.Err(); err != nil {
+		log.Print(err)
+		kc.err = err
+		return nil
+	}
+	return
+}
+
+// Revoke deletes the signing key with ID keyID of the service account with ID owner.
+func (kc *KeyClient) Revoke(owner, keyID string, db sq.BaseRunner) {
+	if kc.err != nil {
+		return
+	}
+
+	res, err := sq.Delete("[auth].[SigningKeys]").Where(sq.Eq{"[KeyID]": keyID, "[ID]": owner}).RunWith(db).Exec()
+	if err != nil {
+		log.Print(err)
+		kc.err = err
+		return
+	}
+	if cnt, err := res.RowsAffected(); err != nil || cnt != 1 {
+		kc.err = ErrorKeyNotFound
+	}
+}
+
+// Verify returns the signing key with ID keyID if signature is the signature of stringToSign with its secret
+// and it has not expired, recording that the key was used. It returns ErrorSignatureInvalid otherwise.
+func (kc *KeyClient) Verify(keyID, stringToSign, signature string, db sq.BaseRunner) (*Key, error) {
+	if kc.err != nil {
+		return nil, kc.err
+	}
+
+	var sealed []byte
+	sel := sq.Select(keyColumns...).Column("[Secret]").From("[auth].[SigningKeys]").Where(sq.Eq{"[KeyID]": keyID})
+	k, err := scanKey(sel.RunWith(db).QueryRow(), &sealed)
+	switch {
+	case err == sql.ErrNoRows:
+		return nil, ErrorSignatureInvalid
+	case err != nil:
+		log.Print(err)
+		kc.err = err
+		return nil, err
+	}
+	if !k.Expires.IsZero() && time.Now().UTC().After(k.Expires) {
+		return nil, ErrorSignatureInvalid
+	}
+	secret, err := seal.Open(SecretKey, SecretKeyEnv, sealed, []byte(k.KeyID))
+	if err != nil {
+		log.Print(err)
+		kc.err = err
+		return nil, err
+	}
+	if !Valid(string(secret), stringToSign, signature) {
+		return nil, ErrorSignatureInvalid
+	}
+
+	k.LastUsed = time.Now().UTC()
+	update := sq.Update("[auth].[SigningKeys]").Set("[LastUsed]", k.LastUsed).Where(sq.Eq{"[KeyID]": k.KeyID})
+	if _, err := update.RunWith(db).Exec(); err != nil {
+		log.Print(err)
+	}
+	return k, nil
+}
+
+// Err returns the error status of a KeyClient instance.
+func (kc *KeyClient) Err() error {
+	return kc.err
+}
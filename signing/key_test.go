@@ -0,0 +1,157 @@
+package signing
+
+import (
+	"encoding/base64"
+	"github.com/penutty/authservice/internal/seal"
+	"github.com/penutty/authservice/pat"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/DATA-DOG/go-sqlmock.v1"
+	"strconv"
+	"testing"
+	"time"
+)
+
+var (
+	tOwner  = "01890a5d-ac96-774b-bcce-b302099a8057"
+	keyRows = []string{"KeyID", "ID", "Created", "Expires", "LastUsed"}
+)
+
+func init() {
+	SecretKey = base64.StdEncoding.EncodeToString([]byte("0123456789abcdef0123456789abcdef"))
+}
+
+func Test_Create(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("An error occured when opening a stub database connection. ERROR: %v\n", err)
+	}
+	defer db.Close()
+
+	expires := time.Now().UTC().Add(time.Hour)
+	mock.ExpectExec(`INSERT INTO \[auth]\.\[SigningKeys] \(\[KeyID],\[ID],\[Secret],\[Created],\[Expires]\) VALUES \(\?,\?,\?,\?,\?\)`).
+		WithArgs(sqlmock.AnyArg(), tOwner, sqlmock.AnyArg(), sqlmock.AnyArg(), expires).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	kc := new(KeyClient)
+	k := &Key{Owner: tOwner, Expires: expires}
+	secret := kc.Create(k, db)
+	assert.Nil(t, kc.Err())
+	assert.True(t, pat.ValidWith(secret, SecretPrefix))
+	assert.Len(t, k.KeyID, 36)
+
+	kc = new(KeyClient)
+	assert.Empty(t, kc.Create(&Key{Owner: tOwner, Expires: time.Now().UTC().Add(-time.Minute)}, db))
+	assert.Equal(t, ErrorExpiresInvalid, kc.Err())
+
+	if err = mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Expectations were not met. ERROR: %v\n", err)
+	}
+}
+
+func Test_List(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("An error occured when opening a stub database connection. ERROR: %v\n", err)
+	}
+	defer db.Close()
+
+	created := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	mock.ExpectQuery(`SELECT \[KeyID], \[ID], \[Created], \[Expires], \[LastUsed] FROM \[auth]\.\[SigningKeys] WHERE \[ID] = \? ORDER BY \[Created] DESC`).
+		WithArgs(tOwner).
+		WillReturnRows(sqlmock.NewRows(keyRows).AddRow(tKeyID, tOwner, created, nil, created))
+
+	kc := new(KeyClient)
+	assert.Equal(t, []*Key{&Key{KeyID: tKeyID, Owner: tOwner, Created: created, LastUsed: created}}, kc.List(tOwner, db))
+	assert.Nil(t, kc.Err())
+
+	if err = mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Expectations were not met. ERROR: %v\n", err)
+	}
+}
+
+func Test_Revoke(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("An error occured when opening a stub database connection. ERROR: %v\n", err)
+	}
+	defer db.Close()
+
+	cases := []struct {
+		affected int64
+		err      error
+	}{
+		{1, nil},
+		{0, ErrorKeyNotFound},
+	}
+	for i, c := range cases {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			mock.ExpectExec(`DELETE FROM \[auth]\.\[SigningKeys] WHERE \[ID] = \? AND \[KeyID] = \?`).
+				WithArgs(tOwner, tKeyID).
+				WillReturnResult(sqlmock.NewResult(0, c.affected))
+
+			kc := new(KeyClient)
+			kc.Revoke(tOwner, tKeyID, db)
+			assert.Equal(t, c.err, kc.Err())
+
+			if err = mock.ExpectationsWereMet(); err != nil {
+				t.Errorf("Expectations were not met. ERROR: %v\n", err)
+			}
+		})
+	}
+}
+
+func Test_Verify(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("An error occured when opening a stub database connection. ERROR: %v\n", err)
+	}
+	defer db.Close()
+
+	sealed, err := seal.Seal(SecretKey, SecretKeyEnv, []byte(tSecret), []byte(tKeyID))
+	if err != nil {
+		t.Fatal(err)
+	}
+	str := Scheme + "\nGET\n/moments\n1700000000\nn0nce\ne3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+	created := time.Now().UTC().Add(-time.Hour)
+	sel := `SELECT \[KeyID], \[ID], \[Created], \[Expires], \[LastUsed], \[Secret] FROM \[auth]\.\[SigningKeys] WHERE \[KeyID] = \?`
+	rows := append(keyRows, "Secret")
+
+	cases := []struct {
+		signature string
+		expires   interface{}
+		found     bool
+		err       error
+	}{
+		{Sum(tSecret, str), nil, true, nil},
+		{Sum("other", str), nil, true, ErrorSignatureInvalid},
+		{Sum(tSecret, str), created, true, ErrorSignatureInvalid},
+		{Sum(tSecret, str), nil, false, ErrorSignatureInvalid},
+	}
+	for i, c := range cases {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			res := sqlmock.NewRows(rows)
+			if c.found {
+				res.AddRow(tKeyID, tOwner, created, c.expires, nil, sealed)
+			}
+			mock.ExpectQuery(sel).WithArgs(tKeyID).WillReturnRows(res)
+			if c.err == nil {
+				mock.ExpectExec(`UPDATE \[auth]\.\[SigningKeys] SET \[LastUsed] = \? WHERE \[KeyID] = \?`).
+					WithArgs(sqlmock.AnyArg(), tKeyID).
+					WillReturnResult(sqlmock.NewResult(0, 1))
+			}
+
+			kc := new(KeyClient)
+			k, err := kc.Verify(tKeyID, str, c.signature, db)
+			assert.Equal(t, c.err, err)
+			assert.Nil(t, kc.Err())
+			if c.err == nil {
+				assert.Equal(t, tOwner, k.Owner)
+				assert.False(t, k.LastUsed.IsZero())
+			}
+
+			if err = mock.ExpectationsWereMet(); err != nil {
+				t.Errorf("Expectations were not met. ERROR: %v\n", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,210 @@
+// Package signing implements HMAC request signing for callers that cannot handle bearer tokens, and reads and
+// writes the signing keys of service accounts in Auth-Db.
+//
+// A signing key is a public KeyID and a secret shared with Auth-Service. A request is signed with the
+// HMAC-SHA256 of its string to sign, see StringToSign, which covers the method, host, path and query, a
+// timestamp, a nonce and the SHA-256 digest of the body. The signature is sent in the Authorization header:
+//
+//	Authorization: MOMENT-HMAC-SHA256 KeyID=<KeyID>, Timestamp=<Unix time>, Nonce=<nonce>, Signature=<signature>
+//
+// Go clients sign requests with Signer or Transport. Services verify them with verification.SignatureVerifier,
+// which leaves checking the signature to Auth-Service as secrets never leave it.
+package signing
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Scheme is the authentication scheme of signed requests.
+const Scheme = "MOMENT-HMAC-SHA256"
+
+var (
+	ErrorSignatureMissing   = errors.New("Authorization header must use the " + Scheme + " scheme.")
+	ErrorSignatureMalformed = errors.New("Signature must name KeyID, Timestamp, Nonce and Signature.")
+	ErrorSignatureInvalid   = errors.New("Request signature is invalid.")
+)
+
+// Signature is the signature of a request in its Authorization header.
+type Signature struct {
+	KeyID     string
+	Timestamp time.Time
+	Nonce     string
+	Signature string
+}
+
+// String returns the Authorization header of s.
+func (s *Signature) String() string {
+	return Scheme + " KeyID=" + s.KeyID +
+		", Timestamp=" + strconv.FormatInt(s.Timestamp.Unix(), 10) +
+		", Nonce=" + s.Nonce +
+		", Signature=" + s.Signature
+}
+
+// ParseAuthorization parses the Authorization header h of a signed request.
+func ParseAuthorization(h string) (*Signature, error) {
+	if !strings.HasPrefix(h, Scheme+" ") {
+		return nil, ErrorSignatureMissing
+	}
+	s := new(Signature)
+	for _, param := range strings.Split(strings.TrimPrefix(h, Scheme+" "), ",") {
+		kv := strings.SplitN(strings.TrimSpace(param), "=", 2)
+		if len(kv) != 2 {
+			return nil, ErrorSignatureMalformed
+		}
+		switch kv[0] {
+		case "KeyID":
+			s.KeyID = kv[1]
+		case "Timestamp":
+			t, err := strconv.ParseInt(kv[1], 10, 64)
+			if err != nil {
+				return nil, ErrorSignatureMalformed
+			}
+			s.Timestamp = time.Unix(t, 0).UTC()
+		case "Nonce":
+			s.Nonce = kv[1]
+		case "Signature":
+			s.Signature = kv[1]
+		}
+	}
+	if s.KeyID == "" || s.Timestamp.IsZero() || s.Nonce == "" || s.Signature == "" {
+		return nil, ErrorSignatureMalformed
+	}
+	return s, nil
+}
+
+// StringToSign returns the string signed for r at timestamp with nonce: the scheme, method, host, escaped path
+// and raw query, timestamp, nonce and hex encoded SHA-256 digest of the body, separated by newlines. The host
+// keeps a signature made for one service from being replayed against another sharing the signing key.
+// The body of r is read and replaced, so r can still be sent or handled.
+func StringToSign(r *http.Request, timestamp time.Time, nonce string) (string, error) {
+	var body []byte
+	if r.Body != nil {
+		b, err := ioutil.ReadAll(r.Body)
+		r.Body.Close()
+		if err != nil {
+			return "", err
+		}
+		body = b
+		r.Body = ioutil.NopCloser(bytes.NewReader(body))
+	}
+	digest := sha256.Sum256(body)
+	return strings.Join([]string{
+		Scheme,
+		r.Method,
+		requestHost(r),
+		requestPath(r.URL),
+		strconv.FormatInt(timestamp.Unix(), 10),
+		nonce,
+		hex.EncodeToString(digest[:]),
+	}, "\n"), nil
+}
+
+// Timestamp returns the timestamp of stringToSign, a string returned by StringToSign.
+func Timestamp(stringToSign string) (time.Time, error) {
+	parts := strings.Split(stringToSign, "\n")
+	if len(parts) != 7 || parts[0] != Scheme {
+		return time.Time{}, ErrorSignatureMalformed
+	}
+	t, err := strconv.ParseInt(parts[4], 10, 64)
+	if err != nil {
+		return time.Time{}, ErrorSignatureMalformed
+	}
+	return time.Unix(t, 0).UTC(), nil
+}
+
+// requestHost returns the host r is sent to or was received at, in lower case. Outgoing requests may leave Host
+// empty to use that of their URL.
+func requestHost(r *http.Request) string {
+	host := r.Host
+	if host == "" {
+		host = r.URL.Host
+	}
+	return strings.ToLower(host)
+}
+
+// requestPath returns the escaped path of u followed by its raw query, if any.
+func requestPath(u *url.URL) string {
+	path := u.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	if u.RawQuery != "" {
+		path += "?" + u.RawQuery
+	}
+	return path
+}
+
+// Sum returns the base64url encoded HMAC-SHA256 of stringToSign keyed with secret.
+func Sum(secret, stringToSign string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(stringToSign))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// Valid reports whether signature is the signature of stringToSign with secret, in constant time.
+func Valid(secret, stringToSign, signature string) bool {
+	return hmac.Equal([]byte(Sum(secret, stringToSign)), []byte(signature))
+}
+
+// NewNonce returns 16 random bytes, base64url encoded.
+func NewNonce() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// Signer signs requests with the signing key KeyID and its secret.
+type Signer struct {
+	KeyID  string
+	Secret string
+}
+
+// Sign sets the Authorization header of r to a signature of r made now with a new nonce.
+func (s *Signer) Sign(r *http.Request) error {
+	nonce, err := NewNonce()
+	if err != nil {
+		return err
+	}
+	sig := &Signature{KeyID: s.KeyID, Timestamp: time.Now().UTC(), Nonce: nonce}
+	str, err := StringToSign(r, sig.Timestamp, sig.Nonce)
+	if err != nil {
+		return err
+	}
+	sig.Signature = Sum(s.Secret, str)
+	r.Header.Set("Authorization", sig.String())
+	return nil
+}
+
+// Transport is an http.RoundTripper signing every request with Signer before sending it with Base,
+// http.DefaultTransport if nil. Use it as the Transport of an http.Client calling services accepting signed requests.
+type Transport struct {
+	Signer *Signer
+	Base   http.RoundTripper
+}
+
+// RoundTrip signs a copy of r and sends it.
+func (t *Transport) RoundTrip(r *http.Request) (*http.Response, error) {
+	signed := r.Clone(r.Context())
+	if err := t.Signer.Sign(signed); err != nil {
+		return nil, err
+	}
+	base := t.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return base.RoundTrip(signed)
+}
@@ -0,0 +1,124 @@
+package signing
+
+import (
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+var (
+	tKeyID  = "01890a5d-ac96-774b-bcce-b302099a8059"
+	tSecret = "ahs_0123456789abcdefghijklmnopqrstuvwxyzABCD00000000"
+)
+
+func Test_ParseAuthorization(t *testing.T) {
+	ts := time.Unix(1700000000, 0).UTC()
+	sig := &Signature{KeyID: tKeyID, Timestamp: ts, Nonce: "n0nce", Signature: "c2ln"}
+	cases := []struct {
+		h   string
+		sig *Signature
+		err error
+	}{
+		{sig.String(), sig, nil},
+		{Scheme + " Nonce=n0nce,Signature=c2ln,KeyID=" + tKeyID + ",Timestamp=1700000000", sig, nil},
+		{"Bearer abc", nil, ErrorSignatureMissing},
+		{Scheme + " KeyID=" + tKeyID + ", Timestamp=1700000000, Nonce=n0nce", nil, ErrorSignatureMalformed},
+		{Scheme + " KeyID=" + tKeyID + ", Timestamp=now, Nonce=n0nce, Signature=c2ln", nil, ErrorSignatureMalformed},
+		{Scheme + " KeyID", nil, ErrorSignatureMalformed},
+	}
+	for i, c := range cases {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			sig, err := ParseAuthorization(c.h)
+			assert.Equal(t, c.err, err)
+			assert.Equal(t, c.sig, sig)
+		})
+	}
+}
+
+func Test_StringToSign(t *testing.T) {
+	ts := time.Unix(1700000000, 0).UTC()
+	r := httptest.NewRequest(http.MethodPost, "/moments/a%20b?limit=10&sort=asc", strings.NewReader(`{"a":1}`))
+	str, err := StringToSign(r, ts, "n0nce")
+	assert.Nil(t, err)
+	assert.Equal(t, Scheme+"\nPOST\nexample.com\n/moments/a%20b?limit=10&sort=asc\n1700000000\nn0nce\n"+
+		"015abd7f5cc57a2dd94b7590f04ad8084273905ee33ec5cebeae62276a97f862", str)
+
+	body, err := ioutil.ReadAll(r.Body)
+	assert.Nil(t, err)
+	assert.Equal(t, `{"a":1}`, string(body))
+
+	parsed, err := Timestamp(str)
+	assert.Nil(t, err)
+	assert.Equal(t, ts, parsed)
+	_, err = Timestamp("1700000000")
+	assert.Equal(t, ErrorSignatureMalformed, err)
+
+	r = httptest.NewRequest(http.MethodGet, "/moments", nil)
+	str, err = StringToSign(r, ts, "n0nce")
+	assert.Nil(t, err)
+	assert.True(t, strings.HasSuffix(str, "\ne3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"))
+
+	// A signature made for one host is not valid at another.
+	other := httptest.NewRequest(http.MethodGet, "http://Media.example.com/moments", nil)
+	otherStr, err := StringToSign(other, ts, "n0nce")
+	assert.Nil(t, err)
+	assert.NotEqual(t, str, otherStr)
+	assert.Contains(t, otherStr, "\nmedia.example.com\n")
+}
+
+func Test_Signer(t *testing.T) {
+	s := &Signer{KeyID: tKeyID, Secret: tSecret}
+	r := httptest.NewRequest(http.MethodPut, "/moments/1", strings.NewReader("body"))
+	assert.Nil(t, s.Sign(r))
+
+	sig, err := ParseAuthorization(r.Header.Get("Authorization"))
+	assert.Nil(t, err)
+	assert.Equal(t, tKeyID, sig.KeyID)
+	assert.WithinDuration(t, time.Now(), sig.Timestamp, time.Second)
+
+	str, err := StringToSign(r, sig.Timestamp, sig.Nonce)
+	assert.Nil(t, err)
+	assert.True(t, Valid(tSecret, str, sig.Signature))
+	assert.False(t, Valid("other", str, sig.Signature))
+	assert.False(t, Valid(tSecret, strings.Replace(str, "PUT", "GET", 1), sig.Signature))
+
+	other := httptest.NewRequest(http.MethodPut, "/moments/1", strings.NewReader("body"))
+	assert.Nil(t, s.Sign(other))
+	assert.NotEqual(t, r.Header.Get("Authorization"), other.Header.Get("Authorization"))
+}
+
+func Test_Transport(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sig, err := ParseAuthorization(r.Header.Get("Authorization"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+		str, err := StringToSign(r, sig.Timestamp, sig.Nonce)
+		if err != nil || !Valid(tSecret, str, sig.Signature) {
+			http.Error(w, "invalid", http.StatusUnauthorized)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	client := &http.Client{Transport: &Transport{Signer: &Signer{KeyID: tKeyID, Secret: tSecret}}}
+	req, err := http.NewRequest(http.MethodPost, srv.URL+"/moments?draft=true", strings.NewReader("body"))
+	assert.Nil(t, err)
+	resp, err := client.Do(req)
+	assert.Nil(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusNoContent, resp.StatusCode)
+	assert.Empty(t, req.Header.Get("Authorization"))
+
+	resp, err = http.Post(srv.URL+"/moments", "text/plain", strings.NewReader("body"))
+	assert.Nil(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+}
@@ -0,0 +1,241 @@
+package main
+
+import (
+	"encoding/json"
+	sq "github.com/Masterminds/squirrel"
+	"github.com/penutty/authservice/pat"
+	"github.com/penutty/authservice/signing"
+	"github.com/penutty/authservice/verification"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// MockSigningKeyClient keeps signing keys and their secrets in memory by KeyID. KeyIDs are "key-" followed by
+// a sequence number.
+type MockSigningKeyClient struct {
+	err     error
+	keys    map[string]*signing.Key
+	secrets map[string]string
+}
+
+func NewMockSigningKeyClient() *MockSigningKeyClient {
+	return &MockSigningKeyClient{keys: make(map[string]*signing.Key), secrets: make(map[string]string)}
+}
+
+func (m *MockSigningKeyClient) Create(k *signing.Key, db sq.BaseRunner) string {
+	if err := k.Check(); err != nil {
+		m.err = err
+		return ""
+	}
+	secret, err := pat.GenerateWith(signing.SecretPrefix)
+	if err != nil {
+		m.err = err
+		return ""
+	}
+	k.KeyID, k.Created = "key-"+strconv.Itoa(len(m.keys)), time.Now().UTC()
+	m.keys[k.KeyID], m.secrets[k.KeyID] = k, secret
+	return secret
+}
+
+func (m *MockSigningKeyClient) List(owner string, db sq.BaseRunner) (keys []*signing.Key) {
+	for _, k := range m.keys {
+		if k.Owner == owner {
+			keys = append(keys, k)
+		}
+	}
+	return
+}
+
+func (m *MockSigningKeyClient) Revoke(owner, keyID string, db sq.BaseRunner) {
+	if k, ok := m.keys[keyID]; !ok || k.Owner != owner {
+		m.err = signing.ErrorKeyNotFound
+		return
+	}
+	delete(m.keys, keyID)
+}
+
+func (m *MockSigningKeyClient) Verify(keyID, stringToSign, signature string, db sq.BaseRunner) (*signing.Key, error) {
+	if m.err != nil {
+		return nil, m.err
+	}
+	k, ok := m.keys[keyID]
+	if !ok || (!k.Expires.IsZero() && time.Now().UTC().After(k.Expires)) || !signing.Valid(m.secrets[keyID], stringToSign, signature) {
+		return nil, signing.ErrorSignatureInvalid
+	}
+	k.LastUsed = time.Now().UTC()
+	return k, nil
+}
+
+func (m *MockSigningKeyClient) Err() error {
+	return m.err
+}
+
+// newSigningApp returns an app where tAccount has a signing key, and the signer using it.
+func newSigningApp() (*app, *MockSigningKeyClient, *signing.Signer) {
+	a, _ := newServiceAccountApp()
+	a.x = new(MockExchangeClient)
+	sk := NewMockSigningKeyClient()
+	a.sk = sk
+	k := &signing.Key{Owner: tAccount}
+	secret := sk.Create(k, nil)
+	return a, sk, &signing.Signer{KeyID: k.KeyID, Secret: secret}
+}
+
+func Test_serviceAccountSigningKeysHandler(t *testing.T) {
+	type test struct {
+		req   *http.Request
+		code  int
+		keys  int
+		audit int
+	}
+	cases := []*test{
+		&test{NewBearerRequest(http.MethodPost, ServiceAccountSigningKeysEndpoint, strings.NewReader(`{"ID": "`+tAccount+`"}`)), http.StatusCreated, 2, 1},
+		&test{NewBearerRequest(http.MethodPost, ServiceAccountSigningKeysEndpoint, strings.NewReader(`{"ID": "`+tAccount+`", "Expires": "2001-01-01T00:00:00Z"}`)), http.StatusBadRequest, 1, 0},
+		&test{NewBearerRequest(http.MethodPost, ServiceAccountSigningKeysEndpoint, strings.NewReader(`{"ID": "`+tOtherAccount+`"}`)), http.StatusForbidden, 1, 0},
+		&test{NewBearerRequest(http.MethodGet, ServiceAccountSigningKeysEndpoint+"?ID="+tAccount, nil), http.StatusOK, 1, 0},
+		&test{NewBearerRequest(http.MethodGet, ServiceAccountSigningKeysEndpoint+"?ID=sa-missing", nil), http.StatusNotFound, 1, 0},
+		&test{NewBearerRequest(http.MethodDelete, ServiceAccountSigningKeysEndpoint+"?ID="+tAccount+"&KeyID=key-0", nil), http.StatusNoContent, 0, 1},
+		&test{NewBearerRequest(http.MethodDelete, ServiceAccountSigningKeysEndpoint+"?ID="+tAccount+"&KeyID=key-9", nil), http.StatusNotFound, 1, 0},
+		&test{httptest.NewRequest(http.MethodGet, ServiceAccountSigningKeysEndpoint+"?ID="+tAccount, nil), http.StatusUnauthorized, 1, 0},
+		&test{NewBearerRequest(http.MethodPut, ServiceAccountSigningKeysEndpoint, nil), http.StatusNotImplemented, 1, 0},
+	}
+	for i, c := range cases {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			a, sk, signer := newSigningApp()
+			au := a.au.(*MockAuditClient)
+			rec := httptest.NewRecorder()
+			a.serviceAccountSigningKeysHandler(rec, c.req)
+			assert.Equal(t, c.code, rec.Code)
+			assert.Len(t, sk.keys, c.keys)
+			assert.Len(t, au.entries, c.audit)
+			assert.NotContains(t, rec.Body.String(), signer.Secret)
+
+			switch c.code {
+			case http.StatusCreated:
+				res := new(signingKeyResource)
+				assert.Nil(t, json.NewDecoder(rec.Body).Decode(res))
+				assert.Equal(t, "key-1", res.KeyID)
+				assert.True(t, pat.ValidWith(res.Secret, signing.SecretPrefix))
+				assert.Equal(t, "no-store", rec.Header().Get("Cache-Control"))
+			case http.StatusOK:
+				var res []*signingKeyResource
+				assert.Nil(t, json.NewDecoder(rec.Body).Decode(&res))
+				assert.Len(t, res, 1)
+				assert.Equal(t, signer.KeyID, res[0].KeyID)
+				assert.Empty(t, res[0].Secret)
+			}
+		})
+	}
+}
+
+func Test_signatureVerificationHandler(t *testing.T) {
+	type test struct {
+		prepare func(a *app, sk *MockSigningKeyClient, c *verification.SignatureCheck)
+		secret  string
+		code    int
+		active  bool
+	}
+	cases := []*test{
+		&test{nil, tSecret, http.StatusOK, true},
+		&test{func(a *app, sk *MockSigningKeyClient, c *verification.SignatureCheck) {
+			c.Signature = signing.Sum("other", c.StringToSign)
+		}, tSecret, http.StatusOK, false},
+		&test{func(a *app, sk *MockSigningKeyClient, c *verification.SignatureCheck) {
+			c.StringToSign = strings.Replace(c.StringToSign, "GET", "DELETE", 1)
+		}, tSecret, http.StatusOK, false},
+		&test{func(a *app, sk *MockSigningKeyClient, c *verification.SignatureCheck) {
+			sk.keys[c.KeyID].Expires = time.Now().UTC().Add(-time.Minute)
+		}, tSecret, http.StatusOK, false},
+		&test{func(a *app, sk *MockSigningKeyClient, c *verification.SignatureCheck) {
+			a.sa.(*MockServiceAccountClient).accounts[tAccount].Disabled = true
+		}, tSecret, http.StatusOK, false},
+		&test{func(a *app, sk *MockSigningKeyClient, c *verification.SignatureCheck) {
+			a.x = &MockExchangeClient{tenantID: tTenant}
+		}, tSecret, http.StatusOK, false},
+		&test{nil, "wrong", http.StatusUnauthorized, false},
+	}
+	for i, c := range cases {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			a, sk, signer := newSigningApp()
+			r := httptest.NewRequest(http.MethodGet, "/moments", nil)
+			assert.Nil(t, signer.Sign(r))
+			sig, _ := signing.ParseAuthorization(r.Header.Get("Authorization"))
+			str, _ := signing.StringToSign(r, sig.Timestamp, sig.Nonce)
+			check := &verification.SignatureCheck{KeyID: sig.KeyID, StringToSign: str, Signature: sig.Signature}
+			if c.prepare != nil {
+				c.prepare(a, sk, check)
+			}
+
+			b, _ := json.Marshal(check)
+			req := httptest.NewRequest(http.MethodPost, SignatureVerificationEndpoint, strings.NewReader(string(b)))
+			req.SetBasicAuth(tClientID, c.secret)
+			rec := httptest.NewRecorder()
+			a.signatureVerificationHandler(rec, req)
+			assert.Equal(t, c.code, rec.Code)
+			if c.code != http.StatusOK {
+				return
+			}
+
+			res := new(verification.Introspection)
+			assert.Nil(t, json.NewDecoder(rec.Body).Decode(res))
+			assert.Equal(t, c.active, res.Active)
+			if !c.active {
+				assert.Equal(t, &verification.Introspection{}, res)
+				return
+			}
+			assert.Equal(t, verification.TokenTypeSignature, res.TokenType)
+			assert.Equal(t, tAccount, res.Sub)
+			assert.Equal(t, "ci", res.Username)
+			assert.Equal(t, []string{"deploy:prod"}, res.Scopes())
+		})
+	}
+
+	t.Run(strconv.Itoa(len(cases)), func(t *testing.T) {
+		a, sk, signer := newSigningApp()
+		str := signing.Scheme + "\nGET\n/moments\n" + strconv.FormatInt(time.Now().Add(-time.Hour).Unix(), 10) + "\nn0nce\ndigest"
+		b, _ := json.Marshal(&verification.SignatureCheck{KeyID: signer.KeyID, StringToSign: str, Signature: signing.Sum(signer.Secret, str)})
+		req := httptest.NewRequest(http.MethodPost, SignatureVerificationEndpoint, strings.NewReader(string(b)))
+		req.SetBasicAuth(tClientID, tSecret)
+		rec := httptest.NewRecorder()
+		a.signatureVerificationHandler(rec, req)
+
+		res := new(verification.Introspection)
+		assert.Nil(t, json.NewDecoder(rec.Body).Decode(res))
+		assert.False(t, res.Active)
+		assert.True(t, sk.keys[signer.KeyID].LastUsed.IsZero())
+	})
+
+	t.Run(strconv.Itoa(len(cases)+1), func(t *testing.T) {
+		a, _, _ := newSigningApp()
+		rec := httptest.NewRecorder()
+		a.signatureVerificationHandler(rec, httptest.NewRequest(http.MethodGet, SignatureVerificationEndpoint, nil))
+		assert.Equal(t, http.StatusNotImplemented, rec.Code)
+	})
+}
+
+func Test_SignatureVerifier_authService(t *testing.T) {
+	a, _, signer := newSigningApp()
+	srv := httptest.NewServer(http.HandlerFunc(a.signatureVerificationHandler))
+	defer srv.Close()
+
+	var subject string
+	h := verification.NewSignatureVerifier(srv.URL, tClientID, tSecret).Wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		subject = verification.Signer(r).Sub
+	}))
+
+	r := httptest.NewRequest(http.MethodPost, "/moments", strings.NewReader(`{"Message": "hi"}`))
+	assert.Nil(t, signer.Sign(r))
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, r)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, tAccount, subject)
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, r)
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/penutty/authservice/audit"
+	"github.com/penutty/authservice/user"
+	"net/http"
+	"time"
+)
+
+var (
+	ErrorAccountSuspended       = errors.New("Account is suspended.")
+	ErrorAccountDisabled        = errors.New("Account is disabled.")
+	ErrorAccountPendingDeletion = errors.New("Account is pending deletion.")
+	ErrorStatusSelf             = errors.New("Administrators may not change their own status.")
+)
+
+// checkStatus returns an error unless the status of u allows it to log in.
+func checkStatus(u *user.User) error {
+	switch u.Status(time.Now().UTC()) {
+	case user.StatusSuspended:
+		return ErrorAccountSuspended
+	case user.StatusDisabled:
+		return ErrorAccountDisabled
+	case user.StatusPendingDeletion:
+		return ErrorAccountPendingDeletion
+	}
+	return nil
+}
+
+// statusResource is the representation of the status of a user returned by StatusEndpoint.
+// Audit entries are only shown to administrators.
+type statusResource struct {
+	UserID  string
+	Status  user.Status
+	Reason  string
+	Changed time.Time
+	Until   *time.Time     `json:",omitempty"`
+	Audit   []*audit.Entry `json:",omitempty"`
+}
+
+func newStatusResource(u *user.User) *statusResource {
+	s := &statusResource{
+		UserID:  u.UserID(),
+		Status:  u.Status(time.Now().UTC()),
+		Reason:  u.StatusReason(),
+		Changed: u.StatusChanged(),
+	}
+	if until := u.StatusUntil(); !until.IsZero() && s.Status == user.StatusSuspended {
+		s.Until = &until
+	}
+	return s
+}
+
+func (a *app) statusHandler(w http.ResponseWriter, r *http.Request) {
+	var (
+		s   *statusResource
+		err error
+	)
+	switch r.Method {
+	case http.MethodGet:
+		s, err = a.getStatus(r)
+	case http.MethodPut:
+		s, err = a.putStatus(r)
+	default:
+		logger(Error).Println(ErrorMethodNotImplemented)
+		http.Error(w, http.StatusText(http.StatusNotImplemented), http.StatusNotImplemented)
+		return
+	}
+	if err != nil {
+		genErrorHandler(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(s); err != nil {
+		logger(Error).Println(err)
+	}
+}
+
+// getStatus returns the status of the caller, or as an administrator the status and audit log of any user.
+func (a *app) getStatus(r *http.Request) (*statusResource, error) {
+	caller, u, err := a.targetUser(r, ScopeStatusRead)
+	if err != nil {
+		return nil, err
+	}
+
+	s := newStatusResource(u)
+	if isAdministrator(caller.ID()) {
+		s.Audit = a.au.List(u.ID(), user.AuthDB())
+		if err := a.au.Err(); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// putStatus lets an administrator change the status of a user. Every token and session of a user that may no
+// longer log in is revoked. The change is recorded in the audit log.
+func (a *app) putStatus(r *http.Request) (*statusResource, error) {
+	caller, err := a.authenticateLogin(r)
+	if err != nil {
+		return nil, err
+	}
+	if !isAdministrator(caller.ID()) {
+		return nil, ErrorUserForbidden
+	}
+
+	type body struct {
+		UserID string
+		Status user.Status
+		Reason string
+		Until  time.Time
+	}
+	b := new(body)
+	if err := json.NewDecoder(r.Body).Decode(b); err != nil {
+		return nil, err
+	}
+	u, err := a.fetchUser(qualify(r, b.UserID))
+	if err != nil {
+		return nil, err
+	}
+	b.UserID = u.UserID()
+	if u.ID() == caller.ID() {
+		return nil, ErrorStatusSelf
+	}
+	from := u.Status(time.Now().UTC())
+	u.SetStatus(b.Status, b.Reason, b.Until)
+	if err := u.Err(); err != nil {
+		return nil, err
+	}
+
+	a.c.ChangeStatus(b.UserID, b.Status, b.Reason, b.Until, user.AuthDB())
+	if err := a.c.Err(); err != nil {
+		return nil, err
+	}
+	if err := checkStatus(u); err != nil {
+		a.c.RevokeTokens(b.UserID, user.AuthDB())
+		if err := a.c.Err(); err != nil {
+			return nil, err
+		}
+		a.ss.RevokeAll(u.ID(), user.AuthDB())
+		if err := a.ss.Err(); err != nil {
+			return nil, err
+		}
+	}
+
+	detail := fmt.Sprintf("%s -> %s", from, b.Status)
+	if b.Reason != "" {
+		detail += ": " + b.Reason
+	}
+	a.au.Record(audit.NewEntry(caller.ID(), u.ID(), "status", detail), user.AuthDB())
+	if err := a.au.Err(); err != nil {
+		logger(Error).Println(err)
+	}
+	return newStatusResource(u), nil
+}
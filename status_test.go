@@ -0,0 +1,150 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	sq "github.com/Masterminds/squirrel"
+	"github.com/penutty/authservice/audit"
+	"github.com/penutty/authservice/session"
+	"github.com/penutty/authservice/user"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+type MockAuditClient struct {
+	err     error
+	entries []*audit.Entry
+}
+
+func (m *MockAuditClient) Record(e *audit.Entry, db sq.BaseRunner) {
+	m.entries = append(m.entries, e)
+}
+
+func (m *MockAuditClient) List(subject string, db sq.BaseRunner) []*audit.Entry {
+	return m.entries
+}
+
+func (m *MockAuditClient) Err() error {
+	return m.err
+}
+
+func NewStatusBody(u string, status user.Status, reason string) *strings.Reader {
+	return strings.NewReader(fmt.Sprintf("{\"UserID\": \"%s\", \"Status\": \"%s\", \"Reason\": \"%s\"}", u, status, reason))
+}
+
+func Test_checkStatus(t *testing.T) {
+	type test struct {
+		status user.Status
+		until  time.Time
+		err    error
+	}
+	cases := []*test{
+		&test{user.StatusActive, time.Time{}, nil},
+		&test{user.StatusPendingVerification, time.Time{}, nil},
+		&test{user.StatusSuspended, time.Time{}, ErrorAccountSuspended},
+		&test{user.StatusSuspended, time.Now().Add(time.Hour), ErrorAccountSuspended},
+		&test{user.StatusSuspended, time.Now().Add(-time.Hour), nil},
+		&test{user.StatusDisabled, time.Time{}, ErrorAccountDisabled},
+		&test{user.StatusPendingDeletion, time.Time{}, ErrorAccountPendingDeletion},
+	}
+	for i, c := range cases {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			m := &MockUserClient{status: c.status, until: c.until}
+			assert.Equal(t, c.err, checkStatus(m.Fetch(tUser, nil)))
+		})
+	}
+}
+
+func Test_authHandler_status(t *testing.T) {
+	type test struct {
+		status user.Status
+		code   int
+	}
+	cases := []*test{
+		&test{user.StatusActive, http.StatusOK},
+		&test{user.StatusSuspended, http.StatusForbidden},
+		&test{user.StatusDisabled, http.StatusForbidden},
+		&test{user.StatusPendingDeletion, http.StatusForbidden},
+	}
+	for i, c := range cases {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			a, m, _ := newVerifyApp()
+			m.status = c.status
+			rec := httptest.NewRecorder()
+			a.authHandler(rec, httptest.NewRequest(http.MethodPost, AuthEndpoint, NewAuthBody(tUser, tPassword)))
+			assert.Equal(t, c.code, rec.Code)
+			assert.Equal(t, c.code == http.StatusOK, rec.Header().Get("jwt") != "")
+		})
+	}
+}
+
+func Test_statusHandler(t *testing.T) {
+	defer func(admins []string) { Administrators = admins }(Administrators)
+
+	type test struct {
+		req     *http.Request
+		admins  []string
+		code    int
+		status  user.Status
+		revoked bool
+		audit   int
+	}
+	cases := []*test{
+		&test{NewBearerRequest(http.MethodGet, StatusEndpoint, nil), nil, http.StatusOK, user.StatusPendingVerification, false, 0},
+		&test{NewBearerRequest(http.MethodGet, StatusEndpoint+"?UserID="+tOtherUser, nil), nil, http.StatusForbidden, "", false, 0},
+		&test{NewBearerRequest(http.MethodPut, StatusEndpoint, NewStatusBody(tOtherUser, user.StatusSuspended, "Spam")), nil, http.StatusForbidden, "", false, 0},
+		&test{NewBearerRequest(http.MethodPut, StatusEndpoint, NewStatusBody(tOtherUser, user.StatusSuspended, "Spam")), []string{tUser}, http.StatusOK, user.StatusSuspended, true, 1},
+		&test{NewBearerRequest(http.MethodPut, StatusEndpoint, NewStatusBody(tOtherUser, user.StatusActive, "")), []string{tUser}, http.StatusOK, user.StatusActive, false, 1},
+		&test{NewBearerRequest(http.MethodPut, StatusEndpoint, NewStatusBody(tOtherUser, "banned", "")), []string{tUser}, http.StatusBadRequest, "", false, 0},
+		&test{NewBearerRequest(http.MethodPut, StatusEndpoint, NewStatusBody(tUser, user.StatusDisabled, "")), []string{tUser}, http.StatusForbidden, "", false, 0},
+		&test{NewBearerRequest(http.MethodPut, StatusEndpoint, NewStatusBody(tUserMissing, user.StatusDisabled, "")), []string{tUser}, http.StatusNotFound, "", false, 0},
+		&test{httptest.NewRequest(http.MethodGet, StatusEndpoint, nil), nil, http.StatusUnauthorized, "", false, 0},
+		&test{NewBearerRequest(http.MethodPost, StatusEndpoint, nil), nil, http.StatusNotImplemented, "", false, 0},
+	}
+	for i, c := range cases {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			Administrators = administrators(c.admins...)
+			a, m, _ := newVerifyApp()
+			au := new(MockAuditClient)
+			a.au = au
+			ss := a.ss.(*MockSessionClient)
+			ss.Create(&session.Session{Owner: "id-" + tOtherUser}, nil)
+			rec := httptest.NewRecorder()
+			a.statusHandler(rec, c.req)
+			assert.Equal(t, c.code, rec.Code)
+			assert.Equal(t, c.revoked, !m.revoked.IsZero())
+			assert.Equal(t, c.revoked, len(ss.sessions) == 0)
+			assert.Len(t, au.entries, c.audit)
+			if c.code != http.StatusOK {
+				return
+			}
+
+			s := new(statusResource)
+			if err := json.NewDecoder(rec.Body).Decode(s); err != nil {
+				t.Fatal(err)
+			}
+			assert.Equal(t, c.status, s.Status)
+			if c.audit > 0 {
+				assert.Equal(t, tID, au.entries[0].Actor)
+				assert.Equal(t, "id-"+tOtherUser, au.entries[0].Subject)
+			}
+		})
+	}
+}
+
+func Test_getStatus_audit(t *testing.T) {
+	defer func(admins []string) { Administrators = admins }(Administrators)
+	Administrators = administrators(tUser)
+
+	a, _, _ := newVerifyApp()
+	a.au = &MockAuditClient{entries: []*audit.Entry{audit.NewEntry(tUser, tOtherUser, "status", "active -> suspended: Spam")}}
+
+	s, err := a.getStatus(NewBearerRequest(http.MethodGet, StatusEndpoint+"?UserID="+tOtherUser, nil))
+	assert.Nil(t, err)
+	assert.Len(t, s.Audit, 1)
+}
@@ -0,0 +1,235 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/penutty/authservice/audit"
+	"github.com/penutty/authservice/tenant"
+	"github.com/penutty/authservice/user"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+var (
+	// TenantKeyDir holds a directory per tenant other than the default one, named by its ID, with the
+	// jwt_private.pem its tokens are signed with. The matching jwt_public.pem is read from verification.TenantKeyDir.
+	TenantKeyDir = GOPATH + "/src/github.com/penutty/authservice/.ssh/tenants"
+
+	ErrorTenantMismatch  = errors.New("Token was issued for another tenant.")
+	ErrorTenantForbidden = errors.New("Tenants, roles and groups may only be managed by administrators of the default tenant.")
+
+	defaultTenant = &tenant.Tenant{ID: tenant.Default}
+)
+
+// tenantKey is the key of the tenant of a request in its context.
+type tenantKey struct{}
+
+// resolveTenant wraps h to resolve the tenant of every request: the tenant named by a path starting with
+// "/t/" and its ID, which is stripped from the path, or else the tenant served at the Host of the request,
+// or else the default tenant. A path naming an unknown tenant is answered with 404 Not Found.
+func (a *app) resolveTenant(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t, err := a.requestTenant(r)
+		if err != nil {
+			genErrorHandler(w, err)
+			return
+		}
+		h.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), tenantKey{}, t)))
+	})
+}
+
+func (a *app) requestTenant(r *http.Request) (*tenant.Tenant, error) {
+	if strings.HasPrefix(r.URL.Path, "/t/") {
+		rest := strings.TrimPrefix(r.URL.Path, "/t/")
+		i := strings.Index(rest, "/")
+		if i < 0 || tenant.CheckID(rest[:i]) != nil {
+			return nil, tenant.ErrorTenantNotFound
+		}
+		t := defaultTenant
+		if rest[:i] != tenant.Default {
+			t = a.t.Fetch(rest[:i], user.AuthDB())
+			if err := a.t.Err(); err != nil {
+				return nil, err
+			}
+			if t == nil {
+				return nil, tenant.ErrorTenantNotFound
+			}
+		}
+		r.URL.Path = rest[i:]
+		return t, nil
+	}
+
+	host := r.Host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	if host == "" {
+		return defaultTenant, nil
+	}
+	t := a.t.FetchByHost(host, user.AuthDB())
+	if err := a.t.Err(); err != nil {
+		return nil, err
+	}
+	if t == nil {
+		return defaultTenant, nil
+	}
+	return t, nil
+}
+
+// tenantOf returns the tenant r was resolved to by resolveTenant, the default tenant if it was not resolved.
+func tenantOf(r *http.Request) *tenant.Tenant {
+	if t, ok := r.Context().Value(tenantKey{}).(*tenant.Tenant); ok {
+		return t
+	}
+	return defaultTenant
+}
+
+// checkTenant returns ErrorTenantMismatch unless u is a user of the tenant of r. Logins completed with a token
+// or credential naming the user rather than its UserID are checked with it.
+func checkTenant(r *http.Request, u *user.User) error {
+	if u.TenantID() != tenantOf(r).ID {
+		return ErrorTenantMismatch
+	}
+	return nil
+}
+
+// qualify returns the UserID userID names in the tenant of r. UserIDs in requests are always read this way,
+// so that no request names a user of another tenant.
+func qualify(r *http.Request, userID string) string {
+	if userID == "" {
+		return ""
+	}
+	return user.Qualify(tenantOf(r).ID, userID)
+}
+
+// userTenant returns the tenant of the user with UserID userID.
+func (a *app) userTenant(userID string) (*tenant.Tenant, error) {
+	id := user.Tenant(userID)
+	if id == tenant.Default {
+		return defaultTenant, nil
+	}
+	t := a.t.Fetch(id, user.AuthDB())
+	if err := a.t.Err(); err != nil {
+		return nil, err
+	}
+	if t == nil {
+		return nil, tenant.ErrorTenantNotFound
+	}
+	return t, nil
+}
+
+// checkTenantPassword returns user.ErrorPasswordShort if password is shorter than the minimum length of t.
+// The global rules of user.CheckPassword apply as well.
+func checkTenantPassword(t *tenant.Tenant, password string) error {
+	if utf8.RuneCountInString(password) < t.PasswordMinLength {
+		return user.ErrorPasswordShort
+	}
+	return nil
+}
+
+// tenantResource is the representation of a tenant accepted and returned by TenantsEndpoint.
+// PasswordMaxAge is a duration such as "2160h".
+type tenantResource struct {
+	ID                string
+	Name              string
+	Host              string
+	PasswordMinLength int
+	PasswordMaxAge    string
+}
+
+func newTenantResource(t *tenant.Tenant) *tenantResource {
+	res := &tenantResource{ID: t.ID, Name: t.Name, Host: t.Host, PasswordMinLength: t.PasswordMinLength}
+	if t.PasswordMaxAge > 0 {
+		res.PasswordMaxAge = t.PasswordMaxAge.String()
+	}
+	return res
+}
+
+func (a *app) tenantsHandler(w http.ResponseWriter, r *http.Request) {
+	var (
+		res interface{}
+		err error
+	)
+	switch r.Method {
+	case http.MethodGet:
+		res, err = a.getTenants(r)
+	case http.MethodPut:
+		res, err = a.putTenant(r)
+	default:
+		logger(Error).Println(ErrorMethodNotImplemented)
+		http.Error(w, http.StatusText(http.StatusNotImplemented), http.StatusNotImplemented)
+		return
+	}
+	if err != nil {
+		genErrorHandler(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(res); err != nil {
+		logger(Error).Println(err)
+	}
+}
+
+// defaultAdministrator returns the caller of r if it is an administrator of the default tenant. Tenants, roles and
+// groups are shared by every tenant, so administrators of other tenants may not manage them.
+func (a *app) defaultAdministrator(r *http.Request) (*user.User, error) {
+	caller, err := a.administrator(r)
+	if err != nil {
+		return nil, err
+	}
+	if caller.TenantID() != tenant.Default {
+		return nil, ErrorTenantForbidden
+	}
+	return caller, nil
+}
+
+// getTenants lets an administrator of the default tenant list every tenant.
+func (a *app) getTenants(r *http.Request) ([]*tenantResource, error) {
+	if _, err := a.defaultAdministrator(r); err != nil {
+		return nil, err
+	}
+	tenants := a.t.List(user.AuthDB())
+	if err := a.t.Err(); err != nil {
+		return nil, err
+	}
+	res := make([]*tenantResource, 0, len(tenants))
+	for _, t := range tenants {
+		res = append(res, newTenantResource(t))
+	}
+	return res, nil
+}
+
+// putTenant lets an administrator of the default tenant create a tenant or replace its name, host and
+// password policy. The change is recorded in the audit log. The keys of a new tenant must be placed in
+// TenantKeyDir before its users can log in.
+func (a *app) putTenant(r *http.Request) (*tenantResource, error) {
+	caller, err := a.defaultAdministrator(r)
+	if err != nil {
+		return nil, err
+	}
+
+	b := new(tenantResource)
+	if err := json.NewDecoder(r.Body).Decode(b); err != nil {
+		return nil, err
+	}
+	t := &tenant.Tenant{ID: b.ID, Name: b.Name, Host: b.Host, PasswordMinLength: b.PasswordMinLength}
+	if b.PasswordMaxAge != "" {
+		if t.PasswordMaxAge, err = time.ParseDuration(b.PasswordMaxAge); err != nil {
+			return nil, err
+		}
+	}
+	a.t.Save(t, user.AuthDB())
+	if err := a.t.Err(); err != nil {
+		return nil, err
+	}
+
+	a.au.Record(audit.NewEntry(caller.ID(), t.ID, "tenant_save", t.Host), user.AuthDB())
+	if err := a.au.Err(); err != nil {
+		logger(Error).Println(err)
+	}
+	return newTenantResource(t), nil
+}
@@ -0,0 +1,230 @@
+// Package tenant is dedicated to reading and writing tenants in Auth-Db. Every user and client belongs to a
+// tenant; the UserIDs of a tenant are its own, see user.Qualify, and so are the keys its tokens are signed with.
+package tenant
+
+import (
+	"database/sql"
+	"errors"
+	sq "github.com/Masterminds/squirrel"
+	"log"
+	"regexp"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// Default is the tenant of users and clients created before tenants existed, and of requests naming no tenant.
+const Default = "default"
+
+var (
+	NameMaxLength = 256
+
+	ErrorTenantInvalid   = errors.New("Tenant must be 1 to 32 lowercase letters, digits or '-'.")
+	ErrorNameLong        = errors.New("Name too long.")
+	ErrorPasswordPolicy  = errors.New("PasswordMinLength and PasswordMaxAge may not be negative.")
+	ErrorTenantNotFound  = errors.New("Tenant does not exist.")
+	ErrorHostInUse       = errors.New("Host is already used by another tenant.")
+	ErrorDefaultReserved = errors.New("The default tenant has no host and follows the global password policy.")
+
+	tenantRegexp = regexp.MustCompile(`^[a-z0-9-]{1,32}$`)
+)
+
+// Tenant is an isolated set of users and clients. Requests for a tenant are recognized by its Host or by
+// a path starting with "/t/" and its ID. PasswordMinLength and PasswordMaxAge tighten the password policy
+// for its users when they are stricter than the global one; zero means the global policy applies, as it
+// always does for the default tenant.
+type Tenant struct {
+	ID                string
+	Name              string
+	Host              string
+	PasswordMinLength int
+	PasswordMaxAge    time.Duration
+}
+
+// CheckID returns an error if id is not a valid tenant ID.
+func CheckID(id string) error {
+	if !tenantRegexp.MatchString(id) {
+		return ErrorTenantInvalid
+	}
+	return nil
+}
+
+// Check returns an error if t is invalid.
+func (t *Tenant) Check() error {
+	if err := CheckID(t.ID); err != nil {
+		return err
+	}
+	if utf8.RuneCountInString(t.Name) > NameMaxLength {
+		return ErrorNameLong
+	}
+	if t.PasswordMinLength < 0 || t.PasswordMaxAge < 0 {
+		return ErrorPasswordPolicy
+	}
+	if t.ID == Default && (t.Host != "" || t.PasswordMinLength != 0 || t.PasswordMaxAge != 0) {
+		return ErrorDefaultReserved
+	}
+	return nil
+}
+
+type Client interface {
+	Fetcher
+	HostFetcher
+	Saver
+	Lister
+	Err() error
+}
+
+type Fetcher interface {
+	Fetch(string, sq.BaseRunner) *Tenant
+}
+
+type HostFetcher interface {
+	FetchByHost(string, sq.BaseRunner) *Tenant
+}
+
+type Saver interface {
+	Save(*Tenant, sq.BaseRunner)
+}
+
+type Lister interface {
+	List(sq.BaseRunner) []*Tenant
+}
+
+type TenantClient struct {
+	err error
+}
+
+// Fetch selects the tenant with ID id from the auth.Tenants table in db.
+// It returns nil without an error if there is no such tenant.
+func (tc *TenantClient) Fetch(id string, db sq.BaseRunner) *Tenant {
+	if tc.err != nil {
+		return nil
+	}
+	return tc.fetch(sq.Eq{"[TenantID]": id}, db)
+}
+
+// FetchByHost selects the tenant served at host, matched regardless of case, from the auth.Tenants table in db.
+// It returns nil without an error if no tenant is served at host.
+func (tc *TenantClient) FetchByHost(host string, db sq.BaseRunner) *Tenant {
+	if tc.err != nil {
+		return nil
+	}
+	return tc.fetch(sq.Eq{"[Host]": strings.ToLower(host)}, db)
+}
+
+func (tc *TenantClient) fetch(where sq.Eq, db sq.BaseRunner) *Tenant {
+	sel := sq.Select("[TenantID]", "[Name]", "[Host]", "[PasswordMinLength]", "[PasswordMaxAge]").From("[auth].[Tenants]").Where(where)
+
+	t := new(Tenant)
+	var (
+		host   *string
+		maxAge int64
+	)
+	err := sel.RunWith(db).QueryRow().Scan(&t.ID, &t.Name, &host, &t.PasswordMinLength, &maxAge)
+	switch {
+	case err == sql.ErrNoRows:
+		return nil
+	case err != nil:
+		log.Print(err)
+		tc.err = err
+		return nil
+	}
+	if host != nil {
+		t.Host = *host
+	}
+	t.PasswordMaxAge = time.Duration(maxAge) * time.Second
+	return t
+}
+
+// Save creates t or replaces its name, host and password policy in the auth.Tenants table in db.
+func (tc *TenantClient) Save(t *Tenant, db sq.BaseRunner) {
+	if tc.err != nil {
+		return
+	}
+	if err := t.Check(); err != nil {
+		tc.err = err
+		return
+	}
+
+	var host interface{}
+	if t.Host != "" {
+		t.Host = strings.ToLower(t.Host)
+		host = t.Host
+	}
+	maxAge := int64(t.PasswordMaxAge / time.Second)
+
+	update := sq.Update("[auth].[Tenants]").
+		Set("[Name]", t.Name).
+		Set("[Host]", host).
+		Set("[PasswordMinLength]", t.PasswordMinLength).
+		Set("[PasswordMaxAge]", maxAge).
+		Where(sq.Eq{"[TenantID]": t.ID})
+	res, err := update.RunWith(db).Exec()
+	if err != nil {
+		tc.err = tc.conflict(err)
+		return
+	}
+	if cnt, err := res.RowsAffected(); err == nil && cnt > 0 {
+		return
+	}
+	insert := sq.Insert("[auth].[Tenants]").
+		Columns("[TenantID]", "[Name]", "[Host]", "[PasswordMinLength]", "[PasswordMaxAge]").
+		Values(t.ID, t.Name, host, t.PasswordMinLength, maxAge)
+	if _, err := insert.RunWith(db).Exec(); err != nil {
+		tc.err = tc.conflict(err)
+	}
+}
+
+// conflict returns ErrorHostInUse if err is a violation of the unique index on hosts and err otherwise.
+func (tc *TenantClient) conflict(err error) error {
+	if strings.Contains(err.Error(), "UQ_Tenants_Host") {
+		return ErrorHostInUse
+	}
+	log.Print(err)
+	return err
+}
+
+// List returns every tenant, ordered by ID.
+func (tc *TenantClient) List(db sq.BaseRunner) (tenants []*Tenant) {
+	if tc.err != nil {
+		return
+	}
+
+	sel := sq.Select("[TenantID]", "[Name]", "[Host]", "[PasswordMinLength]", "[PasswordMaxAge]").From("[auth].[Tenants]").OrderBy("[TenantID]")
+	rows, err := sel.RunWith(db).Query()
+	if err != nil {
+		log.Print(err)
+		tc.err = err
+		return
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		t := new(Tenant)
+		var (
+			host   *string
+			maxAge int64
+		)
+		if err := rows.Scan(&t.ID, &t.Name, &host, &t.PasswordMinLength, &maxAge); err != nil {
+			log.Print(err)
+			tc.err = err
+			return nil
+		}
+		if host != nil {
+			t.Host = *host
+		}
+		t.PasswordMaxAge = time.Duration(maxAge) * time.Second
+		tenants = append(tenants, t)
+	}
+	if err := rows.Err(); err != nil {
+		log.Print(err)
+		tc.err = err
+		return nil
+	}
+	return
+}
+
+// Err returns the error status of a TenantClient.
+func (tc *TenantClient) Err() error {
+	return tc.err
+}
@@ -0,0 +1,153 @@
+package tenant
+
+import (
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/DATA-DOG/go-sqlmock.v1"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+var tTenant = "acme"
+
+func Test_Check(t *testing.T) {
+	cases := []struct {
+		t   *Tenant
+		err error
+	}{
+		{&Tenant{ID: tTenant, Host: "acme.example.com", PasswordMinLength: 12, PasswordMaxAge: time.Hour}, nil},
+		{&Tenant{ID: Default}, nil},
+		{&Tenant{ID: ""}, ErrorTenantInvalid},
+		{&Tenant{ID: "Acme"}, ErrorTenantInvalid},
+		{&Tenant{ID: "acme/eu"}, ErrorTenantInvalid},
+		{&Tenant{ID: strings.Repeat("a", 33)}, ErrorTenantInvalid},
+		{&Tenant{ID: tTenant, Name: strings.Repeat("a", 257)}, ErrorNameLong},
+		{&Tenant{ID: tTenant, PasswordMinLength: -1}, ErrorPasswordPolicy},
+		{&Tenant{ID: Default, Host: "auth.example.com"}, ErrorDefaultReserved},
+		{&Tenant{ID: Default, PasswordMinLength: 12}, ErrorDefaultReserved},
+	}
+	for i, c := range cases {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			assert.Equal(t, c.err, c.t.Check())
+		})
+	}
+}
+
+func Test_Fetch(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("An error occured when opening a stub database connection. ERROR: %v\n", err)
+	}
+	defer db.Close()
+
+	query := `SELECT \[TenantID], \[Name], \[Host], \[PasswordMinLength], \[PasswordMaxAge] FROM \[auth]\.\[Tenants] WHERE `
+
+	mock.ExpectQuery(query + `\[TenantID] = \?`).
+		WithArgs(tTenant).
+		WillReturnRows(sqlmock.NewRows([]string{"TenantID", "Name", "Host", "PasswordMinLength", "PasswordMaxAge"}).
+			AddRow(tTenant, "Acme", "acme.example.com", 12, 3600))
+	tc := new(TenantClient)
+	assert.Equal(t, &Tenant{ID: tTenant, Name: "Acme", Host: "acme.example.com", PasswordMinLength: 12, PasswordMaxAge: time.Hour}, tc.Fetch(tTenant, db))
+	assert.Nil(t, tc.Err())
+
+	mock.ExpectQuery(query + `\[Host] = \?`).
+		WithArgs("acme.example.com").
+		WillReturnRows(sqlmock.NewRows([]string{"TenantID", "Name", "Host", "PasswordMinLength", "PasswordMaxAge"}).
+			AddRow(tTenant, "Acme", "acme.example.com", 0, 0))
+	tc = new(TenantClient)
+	assert.Equal(t, tTenant, tc.FetchByHost("ACME.example.com", db).ID)
+	assert.Nil(t, tc.Err())
+
+	mock.ExpectQuery(query + `\[Host] = \?`).
+		WithArgs("other.example.com").
+		WillReturnRows(sqlmock.NewRows([]string{"TenantID", "Name", "Host", "PasswordMinLength", "PasswordMaxAge"}))
+	tc = new(TenantClient)
+	assert.Nil(t, tc.FetchByHost("other.example.com", db))
+	assert.Nil(t, tc.Err())
+
+	mock.ExpectQuery(query + `\[TenantID] = \?`).
+		WithArgs("missing").
+		WillReturnRows(sqlmock.NewRows([]string{"TenantID", "Name", "Host", "PasswordMinLength", "PasswordMaxAge"}))
+	tc = new(TenantClient)
+	assert.Nil(t, tc.Fetch("missing", db))
+	assert.Nil(t, tc.Err())
+
+	if err = mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Expectations were not met. ERROR: %v\n", err)
+	}
+}
+
+func Test_Save(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("An error occured when opening a stub database connection. ERROR: %v\n", err)
+	}
+	defer db.Close()
+
+	cases := []struct {
+		affected int64
+		err      error
+		expect   error
+	}{
+		{1, nil, nil},
+		{0, nil, nil},
+		{0, errors.New("Cannot insert duplicate key row in object 'auth.Tenants' with unique index 'UQ_Tenants_Host'."), ErrorHostInUse},
+	}
+	for i, c := range cases {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			mock.ExpectExec(`UPDATE \[auth]\.\[Tenants] SET \[Name] = \?, \[Host] = \?, \[PasswordMinLength] = \?, \[PasswordMaxAge] = \? WHERE \[TenantID] = \?`).
+				WithArgs("Acme", "acme.example.com", 12, 3600, tTenant).
+				WillReturnResult(sqlmock.NewResult(0, c.affected))
+			if c.affected == 0 {
+				exec := mock.ExpectExec(`INSERT INTO \[auth]\.\[Tenants] \(\[TenantID],\[Name],\[Host],\[PasswordMinLength],\[PasswordMaxAge]\) VALUES \(\?,\?,\?,\?,\?\)`).
+					WithArgs(tTenant, "Acme", "acme.example.com", 12, 3600)
+				if c.err != nil {
+					exec.WillReturnError(c.err)
+				} else {
+					exec.WillReturnResult(sqlmock.NewResult(0, 1))
+				}
+			}
+
+			tc := new(TenantClient)
+			tc.Save(&Tenant{ID: tTenant, Name: "Acme", Host: "Acme.Example.com", PasswordMinLength: 12, PasswordMaxAge: time.Hour}, db)
+			assert.Equal(t, c.expect, tc.Err())
+
+			if err = mock.ExpectationsWereMet(); err != nil {
+				t.Errorf("Expectations were not met. ERROR: %v\n", err)
+			}
+		})
+	}
+
+	t.Run(strconv.Itoa(len(cases)), func(t *testing.T) {
+		tc := new(TenantClient)
+		tc.Save(&Tenant{ID: "Acme"}, db)
+		assert.Equal(t, ErrorTenantInvalid, tc.Err())
+	})
+}
+
+func Test_List(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("An error occured when opening a stub database connection. ERROR: %v\n", err)
+	}
+	defer db.Close()
+
+	mock.ExpectQuery(`SELECT \[TenantID], \[Name], \[Host], \[PasswordMinLength], \[PasswordMaxAge] FROM \[auth]\.\[Tenants] ORDER BY \[TenantID]`).
+		WillReturnRows(sqlmock.NewRows([]string{"TenantID", "Name", "Host", "PasswordMinLength", "PasswordMaxAge"}).
+			AddRow(tTenant, "Acme", "acme.example.com", 12, 0).
+			AddRow(Default, "Default", nil, 0, 0))
+
+	tc := new(TenantClient)
+	tenants := tc.List(db)
+	assert.Nil(t, tc.Err())
+	assert.Equal(t, []*Tenant{
+		&Tenant{ID: tTenant, Name: "Acme", Host: "acme.example.com", PasswordMinLength: 12},
+		&Tenant{ID: Default, Name: "Default"},
+	}, tenants)
+
+	if err = mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Expectations were not met. ERROR: %v\n", err)
+	}
+}
@@ -0,0 +1,413 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	sq "github.com/Masterminds/squirrel"
+	"github.com/dgrijalva/jwt-go"
+	"github.com/penutty/authservice/tenant"
+	"github.com/penutty/authservice/user"
+	"github.com/penutty/authservice/verification"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+var (
+	// tTenant is served at tTenantHost; tEmptyTenant, served at tEmptyTenantHost, has no users.
+	tTenant          = "acme"
+	tTenantHost      = "acme.example.com"
+	tEmptyTenant     = "beta"
+	tEmptyTenantHost = "beta.example.com"
+)
+
+type MockTenantClient struct {
+	err   error
+	saved *tenant.Tenant
+}
+
+func (m *MockTenantClient) tenants() []*tenant.Tenant {
+	return []*tenant.Tenant{
+		&tenant.Tenant{ID: tTenant, Name: "Acme", Host: tTenantHost, PasswordMinLength: 20, PasswordMaxAge: 24 * time.Hour},
+		&tenant.Tenant{ID: tEmptyTenant, Name: "Beta", Host: tEmptyTenantHost},
+		&tenant.Tenant{ID: tenant.Default, Name: "Default"},
+	}
+}
+
+func (m *MockTenantClient) Fetch(id string, db sq.BaseRunner) *tenant.Tenant {
+	if m.err != nil {
+		return nil
+	}
+	for _, t := range m.tenants() {
+		if t.ID == id {
+			return t
+		}
+	}
+	return nil
+}
+
+func (m *MockTenantClient) FetchByHost(host string, db sq.BaseRunner) *tenant.Tenant {
+	if m.err != nil {
+		return nil
+	}
+	for _, t := range m.tenants() {
+		if t.Host != "" && t.Host == host {
+			return t
+		}
+	}
+	return nil
+}
+
+func (m *MockTenantClient) Save(t *tenant.Tenant, db sq.BaseRunner) {
+	if err := t.Check(); err != nil {
+		m.err = err
+		return
+	}
+	m.saved = t
+}
+
+func (m *MockTenantClient) List(db sq.BaseRunner) []*tenant.Tenant {
+	return m.tenants()
+}
+
+func (m *MockTenantClient) Err() error {
+	return m.err
+}
+
+// tenantKeys generates a key pair for each of tenants in a temporary TenantKeyDir and verification.TenantKeyDir.
+// The returned function restores both.
+func tenantKeys(t *testing.T, tenants ...string) func() {
+	dir, err := ioutil.TempDir("", "tenants")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range tenants {
+		key, err := rsa.GenerateKey(rand.Reader, 2048)
+		if err != nil {
+			t.Fatal(err)
+		}
+		pub, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := os.MkdirAll(filepath.Join(dir, id), 0700); err != nil {
+			t.Fatal(err)
+		}
+		priv := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+		if err := ioutil.WriteFile(filepath.Join(dir, id, "jwt_private.pem"), priv, 0600); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(filepath.Join(dir, id, "jwt_public.pem"), pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: pub}), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	keyDir, publicKeyDir := TenantKeyDir, verification.TenantKeyDir
+	TenantKeyDir, verification.TenantKeyDir = dir, dir
+	return func() {
+		TenantKeyDir, verification.TenantKeyDir = keyDir, publicKeyDir
+		os.RemoveAll(dir)
+	}
+}
+
+func newTenantApp() *app {
+	a := new(app)
+	a.rb = new(MockRBACClient)
+	a.g = new(MockGroupClient)
+	a.ss = NewMockSessionClient()
+	a.c = new(MockUserClient)
+	a.m = new(MockMFAClient)
+	a.l = NewMockLockoutClient()
+	a.h = new(MockHistoryClient)
+	a.au = new(MockAuditClient)
+	a.t = new(MockTenantClient)
+	return a
+}
+
+func Test_requestTenant(t *testing.T) {
+	a := newTenantApp()
+
+	type test struct {
+		target string
+		host   string
+		tenant string
+		path   string
+		err    error
+	}
+	cases := []*test{
+		&test{"/t/" + tTenant + AuthEndpoint, "", tTenant, AuthEndpoint, nil},
+		&test{"/t/" + tenant.Default + AuthEndpoint, tTenantHost, tenant.Default, AuthEndpoint, nil},
+		&test{AuthEndpoint, tTenantHost, tTenant, AuthEndpoint, nil},
+		&test{AuthEndpoint, tTenantHost + ":8080", tTenant, AuthEndpoint, nil},
+		&test{AuthEndpoint, "other.example.com", tenant.Default, AuthEndpoint, nil},
+		&test{"/t/missing" + AuthEndpoint, "", "", "", tenant.ErrorTenantNotFound},
+		&test{"/t/" + tTenant, "", "", "", tenant.ErrorTenantNotFound},
+		&test{"/t/Acme" + AuthEndpoint, "", "", "", tenant.ErrorTenantNotFound},
+	}
+	for i, c := range cases {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			a.t = new(MockTenantClient)
+			r := httptest.NewRequest(http.MethodPost, c.target, nil)
+			r.Host = c.host
+			tn, err := a.requestTenant(r)
+			assert.Equal(t, c.err, err)
+			if err != nil {
+				return
+			}
+			assert.Equal(t, c.tenant, tn.ID)
+			assert.Equal(t, c.path, r.URL.Path)
+		})
+	}
+
+	// An unknown tenant does not affect the requests resolved after it.
+	a.t = new(MockTenantClient)
+	_, err := a.requestTenant(httptest.NewRequest(http.MethodPost, "/t/missing"+AuthEndpoint, nil))
+	assert.Equal(t, tenant.ErrorTenantNotFound, err)
+	tn, err := a.requestTenant(httptest.NewRequest(http.MethodPost, "/t/"+tTenant+AuthEndpoint, nil))
+	assert.Nil(t, err)
+	assert.Equal(t, tTenant, tn.ID)
+
+	rec := httptest.NewRecorder()
+	a.t = new(MockTenantClient)
+	a.resolveTenant(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, tTenant, tenantOf(r).ID)
+		assert.Equal(t, user.Qualify(tTenant, tUser), qualify(r, tUser))
+	})).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/t/"+tTenant+UserEndpoint, nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	assert.Equal(t, tenant.Default, tenantOf(httptest.NewRequest(http.MethodGet, UserEndpoint, nil)).ID)
+}
+
+// tenantRequest returns a request to target served at host, resolved to its tenant by a.
+func tenantRequest(a *app, method, target, host string, body []byte) *http.Request {
+	r := httptest.NewRequest(method, target, bytes.NewReader(body))
+	r.Host = host
+	var resolved *http.Request
+	a.resolveTenant(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		resolved = r
+	})).ServeHTTP(httptest.NewRecorder(), r)
+	return resolved
+}
+
+func Test_tenantIsolation(t *testing.T) {
+	defer tenantKeys(t, tTenant, tEmptyTenant)()
+
+	a := newTenantApp()
+	login := func(host, userID string) *httptest.ResponseRecorder {
+		a.c = new(MockUserClient)
+		b, _ := json.Marshal(map[string]string{"UserID": userID, "Password": tPassword})
+		rec := httptest.NewRecorder()
+		a.authHandler(rec, tenantRequest(a, http.MethodPost, AuthEndpoint, host, b))
+		return rec
+	}
+
+	// A user of acme logs in at the host of acme and gets a token of acme.
+	rec := login(tTenantHost, tUser)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	acmeToken := rec.Header().Get("jwt")
+	claims, err := verification.Parse(acmeToken)
+	assert.Nil(t, err)
+	assert.Equal(t, tTenant, claims["tid"])
+	assert.Equal(t, "Auth-Service/"+tTenant, claims["iss"])
+	assert.Equal(t, "id-"+tTenant+"/"+tUser, claims["sub"])
+
+	rec = login(tTenantHost, tTenant+"/"+tUser)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	// The user of acme cannot log in at the host of beta, which has no users, nor name it in its UserID.
+	rec = login(tEmptyTenantHost, tUser)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	assert.Empty(t, rec.Header().Get("jwt"))
+	rec = login(tEmptyTenantHost, tTenant+"/"+tUser)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	rec = login("", tTenant+"/"+tUser)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	assert.Empty(t, rec.Header().Get("jwt"))
+
+	a.c = new(MockUserClient)
+	defaultToken, _, err := a.accessToken(a.c.Fetch(tUser, nil), NewAuthRequest())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Tokens are only accepted by their own tenant.
+	type test struct {
+		token string
+		host  string
+		code  int
+	}
+	cases := []*test{
+		&test{acmeToken, tTenantHost, http.StatusOK},
+		&test{acmeToken, tEmptyTenantHost, http.StatusUnauthorized},
+		&test{acmeToken, "", http.StatusUnauthorized},
+		&test{defaultToken, "", http.StatusOK},
+		&test{defaultToken, tTenantHost, http.StatusUnauthorized},
+	}
+	for i, c := range cases {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			a.c = new(MockUserClient)
+			r := tenantRequest(a, http.MethodGet, UserEndpoint, c.host, nil)
+			r.Header.Set("Authorization", "Bearer "+c.token)
+			rec := httptest.NewRecorder()
+			a.userHandler(rec, r)
+			assert.Equal(t, c.code, rec.Code)
+		})
+	}
+
+	// A token of acme cannot be passed off as one of beta: it is not signed with the key of beta.
+	p, err := ioutil.ReadFile(filepath.Join(TenantKeyDir, tTenant, "jwt_private.pem"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := jwt.ParseRSAPrivateKeyFromPEM(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims["tid"], claims["iss"] = tEmptyTenant, "Auth-Service/"+tEmptyTenant
+	forged, err := jwt.NewWithClaims(jwt.SigningMethodRS256, claims).SignedString(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = verification.Parse(forged)
+	assert.NotNil(t, err)
+
+	// A subject whose tenant differs from the "tid" of the token is refused.
+	_, err = a.subject(map[string]interface{}{"sub": tID, "tid": tTenant})
+	assert.Equal(t, ErrorTenantMismatch, err)
+}
+
+func Test_checkTenantPassword(t *testing.T) {
+	defer tenantKeys(t, tTenant)()
+
+	a := newTenantApp()
+	acme := a.t.Fetch(tTenant, nil)
+	assert.Equal(t, user.ErrorPasswordShort, checkTenantPassword(acme, tPassword))
+	assert.Nil(t, checkTenantPassword(acme, tPassword+"Longer1!"))
+	assert.Nil(t, checkTenantPassword(defaultTenant, tPassword))
+
+	assert.Equal(t, user.ErrorPasswordShort, a.setPassword(tTenant+"/"+tUser, "NewPassword123!"))
+	assert.Nil(t, a.setPassword(tUser, "NewPassword123!"))
+
+	b, _ := json.Marshal(map[string]string{"UserID": tUser, "Email": tEmail, "Password": tPassword})
+	rec := httptest.NewRecorder()
+	a.userHandler(rec, tenantRequest(a, http.MethodPost, UserEndpoint, tTenantHost, b))
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+}
+
+func Test_tenantsHandler(t *testing.T) {
+	defer func(admins []string) { Administrators = admins }(Administrators)
+	defer tenantKeys(t, tTenant)()
+	Administrators = administrators(tUser, tTenant+"/"+tUser)
+
+	a := newTenantApp()
+	acmeToken, _, err := a.accessToken(a.c.Fetch(tTenant+"/"+tUser, nil), NewAuthRequest())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defaultToken, _, err := a.accessToken(a.c.Fetch(tUser, nil), NewAuthRequest())
+	if err != nil {
+		t.Fatal(err)
+	}
+	body := func(v interface{}) []byte {
+		b, _ := json.Marshal(v)
+		return b
+	}
+
+	type test struct {
+		method string
+		token  string
+		host   string
+		body   []byte
+		code   int
+	}
+	cases := []*test{
+		&test{http.MethodGet, defaultToken, "", nil, http.StatusOK},
+		&test{http.MethodPut, defaultToken, "", body(&tenantResource{ID: "gamma", Host: "gamma.example.com", PasswordMinLength: 16, PasswordMaxAge: "720h"}), http.StatusOK},
+		&test{http.MethodPut, defaultToken, "", body(&tenantResource{ID: "Gamma"}), http.StatusBadRequest},
+		&test{http.MethodPut, defaultToken, "", body(&tenantResource{ID: "gamma", PasswordMaxAge: "a month"}), http.StatusBadRequest},
+		&test{http.MethodPut, defaultToken, "", body(&tenantResource{ID: tenant.Default, PasswordMinLength: 16}), http.StatusBadRequest},
+		&test{http.MethodGet, acmeToken, tTenantHost, nil, http.StatusForbidden},
+		&test{http.MethodGet, acmeToken, "", nil, http.StatusUnauthorized},
+		&test{http.MethodDelete, defaultToken, "", nil, http.StatusNotImplemented},
+	}
+	for i, c := range cases {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			tc := new(MockTenantClient)
+			au := new(MockAuditClient)
+			a.t, a.au = tc, au
+			r := tenantRequest(a, c.method, TenantsEndpoint, c.host, c.body)
+			r.Header.Set("Authorization", "Bearer "+c.token)
+			rec := httptest.NewRecorder()
+			a.tenantsHandler(rec, r)
+			assert.Equal(t, c.code, rec.Code)
+			if c.code != http.StatusOK {
+				return
+			}
+
+			if c.method == http.MethodGet {
+				var res []*tenantResource
+				assert.Nil(t, json.NewDecoder(rec.Body).Decode(&res))
+				assert.Len(t, res, 3)
+				assert.Equal(t, "24h0m0s", res[0].PasswordMaxAge)
+				return
+			}
+			assert.Equal(t, &tenant.Tenant{ID: "gamma", Host: "gamma.example.com", PasswordMinLength: 16, PasswordMaxAge: 720 * time.Hour}, tc.saved)
+			assert.Len(t, au.entries, 1)
+			assert.Equal(t, tID, au.entries[0].Actor)
+			assert.Equal(t, "gamma", au.entries[0].Subject)
+			assert.Equal(t, "tenant_save", au.entries[0].Action)
+		})
+	}
+}
+
+func Test_defaultAdministrator(t *testing.T) {
+	defer func(admins []string) { Administrators = admins }(Administrators)
+	defer tenantKeys(t, tTenant)()
+	Administrators = administrators(tUser, tTenant+"/"+tUser)
+
+	a := newTenantApp()
+	a.rb = NewMockRBACClient()
+	a.g = NewMockGroupClient()
+	acmeToken, _, err := a.accessToken(a.c.Fetch(tTenant+"/"+tUser, nil), NewAuthRequest())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defaultToken, _, err := a.accessToken(a.c.Fetch(tUser, nil), NewAuthRequest())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Roles and groups are shared by every tenant and only managed by administrators of the default tenant.
+	type test struct {
+		handler  http.HandlerFunc
+		endpoint string
+		token    string
+		host     string
+		code     int
+	}
+	cases := []*test{
+		&test{a.rolesHandler, RolesEndpoint, defaultToken, "", http.StatusOK},
+		&test{a.rolesHandler, RolesEndpoint, acmeToken, tTenantHost, http.StatusForbidden},
+		&test{a.groupsHandler, GroupsEndpoint, defaultToken, "", http.StatusOK},
+		&test{a.groupsHandler, GroupsEndpoint, acmeToken, tTenantHost, http.StatusForbidden},
+	}
+	for i, c := range cases {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			r := tenantRequest(a, http.MethodGet, c.endpoint, c.host, nil)
+			r.Header.Set("Authorization", "Bearer "+c.token)
+			rec := httptest.NewRecorder()
+			c.handler(rec, r)
+			assert.Equal(t, c.code, rec.Code)
+		})
+	}
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"github.com/dgrijalva/jwt-go"
+	"github.com/penutty/authservice/exchange"
+	"github.com/penutty/authservice/serviceaccount"
+	"github.com/penutty/authservice/user"
+	"github.com/penutty/authservice/verification"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Token exchange grant and token type identifiers defined by RFC 8693.
+const (
+	GrantTypeTokenExchange = "urn:ietf:params:oauth:grant-type:token-exchange"
+	TokenTypeAccessToken   = "urn:ietf:params:oauth:token-type:access_token"
+	TokenTypeJWT           = "urn:ietf:params:oauth:token-type:jwt"
+)
+
+var (
+	ErrorGrantTypeUnsupported    = errors.New("Form value \"grant_type\" is not supported.")
+	ErrorClientUnauthenticated   = errors.New("Client must authenticate with HTTP Basic authentication.")
+	ErrorSubjectTokenMissing     = errors.New("Form value \"subject_token\" is required.")
+	ErrorSubjectTokenTypeInvalid = errors.New("Form value \"subject_token_type\" is not supported.")
+	ErrorAudienceMissing         = errors.New("Form value \"audience\" is required.")
+)
+
+// tokenResponse is the successful response body of TokenEndpoint.
+type tokenResponse struct {
+	AccessToken     string `json:"access_token"`
+	IssuedTokenType string `json:"issued_token_type,omitempty"`
+	TokenType       string `json:"token_type"`
+	ExpiresIn       int64  `json:"expires_in"`
+	Scope           string `json:"scope,omitempty"`
+	RefreshToken    string `json:"refresh_token,omitempty"`
+}
+
+func (a *app) tokenHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodPost:
+		resp, err := a.postToken(r)
+		if err != nil {
+			tokenErrorHandler(w, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		json.NewEncoder(w).Encode(resp)
+	default:
+		logger(Error).Println(ErrorMethodNotImplemented)
+		http.Error(w, http.StatusText(http.StatusNotImplemented), http.StatusNotImplemented)
+	}
+}
+
+// tokenErrorHandler writes err as an OAuth 2.0 error response.
+func tokenErrorHandler(w http.ResponseWriter, err error) {
+	logger(Error).Println(err)
+
+	code, status := "invalid_request", http.StatusBadRequest
+	switch err {
+	case ErrorGrantTypeUnsupported:
+		code = "unsupported_grant_type"
+	case ErrorClientUnauthenticated, exchange.ErrorClientSecretInvalid, exchange.ErrorClientIDParameterInvalid, sql.ErrNoRows,
+		serviceaccount.ErrorCredentialInvalid, serviceaccount.ErrorAccountNotFound, serviceaccount.ErrorAccountDisabled:
+		code, status = "invalid_client", http.StatusUnauthorized
+		w.Header().Set("WWW-Authenticate", "Basic realm=\"Auth-Service\"")
+	case exchange.ErrorAudienceNotPermitted:
+		code = "invalid_target"
+	case exchange.ErrorScopeNotPermitted, serviceaccount.ErrorScopeNotPermitted:
+		code = "invalid_scope"
+	case ErrorSubjectTokenMissing, ErrorSubjectTokenTypeInvalid, ErrorAudienceMissing, ErrorTokenMissing, ErrorAssertionMissing,
+		ErrorRefreshTokenMissing:
+		code = "invalid_request"
+	default:
+		code = "invalid_grant"
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"error": code})
+}
+
+func (a *app) postToken(r *http.Request) (*tokenResponse, error) {
+	if err := r.ParseForm(); err != nil {
+		return nil, err
+	}
+
+	switch r.PostForm.Get("grant_type") {
+	case GrantTypeTokenExchange:
+		return a.exchangeToken(r)
+	case GrantTypeClientCredentials:
+		return a.clientCredentialsGrant(r)
+	case GrantTypeJWTBearer:
+		return a.jwtBearerGrant(r)
+	case GrantTypeRefreshToken:
+		return a.refreshGrant(r)
+	default:
+		return nil, ErrorGrantTypeUnsupported
+	}
+}
+
+// authenticateClient returns the exchange policy of the client authenticating r with HTTP Basic authentication.
+func (a *app) authenticateClient(r *http.Request) (*exchange.Policy, error) {
+	clientID, secret, ok := r.BasicAuth()
+	if !ok {
+		return nil, ErrorClientUnauthenticated
+	}
+
+	p := a.x.Fetch(clientID, user.AuthDB())
+	if err := a.x.Err(); err != nil {
+		return nil, err
+	}
+	if err := p.Authenticate(secret); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// exchangeToken implements the RFC 8693 token exchange grant. The subject token must be an access token
+// issued by Auth-Service for Moment-Service and the tenant of the calling client; the issued token is narrowed to the requested
+// audience and scopes permitted by the client's exchange policy and identifies the client in its "act" claim.
+func (a *app) exchangeToken(r *http.Request) (*tokenResponse, error) {
+	p, err := a.authenticateClient(r)
+	if err != nil {
+		return nil, err
+	}
+
+	subjectToken := r.PostForm.Get("subject_token")
+	if subjectToken == "" {
+		return nil, ErrorSubjectTokenMissing
+	}
+	switch r.PostForm.Get("subject_token_type") {
+	case TokenTypeAccessToken, TokenTypeJWT:
+	default:
+		return nil, ErrorSubjectTokenTypeInvalid
+	}
+
+	audience := r.PostForm.Get("audience")
+	if audience == "" {
+		return nil, ErrorAudienceMissing
+	}
+
+	subject, err := parseAccessToken(subjectToken)
+	if err != nil {
+		return nil, err
+	}
+	if verification.Tenant(subject) != p.TenantID() {
+		return nil, ErrorTenantMismatch
+	}
+	u, err := a.subject(subject)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkStatus(u); err != nil {
+		return nil, err
+	}
+
+	var held []string
+	if s, ok := subject["scope"].(string); ok {
+		held = strings.Fields(s)
+	}
+	scopes, lifetime, err := p.Narrow(audience, strings.Fields(r.PostForm.Get("scope")), held)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UTC()
+	exp := now.Add(lifetime).Unix()
+	if e, ok := subject["exp"].(float64); ok && int64(e) < exp {
+		exp = int64(e)
+	}
+
+	act := map[string]interface{}{"sub": p.ClientID()}
+	if prior, ok := subject["act"]; ok {
+		act["act"] = prior
+	}
+
+	claims := jwt.MapClaims{
+		"iss":       verification.IssuerFor(p.TenantID()),
+		"sub":       subject["sub"],
+		"aud":       audience,
+		"exp":       exp,
+		"iat":       issuedAt(now),
+		"act":       act,
+		"client_id": p.ClientID(),
+	}
+	if len(scopes) > 0 {
+		claims["scope"] = strings.Join(scopes, " ")
+	}
+	for _, name := range []string{"tid", "sid", "roles", "permissions", "groups", "_claim_names", "_claim_sources"} {
+		if v, ok := subject[name]; ok {
+			claims[name] = v
+		}
+	}
+
+	token, err := signJwt(claims)
+	if err != nil {
+		return nil, err
+	}
+
+	return &tokenResponse{
+		AccessToken:     token,
+		IssuedTokenType: TokenTypeAccessToken,
+		TokenType:       "Bearer",
+		ExpiresIn:       exp - now.Unix(),
+		Scope:           strings.Join(scopes, " "),
+	}, nil
+}
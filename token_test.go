@@ -0,0 +1,240 @@
+package main
+
+import (
+	"encoding/json"
+	sq "github.com/Masterminds/squirrel"
+	"github.com/dgrijalva/jwt-go"
+	"github.com/penutty/authservice/exchange"
+	"github.com/penutty/authservice/user"
+	"github.com/penutty/authservice/verification"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+var (
+	tClientID = "moment-service"
+	tSecret   = "TestSecret123!"
+	tAudience = "Media-Service"
+)
+
+type MockExchangeClient struct {
+	err      error
+	tenantID string
+}
+
+func (m *MockExchangeClient) Fetch(clientID string, db sq.BaseRunner) *exchange.Policy {
+	p := exchange.NewPolicy(tClientID, tSecret,
+		&exchange.Rule{Audience: tAudience, Scopes: []string{"media.read", "media.write"}, Lifetime: time.Minute},
+		&exchange.Rule{Audience: "Moment-Service", Scopes: []string{"media.read", "media.write"}, Lifetime: time.Minute})
+	if m.tenantID != "" {
+		p.SetTenantID(m.tenantID)
+	}
+	return p
+}
+
+func (m *MockExchangeClient) Err() error {
+	return m.err
+}
+
+func NewExchangeRequest(clientID, secret string, form url.Values) *http.Request {
+	r := httptest.NewRequest(http.MethodPost, TokenEndpoint, strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if clientID != "" {
+		r.SetBasicAuth(clientID, secret)
+	}
+	return r
+}
+
+func NewExchangeForm(subjectToken, audience, scope string) url.Values {
+	return url.Values{
+		"grant_type":         {GrantTypeTokenExchange},
+		"subject_token":      {subjectToken},
+		"subject_token_type": {TokenTypeAccessToken},
+		"audience":           {audience},
+		"scope":              {scope},
+	}
+}
+
+func Test_tokenHandler(t *testing.T) {
+	a := new(app)
+	a.rb = new(MockRBACClient)
+	a.g = new(MockGroupClient)
+	a.ss = NewMockSessionClient()
+	a.c = new(MockUserClient)
+	a.x = new(MockExchangeClient)
+
+	subject, err := generateJwt(tID, nil, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	badGrant := NewExchangeForm(subject, tAudience, "")
+	badGrant.Set("grant_type", "password")
+
+	testVars := []*RequestCodePair{
+		&RequestCodePair{NewExchangeRequest(tClientID, tSecret, NewExchangeForm(subject, tAudience, "media.read")), http.StatusOK},
+		&RequestCodePair{NewExchangeRequest("", "", NewExchangeForm(subject, tAudience, "")), http.StatusUnauthorized},
+		&RequestCodePair{NewExchangeRequest(tClientID, "wrong", NewExchangeForm(subject, tAudience, "")), http.StatusUnauthorized},
+		&RequestCodePair{NewExchangeRequest(tClientID, tSecret, badGrant), http.StatusBadRequest},
+		&RequestCodePair{NewExchangeRequest(tClientID, tSecret, NewExchangeForm("not.a.token", tAudience, "")), http.StatusBadRequest},
+		&RequestCodePair{NewExchangeRequest(tClientID, tSecret, NewExchangeForm(subject, "Other-Service", "")), http.StatusBadRequest},
+		&RequestCodePair{NewExchangeRequest(tClientID, tSecret, NewExchangeForm(subject, tAudience, "media.delete")), http.StatusBadRequest},
+		&RequestCodePair{httptest.NewRequest(http.MethodGet, TokenEndpoint, nil), http.StatusNotImplemented},
+	}
+
+	for i, v := range testVars {
+		rec := httptest.NewRecorder()
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			a.tokenHandler(rec, v.req)
+			assert.Equal(t, v.code, rec.Code)
+		})
+	}
+}
+
+func Test_exchangeToken(t *testing.T) {
+	a := new(app)
+	a.rb = new(MockRBACClient)
+	a.g = new(MockGroupClient)
+	a.ss = NewMockSessionClient()
+	a.c = new(MockUserClient)
+	a.x = new(MockExchangeClient)
+
+	subject, err := generateJwt(tID, []string{tRole}, []string{"moments:read"}, jwt.MapClaims{"groups": []string{tGroup}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("1", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		a.tokenHandler(rec, NewExchangeRequest(tClientID, tSecret, NewExchangeForm(subject, tAudience, "media.read")))
+		assert.Equal(t, http.StatusOK, rec.Code)
+
+		resp := new(tokenResponse)
+		if err := json.NewDecoder(rec.Body).Decode(resp); err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, TokenTypeAccessToken, resp.IssuedTokenType)
+		assert.Equal(t, "media.read", resp.Scope)
+		assert.True(t, resp.ExpiresIn <= 60)
+
+		claims, err := verification.ParseAudience(resp.AccessToken, tAudience)
+		assert.Nil(t, err)
+		assert.Equal(t, tID, claims["sub"])
+		assert.Equal(t, "media.read", claims["scope"])
+		assert.Equal(t, map[string]interface{}{"sub": tClientID}, claims["act"])
+		assert.Equal(t, []string{tRole}, verification.Roles(claims))
+		assert.Nil(t, verification.Check(claims, "moments:read"))
+		assert.Equal(t, []string{tGroup}, verification.Groups(claims))
+	})
+
+	t.Run("2", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		a.tokenHandler(rec, NewExchangeRequest(tClientID, tSecret, NewExchangeForm(subject, "Moment-Service", "")))
+		resp := new(tokenResponse)
+		if err := json.NewDecoder(rec.Body).Decode(resp); err != nil {
+			t.Fatal(err)
+		}
+
+		rec = httptest.NewRecorder()
+		a.tokenHandler(rec, NewExchangeRequest(tClientID, tSecret, NewExchangeForm(resp.AccessToken, tAudience, "media.read")))
+		assert.Equal(t, http.StatusOK, rec.Code)
+		if err := json.NewDecoder(rec.Body).Decode(resp); err != nil {
+			t.Fatal(err)
+		}
+
+		claims, err := verification.Parse(resp.AccessToken)
+		assert.Nil(t, err)
+		act, ok := claims["act"].(map[string]interface{})
+		assert.True(t, ok)
+		assert.Equal(t, map[string]interface{}{"sub": tClientID}, act["act"])
+	})
+
+	t.Run("3", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		a.tokenHandler(rec, NewExchangeRequest(tClientID, tSecret, NewExchangeForm(subject, "Moment-Service", "media.read")))
+		resp := new(tokenResponse)
+		if err := json.NewDecoder(rec.Body).Decode(resp); err != nil {
+			t.Fatal(err)
+		}
+
+		rec = httptest.NewRecorder()
+		a.tokenHandler(rec, NewExchangeRequest(tClientID, tSecret, NewExchangeForm(resp.AccessToken, tAudience, "media.write")))
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+		assert.Contains(t, rec.Body.String(), "invalid_scope")
+	})
+
+	t.Run("4", func(t *testing.T) {
+		a.c = &MockUserClient{status: user.StatusSuspended}
+		defer func() { a.c = new(MockUserClient) }()
+
+		rec := httptest.NewRecorder()
+		a.tokenHandler(rec, NewExchangeRequest(tClientID, tSecret, NewExchangeForm(subject, tAudience, "media.read")))
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+		assert.Contains(t, rec.Body.String(), "invalid_grant")
+	})
+
+	// Only access tokens for Moment-Service are exchanged: neither tokens narrowed to another audience nor
+	// purpose tokens such as MFA challenges.
+	t.Run("5", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		a.tokenHandler(rec, NewExchangeRequest(tClientID, tSecret, NewExchangeForm(subject, tAudience, "")))
+		resp := new(tokenResponse)
+		if err := json.NewDecoder(rec.Body).Decode(resp); err != nil {
+			t.Fatal(err)
+		}
+		challenge, err := generateChallenge(tID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		purpose, err := generateJwt(tID, nil, nil, jwt.MapClaims{"typ": "login_link"})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for _, token := range []string{resp.AccessToken, challenge, purpose} {
+			rec = httptest.NewRecorder()
+			a.tokenHandler(rec, NewExchangeRequest(tClientID, tSecret, NewExchangeForm(token, tAudience, "")))
+			assert.Equal(t, http.StatusBadRequest, rec.Code)
+			assert.Contains(t, rec.Body.String(), "invalid_grant")
+		}
+	})
+}
+
+func Test_exchangeToken_tenant(t *testing.T) {
+	defer tenantKeys(t, tTenant)()
+
+	a := new(app)
+	a.rb = new(MockRBACClient)
+	a.g = new(MockGroupClient)
+	a.ss = NewMockSessionClient()
+	a.c = new(MockUserClient)
+	subject, _, err := a.accessToken(a.c.Fetch(tTenant+"/"+tUser, nil), NewAuthRequest())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A client of the default tenant cannot exchange tokens of acme.
+	a.x = new(MockExchangeClient)
+	rec := httptest.NewRecorder()
+	a.tokenHandler(rec, NewExchangeRequest(tClientID, tSecret, NewExchangeForm(subject, tAudience, "")))
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	a.x = &MockExchangeClient{tenantID: tTenant}
+	rec = httptest.NewRecorder()
+	a.tokenHandler(rec, NewExchangeRequest(tClientID, tSecret, NewExchangeForm(subject, tAudience, "")))
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	resp := new(tokenResponse)
+	if err := json.NewDecoder(rec.Body).Decode(resp); err != nil {
+		t.Fatal(err)
+	}
+	claims, err := verification.ParseAudience(resp.AccessToken, tAudience)
+	assert.Nil(t, err)
+	assert.Equal(t, tTenant, claims["tid"])
+	assert.Equal(t, "Auth-Service/"+tTenant, claims["iss"])
+}
@@ -0,0 +1,132 @@
+package user
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/binary"
+	"fmt"
+	sq "github.com/Masterminds/squirrel"
+	"log"
+	"os"
+	"time"
+)
+
+// RenameGracePeriod is how long a UserID given up by a rename stays reserved for its former user.
+var RenameGracePeriod = renameGracePeriod(os.Getenv("RenameGracePeriod"))
+
+func renameGracePeriod(s string) time.Duration {
+	if d, err := time.ParseDuration(s); err == nil && d >= 0 {
+		return d
+	}
+	return 30 * 24 * time.Hour
+}
+
+// NewID returns a new user ID: a UUIDv7 as defined by RFC 9562, which starts with the Unix time in
+// milliseconds so that IDs sort by creation, followed by random bits.
+func NewID() (string, error) {
+	var b [16]byte
+	if _, err := rand.Read(b[6:]); err != nil {
+		return "", err
+	}
+	var ms [8]byte
+	binary.BigEndian.PutUint64(ms[:], uint64(time.Now().UnixNano()/int64(time.Millisecond)))
+	copy(b[:6], ms[2:])
+	b[6] = 0x70 | b[6]&0x0f
+	b[8] = 0x80 | b[8]&0x3f
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16]), nil
+}
+
+type IDFetcher interface {
+	FetchByID(string, sq.BaseRunner) *User
+}
+
+type Renamer interface {
+	Rename(*User, string, sq.BaseRunner)
+}
+
+// FetchByID selects the row of the user with ID id from the user.Users table in db.
+func (uc *UserClient) FetchByID(id string, db sq.BaseRunner) (u *User) {
+	if uc.err != nil {
+		return
+	}
+
+	return uc.fetch(sq.Eq{"[ID]": id}, db)
+}
+
+// Rename changes the UserID of u to userID. The former UserID stays reserved for u for RenameGracePeriod;
+// userID may be one u gave up itself but not one another user gave up less than RenameGracePeriod ago.
+// userID must be qualified with the tenant of u.
+func (uc *UserClient) Rename(u *User, userID string, db sq.BaseRunner) {
+	if uc.err != nil {
+		return
+	}
+	enforced, err := EnforceUserID(userID)
+	if err != nil {
+		uc.err = err
+		return
+	}
+	if Tenant(enforced) != Tenant(u.userID) {
+		uc.err = ErrorTenantChange
+		return
+	}
+	if err := CheckReserved(enforced); err != nil {
+		uc.err = err
+		return
+	}
+	oldKey, key := NormalizeUserID(u.userID), NormalizeUserID(enforced)
+	if uc.checkRetired(key, u.id, db); uc.err != nil {
+		return
+	}
+
+	update := sq.Update("[auth].[Users]").
+		Set("[UserID]", enforced).
+		Set("[UserIDKey]", key).
+		Set("[UserIDSkeleton]", Skeleton(enforced)).
+		Where(sq.Eq{"[ID]": u.id})
+	res, err := update.RunWith(db).Exec()
+	if err != nil {
+		log.Print(err)
+		uc.err = conflict(err)
+		return
+	}
+	if cnt, err := res.RowsAffected(); err != nil || cnt != 1 {
+		log.Print(ErrorUserRowNotUpdated)
+		uc.err = ErrorUserRowNotUpdated
+		return
+	}
+	u.userID = enforced
+	if key == oldKey {
+		return
+	}
+
+	del := sq.Delete("[auth].[RetiredUserIDs]").Where(sq.Eq{"[UserIDKey]": []string{oldKey, key}})
+	if _, err := del.RunWith(db).Exec(); err != nil {
+		log.Print(err)
+		uc.err = err
+		return
+	}
+	insert := sq.Insert("[auth].[RetiredUserIDs]").
+		Columns("[UserIDKey]", "[ID]", "[Expires]").
+		Values(oldKey, u.id, time.Now().UTC().Add(RenameGracePeriod))
+	if _, err := insert.RunWith(db).Exec(); err != nil {
+		log.Print(err)
+		uc.err = err
+	}
+}
+
+// checkRetired fails with ErrorUserExists if key is the lookup key of a UserID a user other than id
+// gave up less than RenameGracePeriod ago.
+func (uc *UserClient) checkRetired(key, id string, db sq.BaseRunner) {
+	var owner string
+	sel := sq.Select("[ID]").From("[auth].[RetiredUserIDs]").
+		Where(sq.Eq{"[UserIDKey]": key}).
+		Where(sq.Gt{"[Expires]": time.Now().UTC()})
+	switch err := sel.RunWith(db).QueryRow().Scan(&owner); {
+	case err == sql.ErrNoRows:
+	case err != nil:
+		log.Print(err)
+		uc.err = err
+	case owner != id:
+		uc.err = ErrorUserExists
+	}
+}
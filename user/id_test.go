@@ -0,0 +1,121 @@
+package user
+
+import (
+	"database/sql"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/DATA-DOG/go-sqlmock.v1"
+	"regexp"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func Test_renameGracePeriod(t *testing.T) {
+	assert.Equal(t, 30*24*time.Hour, renameGracePeriod(""))
+	assert.Equal(t, 30*24*time.Hour, renameGracePeriod("-1h"))
+	assert.Equal(t, time.Hour, renameGracePeriod("1h"))
+}
+
+func Test_NewID(t *testing.T) {
+	r := regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-7[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`)
+	prev := ""
+	for i := 0; i < 10; i++ {
+		id, err := NewID()
+		assert.Nil(t, err)
+		assert.Regexp(t, r, id)
+		assert.NotEqual(t, prev, id)
+		if prev != "" {
+			assert.True(t, prev[:13] <= id[:13])
+		}
+		prev = id
+	}
+}
+
+func Test_FetchByID(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("An error occured when opening a stub database connection. ERROR: %v\n", err)
+	}
+	defer db.Close()
+
+	row := sqlmock.NewRows([]string{"ID", "UserID", "Email", "Password", "Verified", "DisplayName", "Locale", "PasswordChanged",
+		"Status", "StatusReason", "StatusChanged", "StatusUntil"}).
+		AddRow(tID, tUser, tEmail, tPassword, true, "", "", time.Now(), "active", "", time.Now(), nil)
+	mock.ExpectQuery(`SELECT .+ FROM \[auth]\.\[Users] WHERE \[ID] = \?`).
+		WithArgs(tID).
+		WillReturnRows(row)
+
+	uc := new(UserClient)
+	u := uc.FetchByID(tID, db)
+	assert.Nil(t, uc.Err())
+	assert.Equal(t, tID, u.ID())
+	assert.Equal(t, tUser, u.UserID())
+
+	if err = mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Expectations were not met. ERROR: %v\n", err)
+	}
+}
+
+func Test_Rename(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("An error occured when opening a stub database connection. ERROR: %v\n", err)
+	}
+	defer db.Close()
+
+	newUser := "renameduser"
+	cases := []struct {
+		userID  string
+		expect  func()
+		err     error
+		renamed string
+	}{
+		{newUser, func() {
+			mock.ExpectQuery(`SELECT \[ID] FROM \[auth]\.\[RetiredUserIDs] WHERE \[UserIDKey] = \? AND \[Expires] > \?`).
+				WithArgs(newUser, sqlmock.AnyArg()).
+				WillReturnError(sql.ErrNoRows)
+			mock.ExpectExec(`UPDATE \[auth]\.\[Users] SET \[UserID] = \?, \[UserIDKey] = \?, \[UserIDSkeleton] = \? WHERE \[ID] = \?`).
+				WithArgs(newUser, newUser, newUser, tID).
+				WillReturnResult(sqlmock.NewResult(0, 1))
+			mock.ExpectExec(`DELETE FROM \[auth]\.\[RetiredUserIDs] WHERE \[UserIDKey] IN \(\?,\?\)`).
+				WithArgs(tUser, newUser).
+				WillReturnResult(sqlmock.NewResult(0, 0))
+			mock.ExpectExec(`INSERT INTO \[auth]\.\[RetiredUserIDs] \(\[UserIDKey],\[ID],\[Expires]\) VALUES \(\?,\?,\?\)`).
+				WithArgs(tUser, tID, sqlmock.AnyArg()).
+				WillReturnResult(sqlmock.NewResult(0, 1))
+		}, nil, newUser},
+		{"TestUser", func() {
+			mock.ExpectQuery(`SELECT \[ID] FROM \[auth]\.\[RetiredUserIDs]`).
+				WillReturnRows(sqlmock.NewRows([]string{"ID"}).AddRow(tID))
+			mock.ExpectExec(`UPDATE \[auth]\.\[Users]`).
+				WillReturnResult(sqlmock.NewResult(0, 1))
+		}, nil, "TestUser"},
+		{newUser, func() {
+			mock.ExpectQuery(`SELECT \[ID] FROM \[auth]\.\[RetiredUserIDs]`).
+				WillReturnRows(sqlmock.NewRows([]string{"ID"}).AddRow("01890a5d-ac96-774b-bcce-b302099a8058"))
+		}, ErrorUserExists, tUser},
+		{newUser, func() {
+			mock.ExpectQuery(`SELECT \[ID] FROM \[auth]\.\[RetiredUserIDs]`).
+				WillReturnError(sql.ErrNoRows)
+			mock.ExpectExec(`UPDATE \[auth]\.\[Users]`).
+				WillReturnError(errors.New("Violation of UNIQUE KEY constraint 'UQ_Users_UserIDKey'."))
+		}, ErrorUserExists, tUser},
+		{tUserShort, func() {}, ErrorUserIDShort, tUser},
+		{"acme/" + newUser, func() {}, ErrorTenantChange, tUser},
+	}
+	for i, c := range cases {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			c.expect()
+			u := &User{id: tID, userID: tUser}
+			uc := new(UserClient)
+			uc.Rename(u, c.userID, db)
+			assert.Equal(t, c.err, uc.Err())
+			assert.Equal(t, c.renamed, u.UserID())
+
+			if err = mock.ExpectationsWereMet(); err != nil {
+				t.Errorf("Expectations were not met. ERROR: %v\n", err)
+			}
+		})
+	}
+}
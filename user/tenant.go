@@ -0,0 +1,40 @@
+package user
+
+import (
+	"errors"
+	"github.com/penutty/authservice/tenant"
+	"strings"
+)
+
+var ErrorTenantChange = errors.New("A user cannot be moved to another tenant.")
+
+// Qualify returns the UserID userID has in the tenant with ID tenantID: the UserIDs of the default tenant are
+// unqualified, those of other tenants are prefixed with the tenant ID and "/". A userID containing "/" is
+// always prefixed, so that no input names a user of another tenant.
+func Qualify(tenantID, userID string) string {
+	if tenantID == "" {
+		tenantID = tenant.Default
+	}
+	if tenantID == tenant.Default && !strings.Contains(userID, "/") {
+		return userID
+	}
+	return tenantID + "/" + userID
+}
+
+// Tenant returns the ID of the tenant of the qualified UserID userID.
+func Tenant(userID string) string {
+	if i := strings.Index(userID, "/"); i >= 0 {
+		return userID[:i]
+	}
+	return tenant.Default
+}
+
+// local returns the part of the qualified UserID userID that is unique within its tenant.
+func local(userID string) string {
+	return userID[strings.Index(userID, "/")+1:]
+}
+
+// TenantID returns the ID of the tenant of u.
+func (u *User) TenantID() string {
+	return Tenant(u.UserID())
+}
@@ -0,0 +1,38 @@
+package user
+
+import (
+	"github.com/stretchr/testify/assert"
+	"strconv"
+	"testing"
+)
+
+func Test_Qualify(t *testing.T) {
+	cases := []struct {
+		tenantID, userID, qualified string
+	}{
+		{"", tUser, tUser},
+		{"default", tUser, tUser},
+		{"acme", tUser, "acme/" + tUser},
+		{"default", "acme/" + tUser, "default/acme/" + tUser},
+		{"beta", "acme/" + tUser, "beta/acme/" + tUser},
+	}
+	for i, c := range cases {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			q := Qualify(c.tenantID, c.userID)
+			assert.Equal(t, c.qualified, q)
+			if c.qualified != c.userID && c.qualified != "acme/"+tUser {
+				assert.Equal(t, ErrorUserIDInvalidRunes, CheckUserID(q))
+			}
+		})
+	}
+}
+
+func Test_Tenant(t *testing.T) {
+	assert.Equal(t, "default", Tenant(tUser))
+	assert.Equal(t, "acme", Tenant("acme/"+tUser))
+	assert.Equal(t, tUser, local("acme/"+tUser))
+	assert.Equal(t, tUser, local(tUser))
+
+	u := &User{userID: "acme/" + tUser}
+	assert.Equal(t, "acme", u.TenantID())
+}
@@ -7,10 +7,16 @@ import (
 	"errors"
 	sq "github.com/Masterminds/squirrel"
 	_ "github.com/minus5/gofreetds"
+	"github.com/penutty/authservice/tenant"
+	"golang.org/x/text/secure/precis"
 	"log"
 	"net/mail"
 	"os"
 	"regexp"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
 )
 
 var (
@@ -21,6 +27,15 @@ var (
 	ErrorUserIDParameterInvalid   = errors.New("AuthCredentials.userID must be a valid userID.")
 	ErrorPasswordParameterInvalid = errors.New("AuthCredentials.password must be a valid password.")
 	ErrorUserRowNotCreated        = errors.New("Create failed to create one row in the user.Users table.")
+	ErrorUserNotVerified          = errors.New("Verify failed to verify the email address of the user.")
+	ErrorVerificationThrottled    = errors.New("A verification email was sent recently.")
+	ErrorUserRowNotUpdated        = errors.New("Update failed to update one row in the user.Users table.")
+	ErrorUserRowNotDeleted        = errors.New("Delete failed to delete one row in the user.Users table.")
+	ErrorUserExists               = errors.New("UserID is already taken.")
+	ErrorEmailInUse               = errors.New("Email is already used by another user.")
+
+	// VerificationResendInterval is the minimum time between two verification emails to one user.
+	VerificationResendInterval = 5 * time.Minute
 )
 
 // MomentDB returns a connection to the SQLSRV Moment-Db database.
@@ -36,6 +51,17 @@ type Client interface {
 	Newer
 	Creater
 	Fetcher
+	EmailFetcher
+	IDFetcher
+	Verifier
+	Throttler
+	Updater
+	Deleter
+	PasswordSetter
+	Revoker
+	RevocationFetcher
+	StatusChanger
+	Renamer
 	Err() error
 }
 type CreateFetcher interface {
@@ -55,31 +81,76 @@ type Fetcher interface {
 	Fetch(string, sq.BaseRunner) *User
 }
 
+type EmailFetcher interface {
+	FetchByEmail(string, string, sq.BaseRunner) *User
+}
+
+type Verifier interface {
+	Verify(string, string, sq.BaseRunner)
+}
+
+type Throttler interface {
+	ThrottleVerification(string, sq.BaseRunner) error
+}
+
+type Updater interface {
+	Update(*User, sq.BaseRunner)
+}
+
+type Deleter interface {
+	Delete(string, sq.BaseRunner)
+}
+
+type PasswordSetter interface {
+	SetPassword(string, string, sq.BaseRunner)
+}
+
+type Revoker interface {
+	RevokeTokens(string, sq.BaseRunner)
+	RevokeTokensByID([]string, sq.BaseRunner)
+}
+
+type RevocationFetcher interface {
+	Revoked(string, sq.BaseRunner) time.Time
+}
+
+type StatusChanger interface {
+	ChangeStatus(string, Status, string, time.Time, sq.BaseRunner)
+}
+
 type UserClient struct {
 	err error
 }
 
-// NewUser is a constructor of the User struct.
+// NewUser is a constructor of the User struct. The user is given a new ID.
 func (uc *UserClient) NewUser(userID, email, password string) (u *User) {
 	u = new(User)
+	u.setID()
 	u.setUserID(userID)
 	u.setUserEmail(email)
 	u.setPassword(password)
+	u.status = StatusPendingVerification
 	uc.err = u.err
 	return
 }
 
-// Create inserts a new row into the user.Users table in db.
+// Create inserts a new row into the user.Users table in db. A UserID another user gave up less than
+// RenameGracePeriod ago is taken.
 func (uc *UserClient) Create(u *User, db sq.BaseRunner) {
 	if uc.err != nil {
 		return
 	}
+	if uc.checkRetired(NormalizeUserID(u.userID), u.id, db); uc.err != nil {
+		return
+	}
 
-	insert := sq.Insert("[auth].[Users]").Columns("[UserID]", "[Email]", "[Password]").Values(u.userID, u.email, u.password)
+	insert := sq.Insert("[auth].[Users]").
+		Columns("[ID]", "[TenantID]", "[UserID]", "[UserIDKey]", "[UserIDSkeleton]", "[Email]", "[EmailKey]", "[Password]", "[Status]").
+		Values(u.id, Tenant(u.userID), u.userID, NormalizeUserID(u.userID), Skeleton(u.userID), u.email, NormalizeEmail(u.email), u.password, u.status)
 	res, err := insert.RunWith(db).Exec()
 	if err != nil {
 		log.Print(err)
-		uc.err = err
+		uc.err = conflict(err)
 		return
 	}
 	cnt, err := res.RowsAffected()
@@ -95,7 +166,21 @@ func (uc *UserClient) Create(u *User, db sq.BaseRunner) {
 	return
 }
 
-// Fetch selects a row from the user.Users table in db.
+// conflict returns ErrorUserExists, ErrorUserIDConfusable or ErrorEmailInUse if err is a violation of the
+// unique constraint on the lookup key or skeleton of UserIDs or the lookup key of email addresses, and err otherwise.
+func conflict(err error) error {
+	switch {
+	case strings.Contains(err.Error(), "UQ_Users_UserIDKey"):
+		return ErrorUserExists
+	case strings.Contains(err.Error(), "UQ_Users_UserIDSkeleton"):
+		return ErrorUserIDConfusable
+	case strings.Contains(err.Error(), "UQ_Users_EmailKey"):
+		return ErrorEmailInUse
+	}
+	return err
+}
+
+// Fetch selects a row from the user.Users table in db. UserIDs are matched regardless of case.
 func (uc *UserClient) Fetch(userID string, db sq.BaseRunner) (u *User) {
 	if uc.err != nil {
 		return
@@ -105,19 +190,262 @@ func (uc *UserClient) Fetch(userID string, db sq.BaseRunner) (u *User) {
 		return
 	}
 
-	users := sq.Select("[UserID], [Email], [Password]").From("[auth].[Users]")
-	user := users.Where(sq.Eq{"[UserID]": userID})
+	return uc.fetch(sq.Eq{"[UserIDKey]": NormalizeUserID(userID)}, db)
+}
+
+// FetchByEmail selects the row of the user of the tenant with ID tenantID with email address email from the
+// user.Users table in db. Email addresses are matched regardless of case; they are unique within a tenant.
+func (uc *UserClient) FetchByEmail(tenantID, email string, db sq.BaseRunner) (u *User) {
+	if uc.err != nil {
+		return
+	}
+	if err := CheckEmail(email); err != nil {
+		uc.err = err
+		return
+	}
+	if tenantID == "" {
+		tenantID = tenant.Default
+	}
+
+	return uc.fetch(sq.Eq{"[TenantID]": tenantID, "[EmailKey]": NormalizeEmail(email)}, db)
+}
+
+func (uc *UserClient) fetch(where sq.Eq, db sq.BaseRunner) (u *User) {
+	users := sq.Select("[ID], [UserID], [Email], [Password], [Verified], [DisplayName], [Locale], [PasswordChanged]",
+		"[Status], [StatusReason], [StatusChanged], [StatusUntil]").From("[auth].[Users]")
+	user := users.Where(where)
 
 	u = new(User)
+	var until *time.Time
 	row := user.RunWith(db).QueryRow()
-	err := row.Scan(&u.userID, &u.email, &u.password)
+	err := row.Scan(&u.id, &u.userID, &u.email, &u.password, &u.verified, &u.displayName, &u.locale, &u.passwordChanged,
+		&u.status, &u.statusReason, &u.statusChanged, &until)
+	if err != nil {
+		log.Print(err)
+		uc.err = err
+		return
+	}
+	if until != nil {
+		u.statusUntil = *until
+	}
+	return
+}
+
+// Update writes the email address and profile of u to the user.Users table in db.
+// A changed email address is marked as unverified.
+func (uc *UserClient) Update(u *User, db sq.BaseRunner) {
+	if uc.err != nil {
+		return
+	}
+	if u.err != nil {
+		uc.err = u.err
+		return
+	}
+
+	update := sq.Update("[auth].[Users]").
+		Set("[Email]", u.email).
+		Set("[EmailKey]", NormalizeEmail(u.email)).
+		Set("[DisplayName]", u.displayName).
+		Set("[Locale]", u.locale).
+		Where(sq.Eq{"[UserID]": u.userID})
+	if u.emailChanged {
+		update = update.Set("[Verified]", false).Set("[VerificationSent]", nil)
+	}
+	res, err := update.RunWith(db).Exec()
+	if err != nil {
+		log.Print(err)
+		uc.err = conflict(err)
+		return
+	}
+	if cnt, err := res.RowsAffected(); err != nil || cnt != 1 {
+		log.Print(ErrorUserRowNotUpdated)
+		uc.err = ErrorUserRowNotUpdated
+		return
+	}
+	u.emailChanged = false
+}
+
+// Delete removes the row of userID from the user.Users table in db. Rows referencing the user are deleted with it.
+func (uc *UserClient) Delete(userID string, db sq.BaseRunner) {
+	if uc.err != nil {
+		return
+	}
+
+	del := sq.Delete("[auth].[Users]").Where(sq.Eq{"[UserID]": userID})
+	res, err := del.RunWith(db).Exec()
+	if err != nil {
+		log.Print(err)
+		uc.err = err
+		return
+	}
+	if cnt, err := res.RowsAffected(); err != nil || cnt != 1 {
+		log.Print(ErrorUserRowNotDeleted)
+		uc.err = ErrorUserRowNotDeleted
+	}
+}
+
+// Verify marks the email address of userID as verified if it is still email.
+// A user pending verification becomes active.
+func (uc *UserClient) Verify(userID, email string, db sq.BaseRunner) {
+	if uc.err != nil {
+		return
+	}
+
+	update := sq.Update("[auth].[Users]").
+		Set("[Verified]", true).
+		Set("[Status]", sq.Expr("CASE WHEN [Status] = ? THEN ? ELSE [Status] END", StatusPendingVerification, StatusActive)).
+		Where(sq.Eq{"[UserID]": userID, "[Email]": email})
+	res, err := update.RunWith(db).Exec()
+	if err != nil {
+		log.Print(err)
+		uc.err = err
+		return
+	}
+	if cnt, err := res.RowsAffected(); err != nil || cnt != 1 {
+		uc.err = ErrorUserNotVerified
+	}
+}
+
+// ThrottleVerification records that a verification email is being sent to userID.
+// It fails with ErrorVerificationThrottled if the user is already verified or was sent one
+// less than VerificationResendInterval ago.
+func (uc *UserClient) ThrottleVerification(userID string, db sq.BaseRunner) error {
+	if uc.err != nil {
+		return uc.err
+	}
+
+	now := time.Now().UTC()
+	update := sq.Update("[auth].[Users]").
+		Set("[VerificationSent]", now).
+		Where(sq.Eq{"[UserID]": userID, "[Verified]": false}).
+		Where(sq.Or{sq.Eq{"[VerificationSent]": nil}, sq.Lt{"[VerificationSent]": now.Add(-VerificationResendInterval)}})
+	res, err := update.RunWith(db).Exec()
+	if err != nil {
+		log.Print(err)
+		uc.err = err
+		return err
+	}
+	if cnt, err := res.RowsAffected(); err != nil || cnt != 1 {
+		return ErrorVerificationThrottled
+	}
+	return nil
+}
+
+// SetPassword replaces the password of userID if password is valid and records when it was changed.
+func (uc *UserClient) SetPassword(userID, password string, db sq.BaseRunner) {
+	if uc.err != nil {
+		return
+	}
+	if err := CheckPassword(password); err != nil {
+		uc.err = err
+		return
+	}
+
+	update := sq.Update("[auth].[Users]").
+		Set("[Password]", password).
+		Set("[PasswordChanged]", time.Now().UTC()).
+		Where(sq.Eq{"[UserID]": userID})
+	res, err := update.RunWith(db).Exec()
 	if err != nil {
 		log.Print(err)
 		uc.err = err
+		return
+	}
+	if cnt, err := res.RowsAffected(); err != nil || cnt != 1 {
+		log.Print(ErrorUserRowNotUpdated)
+		uc.err = ErrorUserRowNotUpdated
+	}
+}
+
+// RevokeTokens revokes every token issued to userID until now.
+func (uc *UserClient) RevokeTokens(userID string, db sq.BaseRunner) {
+	if uc.err != nil {
+		return
+	}
+
+	update := sq.Update("[auth].[Users]").Set("[TokensRevoked]", time.Now().UTC()).Where(sq.Eq{"[UserID]": userID})
+	res, err := update.RunWith(db).Exec()
+	if err != nil {
+		log.Print(err)
+		uc.err = err
+		return
+	}
+	if cnt, err := res.RowsAffected(); err != nil || cnt != 1 {
+		log.Print(ErrorUserRowNotUpdated)
+		uc.err = ErrorUserRowNotUpdated
+	}
+}
+
+// RevokeTokensByID revokes every token issued until now to the users with IDs ids. IDs of users that no
+// longer exist are ignored.
+func (uc *UserClient) RevokeTokensByID(ids []string, db sq.BaseRunner) {
+	if uc.err != nil || len(ids) == 0 {
+		return
+	}
+
+	update := sq.Update("[auth].[Users]").Set("[TokensRevoked]", time.Now().UTC()).Where(sq.Eq{"[ID]": ids})
+	if _, err := update.RunWith(db).Exec(); err != nil {
+		log.Print(err)
+		uc.err = err
+	}
+}
+
+// Revoked returns the time tokens of userID were last revoked, or the zero time if they never were.
+func (uc *UserClient) Revoked(userID string, db sq.BaseRunner) (t time.Time) {
+	if uc.err != nil {
+		return
+	}
+
+	var revoked *time.Time
+	sel := sq.Select("[TokensRevoked]").From("[auth].[Users]").Where(sq.Eq{"[UserID]": userID})
+	if err := sel.RunWith(db).QueryRow().Scan(&revoked); err != nil {
+		log.Print(err)
+		uc.err = err
+		return
+	}
+	if revoked != nil {
+		t = *revoked
 	}
 	return
 }
 
+// ChangeStatus sets the status of userID with the reason for the change. A suspension lasts until until,
+// or indefinitely if until is the zero time.
+func (uc *UserClient) ChangeStatus(userID string, status Status, reason string, until time.Time, db sq.BaseRunner) {
+	if uc.err != nil {
+		return
+	}
+	if err := CheckStatus(status); err != nil {
+		uc.err = err
+		return
+	}
+	if err := CheckStatusReason(reason); err != nil {
+		uc.err = err
+		return
+	}
+
+	var u *time.Time
+	if status == StatusSuspended && !until.IsZero() {
+		u = &until
+	}
+	update := sq.Update("[auth].[Users]").
+		Set("[Status]", status).
+		Set("[StatusReason]", reason).
+		Set("[StatusChanged]", time.Now().UTC()).
+		Set("[StatusUntil]", u).
+		Where(sq.Eq{"[UserID]": userID})
+	res, err := update.RunWith(db).Exec()
+	if err != nil {
+		log.Print(err)
+		uc.err = err
+		return
+	}
+	if cnt, err := res.RowsAffected(); err != nil || cnt != 1 {
+		log.Print(ErrorUserRowNotUpdated)
+		uc.err = ErrorUserRowNotUpdated
+	}
+}
+
 // Err returns the the error status of a User instance.
 func (uc *UserClient) Err() error {
 	return uc.err
@@ -125,10 +453,125 @@ func (uc *UserClient) Err() error {
 
 // User references a unique user.Users row in the Moment-Db database.
 type User struct {
-	userID   string
-	email    string
-	password string
-	err      error
+	id              string
+	userID          string
+	email           string
+	password        string
+	verified        bool
+	displayName     string
+	locale          string
+	passwordChanged time.Time
+	status          Status
+	statusReason    string
+	statusChanged   time.Time
+	statusUntil     time.Time
+	emailChanged    bool
+	err             error
+}
+
+// Status is the state of a user account. Only active users and users pending verification may log in.
+type Status string
+
+const (
+	StatusActive              Status = "active"
+	StatusPendingVerification Status = "pending_verification"
+	StatusSuspended           Status = "suspended"
+	StatusDisabled            Status = "disabled"
+	StatusPendingDeletion     Status = "pending_deletion"
+)
+
+var (
+	StatusReasonMaxLength = 256
+
+	ErrorStatusInvalid    = errors.New("Status must be active, pending_verification, suspended, disabled or pending_deletion.")
+	ErrorStatusReasonLong = errors.New("StatusReason too long.")
+)
+
+// CheckStatus returns an error if status is not a known Status.
+func CheckStatus(status Status) error {
+	switch status {
+	case StatusActive, StatusPendingVerification, StatusSuspended, StatusDisabled, StatusPendingDeletion:
+		return nil
+	}
+	return ErrorStatusInvalid
+}
+
+// CheckStatusReason returns an error if reason is invalid. An empty reason is valid.
+func CheckStatusReason(reason string) error {
+	if utf8.RuneCountInString(reason) > StatusReasonMaxLength {
+		return ErrorStatusReasonLong
+	}
+	return nil
+}
+
+// SetEmail changes User.email if email is valid. A changed email address is unverified.
+func (u *User) SetEmail(email string) {
+	if u.err != nil || email == u.email {
+		return
+	}
+	u.setUserEmail(email)
+	if u.err == nil {
+		u.verified = false
+		u.emailChanged = true
+	}
+}
+
+// SetDisplayName sets User.displayName if displayName is valid.
+func (u *User) SetDisplayName(displayName string) {
+	if u.err != nil {
+		return
+	}
+	if err := CheckDisplayName(displayName); err != nil {
+		u.err = err
+		return
+	}
+	u.displayName = displayName
+}
+
+var (
+	DisplayNameMaxLength = 128
+
+	ErrorDisplayNameLong         = errors.New("DisplayName too long.")
+	ErrorDisplayNameInvalidRunes = errors.New("DisplayName may not contain control characters.")
+)
+
+// CheckDisplayName returns an error if displayName is invalid. An empty displayName is valid.
+func CheckDisplayName(displayName string) error {
+	if utf8.RuneCountInString(displayName) > DisplayNameMaxLength {
+		return ErrorDisplayNameLong
+	}
+	for _, r := range displayName {
+		if unicode.IsControl(r) || r == utf8.RuneError {
+			return ErrorDisplayNameInvalidRunes
+		}
+	}
+	return nil
+}
+
+// SetLocale sets User.locale if locale is valid.
+func (u *User) SetLocale(locale string) {
+	if u.err != nil {
+		return
+	}
+	if err := CheckLocale(locale); err != nil {
+		u.err = err
+		return
+	}
+	u.locale = locale
+}
+
+var ErrorLocaleInvalid = errors.New("Locale must be a BCP 47 language tag, e.g. \"en-US\".")
+
+// CheckLocale returns an error if locale is invalid. An empty locale is valid.
+func CheckLocale(locale string) error {
+	r, err := regexp.Compile(`^[a-zA-Z]{2,8}(-[a-zA-Z0-9]{1,8})*$`)
+	if err != nil {
+		return err
+	}
+	if locale != "" && (len(locale) > 35 || !r.MatchString(locale)) {
+		return ErrorLocaleInvalid
+	}
+	return nil
 }
 
 // setUserEmail sets User.email if email is valid.
@@ -143,6 +586,15 @@ func (u *User) setUserEmail(email string) {
 	u.email = email
 }
 
+// NormalizeEmail returns the lookup key of email: its address, without a display name or angle brackets,
+// case folded. Two email addresses with the same key belong to the same user.
+func NormalizeEmail(email string) string {
+	if a, err := mail.ParseAddress(email); err == nil {
+		email = a.Address
+	}
+	return strings.ToLower(email)
+}
+
 var (
 	EmailMinLength = 10
 	EmailMaxLength = 128
@@ -165,16 +617,31 @@ func CheckEmail(email string) error {
 	return nil
 }
 
-// setUserID sets User.userID if userID is valid.
+// setUserID sets User.userID to the enforced form of userID if userID is valid and not reserved.
 func (u *User) setUserID(userID string) {
 	if u.err != nil {
 		return
 	}
-	if err := CheckUserID(userID); err != nil {
+	enforced, err := EnforceUserID(userID)
+	if err != nil {
 		u.err = err
 		return
 	}
-	u.userID = userID
+	if err := CheckReserved(enforced); err != nil {
+		u.err = err
+		return
+	}
+	u.userID = enforced
+}
+
+// NormalizeUserID returns the lookup key of userID. UserIDs differing only in case belong to the same user.
+func NormalizeUserID(userID string) string {
+	if UsernameProfile == ProfilePRECIS {
+		if key, err := precis.UsernameCaseMapped.String(userID); err == nil {
+			return key
+		}
+	}
+	return strings.ToLower(userID)
 }
 
 var (
@@ -188,20 +655,61 @@ var (
 
 // CheckUserID returns an error if userID is invalid.
 func CheckUserID(userID string) error {
-	r, err := regexp.Compile(`^[a-zA-Z0-9]+$`)
+	_, err := EnforceUserID(userID)
+	return err
+}
+
+// EnforceUserID returns the form of userID that is stored, or an error if userID is invalid
+// under UsernameProfile. Lengths are counted in runes. A UserID qualified with a tenant, see Qualify,
+// must name a tenant other than the default one and may not exceed UserIDMaxLength as a whole.
+func EnforceUserID(userID string) (string, error) {
+	i := strings.Index(userID, "/")
+	if i < 0 {
+		return enforceLocal(userID)
+	}
+	tenantID := userID[:i]
+	if err := tenant.CheckID(tenantID); err != nil || tenantID == tenant.Default {
+		return "", ErrorUserIDInvalidRunes
+	}
+	enforced, err := enforceLocal(userID[i+1:])
 	if err != nil {
-		return err
+		return "", err
 	}
-	switch {
-	case len(userID) < UserIDMinLength:
-		return ErrorUserIDShort
-	case len(userID) > UserIDMaxLength:
-		return ErrorUserIDLong
-	case !r.MatchString(userID):
-		return ErrorUserIDInvalidRunes
-	default:
-		return nil
+	if strings.Contains(enforced, "/") {
+		return "", ErrorUserIDInvalidRunes
+	}
+	if utf8.RuneCountInString(tenantID+"/"+enforced) > UserIDMaxLength {
+		return "", ErrorUserIDLong
+	}
+	return tenantID + "/" + enforced, nil
+}
+
+// enforceLocal enforces the part of a UserID that is unique within its tenant.
+func enforceLocal(userID string) (string, error) {
+	if UsernameProfile == ProfilePRECIS && userID != "" {
+		enforced, err := precis.UsernameCasePreserved.String(userID)
+		if err != nil {
+			return "", ErrorUserIDInvalidRunes
+		}
+		userID = enforced
+	}
+
+	switch n := utf8.RuneCountInString(userID); {
+	case n < UserIDMinLength:
+		return "", ErrorUserIDShort
+	case n > UserIDMaxLength:
+		return "", ErrorUserIDLong
 	}
+	if UsernameProfile != ProfilePRECIS {
+		r, err := regexp.Compile(`^[a-zA-Z0-9]+$`)
+		if err != nil {
+			return "", err
+		}
+		if !r.MatchString(userID) {
+			return "", ErrorUserIDInvalidRunes
+		}
+	}
+	return userID, nil
 }
 
 // setPassword sets User.password if password is valid.
@@ -268,6 +776,152 @@ func CheckPassword(password string) error {
 	return nil
 }
 
+// ID returns the immutable ID of a User.
+func (u *User) ID() (id string) {
+	if u.err != nil {
+		return
+	}
+	id = u.id
+	return
+}
+
+// SetID sets User.id.
+func (u *User) SetID(id string) {
+	u.id = id
+}
+
+// setID sets User.id to a new ID.
+func (u *User) setID() {
+	if u.err != nil {
+		return
+	}
+	id, err := NewID()
+	if err != nil {
+		u.err = err
+		return
+	}
+	u.id = id
+}
+
+// UserID returns the userID of a User.
+func (u *User) UserID() (id string) {
+	if u.err != nil {
+		return
+	}
+	id = u.userID
+	return
+}
+
+// Email returns the email address of a User.
+func (u *User) Email() (e string) {
+	if u.err != nil {
+		return
+	}
+	e = u.email
+	return
+}
+
+// Verified reports whether the email address of a User has been verified.
+func (u *User) Verified() (v bool) {
+	if u.err != nil {
+		return
+	}
+	v = u.verified
+	return
+}
+
+// SetVerified sets User.verified.
+func (u *User) SetVerified(verified bool) {
+	u.verified = verified
+}
+
+// DisplayName returns the display name of a User.
+func (u *User) DisplayName() (n string) {
+	if u.err != nil {
+		return
+	}
+	n = u.displayName
+	return
+}
+
+// Locale returns the preferred locale of a User.
+func (u *User) Locale() (l string) {
+	if u.err != nil {
+		return
+	}
+	l = u.locale
+	return
+}
+
+// PasswordChanged returns the time the password of a User was last set.
+func (u *User) PasswordChanged() (t time.Time) {
+	if u.err != nil {
+		return
+	}
+	t = u.passwordChanged
+	return
+}
+
+// SetPasswordChanged sets User.passwordChanged.
+func (u *User) SetPasswordChanged(t time.Time) {
+	u.passwordChanged = t
+}
+
+// Status returns the status of a User at now. A suspension ends at its StatusUntil.
+func (u *User) Status(now time.Time) (s Status) {
+	if u.err != nil {
+		return
+	}
+	s = u.status
+	if s == StatusSuspended && !u.statusUntil.IsZero() && !now.Before(u.statusUntil) {
+		s = StatusActive
+	}
+	return
+}
+
+// StatusReason returns the reason given for the last status change of a User.
+func (u *User) StatusReason() (r string) {
+	if u.err != nil {
+		return
+	}
+	r = u.statusReason
+	return
+}
+
+// StatusChanged returns the time the status of a User last changed.
+func (u *User) StatusChanged() (t time.Time) {
+	if u.err != nil {
+		return
+	}
+	t = u.statusChanged
+	return
+}
+
+// StatusUntil returns the time a suspension of a User ends, or the zero time.
+func (u *User) StatusUntil() (t time.Time) {
+	if u.err != nil {
+		return
+	}
+	t = u.statusUntil
+	return
+}
+
+// SetStatus sets User.status, User.statusReason and User.statusUntil if status and reason are valid.
+func (u *User) SetStatus(status Status, reason string, until time.Time) {
+	if u.err != nil {
+		return
+	}
+	if err := CheckStatus(status); err != nil {
+		u.err = err
+		return
+	}
+	if err := CheckStatusReason(reason); err != nil {
+		u.err = err
+		return
+	}
+	u.status, u.statusReason, u.statusUntil = status, reason, until
+}
+
 func (u *User) Password() (p string) {
 	if u.err != nil {
 		return
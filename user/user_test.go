@@ -8,9 +8,11 @@ import (
 	"strconv"
 	"strings"
 	"testing"
+	"time"
 )
 
 var (
+	tID            = "01890a5d-ac96-774b-bcce-b302099a8057"
 	tUser          = "testuser"
 	tUserShort     = "user"
 	tUserLong      = strings.Repeat("u", 65)
@@ -163,12 +165,16 @@ func Test_Create(t *testing.T) {
 	}
 	defer db.Close()
 	t.Run("1", func(t *testing.T) {
-		mock.ExpectExec(`INSERT INTO \[auth]\.\[Users] \(\[UserID],\[Email],\[Password]\) VALUES \(\?,\?,\?\)`).
-			WithArgs(tUser, tEmail, tPassword).
-			WillReturnResult(sqlmock.NewResult(1, 1))
-
 		uc := new(UserClient)
 		u := uc.NewUser(tUser, tEmail, tPassword)
+
+		mock.ExpectQuery(`SELECT \[ID] FROM \[auth]\.\[RetiredUserIDs] WHERE \[UserIDKey] = \? AND \[Expires] > \?`).
+			WithArgs(tUser, sqlmock.AnyArg()).
+			WillReturnError(sql.ErrNoRows)
+		mock.ExpectExec(`INSERT INTO \[auth]\.\[Users] \(\[ID],\[TenantID],\[UserID],\[UserIDKey],\[UserIDSkeleton],\[Email],\[EmailKey],\[Password],\[Status]\) VALUES \(\?,\?,\?,\?,\?,\?,\?,\?,\?\)`).
+			WithArgs(u.ID(), "default", tUser, tUser, tUser, tEmail, "testemail@email.com", tPassword, StatusPendingVerification).
+			WillReturnResult(sqlmock.NewResult(1, 1))
+
 		uc.Create(u, db)
 		assert.Nil(t, uc.Err())
 
@@ -183,6 +189,88 @@ func Test_Create(t *testing.T) {
 		uc.Create(u, db)
 		assert.Error(t, uc.Err())
 	})
+
+	violations := []struct {
+		constraint string
+		err        error
+	}{
+		{"UQ_Users_UserIDKey", ErrorUserExists},
+		{"UQ_Users_UserIDSkeleton", ErrorUserIDConfusable},
+		{"UQ_Users_EmailKey", ErrorEmailInUse},
+	}
+	for i, v := range violations {
+		t.Run(strconv.Itoa(i+3), func(t *testing.T) {
+			mock.ExpectQuery(`SELECT \[ID] FROM \[auth]\.\[RetiredUserIDs]`).WillReturnError(sql.ErrNoRows)
+			mock.ExpectExec(`INSERT INTO \[auth]\.\[Users]`).
+				WillReturnError(errors.New("Violation of UNIQUE KEY constraint '" + v.constraint + "'. Cannot insert duplicate key in object 'auth.Users'."))
+
+			uc := new(UserClient)
+			uc.Create(uc.NewUser("TestUser", "<TestEmail@Email.com>", tPassword), db)
+			assert.Equal(t, v.err, uc.Err())
+
+			if err = mock.ExpectationsWereMet(); err != nil {
+				t.Errorf("Expectations were not met. ERROR: %v\n", err)
+			}
+		})
+	}
+}
+
+func Test_Create_retired(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("An error occured when opening a stub database connection. ERROR: %v\n", err)
+	}
+	defer db.Close()
+
+	mock.ExpectQuery(`SELECT \[ID] FROM \[auth]\.\[RetiredUserIDs]`).
+		WithArgs(tUser, sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"ID"}).AddRow(tID))
+
+	uc := new(UserClient)
+	uc.Create(uc.NewUser(tUser, tEmail, tPassword), db)
+	assert.Equal(t, ErrorUserExists, uc.Err())
+
+	if err = mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Expectations were not met. ERROR: %v\n", err)
+	}
+}
+
+func Test_Normalize(t *testing.T) {
+	assert.Equal(t, "testuser", NormalizeUserID("TestUser"))
+	assert.Equal(t, "testemail@email.com", NormalizeEmail("<TestEmail@Email.COM>"))
+	assert.Equal(t, "testemail@email.com", NormalizeEmail("Test User <testemail@email.com>"))
+}
+
+func Test_FetchByEmail(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("An error occured when opening a stub database connection. ERROR: %v\n", err)
+	}
+	defer db.Close()
+
+	t.Run("1", func(t *testing.T) {
+		row := sqlmock.NewRows([]string{"ID", "UserID", "Email", "Password", "Verified", "DisplayName", "Locale", "PasswordChanged",
+			"Status", "StatusReason", "StatusChanged", "StatusUntil"}).
+			AddRow(tID, tUser, tEmail, tPassword, true, "", "", time.Now(), "active", "", time.Now(), nil)
+		mock.ExpectQuery(`SELECT .+ FROM \[auth]\.\[Users] WHERE \[EmailKey] = \? AND \[TenantID] = \?`).
+			WithArgs("testemail@email.com", "default").
+			WillReturnRows(row)
+
+		uc := new(UserClient)
+		u := uc.FetchByEmail("", "<TestEmail@Email.com>", db)
+		assert.Nil(t, uc.Err())
+		assert.Equal(t, tUser, u.UserID())
+
+		if err = mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("Expectations were not met. ERROR: %v\n", err)
+		}
+	})
+
+	t.Run("2", func(t *testing.T) {
+		uc := new(UserClient)
+		_ = uc.FetchByEmail("", tEmailInvalidFormat, db)
+		assert.Error(t, uc.Err())
+	})
 }
 
 func Test_Fetch(t *testing.T) {
@@ -193,16 +281,28 @@ func Test_Fetch(t *testing.T) {
 	defer db.Close()
 
 	t.Run("1", func(t *testing.T) {
-		row := sqlmock.NewRows([]string{"UserID", "Email", "Password"}).
-			AddRow(tUser, tEmail, tPassword)
+		changed := time.Now().UTC().Add(-time.Hour)
+		until := time.Now().UTC().Add(time.Hour)
+		row := sqlmock.NewRows([]string{"ID", "UserID", "Email", "Password", "Verified", "DisplayName", "Locale", "PasswordChanged",
+			"Status", "StatusReason", "StatusChanged", "StatusUntil"}).
+			AddRow(tID, tUser, tEmail, tPassword, true, "Test User", "en-US", changed, "suspended", "Abuse", changed, until)
 
-		mock.ExpectQuery(`SELECT \[UserID], \[Email], \[Password] FROM \[auth]\.\[Users] WHERE \[UserID] = \?`).
+		mock.ExpectQuery(`SELECT \[ID], \[UserID], \[Email], \[Password], \[Verified], \[DisplayName], \[Locale], \[PasswordChanged], \[Status], \[StatusReason], \[StatusChanged], \[StatusUntil] FROM \[auth]\.\[Users] WHERE \[UserIDKey] = \?`).
 			WithArgs(tUser).
 			WillReturnRows(row)
 
 		uc := new(UserClient)
-		u := uc.Fetch(tUser, db)
+		u := uc.Fetch("TestUser", db)
 		assert.Nil(t, u.Err())
+		assert.Equal(t, tID, u.ID())
+		assert.True(t, u.Verified())
+		assert.Equal(t, "Test User", u.DisplayName())
+		assert.Equal(t, "en-US", u.Locale())
+		assert.Equal(t, changed, u.PasswordChanged())
+		assert.Equal(t, StatusSuspended, u.Status(changed))
+		assert.Equal(t, StatusActive, u.Status(until))
+		assert.Equal(t, "Abuse", u.StatusReason())
+		assert.Equal(t, until, u.StatusUntil())
 
 		if err = mock.ExpectationsWereMet(); err != nil {
 			t.Errorf("Expectations were not met. ERROR: %v\n", err)
@@ -222,3 +322,401 @@ func Test_Fetch(t *testing.T) {
 		assert.EqualError(t, uc.Err(), ErrorUserIDShort.Error())
 	})
 }
+
+func Test_Email(t *testing.T) {
+	uc := new(UserClient)
+	u := uc.NewUser(tUser, tEmail, tPassword)
+	assert.Equal(t, tUser, u.UserID())
+	assert.Equal(t, tEmail, u.Email())
+
+	u = uc.NewUser(tUserShort, tEmail, tPassword)
+	assert.Empty(t, u.Email())
+}
+
+func Test_Verify(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("An error occured when opening a stub database connection. ERROR: %v\n", err)
+	}
+	defer db.Close()
+
+	testVars := []struct {
+		rows int64
+		err  error
+	}{
+		{1, nil},
+		{0, ErrorUserNotVerified},
+	}
+	for i, v := range testVars {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			mock.ExpectExec(`UPDATE \[auth]\.\[Users] SET \[Verified] = \?, \[Status] = CASE WHEN \[Status] = \? THEN \? ELSE \[Status] END WHERE \[Email] = \? AND \[UserID] = \?`).
+				WithArgs(true, StatusPendingVerification, StatusActive, tEmail, tUser).
+				WillReturnResult(sqlmock.NewResult(0, v.rows))
+
+			uc := new(UserClient)
+			uc.Verify(tUser, tEmail, db)
+			if v.err != nil {
+				assert.EqualError(t, uc.Err(), v.err.Error())
+			} else {
+				assert.Nil(t, uc.Err())
+			}
+
+			if err = mock.ExpectationsWereMet(); err != nil {
+				t.Errorf("Expectations were not met. ERROR: %v\n", err)
+			}
+		})
+	}
+}
+
+func Test_ThrottleVerification(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("An error occured when opening a stub database connection. ERROR: %v\n", err)
+	}
+	defer db.Close()
+
+	testVars := []struct {
+		rows int64
+		err  error
+	}{
+		{1, nil},
+		{0, ErrorVerificationThrottled},
+	}
+	for i, v := range testVars {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			mock.ExpectExec(`UPDATE \[auth]\.\[Users] SET \[VerificationSent] = \? WHERE \[UserID] = \? AND \[Verified] = \? AND \(\[VerificationSent] IS NULL OR \[VerificationSent] < \?\)`).
+				WithArgs(sqlmock.AnyArg(), tUser, false, sqlmock.AnyArg()).
+				WillReturnResult(sqlmock.NewResult(0, v.rows))
+
+			uc := new(UserClient)
+			assert.Equal(t, v.err, uc.ThrottleVerification(tUser, db))
+			assert.Nil(t, uc.Err())
+
+			if err = mock.ExpectationsWereMet(); err != nil {
+				t.Errorf("Expectations were not met. ERROR: %v\n", err)
+			}
+		})
+	}
+}
+
+func Test_SetPassword(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("An error occured when opening a stub database connection. ERROR: %v\n", err)
+	}
+	defer db.Close()
+
+	t.Run("1", func(t *testing.T) {
+		mock.ExpectExec(`UPDATE \[auth]\.\[Users] SET \[Password] = \?, \[PasswordChanged] = \? WHERE \[UserID] = \?`).
+			WithArgs(tPassword, sqlmock.AnyArg(), tUser).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+
+		uc := new(UserClient)
+		uc.SetPassword(tUser, tPassword, db)
+		assert.Nil(t, uc.Err())
+
+		if err = mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("Expectations were not met. ERROR: %v\n", err)
+		}
+	})
+
+	t.Run("2", func(t *testing.T) {
+		uc := new(UserClient)
+		uc.SetPassword(tUser, tPasswordNoSpecChars, db)
+		assert.EqualError(t, uc.Err(), ErrorPasswordSpecChars.Error())
+	})
+
+	t.Run("3", func(t *testing.T) {
+		mock.ExpectExec(`UPDATE \[auth]\.\[Users] SET \[Password] = \?, \[PasswordChanged] = \? WHERE \[UserID] = \?`).
+			WithArgs(tPassword, sqlmock.AnyArg(), tUser).
+			WillReturnResult(sqlmock.NewResult(0, 0))
+
+		uc := new(UserClient)
+		uc.SetPassword(tUser, tPassword, db)
+		assert.EqualError(t, uc.Err(), ErrorUserRowNotUpdated.Error())
+	})
+}
+
+func Test_RevokeTokens(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("An error occured when opening a stub database connection. ERROR: %v\n", err)
+	}
+	defer db.Close()
+
+	mock.ExpectExec(`UPDATE \[auth]\.\[Users] SET \[TokensRevoked] = \? WHERE \[UserID] = \?`).
+		WithArgs(sqlmock.AnyArg(), tUser).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	uc := new(UserClient)
+	uc.RevokeTokens(tUser, db)
+	assert.Nil(t, uc.Err())
+
+	if err = mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Expectations were not met. ERROR: %v\n", err)
+	}
+}
+
+func Test_RevokeTokensByID(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("An error occured when opening a stub database connection. ERROR: %v\n", err)
+	}
+	defer db.Close()
+
+	mock.ExpectExec(`UPDATE \[auth]\.\[Users] SET \[TokensRevoked] = \? WHERE \[ID] IN \(\?,\?\)`).
+		WithArgs(sqlmock.AnyArg(), "id-1", "id-2").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	uc := new(UserClient)
+	uc.RevokeTokensByID([]string{"id-1", "id-2"}, db)
+	uc.RevokeTokensByID(nil, db)
+	assert.Nil(t, uc.Err())
+
+	if err = mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Expectations were not met. ERROR: %v\n", err)
+	}
+}
+
+func Test_Revoked(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("An error occured when opening a stub database connection. ERROR: %v\n", err)
+	}
+	defer db.Close()
+
+	revoked := time.Date(2018, 1, 2, 3, 4, 5, 0, time.UTC)
+	testVars := []struct {
+		value    interface{}
+		expected time.Time
+	}{
+		{revoked, revoked},
+		{nil, time.Time{}},
+	}
+	for i, v := range testVars {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			mock.ExpectQuery(`SELECT \[TokensRevoked] FROM \[auth]\.\[Users] WHERE \[UserID] = \?`).
+				WithArgs(tUser).
+				WillReturnRows(sqlmock.NewRows([]string{"TokensRevoked"}).AddRow(v.value))
+
+			uc := new(UserClient)
+			assert.Equal(t, v.expected, uc.Revoked(tUser, db))
+			assert.Nil(t, uc.Err())
+
+			if err = mock.ExpectationsWereMet(); err != nil {
+				t.Errorf("Expectations were not met. ERROR: %v\n", err)
+			}
+		})
+	}
+}
+
+func Test_SetEmail(t *testing.T) {
+	uc := new(UserClient)
+	u := uc.NewUser(tUser, tEmail, tPassword)
+	u.SetVerified(true)
+
+	u.SetEmail(tEmail)
+	assert.True(t, u.Verified())
+
+	u.SetEmail("<otheremail@email.com>")
+	assert.Nil(t, u.Err())
+	assert.False(t, u.Verified())
+	assert.Equal(t, "<otheremail@email.com>", u.Email())
+
+	u.SetEmail(tEmailShort)
+	assert.EqualError(t, u.Err(), ErrorEmailShort.Error())
+}
+
+func Test_CheckDisplayName(t *testing.T) {
+	testVars := []struct {
+		name string
+		err  error
+	}{
+		{"", nil},
+		{"Zoë Żółć", nil},
+		{strings.Repeat("ü", 128), nil},
+		{strings.Repeat("ü", 129), ErrorDisplayNameLong},
+		{"new\nline", ErrorDisplayNameInvalidRunes},
+	}
+	for i, v := range testVars {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			err := CheckDisplayName(v.name)
+			if v.err != nil {
+				assert.EqualError(t, err, v.err.Error())
+			} else {
+				assert.Nil(t, err)
+			}
+		})
+	}
+}
+
+func Test_CheckLocale(t *testing.T) {
+	testVars := []struct {
+		locale string
+		err    error
+	}{
+		{"", nil},
+		{"en", nil},
+		{"pt-BR", nil},
+		{"zh-Hant-TW", nil},
+		{"e", ErrorLocaleInvalid},
+		{"en_US", ErrorLocaleInvalid},
+	}
+	for i, v := range testVars {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			err := CheckLocale(v.locale)
+			if v.err != nil {
+				assert.EqualError(t, err, v.err.Error())
+			} else {
+				assert.Nil(t, err)
+			}
+		})
+	}
+}
+
+func Test_Update(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("An error occured when opening a stub database connection. ERROR: %v\n", err)
+	}
+	defer db.Close()
+
+	t.Run("profile", func(t *testing.T) {
+		mock.ExpectExec(`UPDATE \[auth]\.\[Users] SET \[Email] = \?, \[EmailKey] = \?, \[DisplayName] = \?, \[Locale] = \? WHERE \[UserID] = \?`).
+			WithArgs(tEmail, "testemail@email.com", "Test User", "de", tUser).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+
+		uc := new(UserClient)
+		u := uc.NewUser(tUser, tEmail, tPassword)
+		u.SetDisplayName("Test User")
+		u.SetLocale("de")
+		uc.Update(u, db)
+		assert.Nil(t, uc.Err())
+
+		if err = mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("Expectations were not met. ERROR: %v\n", err)
+		}
+	})
+
+	t.Run("email", func(t *testing.T) {
+		mock.ExpectExec(`UPDATE \[auth]\.\[Users] SET \[Email] = \?, \[EmailKey] = \?, \[DisplayName] = \?, \[Locale] = \?, \[Verified] = \?, \[VerificationSent] = \? WHERE \[UserID] = \?`).
+			WithArgs("<otheremail@email.com>", "otheremail@email.com", "", "", false, nil, tUser).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+
+		uc := new(UserClient)
+		u := uc.NewUser(tUser, tEmail, tPassword)
+		u.SetEmail("<otheremail@email.com>")
+		uc.Update(u, db)
+		assert.Nil(t, uc.Err())
+
+		if err = mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("Expectations were not met. ERROR: %v\n", err)
+		}
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		uc := new(UserClient)
+		u := uc.NewUser(tUser, tEmail, tPassword)
+		u.SetLocale("en_US")
+		uc.Update(u, db)
+		assert.EqualError(t, uc.Err(), ErrorLocaleInvalid.Error())
+	})
+}
+
+func Test_Delete(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("An error occured when opening a stub database connection. ERROR: %v\n", err)
+	}
+	defer db.Close()
+
+	testVars := []struct {
+		rows int64
+		err  error
+	}{
+		{1, nil},
+		{0, ErrorUserRowNotDeleted},
+	}
+	for i, v := range testVars {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			mock.ExpectExec(`DELETE FROM \[auth]\.\[Users] WHERE \[UserID] = \?`).
+				WithArgs(tUser).
+				WillReturnResult(sqlmock.NewResult(0, v.rows))
+
+			uc := new(UserClient)
+			uc.Delete(tUser, db)
+			if v.err != nil {
+				assert.EqualError(t, uc.Err(), v.err.Error())
+			} else {
+				assert.Nil(t, uc.Err())
+			}
+
+			if err = mock.ExpectationsWereMet(); err != nil {
+				t.Errorf("Expectations were not met. ERROR: %v\n", err)
+			}
+		})
+	}
+}
+
+func Test_CheckStatus(t *testing.T) {
+	assert.Nil(t, CheckStatus(StatusDisabled))
+	assert.EqualError(t, CheckStatus("banned"), ErrorStatusInvalid.Error())
+	assert.Nil(t, CheckStatusReason(""))
+	assert.EqualError(t, CheckStatusReason(strings.Repeat("r", 257)), ErrorStatusReasonLong.Error())
+}
+
+func Test_SetStatus(t *testing.T) {
+	uc := new(UserClient)
+	u := uc.NewUser(tUser, tEmail, tPassword)
+	assert.Equal(t, StatusPendingVerification, u.Status(time.Now()))
+
+	u.SetStatus(StatusDisabled, "Requested by user", time.Time{})
+	assert.Nil(t, u.Err())
+	assert.Equal(t, StatusDisabled, u.Status(time.Now()))
+
+	u.SetStatus("banned", "", time.Time{})
+	assert.EqualError(t, u.Err(), ErrorStatusInvalid.Error())
+}
+
+func Test_ChangeStatus(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("An error occured when opening a stub database connection. ERROR: %v\n", err)
+	}
+	defer db.Close()
+
+	until := time.Now().UTC().Add(24 * time.Hour)
+	type test struct {
+		status Status
+		until  time.Time
+		rows   int64
+		err    error
+	}
+	cases := []*test{
+		&test{StatusSuspended, until, 1, nil},
+		&test{StatusDisabled, until, 1, nil},
+		&test{StatusActive, time.Time{}, 0, ErrorUserRowNotUpdated},
+		&test{"banned", time.Time{}, 0, ErrorStatusInvalid},
+	}
+	for i, c := range cases {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			if c.err != ErrorStatusInvalid {
+				var u interface{}
+				if c.status == StatusSuspended {
+					u = until
+				}
+				mock.ExpectExec(`UPDATE \[auth]\.\[Users] SET \[Status] = \?, \[StatusReason] = \?, \[StatusChanged] = \?, \[StatusUntil] = \? WHERE \[UserID] = \?`).
+					WithArgs(c.status, "reason", sqlmock.AnyArg(), u, tUser).
+					WillReturnResult(sqlmock.NewResult(0, c.rows))
+			}
+
+			uc := new(UserClient)
+			uc.ChangeStatus(tUser, c.status, "reason", c.until, db)
+			assert.Equal(t, c.err, uc.Err())
+
+			if err = mock.ExpectationsWereMet(); err != nil {
+				t.Errorf("Expectations were not met. ERROR: %v\n", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,90 @@
+package user
+
+import (
+	"errors"
+	"golang.org/x/text/unicode/norm"
+	"os"
+	"strings"
+	"unicode"
+)
+
+const (
+	// ProfileASCII allows UserIDs of ASCII letters and digits only.
+	ProfileASCII = "ascii"
+	// ProfilePRECIS allows UserIDs valid under the UsernameCasePreserved profile of RFC 8265,
+	// normalized to NFC and compared case-insensitively.
+	ProfilePRECIS = "precis"
+)
+
+var (
+	// UsernameProfile is ProfileASCII or ProfilePRECIS.
+	UsernameProfile = usernameProfile(os.Getenv("UsernameProfile"))
+
+	// ReservedUserIDs may not be registered, compared by their lookup keys.
+	ReservedUserIDs = reservedUserIDs(os.Getenv("ReservedUserIDs"))
+
+	ErrorUserIDReserved   = errors.New("UserID is reserved.")
+	ErrorUserIDConfusable = errors.New("UserID is too similar to an existing UserID.")
+)
+
+func usernameProfile(p string) string {
+	if p == ProfilePRECIS {
+		return ProfilePRECIS
+	}
+	return ProfileASCII
+}
+
+// reservedUserIDs parses a comma separated list of reserved UserIDs, defaulting to the names of administrative
+// and mail accounts. UserIDs shorter than UserIDMinLength, e.g. admin or root, can never be registered anyway.
+func reservedUserIDs(list string) []string {
+	if list == "" {
+		list = "administrator,superuser,postmaster,hostmaster,webmaster,security"
+	}
+	var reserved []string
+	for _, id := range strings.Split(list, ",") {
+		if id = strings.TrimSpace(id); id != "" {
+			reserved = append(reserved, id)
+		}
+	}
+	return reserved
+}
+
+// CheckReserved returns ErrorUserIDReserved if userID is one of ReservedUserIDs or confusable with one.
+// The UserIDs are reserved in every tenant.
+func CheckReserved(userID string) error {
+	userID = local(userID)
+	key, skeleton := NormalizeUserID(userID), Skeleton(userID)
+	for _, id := range ReservedUserIDs {
+		if NormalizeUserID(id) == key || Skeleton(id) == skeleton {
+			return ErrorUserIDReserved
+		}
+	}
+	return nil
+}
+
+// confusables maps runes to the ASCII letter they are commonly mistaken for. It covers the Cyrillic
+// and Greek homoglyphs of Latin letters and the digits that resemble letters; it is a small subset
+// of the confusables of Unicode Technical Standard #39.
+var confusables = map[rune]rune{
+	'0': 'o', '1': 'l', '|': 'l',
+	'а': 'a', 'в': 'b', 'е': 'e', 'һ': 'h', 'і': 'i', 'ј': 'j', 'к': 'k', 'ӏ': 'l', 'м': 'm', 'н': 'h',
+	'о': 'o', 'р': 'p', 'ԛ': 'q', 'ѕ': 's', 'т': 't', 'у': 'y', 'ԝ': 'w', 'х': 'x', 'ԁ': 'd', 'с': 'c',
+	'α': 'a', 'β': 'b', 'ε': 'e', 'η': 'n', 'ι': 'i', 'κ': 'k', 'μ': 'u', 'ν': 'v', 'ο': 'o', 'ρ': 'p',
+	'τ': 't', 'υ': 'u', 'χ': 'x', 'ω': 'w',
+}
+
+// Skeleton returns the form of userID two visually confusable UserIDs share: it is decomposed,
+// stripped of combining marks, case folded and has homoglyphs replaced by the letters they resemble.
+func Skeleton(userID string) string {
+	var b strings.Builder
+	for _, r := range norm.NFKD.String(strings.ToLower(userID)) {
+		if unicode.Is(unicode.Mn, r) {
+			continue
+		}
+		if c, ok := confusables[r]; ok {
+			r = c
+		}
+		b.WriteRune(r)
+	}
+	return strings.NewReplacer("rn", "m", "vv", "w").Replace(b.String())
+}
@@ -0,0 +1,102 @@
+package user
+
+import (
+	"github.com/stretchr/testify/assert"
+	"strconv"
+	"testing"
+)
+
+func Test_EnforceUserID(t *testing.T) {
+	defer func(p string) { UsernameProfile = p }(UsernameProfile)
+
+	type test struct {
+		profile  string
+		userID   string
+		enforced string
+		err      error
+	}
+	cases := []*test{
+		&test{ProfileASCII, tUser, tUser, nil},
+		&test{ProfileASCII, "jürgenmüller", "", ErrorUserIDInvalidRunes},
+		&test{ProfileASCII, tUserShort, "", ErrorUserIDShort},
+		&test{ProfilePRECIS, tUser, tUser, nil},
+		&test{ProfilePRECIS, "jürgenmüller", "jürgenmüller", nil},
+		// "u" followed by U+0308 COMBINING DIAERESIS is normalized to "ü".
+		&test{ProfilePRECIS, "ju\u0308rgenmu\u0308ller", "jürgenmüller", nil},
+		&test{ProfilePRECIS, "Ｊｕｅｒｇｅｎ１２", "Juergen12", nil},
+		&test{ProfilePRECIS, "山田太郎さんです", "山田太郎さんです", nil},
+		&test{ProfilePRECIS, "山田太郎", "", ErrorUserIDShort},
+		&test{ProfilePRECIS, "jürgen müller", "", ErrorUserIDInvalidRunes},
+		&test{ProfilePRECIS, "", "", ErrorUserIDShort},
+		&test{ProfilePRECIS, "ü" + tUserLong, "", ErrorUserIDLong},
+		&test{ProfileASCII, "acme/" + tUser, "acme/" + tUser, nil},
+		&test{ProfilePRECIS, "acme/Ｊｕｅｒｇｅｎ１２", "acme/Juergen12", nil},
+		&test{ProfileASCII, "acme/" + tUserShort, "", ErrorUserIDShort},
+		&test{ProfileASCII, "acme/" + tUserLong[:60], "", ErrorUserIDLong},
+		&test{ProfileASCII, "default/" + tUser, "", ErrorUserIDInvalidRunes},
+		&test{ProfileASCII, "Acme/" + tUser, "", ErrorUserIDInvalidRunes},
+		&test{ProfilePRECIS, "acme/eu/" + tUser, "", ErrorUserIDInvalidRunes},
+	}
+	for i, c := range cases {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			UsernameProfile = c.profile
+			enforced, err := EnforceUserID(c.userID)
+			assert.Equal(t, c.err, err)
+			assert.Equal(t, c.enforced, enforced)
+		})
+	}
+}
+
+func Test_NormalizeUserID_precis(t *testing.T) {
+	defer func(p string) { UsernameProfile = p }(UsernameProfile)
+	UsernameProfile = ProfilePRECIS
+
+	assert.Equal(t, NormalizeUserID("jürgenmüller"), NormalizeUserID("JÜRGENMÜLLER"))
+	assert.Equal(t, NormalizeUserID("jürgenmüller"), NormalizeUserID("jürgenmüller"))
+}
+
+func Test_Skeleton(t *testing.T) {
+	type test struct {
+		a, b      string
+		confusing bool
+	}
+	cases := []*test{
+		&test{"paypaluser", "раураluser", true},
+		&test{"testuser", "TestUser", true},
+		&test{"testuser1", "testuserl", true},
+		&test{"modernuser", "modemuser", true},
+		&test{"jürgenmüller", "jurgenmuller", true},
+		&test{"testuser", "otheruser", false},
+	}
+	for i, c := range cases {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			assert.Equal(t, c.confusing, Skeleton(c.a) == Skeleton(c.b))
+		})
+	}
+}
+
+func Test_CheckReserved(t *testing.T) {
+	defer func(r []string) { ReservedUserIDs = r }(ReservedUserIDs)
+	ReservedUserIDs = reservedUserIDs("")
+
+	assert.Equal(t, []string{"administrator", "superuser", "postmaster", "hostmaster", "webmaster", "security"}, ReservedUserIDs)
+	for _, id := range ReservedUserIDs {
+		assert.Nil(t, CheckUserID(id))
+	}
+	assert.EqualError(t, CheckReserved("Superuser"), ErrorUserIDReserved.Error())
+	assert.EqualError(t, CheckReserved("аdministrator"), ErrorUserIDReserved.Error())
+	assert.Nil(t, CheckReserved(tUser))
+	assert.EqualError(t, CheckReserved("acme/postmaster"), ErrorUserIDReserved.Error())
+
+	uc := new(UserClient)
+	_ = uc.NewUser("Administrator", tEmail, tPassword)
+	assert.EqualError(t, uc.Err(), ErrorUserIDReserved.Error())
+
+	ReservedUserIDs = reservedUserIDs("operator, ,security")
+	assert.Equal(t, []string{"operator", "security"}, ReservedUserIDs)
+	assert.EqualError(t, CheckReserved("0perator"), ErrorUserIDReserved.Error())
+
+	uc = new(UserClient)
+	_ = uc.NewUser("Security", tEmail, tPassword)
+	assert.EqualError(t, uc.Err(), ErrorUserIDReserved.Error())
+}
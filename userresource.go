@@ -0,0 +1,203 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"github.com/penutty/authservice/mailer"
+	"github.com/penutty/authservice/user"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+var (
+	// Administrators lists the IDs of the users that may read and change every user, separated by commas.
+	// Users are listed by ID as their UserIDs can be renamed and then registered by someone else.
+	Administrators = strings.Split(os.Getenv("Administrators"), ",")
+
+	ErrorUserForbidden = errors.New("Caller may not access the requested user.")
+	ErrorUserNotFound  = errors.New("Requested user does not exist.")
+)
+
+// userResource is the representation of a user returned by UserEndpoint. It never contains the password.
+// ID never changes; UserID is the login handle of the user and may be changed at RenameEndpoint.
+type userResource struct {
+	ID          string
+	UserID      string
+	Email       string
+	Verified    bool
+	DisplayName string
+	Locale      string
+	Status      user.Status
+}
+
+func newUserResource(u *user.User) *userResource {
+	return &userResource{
+		ID:          u.ID(),
+		UserID:      u.UserID(),
+		Email:       u.Email(),
+		Verified:    u.Verified(),
+		DisplayName: u.DisplayName(),
+		Locale:      u.Locale(),
+		Status:      u.Status(time.Now().UTC()),
+	}
+}
+
+// isAdministrator reports whether the user with ID id is listed in Administrators.
+func isAdministrator(id string) bool {
+	for _, admin := range Administrators {
+		if admin != "" && admin == id {
+			return true
+		}
+	}
+	return false
+}
+
+// targetUser authenticates r and returns the caller and the user the request is about: the user with
+// the "UserID" query parameter, which requires an administrator unless it is the caller, or else the caller.
+// A personal access token must have scope, and administrators cannot act on other users with one.
+func (a *app) targetUser(r *http.Request, scope string) (*user.User, *user.User, error) {
+	return a.target(r, scope)
+}
+
+// loginTargetUser is targetUser, refusing personal access tokens with ErrorPATForbidden.
+func (a *app) loginTargetUser(r *http.Request) (*user.User, *user.User, error) {
+	return a.target(r, "")
+}
+
+// target implements targetUser; an empty scope refuses personal access tokens.
+func (a *app) target(r *http.Request, scope string) (*user.User, *user.User, error) {
+	caller, t, err := a.bearer(r)
+	if err != nil {
+		return nil, nil, err
+	}
+	if t != nil && scope == "" {
+		return nil, nil, ErrorPATForbidden
+	}
+	if t != nil && !t.HasScope(scope) {
+		return nil, nil, ErrorPATScope
+	}
+
+	target := qualify(r, r.URL.Query().Get("UserID"))
+	if target == "" || user.NormalizeUserID(target) == user.NormalizeUserID(caller.UserID()) {
+		return caller, caller, nil
+	}
+	if !isAdministrator(caller.ID()) {
+		return nil, nil, ErrorUserForbidden
+	}
+	if t != nil {
+		return nil, nil, ErrorPATForbidden
+	}
+	u, err := a.fetchUser(target)
+	if err != nil {
+		return nil, nil, err
+	}
+	return caller, u, nil
+}
+
+// fetchUser fetches UserID, returning ErrorUserNotFound if it does not exist.
+func (a *app) fetchUser(UserID string) (*user.User, error) {
+	u := a.c.Fetch(UserID, user.AuthDB())
+	if err := a.c.Err(); err == sql.ErrNoRows {
+		return nil, ErrorUserNotFound
+	} else if err != nil {
+		return nil, err
+	}
+	return u, nil
+}
+
+// fetchUserByID fetches the user with ID id, returning ErrorUserNotFound if it does not exist.
+func (a *app) fetchUserByID(id string) (*user.User, error) {
+	u := a.c.FetchByID(id, user.AuthDB())
+	if err := a.c.Err(); err == sql.ErrNoRows {
+		return nil, ErrorUserNotFound
+	} else if err != nil {
+		return nil, err
+	}
+	return u, nil
+}
+
+// getUser returns the caller, or as an administrator any user.
+func (a *app) getUser(r *http.Request) (*userResource, error) {
+	_, u, err := a.targetUser(r, ScopeUserRead)
+	if err != nil {
+		return nil, err
+	}
+	return newUserResource(u), nil
+}
+
+// patchUser changes the email address and profile of the caller, or as an administrator of any user.
+// Fields missing from the body are left unchanged. A changed email address must be verified again, and as it
+// receives password reset links, it cannot be changed with a personal access token.
+func (a *app) patchUser(r *http.Request) (*userResource, error) {
+	_, u, err := a.loginTargetUser(r)
+	if err != nil {
+		return nil, err
+	}
+
+	type body struct {
+		Email       *string
+		DisplayName *string
+		Locale      *string
+	}
+	b := new(body)
+	if err := json.NewDecoder(r.Body).Decode(b); err != nil {
+		return nil, err
+	}
+
+	email := u.Email()
+	if b.Email != nil {
+		u.SetEmail(*b.Email)
+	}
+	if b.DisplayName != nil {
+		u.SetDisplayName(*b.DisplayName)
+	}
+	if b.Locale != nil {
+		u.SetLocale(*b.Locale)
+	}
+	if err := u.Err(); err != nil {
+		return nil, err
+	}
+
+	a.c.Update(u, user.AuthDB())
+	if err := a.c.Err(); err != nil {
+		return nil, err
+	}
+
+	if u.Email() != email {
+		if err := a.sendVerification(u, mailer.Locale(r.Header.Get("Accept-Language"))); err != nil {
+			logger(Error).Println(err)
+		}
+	}
+	return newUserResource(u), nil
+}
+
+// deleteUser deletes the caller, or as an administrator any user. The body must contain the password of the caller.
+func (a *app) deleteUser(r *http.Request) error {
+	caller, target, err := a.loginTargetUser(r)
+	if err != nil {
+		return err
+	}
+
+	type body struct {
+		Password string
+	}
+	b := new(body)
+	if err := json.NewDecoder(r.Body).Decode(b); err != nil {
+		return err
+	}
+
+	if caller.Password() != b.Password {
+		return ErrorInvalidPass
+	}
+
+	a.c.Delete(target.UserID(), user.AuthDB())
+	if err := a.c.Err(); err == user.ErrorUserRowNotDeleted {
+		return ErrorUserNotFound
+	} else if err != nil {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+var tOtherUser = "otheruser"
+
+// administrators returns the IDs MockUserClient gives the users with userIDs, as listed in Administrators.
+func administrators(userIDs ...string) []string {
+	ids := make([]string, len(userIDs))
+	for i, userID := range userIDs {
+		ids[i] = "id-" + userID
+	}
+	return ids
+}
+
+func NewPasswordBody(p string) *strings.Reader {
+	return strings.NewReader(fmt.Sprintf("{\"Password\": \"%s\"}", p))
+}
+
+func Test_isAdministrator(t *testing.T) {
+	defer func(admins []string) { Administrators = admins }(Administrators)
+
+	// A UserID can be renamed and registered again, so only IDs are administrators.
+	Administrators = []string{tUser}
+	assert.False(t, isAdministrator(tID))
+	Administrators = []string{"", tID}
+	assert.True(t, isAdministrator(tID))
+	assert.False(t, isAdministrator(""))
+}
+
+func Test_userHandler_get(t *testing.T) {
+	defer func(admins []string) { Administrators = admins }(Administrators)
+
+	type test struct {
+		req    *http.Request
+		admins []string
+		code   int
+		userID string
+	}
+	cases := []*test{
+		&test{NewBearerRequest(http.MethodGet, UserEndpoint, nil), nil, http.StatusOK, tUser},
+		&test{NewBearerRequest(http.MethodGet, UserEndpoint+"?UserID="+tUser, nil), nil, http.StatusOK, tUser},
+		&test{NewBearerRequest(http.MethodGet, UserEndpoint+"?UserID="+tOtherUser, nil), nil, http.StatusForbidden, ""},
+		&test{NewBearerRequest(http.MethodGet, UserEndpoint+"?UserID="+tOtherUser, nil), []string{tUser}, http.StatusOK, tOtherUser},
+		&test{NewBearerRequest(http.MethodGet, UserEndpoint+"?UserID="+tUserMissing, nil), []string{tUser}, http.StatusNotFound, ""},
+		&test{httptest.NewRequest(http.MethodGet, UserEndpoint, nil), nil, http.StatusUnauthorized, ""},
+	}
+	for i, c := range cases {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			Administrators = administrators(c.admins...)
+			a, _, _ := newVerifyApp()
+			rec := httptest.NewRecorder()
+			a.userHandler(rec, c.req)
+			assert.Equal(t, c.code, rec.Code)
+			if c.code != http.StatusOK {
+				return
+			}
+			assert.NotContains(t, rec.Body.String(), "Password")
+			res := new(userResource)
+			assert.Nil(t, json.NewDecoder(rec.Body).Decode(res))
+			assert.Equal(t, c.userID, res.UserID)
+			assert.Equal(t, tEmail, res.Email)
+		})
+	}
+}
+
+func Test_userHandler_patch(t *testing.T) {
+	type test struct {
+		body     string
+		code     int
+		verified bool
+		mails    int
+	}
+	cases := []*test{
+		&test{`{"DisplayName": "Test User", "Locale": "de-DE"}`, http.StatusOK, true, 0},
+		&test{`{"Email": "<otheremail@email.com>"}`, http.StatusOK, false, 1},
+		&test{`{"Locale": "de_DE"}`, http.StatusBadRequest, true, 0},
+		&test{`{"Email": "bad"}`, http.StatusBadRequest, true, 0},
+	}
+	for i, c := range cases {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			a, m, mail := newVerifyApp()
+			m.verified = true
+			rec := httptest.NewRecorder()
+			a.userHandler(rec, NewBearerRequest(http.MethodPatch, UserEndpoint, strings.NewReader(c.body)))
+			assert.Equal(t, c.code, rec.Code)
+			assert.Len(t, mail.Messages, c.mails)
+			if c.code != http.StatusOK {
+				assert.Nil(t, m.updated)
+				return
+			}
+			res := new(userResource)
+			assert.Nil(t, json.NewDecoder(rec.Body).Decode(res))
+			assert.Equal(t, c.verified, res.Verified)
+			assert.Equal(t, m.updated.Email(), res.Email)
+			assert.Equal(t, m.updated.DisplayName(), res.DisplayName)
+		})
+	}
+}
+
+func Test_userHandler_patchPAT(t *testing.T) {
+	a, _, secret := newPATApp()
+	m := a.c.(*MockUserClient)
+	rec := httptest.NewRecorder()
+	a.userHandler(rec, NewPATRequest(http.MethodPatch, UserEndpoint, secret, strings.NewReader(`{"Email": "<attacker@email.com>"}`)))
+	assert.Equal(t, http.StatusForbidden, rec.Code)
+	assert.Nil(t, m.updated)
+}
+
+func Test_userHandler_delete(t *testing.T) {
+	defer func(admins []string) { Administrators = admins }(Administrators)
+
+	type test struct {
+		req     *http.Request
+		admins  []string
+		code    int
+		deleted string
+	}
+	cases := []*test{
+		&test{NewBearerRequest(http.MethodDelete, UserEndpoint, NewPasswordBody(tPassword)), nil, http.StatusNoContent, tUser},
+		&test{NewBearerRequest(http.MethodDelete, UserEndpoint, NewPasswordBody("WrongPassword1!")), nil, http.StatusBadRequest, ""},
+		&test{NewBearerRequest(http.MethodDelete, UserEndpoint+"?UserID="+tOtherUser, NewPasswordBody(tPassword)), nil, http.StatusForbidden, ""},
+		&test{NewBearerRequest(http.MethodDelete, UserEndpoint+"?UserID="+tOtherUser, NewPasswordBody(tPassword)), []string{tUser}, http.StatusNoContent, tOtherUser},
+		&test{httptest.NewRequest(http.MethodDelete, UserEndpoint, NewPasswordBody(tPassword)), nil, http.StatusUnauthorized, ""},
+	}
+	for i, c := range cases {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			Administrators = administrators(c.admins...)
+			a, m, _ := newVerifyApp()
+			rec := httptest.NewRecorder()
+			a.userHandler(rec, c.req)
+			assert.Equal(t, c.code, rec.Code)
+			assert.Equal(t, c.deleted, m.deleted)
+		})
+	}
+}
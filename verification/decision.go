@@ -0,0 +1,87 @@
+package verification
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+)
+
+var (
+	ErrorDecisionUnavailable = errors.New("Policy decision endpoint did not return a decision.")
+)
+
+// CheckRequest asks whether a subject may perform Action on Resource. The "type" attribute of Resource selects
+// the rules that apply. If SubjectToken is an access token issued by Auth-Service, the attributes of its subject
+// ("id", "username", "roles", "permissions", "groups") replace those given in Subject.
+type CheckRequest struct {
+	SubjectToken string                 `json:",omitempty"`
+	Subject      map[string]interface{} `json:",omitempty"`
+	Action       string
+	Resource     map[string]interface{} `json:",omitempty"`
+	Context      map[string]interface{} `json:",omitempty"`
+}
+
+// Decision is the answer to a CheckRequest. ID identifies the decision in the decision log of Auth-Service;
+// Policy and Rule name the rule that caused it and are empty if no rule applied.
+type Decision struct {
+	ID      string
+	Allowed bool
+	Policy  string
+	Rule    string
+}
+
+// DecisionClient asks the policy decision endpoint of Auth-Service, authenticating as the exchange client ClientID.
+type DecisionClient struct {
+	// Endpoint is the absolute URL of the endpoint, e.g. "https://auth.example.com/authorize/check".
+	Endpoint string
+	ClientID string
+	Secret   string
+	// HTTPClient sends the requests; http.DefaultClient if nil.
+	HTTPClient *http.Client
+}
+
+// Check returns the decision on req.
+func (c *DecisionClient) Check(req *CheckRequest) (*Decision, error) {
+	b, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+	r, err := http.NewRequest(http.MethodPost, c.Endpoint, bytes.NewReader(b))
+	if err != nil {
+		return nil, err
+	}
+	r.Header.Set("Content-Type", "application/json")
+	r.SetBasicAuth(c.ClientID, c.Secret)
+
+	client := c.HTTPClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(r)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, ErrorDecisionUnavailable
+	}
+
+	d := new(Decision)
+	if err := json.NewDecoder(resp.Body).Decode(d); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+// Allowed returns ErrorPermissionDenied unless the subject of token may perform action on resource.
+func (c *DecisionClient) Allowed(token, action string, resource map[string]interface{}) error {
+	d, err := c.Check(&CheckRequest{SubjectToken: token, Action: action, Resource: resource})
+	if err != nil {
+		return err
+	}
+	if !d.Allowed {
+		return ErrorPermissionDenied
+	}
+	return nil
+}
@@ -0,0 +1,52 @@
+package verification
+
+import (
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+)
+
+func Test_DecisionClient(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if id, secret, ok := r.BasicAuth(); !ok || id != "moment-service" || secret != "secret" {
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return
+		}
+		req := new(CheckRequest)
+		if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+			http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+			return
+		}
+		d := &Decision{ID: "d1"}
+		if req.SubjectToken == "owner" && req.Resource["owner"] == "u1" {
+			d.Allowed, d.Policy, d.Rule = true, "moments", "owner"
+		}
+		json.NewEncoder(w).Encode(d)
+	}))
+	defer srv.Close()
+
+	type test struct {
+		secret string
+		token  string
+		err    error
+	}
+	cases := []*test{
+		&test{"secret", "owner", nil},
+		&test{"secret", "other", ErrorPermissionDenied},
+		&test{"wrong", "owner", ErrorDecisionUnavailable},
+	}
+	for i, c := range cases {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			dc := &DecisionClient{Endpoint: srv.URL, ClientID: "moment-service", Secret: c.secret}
+			assert.Equal(t, c.err, dc.Allowed(c.token, "moments:edit", map[string]interface{}{"type": "moment", "owner": "u1"}))
+		})
+	}
+
+	dc := &DecisionClient{Endpoint: srv.URL, ClientID: "moment-service", Secret: "secret"}
+	d, err := dc.Check(&CheckRequest{SubjectToken: "owner", Action: "moments:edit", Resource: map[string]interface{}{"owner": "u1"}})
+	assert.Nil(t, err)
+	assert.Equal(t, &Decision{ID: "d1", Allowed: true, Policy: "moments", Rule: "owner"}, d)
+}
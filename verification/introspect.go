@@ -0,0 +1,90 @@
+package verification
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+var (
+	ErrorIntrospectionUnavailable = errors.New("Introspection endpoint did not return a result.")
+	ErrorTokenInactive            = errors.New("Token is not active.")
+)
+
+// Introspection is the RFC 7662 introspection response of Auth-Service about a token. Only Active is set for
+// tokens that are invalid, expired, revoked or issued for another tenant than that of the client. Personal access
+// tokens have TokenType "personal_access_token" and API keys of service accounts "api_key"; both have no audience,
+// and Exp is zero if they do not expire.
+type Introspection struct {
+	Active    bool        `json:"active"`
+	Scope     string      `json:"scope,omitempty"`
+	ClientID  string      `json:"client_id,omitempty"`
+	Username  string      `json:"username,omitempty"`
+	TokenType string      `json:"token_type,omitempty"`
+	Exp       int64       `json:"exp,omitempty"`
+	Iat       int64       `json:"iat,omitempty"`
+	Sub       string      `json:"sub,omitempty"`
+	Aud       interface{} `json:"aud,omitempty"`
+	Iss       string      `json:"iss,omitempty"`
+	Tenant    string      `json:"tid,omitempty"`
+}
+
+// Scopes returns the scopes of i.
+func (i *Introspection) Scopes() []string {
+	return strings.Fields(i.Scope)
+}
+
+// IntrospectionClient asks the introspection endpoint of Auth-Service about tokens that cannot be verified
+// offline, such as personal access tokens, authenticating as the exchange client ClientID.
+type IntrospectionClient struct {
+	// Endpoint is the absolute URL of the endpoint, e.g. "https://auth.example.com/token/introspect".
+	Endpoint string
+	ClientID string
+	Secret   string
+	// HTTPClient sends the requests; http.DefaultClient if nil.
+	HTTPClient *http.Client
+}
+
+// Introspect returns the introspection response about token.
+func (c *IntrospectionClient) Introspect(token string) (*Introspection, error) {
+	form := url.Values{"token": {token}}
+	r, err := http.NewRequest(http.MethodPost, c.Endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	r.SetBasicAuth(c.ClientID, c.Secret)
+
+	client := c.HTTPClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(r)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, ErrorIntrospectionUnavailable
+	}
+
+	i := new(Introspection)
+	if err := json.NewDecoder(resp.Body).Decode(i); err != nil {
+		return nil, err
+	}
+	return i, nil
+}
+
+// Active returns the introspection response about token, or ErrorTokenInactive unless it is active.
+func (c *IntrospectionClient) Active(token string) (*Introspection, error) {
+	i, err := c.Introspect(token)
+	if err != nil {
+		return nil, err
+	}
+	if !i.Active {
+		return nil, ErrorTokenInactive
+	}
+	return i, nil
+}
@@ -0,0 +1,47 @@
+package verification
+
+import (
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+)
+
+func Test_IntrospectionClient(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if id, secret, ok := r.BasicAuth(); !ok || id != "moment-service" || secret != "secret" {
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return
+		}
+		i := new(Introspection)
+		if r.PostFormValue("token") == "active" {
+			i = &Introspection{Active: true, Sub: "u1", Username: "alice", Scope: "repo:read ci", TokenType: "personal_access_token"}
+		}
+		json.NewEncoder(w).Encode(i)
+	}))
+	defer srv.Close()
+
+	type test struct {
+		secret string
+		token  string
+		err    error
+	}
+	cases := []*test{
+		&test{"secret", "active", nil},
+		&test{"secret", "revoked", ErrorTokenInactive},
+		&test{"wrong", "active", ErrorIntrospectionUnavailable},
+	}
+	for i, c := range cases {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			ic := &IntrospectionClient{Endpoint: srv.URL, ClientID: "moment-service", Secret: c.secret}
+			res, err := ic.Active(c.token)
+			assert.Equal(t, c.err, err)
+			if c.err == nil {
+				assert.Equal(t, "u1", res.Sub)
+				assert.Equal(t, []string{"repo:read", "ci"}, res.Scopes())
+			}
+		})
+	}
+}
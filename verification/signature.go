@@ -0,0 +1,178 @@
+package verification
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/penutty/authservice/signing"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// TokenTypeSignature is the TokenType of the introspection responses about signed requests.
+const TokenTypeSignature = "hmac_signature"
+
+var (
+	// ReplayWindow is how far the timestamp of a signed request may be from the current time by default.
+	ReplayWindow = 5 * time.Minute
+
+	ErrorSignatureUnavailable = errors.New("Signature verification endpoint did not return a result.")
+	ErrorTimestampSkewed      = errors.New("Request timestamp is outside the replay window.")
+	ErrorNonceReused          = errors.New("Request nonce was already used.")
+)
+
+// SignatureCheck asks Auth-Service whether Signature is the signature of StringToSign with the signing key KeyID.
+type SignatureCheck struct {
+	KeyID        string
+	StringToSign string
+	Signature    string
+}
+
+// NonceCache remembers the nonces of signed requests until they leave the replay window.
+type NonceCache interface {
+	// Add adds key until expires and reports whether it was not present yet.
+	Add(key string, expires time.Time) bool
+}
+
+// NonceMemory is a NonceCache for a single instance of a service.
+type NonceMemory struct {
+	mu     sync.Mutex
+	nonces map[string]time.Time
+}
+
+func NewNonceMemory() *NonceMemory {
+	return &NonceMemory{nonces: make(map[string]time.Time)}
+}
+
+func (m *NonceMemory) Add(key string, expires time.Time) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	if e, ok := m.nonces[key]; ok && now.Before(e) {
+		return false
+	}
+	for k, e := range m.nonces {
+		if !now.Before(e) {
+			delete(m.nonces, k)
+		}
+	}
+	m.nonces[key] = expires
+	return true
+}
+
+// SignatureVerifier verifies requests signed by service accounts, see package signing. It rejects requests whose
+// timestamp is more than Window from the current time and nonces seen within it, and asks the signature
+// verification endpoint of Auth-Service to check the signature, authenticating as the exchange client ClientID.
+type SignatureVerifier struct {
+	// Endpoint is the absolute URL of the endpoint, e.g. "https://auth.example.com/signature/verify".
+	Endpoint string
+	ClientID string
+	Secret   string
+	// HTTPClient sends the requests; http.DefaultClient if nil.
+	HTTPClient *http.Client
+	Window     time.Duration
+	Nonces     NonceCache
+}
+
+// NewSignatureVerifier returns a SignatureVerifier with a Window of ReplayWindow keeping nonces in a NonceMemory.
+func NewSignatureVerifier(endpoint, clientID, secret string) *SignatureVerifier {
+	return &SignatureVerifier{
+		Endpoint: endpoint,
+		ClientID: clientID,
+		Secret:   secret,
+		Window:   ReplayWindow,
+		Nonces:   NewNonceMemory(),
+	}
+}
+
+// Verify returns the introspection response about the service account that signed r. The nonce of r is only
+// remembered once its signature is verified, so that unsigned requests cannot use up the nonces of others.
+func (v *SignatureVerifier) Verify(r *http.Request) (*Introspection, error) {
+	sig, err := signing.ParseAuthorization(r.Header.Get("Authorization"))
+	if err != nil {
+		return nil, err
+	}
+	if skew := time.Since(sig.Timestamp); skew > v.Window || skew < -v.Window {
+		return nil, ErrorTimestampSkewed
+	}
+	str, err := signing.StringToSign(r, sig.Timestamp, sig.Nonce)
+	if err != nil {
+		return nil, err
+	}
+
+	i, err := v.check(&SignatureCheck{KeyID: sig.KeyID, StringToSign: str, Signature: sig.Signature})
+	if err != nil {
+		return nil, err
+	}
+	if !i.Active {
+		return nil, signing.ErrorSignatureInvalid
+	}
+	if !v.Nonces.Add(sig.KeyID+":"+sig.Nonce, sig.Timestamp.Add(v.Window)) {
+		return nil, ErrorNonceReused
+	}
+	return i, nil
+}
+
+// check posts c to the signature verification endpoint.
+func (v *SignatureVerifier) check(c *SignatureCheck) (*Introspection, error) {
+	b, err := json.Marshal(c)
+	if err != nil {
+		return nil, err
+	}
+	r, err := http.NewRequest(http.MethodPost, v.Endpoint, bytes.NewReader(b))
+	if err != nil {
+		return nil, err
+	}
+	r.Header.Set("Content-Type", "application/json")
+	r.SetBasicAuth(v.ClientID, v.Secret)
+
+	client := v.HTTPClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(r)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, ErrorSignatureUnavailable
+	}
+
+	i := new(Introspection)
+	if err := json.NewDecoder(resp.Body).Decode(i); err != nil {
+		return nil, err
+	}
+	return i, nil
+}
+
+// signerKey is the key of the introspection response about the signer of a request in its context.
+type signerKey struct{}
+
+// Wrap returns a handler that passes requests verified by v to h, see Signer, and rejects others with
+// 401 Unauthorized, or 503 Service Unavailable if their signature could not be checked.
+func (v *SignatureVerifier) Wrap(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		i, err := v.Verify(r)
+		switch err {
+		case nil:
+			h.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), signerKey{}, i)))
+		case signing.ErrorSignatureMissing, signing.ErrorSignatureMalformed, signing.ErrorSignatureInvalid,
+			ErrorTimestampSkewed, ErrorNonceReused:
+			w.Header().Set("WWW-Authenticate", signing.Scheme)
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+		default:
+			http.Error(w, http.StatusText(http.StatusServiceUnavailable), http.StatusServiceUnavailable)
+		}
+	})
+}
+
+// Signer returns the introspection response about the service account that signed r, or nil if r was not
+// verified by SignatureVerifier.Wrap.
+func Signer(r *http.Request) *Introspection {
+	i, _ := r.Context().Value(signerKey{}).(*Introspection)
+	return i
+}
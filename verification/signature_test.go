@@ -0,0 +1,151 @@
+package verification
+
+import (
+	"encoding/json"
+	"github.com/penutty/authservice/signing"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+var (
+	tKeyID         = "01890a5d-ac96-774b-bcce-b302099a8059"
+	tSigningSecret = "ahs_0123456789abcdefghijklmnopqrstuvwxyzABCD00000000"
+)
+
+func Test_NonceMemory(t *testing.T) {
+	m := NewNonceMemory()
+	assert.True(t, m.Add("a", time.Now().Add(time.Minute)))
+	assert.False(t, m.Add("a", time.Now().Add(time.Minute)))
+	assert.True(t, m.Add("b", time.Now().Add(-time.Second)))
+	assert.True(t, m.Add("b", time.Now().Add(time.Minute)))
+	assert.Len(t, m.nonces, 2)
+}
+
+// newSignatureEndpoint returns a fake signature verification endpoint knowing tSigningSecret, and the checks it received.
+func newSignatureEndpoint(t *testing.T) (*httptest.Server, *[]*SignatureCheck) {
+	var checks []*SignatureCheck
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if id, secret, _ := r.BasicAuth(); id != "moment-service" || secret != "secret" {
+			http.Error(w, "invalid_client", http.StatusUnauthorized)
+			return
+		}
+		c := new(SignatureCheck)
+		if err := json.NewDecoder(r.Body).Decode(c); err != nil {
+			t.Fatal(err)
+		}
+		checks = append(checks, c)
+		if c.KeyID != tKeyID || !signing.Valid(tSigningSecret, c.StringToSign, c.Signature) {
+			json.NewEncoder(w).Encode(&Introspection{})
+			return
+		}
+		json.NewEncoder(w).Encode(&Introspection{Active: true, Sub: "sa-partner", TokenType: TokenTypeSignature})
+	}))
+	return srv, &checks
+}
+
+func Test_SignatureVerifier(t *testing.T) {
+	srv, checks := newSignatureEndpoint(t)
+	defer srv.Close()
+
+	signer := &signing.Signer{KeyID: tKeyID, Secret: tSigningSecret}
+	signed := func(method, body string) *http.Request {
+		r := httptest.NewRequest(method, "/moments?limit=5", strings.NewReader(body))
+		if err := signer.Sign(r); err != nil {
+			t.Fatal(err)
+		}
+		return r
+	}
+	resign := func(r *http.Request, ts time.Time) *http.Request {
+		str, err := signing.StringToSign(r, ts, "n0nce")
+		if err != nil {
+			t.Fatal(err)
+		}
+		sig := &signing.Signature{KeyID: tKeyID, Timestamp: ts, Nonce: "n0nce", Signature: signing.Sum(tSigningSecret, str)}
+		r.Header.Set("Authorization", sig.String())
+		return r
+	}
+	replayed := signed(http.MethodPost, "{}")
+
+	type test struct {
+		req    *http.Request
+		secret string
+		err    error
+		checks int
+	}
+	cases := []*test{
+		&test{signed(http.MethodPost, `{"a":1}`), "secret", nil, 1},
+		&test{replayed, "secret", nil, 1},
+		&test{replayed, "secret", ErrorNonceReused, 1},
+		&test{func() *http.Request {
+			r := signed(http.MethodPost, `{"a":1}`)
+			r.Body = httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{"a":2}`)).Body
+			return r
+		}(), "secret", signing.ErrorSignatureInvalid, 1},
+		&test{func() *http.Request {
+			r := signed(http.MethodGet, "")
+			r.Method = http.MethodDelete
+			return r
+		}(), "secret", signing.ErrorSignatureInvalid, 1},
+		&test{resign(httptest.NewRequest(http.MethodGet, "/moments", nil), time.Now().Add(-time.Hour)), "secret", ErrorTimestampSkewed, 0},
+		&test{resign(httptest.NewRequest(http.MethodGet, "/moments", nil), time.Now().Add(time.Hour)), "secret", ErrorTimestampSkewed, 0},
+		&test{httptest.NewRequest(http.MethodGet, "/moments", nil), "secret", signing.ErrorSignatureMissing, 0},
+		&test{signed(http.MethodGet, ""), "wrong", ErrorSignatureUnavailable, 0},
+	}
+	v := NewSignatureVerifier(srv.URL, "moment-service", "")
+	for i, c := range cases {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			*checks = nil
+			v.Secret = c.secret
+			i, err := v.Verify(c.req)
+			assert.Equal(t, c.err, err)
+			assert.Len(t, *checks, c.checks)
+			if c.err == nil {
+				assert.Equal(t, "sa-partner", i.Sub)
+			}
+		})
+	}
+}
+
+func Test_SignatureVerifier_Wrap(t *testing.T) {
+	srv, _ := newSignatureEndpoint(t)
+	defer srv.Close()
+
+	var signer *Introspection
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		signer = Signer(r)
+	})
+	cases := []struct {
+		key      string
+		endpoint string
+		code     int
+	}{
+		{tKeyID, srv.URL, http.StatusOK},
+		{"other", srv.URL, http.StatusUnauthorized},
+		{tKeyID, "http://127.0.0.1:0", http.StatusServiceUnavailable},
+	}
+	for i, c := range cases {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			signer = nil
+			r := httptest.NewRequest(http.MethodGet, "/moments", nil)
+			if err := (&signing.Signer{KeyID: c.key, Secret: tSigningSecret}).Sign(r); err != nil {
+				t.Fatal(err)
+			}
+			rec := httptest.NewRecorder()
+			NewSignatureVerifier(c.endpoint, "moment-service", "secret").Wrap(next).ServeHTTP(rec, r)
+			assert.Equal(t, c.code, rec.Code)
+			if c.code == http.StatusOK {
+				assert.Equal(t, "sa-partner", signer.Sub)
+				return
+			}
+			assert.Nil(t, signer)
+		})
+	}
+
+	r := httptest.NewRequest(http.MethodGet, "/moments", nil)
+	assert.Nil(t, Signer(r))
+}
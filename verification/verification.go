@@ -0,0 +1,223 @@
+// Package verification validates JSON web tokens issued by Auth-Service.
+// It is intended to be imported by Auth-Service itself and by the services that consume its tokens.
+package verification
+
+import (
+	"errors"
+	"github.com/dgrijalva/jwt-go"
+	"github.com/penutty/authservice/tenant"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+var (
+	Issuer        = "Auth-Service"
+	PublicKeyPath = os.Getenv("GOPATH") + "/src/github.com/penutty/authservice/.ssh/jwt_public.pem"
+	// TenantKeyDir holds a directory per tenant other than the default one, named by its ID, with the
+	// jwt_public.pem its tokens are verified with.
+	TenantKeyDir = os.Getenv("GOPATH") + "/src/github.com/penutty/authservice/.ssh/tenants"
+
+	ErrorSigningMethodInvalid = errors.New("Token must be signed with RS256.")
+	ErrorTokenInvalid         = errors.New("Token is invalid.")
+	ErrorIssuerInvalid        = errors.New("Token was not issued by Auth-Service.")
+	ErrorExpirationMissing    = errors.New("Token does not contain an expiration.")
+	ErrorAudienceInvalid      = errors.New("Token audience is invalid.")
+	ErrorPermissionDenied     = errors.New("Token does not grant the required permission.")
+	ErrorTenantInvalid        = errors.New("Token names an invalid tenant.")
+)
+
+// PublicKey reads the RSA public key used to verify tokens from PublicKeyPath.
+func PublicKey() (interface{}, error) {
+	p, err := ioutil.ReadFile(PublicKeyPath)
+	if err != nil {
+		return nil, err
+	}
+	return jwt.ParseRSAPublicKeyFromPEM(p)
+}
+
+// TenantPublicKey reads the RSA public key used to verify the tokens of the tenant with ID tenantID.
+func TenantPublicKey(tenantID string) (interface{}, error) {
+	if tenantID == tenant.Default {
+		return PublicKey()
+	}
+	if err := tenant.CheckID(tenantID); err != nil {
+		return nil, ErrorTenantInvalid
+	}
+	p, err := ioutil.ReadFile(filepath.Join(TenantKeyDir, tenantID, "jwt_public.pem"))
+	if err != nil {
+		return nil, err
+	}
+	return jwt.ParseRSAPublicKeyFromPEM(p)
+}
+
+// IssuerFor returns the issuer of the tokens of the tenant with ID tenantID.
+func IssuerFor(tenantID string) string {
+	if tenantID == "" || tenantID == tenant.Default {
+		return Issuer
+	}
+	return Issuer + "/" + tenantID
+}
+
+// Tenant returns the "tid" claim of claims, the ID of the tenant the token was issued for.
+// Tokens without one were issued for the default tenant.
+func Tenant(claims jwt.MapClaims) string {
+	if tid, ok := claims["tid"].(string); ok && tid != "" {
+		return tid
+	}
+	return tenant.Default
+}
+
+// AccountTypeService is the "account_type" claim of tokens issued to service accounts.
+const AccountTypeService = "service"
+
+// ServiceAccount reports whether claims were issued to a service account rather than a user; their subject
+// is then the ID of the service account.
+func ServiceAccount(claims jwt.MapClaims) bool {
+	return claims["account_type"] == AccountTypeService
+}
+
+// Parse verifies the signature, issuer and expiration of token and returns its claims. The token is
+// verified with the key and issuer of the tenant in its "tid" claim.
+func Parse(token string) (jwt.MapClaims, error) {
+	t, err := jwt.Parse(token, func(t *jwt.Token) (interface{}, error) {
+		if _, ok := t.Method.(*jwt.SigningMethodRSA); !ok {
+			return nil, ErrorSigningMethodInvalid
+		}
+		claims, _ := t.Claims.(jwt.MapClaims)
+		return TenantPublicKey(Tenant(claims))
+	})
+	if err != nil {
+		if ve, ok := err.(*jwt.ValidationError); ok && ve.Inner != nil {
+			return nil, ve.Inner
+		}
+		return nil, err
+	}
+
+	claims, ok := t.Claims.(jwt.MapClaims)
+	if !ok || !t.Valid {
+		return nil, ErrorTokenInvalid
+	}
+	if !claims.VerifyIssuer(IssuerFor(Tenant(claims)), true) {
+		return nil, ErrorIssuerInvalid
+	}
+	if !claims.VerifyExpiresAt(time.Now().UTC().Unix(), true) {
+		return nil, ErrorExpirationMissing
+	}
+	return claims, nil
+}
+
+// ParseAudience parses token and returns an error unless it was issued for audience.
+func ParseAudience(token, audience string) (jwt.MapClaims, error) {
+	claims, err := Parse(token)
+	if err != nil {
+		return nil, err
+	}
+	if !claims.VerifyAudience(audience, true) {
+		return nil, ErrorAudienceInvalid
+	}
+	return claims, nil
+}
+
+// Roles returns the "roles" claim of claims.
+func Roles(claims jwt.MapClaims) []string {
+	return stringsClaim(claims, "roles")
+}
+
+// Permissions returns the "permissions" claim of claims.
+func Permissions(claims jwt.MapClaims) []string {
+	return stringsClaim(claims, "permissions")
+}
+
+// Groups returns the "groups" claim of claims. A user in more groups than fit in a token has no "groups"
+// claim; GroupsEndpoint then returns where to look them up.
+func Groups(claims jwt.MapClaims) []string {
+	return stringsClaim(claims, "groups")
+}
+
+// GroupsEndpoint returns the endpoint the groups of the subject of claims must be fetched from with the
+// token as bearer token, or "" if the token contains them. The endpoint is announced as an OpenID Connect
+// distributed claim in "_claim_names" and "_claim_sources".
+func GroupsEndpoint(claims jwt.MapClaims) string {
+	names, ok := claims["_claim_names"].(map[string]interface{})
+	if !ok {
+		return ""
+	}
+	source, ok := names["groups"].(string)
+	if !ok {
+		return ""
+	}
+	sources, ok := claims["_claim_sources"].(map[string]interface{})
+	if !ok {
+		return ""
+	}
+	src, ok := sources[source].(map[string]interface{})
+	if !ok {
+		return ""
+	}
+	endpoint, _ := src["endpoint"].(string)
+	return endpoint
+}
+
+// stringsClaim returns the claim name of claims if it is a list of strings.
+func stringsClaim(claims jwt.MapClaims, name string) []string {
+	switch v := claims[name].(type) {
+	case []string:
+		return v
+	case []interface{}:
+		s := make([]string, 0, len(v))
+		for _, e := range v {
+			if str, ok := e.(string); ok {
+				s = append(s, str)
+			}
+		}
+		return s
+	}
+	return nil
+}
+
+// HasRole reports whether claims contain role.
+func HasRole(claims jwt.MapClaims, role string) bool {
+	for _, r := range Roles(claims) {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+// Granted reports whether claims grant permission, either exactly or through a wildcard:
+// "*" grants every permission and e.g. "moments:*" every permission starting with "moments:".
+func Granted(claims jwt.MapClaims, permission string) bool {
+	for _, p := range Permissions(claims) {
+		if p == permission || p == "*" || strings.HasSuffix(p, ":*") && strings.HasPrefix(permission, strings.TrimSuffix(p, "*")) {
+			return true
+		}
+	}
+	return false
+}
+
+// Check returns ErrorPermissionDenied unless claims grant every one of permissions.
+func Check(claims jwt.MapClaims, permissions ...string) error {
+	for _, p := range permissions {
+		if !Granted(claims, p) {
+			return ErrorPermissionDenied
+		}
+	}
+	return nil
+}
+
+// Authorize parses token for audience and returns ErrorPermissionDenied unless it grants every one of permissions.
+// Roles and permissions are those of the user when the token was issued.
+func Authorize(token, audience string, permissions ...string) (jwt.MapClaims, error) {
+	claims, err := ParseAudience(token, audience)
+	if err != nil {
+		return nil, err
+	}
+	if err := Check(claims, permissions...); err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
@@ -0,0 +1,251 @@
+package verification
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"github.com/dgrijalva/jwt-go"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+var (
+	tUser     = "testuser"
+	tAudience = "Moment-Service"
+)
+
+func signClaims(t *testing.T, claims jwt.MapClaims) string {
+	p, err := ioutil.ReadFile(os.Getenv("GOPATH") + "/src/github.com/penutty/authservice/.ssh/jwt_private.pem")
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := jwt.ParseRSAPrivateKeyFromPEM(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, err := jwt.NewWithClaims(jwt.SigningMethodRS256, claims).SignedString(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+func newClaims() jwt.MapClaims {
+	return jwt.MapClaims{
+		"iss": Issuer,
+		"sub": tUser,
+		"aud": tAudience,
+		"exp": time.Now().UTC().Add(time.Hour).Unix(),
+		"iat": time.Now().UTC().Unix(),
+	}
+}
+
+func Test_PublicKey(t *testing.T) {
+	key, err := PublicKey()
+	assert.Nil(t, err)
+	assert.NotNil(t, key)
+}
+
+func Test_Parse(t *testing.T) {
+	t.Run("1", func(t *testing.T) {
+		claims, err := Parse(signClaims(t, newClaims()))
+		assert.Nil(t, err)
+		assert.Equal(t, tUser, claims["sub"])
+	})
+
+	type claimsErrPair struct {
+		claims jwt.MapClaims
+		err    error
+	}
+	wrongIssuer := newClaims()
+	wrongIssuer["iss"] = "Other-Service"
+	noExpiration := newClaims()
+	delete(noExpiration, "exp")
+
+	testVars := []*claimsErrPair{
+		&claimsErrPair{wrongIssuer, ErrorIssuerInvalid},
+		&claimsErrPair{noExpiration, ErrorExpirationMissing},
+	}
+	for i, v := range testVars {
+		t.Run(strconv.Itoa(i+2), func(t *testing.T) {
+			_, err := Parse(signClaims(t, v.claims))
+			assert.EqualError(t, err, v.err.Error())
+		})
+	}
+
+	t.Run("expired", func(t *testing.T) {
+		expired := newClaims()
+		expired["exp"] = time.Now().UTC().Add(-time.Hour).Unix()
+		_, err := Parse(signClaims(t, expired))
+		assert.Error(t, err)
+	})
+
+	t.Run("hmac", func(t *testing.T) {
+		token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, newClaims()).SignedString([]byte("secret"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = Parse(token)
+		assert.EqualError(t, err, ErrorSigningMethodInvalid.Error())
+	})
+}
+
+// tenantKey writes the public key of a new RSA key pair for tenantID to a temporary TenantKeyDir and returns
+// the private key.
+func tenantKey(t *testing.T, tenantID string) *rsa.PrivateKey {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(filepath.Join(TenantKeyDir, tenantID), 0700); err != nil {
+		t.Fatal(err)
+	}
+	p := pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})
+	if err := ioutil.WriteFile(filepath.Join(TenantKeyDir, tenantID, "jwt_public.pem"), p, 0600); err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func Test_Parse_tenant(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tenants")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer func(d string) { TenantKeyDir = d }(TenantKeyDir)
+	TenantKeyDir = dir
+	key := tenantKey(t, "acme")
+
+	sign := func(claims jwt.MapClaims) string {
+		token, err := jwt.NewWithClaims(jwt.SigningMethodRS256, claims).SignedString(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return token
+	}
+	acme := newClaims()
+	acme["iss"], acme["tid"] = IssuerFor("acme"), "acme"
+	defaultIssuer := newClaims()
+	defaultIssuer["tid"] = "acme"
+	traversal := newClaims()
+	traversal["iss"], traversal["tid"] = IssuerFor("../acme"), "../acme"
+
+	claims, err := Parse(sign(acme))
+	assert.Nil(t, err)
+	assert.Equal(t, "acme", Tenant(claims))
+
+	_, err = Parse(sign(defaultIssuer))
+	assert.Equal(t, ErrorIssuerInvalid, err)
+	_, err = Parse(sign(traversal))
+	assert.Equal(t, ErrorTenantInvalid, err)
+	// A token of the default tenant cannot be passed off as one of acme, nor one of acme as one of the default tenant.
+	_, err = Parse(signClaims(t, acme))
+	assert.NotNil(t, err)
+	_, err = Parse(sign(newClaims()))
+	assert.NotNil(t, err)
+}
+
+func Test_IssuerFor(t *testing.T) {
+	assert.Equal(t, Issuer, IssuerFor(""))
+	assert.Equal(t, Issuer, IssuerFor("default"))
+	assert.Equal(t, Issuer+"/acme", IssuerFor("acme"))
+	assert.Equal(t, "default", Tenant(newClaims()))
+}
+
+func Test_ServiceAccount(t *testing.T) {
+	claims := newClaims()
+	assert.False(t, ServiceAccount(claims))
+	claims["account_type"] = AccountTypeService
+	assert.True(t, ServiceAccount(claims))
+}
+
+func Test_ParseAudience(t *testing.T) {
+	token := signClaims(t, newClaims())
+
+	_, err := ParseAudience(token, tAudience)
+	assert.Nil(t, err)
+
+	_, err = ParseAudience(token, "Other-Service")
+	assert.EqualError(t, err, ErrorAudienceInvalid.Error())
+}
+
+func Test_Check(t *testing.T) {
+	claims := newClaims()
+	claims["roles"] = []string{"editor"}
+	claims["permissions"] = []string{"moments:*", "profile:read"}
+	parsed, err := Parse(signClaims(t, claims))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	type test struct {
+		permissions []string
+		err         error
+	}
+	cases := []*test{
+		&test{nil, nil},
+		&test{[]string{"profile:read"}, nil},
+		&test{[]string{"moments:write", "profile:read"}, nil},
+		&test{[]string{"profile:write"}, ErrorPermissionDenied},
+		&test{[]string{"moments"}, ErrorPermissionDenied},
+		&test{[]string{"profile:read", "admin"}, ErrorPermissionDenied},
+	}
+	for i, c := range cases {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			assert.Equal(t, c.err, Check(claims, c.permissions...))
+			assert.Equal(t, c.err, Check(parsed, c.permissions...))
+		})
+	}
+
+	assert.True(t, HasRole(parsed, "editor"))
+	assert.False(t, HasRole(parsed, "admin"))
+	assert.True(t, Granted(jwt.MapClaims{"permissions": []string{"*"}}, "admin"))
+	assert.Empty(t, Roles(newClaims()))
+}
+
+func Test_Groups(t *testing.T) {
+	claims := newClaims()
+	claims["groups"] = []string{"engineering", "staff"}
+	parsed, err := Parse(signClaims(t, claims))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, []string{"engineering", "staff"}, Groups(parsed))
+	assert.Equal(t, "", GroupsEndpoint(parsed))
+
+	claims = newClaims()
+	claims["_claim_names"] = map[string]string{"groups": "groups"}
+	claims["_claim_sources"] = map[string]map[string]string{"groups": {"endpoint": "https://auth.example.com/user/groups"}}
+	parsed, err = Parse(signClaims(t, claims))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Nil(t, Groups(parsed))
+	assert.Equal(t, "https://auth.example.com/user/groups", GroupsEndpoint(parsed))
+}
+
+func Test_Authorize(t *testing.T) {
+	claims := newClaims()
+	claims["permissions"] = []string{"moments:read"}
+	token := signClaims(t, claims)
+
+	_, err := Authorize(token, tAudience, "moments:read")
+	assert.Nil(t, err)
+
+	_, err = Authorize(token, tAudience, "moments:write")
+	assert.EqualError(t, err, ErrorPermissionDenied.Error())
+
+	_, err = Authorize(token, "Other-Service", "moments:read")
+	assert.EqualError(t, err, ErrorAudienceInvalid.Error())
+}
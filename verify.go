@@ -0,0 +1,168 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/dgrijalva/jwt-go"
+	"github.com/penutty/authservice/mailer"
+	"github.com/penutty/authservice/user"
+	"github.com/penutty/authservice/verification"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+)
+
+const (
+	// VerificationPolicyClaim issues tokens to unverified users without an email_verified claim.
+	VerificationPolicyClaim = "claim"
+	// VerificationPolicyBlock refuses to issue tokens to unverified users.
+	VerificationPolicyBlock = "block"
+)
+
+var (
+	// EmailVerificationPolicy is VerificationPolicyClaim or VerificationPolicyBlock.
+	EmailVerificationPolicy = os.Getenv("EmailVerificationPolicy")
+	// VerifyEmailURL is the page verification links point to; the token is appended as the "token" query parameter.
+	VerifyEmailURL       = os.Getenv("VerifyEmailURL")
+	VerificationLifetime = 24 * time.Hour
+
+	ErrorVerificationTokenInvalid = errors.New("Form value \"Token\" is invalid.")
+	ErrorEmailUnverified          = errors.New("Email address of the user has not been verified.")
+)
+
+// generateVerification returns a signed token verifying that email belongs to the user with ID id.
+func generateVerification(id, email string) (string, error) {
+	claims := jwt.MapClaims{
+		"iss":   "Auth-Service",
+		"sub":   id,
+		"aud":   "Auth-Service",
+		"typ":   "email_verification",
+		"email": email,
+		"exp":   time.Now().UTC().Add(VerificationLifetime).Unix(),
+		"iat":   time.Now().UTC().Unix(),
+	}
+	return signJwt(claims)
+}
+
+// parseVerification returns the user ID and email address of a token generated by generateVerification.
+func parseVerification(token string) (string, string, error) {
+	claims, err := verification.ParseAudience(token, "Auth-Service")
+	if err != nil {
+		logger(Warn).Println(err)
+		return "", "", ErrorVerificationTokenInvalid
+	}
+	sub, ok := claims["sub"].(string)
+	email, _ := claims["email"].(string)
+	if claims["typ"] != "email_verification" || !ok || email == "" {
+		return "", "", ErrorVerificationTokenInvalid
+	}
+	return sub, email, nil
+}
+
+// sendVerification emails a verification link to the address of u, unless one was sent recently.
+func (a *app) sendVerification(u *user.User, locale string) error {
+	if err := a.c.ThrottleVerification(u.UserID(), user.AuthDB()); err != nil {
+		return err
+	}
+
+	token, err := generateVerification(u.ID(), u.Email())
+	if err != nil {
+		return err
+	}
+	data := map[string]interface{}{
+		"URL":   VerifyEmailURL + "?token=" + url.QueryEscape(token),
+		"Hours": int(VerificationLifetime.Hours()),
+	}
+	m, err := a.tmpl.Render("verify_email", locale, data)
+	if err != nil {
+		return err
+	}
+	m.To = u.Email()
+	return a.mail.Send(m)
+}
+
+func (a *app) verifyHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodPost:
+		if err := a.postVerify(r); err != nil {
+			genErrorHandler(w, err)
+			return
+		}
+	default:
+		logger(Error).Println(ErrorMethodNotImplemented)
+		http.Error(w, http.StatusText(http.StatusNotImplemented), http.StatusNotImplemented)
+	}
+}
+
+// postVerify marks the email address in a verification Token as verified.
+// Tokens for an address the user has since changed are rejected.
+func (a *app) postVerify(r *http.Request) error {
+	type body struct {
+		Token string
+	}
+	b := new(body)
+	if err := json.NewDecoder(r.Body).Decode(b); err != nil {
+		return err
+	}
+
+	id, email, err := parseVerification(b.Token)
+	if err != nil {
+		return err
+	}
+	u, err := a.fetchUserByID(id)
+	if err != nil {
+		logger(Warn).Println(err)
+		return ErrorVerificationTokenInvalid
+	}
+	a.c.Verify(u.UserID(), email, user.AuthDB())
+	if err := a.c.Err(); err == user.ErrorUserNotVerified {
+		return ErrorVerificationTokenInvalid
+	} else if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (a *app) verifyResendHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodPost:
+		if err := a.postVerifyResend(r); err != nil {
+			genErrorHandler(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusAccepted)
+	default:
+		logger(Error).Println(ErrorMethodNotImplemented)
+		http.Error(w, http.StatusText(http.StatusNotImplemented), http.StatusNotImplemented)
+	}
+}
+
+// postVerifyResend emails a new verification link to an unverified user.
+// The response does not reveal whether the user exists, is verified or was throttled.
+func (a *app) postVerifyResend(r *http.Request) error {
+	type body struct {
+		UserID string
+	}
+	b := new(body)
+	if err := json.NewDecoder(r.Body).Decode(b); err != nil {
+		return err
+	}
+	if err := user.CheckUserID(b.UserID); err != nil {
+		return err
+	}
+
+	u := a.c.Fetch(qualify(r, b.UserID), user.AuthDB())
+	if err := a.c.Err(); err != nil {
+		logger(Warn).Println(err)
+		return nil
+	}
+	if u.Verified() {
+		return nil
+	}
+
+	if err := a.sendVerification(u, mailer.Locale(r.Header.Get("Accept-Language"))); err != nil {
+		logger(Warn).Println(err)
+	}
+	return nil
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"fmt"
+	"github.com/penutty/authservice/mailer"
+	"github.com/penutty/authservice/verification"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func newVerifyApp() (*app, *MockUserClient, *mailer.Memory) {
+	c := new(MockUserClient)
+	mail := new(mailer.Memory)
+	a := new(app)
+	a.rb = new(MockRBACClient)
+	a.g = new(MockGroupClient)
+	a.ss = NewMockSessionClient()
+	a.c = c
+	a.m = new(MockMFAClient)
+	a.l = NewMockLockoutClient()
+	a.mail = mail
+	a.tmpl, _ = mailer.LoadTemplates("mailer/templates")
+	return a, c, mail
+}
+
+func NewTokenBody(token string) *strings.Reader {
+	return strings.NewReader(fmt.Sprintf("{\"Token\": \"%s\"}", token))
+}
+
+func Test_parseVerification(t *testing.T) {
+	token, err := generateVerification(tID, tEmail)
+	assert.Nil(t, err)
+	u, email, err := parseVerification(token)
+	assert.Nil(t, err)
+	assert.Equal(t, tID, u)
+	assert.Equal(t, tEmail, email)
+
+	link, err := generateLoginLink(tID, "secret")
+	assert.Nil(t, err)
+	_, _, err = parseVerification(link)
+	assert.EqualError(t, err, ErrorVerificationTokenInvalid.Error())
+}
+
+func Test_verifyHandler(t *testing.T) {
+	valid, err := generateVerification(tID, tEmail)
+	if err != nil {
+		t.Fatal(err)
+	}
+	changed, err := generateVerification(tID, "<otheremail@email.com>")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	type test struct {
+		token    string
+		code     int
+		verified bool
+	}
+	cases := []*test{
+		&test{valid, http.StatusOK, true},
+		&test{changed, http.StatusBadRequest, false},
+		&test{"invalid", http.StatusBadRequest, false},
+	}
+	for i, c := range cases {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			a, m, _ := newVerifyApp()
+			rec := httptest.NewRecorder()
+			a.verifyHandler(rec, httptest.NewRequest(http.MethodPost, VerifyEndpoint, NewTokenBody(c.token)))
+			assert.Equal(t, c.code, rec.Code)
+			assert.Equal(t, c.verified, m.verified)
+		})
+	}
+}
+
+func Test_verifyResendHandler(t *testing.T) {
+	a, _, mail := newVerifyApp()
+	body := func() *strings.Reader {
+		return strings.NewReader(fmt.Sprintf("{\"UserID\": \"%s\"}", tUser))
+	}
+
+	rec := httptest.NewRecorder()
+	a.verifyResendHandler(rec, httptest.NewRequest(http.MethodPost, VerifyResendEndpoint, body()))
+	assert.Equal(t, http.StatusAccepted, rec.Code)
+	assert.Len(t, mail.Messages, 1)
+
+	link := mail.Last().Text[strings.Index(mail.Last().Text, "token=")+len("token="):]
+	token, err := url.QueryUnescape(strings.Fields(link)[0])
+	assert.Nil(t, err)
+	u, _, err := parseVerification(token)
+	assert.Nil(t, err)
+	assert.Equal(t, tID, u)
+
+	// Throttled; the response does not differ.
+	rec = httptest.NewRecorder()
+	a.verifyResendHandler(rec, httptest.NewRequest(http.MethodPost, VerifyResendEndpoint, body()))
+	assert.Equal(t, http.StatusAccepted, rec.Code)
+	assert.Len(t, mail.Messages, 1)
+}
+
+func Test_EmailVerificationPolicy(t *testing.T) {
+	defer func(p string) { EmailVerificationPolicy = p }(EmailVerificationPolicy)
+
+	type test struct {
+		policy   string
+		verified bool
+		code     int
+		claim    interface{}
+	}
+	cases := []*test{
+		&test{VerificationPolicyClaim, false, http.StatusOK, nil},
+		&test{VerificationPolicyClaim, true, http.StatusOK, true},
+		&test{VerificationPolicyBlock, false, http.StatusForbidden, nil},
+		&test{VerificationPolicyBlock, true, http.StatusOK, true},
+	}
+	for i, c := range cases {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			EmailVerificationPolicy = c.policy
+			a, m, _ := newVerifyApp()
+			m.verified = c.verified
+
+			rec := httptest.NewRecorder()
+			a.authHandler(rec, httptest.NewRequest(http.MethodPost, AuthEndpoint, NewAuthBody(tUser, tPassword)))
+			assert.Equal(t, c.code, rec.Code)
+			if c.code != http.StatusOK {
+				return
+			}
+
+			claims, err := verification.Parse(rec.Header().Get("jwt"))
+			assert.Nil(t, err)
+			assert.Equal(t, c.claim, claims["email_verified"])
+		})
+	}
+}
@@ -0,0 +1,273 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/penutty/authservice/user"
+	"github.com/penutty/authservice/webauthn"
+	"net/http"
+)
+
+var ErrorCredentialUserMismatch = errors.New("Credential does not belong to the user the challenge was issued for.")
+
+// credentialParameter is a PublicKeyCredentialParameters or PublicKeyCredentialDescriptor.
+type credentialParameter struct {
+	Type string         `json:"type"`
+	Alg  int64          `json:"alg,omitempty"`
+	ID   webauthn.Bytes `json:"id,omitempty"`
+}
+
+// creationOptions is the response body of WebAuthnRegisterEndpoint, to be passed to navigator.credentials.create.
+type creationOptions struct {
+	PublicKey struct {
+		Challenge webauthn.Bytes `json:"challenge"`
+		RP        struct {
+			ID   string `json:"id"`
+			Name string `json:"name"`
+		} `json:"rp"`
+		User struct {
+			ID          webauthn.Bytes `json:"id"`
+			Name        string         `json:"name"`
+			DisplayName string         `json:"displayName"`
+		} `json:"user"`
+		PubKeyCredParams       []credentialParameter `json:"pubKeyCredParams"`
+		ExcludeCredentials     []credentialParameter `json:"excludeCredentials"`
+		Timeout                int64                 `json:"timeout"`
+		Attestation            string                `json:"attestation"`
+		AuthenticatorSelection map[string]string     `json:"authenticatorSelection"`
+	} `json:"publicKey"`
+}
+
+// requestOptions is the response body of WebAuthnLoginEndpoint, to be passed to navigator.credentials.get.
+type requestOptions struct {
+	PublicKey struct {
+		Challenge        webauthn.Bytes        `json:"challenge"`
+		RPID             string                `json:"rpId"`
+		AllowCredentials []credentialParameter `json:"allowCredentials"`
+		Timeout          int64                 `json:"timeout"`
+		UserVerification string                `json:"userVerification"`
+	} `json:"publicKey"`
+}
+
+// publicKeyCredential is the JSON serialization of the PublicKeyCredential returned by the browser.
+type publicKeyCredential struct {
+	ID       string         `json:"id"`
+	RawID    webauthn.Bytes `json:"rawId"`
+	Type     string         `json:"type"`
+	Response struct {
+		ClientDataJSON    webauthn.Bytes `json:"clientDataJSON"`
+		AttestationObject webauthn.Bytes `json:"attestationObject"`
+		AuthenticatorData webauthn.Bytes `json:"authenticatorData"`
+		Signature         webauthn.Bytes `json:"signature"`
+		UserHandle        webauthn.Bytes `json:"userHandle"`
+	} `json:"response"`
+}
+
+func descriptors(cs []*webauthn.Credential) []credentialParameter {
+	d := make([]credentialParameter, 0, len(cs))
+	for _, c := range cs {
+		d = append(d, credentialParameter{Type: "public-key", ID: c.ID()})
+	}
+	return d
+}
+
+func (a *app) webauthnRegisterHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodPost:
+		o, err := a.postWebAuthnRegister(r)
+		if err != nil {
+			genErrorHandler(w, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(o)
+	default:
+		logger(Error).Println(ErrorMethodNotImplemented)
+		http.Error(w, http.StatusText(http.StatusNotImplemented), http.StatusNotImplemented)
+	}
+}
+
+// postWebAuthnRegister starts a registration ceremony for the authenticated user.
+func (a *app) postWebAuthnRegister(r *http.Request) (*creationOptions, error) {
+	caller, err := a.authenticateLogin(r)
+	if err != nil {
+		return nil, err
+	}
+	userID := caller.UserID()
+
+	existing := a.w.List(userID, user.AuthDB())
+	challenge := a.w.Challenge(userID, webauthn.CeremonyCreate, user.AuthDB())
+	if err := a.w.Err(); err != nil {
+		return nil, err
+	}
+
+	o := new(creationOptions)
+	o.PublicKey.Challenge = challenge
+	o.PublicKey.RP.ID = webauthn.RPID
+	o.PublicKey.RP.Name = webauthn.RPName
+	o.PublicKey.User.ID = webauthn.Bytes(caller.ID())
+	o.PublicKey.User.Name = userID
+	o.PublicKey.User.DisplayName = userID
+	o.PublicKey.PubKeyCredParams = []credentialParameter{
+		{Type: "public-key", Alg: webauthn.AlgES256},
+		{Type: "public-key", Alg: webauthn.AlgRS256},
+	}
+	o.PublicKey.ExcludeCredentials = descriptors(existing)
+	o.PublicKey.Timeout = webauthn.ChallengeLifetime.Nanoseconds() / 1e6
+	o.PublicKey.Attestation = "direct"
+	o.PublicKey.AuthenticatorSelection = map[string]string{"residentKey": "preferred", "userVerification": webauthn.UserVerification}
+	return o, nil
+}
+
+func (a *app) webauthnRegisterFinishHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodPost:
+		if err := a.postWebAuthnRegisterFinish(r); err != nil {
+			genErrorHandler(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+	default:
+		logger(Error).Println(ErrorMethodNotImplemented)
+		http.Error(w, http.StatusText(http.StatusNotImplemented), http.StatusNotImplemented)
+	}
+}
+
+// postWebAuthnRegisterFinish verifies the attestation returned by the authenticator and stores the new credential.
+func (a *app) postWebAuthnRegisterFinish(r *http.Request) error {
+	caller, err := a.authenticateLogin(r)
+	if err != nil {
+		return err
+	}
+	userID := caller.UserID()
+
+	b := new(publicKeyCredential)
+	if err := json.NewDecoder(r.Body).Decode(b); err != nil {
+		return err
+	}
+	_, challenge, err := webauthn.ParseClientData(b.Response.ClientDataJSON)
+	if err != nil {
+		return err
+	}
+
+	issuedTo := a.w.ConsumeChallenge(challenge, webauthn.CeremonyCreate, user.AuthDB())
+	if err := a.w.Err(); err != nil {
+		return err
+	}
+	if issuedTo != userID {
+		return webauthn.ErrorChallengeInvalid
+	}
+
+	c, err := webauthn.VerifyRegistration(userID, challenge, b.Response.ClientDataJSON, b.Response.AttestationObject)
+	if err != nil {
+		return err
+	}
+	a.w.Register(c, user.AuthDB())
+	return a.w.Err()
+}
+
+func (a *app) webauthnLoginHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodPost:
+		o, err := a.postWebAuthnLogin(r)
+		if err != nil {
+			genErrorHandler(w, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(o)
+	default:
+		logger(Error).Println(ErrorMethodNotImplemented)
+		http.Error(w, http.StatusText(http.StatusNotImplemented), http.StatusNotImplemented)
+	}
+}
+
+// postWebAuthnLogin starts an assertion ceremony. When the body names a UserID the challenge is
+// bound to that user and their credentials are listed; otherwise a discoverable credential is expected.
+func (a *app) postWebAuthnLogin(r *http.Request) (*requestOptions, error) {
+	type body struct {
+		UserID string
+	}
+	b := new(body)
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(b); err != nil {
+			return nil, err
+		}
+	}
+
+	var allowed []*webauthn.Credential
+	if b.UserID != "" {
+		if err := user.CheckUserID(b.UserID); err != nil {
+			return nil, err
+		}
+		b.UserID = qualify(r, b.UserID)
+		allowed = a.w.List(b.UserID, user.AuthDB())
+	}
+	challenge := a.w.Challenge(b.UserID, webauthn.CeremonyGet, user.AuthDB())
+	if err := a.w.Err(); err != nil {
+		return nil, err
+	}
+
+	o := new(requestOptions)
+	o.PublicKey.Challenge = challenge
+	o.PublicKey.RPID = webauthn.RPID
+	o.PublicKey.AllowCredentials = descriptors(allowed)
+	o.PublicKey.Timeout = webauthn.ChallengeLifetime.Nanoseconds() / 1e6
+	o.PublicKey.UserVerification = webauthn.UserVerification
+	return o, nil
+}
+
+func (a *app) webauthnLoginFinishHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodPost:
+		token, refresh, err := a.postWebAuthnLoginFinish(r)
+		loginResponse(w, token, refresh, err)
+	default:
+		logger(Error).Println(ErrorMethodNotImplemented)
+		http.Error(w, http.StatusText(http.StatusNotImplemented), http.StatusNotImplemented)
+	}
+}
+
+// postWebAuthnLoginFinish verifies the assertion returned by the authenticator and issues a token
+// for the owner of the credential.
+func (a *app) postWebAuthnLoginFinish(r *http.Request) (string, string, error) {
+	b := new(publicKeyCredential)
+	if err := json.NewDecoder(r.Body).Decode(b); err != nil {
+		return "", "", err
+	}
+	_, challenge, err := webauthn.ParseClientData(b.Response.ClientDataJSON)
+	if err != nil {
+		return "", "", err
+	}
+
+	issuedTo := a.w.ConsumeChallenge(challenge, webauthn.CeremonyGet, user.AuthDB())
+	c := a.w.Fetch(b.RawID, user.AuthDB())
+	if err := a.w.Err(); err != nil {
+		return "", "", err
+	}
+	if issuedTo != "" && issuedTo != c.UserID() {
+		return "", "", ErrorCredentialUserMismatch
+	}
+	u, err := a.fetchUser(c.UserID())
+	if err != nil {
+		return "", "", err
+	}
+	if err := checkTenant(r, u); err != nil {
+		return "", "", err
+	}
+	// Credentials registered before users had IDs carry the UserID as their user handle.
+	if h := string(b.Response.UserHandle); h != "" && h != u.ID() && h != c.UserID() {
+		return "", "", ErrorCredentialUserMismatch
+	}
+
+	count, err := webauthn.VerifyAssertion(c, challenge, b.Response.ClientDataJSON, b.Response.AuthenticatorData, b.Response.Signature)
+	if err != nil {
+		return "", "", err
+	}
+	a.w.UpdateSignCount(c, count, user.AuthDB())
+	if err := a.w.Err(); err != nil {
+		return "", "", err
+	}
+
+	return a.accessToken(u, r)
+}
@@ -0,0 +1,133 @@
+package webauthn
+
+import (
+	"bytes"
+	"crypto/x509"
+	"encoding/asn1"
+	"errors"
+)
+
+// Attestation statement formats supported by VerifyRegistration.
+const (
+	FormatNone   = "none"
+	FormatPacked = "packed"
+)
+
+// oidAAGUID is the id-fido-gen-ce-aaguid certificate extension.
+var oidAAGUID = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 45724, 1, 1, 4}
+
+var (
+	ErrorAttestationInvalid       = errors.New("Attestation object is invalid.")
+	ErrorAttestationFormat        = errors.New("Attestation statement format is unsupported.")
+	ErrorAttestationCertificate   = errors.New("Attestation certificate does not meet the packed attestation requirements.")
+	ErrorAttestationAAGUIDInvalid = errors.New("Attestation certificate AAGUID does not match the authenticator data.")
+)
+
+type attestation struct {
+	format   string
+	authData []byte
+	stmt     map[interface{}]interface{}
+}
+
+// parseAttestation decodes a CBOR attestation object.
+func parseAttestation(b []byte) (*attestation, error) {
+	v, used, err := decodeCBOR(b)
+	if err != nil || used != len(b) {
+		return nil, ErrorAttestationInvalid
+	}
+	m, ok := v.(map[interface{}]interface{})
+	if !ok {
+		return nil, ErrorAttestationInvalid
+	}
+
+	att := new(attestation)
+	att.format, _ = m["fmt"].(string)
+	att.authData, _ = m["authData"].([]byte)
+	att.stmt, ok = m["attStmt"].(map[interface{}]interface{})
+	if att.format == "" || att.authData == nil || !ok {
+		return nil, ErrorAttestationInvalid
+	}
+	return att, nil
+}
+
+// verify checks the attestation statement over data, the concatenation of authenticator data and
+// the client data hash. Packed attestation certificates are checked for conformance but are not
+// chained to a trust anchor, so attestation only proves possession of the credential key.
+func (att *attestation) verify(ad *AuthenticatorData, pub *PublicKey, data []byte) error {
+	switch att.format {
+	case FormatNone:
+		if len(att.stmt) != 0 {
+			return ErrorAttestationInvalid
+		}
+		return nil
+	case FormatPacked:
+		return att.verifyPacked(ad, pub, data)
+	}
+	return ErrorAttestationFormat
+}
+
+func (att *attestation) verifyPacked(ad *AuthenticatorData, pub *PublicKey, data []byte) error {
+	alg, ok := att.stmt["alg"].(int64)
+	if !ok {
+		return ErrorAttestationInvalid
+	}
+	sig, ok := att.stmt["sig"].([]byte)
+	if !ok {
+		return ErrorAttestationInvalid
+	}
+
+	x5c, ok := att.stmt["x5c"].([]interface{})
+	if !ok {
+		if _, present := att.stmt["x5c"]; present {
+			return ErrorAttestationInvalid
+		}
+		if alg != pub.Alg {
+			return ErrorAlgorithmMismatch
+		}
+		return pub.Verify(data, sig)
+	}
+
+	if len(x5c) == 0 {
+		return ErrorAttestationInvalid
+	}
+	der, ok := x5c[0].([]byte)
+	if !ok {
+		return ErrorAttestationInvalid
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return ErrorAttestationCertificate
+	}
+	if err := checkPackedCertificate(cert, ad.AAGUID); err != nil {
+		return err
+	}
+	return verifySignature(alg, cert.PublicKey, data, sig)
+}
+
+// checkPackedCertificate applies the packed attestation certificate requirements of WebAuthn §8.2.1.
+func checkPackedCertificate(cert *x509.Certificate, aaguid []byte) error {
+	if cert.Version != 3 || !cert.BasicConstraintsValid || cert.IsCA {
+		return ErrorAttestationCertificate
+	}
+	ou := cert.Subject.OrganizationalUnit
+	if len(ou) != 1 || ou[0] != "Authenticator Attestation" {
+		return ErrorAttestationCertificate
+	}
+	if len(cert.Subject.Country) == 0 || len(cert.Subject.Organization) == 0 || cert.Subject.CommonName == "" {
+		return ErrorAttestationCertificate
+	}
+
+	for _, ext := range cert.Extensions {
+		if !ext.Id.Equal(oidAAGUID) {
+			continue
+		}
+		if ext.Critical {
+			return ErrorAttestationCertificate
+		}
+		var id []byte
+		if _, err := asn1.Unmarshal(ext.Value, &id); err != nil || !bytes.Equal(id, aaguid) {
+			return ErrorAttestationAAGUIDInvalid
+		}
+	}
+	return nil
+}
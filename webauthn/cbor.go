@@ -0,0 +1,180 @@
+package webauthn
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+)
+
+// maxDepth bounds the nesting of decoded CBOR items.
+const maxDepth = 16
+
+var ErrorCBORInvalid = errors.New("CBOR data is invalid or unsupported.")
+
+// decodeCBOR decodes the first CBOR data item in b and returns it with the number of bytes it occupied.
+// Only the subset of RFC 7049 used by WebAuthn is supported: definite length items, integers,
+// byte and text strings, arrays, maps with integer or text keys, tags, booleans, null and floats.
+// Integers decode to int64, byte strings to []byte, arrays to []interface{} and maps to map[interface{}]interface{}.
+func decodeCBOR(b []byte) (interface{}, int, error) {
+	d := &decoder{b: b}
+	v, err := d.value(0)
+	if err != nil {
+		return nil, 0, err
+	}
+	return v, d.off, nil
+}
+
+type decoder struct {
+	b   []byte
+	off int
+}
+
+func (d *decoder) next(n uint64) ([]byte, error) {
+	if n > uint64(len(d.b)-d.off) {
+		return nil, ErrorCBORInvalid
+	}
+	p := d.b[d.off : d.off+int(n)]
+	d.off += int(n)
+	return p, nil
+}
+
+func (d *decoder) head() (major byte, arg uint64, err error) {
+	p, err := d.next(1)
+	if err != nil {
+		return 0, 0, err
+	}
+	major, info := p[0]>>5, p[0]&0x1f
+
+	switch {
+	case info < 24:
+		return major, uint64(info), nil
+	case info == 24:
+		p, err = d.next(1)
+		if err != nil {
+			return 0, 0, err
+		}
+		return major, uint64(p[0]), nil
+	case info == 25:
+		p, err = d.next(2)
+		if err != nil {
+			return 0, 0, err
+		}
+		return major, uint64(binary.BigEndian.Uint16(p)), nil
+	case info == 26:
+		p, err = d.next(4)
+		if err != nil {
+			return 0, 0, err
+		}
+		return major, uint64(binary.BigEndian.Uint32(p)), nil
+	case info == 27:
+		p, err = d.next(8)
+		if err != nil {
+			return 0, 0, err
+		}
+		return major, binary.BigEndian.Uint64(p), nil
+	}
+	return 0, 0, ErrorCBORInvalid
+}
+
+func (d *decoder) value(depth int) (interface{}, error) {
+	if depth > maxDepth {
+		return nil, ErrorCBORInvalid
+	}
+	start := d.off
+	major, arg, err := d.head()
+	if err != nil {
+		return nil, err
+	}
+
+	switch major {
+	case 0:
+		if arg > math.MaxInt64 {
+			return nil, ErrorCBORInvalid
+		}
+		return int64(arg), nil
+	case 1:
+		if arg > math.MaxInt64 {
+			return nil, ErrorCBORInvalid
+		}
+		return -1 - int64(arg), nil
+	case 2:
+		p, err := d.next(arg)
+		if err != nil {
+			return nil, err
+		}
+		return append([]byte(nil), p...), nil
+	case 3:
+		p, err := d.next(arg)
+		if err != nil {
+			return nil, err
+		}
+		return string(p), nil
+	case 4:
+		if arg > uint64(len(d.b)-d.off) {
+			return nil, ErrorCBORInvalid
+		}
+		a := make([]interface{}, arg)
+		for i := range a {
+			if a[i], err = d.value(depth + 1); err != nil {
+				return nil, err
+			}
+		}
+		return a, nil
+	case 5:
+		if arg > uint64(len(d.b)-d.off) {
+			return nil, ErrorCBORInvalid
+		}
+		m := make(map[interface{}]interface{}, arg)
+		for i := uint64(0); i < arg; i++ {
+			k, err := d.value(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+			switch k.(type) {
+			case int64, string:
+			default:
+				return nil, ErrorCBORInvalid
+			}
+			if m[k], err = d.value(depth + 1); err != nil {
+				return nil, err
+			}
+		}
+		return m, nil
+	case 6:
+		return d.value(depth + 1)
+	case 7:
+		switch d.b[start] & 0x1f {
+		case 20:
+			return false, nil
+		case 21:
+			return true, nil
+		case 22, 23:
+			return nil, nil
+		case 25:
+			return float64(halfToFloat(uint16(arg))), nil
+		case 26:
+			return float64(math.Float32frombits(uint32(arg))), nil
+		case 27:
+			return math.Float64frombits(arg), nil
+		}
+	}
+	return nil, ErrorCBORInvalid
+}
+
+func halfToFloat(h uint16) float32 {
+	sign := uint32(h>>15) << 31
+	exp := uint32(h>>10) & 0x1f
+	frac := uint32(h) & 0x3ff
+
+	switch exp {
+	case 0:
+		f := float32(frac) / 1024 * float32(math.Pow(2, -14))
+		if sign != 0 {
+			return -f
+		}
+		return f
+	case 0x1f:
+		return math.Float32frombits(sign | 0x7f800000 | frac<<13)
+	}
+	return math.Float32frombits(sign | (exp+112)<<23 | frac<<13)
+}
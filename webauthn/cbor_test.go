@@ -0,0 +1,64 @@
+package webauthn
+
+import (
+	"github.com/penutty/authservice/webauthn/webauthntest"
+	"github.com/stretchr/testify/assert"
+	"strconv"
+	"testing"
+)
+
+func Test_decodeCBOR(t *testing.T) {
+	type bytesValuePair struct {
+		data  []byte
+		value interface{}
+	}
+	testVars := []*bytesValuePair{
+		&bytesValuePair{[]byte{0x00}, int64(0)},
+		&bytesValuePair{[]byte{0x18, 0x64}, int64(100)},
+		&bytesValuePair{[]byte{0x39, 0x01, 0x00}, int64(-257)},
+		&bytesValuePair{[]byte{0x43, 0x01, 0x02, 0x03}, []byte{1, 2, 3}},
+		&bytesValuePair{[]byte{0x63, 'f', 'm', 't'}, "fmt"},
+		&bytesValuePair{[]byte{0x82, 0x01, 0x20}, []interface{}{int64(1), int64(-1)}},
+		&bytesValuePair{[]byte{0xf5}, true},
+		&bytesValuePair{[]byte{0xf6}, nil},
+		&bytesValuePair{[]byte{0xf9, 0x3c, 0x00}, float64(1)},
+		&bytesValuePair{[]byte{0xc2, 0x41, 0x01}, []byte{1}},
+		&bytesValuePair{
+			webauthntest.Marshal(webauthntest.Map{"fmt", "none", 1, []interface{}{}}),
+			map[interface{}]interface{}{"fmt": "none", int64(1): []interface{}{}},
+		},
+	}
+
+	for i, v := range testVars {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			value, used, err := decodeCBOR(v.data)
+			assert.Nil(t, err)
+			assert.Equal(t, len(v.data), used)
+			assert.Equal(t, v.value, value)
+		})
+	}
+}
+
+func Test_decodeCBOR_invalid(t *testing.T) {
+	testVars := [][]byte{
+		{},
+		{0x43, 0x01},
+		{0x9f, 0x01, 0xff},
+		{0xa1, 0x41, 0x01, 0x01},
+		{0x9a, 0xff, 0xff, 0xff, 0xff},
+		{0x1b, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff},
+	}
+
+	for i, v := range testVars {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			_, _, err := decodeCBOR(v)
+			assert.EqualError(t, err, ErrorCBORInvalid.Error())
+		})
+	}
+}
+
+func Test_decodeCBOR_trailing(t *testing.T) {
+	_, used, err := decodeCBOR([]byte{0x01, 0x02})
+	assert.Nil(t, err)
+	assert.Equal(t, 1, used)
+}
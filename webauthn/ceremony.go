@@ -0,0 +1,286 @@
+package webauthn
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"os"
+)
+
+// Authenticator data flags.
+const (
+	FlagUserPresent       = 0x01
+	FlagUserVerified      = 0x04
+	FlagAttestedCredData  = 0x40
+	FlagExtensionDataIncl = 0x80
+)
+
+var (
+	RPID          = os.Getenv("WebAuthnRPID")
+	RPName        = "Auth-Service"
+	Origin        = os.Getenv("WebAuthnOrigin")
+	ChallengeSize = 32
+	// UserVerification is the userVerification requirement of ceremonies. When it is UserVerificationRequired,
+	// authenticator data that does not assert user verification is refused.
+	UserVerification = userVerificationRequirement(os.Getenv("WebAuthnUserVerification"))
+
+	ErrorClientDataInvalid        = errors.New("clientDataJSON is invalid.")
+	ErrorCeremonyTypeInvalid      = errors.New("clientDataJSON type does not match the ceremony.")
+	ErrorChallengeMismatch        = errors.New("clientDataJSON challenge does not match the issued challenge.")
+	ErrorOriginInvalid            = errors.New("clientDataJSON origin is not permitted.")
+	ErrorAuthenticatorDataInvalid = errors.New("Authenticator data is invalid.")
+	ErrorRPIDHashMismatch         = errors.New("Authenticator data was not produced for this relying party.")
+	ErrorUserNotPresent           = errors.New("Authenticator did not assert user presence.")
+	ErrorUserNotVerified          = errors.New("Authenticator did not assert user verification.")
+	ErrorCredentialDataMissing    = errors.New("Authenticator data does not contain an attested credential.")
+	ErrorSignCountInvalid         = errors.New("Signature counter did not increase; the authenticator may be cloned.")
+)
+
+// Ceremony types as they appear in clientDataJSON.
+const (
+	CeremonyCreate = "webauthn.create"
+	CeremonyGet    = "webauthn.get"
+)
+
+// User verification requirements.
+const (
+	UserVerificationRequired    = "required"
+	UserVerificationPreferred   = "preferred"
+	UserVerificationDiscouraged = "discouraged"
+)
+
+// userVerificationRequirement returns v if it is a user verification requirement, UserVerificationPreferred otherwise.
+func userVerificationRequirement(v string) string {
+	switch v {
+	case UserVerificationRequired, UserVerificationDiscouraged:
+		return v
+	}
+	return UserVerificationPreferred
+}
+
+// NewChallenge returns ChallengeSize random bytes for a registration or assertion ceremony.
+func NewChallenge() ([]byte, error) {
+	c := make([]byte, ChallengeSize)
+	if _, err := rand.Read(c); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Bytes is binary data encoded as unpadded base64url in JSON, as in the WebAuthn JSON serialization.
+type Bytes []byte
+
+// MarshalJSON encodes b as an unpadded base64url string.
+func (b Bytes) MarshalJSON() ([]byte, error) {
+	return json.Marshal(base64.RawURLEncoding.EncodeToString(b))
+}
+
+// UnmarshalJSON decodes a base64url string, with or without padding, into b.
+func (b *Bytes) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	p, err := base64.RawURLEncoding.DecodeString(trimPadding(s))
+	if err != nil {
+		return err
+	}
+	*b = p
+	return nil
+}
+
+func trimPadding(s string) string {
+	for len(s) > 0 && s[len(s)-1] == '=' {
+		s = s[:len(s)-1]
+	}
+	return s
+}
+
+// CollectedClientData is the parsed clientDataJSON of a ceremony.
+type CollectedClientData struct {
+	Type      string `json:"type"`
+	Challenge string `json:"challenge"`
+	Origin    string `json:"origin"`
+}
+
+// ParseClientData parses clientDataJSON and returns the challenge it was produced for.
+func ParseClientData(clientDataJSON []byte) (*CollectedClientData, []byte, error) {
+	c := new(CollectedClientData)
+	if err := json.Unmarshal(clientDataJSON, c); err != nil {
+		return nil, nil, ErrorClientDataInvalid
+	}
+	challenge, err := base64.RawURLEncoding.DecodeString(trimPadding(c.Challenge))
+	if err != nil {
+		return nil, nil, ErrorClientDataInvalid
+	}
+	return c, challenge, nil
+}
+
+// verifyClientData checks the type, challenge and origin of clientDataJSON.
+func verifyClientData(clientDataJSON []byte, ceremony string, challenge []byte) error {
+	c, got, err := ParseClientData(clientDataJSON)
+	if err != nil {
+		return err
+	}
+	if c.Type != ceremony {
+		return ErrorCeremonyTypeInvalid
+	}
+	if subtle.ConstantTimeCompare(got, challenge) != 1 {
+		return ErrorChallengeMismatch
+	}
+	if c.Origin != Origin {
+		return ErrorOriginInvalid
+	}
+	return nil
+}
+
+// AuthenticatorData is the parsed authenticator data of a ceremony.
+type AuthenticatorData struct {
+	RPIDHash     []byte
+	Flags        byte
+	SignCount    uint32
+	AAGUID       []byte
+	CredentialID []byte
+	PublicKey    []byte
+}
+
+// ParseAuthenticatorData parses the binary authenticator data structure.
+func ParseAuthenticatorData(b []byte) (*AuthenticatorData, error) {
+	if len(b) < 37 {
+		return nil, ErrorAuthenticatorDataInvalid
+	}
+	ad := &AuthenticatorData{
+		RPIDHash:  b[:32],
+		Flags:     b[32],
+		SignCount: binary.BigEndian.Uint32(b[33:37]),
+	}
+	rest := b[37:]
+
+	if ad.Flags&FlagAttestedCredData != 0 {
+		if len(rest) < 18 {
+			return nil, ErrorAuthenticatorDataInvalid
+		}
+		ad.AAGUID = rest[:16]
+		n := int(binary.BigEndian.Uint16(rest[16:18]))
+		rest = rest[18:]
+		if n == 0 || n > 1023 || len(rest) < n {
+			return nil, ErrorAuthenticatorDataInvalid
+		}
+		ad.CredentialID, rest = rest[:n], rest[n:]
+
+		_, used, err := decodeCBOR(rest)
+		if err != nil {
+			return nil, ErrorAuthenticatorDataInvalid
+		}
+		ad.PublicKey, rest = rest[:used], rest[used:]
+	}
+
+	if ad.Flags&FlagExtensionDataIncl != 0 {
+		_, used, err := decodeCBOR(rest)
+		if err != nil {
+			return nil, ErrorAuthenticatorDataInvalid
+		}
+		rest = rest[used:]
+	}
+
+	if len(rest) != 0 {
+		return nil, ErrorAuthenticatorDataInvalid
+	}
+	return ad, nil
+}
+
+// verify checks the relying party and user presence of the authenticator data, and user verification if
+// UserVerification requires it.
+func (ad *AuthenticatorData) verify() error {
+	h := sha256.Sum256([]byte(RPID))
+	if !bytes.Equal(ad.RPIDHash, h[:]) {
+		return ErrorRPIDHashMismatch
+	}
+	if ad.Flags&FlagUserPresent == 0 {
+		return ErrorUserNotPresent
+	}
+	if UserVerification == UserVerificationRequired && ad.Flags&FlagUserVerified == 0 {
+		return ErrorUserNotVerified
+	}
+	return nil
+}
+
+// signedData returns the data covered by attestation and assertion signatures.
+func signedData(authData, clientDataJSON []byte) []byte {
+	h := sha256.Sum256(clientDataJSON)
+	return append(append([]byte(nil), authData...), h[:]...)
+}
+
+// VerifyRegistration validates the response of a registration ceremony started with challenge
+// and returns the new credential for userID.
+func VerifyRegistration(userID string, challenge, clientDataJSON, attestationObject []byte) (*Credential, error) {
+	if err := verifyClientData(clientDataJSON, CeremonyCreate, challenge); err != nil {
+		return nil, err
+	}
+
+	att, err := parseAttestation(attestationObject)
+	if err != nil {
+		return nil, err
+	}
+	ad, err := ParseAuthenticatorData(att.authData)
+	if err != nil {
+		return nil, err
+	}
+	if err := ad.verify(); err != nil {
+		return nil, err
+	}
+	if ad.CredentialID == nil {
+		return nil, ErrorCredentialDataMissing
+	}
+
+	pub, err := ParsePublicKey(ad.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+	if err := att.verify(ad, pub, signedData(att.authData, clientDataJSON)); err != nil {
+		return nil, err
+	}
+
+	return &Credential{
+		id:        ad.CredentialID,
+		userID:    userID,
+		publicKey: ad.PublicKey,
+		signCount: ad.SignCount,
+		aaguid:    ad.AAGUID,
+		format:    att.format,
+	}, nil
+}
+
+// VerifyAssertion validates the response of an assertion ceremony started with challenge against c
+// and returns the authenticator's new signature counter.
+func VerifyAssertion(c *Credential, challenge, clientDataJSON, authenticatorData, signature []byte) (uint32, error) {
+	if err := verifyClientData(clientDataJSON, CeremonyGet, challenge); err != nil {
+		return 0, err
+	}
+
+	ad, err := ParseAuthenticatorData(authenticatorData)
+	if err != nil {
+		return 0, err
+	}
+	if err := ad.verify(); err != nil {
+		return 0, err
+	}
+
+	pub, err := ParsePublicKey(c.publicKey)
+	if err != nil {
+		return 0, err
+	}
+	if err := pub.Verify(signedData(authenticatorData, clientDataJSON), signature); err != nil {
+		return 0, err
+	}
+
+	if (ad.SignCount != 0 || c.signCount != 0) && ad.SignCount <= c.signCount {
+		return 0, ErrorSignCountInvalid
+	}
+	return ad.SignCount, nil
+}
@@ -0,0 +1,224 @@
+package webauthn
+
+import (
+	"github.com/penutty/authservice/webauthn/webauthntest"
+	"github.com/stretchr/testify/assert"
+	"strconv"
+	"testing"
+)
+
+var (
+	tUser   = "testuser"
+	tRPID   = "auth.example.com"
+	tOrigin = "https://auth.example.com"
+)
+
+func init() {
+	RPID = tRPID
+	Origin = tOrigin
+}
+
+func newAuthenticator(t *testing.T, format string) *webauthntest.Authenticator {
+	a, err := webauthntest.New(tRPID, tOrigin, format)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return a
+}
+
+func register(t *testing.T, a *webauthntest.Authenticator) *Credential {
+	challenge, err := NewChallenge()
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientData, att, err := a.Create(challenge)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := VerifyRegistration(tUser, challenge, clientData, att)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func Test_VerifyRegistration(t *testing.T) {
+	formats := []string{webauthntest.FormatNone, webauthntest.FormatPacked, webauthntest.FormatPackedX509}
+
+	for i, f := range formats {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			a := newAuthenticator(t, f)
+			challenge, _ := NewChallenge()
+			clientData, att, err := a.Create(challenge)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			c, err := VerifyRegistration(tUser, challenge, clientData, att)
+			assert.Nil(t, err)
+			assert.Equal(t, a.CredentialID, c.ID())
+			assert.Equal(t, tUser, c.UserID())
+			assert.Equal(t, a.PublicKey(), c.publicKey)
+			assert.Equal(t, a.AAGUID, c.aaguid)
+		})
+	}
+}
+
+func Test_VerifyRegistration_invalid(t *testing.T) {
+	challenge, _ := NewChallenge()
+	other, _ := NewChallenge()
+
+	t.Run("challenge", func(t *testing.T) {
+		clientData, att, _ := newAuthenticator(t, webauthntest.FormatNone).Create(other)
+		_, err := VerifyRegistration(tUser, challenge, clientData, att)
+		assert.EqualError(t, err, ErrorChallengeMismatch.Error())
+	})
+
+	t.Run("origin", func(t *testing.T) {
+		a := newAuthenticator(t, webauthntest.FormatNone)
+		a.Origin = "https://evil.example.com"
+		clientData, att, _ := a.Create(challenge)
+		_, err := VerifyRegistration(tUser, challenge, clientData, att)
+		assert.EqualError(t, err, ErrorOriginInvalid.Error())
+	})
+
+	t.Run("rpid", func(t *testing.T) {
+		a := newAuthenticator(t, webauthntest.FormatNone)
+		a.RPID = "evil.example.com"
+		clientData, att, _ := a.Create(challenge)
+		_, err := VerifyRegistration(tUser, challenge, clientData, att)
+		assert.EqualError(t, err, ErrorRPIDHashMismatch.Error())
+	})
+
+	t.Run("presence", func(t *testing.T) {
+		a := newAuthenticator(t, webauthntest.FormatNone)
+		a.Flags = 0
+		clientData, att, _ := a.Create(challenge)
+		_, err := VerifyRegistration(tUser, challenge, clientData, att)
+		assert.EqualError(t, err, ErrorUserNotPresent.Error())
+	})
+
+	t.Run("verification", func(t *testing.T) {
+		defer func(uv string) { UserVerification = uv }(UserVerification)
+		a := newAuthenticator(t, webauthntest.FormatNone)
+		a.Flags = FlagUserPresent
+		clientData, att, _ := a.Create(challenge)
+		_, err := VerifyRegistration(tUser, challenge, clientData, att)
+		assert.Nil(t, err)
+
+		UserVerification = UserVerificationRequired
+		_, err = VerifyRegistration(tUser, challenge, clientData, att)
+		assert.EqualError(t, err, ErrorUserNotVerified.Error())
+	})
+
+	t.Run("type", func(t *testing.T) {
+		a := newAuthenticator(t, webauthntest.FormatNone)
+		_, att, _ := a.Create(challenge)
+		_, err := VerifyRegistration(tUser, challenge, a.ClientData(CeremonyGet, challenge), att)
+		assert.EqualError(t, err, ErrorCeremonyTypeInvalid.Error())
+	})
+
+	t.Run("signature", func(t *testing.T) {
+		a := newAuthenticator(t, webauthntest.FormatPacked)
+		_, att, _ := a.Create(other)
+		clientData := a.ClientData(CeremonyCreate, challenge)
+		_, err := VerifyRegistration(tUser, challenge, clientData, att)
+		assert.EqualError(t, err, ErrorSignatureInvalid.Error())
+	})
+
+	t.Run("format", func(t *testing.T) {
+		a := newAuthenticator(t, "tpm")
+		clientData, att, _ := a.Create(challenge)
+		_, err := VerifyRegistration(tUser, challenge, clientData, att)
+		assert.EqualError(t, err, ErrorAttestationFormat.Error())
+	})
+
+	t.Run("aaguid", func(t *testing.T) {
+		a := newAuthenticator(t, webauthntest.FormatPackedX509)
+		a.AAGUID = make([]byte, 16)
+		clientData, att, _ := a.Create(challenge)
+		_, err := VerifyRegistration(tUser, challenge, clientData, att)
+		assert.EqualError(t, err, ErrorAttestationAAGUIDInvalid.Error())
+	})
+}
+
+func Test_VerifyAssertion(t *testing.T) {
+	a := newAuthenticator(t, webauthntest.FormatNone)
+	c := register(t, a)
+
+	for i := 0; i < 2; i++ {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			challenge, _ := NewChallenge()
+			clientData, authData, sig, err := a.Get(challenge)
+			if err != nil {
+				t.Fatal(err)
+			}
+			count, err := VerifyAssertion(c, challenge, clientData, authData, sig)
+			assert.Nil(t, err)
+			assert.Equal(t, a.SignCount, count)
+			c.signCount = count
+		})
+	}
+
+	t.Run("replay", func(t *testing.T) {
+		challenge, _ := NewChallenge()
+		a.SignCount = 0
+		clientData, authData, sig, _ := a.Get(challenge)
+		_, err := VerifyAssertion(c, challenge, clientData, authData, sig)
+		assert.EqualError(t, err, ErrorSignCountInvalid.Error())
+	})
+
+	t.Run("key", func(t *testing.T) {
+		challenge, _ := NewChallenge()
+		other := newAuthenticator(t, webauthntest.FormatNone)
+		other.SignCount = 10
+		clientData, authData, sig, _ := other.Get(challenge)
+		_, err := VerifyAssertion(c, challenge, clientData, authData, sig)
+		assert.EqualError(t, err, ErrorSignatureInvalid.Error())
+	})
+
+	t.Run("verification", func(t *testing.T) {
+		defer func(uv string, flags byte) { UserVerification, a.Flags = uv, flags }(UserVerification, a.Flags)
+		UserVerification = UserVerificationRequired
+		a.Flags = FlagUserPresent
+		a.SignCount = c.signCount + 10
+		challenge, _ := NewChallenge()
+		clientData, authData, sig, _ := a.Get(challenge)
+		_, err := VerifyAssertion(c, challenge, clientData, authData, sig)
+		assert.EqualError(t, err, ErrorUserNotVerified.Error())
+	})
+
+	t.Run("type", func(t *testing.T) {
+		challenge, _ := NewChallenge()
+		clientData, att, _ := a.Create(challenge)
+		_, err := VerifyAssertion(c, challenge, clientData, att, nil)
+		assert.EqualError(t, err, ErrorCeremonyTypeInvalid.Error())
+	})
+}
+
+func Test_VerifyAssertion_zeroCounter(t *testing.T) {
+	a := newAuthenticator(t, webauthntest.FormatNone)
+	a.FixedCounter = true
+	c := register(t, a)
+
+	for i := 0; i < 2; i++ {
+		challenge, _ := NewChallenge()
+		clientData, authData, sig, _ := a.Get(challenge)
+		count, err := VerifyAssertion(c, challenge, clientData, authData, sig)
+		assert.Nil(t, err)
+		assert.Equal(t, uint32(0), count)
+	}
+}
+
+func Test_ParseAuthenticatorData(t *testing.T) {
+	_, err := ParseAuthenticatorData(make([]byte, 36))
+	assert.EqualError(t, err, ErrorAuthenticatorDataInvalid.Error())
+
+	_, err = ParseAuthenticatorData(append(make([]byte, 37), 0x00))
+	assert.EqualError(t, err, ErrorAuthenticatorDataInvalid.Error())
+
+	ad, err := ParseAuthenticatorData(append(make([]byte, 32), 0x05, 0, 0, 0, 9))
+	assert.Nil(t, err)
+	assert.Equal(t, uint32(9), ad.SignCount)
+	assert.Nil(t, ad.CredentialID)
+}
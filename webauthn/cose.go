@@ -0,0 +1,118 @@
+package webauthn
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"errors"
+	"math/big"
+)
+
+// COSE algorithm identifiers supported for credential and attestation signatures.
+const (
+	AlgES256 int64 = -7
+	AlgRS256 int64 = -257
+)
+
+// COSE key parameters from RFC 8152.
+const (
+	coseKty    = 1
+	coseAlg    = 3
+	coseCrv    = -1
+	coseX      = -2
+	coseY      = -3
+	coseRSAN   = -1
+	coseRSAE   = -2
+	ktyEC2     = 2
+	ktyRSA     = 3
+	crvP256    = 1
+	minRSABits = 2048
+)
+
+var (
+	ErrorPublicKeyInvalid  = errors.New("Credential public key is invalid or unsupported.")
+	ErrorAlgorithmInvalid  = errors.New("Signature algorithm is unsupported.")
+	ErrorSignatureInvalid  = errors.New("Signature is invalid.")
+	ErrorAlgorithmMismatch = errors.New("Signature algorithm does not match the public key.")
+)
+
+// PublicKey is a credential public key decoded from its COSE_Key representation.
+type PublicKey struct {
+	Alg int64
+	Key crypto.PublicKey
+}
+
+// ParsePublicKey decodes a COSE_Key. EC2 keys on P-256 with ES256 and RSA keys with RS256 are supported.
+func ParsePublicKey(cose []byte) (*PublicKey, error) {
+	v, _, err := decodeCBOR(cose)
+	if err != nil {
+		return nil, err
+	}
+	m, ok := v.(map[interface{}]interface{})
+	if !ok {
+		return nil, ErrorPublicKeyInvalid
+	}
+	kty, _ := m[int64(coseKty)].(int64)
+	alg, _ := m[int64(coseAlg)].(int64)
+
+	switch {
+	case kty == ktyEC2 && alg == AlgES256:
+		crv, _ := m[int64(coseCrv)].(int64)
+		x, _ := m[int64(coseX)].([]byte)
+		y, _ := m[int64(coseY)].([]byte)
+		if crv != crvP256 || len(x) != 32 || len(y) != 32 {
+			return nil, ErrorPublicKeyInvalid
+		}
+		pub := &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		if !pub.Curve.IsOnCurve(pub.X, pub.Y) {
+			return nil, ErrorPublicKeyInvalid
+		}
+		return &PublicKey{Alg: alg, Key: pub}, nil
+	case kty == ktyRSA && alg == AlgRS256:
+		n, _ := m[int64(coseRSAN)].([]byte)
+		e, _ := m[int64(coseRSAE)].([]byte)
+		if len(e) == 0 || len(e) > 4 {
+			return nil, ErrorPublicKeyInvalid
+		}
+		pub := &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+		if pub.N.BitLen() < minRSABits || pub.E < 3 {
+			return nil, ErrorPublicKeyInvalid
+		}
+		return &PublicKey{Alg: alg, Key: pub}, nil
+	}
+	return nil, ErrorPublicKeyInvalid
+}
+
+// Verify checks sig over data with the public key.
+func (p *PublicKey) Verify(data, sig []byte) error {
+	return verifySignature(p.Alg, p.Key, data, sig)
+}
+
+// verifySignature checks sig over data with key using the COSE algorithm alg.
+func verifySignature(alg int64, key crypto.PublicKey, data, sig []byte) error {
+	h := sha256.Sum256(data)
+
+	switch alg {
+	case AlgES256:
+		pub, ok := key.(*ecdsa.PublicKey)
+		if !ok {
+			return ErrorAlgorithmMismatch
+		}
+		if !ecdsa.VerifyASN1(pub, h[:], sig) {
+			return ErrorSignatureInvalid
+		}
+		return nil
+	case AlgRS256:
+		pub, ok := key.(*rsa.PublicKey)
+		if !ok {
+			return ErrorAlgorithmMismatch
+		}
+		if err := rsa.VerifyPKCS1v15(pub, crypto.SHA256, h[:], sig); err != nil {
+			return ErrorSignatureInvalid
+		}
+		return nil
+	}
+	return ErrorAlgorithmInvalid
+}
@@ -0,0 +1,69 @@
+package webauthn
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"github.com/penutty/authservice/webauthn/webauthntest"
+	"github.com/stretchr/testify/assert"
+	"math/big"
+	"testing"
+)
+
+func Test_ParsePublicKey_ES256(t *testing.T) {
+	a, err := webauthntest.New(tRPID, tOrigin, webauthntest.FormatNone)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pub, err := ParsePublicKey(a.PublicKey())
+	assert.Nil(t, err)
+	assert.Equal(t, AlgES256, pub.Alg)
+	assert.IsType(t, new(ecdsa.PublicKey), pub.Key)
+
+	_, err = ParsePublicKey(webauthntest.Marshal(webauthntest.Map{1, 2, 3, -7, -1, 1, -2, make([]byte, 32), -3, make([]byte, 32)}))
+	assert.EqualError(t, err, ErrorPublicKeyInvalid.Error())
+
+	_, err = ParsePublicKey(webauthntest.Marshal(webauthntest.Map{1, 2, 3, -8}))
+	assert.EqualError(t, err, ErrorPublicKeyInvalid.Error())
+}
+
+func Test_ParsePublicKey_RS256(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cose := webauthntest.Marshal(webauthntest.Map{1, 3, 3, -257, -1, key.N.Bytes(), -2, big.NewInt(int64(key.E)).Bytes()})
+
+	pub, err := ParsePublicKey(cose)
+	assert.Nil(t, err)
+
+	data := []byte("signed data")
+	h := sha256.Sum256(data)
+	sig, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, h[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Nil(t, pub.Verify(data, sig))
+	assert.EqualError(t, pub.Verify([]byte("other data"), sig), ErrorSignatureInvalid.Error())
+}
+
+func Test_verifySignature(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := []byte("signed data")
+	h := sha256.Sum256(data)
+	sig, err := ecdsa.SignASN1(rand.Reader, key, h[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Nil(t, verifySignature(AlgES256, &key.PublicKey, data, sig))
+	assert.EqualError(t, verifySignature(AlgES256, &key.PublicKey, []byte("other"), sig), ErrorSignatureInvalid.Error())
+	assert.EqualError(t, verifySignature(AlgRS256, &key.PublicKey, data, sig), ErrorAlgorithmMismatch.Error())
+	assert.EqualError(t, verifySignature(-8, &key.PublicKey, data, sig), ErrorAlgorithmInvalid.Error())
+}
@@ -0,0 +1,278 @@
+// Package webauthn is dedicated to WebAuthn (passkey) registration and login.
+// The Credential resource definition and the methods reading and writing credentials and
+// ceremony challenges in Auth-Db are below. Ceremony verification is in ceremony.go,
+// attestation statement verification in attestation.go and COSE key handling in cose.go.
+package webauthn
+
+import (
+	"database/sql"
+	"errors"
+	sq "github.com/Masterminds/squirrel"
+	"log"
+	"time"
+)
+
+var (
+	ChallengeLifetime = 5 * time.Minute
+
+	ErrorUserIDParameterInvalid  = errors.New("Credential.userID must be a valid userID.")
+	ErrorCredentialRowNotWritten = errors.New("Failed to write one row in the auth.Credentials table.")
+	ErrorChallengeInvalid        = errors.New("Challenge was not issued or has already been used.")
+	ErrorChallengeExpired        = errors.New("Challenge has expired.")
+)
+
+type Client interface {
+	Challenger
+	ChallengeConsumer
+	Registerer
+	Fetcher
+	Lister
+	Updater
+	Err() error
+}
+
+type Challenger interface {
+	Challenge(string, string, sq.BaseRunner) []byte
+}
+
+type ChallengeConsumer interface {
+	ConsumeChallenge([]byte, string, sq.BaseRunner) string
+}
+
+type Registerer interface {
+	Register(*Credential, sq.BaseRunner)
+}
+
+type Fetcher interface {
+	Fetch([]byte, sq.BaseRunner) *Credential
+}
+
+type Lister interface {
+	List(string, sq.BaseRunner) []*Credential
+}
+
+type Updater interface {
+	UpdateSignCount(*Credential, uint32, sq.BaseRunner)
+}
+
+type CredentialClient struct {
+	err error
+}
+
+// Challenge generates a challenge for a ceremony and stores it in the auth.WebAuthnChallenges table.
+// userID may be empty for assertion ceremonies where the user is identified by the credential.
+// Expired challenges, e.g. of abandoned ceremonies, are deleted first so the table does not grow.
+func (cc *CredentialClient) Challenge(userID, ceremony string, db sq.BaseRunner) (challenge []byte) {
+	if cc.err != nil {
+		return
+	}
+	challenge, err := NewChallenge()
+	if err != nil {
+		cc.err = err
+		return
+	}
+
+	now := time.Now().UTC()
+	if _, err := sq.Delete("[auth].[WebAuthnChallenges]").Where(sq.Lt{"[Expires]": now}).RunWith(db).Exec(); err != nil {
+		log.Print(err)
+		cc.err = err
+		return nil
+	}
+	insert := sq.Insert("[auth].[WebAuthnChallenges]").
+		Columns("[Challenge]", "[UserID]", "[Ceremony]", "[Expires]").
+		Values(challenge, userID, ceremony, now.Add(ChallengeLifetime))
+	if _, err := insert.RunWith(db).Exec(); err != nil {
+		log.Print(err)
+		cc.err = err
+		challenge = nil
+	}
+	return
+}
+
+// ConsumeChallenge deletes challenge from the auth.WebAuthnChallenges table and returns the userID
+// it was issued for. Each challenge can be consumed once, and only for the ceremony it was issued for.
+func (cc *CredentialClient) ConsumeChallenge(challenge []byte, ceremony string, db sq.BaseRunner) (userID string) {
+	if cc.err != nil {
+		return
+	}
+
+	var expires time.Time
+	sel := sq.Select("[UserID], [Expires]").From("[auth].[WebAuthnChallenges]").
+		Where(sq.Eq{"[Challenge]": challenge, "[Ceremony]": ceremony})
+	err := sel.RunWith(db).QueryRow().Scan(&userID, &expires)
+	if err == sql.ErrNoRows {
+		cc.err = ErrorChallengeInvalid
+		return
+	}
+	if err != nil {
+		log.Print(err)
+		cc.err = err
+		return
+	}
+
+	del := sq.Delete("[auth].[WebAuthnChallenges]").Where(sq.Eq{"[Challenge]": challenge})
+	res, err := del.RunWith(db).Exec()
+	if err != nil {
+		log.Print(err)
+		cc.err = err
+		return
+	}
+	if cnt, err := res.RowsAffected(); err != nil || cnt != 1 {
+		cc.err = ErrorChallengeInvalid
+		return
+	}
+	if time.Now().UTC().After(expires) {
+		cc.err = ErrorChallengeExpired
+	}
+	return
+}
+
+// Register inserts a new row into the auth.Credentials table in db.
+func (cc *CredentialClient) Register(c *Credential, db sq.BaseRunner) {
+	if cc.err != nil {
+		return
+	}
+	if c.userID == "" {
+		cc.err = ErrorUserIDParameterInvalid
+		return
+	}
+
+	insert := sq.Insert("[auth].[Credentials]").
+		Columns("[CredentialID]", "[UserID]", "[PublicKey]", "[SignCount]", "[AAGUID]", "[Format]", "[Created]").
+		Values(c.id, c.userID, c.publicKey, int64(c.signCount), c.aaguid, c.format, time.Now().UTC())
+	res, err := insert.RunWith(db).Exec()
+	if err != nil {
+		log.Print(err)
+		cc.err = err
+		return
+	}
+	if cnt, err := res.RowsAffected(); err != nil || cnt != 1 {
+		log.Print(ErrorCredentialRowNotWritten)
+		cc.err = ErrorCredentialRowNotWritten
+	}
+}
+
+// Fetch selects a row from the auth.Credentials table in db by credential ID.
+func (cc *CredentialClient) Fetch(id []byte, db sq.BaseRunner) (c *Credential) {
+	if cc.err != nil {
+		return
+	}
+	sel := sq.Select("[CredentialID], [UserID], [PublicKey], [SignCount], [AAGUID], [Format]").
+		From("[auth].[Credentials]").
+		Where(sq.Eq{"[CredentialID]": id})
+
+	c = new(Credential)
+	if err := scanCredential(sel.RunWith(db).QueryRow(), c); err != nil {
+		log.Print(err)
+		cc.err = err
+	}
+	return
+}
+
+// List selects every row of userID from the auth.Credentials table in db.
+func (cc *CredentialClient) List(userID string, db sq.BaseRunner) (cs []*Credential) {
+	if cc.err != nil {
+		return
+	}
+	sel := sq.Select("[CredentialID], [UserID], [PublicKey], [SignCount], [AAGUID], [Format]").
+		From("[auth].[Credentials]").
+		Where(sq.Eq{"[UserID]": userID})
+
+	rows, err := sel.RunWith(db).Query()
+	if err != nil {
+		log.Print(err)
+		cc.err = err
+		return
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		c := new(Credential)
+		if err := scanCredential(rows, c); err != nil {
+			log.Print(err)
+			cc.err = err
+			return
+		}
+		cs = append(cs, c)
+	}
+	if err := rows.Err(); err != nil {
+		log.Print(err)
+		cc.err = err
+	}
+	return
+}
+
+// UpdateSignCount stores the signature counter reported by the authenticator of c.
+func (cc *CredentialClient) UpdateSignCount(c *Credential, count uint32, db sq.BaseRunner) {
+	if cc.err != nil {
+		return
+	}
+	update := sq.Update("[auth].[Credentials]").
+		Set("[SignCount]", int64(count)).
+		Set("[LastUsed]", time.Now().UTC()).
+		Where(sq.Eq{"[CredentialID]": c.id})
+	if _, err := update.RunWith(db).Exec(); err != nil {
+		log.Print(err)
+		cc.err = err
+		return
+	}
+	c.signCount = count
+}
+
+// Err returns the error status of a CredentialClient instance.
+func (cc *CredentialClient) Err() error {
+	return cc.err
+}
+
+type scanner interface {
+	Scan(...interface{}) error
+}
+
+func scanCredential(s scanner, c *Credential) error {
+	var count int64
+	if err := s.Scan(&c.id, &c.userID, &c.publicKey, &count, &c.aaguid, &c.format); err != nil {
+		return err
+	}
+	c.signCount = uint32(count)
+	return nil
+}
+
+// Credential references a unique auth.Credentials row in the Auth-Db database.
+type Credential struct {
+	id        []byte
+	userID    string
+	publicKey []byte
+	signCount uint32
+	aaguid    []byte
+	format    string
+}
+
+// NewCredential is a constructor of the Credential struct. publicKey is a COSE_Key.
+func NewCredential(id []byte, userID string, publicKey []byte, signCount uint32) *Credential {
+	return &Credential{id: id, userID: userID, publicKey: publicKey, signCount: signCount, format: FormatNone}
+}
+
+// ID returns the credential ID assigned by the authenticator.
+func (c *Credential) ID() []byte {
+	return c.id
+}
+
+// UserID returns the ID of the user the credential belongs to.
+func (c *Credential) UserID() string {
+	return c.userID
+}
+
+// PublicKey returns the COSE_Key of the credential.
+func (c *Credential) PublicKey() []byte {
+	return c.publicKey
+}
+
+// SignCount returns the last signature counter reported by the authenticator.
+func (c *Credential) SignCount() uint32 {
+	return c.signCount
+}
+
+// Format returns the attestation statement format the credential was registered with.
+func (c *Credential) Format() string {
+	return c.format
+}
@@ -0,0 +1,194 @@
+package webauthn
+
+import (
+	"database/sql"
+	"github.com/penutty/authservice/webauthn/webauthntest"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/DATA-DOG/go-sqlmock.v1"
+	"testing"
+	"time"
+)
+
+func Test_Challenge(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("An error occured when opening a stub database connection. ERROR: %v\n", err)
+	}
+	defer db.Close()
+
+	mock.ExpectExec(`DELETE FROM \[auth]\.\[WebAuthnChallenges] WHERE \[Expires] < \?`).
+		WithArgs(sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 3))
+	mock.ExpectExec(`INSERT INTO \[auth]\.\[WebAuthnChallenges] \(\[Challenge],\[UserID],\[Ceremony],\[Expires]\) VALUES \(\?,\?,\?,\?\)`).
+		WithArgs(sqlmock.AnyArg(), tUser, CeremonyCreate, sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+
+	cc := new(CredentialClient)
+	challenge := cc.Challenge(tUser, CeremonyCreate, db)
+	assert.Nil(t, cc.Err())
+	assert.Len(t, challenge, ChallengeSize)
+
+	if err = mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Expectations were not met. ERROR: %v\n", err)
+	}
+}
+
+func Test_ConsumeChallenge(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("An error occured when opening a stub database connection. ERROR: %v\n", err)
+	}
+	defer db.Close()
+
+	challenge := []byte("challenge")
+
+	t.Run("1", func(t *testing.T) {
+		mock.ExpectQuery(`SELECT \[UserID], \[Expires] FROM \[auth]\.\[WebAuthnChallenges] WHERE \[Ceremony] = \? AND \[Challenge] = \?`).
+			WithArgs(CeremonyGet, challenge).
+			WillReturnRows(sqlmock.NewRows([]string{"UserID", "Expires"}).AddRow(tUser, time.Now().UTC().Add(time.Minute)))
+		mock.ExpectExec(`DELETE FROM \[auth]\.\[WebAuthnChallenges] WHERE \[Challenge] = \?`).
+			WithArgs(challenge).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+
+		cc := new(CredentialClient)
+		assert.Equal(t, tUser, cc.ConsumeChallenge(challenge, CeremonyGet, db))
+		assert.Nil(t, cc.Err())
+	})
+
+	t.Run("2", func(t *testing.T) {
+		mock.ExpectQuery(`SELECT \[UserID], \[Expires] FROM \[auth]\.\[WebAuthnChallenges]`).
+			WillReturnError(sql.ErrNoRows)
+
+		cc := new(CredentialClient)
+		_ = cc.ConsumeChallenge(challenge, CeremonyGet, db)
+		assert.EqualError(t, cc.Err(), ErrorChallengeInvalid.Error())
+	})
+
+	t.Run("3", func(t *testing.T) {
+		mock.ExpectQuery(`SELECT \[UserID], \[Expires] FROM \[auth]\.\[WebAuthnChallenges]`).
+			WillReturnRows(sqlmock.NewRows([]string{"UserID", "Expires"}).AddRow(tUser, time.Now().UTC().Add(-time.Minute)))
+		mock.ExpectExec(`DELETE FROM \[auth]\.\[WebAuthnChallenges]`).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+
+		cc := new(CredentialClient)
+		_ = cc.ConsumeChallenge(challenge, CeremonyGet, db)
+		assert.EqualError(t, cc.Err(), ErrorChallengeExpired.Error())
+	})
+
+	t.Run("4", func(t *testing.T) {
+		mock.ExpectQuery(`SELECT \[UserID], \[Expires] FROM \[auth]\.\[WebAuthnChallenges]`).
+			WillReturnRows(sqlmock.NewRows([]string{"UserID", "Expires"}).AddRow(tUser, time.Now().UTC().Add(time.Minute)))
+		mock.ExpectExec(`DELETE FROM \[auth]\.\[WebAuthnChallenges]`).
+			WillReturnResult(sqlmock.NewResult(0, 0))
+
+		cc := new(CredentialClient)
+		_ = cc.ConsumeChallenge(challenge, CeremonyGet, db)
+		assert.EqualError(t, cc.Err(), ErrorChallengeInvalid.Error())
+	})
+
+	if err = mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Expectations were not met. ERROR: %v\n", err)
+	}
+}
+
+func Test_Register(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("An error occured when opening a stub database connection. ERROR: %v\n", err)
+	}
+	defer db.Close()
+
+	c := register(t, newAuthenticator(t, webauthntest.FormatPacked))
+
+	t.Run("1", func(t *testing.T) {
+		mock.ExpectExec(`INSERT INTO \[auth]\.\[Credentials] \(\[CredentialID],\[UserID],\[PublicKey],\[SignCount],\[AAGUID],\[Format],\[Created]\) VALUES \(\?,\?,\?,\?,\?,\?,\?\)`).
+			WithArgs(c.ID(), tUser, c.publicKey, 0, c.aaguid, FormatPacked, sqlmock.AnyArg()).
+			WillReturnResult(sqlmock.NewResult(1, 1))
+
+		cc := new(CredentialClient)
+		cc.Register(c, db)
+		assert.Nil(t, cc.Err())
+
+		if err = mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("Expectations were not met. ERROR: %v\n", err)
+		}
+	})
+
+	t.Run("2", func(t *testing.T) {
+		cc := new(CredentialClient)
+		cc.Register(NewCredential(c.ID(), "", c.publicKey, 0), db)
+		assert.EqualError(t, cc.Err(), ErrorUserIDParameterInvalid.Error())
+	})
+}
+
+func Test_Fetch(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("An error occured when opening a stub database connection. ERROR: %v\n", err)
+	}
+	defer db.Close()
+
+	a := newAuthenticator(t, webauthntest.FormatNone)
+	mock.ExpectQuery(`SELECT \[CredentialID], \[UserID], \[PublicKey], \[SignCount], \[AAGUID], \[Format] FROM \[auth]\.\[Credentials] WHERE \[CredentialID] = \?`).
+		WithArgs(a.CredentialID).
+		WillReturnRows(sqlmock.NewRows([]string{"CredentialID", "UserID", "PublicKey", "SignCount", "AAGUID", "Format"}).
+			AddRow(a.CredentialID, tUser, a.PublicKey(), 4, a.AAGUID, FormatNone))
+
+	cc := new(CredentialClient)
+	c := cc.Fetch(a.CredentialID, db)
+	assert.Nil(t, cc.Err())
+	assert.Equal(t, tUser, c.UserID())
+	assert.Equal(t, uint32(4), c.SignCount())
+
+	if err = mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Expectations were not met. ERROR: %v\n", err)
+	}
+}
+
+func Test_List(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("An error occured when opening a stub database connection. ERROR: %v\n", err)
+	}
+	defer db.Close()
+
+	a := newAuthenticator(t, webauthntest.FormatNone)
+	b := newAuthenticator(t, webauthntest.FormatNone)
+	mock.ExpectQuery(`SELECT \[CredentialID], \[UserID], \[PublicKey], \[SignCount], \[AAGUID], \[Format] FROM \[auth]\.\[Credentials] WHERE \[UserID] = \?`).
+		WithArgs(tUser).
+		WillReturnRows(sqlmock.NewRows([]string{"CredentialID", "UserID", "PublicKey", "SignCount", "AAGUID", "Format"}).
+			AddRow(a.CredentialID, tUser, a.PublicKey(), 0, a.AAGUID, FormatNone).
+			AddRow(b.CredentialID, tUser, b.PublicKey(), 0, b.AAGUID, FormatNone))
+
+	cc := new(CredentialClient)
+	cs := cc.List(tUser, db)
+	assert.Nil(t, cc.Err())
+	assert.Len(t, cs, 2)
+	assert.Equal(t, b.CredentialID, cs[1].ID())
+
+	if err = mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Expectations were not met. ERROR: %v\n", err)
+	}
+}
+
+func Test_UpdateSignCount(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("An error occured when opening a stub database connection. ERROR: %v\n", err)
+	}
+	defer db.Close()
+
+	c := NewCredential([]byte("id"), tUser, nil, 1)
+	mock.ExpectExec(`UPDATE \[auth]\.\[Credentials] SET \[SignCount] = \?, \[LastUsed] = \? WHERE \[CredentialID] = \?`).
+		WithArgs(5, sqlmock.AnyArg(), c.ID()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	cc := new(CredentialClient)
+	cc.UpdateSignCount(c, 5, db)
+	assert.Nil(t, cc.Err())
+	assert.Equal(t, uint32(5), c.SignCount())
+
+	if err = mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Expectations were not met. ERROR: %v\n", err)
+	}
+}
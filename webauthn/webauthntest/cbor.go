@@ -0,0 +1,54 @@
+package webauthntest
+
+import (
+	"encoding/binary"
+)
+
+// Map is a CBOR map given as alternating keys and values, which are encoded in order.
+type Map []interface{}
+
+// Marshal encodes v as CBOR. Supported types are int, int64, string, []byte, []interface{} and Map.
+// Marshal panics on any other type, as it is only used to build test fixtures.
+func Marshal(v interface{}) []byte {
+	switch v := v.(type) {
+	case int:
+		return Marshal(int64(v))
+	case int64:
+		if v < 0 {
+			return head(1, uint64(-1-v))
+		}
+		return head(0, uint64(v))
+	case []byte:
+		return append(head(2, uint64(len(v))), v...)
+	case string:
+		return append(head(3, uint64(len(v))), v...)
+	case []interface{}:
+		b := head(4, uint64(len(v)))
+		for _, e := range v {
+			b = append(b, Marshal(e)...)
+		}
+		return b
+	case Map:
+		b := head(5, uint64(len(v)/2))
+		for _, e := range v {
+			b = append(b, Marshal(e)...)
+		}
+		return b
+	}
+	panic("webauthntest: unsupported CBOR type")
+}
+
+func head(major byte, arg uint64) []byte {
+	m := major << 5
+	switch {
+	case arg < 24:
+		return []byte{m | byte(arg)}
+	case arg <= 0xff:
+		return []byte{m | 24, byte(arg)}
+	case arg <= 0xffff:
+		return binary.BigEndian.AppendUint16([]byte{m | 25}, uint16(arg))
+	case arg <= 0xffffffff:
+		return binary.BigEndian.AppendUint32([]byte{m | 26}, uint32(arg))
+	}
+	return binary.BigEndian.AppendUint64([]byte{m | 27}, arg)
+}
@@ -0,0 +1,193 @@
+// Package webauthntest provides a software WebAuthn authenticator, so that registration and
+// login ceremonies can be exercised in tests without a browser or hardware security key.
+package webauthntest
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"math/big"
+	"time"
+)
+
+// Attestation statement formats the Authenticator can produce.
+const (
+	FormatNone       = "none"
+	FormatPacked     = "packed"
+	FormatPackedX509 = "packed-x5c"
+)
+
+// Authenticator is a software authenticator holding a single ES256 credential.
+type Authenticator struct {
+	RPID         string
+	Origin       string
+	Format       string
+	AAGUID       []byte
+	CredentialID []byte
+	SignCount    uint32
+	Flags        byte
+
+	// FixedCounter disables the signature counter, as on authenticators that always report zero.
+	FixedCounter bool
+
+	key     *ecdsa.PrivateKey
+	attKey  *ecdsa.PrivateKey
+	attCert []byte
+}
+
+// New returns an Authenticator with a new credential for the relying party rpID.
+// The signature counter starts at zero and increases by one with every assertion.
+func New(rpID, origin, format string) (*Authenticator, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	a := &Authenticator{
+		RPID:         rpID,
+		Origin:       origin,
+		Format:       format,
+		AAGUID:       make([]byte, 16),
+		CredentialID: make([]byte, 32),
+		Flags:        0x01 | 0x04,
+		key:          key,
+	}
+	if _, err := rand.Read(a.AAGUID); err != nil {
+		return nil, err
+	}
+	if _, err := rand.Read(a.CredentialID); err != nil {
+		return nil, err
+	}
+	if format == FormatPackedX509 {
+		if a.attKey, a.attCert, err = NewAttestationCertificate(a.AAGUID); err != nil {
+			return nil, err
+		}
+	}
+	return a, nil
+}
+
+// PublicKey returns the COSE_Key of the credential.
+func (a *Authenticator) PublicKey() []byte {
+	x := make([]byte, 32)
+	y := make([]byte, 32)
+	a.key.X.FillBytes(x)
+	a.key.Y.FillBytes(y)
+	return Marshal(Map{1, 2, 3, -7, -1, 1, -2, x, -3, y})
+}
+
+// ClientData returns clientDataJSON for a ceremony of type typ with challenge.
+func (a *Authenticator) ClientData(typ string, challenge []byte) []byte {
+	c, _ := json.Marshal(map[string]interface{}{
+		"type":        typ,
+		"challenge":   base64.RawURLEncoding.EncodeToString(challenge),
+		"origin":      a.Origin,
+		"crossOrigin": false,
+	})
+	return c
+}
+
+func (a *Authenticator) authData(attested bool) []byte {
+	h := sha256.Sum256([]byte(a.RPID))
+	d := append([]byte(nil), h[:]...)
+
+	flags := a.Flags
+	if attested {
+		flags |= 0x40
+	}
+	d = append(d, flags)
+	d = binary.BigEndian.AppendUint32(d, a.SignCount)
+
+	if attested {
+		d = append(d, a.AAGUID...)
+		d = binary.BigEndian.AppendUint16(d, uint16(len(a.CredentialID)))
+		d = append(d, a.CredentialID...)
+		d = append(d, a.PublicKey()...)
+	}
+	return d
+}
+
+func sign(key *ecdsa.PrivateKey, authData, clientDataJSON []byte) ([]byte, error) {
+	ch := sha256.Sum256(clientDataJSON)
+	h := sha256.Sum256(append(append([]byte(nil), authData...), ch[:]...))
+	return ecdsa.SignASN1(rand.Reader, key, h[:])
+}
+
+// Create performs a registration ceremony and returns clientDataJSON and the attestation object.
+func (a *Authenticator) Create(challenge []byte) (clientDataJSON, attestationObject []byte, err error) {
+	clientDataJSON = a.ClientData("webauthn.create", challenge)
+	authData := a.authData(true)
+
+	var (
+		format = a.Format
+		stmt   = Map{}
+	)
+	switch a.Format {
+	case FormatPacked:
+		sig, err := sign(a.key, authData, clientDataJSON)
+		if err != nil {
+			return nil, nil, err
+		}
+		stmt = Map{"alg", -7, "sig", sig}
+	case FormatPackedX509:
+		sig, err := sign(a.attKey, authData, clientDataJSON)
+		if err != nil {
+			return nil, nil, err
+		}
+		format = FormatPacked
+		stmt = Map{"alg", -7, "sig", sig, "x5c", []interface{}{a.attCert}}
+	}
+
+	attestationObject = Marshal(Map{"fmt", format, "attStmt", stmt, "authData", authData})
+	return clientDataJSON, attestationObject, nil
+}
+
+// Get performs an assertion ceremony and returns clientDataJSON, authenticator data and signature.
+func (a *Authenticator) Get(challenge []byte) (clientDataJSON, authenticatorData, signature []byte, err error) {
+	if !a.FixedCounter {
+		a.SignCount++
+	}
+	clientDataJSON = a.ClientData("webauthn.get", challenge)
+	authenticatorData = a.authData(false)
+	signature, err = sign(a.key, authenticatorData, clientDataJSON)
+	return
+}
+
+// NewAttestationCertificate returns a key and a self-signed certificate meeting the packed
+// attestation certificate requirements for an authenticator model identified by aaguid.
+func NewAttestationCertificate(aaguid []byte) (*ecdsa.PrivateKey, []byte, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	ext, err := asn1.Marshal(aaguid)
+	if err != nil {
+		return nil, nil, err
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject: pkix.Name{
+			Country:            []string{"US"},
+			Organization:       []string{"Auth-Service Test"},
+			OrganizationalUnit: []string{"Authenticator Attestation"},
+			CommonName:         "Software Authenticator",
+		},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		BasicConstraintsValid: true,
+		IsCA:                  false,
+		ExtraExtensions: []pkix.Extension{
+			{Id: asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 45724, 1, 1, 4}, Value: ext},
+		},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		return nil, nil, err
+	}
+	return key, der, nil
+}
@@ -0,0 +1,258 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	sq "github.com/Masterminds/squirrel"
+	"github.com/penutty/authservice/webauthn"
+	"github.com/penutty/authservice/webauthn/webauthntest"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+var (
+	tRPID   = "auth.example.com"
+	tOrigin = "https://auth.example.com"
+)
+
+func init() {
+	webauthn.RPID = tRPID
+	webauthn.Origin = tOrigin
+}
+
+type MockWebAuthnClient struct {
+	err         error
+	challenges  map[string]string
+	credentials map[string]*webauthn.Credential
+}
+
+func NewMockWebAuthnClient() *MockWebAuthnClient {
+	return &MockWebAuthnClient{challenges: make(map[string]string), credentials: make(map[string]*webauthn.Credential)}
+}
+
+func (m *MockWebAuthnClient) Challenge(u, ceremony string, db sq.BaseRunner) []byte {
+	c, _ := webauthn.NewChallenge()
+	m.challenges[ceremony+string(c)] = u
+	return c
+}
+
+func (m *MockWebAuthnClient) ConsumeChallenge(c []byte, ceremony string, db sq.BaseRunner) string {
+	u, ok := m.challenges[ceremony+string(c)]
+	if !ok {
+		m.err = webauthn.ErrorChallengeInvalid
+	}
+	delete(m.challenges, ceremony+string(c))
+	return u
+}
+
+func (m *MockWebAuthnClient) Register(c *webauthn.Credential, db sq.BaseRunner) {
+	m.credentials[string(c.ID())] = c
+}
+
+func (m *MockWebAuthnClient) Fetch(id []byte, db sq.BaseRunner) *webauthn.Credential {
+	c, ok := m.credentials[string(id)]
+	if !ok {
+		m.err = webauthn.ErrorChallengeInvalid
+	}
+	return c
+}
+
+func (m *MockWebAuthnClient) List(u string, db sq.BaseRunner) (cs []*webauthn.Credential) {
+	for _, c := range m.credentials {
+		if c.UserID() == u {
+			cs = append(cs, c)
+		}
+	}
+	return
+}
+
+func (m *MockWebAuthnClient) UpdateSignCount(c *webauthn.Credential, count uint32, db sq.BaseRunner) {
+	m.credentials[string(c.ID())] = webauthn.NewCredential(c.ID(), c.UserID(), c.PublicKey(), count)
+}
+
+func (m *MockWebAuthnClient) Err() error {
+	return m.err
+}
+
+func NewAttestationBody(a *webauthntest.Authenticator, challenge []byte) *bytes.Reader {
+	clientData, att, err := a.Create(challenge)
+	if err != nil {
+		panic(err)
+	}
+	b := new(publicKeyCredential)
+	b.RawID = a.CredentialID
+	b.Type = "public-key"
+	b.Response.ClientDataJSON = clientData
+	b.Response.AttestationObject = att
+	p, _ := json.Marshal(b)
+	return bytes.NewReader(p)
+}
+
+func NewAssertionBody(a *webauthntest.Authenticator, challenge []byte) *bytes.Reader {
+	clientData, authData, sig, err := a.Get(challenge)
+	if err != nil {
+		panic(err)
+	}
+	b := new(publicKeyCredential)
+	b.RawID = a.CredentialID
+	b.Type = "public-key"
+	b.Response.ClientDataJSON = clientData
+	b.Response.AuthenticatorData = authData
+	b.Response.Signature = sig
+	p, _ := json.Marshal(b)
+	return bytes.NewReader(p)
+}
+
+func beginRegistration(t *testing.T, a *app) []byte {
+	rec := httptest.NewRecorder()
+	a.webauthnRegisterHandler(rec, NewBearerRequest(http.MethodPost, WebAuthnRegisterEndpoint, nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	o := new(creationOptions)
+	if err := json.NewDecoder(rec.Body).Decode(o); err != nil {
+		t.Fatal(err)
+	}
+	return o.PublicKey.Challenge
+}
+
+func beginLogin(t *testing.T, a *app, body string) *requestOptions {
+	rec := httptest.NewRecorder()
+	a.webauthnLoginHandler(rec, httptest.NewRequest(http.MethodPost, WebAuthnLoginEndpoint, strings.NewReader(body)))
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	o := new(requestOptions)
+	if err := json.NewDecoder(rec.Body).Decode(o); err != nil {
+		t.Fatal(err)
+	}
+	return o
+}
+
+func Test_webauthn(t *testing.T) {
+	formats := []string{webauthntest.FormatNone, webauthntest.FormatPacked, webauthntest.FormatPackedX509}
+
+	for _, f := range formats {
+		t.Run(f, func(t *testing.T) {
+			a := new(app)
+			a.rb = new(MockRBACClient)
+			a.g = new(MockGroupClient)
+			a.ss = NewMockSessionClient()
+			a.c = new(MockUserClient)
+			a.w = NewMockWebAuthnClient()
+
+			auth, err := webauthntest.New(tRPID, tOrigin, f)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			challenge := beginRegistration(t, a)
+			rec := httptest.NewRecorder()
+			a.webauthnRegisterFinishHandler(rec, NewBearerRequest(http.MethodPost, WebAuthnRegisterFinishEndpoint, NewAttestationBody(auth, challenge)))
+			assert.Equal(t, http.StatusCreated, rec.Code)
+
+			o := beginLogin(t, a, `{"UserID": "`+tUser+`"}`)
+			assert.Len(t, o.PublicKey.AllowCredentials, 1)
+			assert.Equal(t, tRPID, o.PublicKey.RPID)
+
+			rec = httptest.NewRecorder()
+			a.webauthnLoginFinishHandler(rec, httptest.NewRequest(http.MethodPost, WebAuthnLoginFinishEndpoint, NewAssertionBody(auth, o.PublicKey.Challenge)))
+			assert.Equal(t, http.StatusOK, rec.Code)
+			assert.NotEmpty(t, rec.Header().Get("jwt"))
+		})
+	}
+}
+
+func Test_webauthnRegisterFinishHandler(t *testing.T) {
+	a := new(app)
+	a.rb = new(MockRBACClient)
+	a.g = new(MockGroupClient)
+	a.ss = NewMockSessionClient()
+	a.c = new(MockUserClient)
+	a.w = NewMockWebAuthnClient()
+	auth, err := webauthntest.New(tRPID, tOrigin, webauthntest.FormatNone)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("unissued", func(t *testing.T) {
+		challenge, _ := webauthn.NewChallenge()
+		rec := httptest.NewRecorder()
+		a.webauthnRegisterFinishHandler(rec, NewBearerRequest(http.MethodPost, WebAuthnRegisterFinishEndpoint, NewAttestationBody(auth, challenge)))
+		assert.Equal(t, http.StatusUnauthorized, rec.Code)
+	})
+
+	t.Run("unauthenticated", func(t *testing.T) {
+		a.w = NewMockWebAuthnClient()
+		challenge := beginRegistration(t, a)
+		rec := httptest.NewRecorder()
+		a.webauthnRegisterFinishHandler(rec, httptest.NewRequest(http.MethodPost, WebAuthnRegisterFinishEndpoint, NewAttestationBody(auth, challenge)))
+		assert.Equal(t, http.StatusUnauthorized, rec.Code)
+	})
+
+	t.Run("login challenge", func(t *testing.T) {
+		a.w = NewMockWebAuthnClient()
+		o := beginLogin(t, a, "")
+		rec := httptest.NewRecorder()
+		a.webauthnRegisterFinishHandler(rec, NewBearerRequest(http.MethodPost, WebAuthnRegisterFinishEndpoint, NewAttestationBody(auth, o.PublicKey.Challenge)))
+		assert.Equal(t, http.StatusUnauthorized, rec.Code)
+	})
+}
+
+func Test_webauthnLoginFinishHandler(t *testing.T) {
+	a := new(app)
+	a.rb = new(MockRBACClient)
+	a.g = new(MockGroupClient)
+	a.ss = NewMockSessionClient()
+	a.c = new(MockUserClient)
+	m := NewMockWebAuthnClient()
+	a.w = m
+	auth, err := webauthntest.New(tRPID, tOrigin, webauthntest.FormatNone)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec := httptest.NewRecorder()
+	a.webauthnRegisterFinishHandler(rec, NewBearerRequest(http.MethodPost, WebAuthnRegisterFinishEndpoint, NewAttestationBody(auth, beginRegistration(t, a))))
+	assert.Equal(t, http.StatusCreated, rec.Code)
+
+	t.Run("discoverable", func(t *testing.T) {
+		o := beginLogin(t, a, "")
+		assert.Empty(t, o.PublicKey.AllowCredentials)
+
+		rec := httptest.NewRecorder()
+		a.webauthnLoginFinishHandler(rec, httptest.NewRequest(http.MethodPost, WebAuthnLoginFinishEndpoint, NewAssertionBody(auth, o.PublicKey.Challenge)))
+		assert.Equal(t, http.StatusOK, rec.Code)
+	})
+
+	t.Run("replay", func(t *testing.T) {
+		o := beginLogin(t, a, "")
+		body := NewAssertionBody(auth, o.PublicKey.Challenge)
+		p := make([]byte, body.Len())
+		body.Read(p)
+
+		rec := httptest.NewRecorder()
+		a.webauthnLoginFinishHandler(rec, httptest.NewRequest(http.MethodPost, WebAuthnLoginFinishEndpoint, bytes.NewReader(p)))
+		assert.Equal(t, http.StatusOK, rec.Code)
+
+		rec = httptest.NewRecorder()
+		a.webauthnLoginFinishHandler(rec, httptest.NewRequest(http.MethodPost, WebAuthnLoginFinishEndpoint, bytes.NewReader(p)))
+		assert.Equal(t, http.StatusUnauthorized, rec.Code)
+		m.err = nil
+	})
+
+	t.Run("other user", func(t *testing.T) {
+		o := beginLogin(t, a, `{"UserID": "otheruser"}`)
+		rec := httptest.NewRecorder()
+		a.webauthnLoginFinishHandler(rec, httptest.NewRequest(http.MethodPost, WebAuthnLoginFinishEndpoint, NewAssertionBody(auth, o.PublicKey.Challenge)))
+		assert.Equal(t, http.StatusUnauthorized, rec.Code)
+	})
+
+	t.Run("cloned", func(t *testing.T) {
+		o := beginLogin(t, a, "")
+		auth.SignCount = 0
+		rec := httptest.NewRecorder()
+		a.webauthnLoginFinishHandler(rec, httptest.NewRequest(http.MethodPost, WebAuthnLoginFinishEndpoint, NewAssertionBody(auth, o.PublicKey.Challenge)))
+		assert.Equal(t, http.StatusUnauthorized, rec.Code)
+	})
+}